@@ -0,0 +1,98 @@
+// Command fakeclaude is a stand-in for the claude CLI used by the end-to-end
+// tests. It accepts the same flags as claude, ignores them, and makes
+// deterministic changes so the whole outie -> docker -> innie -> push pipeline
+// can run without Anthropic credentials.
+//
+// Interactive runs append the prompt to FAKECLAUDE.md and commit it. --print
+// runs commit any outstanding changes when asked to commit, and otherwise
+// print a one-line response naming the prompt.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// NotesFile is the file fakeclaude writes prompts to
+const NotesFile = "FAKECLAUDE.md"
+
+func main() {
+	printMode := false
+	prompt := ""
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--print" || arg == "-p":
+			printMode = true
+		case strings.HasPrefix(arg, "-"):
+			// Ignore every other claude flag
+		default:
+			prompt = arg
+		}
+	}
+
+	// claude --print reads the prompt from stdin when none is given
+	if prompt == "" && printMode {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fail("failed to read prompt: %v", err)
+		}
+		prompt = string(data)
+	}
+	if prompt == "" {
+		fail("no prompt given")
+	}
+
+	if printMode {
+		if strings.Contains(strings.ToLower(firstLine(prompt)), "commit") {
+			commitAll("fakeclaude: commit changes")
+			return
+		}
+		fmt.Printf("fakeclaude handled: %s\n", firstLine(prompt))
+		return
+	}
+
+	file, err := os.OpenFile(NotesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fail("failed to open %s: %v", NotesFile, err)
+	}
+	if _, err := fmt.Fprintln(file, prompt); err != nil {
+		fail("failed to write %s: %v", NotesFile, err)
+	}
+	if err := file.Close(); err != nil {
+		fail("failed to close %s: %v", NotesFile, err)
+	}
+	commitAll("fakeclaude: " + firstLine(prompt))
+}
+
+// commitAll stages everything in the working tree and commits it, doing
+// nothing if there is nothing to commit
+func commitAll(message string) {
+	run("git", "add", "-A")
+	if err := exec.Command("git", "diff", "--cached", "--quiet").Run(); err == nil {
+		return
+	}
+	run("git", "commit", "-m", message)
+}
+
+// run runs a command, exiting if it fails
+func run(name string, args ...string) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fail("%s %s failed: %v", name, strings.Join(args, " "), err)
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "fakeclaude: "+format+"\n", args...)
+	os.Exit(1)
+}
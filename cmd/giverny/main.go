@@ -1,17 +1,30 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"regexp"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"giverny"
+	"giverny/internal/cmdutil"
 	"giverny/internal/ctrlsock"
+	"giverny/internal/describe"
 	"giverny/internal/docker"
+	"giverny/internal/ghtoken"
+	"giverny/internal/git"
+	"giverny/internal/hooks"
 	"giverny/internal/innie"
+	"giverny/internal/orphans"
 	"giverny/internal/outie"
+	"giverny/internal/reproduce"
+	"giverny/internal/taskid"
+	"giverny/internal/taskspec"
+	"giverny/internal/watch"
 )
 
 // Version information - injected at build time via -ldflags
@@ -30,24 +43,117 @@ func init() {
 type Config struct {
 	TaskID          string
 	Slug            string
+	BranchUser      string
 	Prompt          string
 	BaseImage       string
 	DockerArgs      string
 	AgentArgs       string
 	IsInnie         bool
-	GitServerPort   int
 	Debug           bool
 	ShowBuildOutput bool
 	ExistingBranch  bool
+	BaseBranch      string
+	TargetBranch    string
+	FromRef         string
+	CloneDepth      int
+	CloneFilter     string
+	OptimizeClone   bool
+	SparsePaths     []string
+	ReuseContainer  bool
+	PushRefspecs    []string
 	AllowDirty      bool
+	Autostash       bool
+	Force           bool
 	UseAmp          bool
+	Squash          bool
+	PushToRemote    string
+	Provenance      bool
+	AuditLog        string
+	ScanPaths       []string
+	DenyPaths       []string
+	StrictPolicy    bool
+	MaxFiles        int
+	MaxLines        int
+	MaxBinaryKB     int
+	NoSecretScan    bool
+	Summary         bool
+	PlanFirst       bool
+	Split           bool
+	TDD             bool
+	TestCmd         string
+	CoverageCmd     string
+	MaxCoverageDrop float64
+	Checks          []string
+	ReviewRounds    int
+	ReviewerArgs    string
+	ComplianceCmd   string
+	RequireSPDX     bool
+	SaveWIP         bool
 	ForceRebuild    bool
 	CtrlSend        string
+	CredentialOp    string
+	SyncWorkspace   string
+	WorkspaceDir    string
+	CloneDir        string
+
+	// CheckpointInterval is how often innie checkpoints the workspace (0 to never)
+	CheckpointInterval time.Duration
+	LiveDiff           bool
+	Detach             bool
+	Samples            int
+	Sample             int
+	Detached           bool
+	Attachable         bool
+	Timestamps         bool
+	OutputLog          string
+	AutoCRLF           string
+	FileMode           string
+	Hooks              string
+	Verify             []string
+	BenchCmd           string
+	BenchThreshold     float64
+	ScanImage          bool
+	FailOnCritical     bool
+	SBOM               string
+	ImageRegistry      string
+	Devcontainer       bool
+	Nix                bool
+	Mise               bool
+	Proxy              string
+	CACert             string
+	BuildNetrc         string
+	BuildNpmrc         string
+	GoPrivate          string
+	Diffreviewer       docker.ToolVersion
+	Beads              docker.ToolVersion
+	WithTools          []string
+	Packages           []string
+	RunSteps           []string
+	EnableDocker       string
+	UnsafeDockerArgs   bool
+	OrphanAge          time.Duration
+	Dotfiles           string
+	NoHostMenu         bool
+	GitCredentials     []string
+	GitHubRepos        []string
+	Network            string
+	GitBind            string
+	GitHost            string
 }
 
 var (
-	config      Config
-	showVersion bool
+	config        Config
+	showVersion   bool
+	syncRebase    bool
+	slugifyTaskID bool
+	userBranch    bool
+
+	describeOutput   string
+	describeUseAgent bool
+
+	watchTail int
+
+	jsonOutput bool
 )
 
 // getVersion returns the formatted version string
@@ -103,20 +209,96 @@ func main() {
 				return ctrlsock.Send(addr, config.CtrlSend)
 			}
 
+			// Handle --git-credential-helper: git in the container asks for
+			// credentials given with --git-credential
+			if config.CredentialOp != "" {
+				return innie.CredentialHelper(config.CredentialOp, os.Stdin, os.Stdout)
+			}
+
+			// Run the task outie describes in the container's environment
+			if config.IsInnie {
+				spec, err := taskspec.FromEnv()
+				if err != nil {
+					return err
+				}
+				innieConfig, err := innie.ConfigFromSpec(*spec)
+				if err != nil {
+					return err
+				}
+				innieConfig.WorkspaceDir = config.WorkspaceDir
+				innieConfig.CloneDir = config.CloneDir
+				innieConfig.Version = getVersion()
+				return innie.Run(innieConfig)
+			}
+
 			// Require TASK-ID if not showing version
 			if len(args) < 1 {
 				return fmt.Errorf("TASK-ID is required")
 			}
-			config.TaskID = args[0]
-
-			// Validate TASK-ID
-			if err := validateTaskID(config.TaskID); err != nil {
-				return fmt.Errorf("invalid TASK-ID: %w", err)
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			config.TaskID = taskID
+			// Each sample of a task run with --samples is a task of its own
+			if config.Sample > 0 {
+				config.TaskID = outie.SampleTaskID(config.TaskID, config.Sample)
 			}
 
 			// Sanitize slug if provided
 			if config.Slug != "" {
-				config.Slug = sanitizeSlug(config.Slug)
+				config.Slug = taskid.SanitizeSlug(config.Slug)
+			}
+
+			// Innie is given the user outie found
+			if userBranch && config.BranchUser == "" {
+				if config.BranchUser, err = taskid.User(); err != nil {
+					return err
+				}
+			}
+
+			// Handle --sync-workspace: run inside the container by `giverny sync`
+			if config.SyncWorkspace != "" {
+				if config.SyncWorkspace != "merge" && config.SyncWorkspace != "rebase" {
+					return fmt.Errorf("--sync-workspace must be 'merge' or 'rebase'")
+				}
+				return innie.Sync(config.WorkspaceDir, config.BranchUser, config.TaskID, config.Slug, config.SyncWorkspace == "rebase", config.Debug)
+			}
+
+			// Validate the container clone's git settings
+			switch config.AutoCRLF {
+			case "", "true", "input", "false":
+			default:
+				return fmt.Errorf("--autocrlf must be 'true', 'input' or 'false'")
+			}
+			switch config.FileMode {
+			case "", "true", "false":
+			default:
+				return fmt.Errorf("--filemode must be 'true' or 'false'")
+			}
+			if config.Hooks != "" && config.Hooks != hooks.ModeInstall && config.Hooks != hooks.ModeSkip {
+				return fmt.Errorf("--hooks must be '%s' or '%s'", hooks.ModeInstall, hooks.ModeSkip)
+			}
+			if err := config.Diffreviewer.Validate(); err != nil {
+				return fmt.Errorf("invalid --diffreviewer-version or --diffreviewer-sha256: %w", err)
+			}
+			if err := config.Beads.Validate(); err != nil {
+				return fmt.Errorf("invalid --beads-version or --beads-sha256: %w", err)
+			}
+			if err := docker.CheckTools(config.WithTools); err != nil {
+				return fmt.Errorf("invalid --with-tools: %w", err)
+			}
+			if err := (docker.BuildOptions{Packages: config.Packages, RunSteps: config.RunSteps}).ValidateExtras(); err != nil {
+				return fmt.Errorf("invalid --image-package or --image-run: %w", err)
+			}
+			if config.EnableDocker != "" && config.EnableDocker != docker.DockerModeSocket && config.EnableDocker != docker.DockerModeDinD {
+				return fmt.Errorf("--enable-docker must be '%s' or '%s'", docker.DockerModeSocket, docker.DockerModeDinD)
+			}
+			if config.Devcontainer && cmd.Flags().Changed("base-image") {
+				return fmt.Errorf("--devcontainer and --base-image cannot be used together")
+			}
+			if config.SBOM != "" && config.SBOM != docker.SBOMFormatSPDX && config.SBOM != docker.SBOMFormatCycloneDX {
+				return fmt.Errorf("--sbom must be '%s' or '%s'", docker.SBOMFormatSPDX, docker.SBOMFormatCycloneDX)
 			}
 
 			// Set default prompt if not provided
@@ -124,27 +306,113 @@ func main() {
 				config.Prompt = fmt.Sprintf("Please work on %s.", config.TaskID)
 			}
 
-			// Validate innie-specific requirements
-			if config.IsInnie && config.GitServerPort == 0 {
-				return fmt.Errorf("--git-server-port is required when --innie is set")
+			// Fail now rather than when the image build can't find a package
+			if err := docker.VerifyEmbeddedSource(giverny.Source); err != nil {
+				return err
+			}
+			// The log paths are relative to where giverny was run, not the project root
+			if config.AuditLog != "" {
+				absPath, err := filepath.Abs(config.AuditLog)
+				if err != nil {
+					return fmt.Errorf("invalid --audit-log path: %w", err)
+				}
+				config.AuditLog = absPath
+			}
+			if config.OutputLog != "" {
+				absPath, err := filepath.Abs(config.OutputLog)
+				if err != nil {
+					return fmt.Errorf("invalid --output-log path: %w", err)
+				}
+				config.OutputLog = absPath
+			}
+			// Files used by the image builds are relative to where giverny
+			// was run too
+			for _, file := range []struct {
+				flag string
+				path *string
+			}{{"--ca-cert", &config.CACert}, {"--build-netrc", &config.BuildNetrc}, {"--build-npmrc", &config.BuildNpmrc}, {"--dotfiles", &config.Dotfiles}} {
+				if *file.path == "" {
+					continue
+				}
+				absPath, err := filepath.Abs(*file.path)
+				if err == nil {
+					_, err = os.Stat(absPath)
+				}
+				if err != nil {
+					return fmt.Errorf("invalid %s path: %w", file.flag, err)
+				}
+				*file.path = absPath
+			}
+			if config.ReviewRounds < 0 {
+				return fmt.Errorf("--review-rounds must not be negative")
+			}
+			if config.BenchThreshold < 0 {
+				return fmt.Errorf("--bench-threshold must not be negative")
+			}
+			if config.MaxCoverageDrop < 0 {
+				return fmt.Errorf("--max-coverage-drop must not be negative")
+			}
+			if config.MaxCoverageDrop > 0 && config.CoverageCmd == "" {
+				return fmt.Errorf("--max-coverage-drop requires --coverage-cmd")
+			}
+			if config.TDD && config.TestCmd == "" {
+				return fmt.Errorf("--tdd requires --test-cmd")
+			}
+			if config.Attachable && !config.Detach {
+				return fmt.Errorf("--attachable can only be used with --detach")
+			}
+			// These are split as a shell would, so unbalanced quotes are
+			// caught before anything is built
+			for _, flag := range []struct{ name, value string }{{"--docker-args", config.DockerArgs}, {"--agent-args", config.AgentArgs}, {"--reviewer-args", config.ReviewerArgs}} {
+				if _, err := cmdutil.SplitArgs(flag.value); err != nil {
+					return fmt.Errorf("invalid %s: %w", flag.name, err)
+				}
+			}
+
+			// Run the task several times at once and compare the results
+			if config.Samples < 0 {
+				return fmt.Errorf("--samples must be a positive number")
+			}
+			if config.Samples > 1 && config.Sample == 0 {
+				if config.Detach || config.ReuseContainer || config.ExistingBranch || config.Autostash {
+					return fmt.Errorf("--samples cannot be used with --detach, --reuse-container, --existing-branch or --autostash")
+				}
+				return outie.RunSamples(outie.SamplesConfig{
+					TaskID:       config.TaskID,
+					Slug:         config.Slug,
+					BranchUser:   config.BranchUser,
+					TargetBranch: config.TargetBranch,
+					Verify:       config.Verify,
+				}, os.Args[1:], config.Samples)
+			}
+			// The giverny that started a sample verifies it with the others
+			if config.Sample > 0 {
+				config.Verify = nil
 			}
 
-			// Execute appropriate mode
-			if config.IsInnie {
-				innieConfig := innie.Config{
-					TaskID:        config.TaskID,
-					Slug:          config.Slug,
-					Prompt:        config.Prompt,
-					GitServerPort: config.GitServerPort,
-					AgentArgs:     config.AgentArgs,
-					Debug:         config.Debug,
-					UseAmp:        config.UseAmp,
+			// Start the task again in the background and return straight away
+			if config.Detach && !config.Detached {
+				containerName := docker.ContainerName(config.TaskID, config.Slug)
+				logPath, err := outie.DetachedLogPath(containerName)
+				if err != nil {
+					return err
 				}
-				return innie.Run(innieConfig)
+				if err := outie.StartDetached(os.Args[1:], logPath); err != nil {
+					return err
+				}
+				fmt.Printf("Task %s is running in the background\n", config.TaskID)
+				taskArgs := outie.TaskArgs(config.TaskID, config.Slug, config.BranchUser)
+				fmt.Printf("  Follow it: giverny watch %s\n", taskArgs)
+				if config.Attachable {
+					fmt.Printf("  Use it:    giverny attach %s\n", outie.TaskArgs(config.TaskID, config.Slug, ""))
+				}
+				fmt.Printf("  Log:       %s\n", logPath)
+				return nil
 			}
 			outieConfig := outie.Config{
 				TaskID:          config.TaskID,
 				Slug:            config.Slug,
+				BranchUser:      config.BranchUser,
 				Prompt:          config.Prompt,
 				BaseImage:       config.BaseImage,
 				DockerArgs:      config.DockerArgs,
@@ -153,100 +421,501 @@ func main() {
 				ShowBuildOutput: config.ShowBuildOutput,
 				ForceRebuild:    config.ForceRebuild,
 				ExistingBranch:  config.ExistingBranch,
+				BaseBranch:      config.BaseBranch,
+				TargetBranch:    config.TargetBranch,
+				FromRef:         config.FromRef,
+				CloneDepth:      config.CloneDepth,
+				CloneFilter:     config.CloneFilter,
+				OptimizeClone:   config.OptimizeClone,
+				SparsePaths:     config.SparsePaths,
+				ReuseContainer:  config.ReuseContainer,
+				PushRefspecs:    config.PushRefspecs,
 				AllowDirty:      config.AllowDirty,
+				Autostash:       config.Autostash,
+				Force:           config.Force,
 				UseAmp:          config.UseAmp,
+				Squash:          config.Squash,
+				PushToRemote:    config.PushToRemote,
+				Provenance:      config.Provenance,
+				DenyPaths:       config.DenyPaths,
+				StrictPolicy:    config.StrictPolicy,
+				MaxFiles:        config.MaxFiles,
+				MaxLines:        config.MaxLines,
+				MaxBinaryKB:     config.MaxBinaryKB,
+				NoSecretScan:    config.NoSecretScan,
+				Summary:         config.Summary,
+				PlanFirst:       config.PlanFirst,
+				Split:           config.Split,
+				TDD:             config.TDD,
+				TestCmd:         config.TestCmd,
+				CoverageCmd:     config.CoverageCmd,
+				MaxCoverageDrop: config.MaxCoverageDrop,
+				Checks:          config.Checks,
+				ReviewRounds:    config.ReviewRounds,
+				ReviewerArgs:    config.ReviewerArgs,
+				ComplianceCmd:   config.ComplianceCmd,
+				RequireSPDX:     config.RequireSPDX,
+				AuditLog:        config.AuditLog,
+				ScanPaths:       config.ScanPaths,
+				SaveWIP:         config.SaveWIP,
+
+				CheckpointInterval: config.CheckpointInterval,
+				LiveDiff:           config.LiveDiff,
+				Detached:           config.Detached,
+				HostMenu:           !config.NoHostMenu && docker.StdinIsTerminal(),
+				Attachable:         config.Attachable,
+				Output:             docker.OutputOptions{Timestamps: config.Timestamps, LogFile: config.OutputLog},
+				AutoCRLF:           config.AutoCRLF,
+				FileMode:           config.FileMode,
+				Hooks:              config.Hooks,
+				Verify:             config.Verify,
+				BenchCmd:           config.BenchCmd,
+				BenchThreshold:     config.BenchThreshold,
+				ScanImage:          config.ScanImage,
+				FailOnCritical:     config.FailOnCritical,
+				SBOM:               config.SBOM,
+				ImageRegistry:      config.ImageRegistry,
+				Devcontainer:       config.Devcontainer,
+				Nix:                config.Nix,
+				Mise:               config.Mise,
+				Proxy:              config.Proxy,
+				CACert:             config.CACert,
+				BuildNetrc:         config.BuildNetrc,
+				BuildNpmrc:         config.BuildNpmrc,
+				GoPrivate:          config.GoPrivate,
+				Diffreviewer:       config.Diffreviewer,
+				Beads:              config.Beads,
+				NoDiffreviewer:     !slices.Contains(config.WithTools, docker.ToolDiffreviewer),
+				NoBeads:            !slices.Contains(config.WithTools, docker.ToolBeads),
+				Packages:           config.Packages,
+				RunSteps:           config.RunSteps,
+				EnableDocker:       config.EnableDocker,
+				UnsafeDockerArgs:   config.UnsafeDockerArgs,
+				OrphanAge:          config.OrphanAge,
+				GitCredentials:     config.GitCredentials,
+				GitHubRepos:        config.GitHubRepos,
+				Network:            config.Network,
+				GitBind:            config.GitBind,
+				GitHost:            config.GitHost,
+				Dotfiles:           config.Dotfiles,
+				Version:            getVersion(),
 			}
 			return outie.Run(outieConfig)
 		},
 	}
 
+	syncCmd := &cobra.Command{
+		Use:   "sync [OPTIONS] TASK-ID",
+		Short: "Pull new commits from the host into a running task's branch",
+		Long:  "Fetches new commits on the branch the task will be merged into and rebases or merges them into the task branch inside its running container.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			slug := taskid.SanitizeSlug(config.Slug)
+
+			mode := "merge"
+			if syncRebase {
+				mode = "rebase"
+			}
+			command := []string{"giverny", "--sync-workspace=" + mode}
+			if slug != "" {
+				command = append(command, "--slug", slug)
+			}
+			user, err := branchUser()
+			if err != nil {
+				return err
+			}
+			if user != "" {
+				command = append(command, "--branch-user", user)
+			}
+			if config.Debug {
+				command = append(command, "--debug")
+			}
+			command = append(command, taskID)
+
+			exitCode, err := docker.ExecInContainer(docker.ContainerName(taskID, slug), command...)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return fmt.Errorf("sync exited with code %d", exitCode)
+			}
+			return nil
+		},
+	}
+	syncCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	syncCmd.Flags().BoolVar(&userBranch, "user-branch", os.Getenv(taskid.UserBranchEnv) == "1", "The task was started with --user-branch")
+	syncCmd.Flags().BoolVar(&syncRebase, "rebase", false, "Rebase the task branch instead of merging")
+	syncCmd.Flags().BoolVar(&config.Debug, "debug", false, "Enable debug output")
+	rootCmd.AddCommand(syncCmd)
+
+	describeCmd := &cobra.Command{
+		Use:   "describe [OPTIONS] TASK-ID",
+		Short: "Write a pull request description for a task branch",
+		Long:  "Builds a Markdown pull request description and changelog entry from the commits and diff on a task branch, optionally having the agent write it.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			user, err := branchUser()
+			if err != nil {
+				return err
+			}
+			branchName := taskid.BranchName(user, taskID, taskid.SanitizeSlug(config.Slug))
+			baseBranch := config.TargetBranch
+			if baseBranch == "" {
+				baseBranch = git.DefaultBranch()
+			}
+
+			d, err := describe.Load(taskID, branchName, baseBranch)
+			if err != nil {
+				return err
+			}
+			text := d.Markdown()
+			if describeUseAgent {
+				if text, err = d.WithAgent(config.UseAmp); err != nil {
+					return err
+				}
+			}
+
+			if describeOutput == "" {
+				fmt.Print(text)
+				return nil
+			}
+			if err := os.WriteFile(describeOutput, []byte(text), 0644); err != nil {
+				return fmt.Errorf("failed to write description: %w", err)
+			}
+			fmt.Printf("Description written to %s\n", describeOutput)
+			return nil
+		},
+	}
+	describeCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	describeCmd.Flags().BoolVar(&userBranch, "user-branch", os.Getenv(taskid.UserBranchEnv) == "1", "The task was started with --user-branch")
+	describeCmd.Flags().StringVar(&config.TargetBranch, "base", "", "Branch the changes will be merged into (default: the repository's default branch)")
+	describeCmd.Flags().StringVarP(&describeOutput, "output", "o", "", "Write the description to this file instead of stdout")
+	describeCmd.Flags().BoolVar(&describeUseAgent, "agent", false, "Have the agent write the description in print mode on the host")
+	describeCmd.Flags().BoolVarP(&config.UseAmp, "amp", "a", false, "Use Amp instead of Claude Code with --agent")
+	rootCmd.AddCommand(describeCmd)
+
+	attachCmd := &cobra.Command{
+		Use:   "attach [OPTIONS] TASK-ID",
+		Short: "Connect to the agent and menu of a running task",
+		Long:  "Connects the terminal to a running task's container, so you can use the agent and the post-agent menu as if you had started it in the foreground. Use it for tasks started with --detach --attachable, or to get back to a task after its terminal went away.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			if !docker.StdinIsTerminal() {
+				return fmt.Errorf("giverny attach must be run in a terminal")
+			}
+			containerName := docker.ContainerName(taskID, taskid.SanitizeSlug(config.Slug))
+			info, err := docker.InspectContainer(containerName)
+			if err != nil {
+				return err
+			}
+			if info == nil || !info.Running {
+				return fmt.Errorf("task %s is not running (looked for container '%s')", taskID, containerName)
+			}
+
+			if err := docker.AttachContainer(containerName); err != nil {
+				return err
+			}
+			fmt.Printf("\nDetached from %s\n", containerName)
+			return nil
+		},
+	}
+	attachCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	rootCmd.AddCommand(attachCmd)
+
+	menuCmd := &cobra.Command{
+		Use:   "menu",
+		Short: "Show the post-agent menu of the task, from a shell in its container",
+		Long:  "Shows the post-agent menu of the task running in this container, for when you have entered it with docker exec rather than through the task's own session. It runs the agent with the task's settings. The task branch is pushed to the host when the task's own session exits its menu.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return innie.Menu()
+		},
+	}
+	rootCmd.AddCommand(menuCmd)
+
+	watchCmd := &cobra.Command{
+		Use:   "watch [OPTIONS] TASK-ID",
+		Short: "Show the progress and output of a running task",
+		Long:  "Shows what a task's container is doing and the changes in its latest checkpoint, then follows the container's output. Works from any terminal, including for tasks started elsewhere.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			slug := taskid.SanitizeSlug(config.Slug)
+			user, err := branchUser()
+			if err != nil {
+				return err
+			}
+			branchName := taskid.BranchName(user, taskID, slug)
+			baseBranch := config.TargetBranch
+			if baseBranch == "" {
+				baseBranch = git.DefaultBranch()
+			}
+			return watch.Run(taskID, docker.ContainerName(taskID, slug), branchName, baseBranch, watchTail)
+		},
+	}
+	watchCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	watchCmd.Flags().BoolVar(&userBranch, "user-branch", os.Getenv(taskid.UserBranchEnv) == "1", "The task was started with --user-branch")
+	watchCmd.Flags().StringVar(&config.TargetBranch, "base", "", "Branch the changes will be merged into (default: the repository's default branch)")
+	watchCmd.Flags().IntVar(&watchTail, "tail", 20, "Number of lines of earlier output to show")
+	rootCmd.AddCommand(watchCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list [--json]",
+		Short: "List the tasks recorded in this repository",
+		Long:  "Lists the tasks with recorded state in this repository, most recently started first, with whether their containers are running, stopped or removed. With --json, prints them as a JSON array for scripts and editor extensions.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := git.Open(".")
+			if err != nil {
+				return err
+			}
+			gitDir, err := repo.GitDir()
+			if err != nil {
+				return err
+			}
+			tasks, err := watch.List(gitDir)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(tasks)
+			}
+			fmt.Print(watch.ListText(tasks))
+			return nil
+		},
+	}
+	listCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the tasks as JSON")
+	rootCmd.AddCommand(listCmd)
+
+	statusCmd := &cobra.Command{
+		Use:   "status [OPTIONS] TASK-ID",
+		Short: "Show the progress of a task",
+		Long:  "Shows what a task's container is doing and the changes in its latest checkpoint, like watch without following the output. With --json, prints them as a JSON object for scripts and editor extensions.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			slug := taskid.SanitizeSlug(config.Slug)
+			user, err := branchUser()
+			if err != nil {
+				return err
+			}
+			branchName := taskid.BranchName(user, taskID, slug)
+			baseBranch := config.TargetBranch
+			if baseBranch == "" {
+				baseBranch = git.DefaultBranch()
+			}
+			r, err := watch.Load(taskID, docker.ContainerName(taskID, slug), branchName, baseBranch)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(r)
+			}
+			fmt.Print(r.Text(time.Now()))
+			return nil
+		},
+	}
+	statusCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	statusCmd.Flags().BoolVar(&userBranch, "user-branch", os.Getenv(taskid.UserBranchEnv) == "1", "The task was started with --user-branch")
+	statusCmd.Flags().StringVar(&config.TargetBranch, "base", "", "Branch the changes will be merged into (default: the repository's default branch)")
+	statusCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the status as JSON")
+	rootCmd.AddCommand(statusCmd)
+
+	reproduceCmd := &cobra.Command{
+		Use:   "reproduce [OPTIONS] TASK-ID",
+		Short: "Open a shell in the environment a past task started in",
+		Long:  "Recreates the environment recorded when a task started: its giverny-main image, rebuilt from the base image's recorded digest if it is gone, and its branch at the START label in a throwaway clone. Then opens a shell in a new container with the clone as the workspace.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskID, err := taskIDArg(args[0])
+			if err != nil {
+				return err
+			}
+			if !docker.StdinIsTerminal() {
+				return fmt.Errorf("giverny reproduce must be run in a terminal")
+			}
+			if err := docker.VerifyEmbeddedSource(giverny.Source); err != nil {
+				return err
+			}
+			return reproduce.Run(docker.ContainerName(taskID, taskid.SanitizeSlug(config.Slug)), config.ShowBuildOutput, config.Debug)
+		},
+	}
+	reproduceCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Slug the task was started with")
+	reproduceCmd.Flags().BoolVar(&config.ShowBuildOutput, "show-build-output", false, "Show docker build output if the image is rebuilt")
+	reproduceCmd.Flags().BoolVar(&config.Debug, "debug", false, "Enable debug output")
+	rootCmd.AddCommand(reproduceCmd)
+
 	// Define flags
 	rootCmd.Flags().BoolVar(&showVersion, "version", false, "Show version information")
+	rootCmd.PersistentFlags().BoolVar(&slugifyTaskID, "slugify", false, "Turn spaces and characters git doesn't allow in TASK-ID into hyphens instead of failing")
 	rootCmd.Flags().StringVarP(&config.Slug, "slug", "s", "", "Short description for branch name (e.g., 'fix-login-bug')")
+	rootCmd.Flags().BoolVar(&userBranch, "user-branch", os.Getenv(taskid.UserBranchEnv) == "1", "Name the branch giverny/USER/TASK-ID, for repositories shared with others")
 	rootCmd.Flags().StringVarP(&config.Prompt, "prompt", "p", "", "Prompt to pass to the agent")
 	rootCmd.Flags().StringVar(&config.BaseImage, "base-image", "giverny:latest", "Docker base image")
-	rootCmd.Flags().StringVar(&config.DockerArgs, "docker-args", "", "Additional docker run arguments")
-	rootCmd.Flags().StringVar(&config.AgentArgs, "agent-args", "", "Additional arguments to pass to the agent (claude code)")
+	rootCmd.Flags().StringVar(&config.DockerArgs, "docker-args", "", "Additional docker run arguments, split and quoted as by a shell (e.g. '-e FOO=\"a b\"')")
+	rootCmd.Flags().StringVar(&config.AgentArgs, "agent-args", "", "Additional arguments to pass to the agent (claude code), split and quoted as by a shell")
 	rootCmd.Flags().BoolVar(&config.Debug, "debug", false, "Enable debug output")
 	rootCmd.Flags().BoolVar(&config.ShowBuildOutput, "show-build-output", false, "Show docker build output")
 	rootCmd.Flags().BoolVar(&config.ForceRebuild, "force-rebuild", false, "Force rebuild of Docker image even if recent")
+	rootCmd.Flags().BoolVar(&config.ScanImage, "scan-image", false, "Scan the giverny-main image for vulnerabilities (trivy, grype or docker scout) before the task starts")
+	rootCmd.Flags().BoolVar(&config.FailOnCritical, "fail-on-critical", false, "Scan the giverny-main image and don't start the task if it has critical vulnerabilities")
+	rootCmd.Flags().StringVar(&config.SBOM, "sbom", "", "Write an SBOM of the giverny-main image in this format (spdx or cyclonedx) with the task's files")
+	rootCmd.Flags().BoolVar(&config.Devcontainer, "devcontainer", false, "Base the giverny-main image on the project's .devcontainer/devcontainer.json instead of --base-image")
+	rootCmd.Flags().BoolVar(&config.Nix, "nix", false, "Install Nix in the giverny-main image and run the agent in the project's flake dev shell (nix develop)")
+	rootCmd.Flags().BoolVar(&config.Mise, "mise", false, "Install mise in the giverny-main image and the runtimes the project pins in .tool-versions or mise.toml in the container")
+	rootCmd.Flags().StringVar(&config.Proxy, "proxy", "", "HTTP(S) proxy URL for the image builds and the container (sets HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	rootCmd.Flags().StringVar(&config.CACert, "ca-cert", "", "PEM file of extra CA certificates to trust in the image builds and the container")
+	rootCmd.Flags().StringVar(&config.BuildNetrc, "build-netrc", "", "A .netrc with credentials for private Go modules, used by the image builds without being stored in the image")
+	rootCmd.Flags().StringVar(&config.BuildNpmrc, "build-npmrc", "", "An .npmrc with credentials for a private npm registry, used by the image builds without being stored in the image")
+	rootCmd.Flags().StringVar(&config.GoPrivate, "goprivate", "", "GOPRIVATE for the Go builds in the image builds (e.g. 'git.corp.example.com/*')")
+	rootCmd.Flags().StringVar(&config.Diffreviewer.Version, "diffreviewer-version", "", "Release tag of diffreviewer to build into the image (default "+docker.DiffreviewerVersion+")")
+	rootCmd.Flags().StringVar(&config.Diffreviewer.SHA256, "diffreviewer-sha256", "", "SHA-256 checksum the diffreviewer source tarball must have")
+	rootCmd.Flags().StringVar(&config.Beads.Version, "beads-version", "", "Release tag of beads_rust to build into the image (default "+docker.BeadsRustVersion+")")
+	rootCmd.Flags().StringVar(&config.Beads.SHA256, "beads-sha256", "", "SHA-256 checksum the beads_rust source tarball must have")
+	rootCmd.Flags().StringSliceVar(&config.WithTools, "with-tools", docker.DefaultTools(), "Optional tools to build into the image: "+strings.Join(docker.Tools, ", ")+" (the default can be set with $"+docker.WithToolsEnv+")")
+	rootCmd.Flags().BoolVar(&config.UnsafeDockerArgs, "i-know-what-im-doing", false, "Run the task even if --docker-args would defeat the container's sandbox, e.g. with --privileged or by mounting the Docker socket")
+	rootCmd.Flags().DurationVar(&config.OrphanAge, "orphan-age", orphans.DefaultMaxAge, "Offer to remove the containers and git servers of tasks giverny is no longer running once they are this old (0 to never look for them)")
+	rootCmd.Flags().StringVar(&config.EnableDocker, "enable-docker", "", "Give the task Docker: 'socket' mounts the host's Docker socket (full control of the host), 'dind' runs a separate daemon in a privileged container")
+	rootCmd.Flags().StringVar(&config.GitBind, "git-bind", "", "Address the git server on the host listens on (default 127.0.0.1 where the Docker runtime forwards host.docker.internal to it, else the Docker bridge address, otherwise all interfaces)")
+	rootCmd.Flags().StringVar(&config.GitHost, "git-host", "", "Host the container reaches the git server on this machine at, e.g. through a tunnel to a remote Docker host (default host.docker.internal)")
+	rootCmd.Flags().StringVar(&config.Network, "network", "", "Run the task on this Docker network (created if missing), cloning from a git server sidecar on it instead of a git server on the host")
+	rootCmd.Flags().StringSliceVar(&config.GitCredentials, "git-credential", nil, "Let git in the container use your HTTPS git credentials for this host, e.g. 'github.com', for private dependencies; they are fetched from your credential helper on demand and never stored in the container (repeatable)")
+	rootCmd.Flags().StringSliceVar(&config.GitHubRepos, "github-repo", nil, "Give git in the container a short-lived token that can only read this GitHub repository (OWNER/REPO), from the GitHub App in $"+ghtoken.AppIDEnv+" and $"+ghtoken.AppKeyFileEnv+" or the fine-grained token in $"+ghtoken.PATEnv+" (repeatable)")
+	rootCmd.Flags().BoolVar(&config.NoHostMenu, "no-host-menu", false, "Don't offer to merge, verify or delete the task branch, open a pull request or retry once the task is done; only print how to")
+	rootCmd.Flags().StringVar(&config.Dotfiles, "dotfiles", "", "Directory of dotfiles (e.g. .bashrc, .zshrc) to install in the image's home directory, for shells started from the menu")
+	rootCmd.Flags().StringSliceVar(&config.Packages, "image-package", nil, "Extra package to install in the image with its package manager, e.g. 'jq' (can be repeated or comma-separated)")
+	rootCmd.Flags().StringArrayVar(&config.RunSteps, "image-run", nil, "Extra shell command to run at the end of the image build, e.g. 'go install golang.org/x/tools/gopls@latest' (can be repeated)")
+	rootCmd.Flags().StringVar(&config.ImageRegistry, "image-registry", "", "Pull the giverny-main image from this registry (e.g. 'ghcr.io/team') before building, and push it there when it is built")
 	rootCmd.Flags().BoolVar(&config.ExistingBranch, "existing-branch", false, "Use existing branch instead of creating a new one")
+	rootCmd.Flags().StringVar(&config.BaseBranch, "branch", "", "Create the task branch from an existing branch inside the container")
+	rootCmd.Flags().StringVar(&config.FromRef, "from", "", "Create the task branch from this ref (branch, tag or commit) instead of HEAD")
+	rootCmd.Flags().StringVar(&config.TargetBranch, "base", "", "Branch the task's changes will be merged into (default: the repository's default branch)")
+	rootCmd.Flags().IntVar(&config.CloneDepth, "clone-depth", 0, "Limit the history cloned into the container to this many commits")
+	rootCmd.Flags().StringVar(&config.CloneFilter, "clone-filter", "", "Partial clone filter for the container clone (e.g., 'blob:none')")
+	rootCmd.Flags().BoolVar(&config.OptimizeClone, "optimize-clone", false, "If the repository is large, clone it into the container with --clone-filter blob:none")
+	rootCmd.Flags().StringVar(&config.AutoCRLF, "autocrlf", "", "Set core.autocrlf in the container clone (true, input or false)")
+	rootCmd.Flags().StringVar(&config.FileMode, "filemode", "", "Set core.fileMode in the container clone (true or false)")
+	rootCmd.Flags().StringVar(&config.BenchCmd, "bench-cmd", "", "Benchmark command, such as 'go test -run=^$ -bench=. -count=5 ./...', run with sh at the task's start and on the task branch after it is pushed back, to compare their results")
+	rootCmd.Flags().Float64Var(&config.BenchThreshold, "bench-threshold", 5, "Percentage by which a --bench-cmd result may get worse before it is marked as a regression")
+	rootCmd.Flags().StringArrayVar(&config.Verify, "verify", nil, "Command to run with sh in a temporary worktree of the task branch after it is pushed back, before the merge instructions (repeatable)")
+	rootCmd.Flags().StringVar(&config.Hooks, "hooks", "", "Handle the project's git hooks in the container: 'install' its hook framework, or 'skip' hooks and run the pre-commit hook on the host afterwards")
+	rootCmd.Flags().StringSliceVar(&config.SparsePaths, "sparse", nil, "Only check out these directories in the container (repeatable)")
+	rootCmd.Flags().BoolVar(&config.ReuseContainer, "reuse-container", false, "Restart the container kept from a failed run of this task instead of starting a new one")
+	rootCmd.Flags().StringSliceVar(&config.PushRefspecs, "push-ref", nil, "Also push these refspecs back from the container, e.g. 'refs/tags/*' or 'refs/notes/*' (repeatable)")
 	rootCmd.Flags().BoolVar(&config.AllowDirty, "allow-dirty", false, "Allow creating branch even if working directory has uncommitted changes")
+	rootCmd.Flags().BoolVar(&config.Autostash, "autostash", false, "Stash uncommitted changes before the task starts and restore them when it finishes")
+	rootCmd.Flags().BoolVar(&config.Force, "force", false, "Start the task even if HEAD is on a protected branch or a rebase or merge is in progress")
 	rootCmd.Flags().BoolVarP(&config.UseAmp, "amp", "a", false, "Use Amp instead of Claude Code as the agent")
+	rootCmd.Flags().BoolVar(&config.Squash, "squash", false, "Squash the task branch into a single commit after a successful run")
+	rootCmd.Flags().StringVar(&config.PushToRemote, "push-to-remote", "", "Push the task branch to this remote with --set-upstream after a successful run (default 'origin' if given without '=REMOTE')")
+	rootCmd.Flags().Lookup("push-to-remote").NoOptDefVal = "origin"
+	rootCmd.Flags().StringSliceVar(&config.DenyPaths, "deny", nil, "Don't let the agent push changes to paths matching this pattern, e.g. '.github/workflows/**' (repeatable)")
+	rootCmd.Flags().BoolVar(&config.StrictPolicy, "strict-policy", false, "Block the push while --deny is violated instead of allowing an override")
+	rootCmd.Flags().IntVar(&config.MaxFiles, "max-files", 0, "Ask before pushing a task branch that changes more than this many files")
+	rootCmd.Flags().IntVar(&config.MaxLines, "max-lines", 0, "Ask before pushing a task branch that adds and deletes more than this many lines")
+	rootCmd.Flags().IntVar(&config.MaxBinaryKB, "max-binary-kb", 0, "Ask before pushing a task branch with a binary file larger than this many KB")
+	rootCmd.Flags().StringVar(&config.ComplianceCmd, "compliance-cmd", "", "Command run in the container with the changed files as arguments before pushing; a non-zero exit blocks the push")
+	rootCmd.Flags().BoolVar(&config.RequireSPDX, "require-spdx", false, "Check that changed source files have an SPDX-License-Identifier header before pushing")
+	rootCmd.Flags().BoolVar(&config.Summary, "summary", false, "Have the agent summarize its changes before pushing and print the summary at the end of the run")
+	rootCmd.Flags().BoolVar(&config.PlanFirst, "plan-first", false, "Have the agent write a plan for the task to .giverny/PLAN.md, for you to approve or edit, before it starts on it")
+	rootCmd.Flags().BoolVar(&config.TDD, "tdd", false, "Have the agent write failing tests for the task first, for you to approve, then implement it until --test-cmd passes")
+	rootCmd.Flags().StringVar(&config.TestCmd, "test-cmd", "", "Command run in the workspace to test the task branch before pushing, and a --tdd task between its phases")
+	rootCmd.Flags().StringArrayVar(&config.Checks, "check", nil, "Command to run with sh in the workspace after the agent finishes, its result shown in a pass/fail table before the menu (repeatable)")
+	rootCmd.Flags().StringVar(&config.CoverageCmd, "coverage-cmd", "", "Command that prints test coverage as a percentage, run at the task's start and end before pushing to report the change")
+	rootCmd.Flags().Float64Var(&config.MaxCoverageDrop, "max-coverage-drop", 0, "Block the push if --coverage-cmd coverage drops by more than this many percentage points (default: only report it)")
+	rootCmd.Flags().BoolVar(&config.Split, "split", false, "Have the agent split a large task into sub-tasks, each run in a session of its own and committed before the next")
+	rootCmd.Flags().IntVar(&config.ReviewRounds, "review-rounds", 0, "After the agent's first run, have a second agent review its changes and the first address the notes, up to this many times")
+	rootCmd.Flags().StringVar(&config.ReviewerArgs, "reviewer-args", "", "Arguments for the reviewing agent of --review-rounds, e.g. '--model opus' (default: --agent-args)")
+	rootCmd.Flags().BoolVar(&config.NoSecretScan, "no-secret-scan", false, "Don't scan the task branch for credentials before pushing")
+	rootCmd.Flags().StringVar(&config.AuditLog, "audit-log", "", "Log every command the agent runs in the container and copy the log to this path")
+	rootCmd.Flags().StringSliceVar(&config.ScanPaths, "scan-path", nil, "Before pushing, look in this directory of the container (e.g. /tmp or /root) for files the agent created outside the workspace, to copy into the workspace or export to the host (repeatable)")
+	rootCmd.Flags().BoolVar(&config.SaveWIP, "save-wip", false, "Save uncommitted changes to refs/giverny/TASK-ID/wip whenever the container exits, even on a crash")
+	rootCmd.Flags().DurationVar(&config.CheckpointInterval, "checkpoint-interval", 0, "Record the workspace on refs/giverny/TASK-ID/checkpoints this often (e.g. '10m') while the agent runs")
+	rootCmd.Flags().BoolVar(&config.LiveDiff, "live-diff", false, "Show the number of files the agent has touched so far in the terminal title, from checkpoints pushed while it runs")
+	rootCmd.Flags().BoolVarP(&config.Detach, "detach", "d", false, "Run the task in the background without the menu, printing the task ID and returning immediately")
+	rootCmd.Flags().IntVar(&config.Samples, "samples", 0, "Run the task this many times in parallel, on branches giverny/TASK-ID-1 to -N, and compare the results to pick the best")
+	rootCmd.Flags().BoolVar(&config.Attachable, "attachable", false, "With --detach, keep the agent and menu interactive so you can connect to them with giverny attach")
+	rootCmd.Flags().BoolVar(&config.Timestamps, "timestamps", false, "Prefix each line of the container's output with the time it was written")
+	rootCmd.Flags().StringVar(&config.OutputLog, "output-log", "", "Also append the container's output to this file")
+	rootCmd.Flags().BoolVar(&config.Provenance, "provenance", false, "Add trailers recording the giverny version, task, prompt hash, agent and base image to every commit")
 
 	// Hidden flags (for internal use only)
-	rootCmd.Flags().BoolVar(&config.IsInnie, "innie", false, "Internal flag for running inside container")
-	rootCmd.Flags().IntVar(&config.GitServerPort, "git-server-port", 0, "Internal flag for git server port")
+	rootCmd.Flags().BoolVar(&config.IsInnie, "innie", false, "Internal flag for running inside container, the task given in $"+taskspec.EnvVar)
 	rootCmd.Flags().StringVar(&config.CtrlSend, "ctrl-send", "", "Send a message on the control socket and exit")
+	rootCmd.Flags().StringVar(&config.CredentialOp, "git-credential-helper", "", "Run as git's credential helper in the container for this operation and exit")
+	rootCmd.Flags().StringVar(&config.BranchUser, "branch-user", "", "Internal flag for the user the task branch is under")
+	rootCmd.Flags().StringVar(&config.SyncWorkspace, "sync-workspace", "", "Sync the task branch in the workspace with its base branch ('merge' or 'rebase') and exit")
+	rootCmd.Flags().StringVar(&config.WorkspaceDir, "workspace-dir", git.DefaultWorkspaceDir, "Internal flag for the directory the task branch is checked out in")
+	rootCmd.Flags().StringVar(&config.CloneDir, "clone-dir", git.DefaultCloneDir, "Internal flag for the directory the repository is cloned into")
+	rootCmd.Flags().BoolVar(&config.Detached, "detached", false, "Internal flag for a task started in the background by --detach")
+	rootCmd.Flags().IntVar(&config.Sample, "sample", 0, "Internal flag for the number of the sample of a task run with --samples")
 	rootCmd.Flags().MarkHidden("innie")
-	rootCmd.Flags().MarkHidden("git-server-port")
 	rootCmd.Flags().MarkHidden("ctrl-send")
+	rootCmd.Flags().MarkHidden("git-credential-helper")
+	rootCmd.Flags().MarkHidden("sync-workspace")
+	rootCmd.Flags().MarkHidden("branch-user")
+	rootCmd.Flags().MarkHidden("workspace-dir")
+	rootCmd.Flags().MarkHidden("clone-dir")
+	rootCmd.Flags().MarkHidden("detached")
+	rootCmd.Flags().MarkHidden("sample")
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
-// sanitizeSlug replaces any characters that are not safe for git branch names
-// or docker container names with hyphens. Also collapses multiple consecutive
-// hyphens into a single hyphen and trims leading/trailing hyphens.
-func sanitizeSlug(slug string) string {
-	// Replace any character that's not alphanumeric, hyphen, or underscore with a hyphen
-	invalidCharsPattern := regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
-	result := invalidCharsPattern.ReplaceAllString(slug, "-")
-
-	// Collapse multiple consecutive hyphens into one
-	multipleHyphens := regexp.MustCompile(`-+`)
-	result = multipleHyphens.ReplaceAllString(result, "-")
-
-	// Trim leading and trailing hyphens
-	result = strings.Trim(result, "-")
-
-	return result
-}
-
-// validateTaskID ensures TASK-ID contains only characters valid in git branch names.
-// Since we use the format "giverny/TASK-ID", the TASK-ID must not contain '/' and
-// must follow git branch naming rules.
-func validateTaskID(taskID string) error {
-	if taskID == "" {
-		return fmt.Errorf("TASK-ID cannot be empty")
-	}
-
-	// Regex for invalid characters in git branch names:
-	// - No forward slash, backslash, space, control chars (0-31, 127)
-	// - No ~, ^, :, ?, *, [
-	invalidCharsPattern := regexp.MustCompile(`[/\\\s\x00-\x1f\x7f~^:?*\[]`)
-	if match := invalidCharsPattern.FindString(taskID); match != "" {
-		if match == "/" {
-			return fmt.Errorf("TASK-ID cannot contain forward slash (/)")
-		} else if match == "\\" {
-			return fmt.Errorf("TASK-ID cannot contain backslash (\\)")
-		} else if match == " " {
-			return fmt.Errorf("TASK-ID cannot contain spaces")
-		} else if match[0] < 32 || match[0] == 127 {
-			return fmt.Errorf("TASK-ID cannot contain control characters")
+// taskIDArg returns the TASK-ID given as arg, which with --slugify is made
+// into a valid one instead of being rejected
+func taskIDArg(arg string) (string, error) {
+	taskID := arg
+	if slugifyTaskID {
+		var err error
+		if taskID, err = taskid.Slugify(arg); err != nil {
+			return "", fmt.Errorf("invalid TASK-ID: %w", err)
+		}
+		if taskID != arg {
+			fmt.Fprintf(os.Stderr, "Using TASK-ID %s\n", taskID)
 		}
-		return fmt.Errorf("TASK-ID cannot contain '%s'", match)
-	}
-
-	// Check for special invalid patterns
-	if strings.Contains(taskID, "..") {
-		return fmt.Errorf("TASK-ID cannot contain double dots (..)")
 	}
-	if strings.Contains(taskID, "@{") {
-		return fmt.Errorf("TASK-ID cannot contain @{")
+	if err := taskid.Validate(taskID); err != nil {
+		return "", fmt.Errorf("invalid TASK-ID: %w", err)
 	}
+	return taskID, nil
+}
 
-	// Check if starts with dot
-	if strings.HasPrefix(taskID, ".") {
-		return fmt.Errorf("TASK-ID cannot start with a dot")
+// branchUser returns the user task branches are under with --user-branch, or
+// "" without it
+func branchUser() (string, error) {
+	if !userBranch {
+		return "", nil
 	}
+	return taskid.User()
+}
 
-	// Check if ends with .lock
-	if strings.HasSuffix(taskID, ".lock") {
-		return fmt.Errorf("TASK-ID cannot end with .lock")
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
 	}
-
+	fmt.Println(string(data))
 	return nil
 }
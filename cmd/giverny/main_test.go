@@ -4,12 +4,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
 	"giverny/internal/git"
 	"giverny/internal/outie"
+	"giverny/internal/taskid"
 	"giverny/internal/testutil"
 )
 
@@ -105,13 +105,13 @@ func createTestCommand(validateOnly bool) *cobra.Command {
 			testConfig.TaskID = args[0]
 
 			// Validate TASK-ID
-			if err := validateTaskID(testConfig.TaskID); err != nil {
+			if err := taskid.Validate(testConfig.TaskID); err != nil {
 				return err
 			}
 
 			// Sanitize slug if provided
 			if testConfig.Slug != "" {
-				testConfig.Slug = sanitizeSlug(testConfig.Slug)
+				testConfig.Slug = taskid.SanitizeSlug(testConfig.Slug)
 			}
 
 			// Set default prompt if not provided
@@ -119,11 +119,6 @@ func createTestCommand(validateOnly bool) *cobra.Command {
 				testConfig.Prompt = "Please work on " + testConfig.TaskID + "."
 			}
 
-			// Validate innie-specific requirements
-			if testConfig.IsInnie && testConfig.GitServerPort == 0 {
-				return nil // Don't fail validation for tests
-			}
-
 			// Copy to global config for test access
 			config = testConfig
 			return nil
@@ -139,9 +134,7 @@ func createTestCommand(validateOnly bool) *cobra.Command {
 	cmd.Flags().BoolVar(&testConfig.Debug, "debug", false, "Enable debug output")
 	cmd.Flags().BoolVar(&testConfig.ShowBuildOutput, "show-build-output", false, "Show docker build output")
 	cmd.Flags().BoolVar(&testConfig.IsInnie, "innie", false, "Internal flag for running inside container")
-	cmd.Flags().IntVar(&testConfig.GitServerPort, "git-server-port", 0, "Internal flag for git server port")
 	cmd.Flags().MarkHidden("innie")
-	cmd.Flags().MarkHidden("git-server-port")
 
 	return cmd
 }
@@ -268,7 +261,6 @@ func TestParseArgs_InnieMode(t *testing.T) {
 	cmd := createTestCommand(true)
 	cmd.SetArgs([]string{
 		"--innie",
-		"--git-server-port", "3000",
 		"task-001",
 	})
 
@@ -280,10 +272,6 @@ func TestParseArgs_InnieMode(t *testing.T) {
 	if !config.IsInnie {
 		t.Error("expected IsInnie to be true")
 	}
-
-	if config.GitServerPort != 3000 {
-		t.Errorf("expected GitServerPort 3000, got %d", config.GitServerPort)
-	}
 }
 
 func TestIsWorkspaceDirty_CleanWorkspace(t *testing.T) {
@@ -313,72 +301,6 @@ func TestIsWorkspaceDirty_CleanWorkspace(t *testing.T) {
 	}
 }
 
-func TestValidateTaskID(t *testing.T) {
-	tests := []struct {
-		name    string
-		taskID  string
-		wantErr bool
-		errMsg  string
-	}{
-		// Valid task IDs
-		{name: "valid simple", taskID: "abc", wantErr: false},
-		{name: "valid with numbers", taskID: "task-123", wantErr: false},
-		{name: "valid with underscores", taskID: "my_task", wantErr: false},
-		{name: "valid with dots", taskID: "task.1.2", wantErr: false},
-		{name: "valid mixed", taskID: "giv-4z1", wantErr: false},
-
-		// Invalid - empty
-		{name: "empty", taskID: "", wantErr: true, errMsg: "cannot be empty"},
-
-		// Invalid - forward slash
-		{name: "contains slash", taskID: "task/123", wantErr: true, errMsg: "forward slash"},
-
-		// Invalid - starts with dot
-		{name: "starts with dot", taskID: ".task", wantErr: true, errMsg: "start with a dot"},
-
-		// Invalid - ends with .lock
-		{name: "ends with .lock", taskID: "task.lock", wantErr: true, errMsg: "end with .lock"},
-
-		// Invalid - double dots
-		{name: "contains double dots", taskID: "task..123", wantErr: true, errMsg: "double dots"},
-
-		// Invalid - @{
-		{name: "contains @{", taskID: "task@{123", wantErr: true, errMsg: "@{"},
-
-		// Invalid - special characters
-		{name: "contains backslash", taskID: "task\\123", wantErr: true, errMsg: "backslash"},
-		{name: "contains space", taskID: "task 123", wantErr: true, errMsg: "space"},
-		{name: "contains tilde", taskID: "task~123", wantErr: true, errMsg: "~"},
-		{name: "contains caret", taskID: "task^123", wantErr: true, errMsg: "^"},
-		{name: "contains colon", taskID: "task:123", wantErr: true, errMsg: ":"},
-		{name: "contains question mark", taskID: "task?123", wantErr: true, errMsg: "?"},
-		{name: "contains asterisk", taskID: "task*123", wantErr: true, errMsg: "*"},
-		{name: "contains square bracket", taskID: "task[123", wantErr: true, errMsg: "["},
-
-		// Invalid - control characters
-		{name: "contains newline", taskID: "task\n123", wantErr: true, errMsg: "control"},
-		{name: "contains tab", taskID: "task\t123", wantErr: true, errMsg: "control"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateTaskID(tt.taskID)
-
-			if tt.wantErr {
-				if err == nil {
-					t.Errorf("validateTaskID(%q) expected error containing %q, got nil", tt.taskID, tt.errMsg)
-				} else if !strings.Contains(err.Error(), tt.errMsg) {
-					t.Errorf("validateTaskID(%q) expected error containing %q, got %q", tt.taskID, tt.errMsg, err.Error())
-				}
-			} else {
-				if err != nil {
-					t.Errorf("validateTaskID(%q) expected no error, got %v", tt.taskID, err)
-				}
-			}
-		})
-	}
-}
-
 func TestIsWorkspaceDirty_DirtyWorkspace(t *testing.T) {
 	// Create a temporary directory for testing
 	tmpDir, err := os.MkdirTemp("", "giverny-test-*")
@@ -412,55 +334,6 @@ func TestIsWorkspaceDirty_DirtyWorkspace(t *testing.T) {
 	}
 }
 
-func TestSanitizeSlug(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    string
-		expected string
-	}{
-		// Simple cases
-		{name: "simple word", input: "hello", expected: "hello"},
-		{name: "with hyphen", input: "hello-world", expected: "hello-world"},
-		{name: "with underscore", input: "hello_world", expected: "hello_world"},
-		{name: "with numbers", input: "task123", expected: "task123"},
-
-		// Spaces
-		{name: "spaces to hyphens", input: "hello world", expected: "hello-world"},
-		{name: "multiple spaces", input: "hello   world", expected: "hello-world"},
-
-		// Special characters
-		{name: "special chars", input: "add@feature!", expected: "add-feature"},
-		{name: "mixed special", input: "fix: bug #123", expected: "fix-bug-123"},
-		{name: "slashes", input: "path/to/file", expected: "path-to-file"},
-
-		// Leading/trailing
-		{name: "leading space", input: " hello", expected: "hello"},
-		{name: "trailing space", input: "hello ", expected: "hello"},
-		{name: "leading special", input: "!hello", expected: "hello"},
-		{name: "trailing special", input: "hello!", expected: "hello"},
-
-		// Multiple consecutive hyphens
-		{name: "collapse hyphens", input: "hello---world", expected: "hello-world"},
-		{name: "special chars create hyphens", input: "hello!!!world", expected: "hello-world"},
-
-		// Unicode
-		{name: "unicode chars", input: "héllo wörld", expected: "h-llo-w-rld"},
-
-		// Empty result
-		{name: "all special chars", input: "!@#$%", expected: ""},
-		{name: "only spaces", input: "   ", expected: ""},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := sanitizeSlug(tt.input)
-			if result != tt.expected {
-				t.Errorf("sanitizeSlug(%q) = %q, want %q", tt.input, result, tt.expected)
-			}
-		})
-	}
-}
-
 func TestGetVersion(t *testing.T) {
 	tests := []struct {
 		name           string
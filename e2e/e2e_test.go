@@ -0,0 +1,117 @@
+//go:build e2e
+
+// Package e2e runs giverny end to end: outie on the host, the container, innie
+// and the push back to the host. The image is built with GIV_TEST=1 so that
+// fakeclaude stands in for Claude Code and no credentials are needed.
+//
+// Run with: make e2e-test
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"giverny/internal/testutil"
+)
+
+// baseImage is the image the test container is built from
+const baseImage = "alpine:latest"
+
+// givernyBinary is the giverny binary under test, built once by TestMain
+var givernyBinary string
+
+// TestMain builds the giverny binary the tests run, or skips them all when
+// there is no Docker to run the task in.
+func TestMain(m *testing.M) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Println("Skipping end-to-end tests: docker not found in PATH")
+		os.Exit(0)
+	}
+
+	binDir, err := os.MkdirTemp("", "giverny-e2e-bin-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	givernyBinary = filepath.Join(binDir, "giverny")
+	build := exec.Command("go", "build", "-o", givernyBinary, "./cmd/giverny")
+	build.Dir = ".."
+	if output, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build giverny: %v\n%s", err, output)
+		os.RemoveAll(binDir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
+func TestEndToEnd(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-e2e-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	if err := os.Mkdir(repoDir, 0755); err != nil {
+		t.Fatalf("failed to create repo dir: %v", err)
+	}
+	testutil.InitTestRepo(t, repoDir)
+
+	taskID := "e2e-task"
+	prompt := "Add a greeting"
+	defer exec.Command("docker", "rm", "-f", "giverny-"+taskID).Run()
+
+	// fakeclaude commits its change straight away, so exit the menu and push
+	cmd := exec.Command(givernyBinary, "--base-image", baseImage, "--prompt", prompt, taskID)
+	cmd.Dir = repoDir
+	cmd.Env = append(os.Environ(), "GIV_TEST=1", "CLAUDE_CODE_OAUTH_TOKEN=giverny-e2e")
+	cmd.Stdin = strings.NewReader("x\n")
+
+	done := make(chan struct{})
+	var output []byte
+	go func() {
+		output, err = cmd.CombinedOutput()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Minute):
+		cmd.Process.Kill()
+		<-done
+		t.Fatalf("giverny timed out:\n%s", output)
+	}
+	if err != nil {
+		t.Fatalf("giverny failed: %v\n%s", err, output)
+	}
+
+	branch := "giverny/" + taskID
+	subject := gitOutput(t, repoDir, "log", "-1", "--format=%s", branch)
+	if subject != "fakeclaude: "+prompt {
+		t.Errorf("expected fakeclaude's commit at the head of %s, got %q", branch, subject)
+	}
+
+	notes := gitOutput(t, repoDir, "show", branch+":FAKECLAUDE.md")
+	if notes != prompt {
+		t.Errorf("expected FAKECLAUDE.md to contain the prompt, got %q", notes)
+	}
+}
+
+// gitOutput runs git in dir and returns its trimmed output
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s failed: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output))
+}
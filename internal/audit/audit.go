@@ -0,0 +1,48 @@
+// Package audit records the shell commands the agent runs inside the container.
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LogPath is where the audit log is written inside the container
+const LogPath = "/var/log/giverny/audit.log"
+
+// scriptPath is where the bash startup file that does the logging is installed
+const scriptPath = "/etc/giverny-audit.bash"
+
+// Enable installs the audit script and points BASH_ENV at it, so every
+// non-interactive bash started by this process or its children (which is how
+// the agent runs commands) appends each command it runs to LogPath.
+func Enable() error {
+	return EnableWithPaths(scriptPath, LogPath)
+}
+
+// EnableWithPaths is Enable with the script and log locations given explicitly.
+func EnableWithPaths(script, logPath string) error {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	if err := os.WriteFile(script, []byte(Script(logPath)), 0644); err != nil {
+		return fmt.Errorf("failed to install audit script: %w", err)
+	}
+	if err := os.Setenv("BASH_ENV", script); err != nil {
+		return fmt.Errorf("failed to set BASH_ENV: %w", err)
+	}
+	return nil
+}
+
+// Script returns the bash startup file that logs each command to logPath as a
+// tab-separated line of UTC timestamp, shell PID, working directory and command.
+func Script(logPath string) string {
+	return fmt.Sprintf(`# Installed by giverny to log the commands run in the container
+__giverny_audit() {
+	local ts
+	TZ=UTC printf -v ts '%%(%%Y-%%m-%%dT%%H:%%M:%%SZ)T' -1
+	printf '%%s\t%%s\t%%s\t%%s\n' "$ts" "$$" "$PWD" "$BASH_COMMAND" >> %q
+}
+trap '__giverny_audit' DEBUG
+`, logPath)
+}
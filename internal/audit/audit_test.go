@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestEnableWithPaths(t *testing.T) {
+	t.Setenv("BASH_ENV", "")
+
+	tmpDir := t.TempDir()
+	script := filepath.Join(tmpDir, "audit.bash")
+	logPath := filepath.Join(tmpDir, "log", "audit.log")
+
+	if err := EnableWithPaths(script, logPath); err != nil {
+		t.Fatalf("EnableWithPaths failed: %v", err)
+	}
+	if got := os.Getenv("BASH_ENV"); got != script {
+		t.Errorf("BASH_ENV = %q, want %q", got, script)
+	}
+
+	cmd := exec.Command("bash", "-c", "cd / && echo audited > /dev/null")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("bash failed: %v\n%s", err, output)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	log := string(data)
+	if !strings.Contains(log, "\t/\techo audited > /dev/null\n") {
+		t.Errorf("expected audit log to record the command with its directory, got:\n%s", log)
+	}
+	if !strings.Contains(log, "cd /") {
+		t.Errorf("expected audit log to record cd, got:\n%s", log)
+	}
+}
@@ -0,0 +1,194 @@
+// Package bench compares the benchmark results of two runs of a benchmark
+// command, in the format `go test -bench` prints.
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Benchmark is the measurements of one benchmark over the runs in a
+// command's output, by unit (such as "ns/op")
+type Benchmark struct {
+	Pkg    string
+	Name   string
+	Values map[string][]float64
+	units  []string // in the order they were first seen
+}
+
+// Parse reads the benchmark results in output: lines such as
+//
+//	BenchmarkParse-8   1000   1052 ns/op   256 B/op   4 allocs/op
+//
+// each under the "pkg:" line of its package. A benchmark run more than once,
+// as with -count, has a value for each run. Benchmarks are returned in the
+// order they were first seen; other lines are ignored.
+func Parse(output string) []*Benchmark {
+	var benchmarks []*Benchmark
+	seen := map[string]*Benchmark{}
+	pkg := ""
+	for _, line := range strings.Split(output, "\n") {
+		if rest, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = strings.TrimSpace(rest)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || len(fields)%2 != 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+
+		key := pkg + "\x00" + fields[0]
+		b := seen[key]
+		if b == nil {
+			b = &Benchmark{Pkg: pkg, Name: fields[0], Values: map[string][]float64{}}
+			seen[key] = b
+			benchmarks = append(benchmarks, b)
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			unit := fields[i+1]
+			if _, ok := b.Values[unit]; !ok {
+				b.units = append(b.units, unit)
+			}
+			b.Values[unit] = append(b.Values[unit], value)
+		}
+	}
+	return benchmarks
+}
+
+// Comparison is how one measurement of a benchmark changed between two runs,
+// as the mean of each run's values
+type Comparison struct {
+	Pkg  string
+	Name string
+	Unit string
+	Old  float64
+	New  float64
+}
+
+// Delta returns the change from Old to New in percent. It is +Inf if Old is
+// zero and New isn't.
+func (c Comparison) Delta() float64 {
+	if c.Old == 0 {
+		if c.New == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (c.New - c.Old) / c.Old * 100
+}
+
+// Regressed reports whether the measurement got worse by more than threshold
+// percent. Lower is better, except for rates such as MB/s.
+func (c Comparison) Regressed(threshold float64) bool {
+	delta := c.Delta()
+	if strings.HasSuffix(c.Unit, "/s") {
+		delta = -delta
+	}
+	return delta > threshold
+}
+
+// Compare pairs up the measurements of the benchmarks in both old and new.
+// Benchmarks only one of them ran are left out.
+func Compare(old, new []*Benchmark) []Comparison {
+	oldByKey := map[string]*Benchmark{}
+	for _, b := range old {
+		oldByKey[b.Pkg+"\x00"+b.Name] = b
+	}
+
+	var comparisons []Comparison
+	for _, n := range new {
+		o := oldByKey[n.Pkg+"\x00"+n.Name]
+		if o == nil {
+			continue
+		}
+		for _, unit := range n.units {
+			if len(o.Values[unit]) == 0 {
+				continue
+			}
+			comparisons = append(comparisons, Comparison{Pkg: n.Pkg, Name: n.Name, Unit: unit, Old: mean(o.Values[unit]), New: mean(n.Values[unit])})
+		}
+	}
+	return comparisons
+}
+
+// Print writes the comparisons to w as a table for each unit, marking those
+// that regressed by more than threshold percent, and returns how many did
+func Print(w io.Writer, comparisons []Comparison, threshold float64) int {
+	var units []string
+	pkgs := map[string]bool{}
+	for _, c := range comparisons {
+		if !slices.Contains(units, c.Unit) {
+			units = append(units, c.Unit)
+		}
+		pkgs[c.Pkg] = true
+	}
+
+	regressions := 0
+	for i, unit := range units {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(tw, "NAME\tOLD %s\tNEW %s\tDELTA\n", unit, unit)
+		for _, c := range comparisons {
+			if c.Unit != unit {
+				continue
+			}
+			name := strings.TrimPrefix(c.Name, "Benchmark")
+			if len(pkgs) > 1 && c.Pkg != "" {
+				name = path.Base(c.Pkg) + "." + name
+			}
+			mark := ""
+			if c.Regressed(threshold) {
+				mark = " ⚠️"
+				regressions++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s%s\n", name, formatValue(c.Old), formatValue(c.New), formatDelta(c.Delta()), mark)
+		}
+		tw.Flush()
+	}
+	return regressions
+}
+
+// mean returns the mean of values
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// formatValue formats a measurement with up to 4 significant digits, without
+// switching large values to exponent notation
+func formatValue(v float64) string {
+	if math.Abs(v) >= 1000 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// formatDelta formats a change in percent, "~" if there is none
+func formatDelta(delta float64) string {
+	switch {
+	case math.IsInf(delta, 1):
+		return "+∞%"
+	case math.Abs(delta) < 0.05:
+		return "~"
+	default:
+		return fmt.Sprintf("%+.1f%%", delta)
+	}
+}
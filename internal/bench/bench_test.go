@@ -0,0 +1,106 @@
+package bench
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const oldOutput = `goos: linux
+goarch: amd64
+pkg: example.com/app/parse
+cpu: Some CPU
+BenchmarkParse-8     	 1000000	      1000 ns/op	     256 B/op	       4 allocs/op
+BenchmarkParse-8     	 1000000	      1200 ns/op	     256 B/op	       4 allocs/op
+BenchmarkCopy-8      	    5000	    200000 ns/op	  500.00 MB/s
+BenchmarkRemoved-8   	    5000	       100 ns/op
+PASS
+ok  	example.com/app/parse	3.2s
+`
+
+const newOutput = `pkg: example.com/app/parse
+BenchmarkParse-8     	 1000000	      1650 ns/op	     256 B/op	       5 allocs/op
+BenchmarkParse-8     	 1000000	      1650 ns/op	     256 B/op	       5 allocs/op
+BenchmarkCopy-8      	    5000	    200000 ns/op	  550.00 MB/s
+BenchmarkAdded-8     	    5000	       100 ns/op
+PASS
+`
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestParse(t *testing.T) {
+	benchmarks := Parse(oldOutput)
+	if len(benchmarks) != 3 {
+		t.Fatalf("expected 3 benchmarks, got %d", len(benchmarks))
+	}
+	parse := benchmarks[0]
+	if parse.Pkg != "example.com/app/parse" || parse.Name != "BenchmarkParse-8" {
+		t.Errorf("unexpected benchmark %s %s", parse.Pkg, parse.Name)
+	}
+	if want := []float64{1000, 1200}; !reflect.DeepEqual(parse.Values["ns/op"], want) {
+		t.Errorf("expected ns/op %v, got %v", want, parse.Values["ns/op"])
+	}
+	if want := []string{"ns/op", "B/op", "allocs/op"}; !reflect.DeepEqual(parse.units, want) {
+		t.Errorf("expected units %v, got %v", want, parse.units)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	comparisons := Compare(Parse(oldOutput), Parse(newOutput))
+
+	var got []string
+	for _, c := range comparisons {
+		got = append(got, c.Name+" "+c.Unit)
+	}
+	want := []string{"BenchmarkParse-8 ns/op", "BenchmarkParse-8 B/op", "BenchmarkParse-8 allocs/op", "BenchmarkCopy-8 ns/op", "BenchmarkCopy-8 MB/s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected comparisons %v, got %v", want, got)
+	}
+
+	// The mean of 1000 and 1200 is 1100, so 1650 is 50% slower
+	if delta := comparisons[0].Delta(); math.Abs(delta-50) > 1e-9 {
+		t.Errorf("expected a delta of 50%%, got %v", delta)
+	}
+	if !comparisons[0].Regressed(5) || comparisons[1].Regressed(5) {
+		t.Error("expected only ns/op of Parse to regress among its first two measurements")
+	}
+	// A higher rate is better
+	if comparisons[4].Regressed(5) {
+		t.Error("expected a higher MB/s not to be a regression")
+	}
+
+	zero := Comparison{Unit: "allocs/op", Old: 0, New: 1}
+	if !math.IsInf(zero.Delta(), 1) || !zero.Regressed(5) {
+		t.Errorf("expected allocations from none to regress, got delta %v", zero.Delta())
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var out bytes.Buffer
+	regressions := Print(&out, Compare(Parse(oldOutput), Parse(newOutput)), 5)
+	if regressions != 2 {
+		t.Errorf("expected 2 regressions, got %d:\n%s", regressions, out.String())
+	}
+
+	text := out.String()
+	for _, want := range []string{"NAME", "OLD ns/op", "NEW ns/op", "Parse-8", "1100", "1650", "+50.0% ⚠️", "+25.0% ⚠️", "~", "+10.0%"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Removed") || strings.Contains(text, "Added") {
+		t.Errorf("expected benchmarks only one run has to be left out:\n%s", text)
+	}
+}
@@ -0,0 +1,90 @@
+package cmdutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitArgs splits s into arguments the way a POSIX shell would, without
+// expanding anything: arguments are separated by unquoted whitespace, single
+// quotes keep everything up to the next single quote, double quotes keep
+// everything up to the next unescaped double quote with backslash escaping
+// ", \, $ and `, and a backslash outside quotes escapes the next character.
+// So `-e FOO="a b"` is "-e" and "FOO=a b".
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false // arg has started, even if it is still empty, as with ""
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case r == '\\':
+			inArg = true
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			// A backslash-newline is a line continuation
+			if runes[i] != '\n' {
+				arg.WriteRune(runes[i])
+			}
+		case r == '\'':
+			inArg = true
+			end := indexRune(runes, i+1, '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			arg.WriteString(string(runes[i+1 : end]))
+			i = end
+		case r == '"':
+			inArg = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				arg.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+		default:
+			inArg = true
+			arg.WriteRune(r)
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// indexRune returns the index of the first r in runes at or after start, or
+// -1 if there is none
+func indexRune(runes []rune, start int, r rune) int {
+	for i := start; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// QuoteArg quotes arg so that SplitArgs, or a shell, reads it back as a
+// single argument. Arguments that need no quoting are returned as they are.
+func QuoteArg(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,90 @@
+package cmdutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"--model opus", []string{"--model", "opus"}},
+		{"  -v  /a:/b \t--rm\n", []string{"-v", "/a:/b", "--rm"}},
+		{`-e FOO="a b"`, []string{"-e", "FOO=a b"}},
+		{`-e 'FOO=a b'`, []string{"-e", "FOO=a b"}},
+		{`-e FOO=a\ b`, []string{"-e", "FOO=a b"}},
+		{`--label 'it'\''s'`, []string{"--label", "it's"}},
+		{`"say \"hi\""`, []string{`say "hi"`}},
+		{`"\a\$\\"`, []string{`\a$\`}},
+		{`'no \escapes "here"'`, []string{`no \escapes "here"`}},
+		{`"" ''`, []string{"", ""}},
+		{`a"b c"d`, []string{"ab cd"}},
+		{`--append-system-prompt "Be brief.
+Use tests."`, []string{"--append-system-prompt", "Be brief.\nUse tests."}},
+		{"a \\\nb", []string{"a", "b"}},
+		{`-v "/path with spaces:/app/data"`, []string{"-v", "/path with spaces:/app/data"}},
+		{`$HOME ~ *`, []string{"$HOME", "~", "*"}},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.input)
+		if err != nil {
+			t.Errorf("SplitArgs(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestSplitArgs_Errors(t *testing.T) {
+	for _, input := range []string{`-e FOO="a b`, `-e 'FOO`, `trailing\`, `"a\"`} {
+		if args, err := SplitArgs(input); err == nil {
+			t.Errorf("SplitArgs(%q) = %q, expected an error", input, args)
+		}
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"--rm", "--rm"},
+		{"HTTP_PROXY=http://proxy:3128", "HTTP_PROXY=http://proxy:3128"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	}
+	for _, tt := range tests {
+		if got := QuoteArg(tt.input); got != tt.expected {
+			t.Errorf("QuoteArg(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestQuoteArg_RoundTrip checks that quoted arguments are split back into
+// the same arguments, whatever they hold
+func TestQuoteArg_RoundTrip(t *testing.T) {
+	roundTrip := func(args []string) bool {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = QuoteArg(arg)
+		}
+		got, err := SplitArgs(strings.Join(quoted, " "))
+		if err != nil {
+			return false
+		}
+		return len(got) == len(args) && (len(args) == 0 || reflect.DeepEqual(got, args))
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
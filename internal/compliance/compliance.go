@@ -0,0 +1,93 @@
+// Package compliance runs the project's licensing and header checks on the
+// files the agent changed.
+package compliance
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// spdxHeaderLines is how far into a file the SPDX identifier may appear, to
+// allow for shebangs, build tags and copyright lines above it.
+const spdxHeaderLines = 10
+
+// spdxExtensions are the source file types that must carry an SPDX header.
+var spdxExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cs": true, ".go": true, ".h": true,
+	".hpp": true, ".java": true, ".js": true, ".jsx": true, ".kt": true, ".php": true,
+	".py": true, ".rb": true, ".rs": true, ".scala": true, ".sh": true, ".swift": true,
+	".ts": true, ".tsx": true,
+}
+
+// CheckSPDX returns a problem for each source file in files (relative to dir)
+// that has no SPDX-License-Identifier in its first lines. Files that no longer
+// exist and non-source files are skipped.
+func CheckSPDX(dir string, files []string) ([]string, error) {
+	var problems []string
+	for _, file := range files {
+		if !spdxExtensions[filepath.Ext(file)] {
+			continue
+		}
+		found, err := hasSPDXHeader(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: missing SPDX-License-Identifier header", file))
+		}
+	}
+	return problems, nil
+}
+
+// hasSPDXHeader reports whether the file has an SPDX identifier near the top.
+func hasSPDXHeader(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < spdxHeaderLines && scanner.Scan(); i++ {
+		if strings.Contains(scanner.Text(), "SPDX-License-Identifier:") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// RunCommand runs a compliance command through sh in dir, with the changed
+// files appended as arguments. Files the changes deleted are left out, as
+// tools that open each file would fail on them; if that leaves none, the
+// command isn't run. It returns the command's combined output and whether it
+// succeeded; err is only set if the command could not be run.
+func RunCommand(dir, command string, files []string) (string, bool, error) {
+	var existing []string
+	for _, file := range files {
+		if _, err := os.Lstat(filepath.Join(dir, file)); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	if len(existing) == 0 {
+		return "", true, nil
+	}
+
+	args := append([]string{"-c", command + ` "$@"`, "sh"}, existing...)
+	cmd := exec.Command("sh", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return string(output), false, nil
+		}
+		return "", false, fmt.Errorf("failed to run compliance command: %w", err)
+	}
+	return string(output), true, nil
+}
@@ -0,0 +1,81 @@
+package compliance
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestCheckSPDX(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.go":    "// SPDX-License-Identifier: MIT\n\npackage main\n",
+		"shebang.sh": "#!/bin/sh\n# Copyright 2026 Example\n# SPDX-License-Identifier: Apache-2.0\necho hi\n",
+		"bad.py":     "print('hello')\n",
+		"README.md":  "# No header needed\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	problems, err := CheckSPDX(dir, []string{"good.go", "shebang.sh", "bad.py", "README.md", "deleted.go"})
+	if err != nil {
+		t.Fatalf("CheckSPDX failed: %v", err)
+	}
+	expected := []string{"bad.py: missing SPDX-License-Identifier header"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("CheckSPDX() = %v, want %v", problems, expected)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b c.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deleted.go was deleted by the changes, so isn't passed on
+	output, ok, err := RunCommand(dir, "echo checked", []string{"a.go", "deleted.go", "b c.go"})
+	if err != nil {
+		t.Fatalf("RunCommand failed: %v", err)
+	}
+	if !ok {
+		t.Error("expected command to succeed")
+	}
+	if strings.TrimSpace(output) != "checked a.go b c.go" {
+		t.Errorf("expected changed files to be passed as arguments, got %q", output)
+	}
+
+	output, ok, err = RunCommand(dir, `sh -c 'for f; do echo "$f: bad header"; done; exit 1' check`, []string{"a.go"})
+	if err != nil {
+		t.Fatalf("RunCommand failed: %v", err)
+	}
+	if ok {
+		t.Error("expected command to fail")
+	}
+	if !strings.Contains(output, "a.go: bad header") {
+		t.Errorf("expected failure output, got %q", output)
+	}
+
+	// With only deleted files there is nothing to check
+	if output, ok, err = RunCommand(dir, "exit 1", []string{"deleted.go"}); err != nil || !ok || output != "" {
+		t.Errorf("expected the command not to run, got ok=%v output=%q err=%v", ok, output, err)
+	}
+}
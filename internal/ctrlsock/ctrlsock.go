@@ -8,12 +8,16 @@ package ctrlsock
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
 // EnvVar is the environment variable that holds the control server address
@@ -35,18 +39,34 @@ type Listener struct {
 	orbstack      bool   // true if running under OrbStack
 	done          chan struct{}
 	debug         bool
+
+	mu          sync.Mutex
+	pushed      map[string]string // branch name -> commit hash reported by innie
+	credentials CredentialFunc    // nil unless the task was given git credentials
+	token       string            // the task's token, which credential requests must give
 }
 
+// CredentialFunc returns the git credential for host in git's credential
+// helper format, or an error if the task may not have one
+type CredentialFunc func(host string) (string, error)
+
 // Listen binds a TCP port on localhost (OS-allocated) and starts a goroutine
 // that accepts connections and handles messages. containerName is the Docker
 // container name, used to construct OrbStack URLs. The returned Listener must
 // be closed with Close().
 func Listen(containerName string, debug bool) (*Listener, error) {
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	return ListenOnPort(containerName, 0, debug)
+}
+
+// ListenOnPort is like Listen but binds the given port. This is used when a
+// container is reused, since its environment still holds the old address.
+// A port of 0 lets the OS allocate one.
+func ListenOnPort(containerName string, port int, debug bool) (*Listener, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen: %w", err)
 	}
-	port := ln.Addr().(*net.TCPAddr).Port
+	port = ln.Addr().(*net.TCPAddr).Port
 
 	l := &Listener{
 		ln:            ln,
@@ -55,6 +75,7 @@ func Listen(containerName string, debug bool) (*Listener, error) {
 		orbstack:      isOrbStack(),
 		done:          make(chan struct{}),
 		debug:         debug,
+		pushed:        make(map[string]string),
 	}
 
 	go l.accept()
@@ -90,13 +111,18 @@ func (l *Listener) handleConn(conn net.Conn) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		if l.debug {
-			fmt.Fprintf(os.Stderr, "[ctrlsock] received: %s\n", line)
+			logged := line
+			if strings.HasPrefix(line, "CREDENTIAL ") {
+				logged = "CREDENTIAL (token and host not shown)"
+			}
+			fmt.Fprintf(os.Stderr, "[ctrlsock] received: %s\n", logged)
 		}
-		l.dispatch(line)
+		l.dispatch(line, conn)
 	}
 }
 
-func (l *Listener) dispatch(msg string) {
+// dispatch handles a message. Replies to requests are written to w.
+func (l *Listener) dispatch(msg string, w io.Writer) {
 	parts := strings.SplitN(msg, " ", 2)
 	cmd := parts[0]
 
@@ -113,11 +139,78 @@ func (l *Listener) dispatch(msg string) {
 		if err := openBrowser(url); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: failed to open browser for %s: %v\n", url, err)
 		}
+	case "PUSHED":
+		// PUSHED <branch> <commit>: innie pushed branch at commit to the git server
+		var branch, commit string
+		if len(parts) > 1 {
+			if fields := strings.Fields(parts[1]); len(fields) == 2 {
+				branch, commit = fields[0], fields[1]
+			}
+		}
+		if branch == "" {
+			fmt.Fprintf(os.Stderr, "Warning: malformed control message: %s\n", msg)
+			return
+		}
+		l.mu.Lock()
+		l.pushed[branch] = commit
+		l.mu.Unlock()
+	case "CREDENTIAL":
+		// CREDENTIAL <token> <host>: innie's git credential helper asks for
+		// the credential for host, giving the task's token, since any
+		// process on the host can connect. The reply is the credential in
+		// git's format, or just the blank line that ends it if there is none.
+		reply := "\n"
+		l.mu.Lock()
+		credentials, token := l.credentials, l.token
+		l.mu.Unlock()
+		var fields []string
+		if len(parts) > 1 {
+			fields = strings.Fields(parts[1])
+		}
+		switch {
+		case credentials == nil:
+		case len(fields) != 2 || subtle.ConstantTimeCompare([]byte(fields[0]), []byte(token)) != 1:
+			fmt.Fprintf(os.Stderr, "Warning: refused a request for a git credential without the task's token\n")
+		default:
+			credential, err := credentials(fields[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: git credential for %s not given to the task: %v\n", fields[1], err)
+			} else {
+				reply = credential
+			}
+		}
+		io.WriteString(w, reply)
 	default:
 		fmt.Fprintf(os.Stderr, "Warning: unknown control message: %s\n", msg)
 	}
 }
 
+// ServeCredentials answers innie's requests for git credentials that give
+// token, the task's token, with fn. Without a token no request is answered.
+func (l *Listener) ServeCredentials(token string, fn CredentialFunc) {
+	if token == "" {
+		return
+	}
+	l.mu.Lock()
+	l.credentials, l.token = fn, token
+	l.mu.Unlock()
+}
+
+// WaitForPush waits up to timeout for innie to report that it pushed branch,
+// and returns the commit hash it reported. Returns false if no report arrived.
+func (l *Listener) WaitForPush(branch string, timeout time.Duration) (string, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l.mu.Lock()
+		commit, ok := l.pushed[branch]
+		l.mu.Unlock()
+		if ok || time.Now().After(deadline) {
+			return commit, ok
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // rewriteURL converts a container-local URL to a host-accessible URL.
 // For OrbStack: http://container-name.orb.local (port auto-detected by OrbStack)
 // For regular Docker: the URL is returned unchanged (requires -p port mapping).
@@ -165,3 +258,30 @@ func Send(addr, msg string) error {
 	_, err = conn.Write([]byte(msg))
 	return err
 }
+
+// RequestCredential asks the control server at addr for the git credential
+// for host, giving the task's token, and returns it in git's credential
+// helper format. It returns an empty string if outie has none to give.
+func RequestCredential(addr, token, host string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to control server: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "CREDENTIAL %s %s\n", token, host); err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			return reply.String(), nil
+		}
+		reply.WriteString(scanner.Text() + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read credential: %w", err)
+	}
+	return "", fmt.Errorf("control server closed the connection without a credential")
+}
@@ -94,3 +94,73 @@ func TestContainerAddrEmpty(t *testing.T) {
 		t.Fatalf("expected empty addr, got: %s", a)
 	}
 }
+
+func TestWaitForPush(t *testing.T) {
+	l, err := Listen("test-container", false)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", l.Port())
+
+	if _, ok := l.WaitForPush("giverny/task", 20*time.Millisecond); ok {
+		t.Fatal("expected no push report before one is sent")
+	}
+
+	if err := Send(addr, "PUSHED giverny/task abc123"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	commit, ok := l.WaitForPush("giverny/task", time.Second)
+	if !ok {
+		t.Fatal("expected push report")
+	}
+	if commit != "abc123" {
+		t.Errorf("expected commit abc123, got %q", commit)
+	}
+
+	// Reports for other branches are kept separate
+	if _, ok := l.WaitForPush("giverny/other", 20*time.Millisecond); ok {
+		t.Error("expected no push report for other branch")
+	}
+}
+
+func TestRequestCredential(t *testing.T) {
+	l, err := Listen("test-container", false)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", l.Port())
+
+	// No credentials are given unless outie serves them
+	if credential, err := RequestCredential(addr, "0123abcd", "github.com"); err != nil || credential != "" {
+		t.Fatalf("RequestCredential() = %q, %v, want no credential", credential, err)
+	}
+
+	l.ServeCredentials("0123abcd", func(host string) (string, error) {
+		if host != "github.com" {
+			return "", fmt.Errorf("not allowed")
+		}
+		return "username=x-access-token\npassword=abc\n\n", nil
+	})
+	credential, err := RequestCredential(addr, "0123abcd", "github.com")
+	if err != nil {
+		t.Fatalf("RequestCredential failed: %v", err)
+	}
+	if credential != "username=x-access-token\npassword=abc\n" {
+		t.Errorf("unexpected credential %q", credential)
+	}
+	if credential, err := RequestCredential(addr, "0123abcd", "gitlab.com"); err != nil || credential != "" {
+		t.Errorf("RequestCredential() = %q, %v, want no credential", credential, err)
+	}
+
+	// Nor without the task's token
+	for _, token := range []string{"wrong", ""} {
+		if credential, err := RequestCredential(addr, token, "github.com"); err != nil || credential != "" {
+			t.Errorf("RequestCredential() with token %q = %q, %v, want no credential", token, credential, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package describe turns a task branch into a pull request description.
+package describe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"giverny/internal/git"
+)
+
+// maxAgentDiffBytes caps how much of the diff is given to the agent, so that
+// large changes don't exceed its context.
+const maxAgentDiffBytes = 100 * 1024
+
+// Description is what describe knows about a task branch.
+type Description struct {
+	TaskID  string
+	Branch  string
+	Base    string
+	Summary string // the agent's summary note, from --summary
+	Commits []string
+	Stats   []git.FileStat
+}
+
+// Load collects the commits and changed files on branchName since it diverged
+// from baseBranch, in the current git repository.
+func Load(taskID, branchName, baseBranch string) (*Description, error) {
+	return LoadInDir(".", taskID, branchName, baseBranch)
+}
+
+// LoadInDir is Load for the repository at dir.
+func LoadInDir(dir, taskID, branchName, baseBranch string) (*Description, error) {
+	mergeBase, err := git.MergeBaseInDir(dir, baseBranch, branchName)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := git.CommitSubjectsInDir(dir, mergeBase, branchName)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := git.DiffStatsBetweenInDir(dir, mergeBase, branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Description{
+		TaskID:  taskID,
+		Branch:  branchName,
+		Base:    baseBranch,
+		Summary: git.ReadSummaryNoteInDir(dir, branchName),
+		Commits: commits,
+		Stats:   stats,
+	}, nil
+}
+
+// Markdown formats the description as a pull request body, with the commit
+// subjects doubling as a changelog entry.
+func (d *Description) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## %s\n\n", d.TaskID)
+	if d.Summary != "" {
+		fmt.Fprintf(&b, "%s\n\n", d.Summary)
+	}
+
+	b.WriteString("### Changes\n\n")
+	if len(d.Commits) == 0 {
+		fmt.Fprintf(&b, "No commits on %s since it diverged from %s.\n", d.Branch, d.Base)
+		return b.String()
+	}
+	for _, commit := range d.Commits {
+		hash, subject, _ := strings.Cut(commit, " ")
+		fmt.Fprintf(&b, "- %s (%s)\n", subject, hash)
+	}
+
+	added, deleted := 0, 0
+	for _, stat := range d.Stats {
+		added += stat.Added
+		deleted += stat.Deleted
+	}
+	fmt.Fprintf(&b, "\n### Files changed\n\n%d files changed, %d insertions(+), %d deletions(-)\n\n", len(d.Stats), added, deleted)
+	for _, stat := range d.Stats {
+		if stat.Binary {
+			fmt.Fprintf(&b, "- `%s` (binary)\n", stat.Path)
+		} else {
+			fmt.Fprintf(&b, "- `%s` (+%d/-%d)\n", stat.Path, stat.Added, stat.Deleted)
+		}
+	}
+
+	return b.String()
+}
+
+// WithAgent asks the agent, in print mode on the host, to write the
+// description from the generated one and the branch's diff.
+func (d *Description) WithAgent(useAmp bool) (string, error) {
+	diffCmd := exec.Command("git", "diff", "--no-color", "--no-ext-diff", d.Base+"..."+d.Branch)
+	diff, err := diffCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get diff of %s: %w", d.Branch, err)
+	}
+	if len(diff) > maxAgentDiffBytes {
+		diff = append(diff[:maxAgentDiffBytes], []byte("\n[diff truncated]\n")...)
+	}
+
+	prompt := fmt.Sprintf("Write a pull request description for the changes below, in Markdown: a one-line title, "+
+		"a short paragraph on what changed and why, and a bulleted changelog entry. Reply with only the description.\n\n"+
+		"%s\n### Diff\n\n```diff\n%s```\n", d.Markdown(), diff)
+
+	var cmd *exec.Cmd
+	if useAmp {
+		cmd = exec.Command("amp", "-x")
+	} else {
+		cmd = exec.Command("claude", "--print")
+	}
+	cmd.Stdin = strings.NewReader(prompt)
+	cmd.Stderr = os.Stderr
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("agent exited with error: %w", err)
+	}
+	return strings.TrimSpace(output.String()) + "\n", nil
+}
+
+// Title returns a title for the pull request: the subject of the only
+// commit, or the task ID if there are several.
+func (d *Description) Title() string {
+	if len(d.Commits) == 1 {
+		_, subject, _ := strings.Cut(d.Commits[0], " ")
+		return subject
+	}
+	return d.TaskID
+}
+
+// OpenPullRequest pushes the branch to origin and opens a pull request for
+// it into the base branch with the GitHub CLI, with body as its description.
+// It returns the pull request's URL.
+func (d *Description) OpenPullRequest(body string) (string, error) {
+	if _, err := exec.LookPath("gh"); err != nil {
+		return "", fmt.Errorf("the GitHub CLI (gh) is needed to open a pull request")
+	}
+	if output, err := exec.Command("git", "push", "--set-upstream", "origin", d.Branch).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to push %s to origin: %s", d.Branch, strings.TrimSpace(string(output)))
+	}
+
+	cmd := exec.Command("gh", "pr", "create", "--base", d.Base, "--head", d.Branch, "--title", d.Title(), "--body-file", "-")
+	cmd.Stdin = strings.NewReader(body)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to open pull request: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,73 @@
+package describe
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestLoadAndMarkdown(t *testing.T) {
+	t.Parallel()
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	cmd := exec.Command("sh", "-c", `git checkout -q -b giverny/task-1 &&
+		printf 'a\nb\n' > feature.txt && git add feature.txt && git commit -q -m 'Add feature' &&
+		echo 'more' >> test.txt && git commit -q -am 'Update test file' &&
+		git notes --ref=refs/notes/giverny/task-1 add -m '- Added the feature' &&
+		git checkout -q main && echo 'main' > main.txt && git add main.txt && git commit -q -m 'Unrelated main commit'`)
+	cmd.Dir = tmpDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to set up branch: %v\n%s", err, output)
+	}
+
+	d, err := LoadInDir(tmpDir, "task-1", "giverny/task-1", "main")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	md := d.Markdown()
+
+	for _, want := range []string{
+		"## task-1\n\n- Added the feature\n",
+		"- Add feature (",
+		"- Update test file (",
+		"2 files changed, 3 insertions(+), 1 deletions(-)",
+		"- `feature.txt` (+2/-0)",
+		"- `test.txt` (+1/-1)",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected description to contain %q, got:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "Unrelated main commit") || strings.Contains(md, "main.txt") {
+		t.Errorf("expected description to leave out commits on the base branch, got:\n%s", md)
+	}
+	if strings.Index(md, "Add feature") > strings.Index(md, "Update test file") {
+		t.Errorf("expected commits oldest first, got:\n%s", md)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	d := &Description{TaskID: "task-1", Commits: []string{"abc1234 Add feature"}}
+	if got := d.Title(); got != "Add feature" {
+		t.Errorf("Title() = %q, want the commit subject", got)
+	}
+	d.Commits = append(d.Commits, "def5678 Update test file")
+	if got := d.Title(); got != "task-1" {
+		t.Errorf("Title() = %q, want the task ID", got)
+	}
+}
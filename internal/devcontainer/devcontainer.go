@@ -0,0 +1,216 @@
+// Package devcontainer derives the base image for giverny-main from a
+// project's devcontainer.json, so the agent works in the same environment
+// developers use locally.
+package devcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Paths are where a devcontainer.json is looked for, relative to the
+// project root, in order
+var Paths = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// ErrNotFound is returned by Load when the project has no devcontainer.json
+var ErrNotFound = errors.New("no devcontainer.json found (looked for " + strings.Join(Paths, " and ") + ")")
+
+// Config is the part of a devcontainer.json giverny uses
+type Config struct {
+	Image string `json:"image"`
+	Build struct {
+		Dockerfile string            `json:"dockerfile"`
+		Context    string            `json:"context"`
+		Args       map[string]string `json:"args"`
+	} `json:"build"`
+	// DockerFile is the older spelling of Build.Dockerfile
+	DockerFile string                     `json:"dockerFile"`
+	Features   map[string]json.RawMessage `json:"features"`
+
+	// path is the devcontainer.json the config was read from
+	path string
+}
+
+// Load reads the devcontainer.json of the project at dir
+func Load(dir string) (*Config, error) {
+	for _, path := range Paths {
+		path = filepath.Join(dir, path)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		config, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		config.path = path
+		return config, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Parse reads a devcontainer.json, which may have comments and trailing
+// commas
+func Parse(data []byte) (*Config, error) {
+	var config Config
+	if err := json.Unmarshal(stripJSONC(data), &config); err != nil {
+		return nil, err
+	}
+	if config.Build.Dockerfile == "" {
+		config.Build.Dockerfile = config.DockerFile
+	}
+	if config.Image == "" && config.Build.Dockerfile == "" {
+		return nil, fmt.Errorf("it has neither an image nor a build.dockerfile")
+	}
+	return &config, nil
+}
+
+// FeatureNames returns the features the devcontainer adds, sorted
+func (c *Config) FeatureNames() []string {
+	var names []string
+	for name := range c.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BaseImage returns an image of the devcontainer of the project at dir to
+// base giverny-main on. If the devcontainer CLI is installed it builds the
+// image, features included. Otherwise the devcontainer's Dockerfile is built
+// with docker, or its image is used as is, and features are left out with a
+// warning.
+func BaseImage(dir string, showOutput, debug bool) (string, error) {
+	config, err := Load(dir)
+	if err != nil {
+		return "", err
+	}
+	imageName := ImageName(dir)
+
+	if _, err := exec.LookPath("devcontainer"); err == nil {
+		if debug {
+			fmt.Printf("Building %s with the devcontainer CLI\n", imageName)
+		}
+		if err := run(showOutput, "devcontainer", "build", "--workspace-folder", dir, "--config", config.path, "--image-name", imageName); err != nil {
+			return "", fmt.Errorf("failed to build devcontainer: %w", err)
+		}
+		return imageName, nil
+	}
+
+	if features := config.FeatureNames(); len(features) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: the devcontainer CLI is not installed, so these devcontainer features are left out: %s\n", strings.Join(features, ", "))
+	}
+	if config.Build.Dockerfile == "" {
+		return config.Image, nil
+	}
+
+	// Paths in devcontainer.json are relative to the file itself
+	configDir := filepath.Dir(config.path)
+	context := config.Build.Context
+	if context == "" {
+		context = "."
+	}
+	args := []string{"build", "-f", filepath.Join(configDir, config.Build.Dockerfile), "-t", imageName}
+	for _, name := range sortedKeys(config.Build.Args) {
+		args = append(args, "--build-arg", name+"="+config.Build.Args[name])
+	}
+	args = append(args, filepath.Join(configDir, context))
+	if debug {
+		fmt.Printf("Building %s from %s\n", imageName, config.Build.Dockerfile)
+	}
+	if err := run(showOutput, "docker", args...); err != nil {
+		return "", fmt.Errorf("failed to build devcontainer image: %w", err)
+	}
+	return imageName, nil
+}
+
+// invalidImageChars matches characters not allowed in an image name
+var invalidImageChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// ImageName returns the name of the devcontainer image built for the project
+// at dir, e.g. "giverny-devcontainer-myproject:latest"
+func ImageName(dir string) string {
+	name := invalidImageChars.ReplaceAllString(strings.ToLower(filepath.Base(dir)), "-")
+	return "giverny-devcontainer-" + strings.Trim(name, "-._") + ":latest"
+}
+
+func run(showOutput bool, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if showOutput {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stripJSONC removes comments and trailing commas from JSON with comments,
+// leaving strings alone
+func stripJSONC(data []byte) []byte {
+	var out []byte
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := strings.Index(string(data[i+2:]), "*/")
+			if end == -1 {
+				return out
+			}
+			i += end + 3
+		case c == '}' || c == ']':
+			// Drop a comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && strings.ContainsRune(" \t\r\n", rune(out[j])) {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package devcontainer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestParse(t *testing.T) {
+	data := `{
+	// The image developers use
+	"name": "My // project",
+	"image": "mcr.microsoft.com/devcontainers/go:1.25", /* pinned */
+	"features": {
+		"ghcr.io/devcontainers/features/node:1": {"version": "lts"},
+		"ghcr.io/devcontainers/features/docker-in-docker:2": {},
+	},
+}`
+	config, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if config.Image != "mcr.microsoft.com/devcontainers/go:1.25" {
+		t.Errorf("unexpected image %q", config.Image)
+	}
+	expected := []string{"ghcr.io/devcontainers/features/docker-in-docker:2", "ghcr.io/devcontainers/features/node:1"}
+	if got := config.FeatureNames(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("FeatureNames() = %v, want %v", got, expected)
+	}
+
+	config, err = Parse([]byte(`{"dockerFile": "Dockerfile", "build": {"args": {"VARIANT": "bookworm"}}}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if config.Build.Dockerfile != "Dockerfile" || config.Build.Args["VARIANT"] != "bookworm" {
+		t.Errorf("unexpected build %+v", config.Build)
+	}
+
+	if _, err := Parse([]byte(`{"name": "compose only", "dockerComposeFile": "compose.yml"}`)); err == nil {
+		t.Error("expected an error for a devcontainer without an image or Dockerfile")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if _, err := Load(dir); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".devcontainer.json"), []byte(`{"image": "debian:12"}`), 0644); err != nil {
+		t.Fatalf("failed to write devcontainer.json: %v", err)
+	}
+	config, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if config.Image != "debian:12" {
+		t.Errorf("unexpected image %q", config.Image)
+	}
+}
+
+func TestImageName(t *testing.T) {
+	if got, want := ImageName("/home/me/My Project"), "giverny-devcontainer-my-project:latest"; got != want {
+		t.Errorf("ImageName() = %q, want %q", got, want)
+	}
+}
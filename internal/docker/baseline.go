@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"strings"
+
+	"giverny/internal/taskid"
+)
+
+// maxHostnameLength is the longest a hostname label can be
+const maxHostnameLength = 63
+
+// baselineArgs returns the docker run flags every task's container gets
+// unless dockerArgs, the user's --docker-args, set the same thing:
+//   - --init, so processes the agent leaves behind are reaped rather than
+//     becoming zombies (turn off with --init=false)
+//   - --security-opt no-new-privileges, so nothing in the container can gain
+//     privileges through setuid binaries such as sudo (turn off with
+//     --security-opt no-new-privileges=false; left out for --privileged)
+//   - --hostname TASK-ID, so shells and tools show which task they are in
+//   - --restart no, so a container that stops isn't started again behind
+//     giverny's back
+func baselineArgs(taskID string, dockerArgs []string) []string {
+	var args []string
+	if !hasFlag(dockerArgs, "--init") {
+		args = append(args, "--init")
+	}
+	// A privileged container, as for --enable-docker dind, can do anything
+	// already, and its nested daemon may need setuid helpers
+	if !hasSecurityOpt(dockerArgs, "no-new-privileges") && !hasFlag(dockerArgs, "--privileged") {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if !hasFlag(dockerArgs, "--hostname", "-h") {
+		args = append(args, "--hostname", hostname(taskID))
+	}
+	if !hasFlag(dockerArgs, "--restart") {
+		args = append(args, "--restart", "no")
+	}
+	return args
+}
+
+// hasFlag reports whether args set any of the flags names, as "--flag",
+// "--flag=value" or, for short flags, "-fvalue"
+func hasFlag(args []string, names ...string) bool {
+	for _, arg := range args {
+		for _, name := range names {
+			if arg == name || strings.HasPrefix(arg, name+"=") ||
+				(len(name) == 2 && !strings.HasPrefix(arg, "--") && strings.HasPrefix(arg, name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasSecurityOpt reports whether args set the security option name, with
+// any value
+func hasSecurityOpt(args []string, name string) bool {
+	for i, arg := range args {
+		value, ok := strings.CutPrefix(arg, "--security-opt=")
+		if !ok && arg == "--security-opt" && i+1 < len(args) {
+			value, ok = args[i+1], true
+		}
+		if ok && (value == name || strings.HasPrefix(value, name+"=") || strings.HasPrefix(value, name+":")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostname returns the hostname of the container of the task taskID: the
+// task ID made into a valid hostname label
+func hostname(taskID string) string {
+	name := taskid.SanitizeSlug(strings.ReplaceAll(strings.ToLower(taskID), "_", "-"))
+	if len(name) > maxHostnameLength {
+		name = strings.TrimRight(name[:maxHostnameLength], "-")
+	}
+	if name == "" {
+		return "giverny"
+	}
+	return name
+}
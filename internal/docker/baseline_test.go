@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBaselineArgs(t *testing.T) {
+	got := baselineArgs("PROJ-123", nil)
+	want := []string{"--init", "--security-opt", "no-new-privileges", "--hostname", "proj-123", "--restart", "no"}
+	if !slices.Equal(got, want) {
+		t.Errorf("baselineArgs() = %q, want %q", got, want)
+	}
+
+	// Each default gives way to the user's own setting
+	tests := []struct {
+		dockerArgs []string
+		omitted    string
+	}{
+		{[]string{"--init=false"}, "--init"},
+		{[]string{"--security-opt", "no-new-privileges=false"}, "no-new-privileges"},
+		{[]string{"--security-opt=no-new-privileges:false"}, "no-new-privileges"},
+		{[]string{"--privileged", "-v", "/var/lib/docker"}, "no-new-privileges"},
+		{[]string{"--hostname", "dev"}, "--hostname"},
+		{[]string{"-hdev"}, "--hostname"},
+		{[]string{"--restart=on-failure"}, "--restart"},
+	}
+	for _, tt := range tests {
+		got := baselineArgs("task-1", tt.dockerArgs)
+		if slices.Contains(got, tt.omitted) {
+			t.Errorf("baselineArgs(%q) = %q, expected %s to be left to the user", tt.dockerArgs, got, tt.omitted)
+		}
+		if len(got) != len(want)-2 && !(tt.omitted == "--init" && len(got) == len(want)-1) {
+			t.Errorf("baselineArgs(%q) = %q, expected only %s to be left out", tt.dockerArgs, got, tt.omitted)
+		}
+	}
+
+	// Unrelated security options don't count
+	if got := baselineArgs("task-1", []string{"--security-opt", "seccomp=profile.json"}); !slices.Contains(got, "no-new-privileges") {
+		t.Errorf("expected no-new-privileges alongside other security options, got %q", got)
+	}
+}
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		taskID   string
+		expected string
+	}{
+		{"task-1", "task-1"},
+		{"PROJ-123", "proj-123"},
+		{"fix_the.bug", "fix-the-bug"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		{strings.Repeat("a", 62) + "-b", strings.Repeat("a", 62)},
+		{"...", "giverny"},
+	}
+	for _, tt := range tests {
+		if got := hostname(tt.taskID); got != tt.expected {
+			t.Errorf("hostname(%q) = %q, want %q", tt.taskID, got, tt.expected)
+		}
+	}
+}
@@ -1,127 +1,512 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"giverny/internal/cmdutil"
+	"giverny/internal/ctrlsock"
+	"giverny/internal/git"
+	"giverny/internal/shell"
+	"giverny/internal/taskid"
+	"giverny/internal/taskspec"
 	"giverny/internal/terminal"
 )
 
-// RunContainer starts the giverny-main container with Innie
-// Returns the exit code of the container
-func RunContainer(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
-	// Generate a container name based on task ID and slug
-	var containerName string
+// ContainerName returns the name of the container for a task
+func ContainerName(taskID, slug string) string {
 	if slug != "" {
-		containerName = fmt.Sprintf("giverny-%s-%s", taskID, slug)
-	} else {
-		containerName = fmt.Sprintf("giverny-%s", taskID)
+		return fmt.Sprintf("giverny-%s-%s", taskID, slug)
+	}
+	return fmt.Sprintf("giverny-%s", taskID)
+}
+
+// RunContainer starts the giverny-main container with Innie running the task
+// in spec.
+// The container's output goes through giverny as output says (see
+// containerOutput).
+// Returns the exit code of the container
+func RunContainer(spec taskspec.Spec, baseImage, dockerArgs string, output OutputOptions) (int, error) {
+	taskID, slug := spec.TaskID, spec.Slug
+	// Only ask for a TTY when we have one, so the menu can also be driven
+	// through a pipe (as the e2e tests do).
+	args := []string{"run", "-i"}
+	if StdinIsTerminal() {
+		args = append(args, "-t")
+	}
+	args, err := appendContainerArgs(args, spec, baseImage, dockerArgs)
+	if err != nil {
+		return 0, err
+	}
+
+	stdout, stderr, closeOutput, err := containerOutput(output)
+	if err != nil {
+		return 0, err
 	}
+	defer closeOutput()
+
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Stdin = os.Stdin
+
+	printContainerStarting(taskID, slug)
+
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			return 0, fmt.Errorf("failed to run container: %w", err)
+		}
+	}
+
+	// If the terminal went away, docker run exits but innie carries on in
+	// its session, so wait for the container instead
+	if info, err := InspectContainer(ContainerName(taskID, slug)); err == nil && info != nil && info.Running {
+		return waitContainer(ContainerName(taskID, slug))
+	}
+
+	return exitCode, nil
+}
+
+// RunContainerAttachable is RunContainer for a task with no terminal of its
+// own. The container gets a TTY and open stdin without anything attached to
+// them, so the agent and menu can be used later with AttachContainer.
+// It waits for the container to exit and returns its exit code.
+func RunContainerAttachable(spec taskspec.Spec, baseImage, dockerArgs string) (int, error) {
+	args, err := appendContainerArgs([]string{"run", "-d", "-i", "-t"}, spec, baseImage, dockerArgs)
+	if err != nil {
+		return 0, err
+	}
+
+	printContainerStarting(spec.TaskID, spec.Slug)
+	if err := cmdutil.RunCommand("docker", args...); err != nil {
+		return 0, fmt.Errorf("failed to run container: %w", err)
+	}
+	return waitContainer(ContainerName(spec.TaskID, spec.Slug))
+}
+
+// waitContainer waits for a container to exit and returns its exit code
+func waitContainer(containerName string) (int, error) {
+	output, err := cmdutil.RunCommandWithOutput("docker", "wait", containerName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to wait for container: %w", err)
+	}
+	exitCode, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected exit code %q from docker wait", output)
+	}
+	return exitCode, nil
+}
+
+// Where the agents' configuration on the host is mounted in the container
+const (
+	claudeConfigMount = "/root/.claude"
+	claudeJSONMount   = "/root/.claude.json"
+	ampConfigMount    = "/root/.config/amp"
+)
+
+// AgentConfigMounts are the paths in the container the agents' configuration
+// on the host is mounted at, where root's files belong to root on the host
+// unless they are given back to HostUser
+var AgentConfigMounts = []string{claudeConfigMount, claudeJSONMount, ampConfigMount}
+
+// appendContainerArgs adds the docker run arguments for a task's container,
+// after those that say how it is attached, to args.
+func appendContainerArgs(args []string, spec taskspec.Spec, baseImage, dockerArgs string) ([]string, error) {
+	taskID, useAmp := spec.TaskID, spec.UseAmp
+
+	// Generate a container name based on task ID and slug
+	containerName := ContainerName(taskID, spec.Slug)
 
 	// Get home directory for mounting config
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
 	}
 
-	// Build the docker run command
-	args := []string{
-		"run",
-		"-it",
-		"--name", containerName,
-	}
+	args = append(args, "--name", containerName)
+	args = append(args, labelArgs(taskID, containerName)...)
 
 	if useAmp {
 		// Validate AMP_API_KEY
 		if os.Getenv("AMP_API_KEY") == "" {
-			return 0, fmt.Errorf("AMP_API_KEY not set")
+			return nil, fmt.Errorf("AMP_API_KEY not set")
 		}
 		args = append(args, "--env", "AMP_API_KEY")
 
 		// Mount Amp config directory
 		ampConfigDir := filepath.Join(homeDir, ".config", "amp")
 		if _, err := os.Stat(ampConfigDir); err == nil {
-			args = append(args, "-v", fmt.Sprintf("%s:/root/.config/amp", ampConfigDir))
+			args = append(args, "-v", fmt.Sprintf("%s:%s", ampConfigDir, ampConfigMount))
 		}
 	} else {
 		// Validate CLAUDE_CODE_OAUTH_TOKEN
 		if os.Getenv("CLAUDE_CODE_OAUTH_TOKEN") == "" {
-			return 0, fmt.Errorf("CLAUDE_CODE_OAUTH_TOKEN not set")
+			return nil, fmt.Errorf("CLAUDE_CODE_OAUTH_TOKEN not set")
 		}
 		args = append(args,
 			"--env", "CLAUDE_CODE_OAUTH_TOKEN",
-			"-v", fmt.Sprintf("%s/.claude:/root/.claude", homeDir),
-			"-v", fmt.Sprintf("%s/.claude.json:/root/.claude.json", homeDir),
+			"-v", fmt.Sprintf("%s/.claude:%s", homeDir, claudeConfigMount),
+			"-v", fmt.Sprintf("%s/.claude.json:%s", homeDir, claudeJSONMount),
 		)
 	}
 
-	// Add any additional docker args
-	if dockerArgs != "" {
-		// Split dockerArgs and add them
-		additionalArgs := strings.Fields(dockerArgs)
-		args = append(args, additionalArgs...)
+	// Let shells, hooks and setup scripts in the container know which task
+	// they are in
+	branchName := taskid.BranchName(spec.BranchUser, taskID, spec.Slug)
+	args = append(args,
+		"--env", TaskIDEnv+"="+taskID,
+		"--env", BranchEnv+"="+branchName,
+		"--env", StartRefEnv+"="+git.StartLabel(branchName),
+		"--env", PromptFileEnv+"="+taskspec.PromptFile,
+	)
+
+	// Tell innie the task to run
+	encoded, err := taskspec.Encode(spec)
+	if err != nil {
+		return nil, err
 	}
+	args = append(args, "--env", taskspec.EnvVar+"="+encoded)
+
+	// Keep the task's cache, such as shell history, in a volume of its own
+	args = append(args, "-v", CacheVolume(containerName)+":"+shell.CacheDir)
+
+	// Add the security baseline, then any additional docker args, split as a
+	// shell would
+	additionalArgs, err := cmdutil.SplitArgs(dockerArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker args: %w", err)
+	}
+	args = append(args, baselineArgs(taskID, additionalArgs)...)
+	args = append(args, additionalArgs...)
 
 	// Specify the image
 	args = append(args, MainImageName(baseImage))
 
-	// Specify the command to run inside the container
-	args = append(args, "giverny", "--innie", fmt.Sprintf("--git-server-port=%d", gitPort))
+	// Specify the command to run inside the container, in a session that
+	// survives the terminal going away
+	args = append(args, "giverny-session", "giverny", "--innie")
 
-	// Add --amp flag if using Amp
-	if useAmp {
-		args = append(args, "--amp")
-	}
+	return args, nil
+}
+
+// printContainerStarting tells the user which container the task runs in
+func printContainerStarting(taskID, slug string) {
+	containerName := ContainerName(taskID, slug)
+	fmt.Printf("Starting container %s for task %s...\n", containerName, taskID)
+	fmt.Printf("To start a shell in the container, run:\n")
+	fmt.Printf("  %s\n\n", terminal.Blue(fmt.Sprintf("docker exec -it %s /bin/sh", containerName)))
+}
 
-	// Add debug flag if enabled
-	if debug {
-		args = append(args, "--debug")
+// StdinIsTerminal reports whether standard input is a terminal
+func StdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-	// Add agent args if provided
-	if agentArgs != "" {
-		args = append(args, fmt.Sprintf("--agent-args=%s", agentArgs))
+// stdoutIsTerminal reports whether standard output is a terminal
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-	// Pass slug and prompt via flags, then TASK-ID as positional argument
-	if slug != "" {
-		args = append(args, "--slug", slug)
+// SessionSocket is the dtach socket of innie's session in the container, see
+// scripts/giverny-session.sh
+const SessionSocket = "/tmp/giverny-session"
+
+// AttachContainer connects the terminal to innie in a running container until
+// the user detaches or the container exits. If innie runs in a dtach session
+// it is attached to that, otherwise to the container's TTY. Attaching puts
+// the terminal in raw mode; its settings are saved first and restored
+// afterwards, so they are not left raw if the attach is killed.
+func AttachContainer(containerName string) error {
+	saved, err := sttyOutput("-g")
+	if err != nil {
+		return fmt.Errorf("failed to save terminal settings: %w", err)
 	}
-	if prompt != "" {
-		args = append(args, "--prompt", prompt)
+	defer sttyOutput(saved)
+
+	args := []string{"attach", "--detach-keys=ctrl-p,ctrl-q", containerName}
+	detachKeys := "Ctrl-P Ctrl-Q"
+	if cmdutil.RunCommand("docker", "exec", containerName, "test", "-S", SessionSocket) == nil {
+		args = []string{"exec", "-it", containerName, "dtach", "-a", SessionSocket, "-r", "winch"}
+		detachKeys = "Ctrl-\\"
 	}
-	args = append(args, taskID)
+	fmt.Printf("Attached to %s. Detach with %s.\n", containerName, detachKeys)
+	fmt.Printf("If the menu is not shown, press Enter to show it again.\n")
 
 	cmd := exec.Command("docker", args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// docker attach exits with the container's status, or 1 on detach
+			return nil
+		}
+		return fmt.Errorf("failed to attach to container %s: %w", containerName, err)
+	}
+	return nil
+}
 
-	fmt.Printf("Starting container %s for task %s...\n", containerName, taskID)
-	fmt.Printf("To start a shell in the container, run:\n")
-	fmt.Printf("  %s\n\n", terminal.Blue(fmt.Sprintf("docker exec -it %s /bin/sh", containerName)))
+// sttyOutput runs stty on the terminal on stdin and returns its output
+func sttyOutput(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ExecInContainer runs a command interactively in a running container and
+// returns its exit code
+func ExecInContainer(containerName string, command ...string) (int, error) {
+	args := append([]string{"exec", "-it", containerName}, command...)
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
 
 	exitCode := 0
 	if err := cmd.Run(); err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
 		} else {
-			return 0, fmt.Errorf("failed to run container: %w", err)
+			return 0, fmt.Errorf("failed to exec in container %s: %w", containerName, err)
 		}
 	}
 
 	return exitCode, nil
 }
 
-// RemoveContainer removes a Docker container by name
+// RunShell starts an interactive shell in a new, throwaway container of image
+// with hostDir mounted at, and the shell started in, workDir. It uses bash if
+// the image has it. Files created in workDir are given to the host user when
+// the shell exits.
+func RunShell(image, hostDir, workDir string) error {
+	script := "command -v bash >/dev/null && exec bash || exec sh"
+	if hostUser := HostUser(); hostUser != "" {
+		script = fmt.Sprintf("if command -v bash >/dev/null; then bash; else sh; fi; status=$?; chown -R %s %s; exit $status", hostUser, cmdutil.QuoteArg(workDir))
+	}
+	cmd := exec.Command("docker", "run", "--rm", "-it", "-v", hostDir+":"+workDir, "-w", workDir,
+		"--entrypoint", "/bin/sh", image, "-c", script)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// The shell's exit status is the user's business
+			return nil
+		}
+		return fmt.Errorf("failed to run a shell in %s: %w", image, err)
+	}
+	return nil
+}
+
+// The environment variables that describe the task in its container, for
+// hooks, setup scripts and shells
+const (
+	// TaskIDEnv holds the task ID
+	TaskIDEnv = "GIVERNY_TASK_ID"
+	// BranchEnv holds the task branch
+	BranchEnv = "GIVERNY_BRANCH"
+	// StartRefEnv holds the label marking where work on the task branch
+	// started, so that `git diff $GIVERNY_START_REF` shows the task's changes
+	StartRefEnv = "GIVERNY_START_REF"
+	// PromptFileEnv holds the path of a file with the task's prompt
+	PromptFileEnv = "GIVERNY_PROMPT_FILE"
+)
+
+// CacheVolume returns the name of the cache volume of a task's container
+func CacheVolume(containerName string) string {
+	return containerName + "-cache"
+}
+
+// RemoveContainer removes a Docker container by name, and its cache volume
 func RemoveContainer(containerName string) error {
 	if err := cmdutil.RunCommand("docker", "rm", containerName); err != nil {
 		return fmt.Errorf("failed to remove container %s: %w", containerName, err)
 	}
+	// Containers from before the volume was added don't have one
+	cmdutil.RunCommand("docker", "volume", "rm", CacheVolume(containerName))
 	fmt.Printf("✓ Container removed\n")
 	return nil
 }
+
+// CopyFromContainer copies a file out of a container (running or stopped) to
+// a path on the host
+func CopyFromContainer(containerName, srcPath, dstPath string) error {
+	if err := cmdutil.RunCommand("docker", "cp", containerName+":"+srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to copy %s from container %s: %w", srcPath, containerName, err)
+	}
+	// docker cp gives the copy to the user running it, which under sudo is root
+	if user := invokingUser(); user != "" && os.Getuid() == 0 {
+		return ChownRootFiles(dstPath, user)
+	}
+	return nil
+}
+
+// SetGitServerPort tells a stopped container the port of the git server it
+// should use in place of the one it was started with, in git.ServerPortFile,
+// which innie reads when the container starts
+func SetGitServerPort(containerName string, port int) error {
+	tmpFile, err := os.CreateTemp("", "giverny-git-server-port-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	// docker cp keeps the mode, and the container's user may not be ours
+	err = tmpFile.Chmod(0644)
+	if err == nil {
+		_, err = fmt.Fprintf(tmpFile, "%d\n", port)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if output, err := cmdutil.RunCommandWithOutput("docker", "cp", tmpFile.Name(), containerName+":"+git.ServerPortFile); err != nil {
+		return fmt.Errorf("failed to tell container %s the git server port: %w\n%s", containerName, err, output)
+	}
+	return nil
+}
+
+// ReadContainerFile returns the contents of a file in a running container
+func ReadContainerFile(containerName, path string) (string, error) {
+	output, err := cmdutil.RunCommandWithOutput("docker", "exec", containerName, "cat", path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s in container %s: %w", path, containerName, err)
+	}
+	return output, nil
+}
+
+// ShowLogs prints the last tail lines of a container's output, then keeps
+// printing new output until the container stops if follow is set
+func ShowLogs(containerName string, tail int, follow bool) error {
+	args := []string{"logs", "--tail", strconv.Itoa(tail)}
+	if follow {
+		args = append(args, "--follow")
+	}
+	cmd := exec.Command("docker", append(args, containerName)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to show logs of container %s: %w", containerName, err)
+	}
+	return nil
+}
+
+// ContainerInfo describes an existing giverny container
+type ContainerInfo struct {
+	GitServerPort int    // port of the git server the container was started against
+	CtrlAddr      string // control server address from the container's environment
+	GitToken      string // token of the git server from the URL in the container's environment
+	Running       bool   // the container is running
+}
+
+// InspectContainer returns information about an existing container.
+// Returns nil if no container with that name exists.
+func InspectContainer(containerName string) (*ContainerInfo, error) {
+	cmd := exec.Command("docker", "container", "inspect", "--format", "{{json .}}", containerName)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "No such") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerName, err)
+	}
+	return parseContainerInfo(output)
+}
+
+// parseContainerInfo extracts ContainerInfo from `docker container inspect` JSON output
+func parseContainerInfo(data []byte) (*ContainerInfo, error) {
+	var inspect struct {
+		Args   []string
+		Config struct {
+			Env []string
+		}
+		State struct {
+			Running bool
+		}
+	}
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to parse container details: %w", err)
+	}
+
+	info := &ContainerInfo{Running: inspect.State.Running}
+	// Containers started before innie was given a task spec have the port on
+	// their command line
+	for _, arg := range inspect.Args {
+		if value, ok := strings.CutPrefix(arg, "--git-server-port="); ok {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid git server port %q: %w", value, err)
+			}
+			info.GitServerPort = port
+		}
+	}
+	for _, env := range inspect.Config.Env {
+		if value, ok := strings.CutPrefix(env, ctrlsock.EnvVar+"="); ok {
+			info.CtrlAddr = value
+		} else if value, ok := strings.CutPrefix(env, git.ServerURLEnv+"="); ok {
+			info.GitToken = git.ServerToken(value)
+		} else if value, ok := strings.CutPrefix(env, taskspec.EnvVar+"="); ok {
+			spec, err := taskspec.Decode(value)
+			if err != nil {
+				return nil, err
+			}
+			info.GitServerPort = spec.GitServerPort
+		}
+	}
+	return info, nil
+}
+
+// StartContainer restarts an existing, stopped container and attaches to it,
+// with its output going through giverny as for RunContainer.
+// Returns the exit code of the container
+func StartContainer(containerName string, output OutputOptions) (int, error) {
+	stdout, stderr, closeOutput, err := containerOutput(output)
+	if err != nil {
+		return 0, err
+	}
+	defer closeOutput()
+
+	cmd := exec.Command("docker", "start", "--attach", "--interactive", containerName)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Stdin = os.Stdin
+
+	fmt.Printf("Reusing container %s...\n", containerName)
+	fmt.Printf("To start a shell in the container, run:\n")
+	fmt.Printf("  %s\n\n", terminal.Blue(fmt.Sprintf("docker exec -it %s /bin/sh", containerName)))
+
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			return 0, fmt.Errorf("failed to start container: %w", err)
+		}
+	}
+
+	return exitCode, nil
+}
@@ -2,7 +2,11 @@ package docker
 
 import (
 	"os"
+	"slices"
+	"strings"
 	"testing"
+
+	"giverny/internal/taskspec"
 )
 
 func TestRunContainer_RequiresClaudeToken(t *testing.T) {
@@ -16,7 +20,7 @@ func TestRunContainer_RequiresClaudeToken(t *testing.T) {
 	}()
 
 	// Should fail without token (useAmp=false)
-	_, err := RunContainer("test-task", "", "test prompt", "alpine:latest", 9999, "", "", false, false)
+	_, err := RunContainer(taskspec.Spec{TaskID: "test-task", Prompt: "test prompt", GitServerPort: 9999}, "alpine:latest", "", OutputOptions{})
 	if err == nil {
 		t.Error("expected error when CLAUDE_CODE_OAUTH_TOKEN is not set")
 	}
@@ -36,7 +40,7 @@ func TestRunContainer_RequiresAmpToken(t *testing.T) {
 	}()
 
 	// Should fail without token (useAmp=true)
-	_, err := RunContainer("test-task", "", "test prompt", "alpine:latest", 9999, "", "", false, true)
+	_, err := RunContainer(taskspec.Spec{TaskID: "test-task", Prompt: "test prompt", GitServerPort: 9999, UseAmp: true}, "alpine:latest", "", OutputOptions{})
 	if err == nil {
 		t.Error("expected error when AMP_API_KEY is not set")
 	}
@@ -44,3 +48,124 @@ func TestRunContainer_RequiresAmpToken(t *testing.T) {
 		t.Errorf("unexpected error message: %v", err)
 	}
 }
+
+func TestParseContainerInfo(t *testing.T) {
+	data := []byte(`{
+		"Path": "giverny",
+		"Args": ["giverny", "--innie"],
+		"Config": {"Env": ["PATH=/usr/bin", "GIVERNY_CTRL_SOCK=host.docker.internal:5151", "GIVERNY_GIT_URL=git://host.docker.internal:4242/0123abcd", "GIVERNY_TASK={\"version\":1,\"task_id\":\"task-1\",\"git_server_port\":4242}"]},
+		"State": {"Running": true}
+	}`)
+
+	info, err := parseContainerInfo(data)
+	if err != nil {
+		t.Fatalf("parseContainerInfo failed: %v", err)
+	}
+	if info.GitServerPort != 4242 {
+		t.Errorf("expected git server port 4242, got %d", info.GitServerPort)
+	}
+	if info.CtrlAddr != "host.docker.internal:5151" {
+		t.Errorf("expected control address host.docker.internal:5151, got %q", info.CtrlAddr)
+	}
+	if info.GitToken != "0123abcd" {
+		t.Errorf("expected git token 0123abcd, got %q", info.GitToken)
+	}
+	if !info.Running {
+		t.Error("expected container to be running")
+	}
+
+	// A container not started by giverny has no git server port
+	info, err = parseContainerInfo([]byte(`{"Args": ["-c", "sleep 1"], "Config": {"Env": []}}`))
+	if err != nil {
+		t.Fatalf("parseContainerInfo failed: %v", err)
+	}
+	if info.GitServerPort != 0 || info.CtrlAddr != "" || info.GitToken != "" || info.Running {
+		t.Errorf("expected empty info, got %+v", info)
+	}
+
+	// A container started by an older giverny has the port on its command line
+	info, err = parseContainerInfo([]byte(`{"Args": ["--innie", "--git-server-port=4242", "task-1"]}`))
+	if err != nil {
+		t.Fatalf("parseContainerInfo failed: %v", err)
+	}
+	if info.GitServerPort != 4242 {
+		t.Errorf("expected git server port 4242 from the arguments, got %d", info.GitServerPort)
+	}
+
+	if _, err := parseContainerInfo([]byte(`{"Args": ["--git-server-port=abc"]}`)); err == nil {
+		t.Error("expected error for invalid git server port")
+	}
+	if _, err := parseContainerInfo([]byte(`{"Config": {"Env": ["GIVERNY_TASK={"]}}`)); err == nil {
+		t.Error("expected error for an invalid task spec")
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	if got := ContainerName("task-1", ""); got != "giverny-task-1" {
+		t.Errorf("ContainerName without slug = %q, want %q", got, "giverny-task-1")
+	}
+	if got := ContainerName("task-1", "fix-bug"); got != "giverny-task-1-fix-bug" {
+		t.Errorf("ContainerName with slug = %q, want %q", got, "giverny-task-1-fix-bug")
+	}
+}
+
+func TestAppendContainerArgs(t *testing.T) {
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	spec := taskspec.Spec{TaskID: "task-1", Slug: "fix-bug", Prompt: "Fix it", GitServerPort: 4242, BaseBranch: "main"}
+	args, err := appendContainerArgs([]string{"run", "-d"}, spec, "alpine:latest", "--cpus 2")
+	if err != nil {
+		t.Fatalf("appendContainerArgs failed: %v", err)
+	}
+	got := strings.Join(args, " ")
+
+	if !strings.HasPrefix(got, "run -d --name giverny-task-1-fix-bug ") {
+		t.Errorf("expected attach arguments and name first, got %q", got)
+	}
+	if !strings.HasSuffix(got, "--cpus 2 "+MainImageName("alpine:latest")+" giverny-session giverny --innie") {
+		t.Errorf("expected docker args, image and innie command last, got %q", got)
+	}
+	for _, env := range []string{"GIVERNY_TASK_ID=task-1", "GIVERNY_BRANCH=giverny/task-1-fix-bug", "GIVERNY_START_REF=giverny/task-1-fix-bug-START", "GIVERNY_PROMPT_FILE=/etc/giverny-prompt"} {
+		if !strings.Contains(got, " --env "+env+" ") {
+			t.Errorf("expected %s in the environment, got %q", env, got)
+		}
+	}
+	if !strings.Contains(got, " -v giverny-task-1-fix-bug-cache:/var/cache/giverny ") {
+		t.Errorf("expected the cache volume to be mounted, got %q", got)
+	}
+
+	env := slices.IndexFunc(args, func(arg string) bool {
+		return strings.HasPrefix(arg, taskspec.EnvVar+"=")
+	})
+	if env < 1 || args[env-1] != "--env" {
+		t.Fatalf("expected the task spec in the environment, got %q", got)
+	}
+	passed, err := taskspec.Decode(strings.TrimPrefix(args[env], taskspec.EnvVar+"="))
+	if err != nil {
+		t.Fatalf("failed to decode the task spec: %v", err)
+	}
+	if passed.TaskID != "task-1" || passed.Slug != "fix-bug" || passed.Prompt != "Fix it" || passed.GitServerPort != 4242 || passed.BaseBranch != "main" {
+		t.Errorf("expected the task spec to be passed unchanged, got %+v", passed)
+	}
+}
+
+func TestAppendContainerArgs_QuotedDockerArgs(t *testing.T) {
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	spec := taskspec.Spec{TaskID: "task-1", Prompt: "Fix it", GitServerPort: 4242}
+	args, err := appendContainerArgs([]string{"run"}, spec, "alpine:latest", `-e GREETING="hello world" -v '/my data:/data'`)
+	if err != nil {
+		t.Fatalf("appendContainerArgs failed: %v", err)
+	}
+	image := slices.Index(args, MainImageName("alpine:latest"))
+	if image < 4 {
+		t.Fatalf("expected the docker args before the image, got %q", args)
+	}
+	if got, want := args[image-4:image], []string{"-e", "GREETING=hello world", "-v", "/my data:/data"}; !slices.Equal(got, want) {
+		t.Errorf("expected quoted docker args to be kept whole, got %q, want %q", got, want)
+	}
+
+	if _, err := appendContainerArgs([]string{"run"}, spec, "alpine:latest", `-e GREETING="hello`); err == nil || !strings.Contains(err.Error(), "invalid docker args") {
+		t.Errorf("expected an error for an unterminated quote, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dockerSockets are where Docker daemons listen on Linux hosts. Mounting
+// one gives the container control of the host's daemon.
+var dockerSockets = []string{hostDockerSocket, "/run/docker.sock"}
+
+// hostNamespaceFlags are the docker run flags that can share one of the
+// host's namespaces with the container, by being set to "host"
+var hostNamespaceFlags = map[string]string{
+	"--network":  "network",
+	"--net":      "network",
+	"--pid":      "process",
+	"--ipc":      "IPC",
+	"--uts":      "UTS",
+	"--userns":   "user",
+	"--cgroupns": "cgroup",
+}
+
+// DangerousDockerArgs returns why the docker run arguments args would
+// defeat the container's sandbox, one reason per argument, or nothing if
+// they wouldn't: running privileged, sharing the host's namespaces,
+// mounting the host's root or Docker socket, adding all capabilities or
+// CAP_SYS_ADMIN, or turning off seccomp or AppArmor.
+func DangerousDockerArgs(args []string) []string {
+	var reasons []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		// Short flags can have their value attached, e.g. -v/:/host
+		if len(name) > 2 && name[0] == '-' && name[1] != '-' {
+			name, value, hasValue = name[:2], arg[2:], true
+		}
+		// The value of a flag that takes one
+		next := func() string {
+			if hasValue {
+				return value
+			}
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+
+		if namespace, ok := hostNamespaceFlags[name]; ok {
+			if next() == "host" {
+				reasons = append(reasons, name+" host shares the host's "+namespace+" namespace with the container")
+			}
+			continue
+		}
+		switch name {
+		case "--privileged":
+			// docker parses the value as a bool, and refuses to run with
+			// one it can't parse, so anything but false counts
+			if privileged, err := strconv.ParseBool(value); !hasValue || err != nil || privileged {
+				reasons = append(reasons, "--privileged gives the container full access to the host's devices and kernel")
+			}
+		case "-v", "--volume":
+			source, _, _ := strings.Cut(next(), ":")
+			if reason := dangerousMount(source); reason != "" {
+				reasons = append(reasons, name+" "+reason)
+			}
+		case "--mount":
+			for _, field := range strings.Split(next(), ",") {
+				key, source, _ := strings.Cut(field, "=")
+				if key == "source" || key == "src" {
+					if reason := dangerousMount(source); reason != "" {
+						reasons = append(reasons, name+" "+reason)
+					}
+				}
+			}
+		case "--cap-add":
+			switch capability := strings.TrimPrefix(strings.ToUpper(next()), "CAP_"); capability {
+			case "ALL", "SYS_ADMIN":
+				reasons = append(reasons, "--cap-add "+capability+" lets the container escape its sandbox")
+			}
+		case "--security-opt":
+			switch option := strings.Replace(next(), ":", "=", 1); option {
+			case "seccomp=unconfined", "apparmor=unconfined", "label=disable":
+				reasons = append(reasons, "--security-opt "+option+" turns off the container's confinement")
+			}
+		}
+	}
+	return reasons
+}
+
+// dangerousMount says why mounting source from the host would defeat the
+// sandbox, or returns "" if it wouldn't. Symlinks in source are resolved, and
+// a directory holding a Docker socket, such as /var/run, is as dangerous as
+// the socket itself.
+func dangerousMount(source string) string {
+	if !strings.HasPrefix(source, "/") {
+		return "" // a named volume
+	}
+	sources := []string{path.Clean(source), resolvePath(source)}
+	for _, source := range sources {
+		if source == "/" {
+			return "mounts the host's root filesystem"
+		}
+	}
+	for _, socket := range dockerSockets {
+		for _, socket := range []string{socket, resolvePath(socket)} {
+			for _, source := range sources {
+				if socket == source || strings.HasPrefix(socket, source+"/") {
+					return "mounts the host's Docker socket, which controls the host's Docker daemon (use --enable-docker socket if that's what you want)"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// resolvePath returns p, cleaned, with the symlinks in it resolved, even
+// those pointing at paths that don't exist on this host, such as a Docker
+// socket only in the Docker VM
+func resolvePath(p string) string {
+	return resolvePathDepth(filepath.Clean(p), 0)
+}
+
+// maxSymlinks is how many symlinks resolvePath follows before giving up, as
+// the kernel does, so loops end
+const maxSymlinks = 40
+
+func resolvePathDepth(p string, depth int) string {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p
+	}
+	p = filepath.Join(resolvePathDepth(parent, depth), filepath.Base(p))
+	target, err := os.Readlink(p)
+	if err != nil || depth >= maxSymlinks {
+		return p
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(p), target)
+	}
+	return resolvePathDepth(filepath.Clean(target), depth+1)
+}
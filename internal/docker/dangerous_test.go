@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDangerousDockerArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string // part of the reason, or "" if the args are safe
+	}{
+		{nil, ""},
+		{[]string{"--cpus", "2", "-e", "FOO=bar", "-v", "/home/me/data:/data"}, ""},
+		{[]string{"-v", "cache:/root/.cache", "--network", "bridge", "--privileged=false"}, ""},
+		{[]string{"--mount", "type=bind,source=/home/me,target=/me"}, ""},
+		{[]string{"--cap-add", "NET_ADMIN", "--security-opt", "no-new-privileges"}, ""},
+		{[]string{"--privileged"}, "--privileged"},
+		{[]string{"--privileged=true"}, "--privileged"},
+		{[]string{"--privileged=1"}, "--privileged"},
+		{[]string{"--privileged=t"}, "--privileged"},
+		{[]string{"--privileged=T"}, "--privileged"},
+		{[]string{"--privileged=TRUE"}, "--privileged"},
+		{[]string{"--privileged=True"}, "--privileged"},
+		{[]string{"--privileged=yes"}, "--privileged"},
+		{[]string{"--privileged=0"}, ""},
+		{[]string{"--network", "host"}, "network namespace"},
+		{[]string{"--net=host"}, "network namespace"},
+		{[]string{"--pid", "host"}, "process namespace"},
+		{[]string{"--userns=host"}, "user namespace"},
+		{[]string{"-v", "/:/host"}, "root filesystem"},
+		{[]string{"-v/:/host:ro"}, "root filesystem"},
+		{[]string{"--volume=//:/host"}, "root filesystem"},
+		{[]string{"-v", "/var/run/docker.sock:/var/run/docker.sock"}, "Docker socket"},
+		{[]string{"-v", "/run/docker.sock:/var/run/docker.sock"}, "Docker socket"},
+		{[]string{"--mount", "type=bind,src=/var/run/docker.sock,dst=/var/run/docker.sock"}, "Docker socket"},
+		{[]string{"--mount=type=bind,source=/,target=/host"}, "root filesystem"},
+		{[]string{"-v", "/var/run:/var/run"}, "Docker socket"},
+		{[]string{"-v", "/run/:/host-run"}, "Docker socket"},
+		{[]string{"--mount", "type=bind,source=/var,target=/host-var"}, "Docker socket"},
+		{[]string{"-v", "/var/lib/app:/data"}, ""},
+		{[]string{"--cap-add", "ALL"}, "--cap-add ALL"},
+		{[]string{"--cap-add=cap_sys_admin"}, "--cap-add SYS_ADMIN"},
+		{[]string{"--security-opt", "seccomp=unconfined"}, "seccomp=unconfined"},
+		{[]string{"--security-opt", "apparmor:unconfined"}, "apparmor=unconfined"},
+	}
+	for _, tt := range tests {
+		reasons := DangerousDockerArgs(tt.args)
+		if tt.expected == "" {
+			if len(reasons) != 0 {
+				t.Errorf("DangerousDockerArgs(%q) = %q, expected the args to be safe", tt.args, reasons)
+			}
+			continue
+		}
+		if len(reasons) != 1 || !strings.Contains(reasons[0], tt.expected) {
+			t.Errorf("DangerousDockerArgs(%q) = %q, expected one reason mentioning %q", tt.args, reasons, tt.expected)
+		}
+	}
+
+	// Every dangerous argument is reported
+	if reasons := DangerousDockerArgs([]string{"--privileged", "-e", "A=1", "--network", "host"}); len(reasons) != 2 {
+		t.Errorf("expected two reasons, got %q", reasons)
+	}
+}
+
+func TestDangerousMountResolvesSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	for name, target := range map[string]string{"root": "/", "run": "/var/run", "sock": "/var/run/docker.sock", "data": dir, "loop": "loop"} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"root", "root filesystem"},
+		{"run", "Docker socket"},
+		{"sock", "Docker socket"},
+		{"data", ""},
+		{"loop", ""},
+	}
+	for _, tt := range tests {
+		if reason := dangerousMount(filepath.Join(dir, tt.source)); !strings.Contains(reason, tt.expected) || (tt.expected == "" && reason != "") {
+			t.Errorf("dangerousMount(%s) = %q, expected %q", tt.source, reason, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// NoDiskCheckEnv turns off the disk space check before image builds when
+// set to 1, for when the estimate is wrong
+const NoDiskCheckEnv = "GIVERNY_NO_DISK_CHECK"
+
+// Rough amounts of disk an image build uses on top of the base image,
+// including the build cache
+const (
+	// unknownBaseSize stands in for a base image that still has to be pulled
+	unknownBaseSize int64 = 1 << 30
+	// depsBuildSize is the Go toolchain and the builds of giverny and
+	// diffreviewer in giverny-deps
+	depsBuildSize int64 = 2 << 30
+	// beadsBuildSize is the Rust toolchain and build of beads_rust
+	beadsBuildSize int64 = 2 << 30
+	// mainLayersSize is what giverny-main adds to the base image: Node.js,
+	// Claude Code and Amp, and the tools copied from giverny-deps
+	mainLayersSize int64 = 1 << 30
+	// nixSize and dockerSize are Nix and the static Docker binaries
+	nixSize    int64 = 1 << 30
+	dockerSize int64 = 512 << 20
+)
+
+// EstimateBuildSize returns roughly how much disk building the images with
+// opts on a base image of baseImageSize bytes takes, counting the base image
+// too if it has to be pulled (baseImageSize 0)
+func EstimateBuildSize(baseImageSize int64, opts BuildOptions) int64 {
+	size := baseImageSize
+	if size == 0 {
+		size = unknownBaseSize
+	}
+	size += depsBuildSize + mainLayersSize
+	if !opts.NoBeads {
+		size += beadsBuildSize
+	}
+	if opts.Nix {
+		size += nixSize
+	}
+	if opts.Docker != "" {
+		size += dockerSize
+	}
+	return size
+}
+
+// checkDiskSpace fails if Docker's data root has less free space than
+// building the images is estimated to need. It says nothing if the free
+// space can't be found out, as with a daemon in a VM or on another machine.
+func checkDiskSpace(baseImage string, opts BuildOptions, debug bool) error {
+	if os.Getenv(NoDiskCheckEnv) == "1" {
+		return nil
+	}
+	dataRoot, available, err := dockerFreeSpace()
+	if err != nil {
+		if debug {
+			fmt.Printf("Not checking disk space: %v\n", err)
+		}
+		return nil
+	}
+	baseImageSize, _ := imageSize(baseImage)
+	needed := EstimateBuildSize(baseImageSize, opts)
+	if debug {
+		fmt.Printf("Image build needs about %s, %s available in %s\n", FormatSize(needed), FormatSize(available), dataRoot)
+	}
+	if available < needed {
+		return fmt.Errorf("not enough disk space to build the image: it needs about %s, and Docker's data root %s has %s free.\n"+
+			"Free some with 'docker system prune' or 'docker builder prune', or move Docker's data-root to a larger disk.\n"+
+			"Set %s=1 to build anyway", FormatSize(needed), dataRoot, FormatSize(available), NoDiskCheckEnv)
+	}
+	return nil
+}
+
+// dockerFreeSpace returns Docker's data root and the space free on its
+// filesystem, if the daemon runs on this machine
+func dockerFreeSpace() (string, int64, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return "", 0, fmt.Errorf("the Docker daemon is remote (%s)", host)
+	}
+	output, err := exec.Command("docker", "info", "--format", "{{.DockerRootDir}}").Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to find Docker's data root: %w", err)
+	}
+	dataRoot := strings.TrimSpace(string(output))
+	available, err := freeSpace(dataRoot)
+	if err != nil {
+		// Docker Desktop and the like keep it in a VM
+		return "", 0, fmt.Errorf("%s is not on this machine: %w", dataRoot, err)
+	}
+	return dataRoot, available, nil
+}
+
+// imageSize returns the size of a local image in bytes, or an error if it
+// isn't present
+func imageSize(image string) (int64, error) {
+	output, err := exec.Command("docker", "image", "inspect", "--format", "{{.Size}}", image).Output()
+	if err != nil {
+		return 0, fmt.Errorf("image %s not found", image)
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+}
+
+// FormatSize formats a number of bytes for people, e.g. "1.5 GB"
+func FormatSize(bytes int64) string {
+	const unit = 1 << 10
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEstimateBuildSize(t *testing.T) {
+	base := EstimateBuildSize(0, BuildOptions{NoBeads: true})
+	if base != unknownBaseSize+depsBuildSize+mainLayersSize {
+		t.Errorf("expected an unpulled base image to be counted, got %d", base)
+	}
+	if got := EstimateBuildSize(100<<20, BuildOptions{NoBeads: true}); got != base-unknownBaseSize+100<<20 {
+		t.Errorf("expected the base image's size to be used, got %d", got)
+	}
+
+	all := EstimateBuildSize(0, BuildOptions{Nix: true, Docker: DockerModeDinD})
+	if all != base+beadsBuildSize+nixSize+dockerSize {
+		t.Errorf("expected beads, Nix and Docker to be counted, got %d", all)
+	}
+}
+
+func TestCheckDiskSpace_Disabled(t *testing.T) {
+	t.Setenv(NoDiskCheckEnv, "1")
+	// Would fail if it ran docker without Docker
+	t.Setenv("PATH", "")
+	if err := checkDiskSpace("ubuntu:24.04", BuildOptions{}, false); err != nil {
+		t.Errorf("expected no check, got %v", err)
+	}
+}
+
+func TestCheckDiskSpace_RemoteDaemon(t *testing.T) {
+	t.Setenv(NoDiskCheckEnv, "")
+	t.Setenv("DOCKER_HOST", "tcp://build.example.com:2376")
+	if err := checkDiskSpace("ubuntu:24.04", BuildOptions{}, false); err != nil {
+		t.Errorf("expected the check to be skipped, got %v", err)
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	available, err := freeSpace(os.TempDir())
+	if err != nil {
+		t.Skipf("free space not supported: %v", err)
+	}
+	if available <= 0 {
+		t.Errorf("expected some free space in %s, got %d", os.TempDir(), available)
+	}
+	if _, err := freeSpace("/nonexistent/giverny"); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{512, "512 B"},
+		{1536, "1.5 KB"},
+		{5 << 30, "5.0 GB"},
+		{3 << 40, "3.0 TB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.bytes); got != tt.expected {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.bytes, got, tt.expected)
+		}
+	}
+}
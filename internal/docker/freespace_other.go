@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package docker
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// freeSpace is not supported on this platform
+func freeSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("checking free space is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// NoHostBuildEnv is the environment variable that, when set, makes giverny
+// build its binary in the giverny-deps image even when Go is installed
+const NoHostBuildEnv = "GIVERNY_NO_HOST_BUILD"
+
+// HostBinDir is the directory of the binaries built on the host in the
+// build context
+const HostBinDir = "giverny-bin"
+
+// hostBuildArch returns the architecture to cross-compile giverny for on the
+// host, that of the Docker daemon, or "" if it can't be built on the host:
+// Go isn't installed, the daemon doesn't run Linux containers, or the host
+// build is turned off with NoHostBuildEnv.
+func hostBuildArch(debug bool) string {
+	if os.Getenv(NoHostBuildEnv) != "" {
+		return ""
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		if debug {
+			fmt.Println("Go isn't installed, giverny will be built in Docker")
+		}
+		return ""
+	}
+	output, err := exec.Command("docker", "version", "--format", "{{.Server.Os}}/{{.Server.Arch}}").Output()
+	if err != nil {
+		return ""
+	}
+	goos, arch, _ := strings.Cut(strings.TrimSpace(string(output)), "/")
+	if goos != "linux" || arch == "" {
+		return ""
+	}
+	return arch
+}
+
+// buildHostBinaries cross-compiles giverny, and fakeclaude if testAgent is
+// set, from sourceDir for Linux on arch, into outDir. They are statically
+// linked, so they run on any base image.
+func buildHostBinaries(sourceDir, outDir, arch string, testAgent bool, debug bool) error {
+	packages := []string{"giverny"}
+	if testAgent {
+		packages = append(packages, "fakeclaude")
+	}
+	for _, name := range packages {
+		if debug {
+			fmt.Printf("Building %s for linux/%s on the host...\n", name, arch)
+		}
+		cmd := exec.Command("go", "build", "-trimpath", "-o", filepath.Join(outDir, name), "./cmd/"+name)
+		cmd.Dir = sourceDir
+		// The extracted source isn't in a workspace, even if the cache
+		// directory is under one
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+arch, "CGO_ENABLED=0", "GOWORK=off")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to build %s on the host: %w\n%s", name, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
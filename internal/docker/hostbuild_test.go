@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"giverny"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDockerfileHostBinaries(t *testing.T) {
+	tmpDir := t.TempDir()
+	depsPath := filepath.Join(tmpDir, "Dockerfile.deps")
+	mainPath := filepath.Join(tmpDir, "Dockerfile.main")
+	data := DockerfileData{BaseImage: "debian:12", HostBinaries: true, TestAgent: true}
+	if _, err := generateDockerfiles(depsPath, mainPath, data, nil); err != nil {
+		t.Fatalf("generateDockerfiles failed: %v", err)
+	}
+	deps, err := os.ReadFile(depsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, err := os.ReadFile(mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// giverny isn't built in Docker, but the tools still are
+	for _, unexpected := range []string{"AS builder", "--from=builder", "/output/giverny"} {
+		if strings.Contains(string(deps), unexpected) {
+			t.Errorf("Dockerfile.deps should not contain %q when giverny is built on the host", unexpected)
+		}
+	}
+	for _, expected := range []string{"AS diffreviewer-builder", "AS beads-builder"} {
+		if !strings.Contains(string(deps), expected) {
+			t.Errorf("Dockerfile.deps missing %q", expected)
+		}
+	}
+
+	// The binaries come from the build files instead
+	for _, expected := range []string{
+		"COPY --from=" + buildFilesContext + " " + HostBinDir + "/giverny /usr/local/bin/giverny",
+		"COPY --from=" + buildFilesContext + " " + HostBinDir + "/fakeclaude /usr/local/bin/claude",
+	} {
+		if !strings.Contains(string(main), expected) {
+			t.Errorf("Dockerfile.main missing %q", expected)
+		}
+	}
+	if strings.Contains(string(main), "giverny-deps:latest /output/giverny") {
+		t.Error("Dockerfile.main should not copy giverny from giverny-deps when it is built on the host")
+	}
+
+	// Building on the host or in Docker makes a different image
+	hostHash, _ := generateDockerfiles(depsPath, mainPath, data, nil)
+	data.HostBinaries = false
+	dockerHash, _ := generateDockerfiles(depsPath, mainPath, data, nil)
+	if hostHash == dockerHash {
+		t.Error("expected the Dockerfile hash to depend on where giverny is built")
+	}
+
+	// Another giverny version makes a different image
+	data.SourceHash = "1111"
+	oldHash, _ := generateDockerfiles(depsPath, mainPath, data, nil)
+	data.SourceHash = "2222"
+	newHash, _ := generateDockerfiles(depsPath, mainPath, data, nil)
+	if oldHash == newHash {
+		t.Error("expected the Dockerfile hash to depend on the giverny source")
+	}
+}
+
+func TestHostBuildArch_Disabled(t *testing.T) {
+	t.Setenv(NoHostBuildEnv, "1")
+	if arch := hostBuildArch(false); arch != "" {
+		t.Errorf("expected no host build with %s set, got %q", NoHostBuildEnv, arch)
+	}
+}
+
+func TestBuildHostBinaries(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	sourceDir, err := extractSourceTo(giverny.Source, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	outDir := filepath.Join(t.TempDir(), HostBinDir)
+	if err := buildHostBinaries(sourceDir, outDir, "amd64", true, false); err != nil {
+		t.Fatalf("buildHostBinaries failed: %v", err)
+	}
+	for _, name := range []string{"giverny", "fakeclaude"} {
+		content, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("expected %s to be built: %v", name, err)
+		}
+		if !strings.HasPrefix(string(content), "\x7fELF") {
+			t.Errorf("expected %s to be a Linux binary", name)
+		}
+	}
+
+	// A build that fails says why
+	if err := buildHostBinaries(t.TempDir(), outDir, "amd64", false, false); err == nil || !strings.Contains(err.Error(), "failed to build giverny on the host") {
+		t.Errorf("expected a build error, got %v", err)
+	}
+}
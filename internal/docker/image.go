@@ -1,13 +1,17 @@
 package docker
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"text/template"
 	"time"
@@ -17,37 +21,107 @@ import (
 // given base image. We embed the base image name so that runs against
 // different base images don't collide on a single shared "giverny-main:latest"
 // tag. e.g. "alpine:latest" -> "alpine-giverny-main:latest",
-// "gcr.io/foo/bar:dev" -> "gcr.io-foo-bar-giverny-main:dev".
+// "gcr.io/foo/bar:dev" -> "gcr.io-foo-bar-giverny-main:dev". A base image
+// pinned by digest is tagged with the start of the digest instead, e.g.
+// "alpine@sha256:0123456789abcdef..." -> "alpine-giverny-main:sha256-0123456789ab".
 func MainImageName(baseImage string) string {
 	name, tag := baseImage, "latest"
+	digest := ""
+	if i := strings.Index(name, "@"); i != -1 {
+		name, digest = name[:i], strings.Replace(name[i+1:], ":", "-", 1)
+	}
 	// Split on the last colon to separate tag (avoid splitting registry ports
 	// like "registry:5000/foo"; if there's a slash after the colon it's a
 	// port, not a tag).
-	if i := strings.LastIndex(baseImage, ":"); i != -1 && !strings.Contains(baseImage[i:], "/") {
-		name, tag = baseImage[:i], baseImage[i+1:]
+	if i := strings.LastIndex(name, ":"); i != -1 && !strings.Contains(name[i:], "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if digest != "" {
+		tag = digest[:min(len(digest), len("sha256-")+12)]
 	}
 	name = strings.ReplaceAll(name, "/", "-")
+	if TestAgentEnabled() {
+		// Keep test images, which have no real agent, away from the real ones
+		return fmt.Sprintf("%s-giverny-main-test:%s", name, tag)
+	}
 	return fmt.Sprintf("%s-giverny-main:%s", name, tag)
 }
 
+// TestAgentEnabled reports whether GIV_TEST=1 is set, in which case the image
+// is built with fakeclaude installed as the claude binary instead of Claude
+// Code and Amp. This is used by the end-to-end tests.
+func TestAgentEnabled() bool {
+	return os.Getenv("GIV_TEST") == "1"
+}
+
 // EmbeddedSource holds the embedded source code for building the image.
 // This is set by the main package which has access to the module root.
 var EmbeddedSource embed.FS
 
-// DiffreviewerVersion specifies the version of diffreviewer to install
+// DiffreviewerVersion specifies the version of diffreviewer to install by default
 const DiffreviewerVersion = "v0.2.3"
 
-// BeadsRustVersion specifies the version of beads_rust to install
+// BeadsRustVersion specifies the version of beads_rust to install by default
 const BeadsRustVersion = "v0.1.14"
 
+// NixVersion is the release of Nix installed in images built with --nix
+const NixVersion = "2.24.9"
+
+// MiseVersion is the release of mise installed in images built with --mise
+const MiseVersion = "v2025.1.0"
+
+// DockerfileHashLabel is the label on giverny-main images holding a hash of
+// the Dockerfiles they were built from
+const DockerfileHashLabel = "giverny.dockerfile-hash"
+
+// Labels on giverny-main images holding the versions of the tools built
+// into them
+const (
+	DiffreviewerVersionLabel = "giverny.diffreviewer-version"
+	BeadsVersionLabel        = "giverny.beads-version"
+)
+
 // ImageMaxAge is the maximum age of a Docker image before it should be rebuilt
 const ImageMaxAge = 24 * time.Hour
 
+// CACertFile is the name of the extra CA certificates in the build context
+const CACertFile = "giverny-ca.crt"
+
+// DotfilesDir is the directory of the user's dotfiles in the build context
+const DotfilesDir = "giverny-dotfiles"
+
+// buildFilesContext is the name of the build context holding the files
+// generated for a build, the CA certificates and dotfiles. The main build
+// context is the cached embedded source, which is the same for every build.
+const buildFilesContext = "giverny-build-files"
+
+// caCertStep trusts the extra CA certificates, if any, in a build stage. They
+// are appended to the system bundle directly, so they work before
+// ca-certificates tooling is installed, and installed for the tooling too.
+const caCertStep = `{{if .CACert}}
+# Trust the extra CA certificates, e.g. a corporate proxy's
+COPY --from=` + buildFilesContext + ` ` + CACertFile + ` /usr/local/share/ca-certificates/` + CACertFile + `
+RUN mkdir -p /etc/ssl/certs && \
+    cat /usr/local/share/ca-certificates/` + CACertFile + ` >> /etc/ssl/certs/ca-certificates.crt && \
+    (! command -v update-ca-certificates >/dev/null 2>&1 || update-ca-certificates >/dev/null 2>&1 || true) && \
+    (! test -d /etc/pki/ca-trust/source/anchors || \
+        (cp /usr/local/share/ca-certificates/` + CACertFile + ` /etc/pki/ca-trust/source/anchors/ && update-ca-trust))
+ENV NODE_EXTRA_CA_CERTS=/usr/local/share/ca-certificates/` + CACertFile + `
+{{end}}`
+
+// goPrivateStep tells go which modules to fetch directly from their private
+// repositories, in stages that build Go
+const goPrivateStep = `{{if .GoPrivate}}
+ENV GOPRIVATE={{.GoPrivate}}
+{{end}}`
+
 const dockerfileDepsTemplate = `# Multi-stage build for Giverny dependencies
-# This builds the giverny binary, diffreviewer, and beads_rust
+# This builds the giverny binary and the optional tools
+{{- if not .HostBinaries}}
 
 # Stage 1: Build giverny binary
 FROM golang:alpine AS builder
+` + caCertStep + goPrivateStep + `
 
 # Install build dependencies
 RUN apk add --no-cache git make
@@ -59,13 +133,23 @@ WORKDIR /build
 COPY . .
 
 # Build the binary
-RUN mkdir -p /output && make build && ln ./bin/giverny /output/giverny
+RUN {{.SecretMounts}}mkdir -p /output && make build && ln ./bin/giverny /output/giverny
 
 # Verify the binary was created
 RUN test -f /output/giverny && chmod +x /output/giverny
 
+{{- if .TestAgent}}
+
+# Build the fake agent used by the end-to-end tests
+RUN go build -o /output/fakeclaude ./cmd/fakeclaude
+{{- end}}
+{{- end}}
+
+{{- if not .NoDiffreviewer}}
+
 # Stage 2: Build diffreviewer
 FROM golang:alpine AS diffreviewer-builder
+` + caCertStep + goPrivateStep + `
 
 # Install build dependencies
 RUN apk add --no-cache git curl nodejs npm make
@@ -73,51 +157,82 @@ RUN apk add --no-cache git curl nodejs npm make
 # Set working directory
 WORKDIR /build
 
-# Download and extract diffreviewer source
-RUN curl -L https://api.github.com/repos/hughe/diffreviewer/tarball/{{.DiffreviewerVersion}} -o diffreviewer.tar.gz && \
+# Download, verify and extract diffreviewer source
+RUN curl -fL https://api.github.com/repos/hughe/diffreviewer/tarball/{{.DiffreviewerVersion}} -o diffreviewer.tar.gz && \
+{{- if .Diffreviewer.SHA256}}
+    echo "{{.Diffreviewer.SHA256}}  diffreviewer.tar.gz" | sha256sum -c - && \
+{{- end}}
     mkdir -p diffreviewer && \
     tar -xzf diffreviewer.tar.gz -C diffreviewer --strip-components=1
 
 # Build diffreviewer using Makefile
 WORKDIR /build/diffreviewer
-RUN make && \
+RUN {{.SecretMounts}}make && \
     mkdir -p /output && \
     ln bin/diffreviewer /output/diffreviewer
 
 # Verify the binary was created
 RUN test -f /output/diffreviewer
+{{- end}}
+{{- if not .NoBeads}}
 
 # Stage 3: Build beads_rust (br)
 FROM rust:alpine AS beads-builder
+` + caCertStep + `
 
 # Install build dependencies
-RUN apk add --no-cache git musl-dev
+RUN apk add --no-cache git musl-dev curl
 
-# Install beads_rust
-RUN cargo install --git https://github.com/Dicklesworthstone/beads_rust.git --tag {{.BeadsRustVersion}} && \
+# Download, verify and install beads_rust
+WORKDIR /build
+RUN curl -fL https://api.github.com/repos/Dicklesworthstone/beads_rust/tarball/{{.BeadsRustVersion}} -o beads_rust.tar.gz && \
+{{- if .Beads.SHA256}}
+    echo "{{.Beads.SHA256}}  beads_rust.tar.gz" | sha256sum -c - && \
+{{- end}}
+    mkdir -p beads_rust && \
+    tar -xzf beads_rust.tar.gz -C beads_rust --strip-components=1 && \
+    cargo install --path beads_rust && \
     mkdir -p /output && \
     cp $(which br) /output/br
 
 # Verify the binary was created
 RUN test -f /output/br
+{{- end}}
 
 # Stage 4: Collect all binaries in a single stage
 FROM alpine:latest
 
 # Copy all binaries
+{{- if not .HostBinaries}}
 COPY --from=builder /output/giverny /output/giverny
+{{- if .TestAgent}}
+COPY --from=builder /output/fakeclaude /output/fakeclaude
+{{- end}}
+{{- end}}
+{{- if not .NoDiffreviewer}}
 COPY --from=diffreviewer-builder /output/diffreviewer /output/diffreviewer
+{{- end}}
+{{- if not .NoBeads}}
 COPY --from=beads-builder /output/br /output/br
+{{- end}}
 
 # Verify all binaries are present
-RUN test -f /output/giverny && \
-    test -f /output/diffreviewer && \
+RUN {{if .HostBinaries}}true{{else}}test -f /output/giverny{{end}}
+{{- if not .NoDiffreviewer}} && \
+    test -f /output/diffreviewer
+{{- end}}
+{{- if not .NoBeads}} && \
     test -f /output/br
+{{- end}}
 `
 
 const dockerfileMainTemplate = `# Final Giverny image with dependencies from giverny-deps
 FROM {{.BaseImage}}
 
+# Base images such as devcontainer images may switch to an unprivileged user
+USER root
+` + caCertStep + `
+
 # Install git and curl if not present
 RUN command -v git >/dev/null 2>&1 || \
     (apt-get update && apt-get install -y git) || \
@@ -136,12 +251,88 @@ RUN command -v rg >/dev/null 2>&1 || \
     (yum install -y ripgrep) || \
     echo "Warning: ripgrep not available in package manager"
 
+# Install bash if not present, for line editing and history in shells started
+# from the menu
+RUN command -v bash >/dev/null 2>&1 || \
+    (apt-get update && apt-get install -y bash) || \
+    (apk add --no-cache bash) || \
+    (yum install -y bash) || \
+    echo "Warning: bash not available in package manager"
+
+# Install dtach if not present, so innie keeps running if the terminal goes away
+RUN command -v dtach >/dev/null 2>&1 || \
+    (apt-get update && apt-get install -y dtach) || \
+    (apk add --no-cache dtach) || \
+    (yum install -y dtach) || \
+    echo "Warning: dtach not available in package manager"
+
 # Install node and npm if not present (still needed for Amp)
 RUN command -v node >/dev/null 2>&1 || \
     (apt-get update && apt-get install -y nodejs npm) || \
     (apk add --no-cache nodejs npm) || \
     (yum install -y nodejs npm)
 
+{{if .Nix}}
+# Install Nix (single user, as root) with flakes enabled, using the pinned
+# release's installer once it matches the checksum published with it; the
+# installer verifies the Nix tarball it downloads in turn
+ENV USER=root
+RUN (command -v xz >/dev/null 2>&1 || \
+        (apt-get update && apt-get install -y xz-utils) || \
+        (apk add --no-cache xz) || \
+        (yum install -y xz)) && \
+    mkdir -p /etc/nix && \
+    printf 'build-users-group =\nexperimental-features = nix-command flakes\n' > /etc/nix/nix.conf && \
+    curl -fsSL https://releases.nixos.org/nix/nix-{{.NixVersion}}/install -o /tmp/nix-install && \
+    echo "$(curl -fsSL https://releases.nixos.org/nix/nix-{{.NixVersion}}/install.sha256)  /tmp/nix-install" | sha256sum -c - && \
+    sh /tmp/nix-install --no-daemon && \
+    rm /tmp/nix-install
+ENV PATH="/root/.nix-profile/bin:${PATH}"
+RUN nix --version
+{{end}}
+
+{{if .Mise}}
+# Install mise, which installs the runtimes projects pin: the pinned
+# release's static binary, once it matches the checksum published with it
+RUN case "$(uname -m)" in x86_64) arch=x64 ;; aarch64) arch=arm64 ;; *) arch=$(uname -m) ;; esac && \
+    file=mise-{{.MiseVersion}}-linux-$arch-musl.tar.gz && \
+    curl -fsSL https://github.com/jdx/mise/releases/download/{{.MiseVersion}}/$file -o /tmp/$file && \
+    sum=$(curl -fsSL https://github.com/jdx/mise/releases/download/{{.MiseVersion}}/SHASUMS256.txt | grep "$file\$" | cut -d' ' -f1) && \
+    test -n "$sum" && \
+    echo "$sum  /tmp/$file" | sha256sum -c - && \
+    tar -xzf /tmp/$file -C /tmp && \
+    mv /tmp/mise/bin/mise /usr/local/bin/mise && \
+    rm -rf /tmp/$file /tmp/mise && \
+    mise --version
+{{end}}
+{{- if .Docker}}
+
+# Install Docker for tasks that build or run containers: the static binaries,
+# with the daemon too to run it nested in the container
+RUN curl -fsSL https://download.docker.com/linux/static/stable/$(uname -m)/docker-{{.DockerVersion}}.tgz | tar -xz -C /tmp && \
+{{- if eq .Docker "dind"}}
+    mv /tmp/docker/* /usr/local/bin/ && \
+{{- else}}
+    mv /tmp/docker/docker /usr/local/bin/docker && \
+{{- end}}
+    rm -rf /tmp/docker && \
+    docker --version
+{{- if eq .Docker "dind"}}
+RUN command -v iptables >/dev/null 2>&1 || \
+    (apt-get update && apt-get install -y iptables) || \
+    (apk add --no-cache iptables) || \
+    (yum install -y iptables)
+{{- end}}
+{{- end}}
+
+{{if .TestAgent}}
+# Install fakeclaude in place of Claude Code for end-to-end tests
+{{- if .HostBinaries}}
+COPY --from=` + buildFilesContext + ` ` + HostBinDir + `/fakeclaude /usr/local/bin/claude
+{{- else}}
+COPY --from=giverny-deps:latest /output/fakeclaude /usr/local/bin/claude
+{{- end}}
+{{else}}
 # Install Claude Code using official installer.
 # The installer drops the binary in ~/.local/bin; add that to PATH.
 RUN curl -fsSL https://claude.ai/install.sh | bash
@@ -149,17 +340,67 @@ ENV PATH="/root/.local/bin:${PATH}"
 RUN claude --version
 
 # Install Amp
-RUN npm install -g @sourcegraph/amp@latest
+RUN {{.SecretMounts}}npm install -g @sourcegraph/amp@latest
+{{end}}
 
-# Copy binaries from giverny-deps image
+# Copy binaries from giverny-deps image, or built on the host
+{{- if .HostBinaries}}
+COPY --from=` + buildFilesContext + ` ` + HostBinDir + `/giverny /usr/local/bin/giverny
+{{- else}}
 COPY --from=giverny-deps:latest /output/giverny /usr/local/bin/giverny
+{{- end}}
+{{- if not .NoBeads}}
 COPY --from=giverny-deps:latest /output/br /usr/local/bin/br
+{{- end}}
+{{- if not .NoDiffreviewer}}
 
 # Install diffreviewer: real binary in /usr/local/lib/giverny, wrapper in PATH
 RUN mkdir -p /usr/local/lib/giverny
 COPY --from=giverny-deps:latest /output/diffreviewer /usr/local/lib/giverny/diffreviewer
 COPY scripts/diffreviewer-wrapper.sh /usr/local/bin/diffreviewer
 RUN chmod +x /usr/local/bin/diffreviewer
+{{- end}}
+
+# Install the wrapper that runs innie in a dtach session
+COPY scripts/giverny-session.sh /usr/local/bin/giverny-session
+RUN chmod +x /usr/local/bin/giverny-session
+
+# Show the task's context in interactive shells, docker exec ones included
+COPY scripts/giverny-profile.sh /etc/profile.d/giverny.sh
+RUN for rc in /etc/bash.bashrc /etc/bash/bashrc /etc/bashrc /etc/zsh/zshrc /etc/zshrc; do \
+        if [ -f "$rc" ]; then echo '. /etc/profile.d/giverny.sh' >> "$rc"; fi; \
+    done
+ENV ENV=/etc/profile.d/giverny.sh
+{{- if .Dotfiles}}
+
+# Install the user's dotfiles, for shells started from the menu
+COPY --from=` + buildFilesContext + ` ` + DotfilesDir + `/ /root/
+{{- else}}
+
+# Install giverny's shell profile, for shells started from the menu
+COPY scripts/giverny-shellrc.sh /etc/giverny/shellrc
+RUN ln -sf shellrc /etc/giverny/.zshrc
+{{- end}}
+{{- if .Packages}}
+
+# Install the extra packages
+RUN if command -v apt-get >/dev/null 2>&1; then \
+        apt-get update && apt-get install -y {{.PackageList}}; \
+    elif command -v apk >/dev/null 2>&1; then \
+        apk add --no-cache {{.PackageList}}; \
+    elif command -v yum >/dev/null 2>&1; then \
+        yum install -y {{.PackageList}}; \
+    else \
+        echo "No supported package manager to install {{.PackageList}}" && exit 1; \
+    fi
+{{- end}}
+{{- if .RunSteps}}
+
+# Extra steps
+{{- range .RunSteps}}
+RUN {{.}}
+{{- end}}
+{{- end}}
 
 # Set working directory
 WORKDIR /app
@@ -169,6 +410,12 @@ type DockerfileData struct {
 	BaseImage           string
 	DiffreviewerVersion string
 	BeadsRustVersion    string
+	TestAgent           bool // install fakeclaude instead of the real agents
+	HostBinaries        bool // giverny was cross-compiled on the host
+	// SourceHash is the hash of the giverny source the binary in the image
+	// is built from, which isn't in the Dockerfiles but must rebuild it
+	SourceHash string
+	BuildOptions
 }
 
 // getImageAge returns the age of a Docker image, or an error if the image doesn't exist
@@ -192,35 +439,253 @@ func getImageAge(imageName string) (time.Duration, error) {
 	return time.Since(created), nil
 }
 
+// ImageDigest returns the repository digest of a local image (e.g.
+// "ubuntu@sha256:..."), or its image ID if it was built locally and has no
+// repository digest.
+func ImageDigest(imageName string) (string, error) {
+	cmd := exec.Command("docker", "image", "inspect", "--format", "{{if .RepoDigests}}{{index .RepoDigests 0}}{{else}}{{.Id}}{{end}}", imageName)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", imageName, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// BuildOptions are optional extras installed in the giverny-main image. The
+// zero value installs none.
+type BuildOptions struct {
+	// Nix installs Nix with flakes enabled, for running the agent in a
+	// project's `nix develop` shell
+	Nix bool `json:"nix,omitempty"`
+	// Mise installs mise, for installing the runtimes a project pins in
+	// .tool-versions or mise.toml
+	Mise bool `json:"mise,omitempty"`
+
+	// CACert is a PEM file of extra CA certificates to trust in the builds
+	// and the image, e.g. for a corporate proxy that intercepts TLS
+	CACert string `json:"ca_cert,omitempty"`
+	// Proxy is an HTTP(S) proxy URL used by the builds, see ProxyEnv. It
+	// doesn't change the image, so it isn't recorded.
+	Proxy string `json:"-"`
+
+	// Netrc and Npmrc are credential files for private Go module and npm
+	// registries. They are mounted as BuildKit secrets in the steps that
+	// download modules and packages, so they are never stored in the image.
+	Netrc string `json:"-"`
+	Npmrc string `json:"-"`
+	// GoPrivate is GOPRIVATE for the Go builds
+	GoPrivate string `json:"goprivate,omitempty"`
+
+	// Diffreviewer and Beads choose the versions of the tools built into the
+	// image, and the checksums their source must have
+	Diffreviewer ToolVersion `json:"diffreviewer,omitempty"`
+	Beads        ToolVersion `json:"beads,omitempty"`
+	// NoDiffreviewer and NoBeads leave those tools out of the image
+	NoDiffreviewer bool `json:"no_diffreviewer,omitempty"`
+	NoBeads        bool `json:"no_beads,omitempty"`
+
+	// Docker installs Docker for a DockerModeSocket or DockerModeDinD task
+	Docker string `json:"docker,omitempty"`
+
+	// Dotfiles is a directory of the user's dotfiles to install in the
+	// image's home directory in place of giverny's shell profile
+	Dotfiles string `json:"dotfiles,omitempty"`
+
+	// Packages are extra packages to install in the image with its package
+	// manager (apt-get, apk or yum), e.g. "jq" or "make"
+	Packages []string `json:"packages,omitempty"`
+	// RunSteps are extra shell commands run, each in a RUN step, at the end
+	// of the giverny-main build
+	RunSteps []string `json:"run_steps,omitempty"`
+}
+
+// packagePattern matches the package names, with an optional version, that
+// can be used in a Dockerfile, e.g. "ripgrep" or "jq=1.6-2"
+var packagePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+:~=-]*$`)
+
+// ValidateExtras checks that the extra packages are well formed and the
+// extra steps are single commands
+func (o BuildOptions) ValidateExtras() error {
+	for _, pkg := range o.Packages {
+		if !packagePattern.MatchString(pkg) {
+			return fmt.Errorf("invalid package name %q", pkg)
+		}
+	}
+	for _, step := range o.RunSteps {
+		if strings.TrimSpace(step) == "" {
+			return fmt.Errorf("empty step")
+		}
+		if strings.ContainsAny(step, "\r\n") {
+			return fmt.Errorf("step %q spans several lines; join them with && instead", step)
+		}
+		if strings.HasSuffix(strings.TrimSpace(step), "\\") {
+			return fmt.Errorf("step %q ends with a line continuation", step)
+		}
+	}
+	return nil
+}
+
+// DockerVersion returns the version of Docker to install, for the
+// Dockerfile templates
+func (o BuildOptions) DockerVersion() string {
+	return DockerVersion
+}
+
+// NixVersion returns the version of Nix to install, for the Dockerfile
+// templates
+func (o BuildOptions) NixVersion() string {
+	return NixVersion
+}
+
+// MiseVersion returns the version of mise to install, for the Dockerfile
+// templates
+func (o BuildOptions) MiseVersion() string {
+	return MiseVersion
+}
+
+// PackageList returns the extra packages separated by spaces, for the
+// Dockerfile templates
+func (o BuildOptions) PackageList() string {
+	return strings.Join(o.Packages, " ")
+}
+
+// The optional tools built into the image
+const (
+	ToolDiffreviewer = "diffreviewer"
+	ToolBeads        = "beads"
+)
+
+// Tools are the optional tools that can be built into the image
+var Tools = []string{ToolDiffreviewer, ToolBeads}
+
+// WithToolsEnv is the environment variable holding the default for
+// --with-tools, a comma-separated list of Tools
+const WithToolsEnv = "GIVERNY_WITH_TOOLS"
+
+// DefaultTools returns the tools to build into the image when none are
+// chosen: those listed in $GIVERNY_WITH_TOOLS if it is set, otherwise all
+// of them
+func DefaultTools() []string {
+	value, ok := os.LookupEnv(WithToolsEnv)
+	if !ok {
+		return Tools
+	}
+	var tools []string
+	for _, tool := range strings.Split(value, ",") {
+		if tool = strings.TrimSpace(tool); tool != "" {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// CheckTools returns an error if any of tools is not one of Tools
+func CheckTools(tools []string) error {
+	for _, tool := range tools {
+		if !slices.Contains(Tools, tool) {
+			return fmt.Errorf("unknown tool %q (expected one of %s)", tool, strings.Join(Tools, ", "))
+		}
+	}
+	return nil
+}
+
+// ToolVersion is a version of a tool built into the image
+type ToolVersion struct {
+	// Version is a release tag; empty uses the default
+	Version string `json:"version,omitempty"`
+	// SHA256 is the checksum the source tarball must have, or empty to not
+	// verify it
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// toolVersionPattern matches the versions that can be used in a Dockerfile
+var toolVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// sha256Pattern matches a SHA-256 checksum in hex
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Validate checks that the version and checksum are well formed
+func (v ToolVersion) Validate() error {
+	if v.Version != "" && !toolVersionPattern.MatchString(v.Version) {
+		return fmt.Errorf("invalid version %q", v.Version)
+	}
+	if v.SHA256 != "" && !sha256Pattern.MatchString(v.SHA256) {
+		return fmt.Errorf("invalid SHA-256 checksum %q (expected 64 lowercase hex digits)", v.SHA256)
+	}
+	return nil
+}
+
+// versionOr returns the version, or def if it is empty
+func (v ToolVersion) versionOr(def string) string {
+	if v.Version == "" {
+		return def
+	}
+	return v.Version
+}
+
+// toolVersions returns the versions of diffreviewer and beads_rust to build
+// into the image, empty for a tool left out
+func (o BuildOptions) toolVersions() (diffreviewer, beads string) {
+	if !o.NoDiffreviewer {
+		diffreviewer = o.Diffreviewer.versionOr(DiffreviewerVersion)
+	}
+	if !o.NoBeads {
+		beads = o.Beads.versionOr(BeadsRustVersion)
+	}
+	return diffreviewer, beads
+}
+
+// buildSecret is a BuildKit secret: the file it comes from, and where it is
+// mounted in the steps that need it
+type buildSecret struct {
+	id, src, target string
+}
+
+// secrets returns the credential files to provide to the builds
+func (o BuildOptions) secrets() []buildSecret {
+	var secrets []buildSecret
+	if o.Netrc != "" {
+		secrets = append(secrets, buildSecret{"netrc", o.Netrc, "/root/.netrc"})
+	}
+	if o.Npmrc != "" {
+		secrets = append(secrets, buildSecret{"npmrc", o.Npmrc, "/root/.npmrc"})
+	}
+	return secrets
+}
+
+// SecretMounts returns the RUN flags that mount the credential files, for
+// the Dockerfile templates
+func (o BuildOptions) SecretMounts() string {
+	var mounts string
+	for _, secret := range o.secrets() {
+		mounts += fmt.Sprintf("--mount=type=secret,id=%s,target=%s ", secret.id, secret.target)
+	}
+	return mounts
+}
+
+// secretArgs returns the docker build flags that provide the credential files
+func (o BuildOptions) secretArgs() []string {
+	var args []string
+	for _, secret := range o.secrets() {
+		args = append(args, "--secret", fmt.Sprintf("id=%s,src=%s", secret.id, secret.src))
+	}
+	return args
+}
+
 // BuildImage builds the giverny Docker images using two separate Dockerfiles.
 // First it builds giverny-deps with all the dependencies (giverny binary, diffreviewer, beads_rust).
 // Then it builds giverny-main which uses the deps image and adds the base image components.
-// It creates a temporary directory, extracts embedded source code,
-// generates both Dockerfiles, builds both images, optionally streams output
-// to stdout based on showOutput, and cleans up.
+// It generates both Dockerfiles in a temporary directory, extracts the
+// embedded source code to a cache directory (see cachedEmbeddedSource) which
+// is the build context, builds both images, optionally streams output to
+// stdout based on showOutput, and cleans up.
 //
-// If giverny-main:latest exists and is less than 24 hours old, the build is skipped
-// unless forceRebuild is true.
-func BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+// If giverny-main:latest exists, is less than 24 hours old and was built from
+// the same Dockerfiles (so with the same giverny version and options), the
+// build is skipped unless forceRebuild is true.
+func BuildImage(baseImage string, opts BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 	mainImage := MainImageName(baseImage)
-	// Check if giverny-main image exists and is fresh enough
-	if !forceRebuild {
-		if age, err := getImageAge(mainImage); err == nil {
-			if age < ImageMaxAge {
-				if debug {
-					fmt.Printf("Using existing %s image (age: %s)\n", mainImage, age.Round(time.Minute))
-				}
-				return nil
-			}
-			if debug {
-				fmt.Printf("Rebuilding %s image (age: %s, max: %s)\n", mainImage, age.Round(time.Minute), ImageMaxAge)
-			}
-		} else if debug {
-			fmt.Printf("Building %s image (no existing image found)\n", mainImage)
-		}
-	} else if debug {
-		fmt.Printf("Force rebuilding %s image\n", mainImage)
-	}
+
 	// Create temporary directory
 	tmpDir, err := os.MkdirTemp("", "giverny-build-*")
 	if err != nil {
@@ -228,70 +693,144 @@ func BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Extract embedded source code to temp directory
-	if err := extractEmbeddedSource(tmpDir); err != nil {
-		return fmt.Errorf("failed to extract embedded source: %w", err)
-	}
+	// Build giverny on the host if we can, rather than in giverny-deps
+	hostArch := hostBuildArch(debug)
 
-	// Build giverny-deps image first
-	if debug {
-		fmt.Println("Building giverny-deps image...")
+	// Copy the files the Dockerfiles use
+	var copiedFiles []string
+	if opts.CACert != "" {
+		caCert, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		caCertPath := filepath.Join(tmpDir, CACertFile)
+		if err := os.WriteFile(caCertPath, caCert, 0644); err != nil {
+			return fmt.Errorf("failed to copy CA certificates: %w", err)
+		}
+		copiedFiles = append(copiedFiles, caCertPath)
+	}
+	if opts.Dotfiles != "" {
+		dotfiles, err := copyDotfiles(opts.Dotfiles, filepath.Join(tmpDir, DotfilesDir))
+		if err != nil {
+			return fmt.Errorf("failed to copy dotfiles: %w", err)
+		}
+		copiedFiles = append(copiedFiles, dotfiles...)
 	}
 
-	// Generate Dockerfile.deps
+	// Generate Dockerfile.deps and Dockerfile.main
 	dockerfileDepsPath := filepath.Join(tmpDir, "Dockerfile.deps")
-	depsData := DockerfileData{
+	dockerfileMainPath := filepath.Join(tmpDir, "Dockerfile.main")
+	diffreviewerVersion, beadsVersion := opts.toolVersions()
+	givernySourceHash, err := sourceHash(EmbeddedSource)
+	if err != nil {
+		return fmt.Errorf("failed to hash embedded source: %w", err)
+	}
+	mainData := DockerfileData{
 		BaseImage:           baseImage,
-		DiffreviewerVersion: DiffreviewerVersion,
-		BeadsRustVersion:    BeadsRustVersion,
+		DiffreviewerVersion: diffreviewerVersion,
+		BeadsRustVersion:    beadsVersion,
+		TestAgent:           TestAgentEnabled(),
+		HostBinaries:        hostArch != "",
+		SourceHash:          givernySourceHash,
+		BuildOptions:        opts,
 	}
-	if err := generateDockerfile(dockerfileDepsPath, dockerfileDepsTemplate, depsData); err != nil {
-		return fmt.Errorf("failed to generate Dockerfile.deps: %w", err)
+	dockerfileHash, err := generateDockerfiles(dockerfileDepsPath, dockerfileMainPath, mainData, copiedFiles)
+	if err != nil {
+		return err
 	}
 
-	// Build giverny-deps image
-	depsBuildCmd := exec.Command("docker", "build",
-		"-f", dockerfileDepsPath,
-		"-t", "giverny-deps:latest",
-		tmpDir,
-	)
-
-	// Conditionally stream output to stdout/stderr
-	if showOutput {
-		depsBuildCmd.Stdout = os.Stdout
-		depsBuildCmd.Stderr = os.Stderr
+	// Check if giverny-main image exists and is fresh enough
+	if !forceRebuild {
+		if age, err := getImageAge(mainImage); err != nil {
+			if debug {
+				fmt.Printf("Building %s image (no existing image found)\n", mainImage)
+			}
+		} else if imageLabel(mainImage, DockerfileHashLabel) != dockerfileHash {
+			if debug {
+				fmt.Printf("Rebuilding %s image (built from different Dockerfiles)\n", mainImage)
+			}
+		} else if age < ImageMaxAge {
+			if debug {
+				fmt.Printf("Using existing %s image (age: %s)\n", mainImage, age.Round(time.Minute))
+			}
+			return nil
+		} else if debug {
+			fmt.Printf("Rebuilding %s image (age: %s, max: %s)\n", mainImage, age.Round(time.Minute), ImageMaxAge)
+		}
+	} else if debug {
+		fmt.Printf("Force rebuilding %s image\n", mainImage)
 	}
 
-	if err := depsBuildCmd.Run(); err != nil {
-		return fmt.Errorf("docker build failed for giverny-deps: %w", err)
+	// Fail now rather than with "no space left on device" halfway through
+	if err := checkDiskSpace(baseImage, opts, debug); err != nil {
+		return err
 	}
 
-	if debug {
-		fmt.Println("Successfully built giverny-deps:latest")
+	// The embedded source is only extracted when giverny changes
+	sourceDir, err := cachedEmbeddedSource(EmbeddedSource, debug)
+	if err != nil {
+		return fmt.Errorf("failed to extract embedded source: %w", err)
 	}
 
-	// Build giverny-main image
-	if debug {
-		fmt.Println("Building giverny-main image...")
+	if mainData.HostBinaries {
+		if err := buildHostBinaries(sourceDir, filepath.Join(tmpDir, HostBinDir), hostArch, mainData.TestAgent, debug); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; building it in Docker instead\n", err)
+			mainData.HostBinaries = false
+			if dockerfileHash, err = generateDockerfiles(dockerfileDepsPath, dockerfileMainPath, mainData, copiedFiles); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Generate Dockerfile.main
-	dockerfileMainPath := filepath.Join(tmpDir, "Dockerfile.main")
-	mainData := DockerfileData{
-		BaseImage:           baseImage,
-		DiffreviewerVersion: DiffreviewerVersion,
-		BeadsRustVersion:    BeadsRustVersion,
+	// The proxy variables are predefined build args, so they need no ARG.
+	// Credentials are passed as secrets rather than build args, which would
+	// be recorded in the image history.
+	var buildArgs []string
+	for _, env := range ProxyEnv(opts.Proxy) {
+		buildArgs = append(buildArgs, "--build-arg", env)
 	}
-	if err := generateDockerfile(dockerfileMainPath, dockerfileMainTemplate, mainData); err != nil {
-		return fmt.Errorf("failed to generate Dockerfile.main: %w", err)
+	buildArgs = append(buildArgs, opts.secretArgs()...)
+	buildArgs = append(buildArgs, "--build-context", buildFilesContext+"="+tmpDir)
+
+	// Build giverny-deps image first, unless giverny was built on the host
+	// and there are no tools to build in it
+	if !mainData.HostBinaries || !opts.NoDiffreviewer || !opts.NoBeads {
+		if debug {
+			fmt.Println("Building giverny-deps image...")
+		}
+		depsBuildCmd := exec.Command("docker", append([]string{"build",
+			"-f", dockerfileDepsPath,
+			"-t", "giverny-deps:latest",
+			sourceDir,
+		}, buildArgs...)...)
+
+		// Conditionally stream output to stdout/stderr
+		if showOutput {
+			depsBuildCmd.Stdout = os.Stdout
+			depsBuildCmd.Stderr = os.Stderr
+		}
+
+		if err := depsBuildCmd.Run(); err != nil {
+			return fmt.Errorf("docker build failed for giverny-deps: %w", err)
+		}
+
+		if debug {
+			fmt.Println("Successfully built giverny-deps:latest")
+		}
 	}
 
-	// Build giverny-main image
-	mainBuildCmd := exec.Command("docker", "build",
+	// Build giverny-main image, labelled with how it was built
+	if debug {
+		fmt.Println("Building giverny-main image...")
+	}
+	mainBuildCmd := exec.Command("docker", append([]string{"build",
 		"-f", dockerfileMainPath,
 		"-t", mainImage,
-		tmpDir,
-	)
+		"--label", DockerfileHashLabel + "=" + dockerfileHash,
+		"--label", DiffreviewerVersionLabel + "=" + mainData.DiffreviewerVersion,
+		"--label", BeadsVersionLabel + "=" + mainData.BeadsRustVersion,
+		sourceDir,
+	}, buildArgs...)...)
 
 	// Conditionally stream output to stdout/stderr
 	if showOutput {
@@ -309,9 +848,131 @@ func BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool
 	return nil
 }
 
-// extractEmbeddedSource extracts all embedded source files to the target directory.
-func extractEmbeddedSource(targetDir string) error {
-	return fs.WalkDir(EmbeddedSource, ".", func(path string, d fs.DirEntry, err error) error {
+// imageLabel returns the value of a label on a local image, or "" if it has
+// none
+func imageLabel(imageName, label string) string {
+	output, err := exec.Command("docker", "image", "inspect", "--format", fmt.Sprintf("{{index .Config.Labels %q}}", label), imageName).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSpace(string(output)), "<no value>")
+}
+
+// generateDockerfiles writes Dockerfile.deps and Dockerfile.main to depsPath
+// and mainPath, and returns the hash of them, the copied files they use and
+// the giverny source, which the image is labelled with. Without the source an
+// image built by another giverny version would be reused.
+func generateDockerfiles(depsPath, mainPath string, data DockerfileData, copiedFiles []string) (string, error) {
+	if err := generateDockerfile(depsPath, dockerfileDepsTemplate, data); err != nil {
+		return "", fmt.Errorf("failed to generate Dockerfile.deps: %w", err)
+	}
+	if err := generateDockerfile(mainPath, dockerfileMainTemplate, data); err != nil {
+		return "", fmt.Errorf("failed to generate Dockerfile.main: %w", err)
+	}
+	filesHash, err := hashFiles(append([]string{depsPath, mainPath}, copiedFiles...)...)
+	if err != nil {
+		return "", err
+	}
+	if data.SourceHash == "" {
+		return filesHash, nil
+	}
+	sum := sha256.Sum256([]byte(filesHash + "\x00" + data.SourceHash))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashFiles returns the SHA-256 of the contents of the files, in order
+func hashFiles(paths ...string) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ImageInfo describes how a local giverny-main image was built
+type ImageInfo struct {
+	ID                string
+	DockerfileHash    string // empty for images built before it was recorded
+	ClaudeCodeVersion string // empty if it could not be found
+
+	// The versions of the tools built into the image; empty for images
+	// built before they were recorded
+	DiffreviewerVersion string
+	BeadsVersion        string
+}
+
+// InspectImage returns how a local giverny-main image was built. Finding the
+// Claude Code version runs a container from the image the first time an
+// image is inspected; it is cached by image ID after that.
+func InspectImage(imageName string) (*ImageInfo, error) {
+	format := "{{.Id}}"
+	for _, label := range []string{DockerfileHashLabel, DiffreviewerVersionLabel, BeadsVersionLabel} {
+		format += fmt.Sprintf("\t{{index .Config.Labels %q}}", label)
+	}
+	output, err := exec.Command("docker", "image", "inspect", "--format", format, imageName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", imageName, err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "\t")
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSuffix(fields[i], "<no value>")
+	}
+	info := &ImageInfo{ID: fields[0], DockerfileHash: fields[1], DiffreviewerVersion: fields[2], BeadsVersion: fields[3]}
+
+	info.ClaudeCodeVersion = cachedClaudeCodeVersion(info.ID, func() string {
+		version, err := exec.Command("docker", "run", "--rm", "--entrypoint", "claude", imageName, "--version").Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(version))
+	})
+	return info, nil
+}
+
+// cachedClaudeCodeVersion returns the Claude Code version of the image with
+// ID imageID, which find gets from the image itself if it isn't cached under
+// the user cache directory yet
+func cachedClaudeCodeVersion(imageID string, find func() string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return find()
+	}
+	return claudeCodeVersionIn(filepath.Join(cacheDir, "giverny", "claude-versions"), imageID, find)
+}
+
+// claudeCodeVersionIn is cachedClaudeCodeVersion with the cache in dir. An
+// image's contents never change, so its version is cached for good; a
+// version that could not be found is not cached.
+func claudeCodeVersionIn(dir, imageID string, find func() string) string {
+	path := filepath.Join(dir, strings.ReplaceAll(imageID, ":", "-"))
+	if version, err := os.ReadFile(path); err == nil {
+		return string(version)
+	}
+	version := find()
+	if version == "" || imageID == "" {
+		return version
+	}
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		_ = os.WriteFile(path, []byte(version), 0644)
+	}
+	return version
+}
+
+// ImageExists reports whether an image (a name or an ID) is present locally
+func ImageExists(image string) bool {
+	return exec.Command("docker", "image", "inspect", image).Run() == nil
+}
+
+// extractEmbeddedSource extracts all the files in src to the target directory.
+func extractEmbeddedSource(src fs.FS, targetDir string) error {
+	return fs.WalkDir(src, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -330,7 +991,7 @@ func extractEmbeddedSource(targetDir string) error {
 		}
 
 		// Read embedded file
-		content, err := EmbeddedSource.ReadFile(path)
+		content, err := fs.ReadFile(src, path)
 		if err != nil {
 			return fmt.Errorf("failed to read embedded file %s: %w", path, err)
 		}
@@ -344,6 +1005,46 @@ func extractEmbeddedSource(targetDir string) error {
 	})
 }
 
+// copyDotfiles copies the dotfiles in srcDir to dstDir, leaving out a .git
+// directory, and returns the paths of the copies
+func copyDotfiles(srcDir, dstDir string) ([]string, error) {
+	var copies []string
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstDir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		// Symlinks are copied as the files they point to
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, info.Mode().Perm()); err != nil {
+			return err
+		}
+		copies = append(copies, target)
+		return nil
+	})
+	return copies, err
+}
+
 // generateDockerfile creates a Dockerfile from a template
 func generateDockerfile(path string, templateStr string, data interface{}) error {
 	tmpl, err := template.New("dockerfile").Parse(templateStr)
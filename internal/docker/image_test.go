@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -51,7 +52,8 @@ func TestGenerateDockerfile(t *testing.T) {
 			"FROM rust:alpine AS beads-builder",
 			"apk add --no-cache git curl nodejs npm make",
 			"RUN make",
-			"cargo install --git https://github.com/Dicklesworthstone/beads_rust.git",
+			"https://api.github.com/repos/Dicklesworthstone/beads_rust/tarball/",
+			"cargo install --path beads_rust",
 			"FROM alpine:latest",
 			"COPY --from=builder /output/giverny /output/giverny",
 			"COPY --from=diffreviewer-builder /output/diffreviewer /output/diffreviewer",
@@ -64,6 +66,23 @@ func TestGenerateDockerfile(t *testing.T) {
 				t.Errorf("Dockerfile.deps missing expected content: %s", expected)
 			}
 		}
+		if strings.Contains(contentStr, "fakeclaude") {
+			t.Error("Dockerfile.deps builds fakeclaude without the test agent")
+		}
+
+		data.TestAgent = true
+		if err := generateDockerfile(dockerfilePath, dockerfileDepsTemplate, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err = os.ReadFile(dockerfilePath)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile.deps: %v", err)
+		}
+		for _, expected := range []string{"RUN go build -o /output/fakeclaude ./cmd/fakeclaude", "COPY --from=builder /output/fakeclaude /output/fakeclaude"} {
+			if !strings.Contains(string(content), expected) {
+				t.Errorf("Dockerfile.deps for the test agent missing expected content: %s", expected)
+			}
+		}
 	})
 
 	t.Run("main dockerfile", func(t *testing.T) {
@@ -154,6 +173,364 @@ func TestGenerateDockerfileWithDifferentBaseImage(t *testing.T) {
 	}
 }
 
+func TestGenerateDockerfileWithTestAgent(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dockerfilePath := filepath.Join(tmpDir, "Dockerfile.main")
+	data := DockerfileData{
+		BaseImage: "alpine:latest",
+		TestAgent: true,
+	}
+
+	if err := generateDockerfile(dockerfilePath, dockerfileMainTemplate, data); err != nil {
+		t.Fatalf("generateDockerfile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		t.Fatalf("failed to read Dockerfile: %v", err)
+	}
+
+	contentStr := string(content)
+	if !strings.Contains(contentStr, "COPY --from=giverny-deps:latest /output/fakeclaude /usr/local/bin/claude") {
+		t.Error("Dockerfile does not install fakeclaude as claude")
+	}
+	for _, unexpected := range []string{"claude.ai/install.sh", "@sourcegraph/amp"} {
+		if strings.Contains(contentStr, unexpected) {
+			t.Errorf("Dockerfile should not install real agents in test mode, found: %s", unexpected)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithBuildOptions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dockerfilePath := filepath.Join(tmpDir, "Dockerfile.main")
+	for _, enabled := range []bool{false, true} {
+		data := DockerfileData{BaseImage: "alpine:latest", BuildOptions: BuildOptions{Nix: enabled, Mise: enabled}}
+		if err := generateDockerfile(dockerfilePath, dockerfileMainTemplate, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err := os.ReadFile(dockerfilePath)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile: %v", err)
+		}
+		if got := strings.Contains(string(content), "https://releases.nixos.org/nix/nix-"+NixVersion+"/install"); got != enabled {
+			t.Errorf("with Nix=%v, Dockerfile installs Nix: %v", enabled, got)
+		}
+		if enabled && !strings.Contains(string(content), "/install.sha256)  /tmp/nix-install\" | sha256sum -c -") {
+			t.Errorf("expected the Nix installer to be verified, got:\n%s", content)
+		}
+		if got := strings.Contains(string(content), "https://github.com/jdx/mise/releases/download/"+MiseVersion+"/"); got != enabled {
+			t.Errorf("with Mise=%v, Dockerfile installs mise: %v", enabled, got)
+		}
+		if enabled && !strings.Contains(string(content), `echo "$sum  /tmp/$file" | sha256sum -c -`) {
+			t.Errorf("expected the mise binary to be verified, got:\n%s", content)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithCACert(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	data := DockerfileData{BaseImage: "alpine:latest", BuildOptions: BuildOptions{CACert: "/etc/corp-ca.pem"}}
+	for name, tmpl := range map[string]string{"Dockerfile.deps": dockerfileDepsTemplate, "Dockerfile.main": dockerfileMainTemplate} {
+		path := filepath.Join(tmpDir, name)
+		if err := generateDockerfile(path, tmpl, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile: %v", err)
+		}
+		// Every stage that downloads anything trusts the certificates
+		stages := strings.Count(string(content), "\nFROM ")
+		if name == "Dockerfile.deps" {
+			stages-- // the final stage only collects the binaries
+		}
+		if got := strings.Count(string(content), "COPY --from="+buildFilesContext+" "+CACertFile+" "); got != stages {
+			t.Errorf("%s: expected the CA certificates in %d stages, got %d", name, stages, got)
+		}
+	}
+}
+
+func TestBuildOptionsSecrets(t *testing.T) {
+	opts := BuildOptions{Netrc: "/home/me/.netrc", Npmrc: "/home/me/.npmrc", GoPrivate: "git.corp.example.com/*"}
+	if got, want := opts.SecretMounts(), "--mount=type=secret,id=netrc,target=/root/.netrc --mount=type=secret,id=npmrc,target=/root/.npmrc "; got != want {
+		t.Errorf("SecretMounts() = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(opts.secretArgs(), " "), "--secret id=netrc,src=/home/me/.netrc --secret id=npmrc,src=/home/me/.npmrc"; got != want {
+		t.Errorf("secretArgs() = %q, want %q", got, want)
+	}
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "Dockerfile.deps")
+	if err := generateDockerfile(path, dockerfileDepsTemplate, DockerfileData{BuildOptions: opts}); err != nil {
+		t.Fatalf("generateDockerfile failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read Dockerfile: %v", err)
+	}
+	if !strings.Contains(string(content), "RUN --mount=type=secret,id=netrc,target=/root/.netrc --mount=type=secret,id=npmrc,target=/root/.npmrc mkdir -p /output && make build") {
+		t.Error("expected the credentials to be mounted when building giverny")
+	}
+	if got := strings.Count(string(content), "ENV GOPRIVATE=git.corp.example.com/*"); got != 2 {
+		t.Errorf("expected GOPRIVATE in both Go stages, got %d", got)
+	}
+
+	// Without credentials, the steps are unchanged
+	if err := generateDockerfile(path, dockerfileDepsTemplate, DockerfileData{}); err != nil {
+		t.Fatalf("generateDockerfile failed: %v", err)
+	}
+	if content, _ := os.ReadFile(path); strings.Contains(string(content), "--mount") || strings.Contains(string(content), "GOPRIVATE") {
+		t.Error("expected no secret mounts or GOPRIVATE without credentials")
+	}
+}
+
+func TestGenerateDockerfileWithChecksums(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "Dockerfile.deps")
+	sum := strings.Repeat("ab", 32)
+	data := DockerfileData{
+		DiffreviewerVersion: "v0.3.0",
+		BeadsRustVersion:    "v0.2.0",
+		BuildOptions:        BuildOptions{Diffreviewer: ToolVersion{Version: "v0.3.0", SHA256: sum}},
+	}
+	if err := generateDockerfile(path, dockerfileDepsTemplate, data); err != nil {
+		t.Fatalf("generateDockerfile failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read Dockerfile: %v", err)
+	}
+	contentStr := string(content)
+	for _, expected := range []string{
+		"diffreviewer/tarball/v0.3.0 -o diffreviewer.tar.gz && \\\n    echo \"" + sum + "  diffreviewer.tar.gz\" | sha256sum -c - && \\\n    mkdir -p diffreviewer",
+		"beads_rust/tarball/v0.2.0 -o beads_rust.tar.gz && \\\n    mkdir -p beads_rust",
+	} {
+		if !strings.Contains(contentStr, expected) {
+			t.Errorf("Dockerfile.deps missing expected content: %s", expected)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithoutTools(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := DockerfileData{BaseImage: "alpine:latest", BuildOptions: BuildOptions{NoDiffreviewer: true, NoBeads: true}}
+	for _, tt := range []struct {
+		name     string
+		template string
+	}{
+		{"Dockerfile.deps", dockerfileDepsTemplate},
+		{"Dockerfile.main", dockerfileMainTemplate},
+	} {
+		path := filepath.Join(tmpDir, tt.name)
+		if err := generateDockerfile(path, tt.template, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile: %v", err)
+		}
+		contentStr := string(content)
+		for _, unexpected := range []string{"diffreviewer", "beads", "/output/br"} {
+			if strings.Contains(contentStr, unexpected) {
+				t.Errorf("%s has unexpected content: %s", tt.name, unexpected)
+			}
+		}
+		if tt.name == "Dockerfile.deps" && !strings.Contains(contentStr, "# Verify all binaries are present\nRUN test -f /output/giverny\n") {
+			t.Errorf("%s should only verify the giverny binary:\n%s", tt.name, contentStr)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithExtras(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Dockerfile.main")
+	data := DockerfileData{
+		BaseImage: "debian:12",
+		BuildOptions: BuildOptions{
+			Packages: []string{"jq", "make"},
+			RunSteps: []string{"curl -fsSL https://example.com/install.sh | sh", "go install golang.org/x/tools/gopls@latest"},
+		},
+	}
+	if err := generateDockerfile(path, dockerfileMainTemplate, data); err != nil {
+		t.Fatalf("generateDockerfile failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read Dockerfile: %v", err)
+	}
+	contentStr := string(content)
+	for _, expected := range []string{
+		"apt-get update && apt-get install -y jq make; \\",
+		"apk add --no-cache jq make; \\",
+		"# Extra steps\nRUN curl -fsSL https://example.com/install.sh | sh\nRUN go install golang.org/x/tools/gopls@latest\n\n# Set working directory",
+	} {
+		if !strings.Contains(contentStr, expected) {
+			t.Errorf("Dockerfile.main missing expected content: %s", expected)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithDocker(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, tt := range []struct {
+		mode       string
+		expected   []string
+		unexpected []string
+	}{
+		{DockerModeSocket, []string{"mv /tmp/docker/docker /usr/local/bin/docker"}, []string{"iptables"}},
+		{DockerModeDinD, []string{"mv /tmp/docker/* /usr/local/bin/", "apt-get install -y iptables"}, nil},
+	} {
+		path := filepath.Join(tmpDir, "Dockerfile.main."+tt.mode)
+		data := DockerfileData{BaseImage: "debian:12", BuildOptions: BuildOptions{Docker: tt.mode}}
+		if err := generateDockerfile(path, dockerfileMainTemplate, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile: %v", err)
+		}
+		contentStr := string(content)
+		expected := append(tt.expected, "linux/static/stable/$(uname -m)/docker-"+DockerVersion+".tgz")
+		for _, want := range expected {
+			if !strings.Contains(contentStr, want) {
+				t.Errorf("%s: Dockerfile.main missing expected content: %s", tt.mode, want)
+			}
+		}
+		for _, unwanted := range tt.unexpected {
+			if strings.Contains(contentStr, unwanted) {
+				t.Errorf("%s: Dockerfile.main has unexpected content: %s", tt.mode, unwanted)
+			}
+		}
+	}
+}
+
+func TestGenerateDockerfileShellProfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, tt := range []struct {
+		dotfiles string
+		expected string
+	}{
+		{"", "COPY scripts/giverny-shellrc.sh /etc/giverny/shellrc"},
+		{"/home/me/dotfiles", "COPY --from=" + buildFilesContext + " " + DotfilesDir + "/ /root/"},
+	} {
+		path := filepath.Join(tmpDir, "Dockerfile.main")
+		data := DockerfileData{BaseImage: "debian:12", BuildOptions: BuildOptions{Dotfiles: tt.dotfiles}}
+		if err := generateDockerfile(path, dockerfileMainTemplate, data); err != nil {
+			t.Fatalf("generateDockerfile failed: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read Dockerfile: %v", err)
+		}
+		if !strings.Contains(string(content), tt.expected) {
+			t.Errorf("Dockerfile.main with dotfiles %q missing expected content: %s", tt.dotfiles, tt.expected)
+		}
+	}
+}
+
+func TestCopyDotfiles(t *testing.T) {
+	srcDir := t.TempDir()
+	for path, content := range map[string]string{
+		".bashrc":            "alias k=kubectl\n",
+		".config/git/config": "[user]\n",
+		".git/HEAD":          "ref: refs/heads/main\n",
+	} {
+		path = filepath.Join(srcDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	dstDir := filepath.Join(t.TempDir(), DotfilesDir)
+	copies, err := copyDotfiles(srcDir, dstDir)
+	if err != nil {
+		t.Fatalf("copyDotfiles failed: %v", err)
+	}
+	expected := []string{filepath.Join(dstDir, ".bashrc"), filepath.Join(dstDir, ".config/git/config")}
+	if !reflect.DeepEqual(copies, expected) {
+		t.Errorf("copyDotfiles() = %v, want %v", copies, expected)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ".git")); !os.IsNotExist(err) {
+		t.Errorf("expected .git to be left out, got %v", err)
+	}
+}
+
+func TestValidateExtras(t *testing.T) {
+	valid := BuildOptions{Packages: []string{"ripgrep", "jq=1.6-2", "g++"}, RunSteps: []string{"make install && make clean"}}
+	if err := valid.ValidateExtras(); err != nil {
+		t.Errorf("ValidateExtras failed: %v", err)
+	}
+	for _, opts := range []BuildOptions{
+		{Packages: []string{"jq; rm -rf /"}},
+		{Packages: []string{"-y"}},
+		{RunSteps: []string{" "}},
+		{RunSteps: []string{"make\nUSER nobody"}},
+		{RunSteps: []string{"make \\"}},
+	} {
+		if err := opts.ValidateExtras(); err == nil {
+			t.Errorf("ValidateExtras(%+v) succeeded, expected an error", opts)
+		}
+	}
+}
+
+func TestDefaultTools(t *testing.T) {
+	t.Setenv(WithToolsEnv, " diffreviewer ,")
+	if got := DefaultTools(); !reflect.DeepEqual(got, []string{ToolDiffreviewer}) {
+		t.Errorf("DefaultTools() = %v, want [diffreviewer]", got)
+	}
+	t.Setenv(WithToolsEnv, "")
+	if got := DefaultTools(); len(got) != 0 {
+		t.Errorf("DefaultTools() = %v, want none", got)
+	}
+
+	if err := CheckTools([]string{ToolBeads, ToolDiffreviewer}); err != nil {
+		t.Errorf("CheckTools failed: %v", err)
+	}
+	if err := CheckTools([]string{"ripgrep"}); err == nil {
+		t.Error("expected an error for an unknown tool")
+	}
+}
+
+func TestToolVersionValidate(t *testing.T) {
+	valid := []ToolVersion{{}, {Version: "v0.2.3"}, {Version: "1.0.0-rc.1", SHA256: strings.Repeat("0f", 32)}}
+	for _, v := range valid {
+		if err := v.Validate(); err != nil {
+			t.Errorf("Validate(%+v) failed: %v", v, err)
+		}
+	}
+	invalid := []ToolVersion{{Version: "v1; rm -rf /"}, {Version: "-v1"}, {SHA256: "abc"}, {SHA256: strings.Repeat("0F", 32)}}
+	for _, v := range invalid {
+		if err := v.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, expected an error", v)
+		}
+	}
+}
+
+func TestMainImageName_TestAgent(t *testing.T) {
+	t.Setenv("GIV_TEST", "1")
+	if got, want := MainImageName("alpine:latest"), "alpine-giverny-main-test:latest"; got != want {
+		t.Errorf("MainImageName() = %q, want %q", got, want)
+	}
+}
+
 func TestBuildImage_IntegrationTest(t *testing.T) {
 	// Skip unless INTEGRATION_TEST=1
 	if os.Getenv("INTEGRATION_TEST") != "1" {
@@ -164,7 +541,7 @@ func TestBuildImage_IntegrationTest(t *testing.T) {
 	EmbeddedSource = giverny.Source
 
 	// Build the image
-	err := BuildImage("alpine:latest", true, false, false)
+	err := BuildImage("alpine:latest", BuildOptions{}, true, false, false)
 	if err != nil {
 		t.Fatalf("BuildImage failed: %v", err)
 	}
@@ -201,3 +578,52 @@ func TestBuildImage_IntegrationTest(t *testing.T) {
 	exec.Command("docker", "rmi", "giverny-deps:latest").Run()
 	exec.Command("docker", "rmi", "alpine-giverny-main:latest").Run()
 }
+
+func TestMainImageName(t *testing.T) {
+	tests := []struct {
+		baseImage string
+		expected  string
+	}{
+		{"alpine", "alpine-giverny-main:latest"},
+		{"alpine:latest", "alpine-giverny-main:latest"},
+		{"gcr.io/foo/bar:dev", "gcr.io-foo-bar-giverny-main:dev"},
+		{"registry:5000/foo", "registry:5000-foo-giverny-main:latest"},
+		{"alpine@sha256:0123456789abcdef0123", "alpine-giverny-main:sha256-0123456789ab"},
+		{"alpine:3.20@sha256:0123456789abcdef0123", "alpine-giverny-main:sha256-0123456789ab"},
+	}
+	for _, tt := range tests {
+		if got := MainImageName(tt.baseImage); got != tt.expected {
+			t.Errorf("MainImageName(%q) = %q, want %q", tt.baseImage, got, tt.expected)
+		}
+	}
+}
+
+func TestClaudeCodeVersionIn(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "claude-versions")
+	runs := 0
+	find := func(version string) func() string {
+		return func() string {
+			runs++
+			return version
+		}
+	}
+
+	// Not found isn't cached, so it is looked for again
+	if got := claudeCodeVersionIn(dir, "sha256:0123", find("")); got != "" {
+		t.Errorf("expected no version, got %q", got)
+	}
+	if got := claudeCodeVersionIn(dir, "sha256:0123", find("2.1.0 (Claude Code)")); got != "2.1.0 (Claude Code)" {
+		t.Errorf("expected the version found, got %q", got)
+	}
+	if got := claudeCodeVersionIn(dir, "sha256:0123", find("other")); got != "2.1.0 (Claude Code)" {
+		t.Errorf("expected the cached version, got %q", got)
+	}
+	if runs != 2 {
+		t.Errorf("expected the image to be run twice, got %d", runs)
+	}
+
+	// Each image has its own
+	if got := claudeCodeVersionIn(dir, "sha256:4567", find("2.2.0 (Claude Code)")); got != "2.2.0 (Claude Code)" {
+		t.Errorf("expected the other image's version, got %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Labels giverny puts on the containers it runs for a task, so they can be
+// found again without knowing their names, e.g. to clean up after a crash
+const (
+	// LabelTask is the task's ID
+	LabelTask = "giverny.task"
+	// LabelContainer is the name of the task's container, on it and on its
+	// git server sidecar
+	LabelContainer = "giverny.container"
+)
+
+// TaskContainer is a container giverny ran for a task
+type TaskContainer struct {
+	Name string
+	// Task is the name of the task's container, which is Name except for a
+	// git server sidecar
+	Task    string
+	Created time.Time
+	Running bool
+}
+
+// labelArgs returns the docker run flags that label a task's container
+func labelArgs(taskID, containerName string) []string {
+	return []string{"--label", LabelTask + "=" + taskID, "--label", LabelContainer + "=" + containerName}
+}
+
+// ListTaskContainers returns the containers giverny ran for tasks, running or
+// not, as labelled. Containers from before the labels were added aren't
+// found.
+func ListTaskContainers() ([]TaskContainer, error) {
+	output, err := exec.Command("docker", "ps", "--all", "--filter", "label="+LabelContainer,
+		"--format", fmt.Sprintf("{{.Names}}\t{{.Label %q}}\t{{.CreatedAt}}\t{{.State}}", LabelContainer)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return taskContainers(string(output)), nil
+}
+
+// containerCreatedLayout is how docker ps shows when a container was created
+const containerCreatedLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// taskContainers parses the containers docker ps lists, one per line as
+// NAME, TASK, CREATED and STATE separated by tabs
+func taskContainers(output string) []TaskContainer {
+	var containers []TaskContainer
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		created, err := time.Parse(containerCreatedLayout, fields[2])
+		if err != nil {
+			continue
+		}
+		containers = append(containers, TaskContainer{
+			Name:    fields[0],
+			Task:    fields[1],
+			Created: created,
+			Running: fields[3] == "running",
+		})
+	}
+	return containers
+}
+
+// RemoveTaskContainer removes a container giverny ran for a task, stopping
+// it first if it is running, and its cache volume if it has one
+func RemoveTaskContainer(containerName string) error {
+	if output, err := exec.Command("docker", "rm", "--force", containerName).CombinedOutput(); err != nil && !notFound(output) {
+		return fmt.Errorf("failed to remove container %s: %w\n%s", containerName, err, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.Command("docker", "volume", "rm", CacheVolume(containerName)).CombinedOutput(); err != nil && !notFound(output) {
+		return fmt.Errorf("failed to remove volume %s: %w\n%s", CacheVolume(containerName), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// notFound reports whether the output of a failed docker command says what
+// it was given doesn't exist
+func notFound(output []byte) bool {
+	return strings.Contains(strings.ToLower(string(output)), "no such")
+}
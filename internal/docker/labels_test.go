@@ -0,0 +1,39 @@
+package docker
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"giverny/internal/taskspec"
+)
+
+func TestTaskContainers(t *testing.T) {
+	output := "giverny-PROJ-1\tgiverny-PROJ-1\t2023-11-14 22:13:20 +0000 UTC\trunning\n" +
+		"giverny-PROJ-1-git\tgiverny-PROJ-1\t2023-11-14 22:13:21 +0000 UTC\texited\n" +
+		"giverny-broken\tgiverny-broken\tyesterday\texited\n"
+	got := taskContainers(output)
+	want := []TaskContainer{
+		{Name: "giverny-PROJ-1", Task: "giverny-PROJ-1", Created: time.Unix(1700000000, 0), Running: true},
+		{Name: "giverny-PROJ-1-git", Task: "giverny-PROJ-1", Created: time.Unix(1700000001, 0)},
+	}
+	if !slices.EqualFunc(got, want, func(a, b TaskContainer) bool {
+		return a.Name == b.Name && a.Task == b.Task && a.Created.Equal(b.Created) && a.Running == b.Running
+	}) {
+		t.Errorf("taskContainers() = %v, want %v", got, want)
+	}
+}
+
+func TestAppendContainerArgs_Labels(t *testing.T) {
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+	args, err := appendContainerArgs(nil, taskspec.Spec{TaskID: "PROJ-1", Slug: "fix", GitServerPort: 4242}, "ubuntu:24.04", "")
+	if err != nil {
+		t.Fatalf("appendContainerArgs() error = %v", err)
+	}
+	for _, label := range []string{LabelTask + "=PROJ-1", LabelContainer + "=giverny-PROJ-1-fix"} {
+		i := slices.Index(args, label)
+		if i < 1 || args[i-1] != "--label" {
+			t.Errorf("expected --label %s, got %q", label, args)
+		}
+	}
+}
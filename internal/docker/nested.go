@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Ways a task can be given Docker, see --enable-docker
+const (
+	// DockerModeSocket mounts the host's Docker socket into the container,
+	// which gives the agent control of the host's Docker daemon
+	DockerModeSocket = "socket"
+	// DockerModeDinD runs a separate Docker daemon inside the container,
+	// which has to be privileged for it. The daemon runs as root: rootless
+	// Docker (as in docker:dind-rootless) needs --privileged just the same,
+	// and the agent it would be kept from is already root in the container,
+	// so it would add setup (a user, subuids and rootlesskit) without
+	// narrowing what the agent can do.
+	DockerModeDinD = "dind"
+)
+
+// DockerVersion is the version of the static Docker binaries installed in
+// images of tasks that use Docker
+const DockerVersion = "27.5.1"
+
+// hostDockerSocket is where the host's Docker daemon listens
+const hostDockerSocket = "/var/run/docker.sock"
+
+// NestedDockerArgs returns the docker run flags that give a task's container
+// Docker in mode
+func NestedDockerArgs(mode string) string {
+	switch mode {
+	case DockerModeSocket:
+		return "-v " + hostDockerSocket + ":" + hostDockerSocket
+	case DockerModeDinD:
+		// The nested daemon's storage can't be on the container's overlay
+		// filesystem, so it gets a volume, removed with the container
+		return "--privileged -v /var/lib/docker"
+	}
+	return ""
+}
+
+// dockerdLog is where the output of a nested Docker daemon goes
+const dockerdLog = "/tmp/dockerd.log"
+
+// dockerdTimeout is how long to wait for a nested Docker daemon to start
+const dockerdTimeout = 30 * time.Second
+
+// StartDaemon starts a Docker daemon in the background inside the container
+// and waits until it answers, for DockerModeDinD. It keeps running until the
+// container stops.
+func StartDaemon(debug bool) error {
+	logFile, err := os.Create(dockerdLog)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dockerdLog, err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("dockerd")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start dockerd: %w", err)
+	}
+	if debug {
+		fmt.Printf("Started dockerd (pid %d), logging to %s\n", cmd.Process.Pid, dockerdLog)
+	}
+
+	deadline := time.Now().Add(dockerdTimeout)
+	for {
+		if err := exec.Command("docker", "info").Run(); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("dockerd did not start within %s, see %s", dockerdTimeout, dockerdLog)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"giverny/internal/cmdutil"
+	"giverny/internal/git"
+)
+
+// GitSidecarPort is the port the git server sidecar serves on, git's own
+const GitSidecarPort = 9418
+
+// gitSidecarRepo is where the repository is mounted in the git server sidecar
+const gitSidecarRepo = "/repo"
+
+// gitSidecarServeDir is the directory the git server sidecar serves, holding
+// a link to the repository named after the token
+const gitSidecarServeDir = "/tmp/giverny-git-serve"
+
+// gitSidecarHooksDir is where the git server sidecar's hooks are written
+const gitSidecarHooksDir = "/tmp/giverny-git-hooks"
+
+// gitSidecarHookEnv is the environment variable the git server sidecar gets
+// its update hook (git.UpdateHook) in
+const gitSidecarHookEnv = "GIVERNY_GIT_UPDATE_HOOK"
+
+// gitSidecarTokenEnv is the environment variable the git server sidecar gets
+// its token in. It is passed by name, so the token isn't on any command line.
+const gitSidecarTokenEnv = "GIVERNY_GIT_TOKEN"
+
+// gitSidecarTimeout is how long to wait for the git server sidecar to serve
+const gitSidecarTimeout = 10 * time.Second
+
+// GitSidecarName returns the name of the git server sidecar of the task run
+// in containerName, which is also its host name on the task's network
+func GitSidecarName(containerName string) string {
+	return containerName + gitSidecarSuffix
+}
+
+// gitSidecarSuffix is added to the task's container name to name its git
+// server sidecar
+const gitSidecarSuffix = "-git"
+
+// NetworkArgs returns the docker run flags that put a task's container on
+// network. The control server stays on the host, and host.docker.internal
+// is only predefined by Docker Desktop, so it is mapped to the host gateway.
+func NetworkArgs(network string) string {
+	return "--network " + network + " --add-host host.docker.internal:host-gateway"
+}
+
+// HostLoopbackReachable reports whether containers reach services listening
+// on this host's loopback interface at host.docker.internal, as they do with
+// Docker Desktop and OrbStack, which forward it to the host from their VM
+func HostLoopbackReachable() bool {
+	info, err := getDaemonInfo()
+	if err != nil {
+		return false
+	}
+	return forwardsHostLoopback(info.OperatingSystem)
+}
+
+// BridgeGateway returns the address of this host on Docker's default bridge
+// network, which host.docker.internal maps to through host-gateway on Docker
+// Engine for Linux, or "" if there is none or it isn't one of this host's
+// addresses, as when the daemon runs in a VM, rootless or on another host
+func BridgeGateway() string {
+	output, err := exec.Command("docker", "network", "inspect", "bridge", "--format", "{{range .IPAM.Config}}{{.Gateway}} {{end}}").Output()
+	if err != nil {
+		return ""
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	return localGateway(strings.Fields(string(output)), addrs)
+}
+
+// localGateway returns the first of gateways that is among addrs, the
+// addresses of this host's interfaces
+func localGateway(gateways []string, addrs []net.Addr) string {
+	for _, gateway := range gateways {
+		ip := net.ParseIP(gateway)
+		if ip == nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return gateway
+			}
+		}
+	}
+	return ""
+}
+
+// daemonInfo is what giverny uses of what `docker info` says about the
+// Docker daemon
+type daemonInfo struct {
+	OperatingSystem string
+	SecurityOptions []string
+}
+
+// getDaemonInfo asks the Docker daemon about itself
+func getDaemonInfo() (daemonInfo, error) {
+	var info daemonInfo
+	output, err := exec.Command("docker", "info", "--format", "{{json .}}").Output()
+	if err != nil {
+		return info, fmt.Errorf("failed to get Docker daemon info: %w", err)
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return info, fmt.Errorf("failed to parse Docker daemon info: %w", err)
+	}
+	return info, nil
+}
+
+// forwardsHostLoopback reports whether the Docker runtime running on
+// operatingSystem, as `docker info` reports it, forwards host.docker.internal
+// to the host's loopback
+func forwardsHostLoopback(operatingSystem string) bool {
+	return strings.Contains(operatingSystem, "Docker Desktop") || strings.Contains(operatingSystem, "OrbStack")
+}
+
+// EnsureNetwork creates the Docker network if it doesn't exist
+func EnsureNetwork(network string, debug bool) error {
+	if err := exec.Command("docker", "network", "inspect", network).Run(); err == nil {
+		return nil
+	}
+	if debug {
+		fmt.Printf("Creating Docker network %s\n", network)
+	}
+	if output, err := cmdutil.RunCommandWithOutput("docker", "network", "create", network); err != nil {
+		return fmt.Errorf("failed to create network %s: %w\n%s", network, err, output)
+	}
+	return nil
+}
+
+// StartGitSidecar starts a container named name on network that serves the
+// repository at repoPath with git daemon, in place of the git server on the
+// host, and waits until it serves. Like the git server on the host, it serves
+// the repository under token, or at / if token is empty, so that other
+// containers on the network can't reach it, and only lets the refs of the
+// task on branchName be pushed (see git.UpdateHook). It runs image, which
+// must have git, as the host user, where the Docker runtime doesn't map root
+// to them, so that pushed objects belong to them.
+func StartGitSidecar(name, network, image, repoPath, token, branchName string, debug bool) error {
+	if token == "." || token == ".." || strings.ContainsAny(token, `/\`) {
+		return fmt.Errorf("invalid git server token %q", token)
+	}
+	args := []string{"run", "-d", "--rm", "--name", name, "--network", network,
+		"-v", repoPath + ":" + gitSidecarRepo,
+		"--env", gitSidecarTokenEnv,
+		"--env", gitSidecarHookEnv,
+		"--label", LabelContainer + "=" + strings.TrimSuffix(name, gitSidecarSuffix)}
+	if hostUser := HostUser(); hostUser != "" {
+		args = append(args, "--user", hostUser)
+	}
+	// Write the hooks and link the repository under the token in the
+	// directory served, then run the daemon. The mounted repository may
+	// belong to another user than the daemon.
+	basePath := gitSidecarRepo
+	script := fmt.Sprintf(`mkdir -p %s && printf '%%s' "$%s" > %s/update && chmod +x %s/update`, gitSidecarHooksDir, gitSidecarHookEnv, gitSidecarHooksDir, gitSidecarHooksDir)
+	if token != "" {
+		basePath = gitSidecarServeDir
+		script += fmt.Sprintf(` && mkdir -p %s && ln -s %s "%s/$%s"`, gitSidecarServeDir, gitSidecarRepo, gitSidecarServeDir, gitSidecarTokenEnv)
+	}
+	script += ` && exec git "$@"`
+	args = append(args, "--entrypoint", "sh", image, "-c", script, "sh", "-c", "safe.directory=*")
+	args = append(args, git.DaemonArgs(basePath, gitSidecarHooksDir, GitSidecarPort)...)
+	cmd := exec.Command("docker", args...)
+	cmd.Env = append(os.Environ(), gitSidecarTokenEnv+"="+token, gitSidecarHookEnv+"="+git.UpdateHook(branchName))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to start git server sidecar: %w\n%s", err, strings.TrimSpace(string(output)))
+	}
+
+	// The token is only known to the sidecar's environment, not its command
+	// line
+	check := fmt.Sprintf(`git ls-remote --heads "git://127.0.0.1:%d/$%s"`, GitSidecarPort, gitSidecarTokenEnv)
+	deadline := time.Now().Add(gitSidecarTimeout)
+	for {
+		err := exec.Command("docker", "exec", name, "sh", "-c", check).Run()
+		if err == nil {
+			if debug {
+				fmt.Printf("Git server sidecar %s serving on network %s\n", name, network)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			StopGitSidecar(name)
+			return fmt.Errorf("git server sidecar %s did not start serving within %s", name, gitSidecarTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// StopGitSidecar stops and removes the git server sidecar named name
+func StopGitSidecar(name string) error {
+	if output, err := cmdutil.RunCommandWithOutput("docker", "rm", "-f", name); err != nil {
+		return fmt.Errorf("failed to remove git server sidecar %s: %w\n%s", name, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package docker
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForwardsHostLoopback(t *testing.T) {
+	tests := []struct {
+		operatingSystem string
+		expected        bool
+	}{
+		{"Docker Desktop", true},
+		{"OrbStack", true},
+		{"Ubuntu 24.04.1 LTS", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := forwardsHostLoopback(tt.operatingSystem); got != tt.expected {
+			t.Errorf("forwardsHostLoopback(%q) = %v, want %v", tt.operatingSystem, got, tt.expected)
+		}
+	}
+}
+
+func TestLocalGateway(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("172.17.0.1"), Mask: net.CIDRMask(16, 32)},
+	}
+	tests := []struct {
+		gateways []string
+		expected string
+	}{
+		{[]string{"172.17.0.1"}, "172.17.0.1"},
+		{[]string{"fd00::1", "172.17.0.1"}, "172.17.0.1"},
+		// The daemon runs elsewhere, e.g. in a VM
+		{[]string{"192.168.65.1"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := localGateway(tt.gateways, addrs); got != tt.expected {
+			t.Errorf("localGateway(%q) = %q, want %q", tt.gateways, got, tt.expected)
+		}
+	}
+}
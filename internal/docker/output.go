@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// TimestampFormat is the format of the timestamps OutputOptions.Timestamps
+// prefixes the container's output with
+const TimestampFormat = "15:04:05.000"
+
+// OutputOptions says how the output of a task's container is shown
+type OutputOptions struct {
+	Timestamps bool   // prefix each line with the time it was written
+	LogFile    string // also append the output to this file
+}
+
+// teed reports whether the output has to go through giverny, rather than
+// straight from docker to the terminal
+func (o OutputOptions) teed() bool {
+	return o.Timestamps || o.LogFile != ""
+}
+
+// containerOutput returns the writers for the container's stdout and
+// stderr, and a function to call once it has exited that closes the log
+// file. When stdout is a terminal and no options are set, docker writes to
+// it directly: docker only passes the terminal's size, and changes to it, on
+// to the container's TTY when its own stdout is the terminal.
+func containerOutput(opts OutputOptions) (stdout, stderr io.Writer, done func(), err error) {
+	if !opts.teed() && stdoutIsTerminal() {
+		return os.Stdout, os.Stderr, func() {}, nil
+	}
+	return teeOutput(os.Stdout, os.Stderr, opts, time.Now)
+}
+
+// teeOutput returns writers for the container's stdout and stderr that
+// write to out and errOut, and the log file if there is one, with
+// timestamps if asked for. Writes to the log file are serialized, so lines
+// from the two streams aren't interleaved in it.
+func teeOutput(out, errOut io.Writer, opts OutputOptions, now func() time.Time) (stdout, stderr io.Writer, done func(), err error) {
+	var log io.Writer
+	done = func() {}
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open output log: %w", err)
+		}
+		log = &lockedWriter{w: f}
+		done = func() { f.Close() }
+	}
+	wrap := func(w io.Writer) io.Writer {
+		if log != nil {
+			w = io.MultiWriter(w, log)
+		}
+		if opts.Timestamps {
+			w = &timestampWriter{w: w, now: now, lineStart: true}
+		}
+		return w
+	}
+	return wrap(out), wrap(errOut), done, nil
+}
+
+// lockedWriter serializes writes to w
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// timestampWriter prefixes each line written to w with the time its first
+// byte was written
+type timestampWriter struct {
+	w         io.Writer
+	now       func() time.Time
+	lineStart bool
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	var buf []byte
+	for _, b := range p {
+		if t.lineStart {
+			buf = t.now().AppendFormat(buf, TimestampFormat)
+			buf = append(buf, ' ')
+			t.lineStart = false
+		}
+		buf = append(buf, b)
+		if b == '\n' {
+			t.lineStart = true
+		}
+	}
+	if _, err := t.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
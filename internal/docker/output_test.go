@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTeeOutput(t *testing.T) {
+	clock := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	now := func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	logFile := filepath.Join(t.TempDir(), "output.log")
+	if err := os.WriteFile(logFile, []byte("earlier\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	stdout, stderr, done, err := teeOutput(&out, &errOut, OutputOptions{Timestamps: true, LogFile: logFile}, now)
+	if err != nil {
+		t.Fatalf("teeOutput failed: %v", err)
+	}
+	// A line split across writes gets one timestamp, when it starts
+	stdout.Write([]byte("Cloning"))
+	stdout.Write([]byte(" repository\nDone\n"))
+	stderr.Write([]byte("warning\n"))
+	done()
+
+	if got, want := out.String(), "15:04:06.000 Cloning repository\n15:04:07.000 Done\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := errOut.String(), "15:04:08.000 warning\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+	// The log file is appended to, with both streams
+	log, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(log), "earlier\n"+out.String()+errOut.String(); got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestTeeOutput_NoOptions(t *testing.T) {
+	var out, errOut bytes.Buffer
+	stdout, stderr, done, err := teeOutput(&out, &errOut, OutputOptions{}, time.Now)
+	if err != nil {
+		t.Fatalf("teeOutput failed: %v", err)
+	}
+	defer done()
+	stdout.Write([]byte("\x1b[2Jscreen\r\n"))
+	stderr.Write([]byte("err"))
+	if out.String() != "\x1b[2Jscreen\r\n" || errOut.String() != "err" {
+		t.Errorf("expected output to pass through unchanged, got %q and %q", out.String(), errOut.String())
+	}
+}
+
+func TestTeeOutput_BadLogFile(t *testing.T) {
+	var out bytes.Buffer
+	logFile := filepath.Join(t.TempDir(), "missing", "output.log")
+	if _, _, _, err := teeOutput(&out, &out, OutputOptions{LogFile: logFile}, time.Now); err == nil {
+		t.Error("expected an error for a log file in a missing directory")
+	}
+}
@@ -0,0 +1,107 @@
+package docker
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HostUser returns the user and group, as "UID:GID", that files root creates
+// in a container's bind mounts should be given to, so that they belong on the
+// host to the user running giverny (under sudo, the user who ran it). It is
+// empty where the Docker runtime already maps root in containers to that
+// user, as Docker Desktop, OrbStack, rootless Docker and user namespace
+// remapping do.
+func HostUser() string {
+	info, err := getDaemonInfo()
+	if err != nil {
+		return ""
+	}
+	if mapsRootToHostUser(info.OperatingSystem, info.SecurityOptions) {
+		return ""
+	}
+	return invokingUser()
+}
+
+// mapsRootToHostUser reports whether the Docker runtime running on
+// operatingSystem with securityOptions, as `docker info` reports them, gives
+// files root creates in bind mounts to the host user
+func mapsRootToHostUser(operatingSystem string, securityOptions []string) bool {
+	if forwardsHostLoopback(operatingSystem) {
+		return true
+	}
+	for _, option := range securityOptions {
+		if option == "name=rootless" || option == "name=userns" {
+			return true
+		}
+	}
+	return false
+}
+
+// invokingUser returns the user and group, as "UID:GID", of the user running
+// giverny, or under sudo of the user who ran sudo. It is empty for root
+// itself, and where there are no user IDs.
+func invokingUser() string {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		sudoUID, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+		if err != nil {
+			return ""
+		}
+		uid = sudoUID
+		if sudoGID, err := strconv.Atoi(os.Getenv("SUDO_GID")); err == nil {
+			gid = sudoGID
+		}
+	}
+	if uid <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", uid, gid)
+}
+
+// ParseHostUser returns the user and group IDs of a HostUser
+func ParseHostUser(hostUser string) (uid, gid int, err error) {
+	uidText, gidText, ok := strings.Cut(hostUser, ":")
+	if ok {
+		uid, err = strconv.Atoi(uidText)
+	}
+	if ok && err == nil {
+		gid, err = strconv.Atoi(gidText)
+	}
+	if !ok || err != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid host user %q, expected UID:GID", hostUser)
+	}
+	return uid, gid, nil
+}
+
+// ChownRootFiles gives the files and directories under path that belong to
+// root to hostUser, leaving those of other users alone. A missing path is
+// not an error.
+func ChownRootFiles(path, hostUser string) error {
+	uid, gid, err := ParseHostUser(hostUser)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if owner, ok := fileOwner(info); ok && owner == 0 {
+			if err := os.Lchown(p, uid, gid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to give %s to %s: %w", path, hostUser, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package docker
+
+import "os"
+
+// fileOwner is not supported where files have no user IDs
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapsRootToHostUser(t *testing.T) {
+	tests := []struct {
+		operatingSystem string
+		securityOptions []string
+		want            bool
+	}{
+		{"Ubuntu 24.04 LTS", []string{"name=apparmor", "name=seccomp,profile=builtin"}, false},
+		{"Ubuntu 24.04 LTS", []string{"name=seccomp,profile=builtin", "name=rootless"}, true},
+		{"Debian GNU/Linux 12 (bookworm)", []string{"name=userns"}, true},
+		{"Docker Desktop", nil, true},
+		{"OrbStack", nil, true},
+	}
+	for _, tt := range tests {
+		if got := mapsRootToHostUser(tt.operatingSystem, tt.securityOptions); got != tt.want {
+			t.Errorf("mapsRootToHostUser(%q, %q) = %t, want %t", tt.operatingSystem, tt.securityOptions, got, tt.want)
+		}
+	}
+}
+
+func TestParseHostUser(t *testing.T) {
+	uid, gid, err := ParseHostUser("1000:100")
+	if err != nil || uid != 1000 || gid != 100 {
+		t.Errorf("ParseHostUser(1000:100) = %d, %d, %v", uid, gid, err)
+	}
+	for _, hostUser := range []string{"", "1000", "1000:", "alice:staff", "-1:0"} {
+		if _, _, err := ParseHostUser(hostUser); err == nil {
+			t.Errorf("expected an error for %q", hostUser)
+		}
+	}
+}
+
+func TestInvokingUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Setenv("SUDO_UID", "1234")
+		if got, want := invokingUser(), fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()); got != want {
+			t.Errorf("invokingUser() = %q, want %q, ignoring SUDO_UID", got, want)
+		}
+		return
+	}
+	t.Setenv("SUDO_UID", "1234")
+	t.Setenv("SUDO_GID", "567")
+	if got := invokingUser(); got != "1234:567" {
+		t.Errorf("invokingUser() = %q under sudo, want 1234:567", got)
+	}
+	t.Setenv("SUDO_UID", "")
+	if got := invokingUser(); got != "" {
+		t.Errorf("invokingUser() = %q for root, want none", got)
+	}
+}
+
+func TestChownRootFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "projects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "projects", "session.jsonl")
+	if err := os.WriteFile(file, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are root's, and given away, only when the test runs as root
+	if err := ChownRootFiles(dir, "1234:567"); err != nil {
+		t.Fatalf("ChownRootFiles failed: %v", err)
+	}
+	if os.Getuid() == 0 {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner, ok := fileOwner(info); ok && owner != 1234 {
+			t.Errorf("expected %s to belong to 1234, got %d", file, owner)
+		}
+	}
+
+	if err := ChownRootFiles(filepath.Join(dir, "missing"), "1234:567"); err != nil {
+		t.Errorf("expected a missing path to be skipped, got %v", err)
+	}
+	if err := ChownRootFiles(dir, "nobody"); err == nil {
+		t.Error("expected an error for an invalid host user")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package docker
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user ID that owns the file described by info
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"os"
+	"strings"
+)
+
+// noProxyHosts are always reached directly, so the container can still
+// reach giverny's git and control servers on the host through a proxy
+var noProxyHosts = []string{"localhost", "127.0.0.1", "host.docker.internal"}
+
+// ProxyEnv returns the environment variables, as NAME=VALUE, that send HTTP
+// and HTTPS traffic through proxy, or nil if proxy is empty. Both spellings
+// of each variable are set, as tools differ in which they read. Hosts in the
+// host's NO_PROXY are also reached directly.
+func ProxyEnv(proxy string) []string {
+	if proxy == "" {
+		return nil
+	}
+	noProxy := noProxyHosts
+	if hostNoProxy := os.Getenv("NO_PROXY"); hostNoProxy != "" {
+		noProxy = append([]string{hostNoProxy}, noProxy...)
+	}
+	values := []struct{ name, value string }{
+		{"HTTP_PROXY", proxy},
+		{"HTTPS_PROXY", proxy},
+		{"NO_PROXY", strings.Join(noProxy, ",")},
+	}
+	var env []string
+	for _, v := range values {
+		env = append(env, v.name+"="+v.value, strings.ToLower(v.name)+"="+v.value)
+	}
+	return env
+}
@@ -0,0 +1,23 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxyEnv(t *testing.T) {
+	t.Setenv("NO_PROXY", ".corp.example.com")
+
+	if env := ProxyEnv(""); env != nil {
+		t.Errorf("expected no variables without a proxy, got %v", env)
+	}
+
+	expected := []string{
+		"HTTP_PROXY=http://proxy:3128", "http_proxy=http://proxy:3128",
+		"HTTPS_PROXY=http://proxy:3128", "https_proxy=http://proxy:3128",
+		"NO_PROXY=.corp.example.com,localhost,127.0.0.1,host.docker.internal", "no_proxy=.corp.example.com,localhost,127.0.0.1,host.docker.internal",
+	}
+	if env := ProxyEnv("http://proxy:3128"); !reflect.DeepEqual(env, expected) {
+		t.Errorf("ProxyEnv() = %v, want %v", env, expected)
+	}
+}
@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RegistryImage returns the name of a local image in a registry, e.g.
+// "ghcr.io/team" and "alpine-giverny-main:latest" ->
+// "ghcr.io/team/alpine-giverny-main:latest".
+func RegistryImage(registry, imageName string) string {
+	return strings.TrimSuffix(registry, "/") + "/" + imageName
+}
+
+// PullImage pulls registryImage and tags it as localImage
+func PullImage(registryImage, localImage string) error {
+	if output, err := exec.Command("docker", "pull", "--quiet", registryImage).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to pull %s: %s", registryImage, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.Command("docker", "tag", registryImage, localImage).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to tag %s as %s: %s", registryImage, localImage, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// PushImage tags localImage as registryImage and pushes it
+func PushImage(localImage, registryImage string) error {
+	if output, err := exec.Command("docker", "tag", localImage, registryImage).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to tag %s as %s: %s", localImage, registryImage, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.Command("docker", "push", "--quiet", registryImage).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to push %s: %s", registryImage, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package docker
+
+import "testing"
+
+func TestRegistryImage(t *testing.T) {
+	for _, registry := range []string{"ghcr.io/team", "ghcr.io/team/"} {
+		if got, want := RegistryImage(registry, "alpine-giverny-main:latest"), "ghcr.io/team/alpine-giverny-main:latest"; got != want {
+			t.Errorf("RegistryImage(%q) = %q, want %q", registry, got, want)
+		}
+	}
+}
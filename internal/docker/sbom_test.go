@@ -0,0 +1,17 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSBOMArgs(t *testing.T) {
+	got := strings.Join(sbomArgs("syft", "giverny-main:latest", SBOMFormatSPDX, "/tmp/task.sbom.json"), " ")
+	if want := "scan docker:giverny-main:latest --quiet --output spdx-json=/tmp/task.sbom.json"; got != want {
+		t.Errorf("syft args = %q, want %q", got, want)
+	}
+	got = strings.Join(sbomArgs("docker", "giverny-main:latest", SBOMFormatCycloneDX, "/tmp/task.sbom.json"), " ")
+	if want := "sbom giverny-main:latest --format cyclonedx-json --output /tmp/task.sbom.json"; got != want {
+		t.Errorf("docker sbom args = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoScanner is returned by ScanImage when no vulnerability scanner is
+// available.
+var ErrNoScanner = errors.New("no vulnerability scanner found (install trivy or grype, or enable docker scout)")
+
+// ScanResult counts the known vulnerabilities in an image by severity.
+type ScanResult struct {
+	Scanner  string
+	Critical int
+	High     int
+	Medium   int
+	Low      int
+}
+
+// Summary describes the result in one line
+func (r ScanResult) Summary() string {
+	return fmt.Sprintf("%d critical, %d high, %d medium, %d low (scanned with %s)", r.Critical, r.High, r.Medium, r.Low, r.Scanner)
+}
+
+// count adds a vulnerability of the given severity, in any case. Other
+// severities, such as unknown or negligible, are not counted.
+func (r *ScanResult) count(severity string) {
+	switch strings.ToLower(severity) {
+	case "critical":
+		r.Critical++
+	case "high":
+		r.High++
+	case "medium":
+		r.Medium++
+	case "low":
+		r.Low++
+	}
+}
+
+// ScanImage scans a local image for known vulnerabilities with trivy or
+// grype, whichever is installed, or else docker scout.
+func ScanImage(imageName string) (*ScanResult, error) {
+	var scanner string
+	var args []string
+	var parse func([]byte) (*ScanResult, error)
+	switch {
+	case hasCommand("trivy"):
+		scanner, args, parse = "trivy", []string{"image", "--quiet", "--format", "json", imageName}, parseTrivy
+	case hasCommand("grype"):
+		scanner, args, parse = "grype", []string{"docker:" + imageName, "--quiet", "--output", "json"}, parseGrype
+	case exec.Command("docker", "scout", "version").Run() == nil:
+		scanner, args, parse = "docker", []string{"scout", "quickview", "local://" + imageName}, parseScoutQuickview
+	default:
+		return nil, ErrNoScanner
+	}
+
+	output, err := exec.Command(scanner, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s with %s: %w", imageName, scanner, err)
+	}
+	return parse(output)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// parseTrivy reads the output of `trivy image --format json`
+func parseTrivy(output []byte) (*ScanResult, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				Severity string
+			}
+		}
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse trivy output: %w", err)
+	}
+
+	result := &ScanResult{Scanner: "trivy"}
+	for _, target := range report.Results {
+		for _, vuln := range target.Vulnerabilities {
+			result.count(vuln.Severity)
+		}
+	}
+	return result, nil
+}
+
+// parseGrype reads the output of `grype --output json`
+func parseGrype(output []byte) (*ScanResult, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				Severity string `json:"severity"`
+			} `json:"vulnerability"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse grype output: %w", err)
+	}
+
+	result := &ScanResult{Scanner: "grype"}
+	for _, match := range report.Matches {
+		result.count(match.Vulnerability.Severity)
+	}
+	return result, nil
+}
+
+// scoutCounts matches the vulnerability counts in `docker scout quickview`
+// output, e.g. "1C     3H    12M    40L"
+var scoutCounts = regexp.MustCompile(`(\d+)C\s+(\d+)H\s+(\d+)M\s+(\d+)L`)
+
+// parseScoutQuickview reads the output of `docker scout quickview`. Its first
+// counts are for the image itself; any after that are for its base image.
+func parseScoutQuickview(output []byte) (*ScanResult, error) {
+	match := scoutCounts.FindSubmatch(output)
+	if match == nil {
+		return nil, fmt.Errorf("failed to parse docker scout output: %q", strings.TrimSpace(string(output)))
+	}
+
+	counts := make([]int, 4)
+	for i := range counts {
+		counts[i], _ = strconv.Atoi(string(match[i+1]))
+	}
+	return &ScanResult{Scanner: "docker scout", Critical: counts[0], High: counts[1], Medium: counts[2], Low: counts[3]}, nil
+}
@@ -0,0 +1,57 @@
+package docker
+
+import "testing"
+
+func TestParseScanOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		parse    func([]byte) (*ScanResult, error)
+		output   string
+		expected ScanResult
+	}{
+		{
+			name:  "trivy",
+			parse: parseTrivy,
+			output: `{"Results": [
+				{"Target": "debian", "Vulnerabilities": [{"Severity": "CRITICAL"}, {"Severity": "HIGH"}, {"Severity": "UNKNOWN"}]},
+				{"Target": "node-pkg"},
+				{"Target": "gobinary", "Vulnerabilities": [{"Severity": "LOW"}, {"Severity": "CRITICAL"}]}
+			]}`,
+			expected: ScanResult{Scanner: "trivy", Critical: 2, High: 1, Low: 1},
+		},
+		{
+			name:  "grype",
+			parse: parseGrype,
+			output: `{"matches": [
+				{"vulnerability": {"id": "CVE-1", "severity": "Medium"}},
+				{"vulnerability": {"id": "CVE-2", "severity": "Negligible"}},
+				{"vulnerability": {"id": "CVE-3", "severity": "High"}}
+			]}`,
+			expected: ScanResult{Scanner: "grype", High: 1, Medium: 1},
+		},
+		{
+			name:  "docker scout",
+			parse: parseScoutQuickview,
+			output: `    Target             │  local://giverny-main:latest  │    1C     3H    12M    40L
+    digest             │  4a1b2c3d4e5f                 │
+  Base image           │  debian:12                    │    0C     1H     5M    30L`,
+			expected: ScanResult{Scanner: "docker scout", Critical: 1, High: 3, Medium: 12, Low: 40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.parse([]byte(tt.output))
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if *result != tt.expected {
+				t.Errorf("got %+v, want %+v", *result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := parseScoutQuickview([]byte("No vulnerabilities data")); err == nil {
+		t.Error("expected an error for unrecognised docker scout output")
+	}
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sourceHashLength is how much of the hash of the embedded source names its
+// cache directory
+const sourceHashLength = 16
+
+// sourceCacheMaxAge is how long the extracted source of other giverny
+// versions is kept, in case they are still being used
+const sourceCacheMaxAge = 7 * 24 * time.Hour
+
+// sourceHash returns the SHA-256 of the paths and contents of the files in
+// src. fs.WalkDir visits them in lexical order, so it is stable.
+func sourceHash(src fs.FS) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(src, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(src, path)
+		if err != nil {
+			return fmt.Errorf("failed to read embedded file %s: %w", path, err)
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", path, len(content))
+		h.Write(content)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceCacheRoot returns the directory holding the extracted embedded
+// source, one directory per hash
+func sourceCacheRoot() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "giverny", "source"), nil
+}
+
+// cachedEmbeddedSource returns a directory holding the files in src, for use
+// as the build context. The directory is named after a hash of the files, so
+// it is only extracted once per giverny version and every build sees
+// identical files, which lets docker reuse its build cache.
+func cachedEmbeddedSource(src fs.FS, debug bool) (string, error) {
+	root, err := sourceCacheRoot()
+	if err != nil {
+		return "", err
+	}
+	return extractSourceTo(src, root, debug)
+}
+
+// extractSourceTo extracts the files in src to a directory under root named
+// after their hash, unless it already exists, and returns it. Other versions'
+// directories that haven't been used for sourceCacheMaxAge are removed.
+func extractSourceTo(src fs.FS, root string, debug bool) (string, error) {
+	hash, err := sourceHash(src)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, hash[:sourceHashLength])
+	if _, err := os.Stat(dir); err == nil {
+		if debug {
+			fmt.Printf("Using embedded source extracted to %s\n", dir)
+		}
+		// Mark it as used, so it isn't pruned by other versions
+		now := time.Now()
+		_ = os.Chtimes(dir, now, now)
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", root, err)
+	}
+	// Extract somewhere private and rename it into place, so a concurrent
+	// build never sees a partly extracted directory
+	tmpDir, err := os.MkdirTemp(root, ".extract-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := extractEmbeddedSource(src, tmpDir); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// Another build extracted the same source first
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", fmt.Errorf("failed to move extracted source to %s: %w", dir, err)
+		}
+	} else if debug {
+		fmt.Printf("Extracted embedded source to %s\n", dir)
+	}
+
+	pruneSourceCache(root, dir, debug)
+	return dir, nil
+}
+
+// pruneSourceCache removes the directories under root other than keep that
+// haven't been used for sourceCacheMaxAge
+func pruneSourceCache(root, keep string, debug bool) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if path == keep || !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < sourceCacheMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil && !errors.Is(err, fs.ErrNotExist) && debug {
+			fmt.Printf("Warning: failed to remove old embedded source %s: %v\n", path, err)
+		}
+	}
+}
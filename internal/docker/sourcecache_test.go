@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"giverny"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSourceHash(t *testing.T) {
+	src := fstest.MapFS{
+		"go.mod":       {Data: []byte("module giverny\n")},
+		"cmd/main.go":  {Data: []byte("package main\n")},
+		"scripts/a.sh": {Data: []byte("echo a\n")},
+	}
+	hash, err := sourceHash(src)
+	if err != nil {
+		t.Fatalf("sourceHash failed: %v", err)
+	}
+	if again, _ := sourceHash(src); again != hash {
+		t.Errorf("sourceHash is not stable: %s != %s", again, hash)
+	}
+
+	// Changing a file's contents or moving it changes the hash
+	changed := fstest.MapFS{
+		"go.mod":       {Data: []byte("module giverny\n")},
+		"cmd/main.go":  {Data: []byte("package main // changed\n")},
+		"scripts/a.sh": {Data: []byte("echo a\n")},
+	}
+	moved := fstest.MapFS{
+		"go.mod":       {Data: []byte("module giverny\n")},
+		"cmd/main.go":  {Data: []byte("package main\n")},
+		"scripts/b.sh": {Data: []byte("echo a\n")},
+	}
+	for name, other := range map[string]fstest.MapFS{"changed": changed, "moved": moved} {
+		if otherHash, _ := sourceHash(other); otherHash == hash {
+			t.Errorf("%s: expected a different hash", name)
+		}
+	}
+}
+
+func TestExtractSourceTo(t *testing.T) {
+	root := t.TempDir()
+	src := fstest.MapFS{
+		"go.mod":      {Data: []byte("module giverny\n")},
+		"cmd/main.go": {Data: []byte("package main\n")},
+	}
+
+	dir, err := extractSourceTo(src, root, false)
+	if err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	if filepath.Dir(dir) != root {
+		t.Errorf("expected the source under %s, got %s", root, dir)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "cmd", "main.go"))
+	if err != nil || string(content) != "package main\n" {
+		t.Fatalf("expected cmd/main.go to be extracted, got %q, %v", content, err)
+	}
+
+	// The same source reuses the directory without extracting it again
+	marker := filepath.Join(dir, "marker")
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := extractSourceTo(src, root, false)
+	if err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	if again != dir {
+		t.Errorf("expected %s to be reused, got %s", dir, again)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the directory to be reused, not extracted again: %v", err)
+	}
+
+	// Different source goes in a different directory, and the old one is
+	// only removed once it hasn't been used for a while
+	src["go.mod"] = &fstest.MapFile{Data: []byte("module giverny // v2\n")}
+	newDir, err := extractSourceTo(src, root, false)
+	if err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	if newDir == dir {
+		t.Fatal("expected changed source to be extracted to a new directory")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected the recently used %s to be kept: %v", dir, err)
+	}
+	old := time.Now().Add(-sourceCacheMaxAge - time.Hour)
+	if err := os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	src["go.mod"] = &fstest.MapFile{Data: []byte("module giverny // v3\n")}
+	if _, err := extractSourceTo(src, root, false); err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the unused %s to be removed, got %v", dir, err)
+	}
+	if _, err := os.Stat(newDir); err != nil {
+		t.Errorf("expected the recently used %s to be kept: %v", newDir, err)
+	}
+
+	// No extraction directories are left behind
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 directories in %s, got %d", root, len(entries))
+	}
+}
+
+func TestExtractSourceTo_Embedded(t *testing.T) {
+	dir, err := extractSourceTo(giverny.Source, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("extractSourceTo failed: %v", err)
+	}
+	for _, file := range []string{"go.mod", "Makefile", "cmd/giverny/main.go", "scripts/giverny-shellrc.sh"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("expected %s in the build context: %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// mainPackageDir is the directory of the giverny command in the module
+const mainPackageDir = "cmd/giverny"
+
+// requiredSourceFiles are the files outside any package that the build uses
+var requiredSourceFiles = []string{"go.mod", "go.sum", "Makefile"}
+
+// copyPattern matches COPY instructions in the Dockerfile templates that copy
+// from the build context, rather than from another stage or context
+var copyPattern = regexp.MustCompile(`(?m)^COPY ([^-\s]\S*) `)
+
+// VerifyEmbeddedSource checks that src holds everything needed to build
+// giverny in the image: go.mod, go.sum and the Makefile, the giverny command
+// and every package of the module it imports, directly or indirectly, and
+// the files the Dockerfiles copy. Without this a directory missing from the
+// go:embed directive in source.go only shows up as a failed image build.
+func VerifyEmbeddedSource(src fs.FS) error {
+	var missing []string
+	for _, file := range requiredSourceFiles {
+		if _, err := fs.Stat(src, file); err != nil {
+			missing = append(missing, file)
+		}
+	}
+	for _, match := range copyPattern.FindAllStringSubmatch(dockerfileDepsTemplate+dockerfileMainTemplate, -1) {
+		if file := match[1]; file != "." && !slices.Contains(missing, file) {
+			if _, err := fs.Stat(src, file); err != nil {
+				missing = append(missing, file)
+			}
+		}
+	}
+
+	modulePath, err := embeddedModulePath(src)
+	if err != nil {
+		return err
+	}
+	missingPackages, err := missingModulePackages(src, modulePath)
+	if err != nil {
+		return err
+	}
+	missing = append(missing, missingPackages...)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("giverny was built with incomplete embedded source, missing %s: add them to the go:embed directive in source.go and rebuild giverny", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// embeddedModulePath returns the module path declared in src's go.mod
+func embeddedModulePath(src fs.FS) (string, error) {
+	f, err := src.Open("go.mod")
+	if err != nil {
+		return "", fmt.Errorf("giverny was built with incomplete embedded source, missing go.mod: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(rest), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("embedded go.mod has no module declaration")
+}
+
+// missingModulePackages follows the imports of the module's own packages in
+// src, starting from the giverny command, and returns the import paths of
+// those that aren't in src
+func missingModulePackages(src fs.FS, modulePath string) ([]string, error) {
+	var missing []string
+	seen := map[string]bool{}
+	queue := []string{mainPackageDir}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		imports, found, err := packageImports(src, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missing = append(missing, path.Join(modulePath, dir))
+			continue
+		}
+		for _, imp := range imports {
+			if imp == modulePath {
+				queue = append(queue, ".")
+			} else if rest, ok := strings.CutPrefix(imp, modulePath+"/"); ok {
+				queue = append(queue, rest)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing, nil
+}
+
+// packageImports returns the imports of the non-test Go files in dir, and
+// whether there are any such files
+func packageImports(src fs.FS, dir string) ([]string, bool, error) {
+	entries, err := fs.ReadDir(src, dir)
+	if err != nil {
+		return nil, false, nil
+	}
+	var imports []string
+	found := false
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		found = true
+		content, err := fs.ReadFile(src, path.Join(dir, name))
+		if err != nil {
+			return nil, false, err
+		}
+		file, err := parser.ParseFile(fset, path.Join(dir, name), content, parser.ImportsOnly)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse embedded %s: %w", path.Join(dir, name), err)
+		}
+		for _, spec := range file.Imports {
+			if imp, err := strconv.Unquote(spec.Path.Value); err == nil && !slices.Contains(imports, imp) {
+				imports = append(imports, imp)
+			}
+		}
+	}
+	return imports, found, nil
+}
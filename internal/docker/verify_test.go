@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"giverny"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestVerifyEmbeddedSource(t *testing.T) {
+	// The source embedded in giverny itself must be complete, or the image
+	// build fails in the container
+	if err := VerifyEmbeddedSource(giverny.Source); err != nil {
+		t.Fatalf("VerifyEmbeddedSource(giverny.Source) failed: %v", err)
+	}
+}
+
+func TestVerifyEmbeddedSource_Missing(t *testing.T) {
+	complete := func() fstest.MapFS {
+		src := fstest.MapFS{
+			"go.mod":    {Data: []byte("module giverny\n\ngo 1.24\n")},
+			"go.sum":    {Data: []byte("")},
+			"Makefile":  {Data: []byte("build:\n")},
+			"source.go": {Data: []byte("package giverny\n")},
+			"cmd/giverny/main.go": {Data: []byte(`package main
+
+import (
+	"fmt"
+	"giverny"
+	"giverny/internal/outie"
+)
+`)},
+			"cmd/giverny/main_test.go": {Data: []byte("package main\n\nimport \"giverny/internal/testonly\"\n")},
+			"internal/outie/outie.go":  {Data: []byte("package outie\n\nimport \"giverny/internal/git\"\n")},
+			"internal/git/git.go":      {Data: []byte("package git\n")},
+		}
+		for _, match := range copyPattern.FindAllStringSubmatch(dockerfileDepsTemplate+dockerfileMainTemplate, -1) {
+			if match[1] != "." {
+				src[match[1]] = &fstest.MapFile{Data: []byte("#!/bin/sh\n")}
+			}
+		}
+		return src
+	}
+	if err := VerifyEmbeddedSource(complete()); err != nil {
+		t.Fatalf("expected complete source to verify, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		remove  string
+		missing string
+	}{
+		{"go.sum", "go.sum", "go.sum"},
+		{"Makefile", "Makefile", "Makefile"},
+		{"script", "scripts/giverny-session.sh", "scripts/giverny-session.sh"},
+		{"main package", "cmd/giverny/main.go", "giverny/cmd/giverny"},
+		{"imported package", "internal/outie/outie.go", "giverny/internal/outie"},
+		{"indirectly imported package", "internal/git/git.go", "giverny/internal/git"},
+		{"root package", "source.go", "missing giverny:"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := complete()
+			delete(src, tt.remove)
+			err := VerifyEmbeddedSource(src)
+			if err == nil {
+				t.Fatalf("expected an error without %s", tt.remove)
+			}
+			if !strings.Contains(err.Error(), tt.missing) || !strings.Contains(err.Error(), "go:embed") {
+				t.Errorf("expected the error to name %s and the go:embed directive, got %v", tt.missing, err)
+			}
+		})
+	}
+}
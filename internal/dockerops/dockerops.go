@@ -1,18 +1,62 @@
 package dockerops
 
-import "giverny/internal/docker"
+import (
+	"giverny/internal/devcontainer"
+	"giverny/internal/docker"
+	"giverny/internal/taskspec"
+)
 
 // DockerOps defines the interface for all Docker operations needed by outie.
 // This interface allows for mocking Docker operations in tests.
 type DockerOps interface {
 	// BuildImage builds the giverny Docker images (deps and main)
-	BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool) error
+	BuildImage(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error
 
 	// RunContainer runs the giverny container and returns the exit code
-	RunContainer(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error)
+	RunContainer(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error)
+
+	// RunContainerAttachable runs the giverny container with a TTY that can be
+	// attached to later, and returns the exit code
+	RunContainerAttachable(spec taskspec.Spec, baseImage, dockerArgs string) (int, error)
 
 	// RemoveContainer removes a Docker container by name
 	RemoveContainer(containerName string) error
+
+	// InspectContainer returns details of an existing container, or nil if it doesn't exist
+	InspectContainer(containerName string) (*docker.ContainerInfo, error)
+
+	// StartContainer restarts an existing container and returns the exit code
+	StartContainer(containerName string, output docker.OutputOptions) (int, error)
+	// CopyFromContainer copies a file out of a container to the host
+	CopyFromContainer(containerName, srcPath, dstPath string) error
+	// SetGitServerPort tells a stopped container the port of its git server
+	SetGitServerPort(containerName string, port int) error
+	// ImageDigest returns the digest or ID of a local image
+	ImageDigest(imageName string) (string, error)
+	// ScanImage scans a local image for known vulnerabilities
+	ScanImage(imageName string) (*docker.ScanResult, error)
+	// GenerateSBOM writes a software bill of materials for a local image to path
+	GenerateSBOM(imageName, format, path string) error
+	// InspectImage returns how a local giverny-main image was built
+	InspectImage(imageName string) (*docker.ImageInfo, error)
+	// PullImage pulls an image from a registry and tags it as a local image
+	PullImage(registryImage, localImage string) error
+	// PushImage pushes a local image to a registry
+	PushImage(localImage, registryImage string) error
+	// DevcontainerImage returns an image of the project's devcontainer
+	DevcontainerImage(dir string, showOutput, debug bool) (string, error)
+	// EnsureNetwork creates a Docker network if it doesn't exist
+	EnsureNetwork(network string, debug bool) error
+	// HostLoopbackReachable reports whether containers reach this host's loopback at host.docker.internal
+	HostLoopbackReachable() bool
+	// BridgeGateway returns this host's address on Docker's default bridge network, or ""
+	BridgeGateway() string
+	// HostUser returns the "UID:GID" root's files in bind mounts are given to, or "" if the runtime maps them itself
+	HostUser() string
+	// StartGitSidecar starts a container on a network that serves a repository with git daemon
+	StartGitSidecar(name, network, image, repoPath, token, branchName string, debug bool) error
+	// StopGitSidecar stops and removes a git server sidecar
+	StopGitSidecar(name string) error
 }
 
 // RealDockerOps implements DockerOps using the actual docker package functions
@@ -24,16 +68,106 @@ func NewRealDockerOps() *RealDockerOps {
 }
 
 // BuildImage builds the giverny Docker images
-func (d *RealDockerOps) BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
-	return docker.BuildImage(baseImage, showOutput, forceRebuild, debug)
+func (d *RealDockerOps) BuildImage(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+	return docker.BuildImage(baseImage, opts, showOutput, forceRebuild, debug)
 }
 
 // RunContainer runs the giverny container
-func (d *RealDockerOps) RunContainer(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
-	return docker.RunContainer(taskID, slug, prompt, baseImage, gitPort, dockerArgs, agentArgs, debug, useAmp)
+func (d *RealDockerOps) RunContainer(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+	return docker.RunContainer(spec, baseImage, dockerArgs, output)
+}
+
+// RunContainerAttachable runs the giverny container with a TTY that can be attached to later
+func (d *RealDockerOps) RunContainerAttachable(spec taskspec.Spec, baseImage, dockerArgs string) (int, error) {
+	return docker.RunContainerAttachable(spec, baseImage, dockerArgs)
 }
 
 // RemoveContainer removes a Docker container
 func (d *RealDockerOps) RemoveContainer(containerName string) error {
 	return docker.RemoveContainer(containerName)
 }
+
+// InspectContainer returns details of an existing container
+func (d *RealDockerOps) InspectContainer(containerName string) (*docker.ContainerInfo, error) {
+	return docker.InspectContainer(containerName)
+}
+
+// StartContainer restarts an existing container
+func (d *RealDockerOps) StartContainer(containerName string, output docker.OutputOptions) (int, error) {
+	return docker.StartContainer(containerName, output)
+}
+
+// ImageDigest returns the digest or ID of a local image
+func (d *RealDockerOps) ImageDigest(imageName string) (string, error) {
+	return docker.ImageDigest(imageName)
+}
+
+// CopyFromContainer copies a file out of a container to the host
+func (d *RealDockerOps) CopyFromContainer(containerName, srcPath, dstPath string) error {
+	return docker.CopyFromContainer(containerName, srcPath, dstPath)
+}
+
+// SetGitServerPort tells a stopped container the port of its git server
+func (d *RealDockerOps) SetGitServerPort(containerName string, port int) error {
+	return docker.SetGitServerPort(containerName, port)
+}
+
+// ScanImage scans a local image for known vulnerabilities
+func (d *RealDockerOps) ScanImage(imageName string) (*docker.ScanResult, error) {
+	return docker.ScanImage(imageName)
+}
+
+// GenerateSBOM writes a software bill of materials for a local image to path
+func (d *RealDockerOps) GenerateSBOM(imageName, format, path string) error {
+	return docker.GenerateSBOM(imageName, format, path)
+}
+
+// InspectImage returns how a local giverny-main image was built
+func (d *RealDockerOps) InspectImage(imageName string) (*docker.ImageInfo, error) {
+	return docker.InspectImage(imageName)
+}
+
+// PullImage pulls an image from a registry and tags it as a local image
+func (d *RealDockerOps) PullImage(registryImage, localImage string) error {
+	return docker.PullImage(registryImage, localImage)
+}
+
+// PushImage pushes a local image to a registry
+func (d *RealDockerOps) PushImage(localImage, registryImage string) error {
+	return docker.PushImage(localImage, registryImage)
+}
+
+// DevcontainerImage returns an image of the project's devcontainer
+func (d *RealDockerOps) DevcontainerImage(dir string, showOutput, debug bool) (string, error) {
+	return devcontainer.BaseImage(dir, showOutput, debug)
+}
+
+// EnsureNetwork creates a Docker network if it doesn't exist
+func (d *RealDockerOps) EnsureNetwork(network string, debug bool) error {
+	return docker.EnsureNetwork(network, debug)
+}
+
+// HostLoopbackReachable reports whether containers reach this host's loopback at host.docker.internal
+func (d *RealDockerOps) HostLoopbackReachable() bool {
+	return docker.HostLoopbackReachable()
+}
+
+// BridgeGateway returns this host's address on Docker's default bridge network
+func (d *RealDockerOps) BridgeGateway() string {
+	return docker.BridgeGateway()
+}
+
+// HostUser returns the "UID:GID" root's files in bind mounts are given to
+func (d *RealDockerOps) HostUser() string {
+	return docker.HostUser()
+}
+
+// StartGitSidecar starts a git server sidecar
+func (d *RealDockerOps) StartGitSidecar(name, network, image, repoPath, token, branchName string, debug bool) error {
+	return docker.StartGitSidecar(name, network, image, repoPath, token, branchName, debug)
+}
+
+// StopGitSidecar stops a git server sidecar
+func (d *RealDockerOps) StopGitSidecar(name string) error {
+	return docker.StopGitSidecar(name)
+}
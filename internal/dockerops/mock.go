@@ -1,39 +1,206 @@
 package dockerops
 
+import (
+	"giverny/internal/docker"
+	"giverny/internal/taskspec"
+)
+
 // MockDockerOps is a mock implementation of DockerOps for testing
 type MockDockerOps struct {
 	// Function stubs that can be set in tests
-	BuildImageFunc      func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error
-	RunContainerFunc    func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error)
-	RemoveContainerFunc func(containerName string) error
+	BuildImageFunc             func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error
+	RunContainerFunc           func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error)
+	RunContainerAttachableFunc func(spec taskspec.Spec, baseImage, dockerArgs string) (int, error)
+	RemoveContainerFunc        func(containerName string) error
+	InspectContainerFunc       func(containerName string) (*docker.ContainerInfo, error)
+	StartContainerFunc         func(containerName string, output docker.OutputOptions) (int, error)
+	ImageDigestFunc            func(imageName string) (string, error)
+	CopyFromContainerFunc      func(containerName, srcPath, dstPath string) error
+	SetGitServerPortFunc       func(containerName string, port int) error
+	ScanImageFunc              func(imageName string) (*docker.ScanResult, error)
+	GenerateSBOMFunc           func(imageName, format, path string) error
+	InspectImageFunc           func(imageName string) (*docker.ImageInfo, error)
+	PullImageFunc              func(registryImage, localImage string) error
+	PushImageFunc              func(localImage, registryImage string) error
+	DevcontainerImageFunc      func(dir string, showOutput, debug bool) (string, error)
+	EnsureNetworkFunc          func(network string, debug bool) error
+	HostLoopbackReachableFunc  func() bool
+	BridgeGatewayFunc          func() string
+	HostUserFunc               func() string
+	StartGitSidecarFunc        func(name, network, image, repoPath, token, branchName string, debug bool) error
+	StopGitSidecarFunc         func(name string) error
 }
 
 // NewMockDockerOps creates a new MockDockerOps with default no-op implementations
 func NewMockDockerOps() *MockDockerOps {
 	return &MockDockerOps{
-		BuildImageFunc: func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+		BuildImageFunc: func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 			return nil
 		},
-		RunContainerFunc: func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
+		RunContainerFunc: func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			return 0, nil
+		},
+		RunContainerAttachableFunc: func(spec taskspec.Spec, baseImage, dockerArgs string) (int, error) {
 			return 0, nil
 		},
 		RemoveContainerFunc: func(containerName string) error {
 			return nil
 		},
+		InspectContainerFunc: func(containerName string) (*docker.ContainerInfo, error) {
+			return nil, nil
+		},
+		StartContainerFunc: func(containerName string, output docker.OutputOptions) (int, error) {
+			return 0, nil
+		},
+		ImageDigestFunc: func(imageName string) (string, error) {
+			return imageName + "@sha256:0000", nil
+		},
+		CopyFromContainerFunc: func(containerName, srcPath, dstPath string) error {
+			return nil
+		},
+		SetGitServerPortFunc: func(containerName string, port int) error {
+			return nil
+		},
+		ScanImageFunc: func(imageName string) (*docker.ScanResult, error) {
+			return &docker.ScanResult{Scanner: "mock"}, nil
+		},
+		GenerateSBOMFunc: func(imageName, format, path string) error {
+			return nil
+		},
+		InspectImageFunc: func(imageName string) (*docker.ImageInfo, error) {
+			return &docker.ImageInfo{ID: "sha256:0000"}, nil
+		},
+		PullImageFunc: func(registryImage, localImage string) error {
+			return nil
+		},
+		PushImageFunc: func(localImage, registryImage string) error {
+			return nil
+		},
+		DevcontainerImageFunc: func(dir string, showOutput, debug bool) (string, error) {
+			return "giverny-devcontainer-test:latest", nil
+		},
+		EnsureNetworkFunc: func(network string, debug bool) error {
+			return nil
+		},
+		HostLoopbackReachableFunc: func() bool {
+			return true
+		},
+		BridgeGatewayFunc: func() string {
+			return ""
+		},
+		HostUserFunc: func() string {
+			return ""
+		},
+		StartGitSidecarFunc: func(name, network, image, repoPath, token, branchName string, debug bool) error {
+			return nil
+		},
+		StopGitSidecarFunc: func(name string) error {
+			return nil
+		},
 	}
 }
 
 // BuildImage calls the mock function
-func (m *MockDockerOps) BuildImage(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
-	return m.BuildImageFunc(baseImage, showOutput, forceRebuild, debug)
+func (m *MockDockerOps) BuildImage(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+	return m.BuildImageFunc(baseImage, opts, showOutput, forceRebuild, debug)
 }
 
 // RunContainer calls the mock function
-func (m *MockDockerOps) RunContainer(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
-	return m.RunContainerFunc(taskID, slug, prompt, baseImage, gitPort, dockerArgs, agentArgs, debug, useAmp)
+func (m *MockDockerOps) RunContainer(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+	return m.RunContainerFunc(spec, baseImage, dockerArgs, output)
+}
+
+// RunContainerAttachable calls the mock function
+func (m *MockDockerOps) RunContainerAttachable(spec taskspec.Spec, baseImage, dockerArgs string) (int, error) {
+	return m.RunContainerAttachableFunc(spec, baseImage, dockerArgs)
 }
 
 // RemoveContainer calls the mock function
 func (m *MockDockerOps) RemoveContainer(containerName string) error {
 	return m.RemoveContainerFunc(containerName)
 }
+
+// InspectContainer calls the mock function
+func (m *MockDockerOps) InspectContainer(containerName string) (*docker.ContainerInfo, error) {
+	return m.InspectContainerFunc(containerName)
+}
+
+// StartContainer calls the mock function
+func (m *MockDockerOps) StartContainer(containerName string, output docker.OutputOptions) (int, error) {
+	return m.StartContainerFunc(containerName, output)
+}
+
+// ImageDigest calls the mock function
+func (m *MockDockerOps) ImageDigest(imageName string) (string, error) {
+	return m.ImageDigestFunc(imageName)
+}
+
+// CopyFromContainer calls the mock function
+func (m *MockDockerOps) CopyFromContainer(containerName, srcPath, dstPath string) error {
+	return m.CopyFromContainerFunc(containerName, srcPath, dstPath)
+}
+
+// SetGitServerPort calls the mock function
+func (m *MockDockerOps) SetGitServerPort(containerName string, port int) error {
+	return m.SetGitServerPortFunc(containerName, port)
+}
+
+// ScanImage calls the mock function
+func (m *MockDockerOps) ScanImage(imageName string) (*docker.ScanResult, error) {
+	return m.ScanImageFunc(imageName)
+}
+
+// GenerateSBOM calls the mock function
+func (m *MockDockerOps) GenerateSBOM(imageName, format, path string) error {
+	return m.GenerateSBOMFunc(imageName, format, path)
+}
+
+// InspectImage calls the mock function
+func (m *MockDockerOps) InspectImage(imageName string) (*docker.ImageInfo, error) {
+	return m.InspectImageFunc(imageName)
+}
+
+// PullImage calls the mock function
+func (m *MockDockerOps) PullImage(registryImage, localImage string) error {
+	return m.PullImageFunc(registryImage, localImage)
+}
+
+// PushImage calls the mock function
+func (m *MockDockerOps) PushImage(localImage, registryImage string) error {
+	return m.PushImageFunc(localImage, registryImage)
+}
+
+// DevcontainerImage calls the mock function
+func (m *MockDockerOps) DevcontainerImage(dir string, showOutput, debug bool) (string, error) {
+	return m.DevcontainerImageFunc(dir, showOutput, debug)
+}
+
+// HostLoopbackReachable calls the mock function
+func (m *MockDockerOps) HostLoopbackReachable() bool {
+	return m.HostLoopbackReachableFunc()
+}
+
+// BridgeGateway calls the mock function
+func (m *MockDockerOps) BridgeGateway() string {
+	return m.BridgeGatewayFunc()
+}
+
+// HostUser calls the mock function
+func (m *MockDockerOps) HostUser() string {
+	return m.HostUserFunc()
+}
+
+// EnsureNetwork calls the mock function
+func (m *MockDockerOps) EnsureNetwork(network string, debug bool) error {
+	return m.EnsureNetworkFunc(network, debug)
+}
+
+// StartGitSidecar calls the mock function
+func (m *MockDockerOps) StartGitSidecar(name, network, image, repoPath, token, branchName string, debug bool) error {
+	return m.StartGitSidecarFunc(name, network, image, repoPath, token, branchName, debug)
+}
+
+// StopGitSidecar calls the mock function
+func (m *MockDockerOps) StopGitSidecar(name string) error {
+	return m.StopGitSidecarFunc(name)
+}
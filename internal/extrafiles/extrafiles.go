@@ -0,0 +1,178 @@
+// Package extrafiles finds the files the agent wrote outside the workspace,
+// such as scripts in /tmp or notes in $HOME, which would otherwise be lost
+// with the container, and copies them somewhere they are kept.
+package extrafiles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExportDir is where files exported from the container are copied to, under
+// their path in the container, for outie to copy to the host when the
+// container exits
+const ExportDir = "/var/lib/giverny/extra-files"
+
+// skipDirNames are directories of caches and installed dependencies, which are
+// rewritten by the tools the agent runs and never its own work
+var skipDirNames = []string{".cache", ".npm", ".cargo", ".rustup", ".git", "node_modules", "__pycache__"}
+
+// Find returns the regular files under roots modified after since, in order,
+// leaving out those under skip and in cache directories. At most limit files
+// are returned, with truncated set if there were more. Roots that don't exist
+// are ignored.
+func Find(roots []string, since time.Time, skip []string, limit int) (files []string, truncated bool, err error) {
+	errLimit := errors.New("limit reached")
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+					return nil
+				}
+				return err
+			}
+			if skipped(path, skip) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && slices.Contains(skipDirNames, d.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if !info.ModTime().After(since) || slices.Contains(files, path) {
+				return nil
+			}
+			if len(files) == limit {
+				truncated = true
+				return errLimit
+			}
+			files = append(files, path)
+			return nil
+		})
+		if errors.Is(err, errLimit) {
+			break
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+	slices.Sort(files)
+	return files, truncated, nil
+}
+
+// skipped reports whether path is one of skip or under one of them
+func skipped(path string, skip []string) bool {
+	for _, s := range skip {
+		if path == s || strings.HasPrefix(path, strings.TrimSuffix(s, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RelPath returns file's path relative to the deepest of roots it is under,
+// or its path without the leading slash if it is under none
+func RelPath(roots []string, file string) string {
+	best := ""
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if skipped(file, []string{root}) && len(root) > len(best) {
+			best = root
+		}
+	}
+	if best == "" || best == file {
+		return strings.TrimPrefix(file, "/")
+	}
+	rel, err := filepath.Rel(best, file)
+	if err != nil {
+		return strings.TrimPrefix(file, "/")
+	}
+	return rel
+}
+
+// CopyInto copies each of files to dir, at its path relative to the root
+// under roots it was found in. It never overwrites a file already in dir;
+// those files are returned as skipped.
+func CopyInto(files, roots []string, dir string) (copied, skippedFiles []string, err error) {
+	for _, file := range files {
+		dst := filepath.Join(dir, RelPath(roots, file))
+		err := CopyFile(file, dst)
+		if errors.Is(err, fs.ErrExist) {
+			skippedFiles = append(skippedFiles, file)
+			continue
+		}
+		if err != nil {
+			return copied, skippedFiles, err
+		}
+		copied = append(copied, dst)
+	}
+	return copied, skippedFiles, nil
+}
+
+// Export copies files to ExportDir
+func Export(files []string) error {
+	return ExportTo(files, ExportDir)
+}
+
+// ExportTo copies each of files to dir under its full path, replacing any
+// copy exported before
+func ExportTo(files []string, dir string) error {
+	for _, file := range files {
+		dst := filepath.Join(dir, file)
+		if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to replace %s: %w", dst, err)
+		}
+		if err := CopyFile(file, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyFile copies the regular file src to dst with the same mode, creating
+// dst's directory. It fails with an error wrapping fs.ErrExist if dst exists.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dst, err)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
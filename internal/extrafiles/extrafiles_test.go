@@ -0,0 +1,148 @@
+package extrafiles
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeFile creates path with content, modified at mtime
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	since := time.Now().Add(-time.Hour)
+	before := since.Add(-time.Minute)
+	after := since.Add(time.Minute)
+
+	writeFile(t, filepath.Join(root, "tmp", "old.sh"), "old", before)
+	writeFile(t, filepath.Join(root, "tmp", "new.sh"), "new", after)
+	writeFile(t, filepath.Join(root, "tmp", "docs", "notes.md"), "notes", after)
+	writeFile(t, filepath.Join(root, "tmp", "node_modules", "dep.js"), "dep", after)
+	writeFile(t, filepath.Join(root, "home", ".cache", "pip", "wheel"), "cached", after)
+	writeFile(t, filepath.Join(root, "home", "app", "main.go"), "workspace", after)
+	writeFile(t, filepath.Join(root, "home", "script.py"), "script", after)
+
+	roots := []string{filepath.Join(root, "tmp"), filepath.Join(root, "home"), filepath.Join(root, "missing")}
+	skip := []string{filepath.Join(root, "home", "app")}
+	files, truncated, err := Find(roots, since, skip, 10)
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	want := []string{
+		filepath.Join(root, "home", "script.py"),
+		filepath.Join(root, "tmp", "docs", "notes.md"),
+		filepath.Join(root, "tmp", "new.sh"),
+	}
+	if !slices.Equal(files, want) || truncated {
+		t.Errorf("Find = %v, %v, want %v, false", files, truncated, want)
+	}
+
+	// A root under another is not listed twice
+	files, _, err = Find(append(roots, filepath.Join(root, "tmp", "docs")), since, skip, 10)
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("Find with nested roots = %v, want %v", files, want)
+	}
+
+	files, truncated, err = Find(roots, since, skip, 2)
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(files) != 2 || !truncated {
+		t.Errorf("Find with limit 2 = %v, %v, want 2 files, true", files, truncated)
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	roots := []string{"/tmp", "/root", "/root/notes/"}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"/tmp/run.sh", "run.sh"},
+		{"/tmp/scripts/run.sh", "scripts/run.sh"},
+		{"/root/notes/plan.md", "plan.md"},
+		{"/tmpfile", "tmpfile"},
+		{"/srv/data.csv", "srv/data.csv"},
+	}
+	for _, tt := range tests {
+		if got := RelPath(roots, tt.file); got != tt.want {
+			t.Errorf("RelPath(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "tmp")
+	workspace := filepath.Join(root, "app")
+	now := time.Now()
+	writeFile(t, filepath.Join(src, "scripts", "run.sh"), "#!/bin/sh\n", now)
+	writeFile(t, filepath.Join(src, "README.md"), "from the agent", now)
+	writeFile(t, filepath.Join(workspace, "README.md"), "the project's", now)
+
+	files := []string{filepath.Join(src, "README.md"), filepath.Join(src, "scripts", "run.sh")}
+	copied, skipped, err := CopyInto(files, []string{src}, workspace)
+	if err != nil {
+		t.Fatalf("CopyInto failed: %v", err)
+	}
+	if want := []string{filepath.Join(workspace, "scripts", "run.sh")}; !slices.Equal(copied, want) {
+		t.Errorf("copied = %v, want %v", copied, want)
+	}
+	if want := []string{filepath.Join(src, "README.md")}; !slices.Equal(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, "README.md")); string(data) != "the project's" {
+		t.Errorf("CopyInto overwrote README.md with %q", data)
+	}
+}
+
+func TestExportTo(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "tmp", "notes.md")
+	exportDir := filepath.Join(root, "export")
+	writeFile(t, file, "first", time.Now())
+
+	if err := ExportTo([]string{file}, exportDir); err != nil {
+		t.Fatalf("ExportTo failed: %v", err)
+	}
+	// Exporting again replaces the earlier copy
+	writeFile(t, file, "second", time.Now())
+	if err := ExportTo([]string{file}, exportDir); err != nil {
+		t.Fatalf("ExportTo failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(exportDir, file))
+	if err != nil {
+		t.Fatalf("exported file missing: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("exported file = %q, want %q", data, "second")
+	}
+}
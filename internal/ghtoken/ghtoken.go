@@ -0,0 +1,251 @@
+// Package ghtoken provides short-lived GitHub tokens that can only read the
+// repositories a task is given, so that users don't have to hand the
+// container a long-lived personal token.
+package ghtoken
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables that configure where tokens come from: a GitHub App
+// installed on the repositories' owner, or else a fine-grained personal
+// access token
+const (
+	AppIDEnv      = "GIVERNY_GITHUB_APP_ID"
+	AppKeyFileEnv = "GIVERNY_GITHUB_APP_KEY_FILE"
+	PATEnv        = "GIVERNY_GITHUB_PAT"
+)
+
+// Host is the git host the tokens are for
+const Host = "github.com"
+
+// Username is the username git uses with the tokens
+const Username = "x-access-token"
+
+// DefaultAPIURL is the GitHub REST API
+const DefaultAPIURL = "https://api.github.com"
+
+// refreshBefore is how long before a token expires it is replaced
+const refreshBefore = 5 * time.Minute
+
+// repoPattern matches OWNER/REPO
+var repoPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+// ValidateRepos checks that repos are OWNER/REPO names with a single owner,
+// as an installation token can only cover one
+func ValidateRepos(repos []string) error {
+	var owner string
+	for _, repo := range repos {
+		if !repoPattern.MatchString(repo) {
+			return fmt.Errorf("'%s' is not an OWNER/REPO name", repo)
+		}
+		repoOwner, _, _ := strings.Cut(repo, "/")
+		if owner != "" && !strings.EqualFold(repoOwner, owner) {
+			return fmt.Errorf("the repositories must all belong to one owner, got %s and %s", owner, repoOwner)
+		}
+		owner = repoOwner
+	}
+	return nil
+}
+
+// Source gives out a token that can read repos, minting a new one when the
+// last is about to expire
+type Source struct {
+	repos  []string
+	apiURL string
+	appID  string
+	key    *rsa.PrivateKey
+	pat    string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewSource returns a Source for repos configured from the environment. A
+// GitHub App (AppIDEnv and AppKeyFileEnv) is used if it is set up, otherwise
+// a fine-grained personal access token (PATEnv), which the user is trusted to
+// have scoped to the repositories.
+func NewSource(repos []string, apiURL string) (*Source, error) {
+	if err := ValidateRepos(repos); err != nil {
+		return nil, err
+	}
+	s := &Source{repos: repos, apiURL: strings.TrimSuffix(apiURL, "/")}
+
+	if appID := os.Getenv(AppIDEnv); appID != "" {
+		keyFile := os.Getenv(AppKeyFileEnv)
+		if keyFile == "" {
+			return nil, fmt.Errorf("%s is set but %s is not", AppIDEnv, AppKeyFileEnv)
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App key: %w", err)
+		}
+		key, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse GitHub App key %s: %w", keyFile, err)
+		}
+		s.appID, s.key = appID, key
+		return s, nil
+	}
+
+	pat := os.Getenv(PATEnv)
+	if pat == "" {
+		return nil, fmt.Errorf("set %s and %s to a GitHub App installed on the repositories, or %s to a fine-grained personal access token", AppIDEnv, AppKeyFileEnv, PATEnv)
+	}
+	if !strings.HasPrefix(pat, "github_pat_") {
+		// Classic tokens can't be limited to repositories
+		return nil, fmt.Errorf("%s must be a fine-grained personal access token (github_pat_...)", PATEnv)
+	}
+	s.pat = pat
+	return s, nil
+}
+
+// Token returns a token that can read the repositories, valid for at least a
+// few more minutes
+func (s *Source) Token() (string, error) {
+	if s.pat != "" {
+		return s.pat, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > refreshBefore {
+		return s.token, nil
+	}
+	token, expires, err := s.mint()
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, expires
+	return token, nil
+}
+
+// mint creates an installation token of the GitHub App that can only read
+// the contents of the repositories
+func (s *Source) mint() (string, time.Time, error) {
+	jwt, err := s.appJWT(time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := s.call(http.MethodGet, "/repos/"+s.repos[0]+"/installation", jwt, nil, &installation); err != nil {
+		return "", time.Time{}, fmt.Errorf("GitHub App %s is not installed on %s: %w", s.appID, s.repos[0], err)
+	}
+
+	request := struct {
+		Repositories []string          `json:"repositories"`
+		Permissions  map[string]string `json:"permissions"`
+	}{Permissions: map[string]string{"contents": "read", "metadata": "read"}}
+	for _, repo := range s.repos {
+		_, name, _ := strings.Cut(repo, "/")
+		request.Repositories = append(request.Repositories, name)
+	}
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installation.ID)
+	if err := s.call(http.MethodPost, path, jwt, request, &response); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create GitHub token: %w", err)
+	}
+	return response.Token, response.ExpiresAt, nil
+}
+
+// call makes a GitHub API request authenticated as the app and decodes the
+// JSON response into out
+func (s *Source) call(method, path, jwt string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, apiErr.Message)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// appJWT returns the JSON Web Token that authenticates as the GitHub App,
+// valid for ten minutes
+func (s *Source) appJWT(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		// Allow for the clocks being a little apart
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App token: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseKey reads a GitHub App private key, which GitHub gives out as PKCS #1
+// PEM
+func parseKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+	return rsaKey, nil
+}
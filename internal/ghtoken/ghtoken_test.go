@@ -0,0 +1,135 @@
+package ghtoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestValidateRepos(t *testing.T) {
+	if err := ValidateRepos([]string{"acme/api", "Acme/web.site"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, repos := range [][]string{{"acme"}, {"https://github.com/acme/api"}, {"acme/api", "other/web"}} {
+		if err := ValidateRepos(repos); err == nil {
+			t.Errorf("ValidateRepos(%v) = nil, want an error", repos)
+		}
+	}
+}
+
+func TestNewSourcePAT(t *testing.T) {
+	t.Setenv(AppIDEnv, "")
+	t.Setenv(PATEnv, "")
+	if _, err := NewSource([]string{"acme/api"}, DefaultAPIURL); err == nil {
+		t.Error("expected an error with nothing configured")
+	}
+
+	t.Setenv(PATEnv, "ghp_classic")
+	if _, err := NewSource([]string{"acme/api"}, DefaultAPIURL); err == nil {
+		t.Error("expected a classic token to be refused")
+	}
+
+	t.Setenv(PATEnv, "github_pat_abc")
+	s, err := NewSource([]string{"acme/api"}, DefaultAPIURL)
+	if err != nil {
+		t.Fatalf("NewSource failed: %v", err)
+	}
+	if token, err := s.Token(); err != nil || token != "github_pat_abc" {
+		t.Errorf("Token() = %q, %v", token, err)
+	}
+}
+
+func TestAppToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	minted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if len(parts) != 3 || !strings.Contains(string(claims), `"iss":"123"`) {
+			http.Error(w, `{"message":"bad JWT"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/acme/api/installation":
+			w.Write([]byte(`{"id": 42}`))
+		case "/app/installations/42/access_tokens":
+			var request struct {
+				Repositories []string          `json:"repositories"`
+				Permissions  map[string]string `json:"permissions"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			if strings.Join(request.Repositories, ",") != "api,web" || request.Permissions["contents"] != "read" {
+				http.Error(w, `{"message":"unexpected request"}`, http.StatusUnprocessableEntity)
+				return
+			}
+			minted++
+			// The second token is about to expire, so it is replaced
+			expires := time.Now().Add(time.Hour)
+			if minted == 2 {
+				expires = time.Now().Add(time.Minute)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"token": "ghs_" + string(rune('0'+minted)), "expires_at": expires})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv(AppIDEnv, "123")
+	t.Setenv(AppKeyFileEnv, keyFile)
+	s, err := NewSource([]string{"acme/api", "acme/web"}, server.URL)
+	if err != nil {
+		t.Fatalf("NewSource failed: %v", err)
+	}
+	for _, want := range []string{"ghs_1", "ghs_1"} {
+		if token, err := s.Token(); err != nil || token != want {
+			t.Fatalf("Token() = %q, %v, want %s", token, err, want)
+		}
+	}
+	s.expires = time.Now()
+	if token, _ := s.Token(); token != "ghs_2" {
+		t.Errorf("expected an expired token to be replaced, got %q", token)
+	}
+	if token, _ := s.Token(); token != "ghs_3" {
+		t.Errorf("expected a token about to expire to be replaced, got %q", token)
+	}
+
+	other, err := NewSource([]string{"other/repo"}, server.URL)
+	if err != nil {
+		t.Fatalf("NewSource failed: %v", err)
+	}
+	if _, err := other.Token(); err == nil || !strings.Contains(err.Error(), "not installed on other/repo") {
+		t.Errorf("expected an error for a repository the app is not installed on, got %v", err)
+	}
+}
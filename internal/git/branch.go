@@ -8,11 +8,22 @@ import (
 	"giverny/internal/cmdutil"
 )
 
-// CreateBranch creates a new git branch at the current HEAD without checking it out.
+// CreateBranch creates a new git branch without checking it out. The branch
+// starts at startPoint (a branch, tag, commit or remote-tracking ref), or at
+// the current HEAD if startPoint is empty.
 // Returns an error if the branch already exists or if git command fails.
-func CreateBranch(branchName string) error {
+func CreateBranch(branchName, startPoint string) error {
+	return CreateBranchInDir(".", branchName, startPoint)
+}
+
+// CreateBranchInDir is CreateBranch for the repository at dir.
+func CreateBranchInDir(dir, branchName, startPoint string) error {
 	// Create the branch without checking it out
-	cmd := exec.Command("git", "branch", branchName)
+	args := []string{"-C", dir, "branch", "--no-track", branchName}
+	if startPoint != "" {
+		args = append(args, startPoint)
+	}
+	cmd := exec.Command("git", args...)
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -20,6 +31,10 @@ func CreateBranch(branchName string) error {
 		if strings.Contains(string(output), "already exists") {
 			return fmt.Errorf("branch '%s' already exists", branchName)
 		}
+		// Check if the start point could not be resolved
+		if strings.Contains(string(output), "not a valid object name") {
+			return fmt.Errorf("'%s' is not a valid ref to create branch '%s' from", startPoint, branchName)
+		}
 		return fmt.Errorf("failed to create branch '%s': %s", branchName, strings.TrimSpace(string(output)))
 	}
 
@@ -29,7 +44,12 @@ func CreateBranch(branchName string) error {
 // BranchExists checks if a git branch exists.
 // Returns true if the branch exists, false otherwise.
 func BranchExists(branchName string) (bool, error) {
-	cmd := exec.Command("git", "rev-parse", "--verify", branchName)
+	return BranchExistsInDir(".", branchName)
+}
+
+// BranchExistsInDir is BranchExists for the repository at dir.
+func BranchExistsInDir(dir, branchName string) (bool, error) {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "--verify", branchName)
 	err := cmd.Run()
 
 	if err != nil {
@@ -43,29 +63,69 @@ func BranchExists(branchName string) (bool, error) {
 	return true, nil
 }
 
+// StartLabel returns the name of the label branch that marks where work on
+// branchName started inside the container.
+func StartLabel(branchName string) string {
+	return branchName + "-START"
+}
+
+// DefaultBranch returns the repository's default branch. It checks, in order:
+// 1. The branch origin/HEAD points at
+// 2. The init.defaultBranch setting, if that branch exists
+// 3. 'main' or 'master', whichever exists
+// and falls back to 'main' if none of these can be found.
+func DefaultBranch() string {
+	return DefaultBranchInDir(".")
+}
+
+// DefaultBranchInDir is DefaultBranch for the repository at dir.
+func DefaultBranchInDir(dir string) string {
+	if ref, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"); err == nil && ref != "" {
+		return strings.TrimPrefix(ref, "origin/")
+	}
+
+	candidates := []string{"main", "master"}
+	if configured, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "config", "--get", "init.defaultBranch"); err == nil && configured != "" {
+		candidates = append([]string{configured}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if err := cmdutil.RunCommand("git", "-C", dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return "main"
+}
+
 // GetBranchCommitRange returns the first and last commit hashes for a branch.
 // Returns empty strings if the branch has no commits beyond its divergence point.
 //
 // The function tries multiple strategies to find the commit range:
 // 1. If a START label exists (branchName-START), use commits after that label
-// 2. Otherwise, find where the branch diverged from 'main' using merge-base
+// 2. Otherwise, find where the branch diverged from baseBranch using merge-base
 //
-// This always compares against 'main' regardless of upstream tracking settings,
-// ensuring cherry-pick instructions are relative to the main branch.
-func GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, err error) {
+// If baseBranch is empty the repository's default branch is used (see
+// DefaultBranch). Upstream tracking settings are ignored, ensuring cherry-pick
+// instructions are relative to the base branch.
+func GetBranchCommitRange(branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
+	return GetBranchCommitRangeInDir(".", branchName, baseBranch)
+}
+
+// GetBranchCommitRangeInDir is GetBranchCommitRange for the repository at dir.
+func GetBranchCommitRangeInDir(dir, branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
 	// Get the last commit (HEAD of the branch)
-	lastCommit, err = cmdutil.RunCommandWithOutput("git", "rev-parse", branchName)
+	lastCommit, err = cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", branchName)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get last commit for branch '%s': %w", branchName, err)
 	}
 
 	// Strategy 1: Check if START label exists (used inside containers)
-	startLabel := branchName + "-START"
-	startCommit, err := cmdutil.RunCommandWithOutput("git", "rev-parse", "--verify", startLabel)
+	startLabel := StartLabel(branchName)
+	startCommit, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", startLabel)
 	if err == nil {
 		// START label exists, get the first commit after it
 		// Check if there are any commits between START and the branch HEAD
-		commits, err := cmdutil.RunCommandWithOutput("git", "rev-list", "--reverse", startCommit+".."+branchName)
+		commits, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-list", "--reverse", startCommit+".."+branchName)
 		if err != nil {
 			return "", "", fmt.Errorf("failed to get commits after START label: %w", err)
 		}
@@ -82,13 +142,17 @@ func GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, er
 	}
 
 	// Strategy 2: Find divergence point using merge-base with parent branch
-	// Always use 'main' as the parent branch for cherry-pick instructions.
-	// This ensures users get instructions to cherry-pick commits from the task
-	// branch into their main branch, regardless of upstream tracking settings.
-	parentBranch := "main"
+	// Use the base branch (not the upstream) as the parent for cherry-pick
+	// instructions. This ensures users get instructions to cherry-pick commits
+	// from the task branch into their base branch, regardless of upstream
+	// tracking settings.
+	parentBranch := baseBranch
+	if parentBranch == "" {
+		parentBranch = DefaultBranchInDir(dir)
+	}
 
 	// Find the merge-base (common ancestor) between the branch and its parent
-	mergeBase, err := cmdutil.RunCommandWithOutput("git", "merge-base", parentBranch, branchName)
+	mergeBase, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "merge-base", parentBranch, branchName)
 	if err != nil {
 		// If merge-base fails, the branches may not share history
 		// Fall back to returning empty (no commits to cherry-pick)
@@ -102,7 +166,7 @@ func GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, er
 	}
 
 	// Get all commits from merge-base to branch HEAD
-	commits, err := cmdutil.RunCommandWithOutput("git", "rev-list", "--reverse", mergeBase+".."+branchName)
+	commits, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-list", "--reverse", mergeBase+".."+branchName)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get commits after merge-base: %w", err)
 	}
@@ -118,13 +182,196 @@ func GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, er
 	return firstCommit, lastCommit, nil
 }
 
+// GetCommitHash resolves ref to a full commit hash
+func GetCommitHash(ref string) (string, error) {
+	return GetCommitHashInDir(".", ref)
+}
+
+// GetCommitHashInDir is GetCommitHash for the repository at dir.
+func GetCommitHashInDir(dir, ref string) (string, error) {
+	hash, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", ref, err)
+	}
+	return hash, nil
+}
+
 // GetShortHash converts a full git commit hash to its short form.
 // Returns the short hash (typically 7 characters) or the original hash if conversion fails.
 func GetShortHash(fullHash string) string {
-	shortHash, err := cmdutil.RunCommandWithOutput("git", "rev-parse", "--short", fullHash)
+	return GetShortHashInDir(".", fullHash)
+}
+
+// GetShortHashInDir is GetShortHash for the repository at dir.
+func GetShortHashInDir(dir, fullHash string) string {
+	shortHash, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--short", fullHash)
 	if err != nil {
 		// If we can't get the short hash, return the full hash
 		return fullHash
 	}
 	return shortHash
 }
+
+// SquashBranch collapses the commits from firstCommit to the head of branchName
+// into a single commit with the given message. The branch does not need to be
+// checked out: the new commit reuses the tree of the branch head and is parented
+// on firstCommit's parent, and the branch ref is updated to point at it. A
+// branch checked out in one of the repository's worktrees is not squashed, as
+// that would leave the worktree's index and files out of step with it.
+// Returns the hash of the new commit.
+func SquashBranch(branchName, firstCommit, message string) (string, error) {
+	return SquashBranchInDir(".", branchName, firstCommit, message)
+}
+
+// SquashBranchInDir is SquashBranch for the repository at dir.
+func SquashBranchInDir(dir, branchName, firstCommit, message string) (string, error) {
+	worktree, err := checkedOutIn(dir, branchName)
+	if err != nil {
+		return "", err
+	}
+	if worktree != "" {
+		return "", fmt.Errorf("'%s' is checked out in %s; check out another branch there to squash it", branchName, worktree)
+	}
+
+	lastCommit, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", branchName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get head of branch '%s': %w", branchName, err)
+	}
+
+	args := []string{"-C", dir, "commit-tree", lastCommit + "^{tree}", "-m", message}
+	if parent, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", "--quiet", firstCommit+"^"); err == nil && parent != "" {
+		args = append(args, "-p", parent)
+	}
+	squashed, err := cmdutil.RunCommandWithOutput("git", args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create squashed commit: %w", err)
+	}
+
+	// Only move the ref if the branch still points at the commit we squashed
+	if err := cmdutil.RunCommand("git", "-C", dir, "update-ref", "refs/heads/"+branchName, squashed, lastCommit); err != nil {
+		return "", fmt.Errorf("failed to update branch '%s': %w", branchName, err)
+	}
+
+	return squashed, nil
+}
+
+// checkedOutIn returns the worktree of the repository at dir that has
+// branchName checked out, or "" if none does
+func checkedOutIn(dir, branchName string) (string, error) {
+	output, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", fmt.Errorf("failed to list worktrees: %w", err)
+	}
+	var worktree string
+	for _, line := range strings.Split(output, "\n") {
+		if path, ok := strings.CutPrefix(line, "worktree "); ok {
+			worktree = path
+		} else if line == "branch refs/heads/"+branchName {
+			return worktree, nil
+		}
+	}
+	return "", nil
+}
+
+// MergeBase returns the best common ancestor of two refs.
+func MergeBase(ref1, ref2 string) (string, error) {
+	return MergeBaseInDir(".", ref1, ref2)
+}
+
+// MergeBaseInDir is MergeBase for the repository at dir.
+func MergeBaseInDir(dir, ref1, ref2 string) (string, error) {
+	commit, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "merge-base", ref1, ref2)
+	if err != nil {
+		return "", fmt.Errorf("failed to find merge base of %s and %s: %w", ref1, ref2, err)
+	}
+	return commit, nil
+}
+
+// CommitSubjects returns "SHORT-HASH SUBJECT" for each commit in
+// fromRef..toRef, oldest first.
+func CommitSubjects(fromRef, toRef string) ([]string, error) {
+	return CommitSubjectsInDir(".", fromRef, toRef)
+}
+
+// CommitSubjectsInDir is CommitSubjects for the repository at dir.
+func CommitSubjectsInDir(dir, fromRef, toRef string) ([]string, error) {
+	output, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "log", "--reverse", "--format=%h %s", fromRef+".."+toRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits in %s..%s: %w", fromRef, toRef, err)
+	}
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+// FastForwardBranch moves targetBranch forward to branchName, failing if
+// targetBranch has diverged. If targetBranch is checked out, the working tree
+// is updated too.
+func FastForwardBranch(targetBranch, branchName string) error {
+	return FastForwardBranchInDir(".", targetBranch, branchName)
+}
+
+// FastForwardBranchInDir is FastForwardBranch for the repository at dir.
+func FastForwardBranchInDir(dir, targetBranch, branchName string) error {
+	args := []string{"-C", dir, "fetch", "--quiet", ".", branchName + ":" + targetBranch}
+	if current, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil && current == targetBranch {
+		args = []string{"-C", dir, "merge", "--quiet", "--ff-only", branchName}
+	}
+	if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fast-forward '%s' to '%s': %s", targetBranch, branchName, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RemoteExists reports whether the repository has a remote named remote.
+func RemoteExists(remote string) (bool, error) {
+	return RemoteExistsInDir(".", remote)
+}
+
+// RemoteExistsInDir is RemoteExists for the repository at dir.
+func RemoteExistsInDir(dir, remote string) (bool, error) {
+	output, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "remote")
+	if err != nil {
+		return false, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	for _, name := range strings.Fields(output) {
+		if name == remote {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PushBranchToRemote pushes branchName to remote and makes it the branch's
+// upstream.
+func PushBranchToRemote(remote, branchName string) error {
+	return PushBranchToRemoteInDir(".", remote, branchName)
+}
+
+// PushBranchToRemoteInDir is PushBranchToRemote for the repository at dir.
+func PushBranchToRemoteInDir(dir, remote, branchName string) error {
+	if output, err := exec.Command("git", "-C", dir, "push", "--quiet", "--set-upstream", remote, branchName).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to push '%s' to %s: %s", branchName, remote, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// DeleteBranch deletes branchName, and its START label if there is one,
+// whether or not it has been merged.
+func DeleteBranch(branchName string) error {
+	return DeleteBranchInDir(".", branchName)
+}
+
+// DeleteBranchInDir is DeleteBranch for the repository at dir.
+func DeleteBranchInDir(dir, branchName string) error {
+	if output, err := exec.Command("git", "-C", dir, "branch", "-D", branchName).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete branch '%s': %s", branchName, strings.TrimSpace(string(output)))
+	}
+	if exists, err := BranchExistsInDir(dir, "refs/heads/"+StartLabel(branchName)); err == nil && exists {
+		if output, err := exec.Command("git", "-C", dir, "branch", "-D", StartLabel(branchName)).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete '%s': %s", StartLabel(branchName), strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
@@ -2,14 +2,17 @@ package git
 
 import (
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"giverny/internal/cmdutil"
 	"giverny/internal/testutil"
 )
 
 func TestCreateBranch(t *testing.T) {
+	t.Parallel()
+
 	// Create a temporary git repository for testing
 	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 	if err != nil {
@@ -20,25 +23,14 @@ func TestCreateBranch(t *testing.T) {
 	// Initialize git repo
 	testutil.InitTestRepo(t, tmpDir)
 
-	// Change to temp directory for tests
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("failed to get working directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("failed to change to temp dir: %v", err)
-	}
-
 	t.Run("creates new branch successfully", func(t *testing.T) {
-		err := CreateBranch("giverny/test-task-1")
+		err := CreateBranchInDir(tmpDir, "giverny/test-task-1", "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
 
 		// Verify branch was created
-		cmd := exec.Command("git", "branch", "--list", "giverny/test-task-1")
+		cmd := testutil.Command(tmpDir, "git", "branch", "--list", "giverny/test-task-1")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to list branches: %v", err)
@@ -53,13 +45,13 @@ func TestCreateBranch(t *testing.T) {
 		branchName := "giverny/test-task-2"
 
 		// Create the branch first time
-		err := CreateBranch(branchName)
+		err := CreateBranchInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Fatalf("first creation failed: %v", err)
 		}
 
 		// Try to create it again
-		err = CreateBranch(branchName)
+		err = CreateBranchInDir(tmpDir, branchName, "")
 		if err == nil {
 			t.Error("expected error for duplicate branch, got nil")
 		}
@@ -69,16 +61,54 @@ func TestCreateBranch(t *testing.T) {
 		}
 	})
 
+	t.Run("creates branch from start point", func(t *testing.T) {
+		// Tag the current commit, then move HEAD on
+		if err := testutil.Command(tmpDir, "git", "tag", "v-start").Run(); err != nil {
+			t.Fatalf("failed to create tag: %v", err)
+		}
+		tagged, err := testutil.Command(tmpDir, "git", "rev-parse", "v-start^{commit}").Output()
+		if err != nil {
+			t.Fatalf("failed to resolve tag: %v", err)
+		}
+		cmd := testutil.Command(tmpDir, "sh", "-c", "echo 'later' > later.txt && git add later.txt && git commit -m 'Later commit'")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("failed to make commit: %v", err)
+		}
+
+		branchName := "giverny/test-task-from"
+		if err := CreateBranchInDir(tmpDir, branchName, "v-start"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		output, err := testutil.Command(tmpDir, "git", "rev-parse", branchName).Output()
+		if err != nil {
+			t.Fatalf("failed to resolve branch: %v", err)
+		}
+		if strings.TrimSpace(string(output)) != strings.TrimSpace(string(tagged)) {
+			t.Errorf("expected branch to start at the tagged commit")
+		}
+	})
+
+	t.Run("returns error for invalid start point", func(t *testing.T) {
+		err := CreateBranchInDir(tmpDir, "giverny/test-task-bad-from", "no-such-ref")
+		if err == nil {
+			t.Fatal("expected error for invalid start point, got nil")
+		}
+		if !strings.Contains(err.Error(), "not a valid ref") {
+			t.Errorf("expected 'not a valid ref' error, got: %v", err)
+		}
+	})
+
 	t.Run("does not check out the branch", func(t *testing.T) {
 		branchName := "giverny/test-task-3"
 
-		err := CreateBranch(branchName)
+		err := CreateBranchInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Fatalf("branch creation failed: %v", err)
 		}
 
 		// Check current branch
-		cmd := exec.Command("git", "branch", "--show-current")
+		cmd := testutil.Command(tmpDir, "git", "branch", "--show-current")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get current branch: %v", err)
@@ -92,6 +122,8 @@ func TestCreateBranch(t *testing.T) {
 }
 
 func TestBranchExists(t *testing.T) {
+	t.Parallel()
+
 	// Create a temporary git repository for testing
 	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 	if err != nil {
@@ -102,24 +134,13 @@ func TestBranchExists(t *testing.T) {
 	// Initialize git repo
 	testutil.InitTestRepo(t, tmpDir)
 
-	// Change to temp directory for tests
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("failed to get working directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("failed to change to temp dir: %v", err)
-	}
-
 	t.Run("returns true for existing branch", func(t *testing.T) {
 		branchName := "giverny/test-exists"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
-		exists, err := BranchExists(branchName)
+		exists, err := BranchExistsInDir(tmpDir, branchName)
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -129,7 +150,7 @@ func TestBranchExists(t *testing.T) {
 	})
 
 	t.Run("returns false for non-existing branch", func(t *testing.T) {
-		exists, err := BranchExists("giverny/non-existing-branch")
+		exists, err := BranchExistsInDir(tmpDir, "giverny/non-existing-branch")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -140,14 +161,14 @@ func TestBranchExists(t *testing.T) {
 
 	t.Run("returns true for current branch", func(t *testing.T) {
 		// Get current branch
-		cmd := exec.Command("git", "branch", "--show-current")
+		cmd := testutil.Command(tmpDir, "git", "branch", "--show-current")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get current branch: %v", err)
 		}
 		currentBranch := strings.TrimSpace(string(output))
 
-		exists, err := BranchExists(currentBranch)
+		exists, err := BranchExistsInDir(tmpDir, currentBranch)
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -158,7 +179,11 @@ func TestBranchExists(t *testing.T) {
 }
 
 func TestGetBranchCommitRange(t *testing.T) {
+	t.Parallel()
+
 	t.Run("returns empty when branch has no commits", func(t *testing.T) {
+		t.Parallel()
+
 		// Create a temporary git repository for this test
 		tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 		if err != nil {
@@ -169,22 +194,12 @@ func TestGetBranchCommitRange(t *testing.T) {
 		// Initialize git repo
 		testutil.InitTestRepo(t, tmpDir)
 
-		// Change to temp directory for this test
-		origDir, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("failed to get working directory: %v", err)
-		}
-		defer os.Chdir(origDir)
-
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("failed to change to temp dir: %v", err)
-		}
 		branchName := "giverny/test-empty"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
-		first, last, err := GetBranchCommitRange(branchName)
+		first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -194,6 +209,8 @@ func TestGetBranchCommitRange(t *testing.T) {
 	})
 
 	t.Run("returns commit range with START label", func(t *testing.T) {
+		t.Parallel()
+
 		// Create a temporary git repository for this test
 		tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 		if err != nil {
@@ -204,43 +221,32 @@ func TestGetBranchCommitRange(t *testing.T) {
 		// Initialize git repo
 		testutil.InitTestRepo(t, tmpDir)
 
-		// Change to temp directory for this test
-		origDir, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("failed to get working directory: %v", err)
-		}
-		defer os.Chdir(origDir)
-
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("failed to change to temp dir: %v", err)
-		}
-
 		branchName := "giverny/test-with-commits"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
 		// Create START label
 		startLabel := branchName + "-START"
-		cmd := exec.Command("git", "branch", startLabel)
+		cmd := testutil.Command(tmpDir, "git", "branch", startLabel)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to create START label: %v", err)
 		}
 
 		// Checkout the branch
-		cmd = exec.Command("git", "checkout", branchName)
+		cmd = testutil.Command(tmpDir, "git", "checkout", branchName)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout branch: %v", err)
 		}
 
 		// Make a commit
-		cmd = exec.Command("sh", "-c", "echo 'test1' > test1.txt && git add test1.txt && git commit -m 'First commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'test1' > test1.txt && git add test1.txt && git commit -m 'First commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make first commit: %v", err)
 		}
 
 		// Get the first commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get first commit hash: %v", err)
@@ -248,13 +254,13 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedFirst := strings.TrimSpace(string(output))
 
 		// Make another commit
-		cmd = exec.Command("sh", "-c", "echo 'test2' > test2.txt && git add test2.txt && git commit -m 'Second commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'test2' > test2.txt && git add test2.txt && git commit -m 'Second commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make second commit: %v", err)
 		}
 
 		// Get the second commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err = cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get second commit hash: %v", err)
@@ -262,7 +268,7 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedLast := strings.TrimSpace(string(output))
 
 		// Now test GetBranchCommitRange
-		first, last, err := GetBranchCommitRange(branchName)
+		first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -275,6 +281,8 @@ func TestGetBranchCommitRange(t *testing.T) {
 	})
 
 	t.Run("handles single commit", func(t *testing.T) {
+		t.Parallel()
+
 		// Create a temporary git repository for this test
 		tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 		if err != nil {
@@ -285,43 +293,32 @@ func TestGetBranchCommitRange(t *testing.T) {
 		// Initialize git repo
 		testutil.InitTestRepo(t, tmpDir)
 
-		// Change to temp directory for this test
-		origDir, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("failed to get working directory: %v", err)
-		}
-		defer os.Chdir(origDir)
-
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("failed to change to temp dir: %v", err)
-		}
-
 		branchName := "giverny/test-single-commit"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
 		// Create START label
 		startLabel := branchName + "-START"
-		cmd := exec.Command("git", "branch", startLabel)
+		cmd := testutil.Command(tmpDir, "git", "branch", startLabel)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to create START label: %v", err)
 		}
 
 		// Checkout the branch
-		cmd = exec.Command("git", "checkout", branchName)
+		cmd = testutil.Command(tmpDir, "git", "checkout", branchName)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout branch: %v", err)
 		}
 
 		// Make a single commit
-		cmd = exec.Command("sh", "-c", "echo 'single' > single.txt && git add single.txt && git commit -m 'Single commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'single' > single.txt && git add single.txt && git commit -m 'Single commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make commit: %v", err)
 		}
 
 		// Get the commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get commit hash: %v", err)
@@ -329,7 +326,7 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedCommit := strings.TrimSpace(string(output))
 
 		// Test GetBranchCommitRange
-		first, last, err := GetBranchCommitRange(branchName)
+		first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -342,6 +339,8 @@ func TestGetBranchCommitRange(t *testing.T) {
 	})
 
 	t.Run("finds divergence point without START label (outie scenario)", func(t *testing.T) {
+		t.Parallel()
+
 		// This test simulates the scenario in outie where:
 		// 1. A branch is created from the default branch
 		// 2. Commits are made to the branch (inside container)
@@ -358,43 +357,32 @@ func TestGetBranchCommitRange(t *testing.T) {
 		// Initialize git repo
 		testutil.InitTestRepo(t, tmpDir)
 
-		// Change to temp directory for this test
-		origDir, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("failed to get working directory: %v", err)
-		}
-		defer os.Chdir(origDir)
-
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("failed to change to temp dir: %v", err)
-		}
-
 		// Make a commit on main
-		cmd := exec.Command("sh", "-c", "echo 'divergence-test-main' > divergence-main.txt && git add divergence-main.txt && git commit -m 'Commit on main'")
+		cmd := testutil.Command(tmpDir, "sh", "-c", "echo 'divergence-test-main' > divergence-main.txt && git add divergence-main.txt && git commit -m 'Commit on main'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make commit on main: %v", err)
 		}
 
 		// Create a branch from main
 		branchName := "giverny/test-without-label"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
 		// Checkout the branch
-		cmd = exec.Command("git", "checkout", branchName)
+		cmd = testutil.Command(tmpDir, "git", "checkout", branchName)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout branch: %v", err)
 		}
 
 		// Make commits on the branch (simulating work done in container)
-		cmd = exec.Command("sh", "-c", "echo 'divergence-test1' > divergence-test1.txt && git add divergence-test1.txt && git commit -m 'First commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'divergence-test1' > divergence-test1.txt && git add divergence-test1.txt && git commit -m 'First commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make first commit: %v", err)
 		}
 
 		// Get the first commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get first commit hash: %v", err)
@@ -402,13 +390,13 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedFirst := strings.TrimSpace(string(output))
 
 		// Make another commit
-		cmd = exec.Command("sh", "-c", "echo 'divergence-test2' > divergence-test2.txt && git add divergence-test2.txt && git commit -m 'Second commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'divergence-test2' > divergence-test2.txt && git add divergence-test2.txt && git commit -m 'Second commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make second commit: %v", err)
 		}
 
 		// Get the second commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err = cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get second commit hash: %v", err)
@@ -416,13 +404,13 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedLast := strings.TrimSpace(string(output))
 
 		// Go back to main (simulating outie checking the branch)
-		cmd = exec.Command("git", "checkout", "main")
+		cmd = testutil.Command(tmpDir, "git", "checkout", "main")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout main: %v", err)
 		}
 
 		// Now test GetBranchCommitRange from main (no START label exists)
-		first, last, err := GetBranchCommitRange(branchName)
+		first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -435,6 +423,8 @@ func TestGetBranchCommitRange(t *testing.T) {
 	})
 
 	t.Run("finds divergence point with upstream tracking branch set", func(t *testing.T) {
+		t.Parallel()
+
 		// This test ensures that even when a branch has an upstream tracking branch,
 		// GetBranchCommitRange still returns the commits relative to 'main', not
 		// relative to the upstream. This is important for giverny's cherry-pick
@@ -450,43 +440,32 @@ func TestGetBranchCommitRange(t *testing.T) {
 		// Initialize git repo
 		testutil.InitTestRepo(t, tmpDir)
 
-		// Change to temp directory for this test
-		origDir, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("failed to get working directory: %v", err)
-		}
-		defer os.Chdir(origDir)
-
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("failed to change to temp dir: %v", err)
-		}
-
 		// Make a commit on main to establish a divergence point
-		cmd := exec.Command("sh", "-c", "echo 'upstream-test-main' > upstream-main.txt && git add upstream-main.txt && git commit -m 'Commit on main'")
+		cmd := testutil.Command(tmpDir, "sh", "-c", "echo 'upstream-test-main' > upstream-main.txt && git add upstream-main.txt && git commit -m 'Commit on main'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make commit on main: %v", err)
 		}
 
 		// Create a branch from main
 		branchName := "giverny/test-with-upstream"
-		if err := CreateBranch(branchName); err != nil {
+		if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
 			t.Fatalf("failed to create branch: %v", err)
 		}
 
 		// Checkout the branch
-		cmd = exec.Command("git", "checkout", branchName)
+		cmd = testutil.Command(tmpDir, "git", "checkout", branchName)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout branch: %v", err)
 		}
 
 		// Make commits on the branch
-		cmd = exec.Command("sh", "-c", "echo 'upstream-test1' > upstream-test1.txt && git add upstream-test1.txt && git commit -m 'First commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'upstream-test1' > upstream-test1.txt && git add upstream-test1.txt && git commit -m 'First commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make first commit: %v", err)
 		}
 
 		// Get the first commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err := cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get first commit hash: %v", err)
@@ -494,13 +473,13 @@ func TestGetBranchCommitRange(t *testing.T) {
 		expectedFirst := strings.TrimSpace(string(output))
 
 		// Make another commit
-		cmd = exec.Command("sh", "-c", "echo 'upstream-test2' > upstream-test2.txt && git add upstream-test2.txt && git commit -m 'Second commit'")
+		cmd = testutil.Command(tmpDir, "sh", "-c", "echo 'upstream-test2' > upstream-test2.txt && git add upstream-test2.txt && git commit -m 'Second commit'")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to make second commit: %v", err)
 		}
 
 		// Get the second commit hash
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 		output, err = cmd.Output()
 		if err != nil {
 			t.Fatalf("failed to get second commit hash: %v", err)
@@ -509,25 +488,25 @@ func TestGetBranchCommitRange(t *testing.T) {
 
 		// Set up a fake upstream tracking branch
 		// First, add a fake remote
-		cmd = exec.Command("git", "remote", "add", "origin", "fake-url")
+		cmd = testutil.Command(tmpDir, "git", "remote", "add", "origin", "fake-url")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to add remote: %v", err)
 		}
 
 		// Create a fake remote branch by creating a ref
-		cmd = exec.Command("git", "update-ref", "refs/remotes/origin/"+branchName, expectedLast)
+		cmd = testutil.Command(tmpDir, "git", "update-ref", "refs/remotes/origin/"+branchName, expectedLast)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to create fake remote ref: %v", err)
 		}
 
 		// Set the upstream tracking
-		cmd = exec.Command("git", "branch", "--set-upstream-to=origin/"+branchName, branchName)
+		cmd = testutil.Command(tmpDir, "git", "branch", "--set-upstream-to=origin/"+branchName, branchName)
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to set upstream: %v", err)
 		}
 
 		// Verify upstream is set
-		cmd = exec.Command("git", "rev-parse", "--abbrev-ref", branchName+"@{upstream}")
+		cmd = testutil.Command(tmpDir, "git", "rev-parse", "--abbrev-ref", branchName+"@{upstream}")
 		output, err = cmd.Output()
 		if err != nil {
 			t.Fatalf("upstream should be set but got error: %v", err)
@@ -539,7 +518,7 @@ func TestGetBranchCommitRange(t *testing.T) {
 
 		// Now test GetBranchCommitRange - it should return commits relative to main,
 		// not relative to the upstream (which would return no commits since they're synced)
-		first, last, err := GetBranchCommitRange(branchName)
+		first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
 		if err != nil {
 			t.Errorf("expected no error, got: %v", err)
 		}
@@ -551,7 +530,7 @@ func TestGetBranchCommitRange(t *testing.T) {
 		}
 
 		// Clean up: go back to main
-		cmd = exec.Command("git", "checkout", "main")
+		cmd = testutil.Command(tmpDir, "git", "checkout", "main")
 		if err := cmd.Run(); err != nil {
 			t.Fatalf("failed to checkout main: %v", err)
 		}
@@ -559,6 +538,8 @@ func TestGetBranchCommitRange(t *testing.T) {
 }
 
 func TestGetShortHash(t *testing.T) {
+	t.Parallel()
+
 	// Create a temporary git repository for testing
 	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
 	if err != nil {
@@ -569,19 +550,8 @@ func TestGetShortHash(t *testing.T) {
 	// Initialize git repo
 	testutil.InitTestRepo(t, tmpDir)
 
-	// Change to temp directory for tests
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("failed to get working directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("failed to change to temp dir: %v", err)
-	}
-
 	// Get the full hash of HEAD
-	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd := testutil.Command(tmpDir, "git", "rev-parse", "HEAD")
 	output, err := cmd.Output()
 	if err != nil {
 		t.Fatalf("failed to get HEAD hash: %v", err)
@@ -589,7 +559,7 @@ func TestGetShortHash(t *testing.T) {
 	fullHash := strings.TrimSpace(string(output))
 
 	// Get the short hash
-	shortHash := GetShortHash(fullHash)
+	shortHash := GetShortHashInDir(tmpDir, fullHash)
 
 	// Verify the short hash is actually shorter
 	if len(shortHash) >= len(fullHash) {
@@ -608,8 +578,386 @@ func TestGetShortHash(t *testing.T) {
 
 	// Test with an invalid hash - should return the original
 	invalidHash := "invalid-hash-xyz"
-	result := GetShortHash(invalidHash)
+	result := GetShortHashInDir(tmpDir, invalidHash)
 	if result != invalidHash {
 		t.Errorf("expected GetShortHash to return original hash on error, got %s", result)
 	}
 }
+
+func TestSquashBranch(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary git repository for testing
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Initialize git repo
+	testutil.InitTestRepo(t, tmpDir)
+
+	branchName := "giverny/test-squash"
+	if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+
+	// Make two commits on the branch, then return to main so the branch is
+	// not checked out (as on the host)
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q "+branchName+
+		" && echo 'one' > one.txt && git add one.txt && git commit -q -m 'First commit'"+
+		" && echo 'two' > two.txt && git add two.txt && git commit -q -m 'Second commit'"+
+		" && git checkout -q main")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commits: %v", err)
+	}
+
+	first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
+	if err != nil {
+		t.Fatalf("failed to get commit range: %v", err)
+	}
+
+	squashed, err := SquashBranchInDir(tmpDir, branchName, first, "Squashed task\n\nDo the thing")
+	if err != nil {
+		t.Fatalf("SquashBranch failed: %v", err)
+	}
+
+	newFirst, newLast, err := GetBranchCommitRangeInDir(tmpDir, branchName, "")
+	if err != nil {
+		t.Fatalf("failed to get commit range after squash: %v", err)
+	}
+	if newFirst != squashed || newLast != squashed {
+		t.Errorf("expected a single commit %s, got first=%s, last=%s", squashed, newFirst, newLast)
+	}
+
+	// The squashed commit must have the same tree as the original head
+	output, err := testutil.Command(tmpDir, "git", "diff", "--stat", last, squashed).Output()
+	if err != nil {
+		t.Fatalf("failed to diff: %v", err)
+	}
+	if len(output) != 0 {
+		t.Errorf("expected squashed tree to match original head, got diff: %s", output)
+	}
+
+	output, err = testutil.Command(tmpDir, "git", "log", "-1", "--format=%B", squashed).Output()
+	if err != nil {
+		t.Fatalf("failed to read commit message: %v", err)
+	}
+	if !strings.Contains(string(output), "Do the thing") {
+		t.Errorf("expected squashed commit message to contain the prompt, got: %s", output)
+	}
+}
+
+func TestSquashBranch_CheckedOut(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	branchName := "giverny/test-squash"
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q -b "+branchName+
+		" && echo 'one' > one.txt && git add one.txt && git commit -q -m 'First commit'"+
+		" && echo 'two' > two.txt && git add two.txt && git commit -q -m 'Second commit'")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commits: %v", err)
+	}
+	first, last, err := GetBranchCommitRangeInDir(tmpDir, branchName, "main")
+	if err != nil {
+		t.Fatalf("failed to get commit range: %v", err)
+	}
+
+	// Checked out in the main worktree, then in a linked one
+	worktree := filepath.Join(t.TempDir(), "worktree")
+	for _, setup := range []string{"true", "git checkout -q main && git worktree add -q " + worktree + " " + branchName} {
+		if err := testutil.Command(tmpDir, "sh", "-c", setup).Run(); err != nil {
+			t.Fatalf("failed to check out %s: %v", branchName, err)
+		}
+		if _, err := SquashBranchInDir(tmpDir, branchName, first, "Squashed"); err == nil || !strings.Contains(err.Error(), "is checked out in") {
+			t.Errorf("expected squashing a checked-out branch to be refused, got %v", err)
+		}
+		if head, err := GetCommitHashInDir(tmpDir, branchName); err != nil || head != last {
+			t.Errorf("expected %s to stay at %s, got %s (err %v)", branchName, last, head, err)
+		}
+	}
+}
+
+func TestDefaultBranch(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary git repository for testing
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Initialize git repo
+	testutil.InitTestRepo(t, tmpDir)
+
+	t.Run("finds main", func(t *testing.T) {
+		if got := DefaultBranchInDir(tmpDir); got != "main" {
+			t.Errorf("expected main, got %s", got)
+		}
+	})
+
+	t.Run("finds master", func(t *testing.T) {
+		if err := testutil.Command(tmpDir, "git", "branch", "-m", "main", "master").Run(); err != nil {
+			t.Fatalf("failed to rename branch: %v", err)
+		}
+		defer testutil.Command(tmpDir, "git", "branch", "-m", "master", "main").Run()
+
+		if got := DefaultBranchInDir(tmpDir); got != "master" {
+			t.Errorf("expected master, got %s", got)
+		}
+	})
+
+	t.Run("prefers origin/HEAD", func(t *testing.T) {
+		cmd := testutil.Command(tmpDir, "sh", "-c", "git branch develop && git update-ref refs/remotes/origin/develop develop && git symbolic-ref refs/remotes/origin/HEAD refs/remotes/origin/develop")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("failed to set up origin/HEAD: %v", err)
+		}
+		defer testutil.Command(tmpDir, "git", "symbolic-ref", "--delete", "refs/remotes/origin/HEAD").Run()
+
+		if got := DefaultBranchInDir(tmpDir); got != "develop" {
+			t.Errorf("expected develop, got %s", got)
+		}
+	})
+}
+
+func TestFastForwardAndDeleteBranch(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	branchName := "giverny/test-merge"
+	for _, branch := range []string{branchName, StartLabel(branchName)} {
+		if err := CreateBranchInDir(tmpDir, branch, ""); err != nil {
+			t.Fatalf("failed to create branch: %v", err)
+		}
+	}
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q "+branchName+
+		" && echo 'one' > one.txt && git add one.txt && git commit -q -m 'First commit'"+
+		" && git checkout -q main")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commits: %v", err)
+	}
+
+	// main is checked out, so it is merged into
+	if err := FastForwardBranchInDir(tmpDir, "main", branchName); err != nil {
+		t.Fatalf("FastForwardBranch failed: %v", err)
+	}
+	mainCommit, _ := GetCommitHashInDir(tmpDir, "main")
+	branchCommit, _ := GetCommitHashInDir(tmpDir, branchName)
+	if mainCommit != branchCommit {
+		t.Errorf("expected main at %s, got %s", branchCommit, mainCommit)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "one.txt")); err != nil {
+		t.Errorf("expected the working tree to be updated: %v", err)
+	}
+
+	// A branch that isn't checked out is moved without touching the working tree
+	if err := CreateBranchInDir(tmpDir, "release", "main~1"); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+	if err := FastForwardBranchInDir(tmpDir, "release", branchName); err != nil {
+		t.Fatalf("FastForwardBranch failed: %v", err)
+	}
+	if err := FastForwardBranchInDir(tmpDir, branchName, "main~1"); err == nil {
+		t.Error("expected an error moving a branch backwards")
+	}
+
+	if err := DeleteBranchInDir(tmpDir, branchName); err != nil {
+		t.Fatalf("DeleteBranch failed: %v", err)
+	}
+	for _, branch := range []string{branchName, StartLabel(branchName)} {
+		if exists, _ := BranchExistsInDir(tmpDir, "refs/heads/"+branch); exists {
+			t.Errorf("expected %s to be deleted", branch)
+		}
+	}
+}
+
+func TestPushBranchToRemote(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+	remoteDir := t.TempDir()
+	if err := testutil.Command(remoteDir, "git", "init", "--quiet", "--bare").Run(); err != nil {
+		t.Fatalf("failed to create remote: %v", err)
+	}
+	if err := testutil.Command(tmpDir, "git", "remote", "add", "origin", remoteDir).Run(); err != nil {
+		t.Fatalf("failed to add remote: %v", err)
+	}
+
+	if exists, err := RemoteExistsInDir(tmpDir, "origin"); err != nil || !exists {
+		t.Errorf("RemoteExists(origin) = %v, %v, want true", exists, err)
+	}
+	if exists, err := RemoteExistsInDir(tmpDir, "upstream"); err != nil || exists {
+		t.Errorf("RemoteExists(upstream) = %v, %v, want false", exists, err)
+	}
+
+	branchName := "giverny/test-push"
+	if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+	if err := PushBranchToRemoteInDir(tmpDir, "origin", branchName); err != nil {
+		t.Fatalf("PushBranchToRemote failed: %v", err)
+	}
+	pushed, err := GetCommitHashInDir(remoteDir, branchName)
+	if err != nil {
+		t.Fatalf("expected %s on the remote: %v", branchName, err)
+	}
+	if local, _ := GetCommitHashInDir(tmpDir, branchName); pushed != local {
+		t.Errorf("expected the remote at %s, got %s", local, pushed)
+	}
+	if upstream, err := cmdutil.RunCommandWithOutput("git", "-C", tmpDir, "rev-parse", "--abbrev-ref", branchName+"@{upstream}"); err != nil || upstream != "origin/"+branchName {
+		t.Errorf("expected upstream origin/%s, got %q (%v)", branchName, upstream, err)
+	}
+
+	if err := PushBranchToRemoteInDir(tmpDir, "upstream", branchName); err == nil {
+		t.Error("expected an error pushing to a missing remote")
+	}
+}
+
+func TestCurrentBranchAndProtectedBranches(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	if branch, err := CurrentBranchInDir(tmpDir); err != nil || branch != "main" {
+		t.Errorf("CurrentBranch() = %q, %v, want main", branch, err)
+	}
+	if err := testutil.Command(tmpDir, "git", "checkout", "--quiet", "--detach").Run(); err != nil {
+		t.Fatalf("failed to detach HEAD: %v", err)
+	}
+	if branch, err := CurrentBranchInDir(tmpDir); err != nil || branch != "" {
+		t.Errorf("CurrentBranch() = %q, %v, want detached", branch, err)
+	}
+
+	if patterns, err := ProtectedBranchesInDir(tmpDir); err != nil || patterns != nil {
+		t.Errorf("ProtectedBranches() = %v, %v, want none", patterns, err)
+	}
+	for _, pattern := range []string{"release/*", "production"} {
+		if err := testutil.Command(tmpDir, "git", "config", "--add", ProtectedBranchKey, pattern).Run(); err != nil {
+			t.Fatalf("failed to set %s: %v", ProtectedBranchKey, err)
+		}
+	}
+	patterns, err := ProtectedBranchesInDir(tmpDir)
+	if err != nil || strings.Join(patterns, " ") != "release/* production" {
+		t.Fatalf("ProtectedBranches() = %v, %v", patterns, err)
+	}
+
+	tests := []struct {
+		branch   string
+		expected string
+	}{
+		{"release/1.0", "release/*"},
+		{"release/1.0/hotfix", ""},
+		{"production", "production"},
+		{"main", ""},
+	}
+	for _, tt := range tests {
+		if got := MatchProtectedBranch(tt.branch, patterns); got != tt.expected {
+			t.Errorf("MatchProtectedBranch(%q) = %q, want %q", tt.branch, got, tt.expected)
+		}
+	}
+}
+
+func TestInProgressOperation(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	if operation, err := InProgressOperationInDir(tmpDir); err != nil || operation != "" {
+		t.Errorf("InProgressOperation() = %q, %v, want none", operation, err)
+	}
+
+	// Make conflicting changes on two branches and start merging them
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q -b other"+
+		" && echo 'other' > conflict.txt && git add conflict.txt && git commit -q -m 'Other'"+
+		" && git checkout -q main"+
+		" && echo 'main' > conflict.txt && git add conflict.txt && git commit -q -m 'Main'")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commits: %v", err)
+	}
+	if err := testutil.Command(tmpDir, "git", "merge", "--quiet", "other").Run(); err == nil {
+		t.Fatal("expected the merge to conflict")
+	}
+	if operation, err := InProgressOperationInDir(tmpDir); err != nil || operation != "merge" {
+		t.Errorf("InProgressOperation() = %q, %v, want merge", operation, err)
+	}
+
+	if err := testutil.Command(tmpDir, "git", "merge", "--abort").Run(); err != nil {
+		t.Fatalf("failed to abort merge: %v", err)
+	}
+	if err := testutil.Command(tmpDir, "git", "rebase", "--quiet", "other").Run(); err == nil {
+		t.Fatal("expected the rebase to conflict")
+	}
+	if operation, err := InProgressOperationInDir(tmpDir); err != nil || operation != "rebase" {
+		t.Errorf("InProgressOperation() = %q, %v, want rebase", operation, err)
+	}
+}
+
+func TestBranchProblems(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+	branch := "giverny/task-1"
+	run := func(script string) {
+		t.Helper()
+		if output, err := testutil.Command(tmpDir, "sh", "-c", script).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %v\n%s", script, err, output)
+		}
+	}
+	run("git commit -q --allow-empty -m Second && git checkout -q -b " + branch + " && git branch " + StartLabel(branch) +
+		" && echo work > work.txt && git add work.txt && git commit -q -m Work")
+	start, err := cmdutil.RunCommandWithOutput("git", "-C", tmpDir, "rev-parse", StartLabel(branch))
+	if err != nil {
+		t.Fatalf("failed to resolve the START label: %v", err)
+	}
+
+	check := func(want ...string) {
+		t.Helper()
+		problems, err := BranchProblemsInDir(tmpDir, branch, start)
+		if err != nil {
+			t.Fatalf("BranchProblems failed: %v", err)
+		}
+		if len(problems) != len(want) {
+			t.Fatalf("BranchProblems() = %q, want %d problems", problems, len(want))
+		}
+		for i, problem := range problems {
+			if !strings.Contains(problem, want[i]) {
+				t.Errorf("problem %q, want it to mention %q", problem, want[i])
+			}
+		}
+	}
+	check()
+
+	run("git checkout -q --detach")
+	check("HEAD is detached")
+	run("git checkout -q main")
+	check("main is checked out")
+
+	// Rebasing the branch's work onto an earlier commit leaves the start out
+	run("git checkout -q " + branch + " && git rebase -q --onto main~1 " + StartLabel(branch))
+	check("no longer contains")
+
+	run("git reset -q --hard " + start + " && git branch -f " + StartLabel(branch) + " main~1")
+	problems, err := BranchProblemsInDir(tmpDir, branch, start)
+	if err != nil {
+		t.Fatalf("BranchProblems failed: %v", err)
+	}
+	if !strings.Contains(strings.Join(problems, "\n"), "was moved") {
+		t.Errorf("expected the moved START label to be reported, got %q", problems)
+	}
+
+	run("git checkout -q main && git branch -D " + branch)
+	problems, err = BranchProblemsInDir(tmpDir, branch, start)
+	if err != nil || !strings.Contains(strings.Join(problems, "\n"), "was deleted") {
+		t.Errorf("expected the deleted branch to be reported, got %q, %v", problems, err)
+	}
+}
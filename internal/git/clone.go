@@ -2,59 +2,262 @@ package git
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
+
+	"giverny/internal/cmdutil"
 )
 
+// ServerURLEnv is the environment variable that holds the URL the container
+// reaches the repository at on the git server, as outie knows it: on the
+// Docker host, on a git server sidecar, or through a tunnel
+const ServerURLEnv = "GIVERNY_GIT_URL"
+
+// DefaultServerHost is the name Docker gives the host inside containers
+const DefaultServerHost = "host.docker.internal"
+
+// RepoURL returns the URL the container reaches the repository at on the git
+// server on port: the one in ServerURLEnv, moved to port if outie moved the
+// server, or else the repository at / on the Docker host
+func RepoURL(port int) string {
+	repoURL := os.Getenv(ServerURLEnv)
+	if repoURL == "" {
+		return ServerURL(DefaultServerHost, port, "")
+	}
+	return withPort(repoURL, port)
+}
+
+// withPort returns repoURL with its port replaced by port
+func withPort(repoURL string, port int) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return repoURL
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	return u.String()
+}
+
+// ServerPortFile is where outie tells a reused container the port of its git
+// server, when the one the container was started with was taken
+const ServerPortFile = "/etc/giverny-git-server-port"
+
+// ServerPort returns the port the container reaches the git server on: the
+// one in ServerPortFile if outie left one, otherwise port
+func ServerPort(port int) int {
+	return readServerPort(ServerPortFile, port)
+}
+
+func readServerPort(path string, port int) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return port
+	}
+	if p, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && p > 0 {
+		return p
+	}
+	return port
+}
+
+// ServerURL returns the URL of the repository served by the git server at
+// host and port under token. When git daemon serves with --base-path pointing
+// to a repo, it is referenced with / (empty path after host:port), and under
+// a token, with /token. IPv6 addresses are bracketed.
+func ServerURL(host string, port int, token string) string {
+	return "git://" + net.JoinHostPort(host, fmt.Sprint(port)) + "/" + token
+}
+
+// ServerToken returns the token in the path of repoURL, a URL ServerURL
+// returned
+func ServerToken(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// CloneOptions controls how much of the repository is cloned into the container.
+// The zero value clones the full repository.
+type CloneOptions struct {
+	// Depth limits the history fetched for every branch to this many commits.
+	// Zero fetches the full history.
+	Depth int
+	// Filter is a partial clone filter spec, e.g. "blob:none". Objects left out
+	// are fetched from the git server on demand.
+	Filter string
+	// AutoCRLF and FileMode set core.autocrlf and core.fileMode in the clone
+	// before anything is checked out. Empty leaves git's default.
+	AutoCRLF string
+	FileMode string
+}
+
 // CloneRepo clones a repository from the git server into /git directory.
 // Uses --no-checkout to create a bare-like clone that can be checked out later.
 // Returns an error if the clone fails.
-func CloneRepo(gitServerPort int, debug bool) error {
-	return CloneRepoToDir(gitServerPort, "/git", debug)
+func CloneRepo(gitServerPort int, opts CloneOptions, debug bool) error {
+	return CloneRepoToDir(gitServerPort, DefaultCloneDir, opts, debug)
 }
 
 // CloneRepoToDir clones a repository from the git server into the specified directory.
 // Uses --no-checkout to create a bare-like clone that can be checked out later.
 // Returns an error if the clone fails.
-func CloneRepoToDir(gitServerPort int, gitDir string, debug bool) error {
-	return CloneRepoFromHost(gitServerPort, gitDir, "host.docker.internal", debug)
+func CloneRepoToDir(gitServerPort int, gitDir string, opts CloneOptions, debug bool) error {
+	return CloneRepoFromURL(RepoURL(gitServerPort), gitDir, opts, debug)
 }
 
-// CloneRepoFromHost clones a repository from the specified host and port into the specified directory.
+// maxCloneAttempts is how many times a clone that can't connect to the git
+// server is tried
+const maxCloneAttempts = 6
+
+// cloneRetryDelay is the wait before the second clone attempt, doubled before
+// each one after it
+var cloneRetryDelay = 500 * time.Millisecond
+
+// CloneRepoFromURL clones the repository at repoURL into the specified directory.
 // Uses --no-checkout to create a bare-like clone that can be checked out later.
+// The container can start before the git server is ready, so a clone that
+// can't connect to it is retried with exponential backoff.
 // Returns an error if the clone fails.
-func CloneRepoFromHost(gitServerPort int, gitDir string, host string, debug bool) error {
+func CloneRepoFromURL(repoURL, gitDir string, opts CloneOptions, debug bool) error {
 	// Create directory
 	if err := os.MkdirAll(gitDir, 0755); err != nil {
 		return fmt.Errorf("failed to create %s directory: %w", gitDir, err)
 	}
 
-	// Clone from the specified host
-	// Docker provides host.docker.internal as a special DNS name that resolves to the host
-	repoURL := fmt.Sprintf("git://%s:%d/", host, gitServerPort)
-
 	// Run git clone with --no-checkout
 	args := []string{"clone", "--no-checkout"}
+	if opts.Depth > 0 {
+		// Fetch every branch, not just the default one, so the task branch is available
+		args = append(args, fmt.Sprintf("--depth=%d", opts.Depth), "--no-single-branch")
+	}
+	if opts.Filter != "" {
+		args = append(args, "--filter="+opts.Filter)
+	}
+	if opts.AutoCRLF != "" {
+		args = append(args, "--config", "core.autocrlf="+opts.AutoCRLF)
+	}
+	if opts.FileMode != "" {
+		args = append(args, "--config", "core.fileMode="+opts.FileMode)
+	}
+	// Show how far the clone has got even when git's stderr isn't a terminal,
+	// as a large repository can take long enough to look hung
+	args = append(args, "--progress", repoURL, gitDir)
+
+	delay := cloneRetryDelay
+	for attempt := 1; ; attempt++ {
+		outputStr, err := runClone(args)
+		if err == nil {
+			return nil
+		}
+		if isConnectError(outputStr) {
+			if attempt < maxCloneAttempts {
+				fmt.Fprintf(os.Stderr, "Can't connect to git server at %s yet, retrying in %s (attempt %d of %d)...\n", repoURL, delay, attempt, maxCloneAttempts)
+				time.Sleep(delay)
+				delay *= 2
+				continue
+			}
+			return fmt.Errorf("failed to connect to git server at %s after %d attempts\n%s\nError: %s", repoURL, maxCloneAttempts, serverCheckHint(repoURL), outputStr)
+		}
+		if strings.Contains(outputStr, "does not appear to be a git repository") {
+			return fmt.Errorf("git server at %s does not appear to be serving a valid repository\nError: %s", repoURL, outputStr)
+		}
+		return fmt.Errorf("failed to clone repository from %s: %s", repoURL, outputStr)
+	}
+}
+
+// serverCheckHint tells how to check on the host that the git server serving
+// repoURL is up
+func serverCheckHint(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Hostname() != DefaultServerHost {
+		return fmt.Sprintf("Is the git server running? Check on the host that it serves the repository, with: git ls-remote %s\n"+
+			"For a task on a network of its own, check that its git server sidecar is running, with: docker ps --filter label=giverny.container", repoURL)
+	}
+	u.Host = net.JoinHostPort("127.0.0.1", u.Port())
+	return fmt.Sprintf("Is the git server running on the host? Check there that giverny's git daemon is running, with: ps -A -o pid,args | grep %s\n"+
+		"and that it serves the repository, with: git ls-remote %s", pidFilePrefix, u.String())
+}
+
+// runClone runs git clone with args, showing its progress, and returns its
+// output
+func runClone(args []string) (string, error) {
+	progress := newCloneProgress(os.Stderr)
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = progress
+	cmd.Stderr = progress
+	err := cmd.Run()
+	progress.finish()
+	return strings.TrimSpace(progress.String()), err
+}
+
+// isConnectError reports whether git's output shows it could not connect to
+// the git server, as when it has not started listening yet
+func isConnectError(output string) bool {
+	return strings.Contains(output, "unable to connect") ||
+		strings.Contains(output, "Connection refused") ||
+		strings.Contains(output, "Connection reset")
+}
+
+// FetchRepo updates an existing clone in /git from the git server, for when a
+// container is reused. The origin URL is updated to the current server port.
+// Returns an error if the fetch fails.
+func FetchRepo(gitServerPort int, debug bool) error {
+	return FetchRepoToDir(gitServerPort, DefaultCloneDir, debug)
+}
+
+// FetchRepoToDir updates an existing clone in the specified directory from the
+// git server.
+// Returns an error if the fetch fails.
+func FetchRepoToDir(gitServerPort int, gitDir string, debug bool) error {
+	return FetchRepoFromURL(RepoURL(gitServerPort), gitDir, debug)
+}
+
+// FetchRepoFromURL updates an existing clone in the specified directory from
+// the repository at repoURL, which becomes its origin. Remote-tracking
+// branches that no longer exist on the server are pruned.
+// Returns an error if the fetch fails.
+func FetchRepoFromURL(repoURL, gitDir string, debug bool) error {
+	if err := cmdutil.RunCommand("git", "-C", gitDir, "remote", "set-url", "origin", repoURL); err != nil {
+		return fmt.Errorf("failed to set origin URL to %s: %w", repoURL, err)
+	}
+
+	args := []string{"-C", gitDir, "fetch", "--prune"}
 	if !debug {
 		args = append(args, "--quiet")
 	}
-	args = append(args, repoURL, gitDir)
+	args = append(args, "origin")
 
 	cmd := exec.Command("git", args...)
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		// Provide useful error message
 		outputStr := strings.TrimSpace(string(output))
 		if strings.Contains(outputStr, "Connection refused") {
 			return fmt.Errorf("failed to connect to git server at %s\nIs the git server running on the host?\nError: %s", repoURL, outputStr)
 		}
-		if strings.Contains(outputStr, "does not appear to be a git repository") {
-			return fmt.Errorf("git server at %s does not appear to be serving a valid repository\nError: %s", repoURL, outputStr)
-		}
-		return fmt.Errorf("failed to clone repository from %s: %s", repoURL, outputStr)
+		return fmt.Errorf("failed to fetch from %s: %s", repoURL, outputStr)
 	}
 
 	return nil
 }
+
+// CloneAtCommit makes a local clone of the repository at srcDir in dstDir
+// with branchName checked out at commit and its START label on the same
+// commit, as the task's workspace was when the task started.
+func CloneAtCommit(srcDir, dstDir, branchName, commit string) error {
+	if output, err := exec.Command("git", "clone", "--quiet", "--no-checkout", srcDir, dstDir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clone %s: %s", srcDir, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.Command("git", "-C", dstDir, "checkout", "--quiet", "-B", branchName, commit).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to check out %s at %s: %s", branchName, commit, strings.TrimSpace(string(output)))
+	}
+	if err := cmdutil.RunCommand("git", "-C", dstDir, "branch", "--force", StartLabel(branchName), commit); err != nil {
+		return fmt.Errorf("failed to create START label: %w", err)
+	}
+	return nil
+}
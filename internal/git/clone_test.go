@@ -1,10 +1,14 @@
 package git
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"giverny/internal/testutil"
 )
@@ -26,7 +30,7 @@ func TestCloneRepo(t *testing.T) {
 	testutil.InitTestRepo(t, sourceRepo, "test content")
 
 	// Start git server on the source repository
-	serverCmd, port, err := StartServer(sourceRepo)
+	serverCmd, port, err := StartServer(sourceRepo, "127.0.0.1", "", "giverny/test-task")
 	if err != nil {
 		t.Fatalf("failed to start git server: %v", err)
 	}
@@ -36,9 +40,9 @@ func TestCloneRepo(t *testing.T) {
 	gitDir := t.TempDir()
 
 	// Clone from the local git server using localhost
-	err = CloneRepoFromHost(port, gitDir, "localhost", false)
+	err = CloneRepoFromURL(ServerURL("localhost", port, ""), gitDir, CloneOptions{}, false)
 	if err != nil {
-		t.Errorf("CloneRepoFromHost failed: %v", err)
+		t.Errorf("CloneRepoFromURL failed: %v", err)
 	}
 
 	// Verify the clone was successful by checking the .git directory exists
@@ -47,7 +51,7 @@ func TestCloneRepo(t *testing.T) {
 		t.Error("cloned repository does not contain .git/config")
 	}
 
-	// Since CloneRepoFromHost uses --no-checkout, we need to checkout the files
+	// Since CloneRepoFromURL uses --no-checkout, we need to checkout the files
 	// to verify the clone worked correctly
 	checkoutCmd := exec.Command("git", "checkout", "HEAD")
 	checkoutCmd.Dir = gitDir
@@ -70,3 +74,277 @@ func TestCloneRepo(t *testing.T) {
 		t.Errorf("cloned file content = %q, want %q", string(content), "test content")
 	}
 }
+
+// TestCloneRepoShallowPartial tests a shallow, blob-filtered clone from a git daemon server
+func TestCloneRepoShallowPartial(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") == "" {
+		t.Skip("Skipping integration test. Set INTEGRATION_TEST=1 to run.")
+	}
+
+	// Create a temporary git repository with some history to serve
+	sourceRepo, err := os.MkdirTemp("", "giverny-clone-source-*")
+	if err != nil {
+		t.Fatalf("failed to create source repo dir: %v", err)
+	}
+	defer os.RemoveAll(sourceRepo)
+
+	testutil.InitTestRepo(t, sourceRepo, "test content")
+	cmd := exec.Command("sh", "-c", "echo 'more' > more.txt && git add more.txt && git commit -m 'Second commit' && git branch giverny/shallow")
+	cmd.Dir = sourceRepo
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make second commit: %v", err)
+	}
+
+	serverCmd, port, err := StartServer(sourceRepo, "127.0.0.1", "", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start git server: %v", err)
+	}
+	defer StopServer(serverCmd)
+
+	gitDir := t.TempDir()
+
+	opts := CloneOptions{Depth: 1, Filter: "blob:none"}
+	if err := CloneRepoFromURL(ServerURL("localhost", port, ""), gitDir, opts, false); err != nil {
+		t.Fatalf("CloneRepoFromURL failed: %v", err)
+	}
+
+	// Only one commit of history should have been fetched
+	countCmd := exec.Command("git", "rev-list", "--count", "HEAD")
+	countCmd.Dir = gitDir
+	output, err := countCmd.Output()
+	if err != nil {
+		t.Fatalf("failed to count commits: %v", err)
+	}
+	if got := string(output); got != "1\n" {
+		t.Errorf("expected 1 commit in shallow clone, got %q", got)
+	}
+
+	// Non-default branches must still be available
+	branchCmd := exec.Command("git", "rev-parse", "--verify", "origin/giverny/shallow")
+	branchCmd.Dir = gitDir
+	if err := branchCmd.Run(); err != nil {
+		t.Errorf("expected task branch to be cloned: %v", err)
+	}
+}
+
+// TestFetchRepo tests fetching new commits into an existing clone from a git daemon server
+func TestFetchRepo(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") == "" {
+		t.Skip("Skipping integration test. Set INTEGRATION_TEST=1 to run.")
+	}
+
+	sourceRepo, err := os.MkdirTemp("", "giverny-clone-source-*")
+	if err != nil {
+		t.Fatalf("failed to create source repo dir: %v", err)
+	}
+	defer os.RemoveAll(sourceRepo)
+
+	testutil.InitTestRepo(t, sourceRepo, "test content")
+
+	serverCmd, port, err := StartServer(sourceRepo, "127.0.0.1", "", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start git server: %v", err)
+	}
+	gitDir := t.TempDir()
+	if err := CloneRepoFromURL(ServerURL("localhost", port, ""), gitDir, CloneOptions{}, false); err != nil {
+		t.Fatalf("CloneRepoFromURL failed: %v", err)
+	}
+	StopServer(serverCmd)
+
+	// Add a commit and serve the repository on a new port
+	cmd := exec.Command("sh", "-c", "echo 'more' > more.txt && git add more.txt && git commit -m 'Second commit'")
+	cmd.Dir = sourceRepo
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make second commit: %v", err)
+	}
+	serverCmd, port, err = StartServer(sourceRepo, "127.0.0.1", "", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to restart git server: %v", err)
+	}
+	defer StopServer(serverCmd)
+
+	if err := FetchRepoFromURL(ServerURL("localhost", port, ""), gitDir, false); err != nil {
+		t.Fatalf("FetchRepoFromHost failed: %v", err)
+	}
+
+	countCmd := exec.Command("git", "rev-list", "--count", "origin/main")
+	countCmd.Dir = gitDir
+	output, err := countCmd.Output()
+	if err != nil {
+		t.Fatalf("failed to count commits: %v", err)
+	}
+	if got := string(output); got != "2\n" {
+		t.Errorf("expected 2 commits after fetch, got %q", got)
+	}
+}
+
+// TestCloneRepoRetries tests that a clone is retried until the git server
+// is up, and fails with a hint once it has been retried enough
+func TestCloneRepoRetries(t *testing.T) {
+	defer func(delay time.Duration) { cloneRetryDelay = delay }(cloneRetryDelay)
+	cloneRetryDelay = 50 * time.Millisecond
+
+	sourceRepo := t.TempDir()
+	testutil.InitTestRepo(t, sourceRepo, "test content")
+
+	t.Run("waits for the git server", func(t *testing.T) {
+		port := freePort(t)
+		started := make(chan *ServerCmd, 1)
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			serverCmd, err := StartServerOnPort(sourceRepo, "127.0.0.1", port, "", "giverny/test-task")
+			if err != nil {
+				t.Errorf("failed to start git server: %v", err)
+			}
+			started <- serverCmd
+		}()
+		defer func() { StopServer(<-started) }()
+
+		if err := CloneRepoFromURL(ServerURL("127.0.0.1", port, ""), t.TempDir(), CloneOptions{}, false); err != nil {
+			t.Errorf("CloneRepoFromURL failed: %v", err)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		cloneRetryDelay = time.Millisecond
+		port := freePort(t)
+		err := CloneRepoFromURL(ServerURL("127.0.0.1", port, ""), t.TempDir(), CloneOptions{}, false)
+		if err == nil {
+			t.Fatal("expected an error with no git server")
+		}
+		for _, want := range []string{
+			fmt.Sprintf("after %d attempts", maxCloneAttempts),
+			fmt.Sprintf("git ls-remote git://127.0.0.1:%d/", port),
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected %q in error, got: %v", want, err)
+			}
+		}
+	})
+}
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestCloneAtCommit(t *testing.T) {
+	t.Parallel()
+
+	srcDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	testutil.InitTestRepo(t, srcDir, "first")
+	start, err := GetCommitHashInDir(srcDir, "HEAD")
+	if err != nil {
+		t.Fatalf("failed to get start commit: %v", err)
+	}
+	cmd := testutil.Command(srcDir, "sh", "-c", "echo second > test.txt && git commit -q -am 'Second'")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit: %v\n%s", err, output)
+	}
+
+	dstDir := filepath.Join(t.TempDir(), "clone")
+	if err := CloneAtCommit(srcDir, dstDir, "giverny/TASK-1", start); err != nil {
+		t.Fatalf("CloneAtCommit failed: %v", err)
+	}
+
+	for _, ref := range []string{"HEAD", "giverny/TASK-1-START"} {
+		if got, _ := GetCommitHashInDir(dstDir, ref); got != start {
+			t.Errorf("expected %s at %s, got %s", ref, start, got)
+		}
+	}
+	if branch, _ := testutil.Command(dstDir, "git", "branch", "--show-current").Output(); string(branch) != "giverny/TASK-1\n" {
+		t.Errorf("expected giverny/TASK-1 checked out, got %q", branch)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dstDir, "test.txt")); string(content) != "first" {
+		t.Errorf("expected the start commit's files, got %q", content)
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		host     string
+		token    string
+		expected string
+	}{
+		{"host.docker.internal", "", "git://host.docker.internal:2345/"},
+		{"giverny-task-1-git", "", "git://giverny-task-1-git:2345/"},
+		{"fd00::1", "", "git://[fd00::1]:2345/"},
+		{"host.docker.internal", "0123abcd", "git://host.docker.internal:2345/0123abcd"},
+	}
+	for _, tt := range tests {
+		if got := ServerURL(tt.host, 2345, tt.token); got != tt.expected {
+			t.Errorf("ServerURL(%q, %q) = %q, want %q", tt.host, tt.token, got, tt.expected)
+		}
+	}
+}
+
+func TestRepoURL(t *testing.T) {
+	tests := []struct {
+		env      string
+		port     int
+		expected string
+	}{
+		{"", 2345, "git://host.docker.internal:2345/"},
+		{"git://giverny-task-1-git:9418/", 9418, "git://giverny-task-1-git:9418/"},
+		{"git://host.docker.internal:2345/0123abcd", 2345, "git://host.docker.internal:2345/0123abcd"},
+		// Outie moved the git server of a reused container
+		{"git://tunnel.example.com:2345/0123abcd", 5353, "git://tunnel.example.com:5353/0123abcd"},
+		{"git://[fd00::1]:2345/", 5353, "git://[fd00::1]:5353/"},
+	}
+	for _, tt := range tests {
+		t.Setenv(ServerURLEnv, tt.env)
+		if got := RepoURL(tt.port); got != tt.expected {
+			t.Errorf("RepoURL(%d) with %q = %q, want %q", tt.port, tt.env, got, tt.expected)
+		}
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	if got := ServerToken(ServerURL("host.docker.internal", 2345, "0123abcd")); got != "0123abcd" {
+		t.Errorf("ServerToken() = %q, want 0123abcd", got)
+	}
+	if got := ServerToken(ServerURL("giverny-task-1-git", 9418, "")); got != "" {
+		t.Errorf("ServerToken() = %q, want none", got)
+	}
+}
+
+func TestServerCheckHint(t *testing.T) {
+	hint := serverCheckHint("git://host.docker.internal:2345/0123abcd")
+	if !strings.Contains(hint, "git ls-remote git://127.0.0.1:2345/0123abcd") || !strings.Contains(hint, pidFilePrefix) {
+		t.Errorf("expected a hint checking the daemon on the host, got %q", hint)
+	}
+	hint = serverCheckHint("git://giverny-task-1-git:9418/")
+	if !strings.Contains(hint, "git ls-remote git://giverny-task-1-git:9418/") || !strings.Contains(hint, "sidecar") {
+		t.Errorf("expected a hint checking the sidecar, got %q", hint)
+	}
+}
+
+func TestReadServerPort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "git-server-port")
+	if got := readServerPort(path, 2345); got != 2345 {
+		t.Errorf("readServerPort() without a file = %d, want 2345", got)
+	}
+	if err := os.WriteFile(path, []byte("4567\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readServerPort(path, 2345); got != 4567 {
+		t.Errorf("readServerPort() = %d, want 4567", got)
+	}
+	if err := os.WriteFile(path, []byte("nonsense"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readServerPort(path, 2345); got != 2345 {
+		t.Errorf("readServerPort() with an invalid file = %d, want 2345", got)
+	}
+}
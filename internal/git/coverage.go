@@ -0,0 +1,46 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// percentPattern matches a percentage such as "71.2%" in a coverage
+// command's output
+var percentPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)%`)
+
+// MeasureCoverage runs command through sh in a temporary worktree of the
+// current git repository checked out at ref, and returns the coverage it
+// reports and its output. The coverage is the last percentage in the output,
+// which is where tools such as `go tool cover -func` print the total.
+func MeasureCoverage(ref, command string) (float64, string, error) {
+	return MeasureCoverageInDir(".", ref, command)
+}
+
+// MeasureCoverageInDir is MeasureCoverage for the git repository at dir.
+func MeasureCoverageInDir(dir, ref, command string) (float64, string, error) {
+	output, err := RunAtRefInDir(dir, ref, command)
+	if err != nil {
+		return 0, output, err
+	}
+	coverage, err := parseCoverage(output)
+	if err != nil {
+		return 0, output, fmt.Errorf("coverage command at %s: %w", ref, err)
+	}
+	return coverage, output, nil
+}
+
+// parseCoverage returns the last percentage in output
+func parseCoverage(output string) (float64, error) {
+	matches := percentPattern.FindAllStringSubmatch(strings.TrimSpace(output), -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no coverage percentage in its output")
+	}
+	coverage, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coverage percentage: %w", err)
+	}
+	return coverage, nil
+}
@@ -0,0 +1,48 @@
+package git
+
+import (
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestMeasureCoverage(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git branch start && echo 'total: (statements) 82.5%' > coverage.txt && git add coverage.txt && git commit -q -m 'Coverage'")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to make commits: %v\n%s", err, output)
+	}
+
+	// The command sees each ref's files, and the last percentage counts
+	command := "echo 'pkg 10.0%'; cat coverage.txt 2>/dev/null || echo 'total 50%'"
+	coverage, _, err := MeasureCoverageInDir(tmpDir, "start", command)
+	if err != nil {
+		t.Fatalf("MeasureCoverage failed: %v", err)
+	}
+	if coverage != 50 {
+		t.Errorf("expected 50%% at start, got %v", coverage)
+	}
+	coverage, output, err := MeasureCoverageInDir(tmpDir, "HEAD", command)
+	if err != nil {
+		t.Fatalf("MeasureCoverage failed: %v", err)
+	}
+	if coverage != 82.5 || !strings.Contains(output, "82.5%") {
+		t.Errorf("expected 82.5%% at HEAD, got %v from %q", coverage, output)
+	}
+
+	if _, _, err := MeasureCoverageInDir(tmpDir, "HEAD", "echo no coverage here"); err == nil {
+		t.Error("expected an error for output without a percentage")
+	}
+	if _, _, err := MeasureCoverageInDir(tmpDir, "HEAD", "echo 90%; false"); err == nil {
+		t.Error("expected an error for a failing command")
+	}
+
+	// The temporary worktrees are cleaned up
+	if output, _ := testutil.Command(tmpDir, "git", "worktree", "list").Output(); strings.Count(string(output), "\n") != 1 {
+		t.Errorf("expected only the main worktree, got:\n%s", output)
+	}
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// Credential is a username and password (or token) for a git host
+type Credential struct {
+	Username string
+	Password string
+}
+
+// credentialHostPattern matches a host name with an optional port
+var credentialHostPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?$`)
+
+// ValidateCredentialHost checks that host is a bare host name, optionally
+// with a port, e.g. "github.com" or "git.corp.example.com:8443"
+func ValidateCredentialHost(host string) error {
+	if !credentialHostPattern.MatchString(host) {
+		return fmt.Errorf("'%s' is not a host name (give it without a scheme or path, e.g. 'github.com')", host)
+	}
+	return nil
+}
+
+// FillCredential asks the host's git credential helpers for the HTTPS
+// credential of host, without prompting. It returns an error if none of them
+// has one.
+func FillCredential(host string) (*Credential, error) {
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("no git credential found for %s: %w", host, err)
+	}
+	fields, err := ParseCredentialFields(strings.NewReader(string(output)))
+	if err != nil {
+		return nil, err
+	}
+	if fields["password"] == "" {
+		return nil, fmt.Errorf("no git credential found for %s", host)
+	}
+	return &Credential{Username: fields["username"], Password: fields["password"]}, nil
+}
+
+// ParseCredentialFields reads key=value lines in git's credential helper
+// format, up to a blank line or the end of r
+func ParseCredentialFields(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credential: %w", err)
+	}
+	return fields, nil
+}
+
+// Format returns the credential in git's credential helper format, ending
+// with a blank line
+func (c *Credential) Format() string {
+	return fmt.Sprintf("username=%s\npassword=%s\n\n", c.Username, c.Password)
+}
+
+// ConfigureCredentialHelper makes helper the user's only git credential
+// helper for HTTPS URLs on host, in the global git config
+func ConfigureCredentialHelper(host, helper string) error {
+	key := fmt.Sprintf("credential.https://%s.helper", host)
+	// An empty helper first clears any inherited helpers for the host
+	if err := cmdutil.RunCommand("git", "config", "--global", "--replace-all", key, ""); err != nil {
+		return fmt.Errorf("failed to configure credential helper for %s: %w", host, err)
+	}
+	if err := cmdutil.RunCommand("git", "config", "--global", "--add", key, helper); err != nil {
+		return fmt.Errorf("failed to configure credential helper for %s: %w", host, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCredentialHost(t *testing.T) {
+	for _, host := range []string{"github.com", "git.corp.example.com:8443", "localhost"} {
+		if err := ValidateCredentialHost(host); err != nil {
+			t.Errorf("ValidateCredentialHost(%q) = %v, want nil", host, err)
+		}
+	}
+	for _, host := range []string{"", "https://github.com", "github.com/org", "-github.com", "github.com:", "user@github.com"} {
+		if err := ValidateCredentialHost(host); err == nil {
+			t.Errorf("ValidateCredentialHost(%q) = nil, want an error", host)
+		}
+	}
+}
+
+func TestFillCredential(t *testing.T) {
+	// A global config whose helper only knows one host
+	configPath := filepath.Join(t.TempDir(), "gitconfig")
+	helper := `!f() { test "$1" = get || exit 0; grep -q '^host=git.example.com$' && printf 'username=me\npassword=s3cret\n'; }; f`
+	if err := os.WriteFile(configPath, []byte("[credential]\n\thelper = \""+strings.ReplaceAll(helper, `\`, `\\`)+"\"\n"), 0644); err != nil {
+		t.Fatalf("failed to write git config: %v", err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", configPath)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	credential, err := FillCredential("git.example.com")
+	if err != nil {
+		t.Fatalf("FillCredential failed: %v", err)
+	}
+	if credential.Username != "me" || credential.Password != "s3cret" {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+	if got := credential.Format(); got != "username=me\npassword=s3cret\n\n" {
+		t.Errorf("Format() = %q", got)
+	}
+
+	if _, err := FillCredential("other.example.com"); err == nil {
+		t.Error("expected an error for a host the helper has no credential for")
+	}
+}
+
+func TestParseCredentialFields(t *testing.T) {
+	fields, err := ParseCredentialFields(strings.NewReader("protocol=https\nhost=github.com\npath=a=b\n\nignored=1\n"))
+	if err != nil {
+		t.Fatalf("ParseCredentialFields failed: %v", err)
+	}
+	if len(fields) != 3 || fields["host"] != "github.com" || fields["path"] != "a=b" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
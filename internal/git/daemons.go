@@ -0,0 +1,227 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pidFilePrefix starts the name of the PID file of every git server giverny
+// starts, which is on the daemon's command line, so it can be told apart from
+// other git daemons
+const pidFilePrefix = "giverny-git-daemon-"
+
+// Daemon is a git server giverny started, found among the host's processes
+type Daemon struct {
+	PID      int
+	Port     int
+	BasePath string // the repository it serves, or the directory linking to it under a token
+	Age      time.Duration
+
+	// child is the PID of the git-daemon process git runs, 0 if not found
+	child int
+}
+
+// Repository returns the path of the repository the git server serves
+func (d Daemon) Repository() string {
+	if !strings.HasPrefix(filepath.Base(d.BasePath), serveDirPrefix) {
+		return d.BasePath
+	}
+	entries, err := os.ReadDir(d.BasePath)
+	if err != nil {
+		return d.BasePath
+	}
+	for _, entry := range entries {
+		if entry.Name() == hooksDirName {
+			continue
+		}
+		if repoPath, err := os.Readlink(filepath.Join(d.BasePath, entry.Name())); err == nil {
+			return repoPath
+		}
+	}
+	return d.BasePath
+}
+
+// ListDaemons returns the git servers giverny started that are running on
+// this host, whichever giverny started them. Without ps, as on Windows, it
+// finds none. -ww stops ps cutting the arguments off at the terminal's
+// width, or $COLUMNS, which would lose the --pid-file and --port they are
+// found by.
+func ListDaemons() ([]Daemon, error) {
+	output, err := exec.Command("ps", "-A", "-ww", "-o", "pid=,etime=,args=").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	return parseDaemons(string(output)), nil
+}
+
+// parseDaemons picks giverny's git servers out of the output of
+// ps -o pid=,etime=,args=. git daemon runs as git with a git-daemon child
+// serving the same port; they are returned as one git server.
+func parseDaemons(output string) []Daemon {
+	var daemons []Daemon
+	byPort := map[int]int{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(filepath.Base(fields[2]), "git") || !strings.Contains(line, "--pid-file=") || !strings.Contains(line, pidFilePrefix) {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		age, err := parseElapsed(fields[1])
+		if err != nil {
+			continue
+		}
+		daemon := Daemon{PID: pid, Age: age}
+		for _, arg := range fields[2:] {
+			if port, ok := strings.CutPrefix(arg, "--port="); ok {
+				daemon.Port, _ = strconv.Atoi(port)
+			} else if basePath, ok := strings.CutPrefix(arg, "--base-path="); ok {
+				daemon.BasePath = basePath
+			}
+		}
+		if daemon.Port == 0 {
+			continue
+		}
+		if i, ok := byPort[daemon.Port]; ok {
+			if filepath.Base(fields[2]) == "git" {
+				daemon.child = daemons[i].PID
+				daemons[i] = daemon
+			} else {
+				daemons[i].child = daemon.PID
+			}
+			continue
+		}
+		byPort[daemon.Port] = len(daemons)
+		daemons = append(daemons, daemon)
+	}
+	return daemons
+}
+
+// parseElapsed parses the time a process has been running as ps shows it,
+// [[DD-]HH:]MM:SS
+func parseElapsed(s string) (time.Duration, error) {
+	var days int
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return 0, fmt.Errorf("invalid elapsed time %q", s)
+		}
+		days, s = n, rest
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid elapsed time %q", s)
+	}
+	var seconds int
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid elapsed time %q", s)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(seconds)*time.Second, nil
+}
+
+// KillDaemon stops a git server found by ListDaemons. The git process of one
+// leads a process group with the daemon, which is killed with it; otherwise,
+// as with servers started before giverny used process groups, the git process
+// and its git-daemon child are killed one by one. The directory linking to
+// the repository under the server's token is removed.
+func KillDaemon(daemon Daemon) error {
+	if isProcessGroupLeader(daemon.PID) {
+		if err := killProcessGroup(daemon.PID); err != nil {
+			return fmt.Errorf("failed to stop git server (PID %d): %w", daemon.PID, err)
+		}
+	} else {
+		if err := killProcess(daemon.PID); err != nil {
+			return err
+		}
+		if daemon.child != 0 {
+			if err := killProcess(daemon.child); err != nil {
+				return err
+			}
+		}
+	}
+	if strings.HasPrefix(filepath.Base(daemon.BasePath), serveDirPrefix) {
+		os.RemoveAll(daemon.BasePath)
+	}
+	return nil
+}
+
+// killProcess kills the git server process pid, if it is still running
+func killProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find git server (PID %d): %w", pid, err)
+	}
+	if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to stop git server (PID %d): %w", pid, err)
+	}
+	return nil
+}
+
+// reclaimTimeout is how long ReclaimPort waits for the port to be freed
+const reclaimTimeout = 2 * time.Second
+
+// ReclaimPort makes port free for a git server serving repoPath, stopping
+// any git server giverny left running on it for the repository, such as
+// after a crash. It reports false if something else holds the port.
+func ReclaimPort(repoPath string, port int) (bool, error) {
+	if portFree(port) {
+		return true, nil
+	}
+	daemons, err := ListDaemons()
+	if err != nil {
+		return false, err
+	}
+	var stale []Daemon
+	for _, daemon := range daemons {
+		if daemon.Port != port {
+			continue
+		}
+		if daemon.Repository() != repoPath {
+			return false, nil
+		}
+		stale = append(stale, daemon)
+	}
+	if len(stale) == 0 {
+		return false, nil
+	}
+	for _, daemon := range stale {
+		if err := KillDaemon(daemon); err != nil {
+			return false, err
+		}
+	}
+	deadline := time.Now().Add(reclaimTimeout)
+	for !portFree(port) {
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(pidPollInterval)
+	}
+	return true, nil
+}
+
+// portFree reports whether nothing on this host listens on port
+func portFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
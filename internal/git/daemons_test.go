@@ -0,0 +1,116 @@
+package git
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+
+	"giverny/internal/testutil"
+)
+
+func TestParseDaemons(t *testing.T) {
+	output := `    1    02:35:15 /sbin/init
+  812 1-04:00:10 git -c uploadpack.allowFilter=true -c receive.denyDeletes=true daemon --base-path=/src/app --enable=receive-pack --reuseaddr --port=4242 --export-all --verbose --pid-file=/tmp/giverny-git-daemon-123.pid
+  813 1-04:00:09 /usr/lib/git-core/git-daemon --base-path=/src/app --enable=receive-pack --reuseaddr --port=4242 --export-all --verbose --pid-file=/tmp/giverny-git-daemon-123.pid
+  900       00:05 git daemon --base-path=/srv/git --port=9418 --pid-file=/run/git-daemon.pid
+  901       00:03 grep --pid-file= giverny-git-daemon-
+`
+	got := parseDaemons(output)
+	want := []Daemon{
+		{PID: 812, Port: 4242, BasePath: "/src/app", Age: 28*time.Hour + 10*time.Second, child: 813},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseDaemons() = %v, want %v", got, want)
+	}
+}
+
+func TestParseElapsed(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"00:05", 5 * time.Second, false},
+		{"12:34", 12*time.Minute + 34*time.Second, false},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"3-00:00:00", 72 * time.Hour, false},
+		{"5", 0, true},
+		{"x-01:00", 0, true},
+		{"aa:bb", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseElapsed(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.expected {
+			t.Errorf("parseElapsed(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.expected, tt.wantErr)
+		}
+	}
+}
+
+func TestListDaemons(t *testing.T) {
+	if _, err := exec.LookPath("ps"); err != nil {
+		t.Skip("ps not available")
+	}
+	repoPath := t.TempDir()
+	testutil.InitTestRepo(t, repoPath)
+	serverCmd, port, err := StartServer(repoPath, "127.0.0.1", "", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start git server: %v", err)
+	}
+	defer StopServer(serverCmd)
+
+	for _, columns := range []string{"", "100"} {
+		t.Run("COLUMNS="+columns, func(t *testing.T) {
+			// ps cuts its output to $COLUMNS, but mustn't cut the arguments
+			if columns != "" {
+				t.Setenv("COLUMNS", columns)
+			}
+			daemons, err := ListDaemons()
+			if err != nil {
+				t.Fatalf("ListDaemons() error = %v", err)
+			}
+			if !slices.ContainsFunc(daemons, func(d Daemon) bool { return d.PID == serverCmd.Process.Pid && d.Port == port }) {
+				t.Errorf("expected the git server (PID %d, port %d) among %v", serverCmd.Process.Pid, port, daemons)
+			}
+		})
+	}
+}
+
+func TestReclaimPort(t *testing.T) {
+	if _, err := exec.LookPath("ps"); err != nil {
+		t.Skip("ps not available")
+	}
+	repoPath := t.TempDir()
+	testutil.InitTestRepo(t, repoPath)
+
+	// A git server left running for the repository is stopped
+	serverCmd, port, err := StartServer(repoPath, "127.0.0.1", "0123abcd", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start git server: %v", err)
+	}
+	defer StopServer(serverCmd)
+	if free, err := ReclaimPort(t.TempDir(), port); err != nil || free {
+		t.Errorf("ReclaimPort() for another repository = %v, %v; want false", free, err)
+	}
+	if free, err := ReclaimPort(repoPath, port); err != nil || !free {
+		t.Fatalf("ReclaimPort() = %v, %v; want true", free, err)
+	}
+	if !portFree(port) {
+		t.Errorf("expected port %d to be free", port)
+	}
+	if _, err := os.Stat(serverCmd.serveDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", serverCmd.serveDir)
+	}
+
+	// Nothing else is
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if free, err := ReclaimPort(repoPath, listener.Addr().(*net.TCPAddr).Port); err != nil || free {
+		t.Errorf("ReclaimPort() for a port held by another program = %v, %v; want false", free, err)
+	}
+}
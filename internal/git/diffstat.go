@@ -0,0 +1,117 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FileStat describes how a file changed between two commits.
+type FileStat struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`   // lines added; 0 for binary files
+	Deleted int    `json:"deleted"` // lines deleted; 0 for binary files
+	Binary  bool   `json:"binary"`  // git considers the file binary
+	Size    int64  `json:"size"`    // size in bytes at HEAD; 0 if the file was deleted
+}
+
+// DiffStats returns per-file change statistics between fromRef and HEAD in
+// the current git repository.
+func DiffStats(fromRef string) ([]FileStat, error) {
+	return DiffStatsBetweenInDir(".", fromRef, "HEAD")
+}
+
+// DiffStatsBetween returns per-file change statistics between fromRef and
+// toRef in the current git repository. Sizes are taken from toRef.
+func DiffStatsBetween(fromRef, toRef string) ([]FileStat, error) {
+	return DiffStatsBetweenInDir(".", fromRef, toRef)
+}
+
+// DiffStatsBetweenInDir is DiffStatsBetween for the git repository at dir.
+func DiffStatsBetweenInDir(dir, fromRef, toRef string) ([]FileStat, error) {
+	cmd := exec.Command("git", "-C", dir, "diff", "--numstat", "--no-renames", fromRef, toRef)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff stats between %s and %s: %w", fromRef, toRef, err)
+	}
+
+	var stats []FileStat
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		stat, err := parseNumstatLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if sizeOutput, err := exec.Command("git", "-C", dir, "cat-file", "-s", toRef+":"+stat.Path).Output(); err == nil {
+			stat.Size, _ = strconv.ParseInt(strings.TrimSpace(string(sizeOutput)), 10, 64)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// parseNumstatLine parses a line of `git diff --numstat` output, which is
+// "ADDED<TAB>DELETED<TAB>PATH" with "-" for both counts on binary files.
+func parseNumstatLine(line string) (FileStat, error) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) != 3 {
+		return FileStat{}, fmt.Errorf("unexpected numstat line: %q", line)
+	}
+
+	stat := FileStat{Path: fields[2]}
+	if fields[0] == "-" && fields[1] == "-" {
+		stat.Binary = true
+		return stat, nil
+	}
+
+	var err error
+	if stat.Added, err = strconv.Atoi(fields[0]); err != nil {
+		return FileStat{}, fmt.Errorf("unexpected numstat line: %q", line)
+	}
+	if stat.Deleted, err = strconv.Atoi(fields[1]); err != nil {
+		return FileStat{}, fmt.Errorf("unexpected numstat line: %q", line)
+	}
+	return stat, nil
+}
+
+// NoiseFilesBetweenInDir lists the files modified between fromRef and toRef in
+// the git repository at dir whose only changes are to whitespace, line endings
+// or the file mode.
+func NoiseFilesBetweenInDir(dir, fromRef, toRef string) ([]string, error) {
+	modified, err := exec.Command("git", "-C", dir, "diff", "--name-only", "--no-renames", "--diff-filter=M", fromRef, toRef).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modified files between %s and %s: %w", fromRef, toRef, err)
+	}
+	// Ignoring whitespace, files with only whitespace changes drop out and
+	// files with only mode changes have no lines changed
+	output, err := exec.Command("git", "-C", dir, "diff", "--numstat", "--no-renames", "--diff-filter=M",
+		"--ignore-all-space", "--ignore-blank-lines", "--ignore-cr-at-eol", fromRef, toRef).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff stats between %s and %s: %w", fromRef, toRef, err)
+	}
+
+	substantive := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		stat, err := parseNumstatLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if stat.Binary || stat.Added > 0 || stat.Deleted > 0 {
+			substantive[stat.Path] = true
+		}
+	}
+
+	var noise []string
+	for _, path := range strings.Split(string(modified), "\n") {
+		if path != "" && !substantive[path] {
+			noise = append(noise, path)
+		}
+	}
+	return noise, nil
+}
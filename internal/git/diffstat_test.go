@@ -0,0 +1,93 @@
+package git
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestDiffStats(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	if err := CreateBranchInDir(tmpDir, "start", ""); err != nil {
+		t.Fatalf("failed to create start branch: %v", err)
+	}
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", "printf 'a\\nb\\nc\\n' > lines.txt && head -c 2048 /dev/zero > blob.bin && git rm -q test.txt && git add . && git commit -m 'Agent commit'")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commit: %v", err)
+	}
+
+	stats, err := DiffStatsBetweenInDir(tmpDir, "start", "HEAD")
+	if err != nil {
+		t.Fatalf("DiffStats failed: %v", err)
+	}
+
+	expected := []FileStat{
+		{Path: "blob.bin", Binary: true, Size: 2048},
+		{Path: "lines.txt", Added: 3, Size: 6},
+		{Path: "test.txt", Deleted: 1},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d file stats, got %+v", len(expected), stats)
+	}
+	for i, want := range expected {
+		if stats[i] != want {
+			t.Errorf("stats[%d] = %+v, want %+v", i, stats[i], want)
+		}
+	}
+}
+
+func TestNoiseFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", `printf 'a\nb\n' > crlf.txt && echo c > mode.sh && echo d > space.txt && echo e > real.txt &&
+		git add . && git commit -q -m 'Base' && git branch start &&
+		printf 'a\r\nb\r\n' > crlf.txt && chmod +x mode.sh && printf '  d  \n\n' > space.txt && echo f > real.txt && echo g > new.txt &&
+		git add . && git commit -q -m 'Agent commit'`)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to make commits: %v\n%s", err, output)
+	}
+
+	noise, err := NoiseFilesBetweenInDir(tmpDir, "start", "HEAD")
+	if err != nil {
+		t.Fatalf("NoiseFiles failed: %v", err)
+	}
+	expected := []string{"crlf.txt", "mode.sh", "space.txt"}
+	if strings.Join(noise, " ") != strings.Join(expected, " ") {
+		t.Errorf("NoiseFiles = %v, want %v", noise, expected)
+	}
+}
+
+func TestParseNumstatLine(t *testing.T) {
+	t.Parallel()
+
+	if _, err := parseNumstatLine("garbage"); err == nil {
+		t.Error("expected error for malformed line")
+	}
+	stat, err := parseNumstatLine("-\t-\timage.png")
+	if err != nil {
+		t.Fatalf("parseNumstatLine failed: %v", err)
+	}
+	if !stat.Binary || stat.Path != "image.png" {
+		t.Errorf("expected binary image.png, got %+v", stat)
+	}
+}
@@ -0,0 +1,52 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// ExcludePath keeps git in the workspace from tracking files matching
+// pattern, without touching the project's .gitignore, by adding it to the
+// repository's info/exclude.
+func ExcludePath(pattern string) error {
+	return ExcludePathInDir(DefaultWorkspaceDir, pattern)
+}
+
+// ExcludePathInDir is ExcludePath for the workspace in dir.
+func ExcludePathInDir(dir, pattern string) error {
+	excludeFile, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "rev-parse", "--path-format=absolute", "--git-path", "info/exclude")
+	if err != nil {
+		return fmt.Errorf("failed to find info/exclude: %w", err)
+	}
+	data, err := os.ReadFile(excludeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", excludeFile, err)
+	}
+	if slices.Contains(strings.Split(string(data), "\n"), pattern) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(excludeFile), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(excludeFile), err)
+	}
+	file, err := os.OpenFile(excludeFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", excludeFile, err)
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		pattern = "\n" + pattern
+	}
+	_, err = fmt.Fprintln(file, pattern)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", excludeFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestExcludePathInDir(t *testing.T) {
+	t.Parallel()
+
+	cloneDir := t.TempDir()
+	testutil.InitTestRepo(t, cloneDir)
+	workspaceDir := filepath.Join(t.TempDir(), "app")
+	if output, err := testutil.Command(cloneDir, "git", "worktree", "add", "-q", "-b", "giverny/task", workspaceDir).CombinedOutput(); err != nil {
+		t.Fatalf("failed to add worktree: %v\n%s", err, output)
+	}
+
+	// Twice, to check the pattern is only added once
+	for range 2 {
+		if err := ExcludePathInDir(workspaceDir, "/.giverny/PLAN.md"); err != nil {
+			t.Fatalf("ExcludePathInDir failed: %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(cloneDir, ".git", "info", "exclude"))
+	if err != nil {
+		t.Fatalf("failed to read info/exclude: %v", err)
+	}
+	if count := strings.Count(string(data), "/.giverny/PLAN.md\n"); count != 1 {
+		t.Errorf("expected the pattern once in info/exclude, found it %d times:\n%s", count, data)
+	}
+
+	if err := os.MkdirAll(filepath.Join(workspaceDir, ".giverny"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspaceDir, ".giverny", "PLAN.md"), []byte("plan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := testutil.Command(workspaceDir, "git", "status", "--porcelain").Output()
+	if err != nil {
+		t.Fatalf("git status failed: %v", err)
+	}
+	if len(output) != 0 {
+		t.Errorf("expected the excluded file to be untracked and hidden, got status:\n%s", output)
+	}
+}
@@ -1,11 +1,17 @@
 package git
 
 import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,15 +23,33 @@ const (
 	pidPollInterval = 10 * time.Millisecond
 )
 
-// StartServer starts a git daemon server on a random port between 2001-9999.
-// It enables receive-pack to allow pushing and retries on port conflicts.
+// serveDirPrefix starts the name of the directory a git server serves the
+// repository from under its token
+const serveDirPrefix = "giverny-git-serve-"
+
+// NewServerToken returns a random token for a git server to serve the
+// repository under, so that only a client told it, the task's container, can
+// fetch from or push to the server
+func NewServerToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate git server token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// StartServer starts a git daemon server on a random port between 2001-9999,
+// listening on bindAddr, or on all interfaces if it is empty. The repository
+// is served under token, as ServerURL gives it, or at / if token is empty.
+// It enables receive-pack to allow pushing, of only the refs of the task on
+// branchName (see UpdateHook), and retries on port conflicts.
 // Returns the process command, the port number, and any error.
-func StartServer(repoPath string) (*ServerCmd, int, error) {
+func StartServer(repoPath, bindAddr, token, branchName string) (*ServerCmd, int, error) {
 	var lastErr error
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		port := randomPort()
-		cmd, err := tryStartServer(repoPath, port)
+		cmd, err := tryStartServer(repoPath, bindAddr, port, token, branchName)
 		if err == nil {
 			return cmd, port, nil
 		}
@@ -35,21 +59,80 @@ func StartServer(repoPath string) (*ServerCmd, int, error) {
 	return nil, 0, fmt.Errorf("failed to start git server after %d attempts: %w", maxRetries, lastErr)
 }
 
+// StartServerOnPort starts a git daemon server on the given port. This is used
+// when a container that was started against an earlier server is reused, since
+// the container's clone still points at the old port.
+// Returns the process command and any error.
+func StartServerOnPort(repoPath, bindAddr string, port int, token, branchName string) (*ServerCmd, error) {
+	return tryStartServer(repoPath, bindAddr, port, token, branchName)
+}
+
 // randomPort generates a random port number in the valid range
 func randomPort() int {
 	return minPort + rand.Intn(maxPort-minPort+1)
 }
 
-// ServerCmd wraps exec.Cmd and tracks the actual daemon PID
+// ServerCmd wraps the exec.Cmd of a git server. git runs git daemon as a
+// child, which forks a process for each connection, so the command is started
+// in a process group of its own, led by the git process, and the server is
+// stopped by killing the group.
 type ServerCmd struct {
 	*exec.Cmd
-	ActualPid int
+	// bindAddr is the address it listens on, token the path it serves the
+	// repository under and branchName the task branch its hook lets be
+	// pushed, kept for restarts
+	bindAddr   string
+	token      string
+	branchName string
+	// serveDir is the directory holding the server's hooks and the link
+	// named token to the repository, removed when the server stops
+	serveDir string
+
+	// While the server is supervised, Cmd is replaced when it is restarted,
+	// under mu
+	mu       sync.Mutex
+	stopping bool
+	// supervised is closed when the supervisor has finished, which waits on
+	// the process in place of StopServer
+	supervised chan struct{}
+}
+
+// DaemonArgs returns the git arguments that run a git daemon serving the
+// repository at repoPath on port, with the hooks in hooksDir (see
+// UpdateHook), as the host and the git server sidecar both run it
+func DaemonArgs(repoPath, hooksDir string, port int) []string {
+	// Allow partial clones (--filter) from the container, and refuse pushes
+	// that delete refs on the host. The settings are inherited by the
+	// upload-pack and receive-pack processes the daemon spawns.
+	return []string{
+		"-c", "uploadpack.allowFilter=true",
+		"-c", "receive.denyDeletes=true",
+		"-c", "core.hooksPath=" + hooksDir,
+		"daemon",
+		"--base-path=" + repoPath,
+		"--enable=receive-pack",
+		"--reuseaddr",
+		fmt.Sprintf("--port=%d", port),
+		"--export-all",
+		"--verbose",
+	}
 }
 
 // tryStartServer attempts to start git daemon on the specified port
-func tryStartServer(repoPath string, port int) (*ServerCmd, error) {
+func tryStartServer(repoPath, bindAddr string, port int, token, branchName string) (*ServerCmd, error) {
+	basePath, serveDir, err := serveUnderToken(repoPath, token, branchName)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			os.RemoveAll(serveDir)
+		}
+	}()
+
 	// Create a temporary PID file
-	pidFile, err := os.CreateTemp("", "giverny-git-daemon-*.pid")
+	pidFile, err := os.CreateTemp("", pidFilePrefix+"*.pid")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PID file: %w", err)
 	}
@@ -57,15 +140,12 @@ func tryStartServer(repoPath string, port int) (*ServerCmd, error) {
 	pidFile.Close()
 	defer os.Remove(pidFilePath)
 
-	cmd := exec.Command("git", "daemon",
-		"--base-path="+repoPath,
-		"--enable=receive-pack",
-		"--reuseaddr",
-		fmt.Sprintf("--port=%d", port),
-		"--export-all",
-		"--verbose",
-		"--pid-file="+pidFilePath,
-	)
+	args := append(DaemonArgs(basePath, filepath.Join(serveDir, hooksDirName), port), "--pid-file="+pidFilePath)
+	if bindAddr != "" {
+		args = append(args, "--listen="+bindAddr)
+	}
+	cmd := exec.Command("git", args...)
+	newProcessGroup(cmd)
 
 	// Start the server
 	if err := cmd.Start(); err != nil {
@@ -76,15 +156,43 @@ func tryStartServer(repoPath string, port int) (*ServerCmd, error) {
 		return nil, fmt.Errorf("failed to start git server on port %d: %w", port, err)
 	}
 
-	// Poll for the PID file to be created with valid content
-	actualPid, err := pollForPidFile(pidFilePath, startupTimeout, os.ReadFile)
-	if err != nil {
-		cmd.Process.Kill()
+	// The daemon writes its PID file once it is up
+	if _, err := pollForPidFile(pidFilePath, startupTimeout, os.ReadFile); err != nil {
+		killProcessGroup(cmd.Process.Pid)
 		cmd.Wait()
 		return nil, fmt.Errorf("failed to start git server on port %d: %w", port, err)
 	}
 
-	return &ServerCmd{Cmd: cmd, ActualPid: actualPid}, nil
+	started = true
+	return &ServerCmd{Cmd: cmd, bindAddr: bindAddr, token: token, branchName: branchName, serveDir: serveDir}, nil
+}
+
+// serveUnderToken returns the base path for git daemon to serve the
+// repository at repoPath under token, and a new directory, only readable by
+// this user, holding the server's hooks for the task on branchName, for
+// removal. Under a token, the
+// directory is the base path, and holds a link named token to the repository;
+// without one, the repository itself is the base path.
+func serveUnderToken(repoPath, token, branchName string) (basePath, serveDir string, err error) {
+	if token == "." || token == ".." || token == hooksDirName || strings.ContainsAny(token, `/\`) {
+		return "", "", fmt.Errorf("invalid git server token %q", token)
+	}
+	serveDir, err = os.MkdirTemp("", serveDirPrefix+"*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create git server directory: %w", err)
+	}
+	if err := writeHooks(filepath.Join(serveDir, hooksDirName), branchName); err != nil {
+		os.RemoveAll(serveDir)
+		return "", "", err
+	}
+	if token == "" {
+		return repoPath, serveDir, nil
+	}
+	if err := os.Symlink(repoPath, filepath.Join(serveDir, token)); err != nil {
+		os.RemoveAll(serveDir)
+		return "", "", fmt.Errorf("failed to link repository into git server directory: %w", err)
+	}
+	return serveDir, serveDir, nil
 }
 
 // fileReader is a function type for reading file contents
@@ -128,33 +236,65 @@ func pollForPidFile(pidFilePath string, timeout time.Duration, readFile fileRead
 	return 0, fmt.Errorf("timeout waiting for PID file")
 }
 
+// serverCheckTimeout is how long CheckServer waits for the git server to answer
+const serverCheckTimeout = 10 * time.Second
+
+// CheckServer checks that the git server on port on this host, listening on
+// bindAddr, serves the repository under token, by listing its branches the
+// way the container will
+func CheckServer(bindAddr string, port int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverCheckTimeout)
+	defer cancel()
+	url := ServerURL(checkHost(bindAddr), port, token)
+	output, err := exec.CommandContext(ctx, "git", "ls-remote", "--heads", url).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("git server on port %d did not answer within %s", port, serverCheckTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("git server on port %d is not serving the repository: %s", port, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// checkHost returns the address to reach a git server listening on bindAddr
+// at from this host
+func checkHost(bindAddr string) string {
+	if ip := net.ParseIP(bindAddr); bindAddr == "" || (ip != nil && ip.IsUnspecified()) {
+		return "127.0.0.1"
+	}
+	return bindAddr
+}
+
 // StopServer stops a running git server process
 func StopServer(serverCmd *ServerCmd) error {
 	if serverCmd == nil {
 		return nil
 	}
+	serverCmd.mu.Lock()
+	defer serverCmd.mu.Unlock()
+	serverCmd.stopping = true
 
-	// Kill the actual daemon process (not the wrapper process)
-	// Git daemon forks itself, so we need to kill the child process
-	if serverCmd.ActualPid > 0 {
-		process, err := os.FindProcess(serverCmd.ActualPid)
-		if err != nil {
-			return fmt.Errorf("failed to find process %d: %w", serverCmd.ActualPid, err)
-		}
-
-		if err := process.Kill(); err != nil {
-			// Process might have already exited
-			if !strings.Contains(err.Error(), "process already finished") &&
-				!strings.Contains(err.Error(), "no such process") {
-				return fmt.Errorf("failed to kill git server (PID %d): %w", serverCmd.ActualPid, err)
-			}
+	// Kill git, the daemon and the processes serving connections together
+	if serverCmd.Cmd != nil && serverCmd.Process != nil {
+		if err := killProcessGroup(serverCmd.Process.Pid); err != nil {
+			return fmt.Errorf("failed to kill git server (PID %d): %w", serverCmd.Process.Pid, err)
 		}
 	}
 
-	// Also wait on the wrapper process to prevent zombies
-	if serverCmd.Cmd != nil {
+	// Also wait on the git process to prevent zombies, or for the supervisor
+	// to, without holding the lock it needs to finish
+	if supervised := serverCmd.supervised; supervised != nil {
+		serverCmd.mu.Unlock()
+		<-supervised
+		serverCmd.mu.Lock()
+	} else if serverCmd.Cmd != nil {
 		_ = serverCmd.Wait()
 	}
 
+	if serverCmd.serveDir != "" {
+		os.RemoveAll(serverCmd.serveDir)
+		serverCmd.serveDir = ""
+	}
+
 	return nil
 }
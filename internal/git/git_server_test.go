@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -33,7 +35,7 @@ func TestStartServer(t *testing.T) {
 	testutil.InitTestRepo(t, tmpDir)
 
 	t.Run("starts server successfully", func(t *testing.T) {
-		serverCmd, port, err := StartServer(tmpDir)
+		serverCmd, port, err := StartServer(tmpDir, "127.0.0.1", "", "giverny/test-task")
 		if err != nil {
 			t.Fatalf("failed to start server: %v", err)
 		}
@@ -48,28 +50,24 @@ func TestStartServer(t *testing.T) {
 			t.Errorf("port %d is outside valid range %d-%d", port, minPort, maxPort)
 		}
 
-		// Verify actual process is running
-		if serverCmd.ActualPid <= 0 {
-			t.Error("server actual PID is invalid")
-		}
-
 		// Give it a moment to ensure it stays running
 		time.Sleep(200 * time.Millisecond)
 
-		// Check if process is still alive using ps command
-		cmd := exec.Command("ps", "-p", fmt.Sprintf("%d", serverCmd.ActualPid))
-		if err := cmd.Run(); err != nil {
-			t.Errorf("server process is not running (pid %d)", serverCmd.ActualPid)
+		// Check that git and the daemon are running in a process group of
+		// their own
+		pgid := serverCmd.Process.Pid
+		if members := processGroupMembers(t, pgid); members < 2 {
+			t.Errorf("expected git and the daemon in process group %d, found %d processes", pgid, members)
 		}
 	})
 
 	t.Run("stops server successfully", func(t *testing.T) {
-		serverCmd, _, err := StartServer(tmpDir)
+		serverCmd, _, err := StartServer(tmpDir, "127.0.0.1", "", "giverny/test-task")
 		if err != nil {
 			t.Fatalf("failed to start server: %v", err)
 		}
 
-		actualPid := serverCmd.ActualPid
+		pgid := serverCmd.Process.Pid
 		err = StopServer(serverCmd)
 		if err != nil {
 			t.Errorf("failed to stop server: %v", err)
@@ -78,10 +76,9 @@ func TestStartServer(t *testing.T) {
 		// Give it a moment to shut down
 		time.Sleep(100 * time.Millisecond)
 
-		// Verify process is stopped using ps command
-		cmd := exec.Command("ps", "-p", fmt.Sprintf("%d", actualPid))
-		if err := cmd.Run(); err == nil {
-			t.Error("server process is still running after stop")
+		// Verify no process of the group is left
+		if members := processGroupMembers(t, pgid); members != 0 {
+			t.Errorf("%d server processes are still running after stop", members)
 		}
 	})
 
@@ -93,6 +90,163 @@ func TestStartServer(t *testing.T) {
 	})
 }
 
+// processGroupMembers counts the running processes in the process group
+// pgid using ps command. Zombies left for init to reap don't count.
+func processGroupMembers(t *testing.T, pgid int) int {
+	t.Helper()
+	output, err := exec.Command("ps", "-A", "-o", "pgid=,stat=").Output()
+	if err != nil {
+		t.Fatalf("failed to list processes: %v", err)
+	}
+	members := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == fmt.Sprint(pgid) && !strings.HasPrefix(fields[1], "Z") {
+			members++
+		}
+	}
+	return members
+}
+
+func TestCheckServer(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-git-server-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	serverCmd, port, err := StartServer(tmpDir, "127.0.0.1", "", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if err := CheckServer("127.0.0.1", port, ""); err != nil {
+		StopServer(serverCmd)
+		t.Fatalf("CheckServer failed on a running server: %v", err)
+	}
+
+	if err := StopServer(serverCmd); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if err := CheckServer("127.0.0.1", port, ""); err == nil {
+		t.Error("expected CheckServer to fail once the server is stopped")
+	}
+}
+
+func TestServeUnderToken(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-git-server-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	token, err := NewServerToken()
+	if err != nil {
+		t.Fatalf("NewServerToken failed: %v", err)
+	}
+	serverCmd, port, err := StartServer(tmpDir, "127.0.0.1", token, "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	serveDir := serverCmd.serveDir
+	defer StopServer(serverCmd)
+
+	if err := CheckServer("127.0.0.1", port, token); err != nil {
+		t.Errorf("CheckServer failed under the token: %v", err)
+	}
+	for _, guess := range []string{"", "0123abcd", token + "/.."} {
+		if err := CheckServer("127.0.0.1", port, guess); err == nil {
+			t.Errorf("expected the repository not to be served under %q", guess)
+		}
+	}
+
+	if err := CloneRepoFromURL(ServerURL("127.0.0.1", port, token), t.TempDir(), CloneOptions{}, false); err != nil {
+		t.Errorf("CloneRepoFromURL failed with the token: %v", err)
+	}
+
+	if err := StopServer(serverCmd); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if _, err := os.Stat(serveDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed once the server stopped", serveDir)
+	}
+
+	if _, _, err := StartServer(tmpDir, "127.0.0.1", "../etc", "giverny/test-task"); err == nil {
+		t.Error("expected an error for a token that is a path")
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-git-server-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	serverCmd, port, err := StartServer(tmpDir, "127.0.0.1", "0123abcd", "giverny/test-task")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	var mu sync.Mutex
+	var logged []string
+	Supervise(serverCmd, tmpDir, port, func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged = append(logged, fmt.Sprintf(format, args...))
+	})
+
+	// Kill git, but not the daemon, as a crash would: the daemon still
+	// holding the port must not stop the server being restarted
+	serverCmd.mu.Lock()
+	firstPid, firstServeDir := serverCmd.Process.Pid, serverCmd.serveDir
+	if process, err := os.FindProcess(firstPid); err == nil {
+		process.Kill()
+	}
+	serverCmd.mu.Unlock()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		restarted := len(logged) == 2
+		mu.Unlock()
+		if restarted {
+			break
+		}
+		if time.Now().After(deadline) {
+			StopServer(serverCmd)
+			t.Fatalf("git server was not restarted, logged %v", logged)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := CheckServer("127.0.0.1", port, "0123abcd"); err != nil {
+		t.Errorf("restarted git server is not serving: %v", err)
+	}
+	serverCmd.mu.Lock()
+	if serverCmd.Process.Pid == firstPid {
+		t.Error("expected the restarted server to have a new PID")
+	}
+	serverCmd.mu.Unlock()
+	if _, err := os.Stat(firstServeDir); !os.IsNotExist(err) {
+		t.Errorf("expected the first server's directory %s to be removed", firstServeDir)
+	}
+
+	// Stopping the server is not mistaken for a crash
+	if err := StopServer(serverCmd); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if err := CheckServer("127.0.0.1", port, "0123abcd"); err == nil {
+		t.Error("expected the git server to stay stopped")
+	}
+	if len(logged) != 2 || !strings.Contains(logged[1], fmt.Sprintf("restarted on port %d", port)) {
+		t.Errorf("unexpected log %v", logged)
+	}
+}
+
 func TestRandomPort(t *testing.T) {
 	// Test that randomPort generates valid ports
 	for i := 0; i < 100; i++ {
@@ -264,3 +418,21 @@ func TestPollForPidFile(t *testing.T) {
 		}
 	})
 }
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		bindAddr string
+		expected string
+	}{
+		{"", "127.0.0.1"},
+		{"0.0.0.0", "127.0.0.1"},
+		{"::", "127.0.0.1"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"192.168.1.5", "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		if got := checkHost(tt.bindAddr); got != tt.expected {
+			t.Errorf("checkHost(%q) = %q, want %q", tt.bindAddr, got, tt.expected)
+		}
+	}
+}
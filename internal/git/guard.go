@@ -0,0 +1,155 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// ProtectedBranchKey is the git config key holding patterns of branches that
+// tasks must not be started from, such as 'release/*'. It can be given more
+// than once.
+const ProtectedBranchKey = "giverny.protectedBranch"
+
+// inProgressFiles are the files in the git directory that show an operation
+// has been started but not finished, checked in order
+var inProgressFiles = []struct {
+	path      string
+	operation string
+}{
+	{"rebase-merge", "rebase"},
+	{"rebase-apply", "rebase"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+	{"BISECT_LOG", "bisect"},
+}
+
+// CurrentBranch returns the name of the branch checked out, or an empty
+// string if HEAD is detached.
+func CurrentBranch() (string, error) {
+	return CurrentBranchInDir(".")
+}
+
+// CurrentBranchInDir is CurrentBranch for the repository at dir.
+func CurrentBranchInDir(dir string) (string, error) {
+	output, err := exec.Command("git", "-C", dir, "symbolic-ref", "--quiet", "--short", "HEAD").Output()
+	if err != nil {
+		// Exit status 1 means HEAD is detached
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to find the current branch: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ProtectedBranches returns the patterns set with ProtectedBranchKey.
+func ProtectedBranches() ([]string, error) {
+	return ProtectedBranchesInDir(".")
+}
+
+// ProtectedBranchesInDir is ProtectedBranches for the repository at dir.
+func ProtectedBranchesInDir(dir string) ([]string, error) {
+	output, err := exec.Command("git", "-C", dir, "config", "--get-all", ProtectedBranchKey).Output()
+	if err != nil {
+		// Exit status 1 means the key isn't set
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", ProtectedBranchKey, err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// MatchProtectedBranch returns the first of patterns that branch matches, or
+// an empty string if it matches none. Patterns are shell globs in which '*'
+// doesn't match '/', so 'release/*' covers release/1.0 but not release/1/x.
+func MatchProtectedBranch(branch string, patterns []string) string {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// InProgressOperation returns the operation, such as "rebase" or "merge",
+// that has been started in the repository and not yet finished or aborted,
+// or an empty string if there is none.
+func InProgressOperation() (string, error) {
+	return InProgressOperationInDir(".")
+}
+
+// InProgressOperationInDir is InProgressOperation for the repository at dir.
+func InProgressOperationInDir(dir string) (string, error) {
+	// The files are in the worktree's own git directory, not the common one
+	gitDir, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--git-dir")
+	if err != nil {
+		return "", fmt.Errorf("failed to find git directory: %w", err)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	for _, file := range inProgressFiles {
+		if _, err := os.Stat(filepath.Join(gitDir, file.path)); err == nil {
+			return file.operation, nil
+		}
+	}
+	return "", nil
+}
+
+// BranchProblemsInDir returns what is wrong, if anything, with pushing branchName
+// from the workspace at dir after the agent has worked in it: HEAD is not on
+// the branch, an operation such as a rebase is unfinished, or the branch no
+// longer contains startCommit, where work on it started, because its history
+// was rewritten. The branch's START label having moved is a problem too.
+func BranchProblemsInDir(dir, branchName, startCommit string) ([]string, error) {
+	var problems []string
+	current, err := CurrentBranchInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	switch current {
+	case branchName:
+	case "":
+		problems = append(problems, fmt.Sprintf("HEAD is detached at %s instead of on %s", GetShortHashInDir(dir, "HEAD"), branchName))
+	default:
+		problems = append(problems, fmt.Sprintf("%s is checked out instead of %s", current, branchName))
+	}
+
+	operation, err := InProgressOperationInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if operation != "" {
+		problems = append(problems, fmt.Sprintf("a %s is in progress", operation))
+	}
+
+	exists, err := BranchExistsInDir(dir, branchName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return append(problems, fmt.Sprintf("%s was deleted", branchName)), nil
+	}
+	if startCommit == "" {
+		return problems, nil
+	}
+	err = exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", startCommit, "refs/heads/"+branchName).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		problems = append(problems, fmt.Sprintf("%s no longer contains %s, where work on it started: its history was rewritten", branchName, GetShortHashInDir(dir, startCommit)))
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check the history of %s: %w", branchName, err)
+	}
+	label, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+StartLabel(branchName))
+	if err == nil && strings.TrimSpace(label) != startCommit {
+		problems = append(problems, fmt.Sprintf("%s was moved from %s", StartLabel(branchName), GetShortHashInDir(dir, startCommit)))
+	}
+	return problems, nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux && !darwin
+
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// newProcessGroup does nothing where there are no process groups
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the process pid, as the nearest thing to its group
+func killProcessGroup(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// isProcessGroupLeader reports false where there are no process groups
+func isProcessGroupLeader(pid int) bool {
+	return false
+}
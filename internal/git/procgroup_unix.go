@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package git
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup makes cmd start in a process group of its own, led by the
+// process it starts
+func newProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills every process in the process group led by pid. A
+// group that has already gone is not an error.
+func killProcessGroup(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// isProcessGroupLeader reports whether pid leads its process group, as the
+// git process of a git server does
+func isProcessGroupLeader(pid int) bool {
+	pgid, err := syscall.Getpgid(pid)
+	return err == nil && pgid == pid
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressPattern matches a progress line of git clone --progress, e.g.
+// "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s"
+var progressPattern = regexp.MustCompile(`^(?:remote: )?([A-Z][a-z]+ (?:objects|deltas)):\s+(\d+)% \((\d+)/(\d+)\)(?:, (.*?))??(, done\.)?\s*$`)
+
+// progressBarWidth is the number of characters in the bar
+const progressBarWidth = 30
+
+// progressRedraw is how often the bar is redrawn at most
+const progressRedraw = 100 * time.Millisecond
+
+// cloneProgress is written git's --progress output. It keeps all of it for
+// error messages, and shows the progress on out: as a bar redrawn in place if
+// out is a terminal, so that a long clone doesn't look hung, or else as a
+// line for each finished phase.
+type cloneProgress struct {
+	out      io.Writer
+	terminal bool
+	output   bytes.Buffer
+	line     []byte
+	drawn    time.Time
+	drawing  bool
+}
+
+// newCloneProgress returns a cloneProgress showing progress on out
+func newCloneProgress(out io.Writer) *cloneProgress {
+	terminal := false
+	if f, ok := out.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			terminal = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return &cloneProgress{out: out, terminal: terminal}
+}
+
+// Write takes git's output, in which progress lines end in carriage returns
+// while they are being updated
+func (p *cloneProgress) Write(data []byte) (int, error) {
+	p.output.Write(data)
+	for _, b := range data {
+		if b == '\r' || b == '\n' {
+			p.show(string(p.line))
+			p.line = p.line[:0]
+			continue
+		}
+		p.line = append(p.line, b)
+	}
+	return len(data), nil
+}
+
+// show shows the progress in line, if it is a progress line
+func (p *cloneProgress) show(line string) {
+	match := progressPattern.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	phase, detail, done := match[1], match[5], match[6] != ""
+	percent, _ := strconv.Atoi(match[2])
+	total := match[4]
+
+	if !p.terminal {
+		if done {
+			summary := fmt.Sprintf("%s: %s", phase, total)
+			if detail != "" {
+				summary += ", " + detail
+			}
+			fmt.Fprintln(p.out, summary)
+		}
+		return
+	}
+
+	if !done && time.Since(p.drawn) < progressRedraw {
+		return
+	}
+	p.drawn = time.Now()
+	filled := percent * progressBarWidth / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	status := fmt.Sprintf("%3d%% (%s/%s)", percent, match[3], total)
+	if detail != "" {
+		status += " " + detail
+	}
+	// Clear the rest of the line, which may have been longer
+	fmt.Fprintf(p.out, "\r%-18s [%s] %s\033[K", phase, bar, status)
+	p.drawing = true
+	if done {
+		fmt.Fprint(p.out, "\n")
+		p.drawing = false
+	}
+}
+
+// finish ends a bar that git stopped updating before it was done, as when the
+// clone fails
+func (p *cloneProgress) finish() {
+	if p.drawing {
+		fmt.Fprint(p.out, "\n")
+		p.drawing = false
+	}
+}
+
+// String returns all of git's output
+func (p *cloneProgress) String() string {
+	return p.output.String()
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// cloneOutput is what git clone --progress writes, shortened
+const cloneOutput = "Cloning into '/git'...\n" +
+	"remote: Enumerating objects: 1231, done.        \n" +
+	"remote: Counting objects:   0% (1/1231)        \rremote: Counting objects: 100% (1231/1231), done.        \n" +
+	"remote: Compressing objects:  50% (300/600)        \rremote: Compressing objects: 100% (600/600), done.        \n" +
+	"Receiving objects:  45% (554/1231), 1.20 MiB | 2.00 MiB/s\rReceiving objects: 100% (1231/1231), 2.50 MiB | 2.10 MiB/s, done.\n" +
+	"Resolving deltas: 100% (400/400), done.\n"
+
+func TestCloneProgress(t *testing.T) {
+	var out bytes.Buffer
+	progress := newCloneProgress(&out)
+	// Split the writes mid-line, as a pipe might
+	for _, chunk := range []string{cloneOutput[:100], cloneOutput[100:]} {
+		if _, err := progress.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	progress.finish()
+
+	if progress.String() != cloneOutput {
+		t.Errorf("expected all of git's output to be kept, got %q", progress.String())
+	}
+	expected := "Counting objects: 1231\n" +
+		"Compressing objects: 600\n" +
+		"Receiving objects: 1231, 2.50 MiB | 2.10 MiB/s\n" +
+		"Resolving deltas: 400\n"
+	if out.String() != expected {
+		t.Errorf("expected a line per phase when not on a terminal, got %q", out.String())
+	}
+}
+
+func TestCloneProgress_Terminal(t *testing.T) {
+	var out bytes.Buffer
+	progress := newCloneProgress(&out)
+	progress.terminal = true
+	progress.Write([]byte("Receiving objects:  50% (500/1000), 1.00 MiB | 1.00 MiB/s\r"))
+
+	want := "\rReceiving objects  [" + strings.Repeat("#", 15) + strings.Repeat("-", 15) + "]  50% (500/1000) 1.00 MiB | 1.00 MiB/s\033[K"
+	if out.String() != want {
+		t.Errorf("expected a bar, got %q", out.String())
+	}
+
+	// Redrawn no more often than progressRedraw, except when done
+	progress.Write([]byte("Receiving objects:  60% (600/1000), 1.20 MiB | 1.00 MiB/s\r"))
+	if out.String() != want {
+		t.Errorf("expected the bar not to be redrawn straight away, got %q", out.String())
+	}
+
+	// A clone that fails part way leaves the bar on its own line
+	progress.finish()
+	if !strings.HasSuffix(out.String(), "\033[K\n") {
+		t.Errorf("expected finish to end the line, got %q", out.String())
+	}
+
+	out.Reset()
+	progress.Write([]byte("Receiving objects: 100% (1000/1000), 2.00 MiB | 1.00 MiB/s, done.\n"))
+	if !strings.Contains(out.String(), "["+strings.Repeat("#", 30)+"] 100%") || !strings.HasSuffix(out.String(), "\n") {
+		t.Errorf("expected a full bar ending the line, got %q", out.String())
+	}
+}
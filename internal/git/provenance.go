@@ -0,0 +1,57 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Trailer is a "Key: Value" line added to the end of a commit message.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// InstallProvenanceHook installs a prepare-commit-msg hook in the clone in /git
+// (and so in the /app worktree) that adds the given trailers to every commit
+// made in the container.
+func InstallProvenanceHook(trailers []Trailer) error {
+	return InstallProvenanceHookInDir(DefaultCloneDir, trailers)
+}
+
+// InstallProvenanceHookInDir installs the provenance hook in the repository
+// cloned into gitDir. Any existing prepare-commit-msg hook is replaced.
+func InstallProvenanceHookInDir(gitDir string, trailers []Trailer) error {
+	hooksDir := filepath.Join(gitDir, ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %w", err)
+	}
+
+	hookPath := filepath.Join(hooksDir, "prepare-commit-msg")
+	if err := os.WriteFile(hookPath, []byte(provenanceHookScript(trailers)), 0755); err != nil {
+		return fmt.Errorf("failed to install provenance hook: %w", err)
+	}
+	return nil
+}
+
+// provenanceHookScript returns the prepare-commit-msg hook that stamps the
+// commit message with trailers. Existing trailers with the same key are
+// replaced, so amending a commit does not duplicate them.
+func provenanceHookScript(trailers []Trailer) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Installed by giverny to record task provenance\n")
+	b.WriteString("git interpret-trailers --in-place --if-exists replace")
+	for _, t := range trailers {
+		b.WriteString(" --trailer ")
+		b.WriteString(shellQuote(t.Key + ": " + t.Value))
+	}
+	b.WriteString(" \"$1\"\n")
+	return b.String()
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
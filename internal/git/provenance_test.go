@@ -0,0 +1,54 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestInstallProvenanceHook(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	trailers := []Trailer{
+		{Key: "Giverny-Task", Value: "giv-123"},
+		{Key: "Giverny-Agent", Value: "claude-code (it's quoted)"},
+	}
+	if err := InstallProvenanceHookInDir(tmpDir, trailers); err != nil {
+		t.Fatalf("InstallProvenanceHookInDir failed: %v", err)
+	}
+
+	// Commit, then amend, and check the trailers appear exactly once
+	cmd := exec.Command("sh", "-c", "echo 'change' > change.txt && git add change.txt && git commit -m 'Agent commit' && git commit --amend --no-edit")
+	cmd.Dir = tmpDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit: %v\n%s", err, output)
+	}
+
+	logCmd := exec.Command("git", "log", "-1", "--format=%B")
+	logCmd.Dir = tmpDir
+	output, err := logCmd.Output()
+	if err != nil {
+		t.Fatalf("failed to read commit message: %v", err)
+	}
+	message := string(output)
+
+	for _, want := range []string{"Giverny-Task: giv-123", "Giverny-Agent: claude-code (it's quoted)"} {
+		if count := strings.Count(message, want); count != 1 {
+			t.Errorf("expected trailer %q once in commit message, found %d times:\n%s", want, count, message)
+		}
+	}
+	if !strings.HasPrefix(message, "Agent commit\n") {
+		t.Errorf("expected original subject to be kept, got:\n%s", message)
+	}
+}
@@ -0,0 +1,60 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UpdateHook returns the update hook the git server of the task on
+// branchName runs for each ref a push from the task's container changes, so
+// that the agent can't rewrite the host's refs whatever it pushes. The agent
+// can read the server's URL, so the hook only lets it push its own task's
+// refs: refs can't be deleted, and only the task branch, its WIPRef and
+// CheckpointsRef, its SummaryNotesRef, tags and other notes can be pushed.
+// Other tasks' branches and refs, and the task's START label, are refused.
+// The task branch and its refs can be forced, as innie does after the user
+// chooses to push over commits made on the host and for each WIP save, but
+// tags can only be created and notes only fast-forwarded.
+func UpdateHook(branchName string) string {
+	return fmt.Sprintf(updateHookTemplate, shellQuote(branchName))
+}
+
+// updateHookTemplate is UpdateHook with the task branch, quoted, left to be
+// filled in
+const updateHookTemplate = `#!/bin/sh
+ref=$1 old=$2 new=$3
+branch=%s
+refuse() {
+	echo "giverny: refusing to $1 $ref" >&2
+	exit 1
+}
+case $new in *[!0]*) ;; *) refuse delete ;; esac
+case $ref in
+"refs/heads/$branch" | "refs/$branch/wip" | "refs/$branch/checkpoints") ;;
+"refs/notes/$branch")
+	case $old in *[!0]*) git merge-base --is-ancestor "$old" "$new" || refuse "rewrite the notes in" ;; esac ;;
+refs/heads/giverny/* | refs/giverny/* | refs/notes/giverny/*) refuse "push to another task's refs or the task's START label," ;;
+refs/tags/*)
+	case $old in *[!0]*) refuse "move the tag" ;; esac ;;
+refs/notes/*)
+	case $old in *[!0]*) git merge-base --is-ancestor "$old" "$new" || refuse "rewrite the notes in" ;; esac ;;
+*) refuse "push anything but the task's branch, tags and notes to" ;;
+esac
+`
+
+// hooksDirName is the directory the git server's hooks are written to, in the
+// directory it serves from
+const hooksDirName = ".hooks"
+
+// writeHooks writes the hooks of the git server of the task on branchName to
+// the directory dir
+func writeHooks(dir, branchName string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create git server hooks directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "update"), []byte(UpdateHook(branchName)), 0755); err != nil {
+		return fmt.Errorf("failed to write git server update hook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestUpdateHook(t *testing.T) {
+	repoPath := t.TempDir()
+	testutil.InitTestRepo(t, repoPath)
+	for _, args := range [][]string{{"tag", "v1"}, {"branch", "other"}} {
+		if output, err := exec.Command("git", append([]string{"-C", repoPath}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+
+	serverCmd, port, err := StartServer(repoPath, "127.0.0.1", "0123abcd", "giverny/task")
+	if err != nil {
+		t.Fatalf("failed to start git server: %v", err)
+	}
+	defer StopServer(serverCmd)
+	url := ServerURL("127.0.0.1", port, "0123abcd")
+
+	clone := t.TempDir()
+	git := func(args ...string) error {
+		output, err := exec.Command("git", append([]string{"-C", clone}, args...)...).CombinedOutput()
+		if err != nil && !strings.Contains(string(output), "giverny: refusing") && !strings.Contains(string(output), "deletion prohibited") {
+			t.Fatalf("git %v failed other than by the hook: %v\n%s", args, err, output)
+		}
+		return err
+	}
+	if output, err := exec.Command("git", "clone", "--quiet", url, clone).CombinedOutput(); err != nil {
+		t.Fatalf("git clone failed: %v\n%s", err, output)
+	}
+	git("-c", "user.email=test@example.com", "-c", "user.name=Test", "commit", "--allow-empty", "--quiet", "-m", "work")
+
+	tests := []struct {
+		name    string
+		args    []string
+		refused bool
+	}{
+		{"task branch", []string{"push", url, "HEAD:refs/heads/giverny/task"}, false},
+		{"forced task branch", []string{"push", "--force", url, "HEAD~1:refs/heads/giverny/task"}, false},
+		{"WIP ref", []string{"push", url, "+HEAD:refs/giverny/task/wip"}, false},
+		{"checkpoints ref", []string{"push", url, "+HEAD:refs/giverny/task/checkpoints"}, false},
+		{"new tag", []string{"push", url, "HEAD:refs/tags/v2"}, false},
+		{"notes", []string{"push", url, "HEAD:refs/notes/giverny/task"}, false},
+		{"other notes", []string{"push", url, "HEAD:refs/notes/commits"}, false},
+		{"other branch", []string{"push", url, "HEAD:refs/heads/other"}, true},
+		{"other task's branch", []string{"push", url, "HEAD:refs/heads/giverny/other-task"}, true},
+		{"START label", []string{"push", "--force", url, "HEAD:refs/heads/giverny/task-START"}, true},
+		{"other task's WIP ref", []string{"push", url, "+HEAD:refs/giverny/other-task/wip"}, true},
+		{"other task's notes", []string{"push", url, "HEAD:refs/notes/giverny/other-task"}, true},
+		{"moved tag", []string{"push", "--force", url, "HEAD:refs/tags/v1"}, true},
+		{"rewritten notes", []string{"push", "--force", url, "HEAD~1:refs/notes/giverny/task"}, true},
+		{"deleted task branch", []string{"push", url, ":refs/heads/giverny/task"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := git(tt.args...); (err != nil) != tt.refused {
+				t.Errorf("git %v: refused = %v, want %v", tt.args, err != nil, tt.refused)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"giverny/internal/cmdutil"
+)
+
+// Repository is a git repository on disk. Its methods run git against the
+// repository's path rather than the process's working directory.
+//
+// The zero Repository refers to the repository containing the current working
+// directory, which is what the package-level functions use.
+type Repository struct {
+	path string
+}
+
+// Open returns the repository containing path. path may be anywhere inside
+// the working tree; the returned repository is rooted at its top level.
+func Open(path string) (*Repository, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	root, err := cmdutil.RunCommandWithOutput("git", "-C", absPath, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not inside a git repository", absPath)
+	}
+	return &Repository{path: root}, nil
+}
+
+// Path returns the top level of the repository's working tree, or "" for the
+// zero Repository.
+func (r *Repository) Path() string {
+	return r.path
+}
+
+// GitDir returns the absolute path of the repository's git directory, which
+// all its worktrees share.
+func (r *Repository) GitDir() (string, error) {
+	gitDir, err := cmdutil.RunCommandWithOutput("git", "-C", r.dir(), "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", fmt.Errorf("failed to find git directory: %w", err)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(r.dir(), gitDir)
+	}
+	return filepath.Abs(gitDir)
+}
+
+// dir returns the directory to run git in
+func (r *Repository) dir() string {
+	if r.path == "" {
+		return "."
+	}
+	return r.path
+}
+
+// CreateBranch creates branchName at startPoint (or HEAD if empty) without
+// checking it out. See CreateBranch.
+func (r *Repository) CreateBranch(branchName, startPoint string) error {
+	return CreateBranchInDir(r.dir(), branchName, startPoint)
+}
+
+// BranchExists checks if a branch exists.
+func (r *Repository) BranchExists(branchName string) (bool, error) {
+	return BranchExistsInDir(r.dir(), branchName)
+}
+
+// DefaultBranch returns the repository's default branch. See DefaultBranch.
+func (r *Repository) DefaultBranch() string {
+	return DefaultBranchInDir(r.dir())
+}
+
+// CommitRange returns the first and last commits of the task on branchName.
+// See GetBranchCommitRange.
+func (r *Repository) CommitRange(branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
+	return GetBranchCommitRangeInDir(r.dir(), branchName, baseBranch)
+}
+
+// CommitHash resolves ref to a full commit hash.
+func (r *Repository) CommitHash(ref string) (string, error) {
+	return GetCommitHashInDir(r.dir(), ref)
+}
+
+// ShortHash converts a full commit hash to its short form, or returns it
+// unchanged if that fails.
+func (r *Repository) ShortHash(fullHash string) string {
+	return GetShortHashInDir(r.dir(), fullHash)
+}
+
+// Squash collapses the commits from firstCommit to the head of branchName
+// into one. See SquashBranch.
+func (r *Repository) Squash(branchName, firstCommit, message string) (string, error) {
+	return SquashBranchInDir(r.dir(), branchName, firstCommit, message)
+}
+
+// FastForward moves targetBranch forward to branchName. See
+// FastForwardBranch.
+func (r *Repository) FastForward(targetBranch, branchName string) error {
+	return FastForwardBranchInDir(r.dir(), targetBranch, branchName)
+}
+
+// CurrentBranch returns the branch checked out, or "" if HEAD is detached.
+func (r *Repository) CurrentBranch() (string, error) {
+	return CurrentBranchInDir(r.dir())
+}
+
+// ProtectedBranches returns the patterns of branches tasks must not be
+// started from. See ProtectedBranchKey.
+func (r *Repository) ProtectedBranches() ([]string, error) {
+	return ProtectedBranchesInDir(r.dir())
+}
+
+// InProgressOperation returns the unfinished operation, such as "rebase", in
+// the repository, if any.
+func (r *Repository) InProgressOperation() (string, error) {
+	return InProgressOperationInDir(r.dir())
+}
+
+// RemoteExists reports whether the repository has a remote named remote.
+func (r *Repository) RemoteExists(remote string) (bool, error) {
+	return RemoteExistsInDir(r.dir(), remote)
+}
+
+// PushToRemote pushes branchName to remote with --set-upstream. See
+// PushBranchToRemote.
+func (r *Repository) PushToRemote(remote, branchName string) error {
+	return PushBranchToRemoteInDir(r.dir(), remote, branchName)
+}
+
+// DeleteBranch deletes branchName and its START label. See DeleteBranch.
+func (r *Repository) DeleteBranch(branchName string) error {
+	return DeleteBranchInDir(r.dir(), branchName)
+}
+
+// MergeBase returns the best common ancestor of two refs.
+func (r *Repository) MergeBase(ref1, ref2 string) (string, error) {
+	return MergeBaseInDir(r.dir(), ref1, ref2)
+}
+
+// CommitSubjects returns "SHORT-HASH SUBJECT" for each commit in
+// fromRef..toRef, oldest first.
+func (r *Repository) CommitSubjects(fromRef, toRef string) ([]string, error) {
+	return CommitSubjectsInDir(r.dir(), fromRef, toRef)
+}
+
+// Dirty reports whether the working tree has uncommitted changes.
+func (r *Repository) Dirty() (bool, error) {
+	return IsWorkspaceDirtyInDir(r.dir())
+}
+
+// Measure works out how big the repository is. See MeasureRepo.
+func (r *Repository) Measure() (RepoSize, error) {
+	return MeasureRepoInDir(r.dir())
+}
+
+// Stash stashes the uncommitted changes, untracked files included. See Stash.
+func (r *Repository) Stash(message string) (string, error) {
+	return StashInDir(r.dir(), message)
+}
+
+// RestoreStash pops the stash commit made by Stash. See RestoreStash.
+func (r *Repository) RestoreStash(stash string) error {
+	return RestoreStashInDir(r.dir(), stash)
+}
+
+// HasStagedChanges reports whether the index differs from HEAD.
+func (r *Repository) HasStagedChanges() (bool, error) {
+	return HasStagedChangesInDir(r.dir())
+}
+
+// ChangedFiles returns the paths of the files that differ between fromRef and
+// HEAD.
+func (r *Repository) ChangedFiles(fromRef string) ([]string, error) {
+	return ChangedFilesInDir(r.dir(), fromRef)
+}
+
+// ChangedFilesBetween returns the paths of the files that differ between
+// fromRef and toRef.
+func (r *Repository) ChangedFilesBetween(fromRef, toRef string) ([]string, error) {
+	return ChangedFilesBetweenInDir(r.dir(), fromRef, toRef)
+}
+
+// DiffStats returns per-file change statistics between fromRef and toRef.
+func (r *Repository) DiffStats(fromRef, toRef string) ([]FileStat, error) {
+	return DiffStatsBetweenInDir(r.dir(), fromRef, toRef)
+}
+
+// IgnoredFiles returns the paths that .gitignore says should not be committed.
+func (r *Repository) IgnoredFiles(paths []string) ([]string, error) {
+	return IgnoredFilesInDir(r.dir(), paths)
+}
+
+// RunPreCommitHook runs the pre-commit hook on the changes between fromRef
+// and toRef. It returns the hook's output and whether it passed.
+func (r *Repository) RunPreCommitHook(fromRef, toRef string) (string, bool, error) {
+	return RunPreCommitHookInDir(r.dir(), fromRef, toRef)
+}
+
+// RunAtRef runs command in a temporary worktree of ref. See RunAtRef.
+func (r *Repository) RunAtRef(ref, command string) (string, error) {
+	return RunAtRefInDir(r.dir(), ref, command)
+}
+
+// VerifyBranch runs each command in a temporary worktree of ref, writing their
+// output to out.
+func (r *Repository) VerifyBranch(ref string, commands []string, out io.Writer) ([]VerifyResult, error) {
+	return VerifyBranchInDir(r.dir(), ref, commands, out)
+}
+
+// NoiseFiles lists the files modified between fromRef and toRef whose only
+// changes are to whitespace, line endings or the file mode.
+func (r *Repository) NoiseFiles(fromRef, toRef string) ([]string, error) {
+	return NoiseFilesBetweenInDir(r.dir(), fromRef, toRef)
+}
+
+// Reset discards all changes by hard-resetting to ref and removing untracked
+// files.
+func (r *Repository) Reset(ref string) error {
+	return ResetWorkspaceInDir(r.dir(), ref)
+}
+
+// AddSummaryNote attaches summary as a note on the tip of branchName.
+func (r *Repository) AddSummaryNote(branchName, summary string) error {
+	return AddSummaryNoteInDir(r.dir(), branchName, summary)
+}
+
+// SummaryNote returns the summary note on the tip of branchName, or "".
+func (r *Repository) SummaryNote(branchName string) string {
+	return ReadSummaryNoteInDir(r.dir(), branchName)
+}
+
+// Serve starts a git daemon serving the repository under token on a random
+// port, listening on bindAddr, for the task on branchName.
+func (r *Repository) Serve(bindAddr, token, branchName string) (*ServerCmd, int, error) {
+	return StartServer(r.dir(), bindAddr, token, branchName)
+}
+
+// ServeOnPort starts a git daemon serving the repository under token on port,
+// listening on bindAddr, for the task on branchName.
+func (r *Repository) ServeOnPort(bindAddr string, port int, token, branchName string) (*ServerCmd, error) {
+	return StartServerOnPort(r.dir(), bindAddr, port, token, branchName)
+}
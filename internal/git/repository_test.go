@@ -0,0 +1,101 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Resolve symlinks (e.g. /tmp on macOS) so paths compare equal
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+
+	t.Run("returns error outside a repository", func(t *testing.T) {
+		if _, err := Open(tmpDir); err == nil {
+			t.Error("expected error opening a directory that is not a repository")
+		}
+	})
+
+	testutil.InitTestRepo(t, tmpDir)
+	subDir := filepath.Join(tmpDir, "sub", "dir")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("failed to create subdirectory: %v", err)
+	}
+
+	t.Run("finds the top level from a subdirectory", func(t *testing.T) {
+		repo, err := Open(subDir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if repo.Path() != tmpDir {
+			t.Errorf("expected path %s, got %s", tmpDir, repo.Path())
+		}
+	})
+}
+
+func TestRepository(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	repo, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	branchName := "giverny/test-repo"
+	if err := repo.CreateBranch(branchName, ""); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	if exists, err := repo.BranchExists(branchName); err != nil || !exists {
+		t.Fatalf("expected %s to exist, got exists=%v err=%v", branchName, exists, err)
+	}
+	if got := repo.DefaultBranch(); got != "main" {
+		t.Errorf("expected default branch main, got %s", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	if dirty, err := repo.Dirty(); err != nil || !dirty {
+		t.Errorf("expected dirty workspace, got dirty=%v err=%v", dirty, err)
+	}
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q "+branchName+" && git commit -q -am 'Task commit' && git checkout -q main")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit: %v\n%s", err, output)
+	}
+	if dirty, err := repo.Dirty(); err != nil || dirty {
+		t.Errorf("expected clean workspace, got dirty=%v err=%v", dirty, err)
+	}
+
+	first, last, err := repo.CommitRange(branchName, "")
+	if err != nil {
+		t.Fatalf("CommitRange failed: %v", err)
+	}
+	if first == "" || first != last {
+		t.Errorf("expected a single task commit, got first=%s, last=%s", first, last)
+	}
+	if subjects, err := repo.CommitSubjects("main", branchName); err != nil || len(subjects) != 1 {
+		t.Errorf("expected one commit subject, got %v (err=%v)", subjects, err)
+	}
+}
@@ -0,0 +1,72 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Above these a repository is large enough that cloning it into the container
+// is noticeably slow
+const (
+	LargeRepoBytes int64 = 1 << 30
+	LargeRepoFiles       = 100000
+)
+
+// RepoSize is how big a repository is
+type RepoSize struct {
+	// Bytes is the size of its objects on disk, about what a full clone
+	// fetches
+	Bytes int64
+	// Files is the number of files checked out
+	Files int
+	// PartialBytes is about what a clone with --filter=blob:none fetches, or
+	// 0 if it wasn't worked out
+	PartialBytes int64
+}
+
+// Large reports whether the repository is over LargeRepoBytes or
+// LargeRepoFiles
+func (s RepoSize) Large() bool {
+	return s.Bytes > LargeRepoBytes || s.Files > LargeRepoFiles
+}
+
+// MeasureRepo works out the size of the repository. The size of a partial
+// clone takes a walk of the whole history, so it is only worked out for a
+// large repository.
+func MeasureRepo() (RepoSize, error) {
+	return MeasureRepoInDir(".")
+}
+
+// MeasureRepoInDir is MeasureRepo for the repository at dir.
+func MeasureRepoInDir(dir string) (RepoSize, error) {
+	var size RepoSize
+	output, err := exec.Command("git", "-C", dir, "count-objects", "-v").Output()
+	if err != nil {
+		return size, fmt.Errorf("failed to count objects: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(line, ": ")
+		if key == "size" || key == "size-pack" {
+			kib, _ := strconv.ParseInt(value, 10, 64)
+			size.Bytes += kib << 10
+		}
+	}
+
+	output, err = exec.Command("git", "-C", dir, "ls-files", "-z").Output()
+	if err != nil {
+		return size, fmt.Errorf("failed to list files: %w", err)
+	}
+	size.Files = bytes.Count(output, []byte{0})
+
+	if size.Large() {
+		// --disk-usage needs git 2.31, so this is left out with older ones
+		output, err := exec.Command("git", "-C", dir, "rev-list", "--all", "--objects", "--filter=blob:none", "--disk-usage").Output()
+		if err == nil {
+			size.PartialBytes, _ = strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,49 @@
+package git
+
+import (
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestMeasureRepo(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+	if err := testutil.Command(tmpDir, "sh", "-c", "echo 'one' > one.txt && echo 'two' > two.txt && git add . && git commit -q -m 'Add files'").Run(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	size, err := MeasureRepoInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("MeasureRepo failed: %v", err)
+	}
+	if size.Bytes <= 0 {
+		t.Errorf("expected the objects to take some space, got %d", size.Bytes)
+	}
+	files, _ := testutil.Command(tmpDir, "git", "ls-files").Output()
+	if want := strings.Count(string(files), "\n"); size.Files != want {
+		t.Errorf("expected %d files, got %d", want, size.Files)
+	}
+	if size.Large() || size.PartialBytes != 0 {
+		t.Errorf("expected a small repository, got %+v", size)
+	}
+}
+
+func TestRepoSizeLarge(t *testing.T) {
+	tests := []struct {
+		size     RepoSize
+		expected bool
+	}{
+		{RepoSize{Bytes: 10 << 20, Files: 100}, false},
+		{RepoSize{Bytes: 2 << 30, Files: 100}, true},
+		{RepoSize{Bytes: 10 << 20, Files: 200000}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.size.Large(); got != tt.expected {
+			t.Errorf("%+v.Large() = %v, want %v", tt.size, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// WIPRef returns the ref that uncommitted work on branchName is saved to.
+// It lives outside refs/heads because a branch cannot also be a directory
+// of refs, and so that it never shows up as a branch to merge.
+func WIPRef(branchName string) string {
+	return "refs/" + branchName + "/wip"
+}
+
+// SnapshotWorkspace commits everything in the workspace, including untracked
+// files that are not ignored, without touching HEAD, the index or any branch.
+// The commit's parent is HEAD. Returns an empty string if there is nothing
+// uncommitted to save.
+func SnapshotWorkspace(message string) (string, error) {
+	return SnapshotWorkspaceInDir(DefaultWorkspaceDir, message)
+}
+
+// SnapshotWorkspaceInDir is SnapshotWorkspace for the worktree in dir.
+func SnapshotWorkspaceInDir(dir, message string) (string, error) {
+	head, tree, err := workspaceTree(dir)
+	if err != nil {
+		return "", err
+	}
+
+	headTree, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", head+"^{tree}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD tree: %w", err)
+	}
+	if tree == headTree {
+		return "", nil
+	}
+
+	commit, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "commit-tree", tree, "-p", head, "-m", message)
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	return commit, nil
+}
+
+// CheckpointsRef returns the ref that periodic checkpoints of the workspace
+// on branchName are recorded on. See WIPRef for why it is not a branch.
+func CheckpointsRef(branchName string) string {
+	return "refs/" + branchName + "/checkpoints"
+}
+
+// CheckpointWorkspace records the state of the workspace, including
+// uncommitted and untracked files, as a new commit on ref, without touching
+// HEAD, the index or any branch. Each checkpoint's first parent is the
+// previous checkpoint and its second parent is HEAD at the time, so
+// `git log --first-parent ref` lists the checkpoints. Returns an empty string
+// if nothing has changed since the last checkpoint.
+func CheckpointWorkspace(ref, message string) (string, error) {
+	return CheckpointWorkspaceInDir(DefaultWorkspaceDir, ref, message)
+}
+
+// CheckpointWorkspaceInDir is CheckpointWorkspace for the worktree in dir.
+func CheckpointWorkspaceInDir(dir, ref, message string) (string, error) {
+	head, tree, err := workspaceTree(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// The first checkpoint is compared against HEAD, later ones against the previous checkpoint
+	args := []string{"-C", dir, "commit-tree", tree, "-m", message}
+	compareTo := head
+	previous, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref)
+	if err == nil && previous != "" {
+		args = append(args, "-p", previous)
+		compareTo = previous
+	}
+	args = append(args, "-p", head)
+
+	previousTree, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", compareTo+"^{tree}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s tree: %w", compareTo, err)
+	}
+	if tree == previousTree {
+		return "", nil
+	}
+
+	commit, err := cmdutil.RunCommandWithOutput("git", args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if err := cmdutil.RunCommand("git", "-C", dir, "update-ref", ref, commit); err != nil {
+		return "", fmt.Errorf("failed to update %s: %w", ref, err)
+	}
+	return commit, nil
+}
+
+// WorkspaceState returns HEAD and the tree of everything in the workspace,
+// including uncommitted and untracked files that are not ignored, without
+// touching HEAD, the index or any branch. Either changes if anything in the
+// workspace is committed or modified.
+func WorkspaceState() (head, tree string, err error) {
+	return WorkspaceStateInDir(DefaultWorkspaceDir)
+}
+
+// WorkspaceStateInDir is WorkspaceState for the worktree in dir.
+func WorkspaceStateInDir(dir string) (head, tree string, err error) {
+	return workspaceTree(dir)
+}
+
+// workspaceTree writes a tree of everything in the worktree in dir, including
+// untracked files that are not ignored, and returns it with the HEAD commit.
+// It stages into a scratch index so the agent's own staging is left alone.
+func workspaceTree(dir string) (head, tree string, err error) {
+	head, err = cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "giverny-snapshot-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(tmpDir, "index"))
+
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(output)))
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if _, err := git("read-tree", head); err != nil {
+		return "", "", fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	if _, err := git("add", "-A"); err != nil {
+		return "", "", fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	tree, err = git("write-tree")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	return head, tree, nil
+}
+
+// PushRef pushes commit to ref on the git server, replacing whatever the ref
+// pointed at before. It is only used for refs giverny owns, such as WIPRef
+// and CheckpointsRef.
+func PushRef(commit, ref string, gitServerPort int, debug bool) error {
+	return PushRefInDir(DefaultWorkspaceDir, commit, ref, gitServerPort, debug)
+}
+
+// PushRefInDir is PushRef for the worktree in dir. The push is quiet unless
+// debug is set, as it may run while the agent has the terminal.
+func PushRefInDir(dir, commit, ref string, gitServerPort int, debug bool) error {
+	gitServerURL := RepoURL(gitServerPort)
+	args := []string{"-C", dir, "push"}
+	if !debug {
+		args = append(args, "--quiet")
+	}
+	cmd := exec.Command("git", append(args, gitServerURL, "+"+commit+":"+ref)...)
+	output, err := cmd.CombinedOutput()
+	if debug {
+		os.Stderr.Write(output)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %s", ref, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestWIPRef(t *testing.T) {
+	t.Parallel()
+
+	if got := WIPRef("giverny/task-1-fix"); got != "refs/giverny/task-1-fix/wip" {
+		t.Errorf("WIPRef() = %q, want %q", got, "refs/giverny/task-1-fix/wip")
+	}
+}
+
+func TestSnapshotWorkspace(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	// Nothing to save in a clean workspace
+	commit, err := SnapshotWorkspaceInDir(tmpDir, "WIP")
+	if err != nil {
+		t.Fatalf("SnapshotWorkspace failed: %v", err)
+	}
+	if commit != "" {
+		t.Errorf("expected no snapshot of a clean workspace, got %s", commit)
+	}
+
+	// A staged change, an unstaged change and an untracked file
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "staged.txt"), []byte("staged"), 0644); err != nil {
+		t.Fatalf("failed to create staged file: %v", err)
+	}
+	if err := testutil.Command(tmpDir, "git", "add", "staged.txt").Run(); err != nil {
+		t.Fatalf("failed to stage file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "untracked.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("failed to create untracked file: %v", err)
+	}
+
+	head, err := GetCommitHashInDir(tmpDir, "HEAD")
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+
+	commit, err = SnapshotWorkspaceInDir(tmpDir, "WIP")
+	if err != nil {
+		t.Fatalf("SnapshotWorkspace failed: %v", err)
+	}
+	if commit == "" {
+		t.Fatal("expected a snapshot of a dirty workspace")
+	}
+
+	parent, err := GetCommitHashInDir(tmpDir, commit+"^")
+	if err != nil {
+		t.Fatalf("failed to get snapshot parent: %v", err)
+	}
+	if parent != head {
+		t.Errorf("expected snapshot parent %s, got %s", head, parent)
+	}
+
+	files, err := testutil.Command(tmpDir, "git", "diff", "--name-only", head, commit).Output()
+	if err != nil {
+		t.Fatalf("failed to diff snapshot: %v", err)
+	}
+	if got := strings.Fields(string(files)); strings.Join(got, " ") != "staged.txt test.txt untracked.txt" {
+		t.Errorf("expected snapshot to contain all three changes, got %v", got)
+	}
+
+	// HEAD and the index are untouched
+	if after, _ := GetCommitHashInDir(tmpDir, "HEAD"); after != head {
+		t.Errorf("expected HEAD to stay at %s, got %s", head, after)
+	}
+	staged, err := testutil.Command(tmpDir, "git", "diff", "--cached", "--name-only").Output()
+	if err != nil {
+		t.Fatalf("failed to list staged files: %v", err)
+	}
+	if strings.TrimSpace(string(staged)) != "staged.txt" {
+		t.Errorf("expected only staged.txt to be staged, got %q", staged)
+	}
+}
+
+func TestCheckpointWorkspace(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+	ref := CheckpointsRef("giverny/task-1")
+
+	// Nothing to record until the workspace changes
+	commit, err := CheckpointWorkspaceInDir(tmpDir, ref, "checkpoint")
+	if err != nil {
+		t.Fatalf("CheckpointWorkspace failed: %v", err)
+	}
+	if commit != "" {
+		t.Errorf("expected no checkpoint of an unchanged workspace, got %s", commit)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "first.txt"), []byte("first"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	first, err := CheckpointWorkspaceInDir(tmpDir, ref, "checkpoint")
+	if err != nil {
+		t.Fatalf("CheckpointWorkspace failed: %v", err)
+	}
+	if first == "" {
+		t.Fatal("expected a first checkpoint")
+	}
+
+	// Unchanged since the last checkpoint
+	if commit, err := CheckpointWorkspaceInDir(tmpDir, ref, "checkpoint"); err != nil || commit != "" {
+		t.Errorf("expected no new checkpoint, got %q (err %v)", commit, err)
+	}
+
+	// Committing the change and adding another gives a second checkpoint on top of the first
+	if err := testutil.Command(tmpDir, "sh", "-c", "git add first.txt && git commit -m 'Agent commit' && echo second > second.txt").Run(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	second, err := CheckpointWorkspaceInDir(tmpDir, ref, "checkpoint")
+	if err != nil {
+		t.Fatalf("CheckpointWorkspace failed: %v", err)
+	}
+	if second == "" {
+		t.Fatal("expected a second checkpoint")
+	}
+
+	if got, _ := GetCommitHashInDir(tmpDir, ref); got != second {
+		t.Errorf("expected %s to point at %s, got %s", ref, second, got)
+	}
+	if got, _ := GetCommitHashInDir(tmpDir, second+"^1"); got != first {
+		t.Errorf("expected first parent %s, got %s", first, got)
+	}
+	head, _ := GetCommitHashInDir(tmpDir, "HEAD")
+	if got, _ := GetCommitHashInDir(tmpDir, second+"^2"); got != head {
+		t.Errorf("expected second parent to be HEAD %s, got %s", head, got)
+	}
+	if dirty, _ := IsWorkspaceDirtyInDir(tmpDir); !dirty {
+		t.Error("expected second.txt to be left uncommitted")
+	}
+}
+
+func TestWorkspaceState(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	head, tree, err := WorkspaceStateInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("WorkspaceState failed: %v", err)
+	}
+	if h, tr, err := WorkspaceStateInDir(tmpDir); err != nil || h != head || tr != tree {
+		t.Errorf("expected the same state of an unchanged workspace, got %s %s (err %v)", h, tr, err)
+	}
+
+	// An untracked file changes the tree but not HEAD
+	if err := os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	h, tr, err := WorkspaceStateInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("WorkspaceState failed: %v", err)
+	}
+	if h != head || tr == tree {
+		t.Errorf("expected only the tree to change, got HEAD %s -> %s, tree %s -> %s", head, h, tree, tr)
+	}
+
+	// Committing it moves HEAD but leaves the tree
+	if err := testutil.Command(tmpDir, "sh", "-c", "git add notes.txt && git commit -m 'Notes'").Run(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	h2, tr2, err := WorkspaceStateInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("WorkspaceState failed: %v", err)
+	}
+	if h2 == h || tr2 != tr {
+		t.Errorf("expected only HEAD to change, got HEAD %s -> %s, tree %s -> %s", h, h2, tr, tr2)
+	}
+}
@@ -0,0 +1,60 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// Stash saves the uncommitted changes in the repository, untracked files
+// included, on the stash with message and cleans the working tree. It returns
+// the stash commit, or an empty string if there was nothing to stash.
+func Stash(message string) (string, error) {
+	return StashInDir(".", message)
+}
+
+// StashInDir is Stash for the repository at dir.
+func StashInDir(dir, message string) (string, error) {
+	before, _ := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--quiet", "--verify", "refs/stash")
+	if output, err := exec.Command("git", "-C", dir, "stash", "push", "--quiet", "--include-untracked", "--message", message).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to stash changes: %s", strings.TrimSpace(string(output)))
+	}
+	after, _ := cmdutil.RunCommandWithOutput("git", "-C", dir, "rev-parse", "--quiet", "--verify", "refs/stash")
+	if after == before {
+		return "", nil
+	}
+	return after, nil
+}
+
+// RestoreStash applies the stash commit made by Stash to the working tree and
+// drops it from the stash. If applying it conflicts, the stash is kept and
+// the error says where it is.
+func RestoreStash(stash string) error {
+	return RestoreStashInDir(".", stash)
+}
+
+// RestoreStashInDir is RestoreStash for the repository at dir.
+func RestoreStashInDir(dir, stash string) error {
+	// Other stashes may have been pushed on top of it in the meantime
+	list, err := cmdutil.RunCommandWithOutput("git", "-C", dir, "stash", "list", "--format=%H")
+	if err != nil {
+		return fmt.Errorf("failed to list stashes: %w", err)
+	}
+	entry := ""
+	for i, hash := range strings.Fields(list) {
+		if hash == stash {
+			entry = fmt.Sprintf("stash@{%d}", i)
+			break
+		}
+	}
+	if entry == "" {
+		return fmt.Errorf("stash %s is no longer on the stash", GetShortHashInDir(dir, stash))
+	}
+
+	if output, err := exec.Command("git", "-C", dir, "stash", "pop", "--quiet", entry).CombinedOutput(); err != nil {
+		return fmt.Errorf("restoring the stashed changes failed, so they were kept as %s: %s", entry, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
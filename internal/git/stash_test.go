@@ -0,0 +1,85 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestStashAndRestore(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+
+	// Nothing to stash
+	if stash, err := StashInDir(tmpDir, "giverny autostash"); err != nil || stash != "" {
+		t.Fatalf("Stash() = %q, %v, want nothing stashed", stash, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "untracked.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	stash, err := StashInDir(tmpDir, "giverny autostash")
+	if err != nil || stash == "" {
+		t.Fatalf("Stash() = %q, %v", stash, err)
+	}
+	if dirty, _ := IsWorkspaceDirtyInDir(tmpDir); dirty {
+		t.Error("expected a clean working tree after stashing")
+	}
+
+	// Another stash on top doesn't get in the way
+	if err := os.WriteFile(filepath.Join(tmpDir, "other.txt"), []byte("other\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := StashInDir(tmpDir, "someone else"); err != nil {
+		t.Fatalf("Stash() failed: %v", err)
+	}
+
+	if err := RestoreStashInDir(tmpDir, stash); err != nil {
+		t.Fatalf("RestoreStash() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "untracked.txt")); err != nil {
+		t.Errorf("expected the stashed file back: %v", err)
+	}
+	if list, _ := testutil.Command(tmpDir, "git", "stash", "list").Output(); strings.Count(string(list), "\n") != 1 || !strings.Contains(string(list), "someone else") {
+		t.Errorf("expected only the other stash left, got %q", list)
+	}
+
+	if err := RestoreStashInDir(tmpDir, stash); err == nil {
+		t.Error("expected an error restoring a stash that was already restored")
+	}
+}
+
+func TestRestoreStash_Conflict(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.InitTestRepo(t, tmpDir)
+	if err := testutil.Command(tmpDir, "sh", "-c", "echo 'one' > file.txt && git add file.txt && git commit -q -m 'Add file'").Run(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("stashed\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	stash, err := StashInDir(tmpDir, "giverny autostash")
+	if err != nil || stash == "" {
+		t.Fatalf("Stash() = %q, %v", stash, err)
+	}
+	// The task's changes are merged while the stash is away
+	if err := testutil.Command(tmpDir, "sh", "-c", "echo 'merged' > file.txt && git commit -q -am 'Change file'").Run(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	err = RestoreStashInDir(tmpDir, stash)
+	if err == nil || !strings.Contains(err.Error(), "kept as stash@{0}") {
+		t.Fatalf("expected a conflict keeping the stash, got %v", err)
+	}
+	if hash, _ := GetCommitHashInDir(tmpDir, "stash@{0}"); hash != stash {
+		t.Errorf("expected the stash to be kept, got %q", hash)
+	}
+}
@@ -0,0 +1,50 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// SummaryNotesRef returns the notes ref holding the agent's summary of a task
+// branch. Each branch gets its own ref so that tasks running at the same time
+// don't race to update a shared one.
+func SummaryNotesRef(branchName string) string {
+	return "refs/notes/" + branchName
+}
+
+// AddSummaryNote attaches summary as a note on the tip of branchName in the
+// current git repository, replacing the note from any earlier run. The notes
+// ref is first fetched from origin so that the push stays a fast-forward.
+func AddSummaryNote(branchName, summary string) error {
+	return AddSummaryNoteInDir(".", branchName, summary)
+}
+
+// AddSummaryNoteInDir is AddSummaryNote for the git repository at dir.
+func AddSummaryNoteInDir(dir, branchName, summary string) error {
+	ref := SummaryNotesRef(branchName)
+	// The ref only exists on the server if an earlier run added a summary
+	_ = cmdutil.RunCommand("git", "-C", dir, "fetch", "--quiet", "origin", "+"+ref+":"+ref)
+
+	if err := cmdutil.RunCommand("git", "-C", dir, "notes", "--ref="+ref, "add", "-f", "-m", summary, branchName); err != nil {
+		return fmt.Errorf("failed to add summary note: %w", err)
+	}
+	return nil
+}
+
+// ReadSummaryNote returns the summary note on the tip of branchName in the
+// current git repository, or "" if there is none.
+func ReadSummaryNote(branchName string) string {
+	return ReadSummaryNoteInDir(".", branchName)
+}
+
+// ReadSummaryNoteInDir is ReadSummaryNote for the git repository at dir.
+func ReadSummaryNoteInDir(dir, branchName string) string {
+	output, err := exec.Command("git", "-C", dir, "notes", "--ref="+SummaryNotesRef(branchName), "show", branchName).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,42 @@
+package git
+
+import (
+	"os"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestSummaryNote(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	branchName := "giverny/test-summary"
+	if err := CreateBranchInDir(tmpDir, branchName, ""); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+
+	if got := ReadSummaryNoteInDir(tmpDir, branchName); got != "" {
+		t.Errorf("expected no summary before one is added, got %q", got)
+	}
+
+	// No origin remote here; adding the note must still work, and a second
+	// run replaces the first summary
+	if err := AddSummaryNoteInDir(tmpDir, branchName, "- First summary"); err != nil {
+		t.Fatalf("AddSummaryNote failed: %v", err)
+	}
+	if err := AddSummaryNoteInDir(tmpDir, branchName, "- Changed the thing\n- Because of reasons"); err != nil {
+		t.Fatalf("AddSummaryNote failed: %v", err)
+	}
+
+	if got := ReadSummaryNoteInDir(tmpDir, branchName); got != "- Changed the thing\n- Because of reasons" {
+		t.Errorf("ReadSummaryNoteInDir(tmpDir) = %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// restartAttempts is how many times a supervised git server that exited
+	// is restarted before giving up
+	restartAttempts = 5
+	// restartDelay is the wait between attempts, for the port to be freed
+	restartDelay = time.Second
+	// minUptime is how long a restarted git server must run for its next
+	// exit not to count as a failed restart
+	minUptime = time.Minute
+)
+
+// Supervise restarts the git server serverCmd, which serves repoPath on
+// port, whenever its process exits, until StopServer is called on it. The
+// container keeps the port, so an hours-long task can still push at the end
+// if the daemon died early on. logf is told about each restart.
+func Supervise(serverCmd *ServerCmd, repoPath string, port int, logf func(format string, args ...any)) {
+	serverCmd.mu.Lock()
+	defer serverCmd.mu.Unlock()
+	if serverCmd.Cmd == nil || serverCmd.supervised != nil || serverCmd.stopping {
+		return
+	}
+	serverCmd.supervised = make(chan struct{})
+	go supervise(serverCmd, repoPath, port, logf)
+}
+
+func supervise(serverCmd *ServerCmd, repoPath string, port int, logf func(format string, args ...any)) {
+	defer close(serverCmd.supervised)
+
+	serverCmd.mu.Lock()
+	cmd := serverCmd.Cmd
+	serverCmd.mu.Unlock()
+	started := time.Now()
+	crashes := 0
+	for {
+		err := cmd.Wait()
+
+		serverCmd.mu.Lock()
+		if serverCmd.stopping {
+			serverCmd.mu.Unlock()
+			return
+		}
+		serverCmd.mu.Unlock()
+
+		// If git itself was killed, the daemon may still hold the port
+		killProcessGroup(cmd.Process.Pid)
+
+		// Don't keep restarting a daemon that can't stay up
+		if time.Since(started) < minUptime {
+			crashes++
+		} else {
+			crashes = 0
+		}
+		if crashes > restartAttempts {
+			logf("git server on port %d keeps exiting, not restarting it again", port)
+			return
+		}
+		if err == nil {
+			logf("git server on port %d exited, restarting it", port)
+		} else {
+			logf("git server on port %d exited (%v), restarting it", port, err)
+		}
+		restarted, err := restartServer(repoPath, serverCmd.bindAddr, port, serverCmd.token, serverCmd.branchName)
+		if err != nil {
+			logf("failed to restart git server on port %d: %v", port, err)
+			return
+		}
+
+		serverCmd.mu.Lock()
+		if serverCmd.stopping {
+			// Stopped while restarting: StopServer killed the old process
+			serverCmd.mu.Unlock()
+			StopServer(restarted)
+			return
+		}
+		// Serve from the restarted daemon's directory from now on
+		oldServeDir := serverCmd.serveDir
+		serverCmd.Cmd, serverCmd.serveDir = restarted.Cmd, restarted.serveDir
+		serverCmd.mu.Unlock()
+		if oldServeDir != "" {
+			os.RemoveAll(oldServeDir)
+		}
+		cmd, started = restarted.Cmd, time.Now()
+		logf("git server restarted on port %d", port)
+	}
+}
+
+// restartServer starts the git server again on port under token, for the task
+// on branchName, retrying while the port is still held by the old daemon
+func restartServer(repoPath, bindAddr string, port int, token, branchName string) (*ServerCmd, error) {
+	var lastErr error
+	for attempt := 0; attempt < restartAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(restartDelay)
+		}
+		restarted, err := StartServerOnPort(repoPath, bindAddr, port, token, branchName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// The daemon writes its PID file before it binds the port
+		if err := CheckServer(bindAddr, port, token); err != nil {
+			StopServer(restarted)
+			lastErr = err
+			continue
+		}
+		return restarted, nil
+	}
+	return nil, lastErr
+}
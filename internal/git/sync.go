@@ -0,0 +1,137 @@
+package git
+
+import (
+	"fmt"
+
+	"giverny/internal/cmdutil"
+)
+
+// taskBaseConfigKey is the git config key in /app that records the branch the
+// task's changes will be merged into on the host.
+const taskBaseConfigKey = "giverny.base"
+
+// taskStartConfigKey is the git config key in /app that records the commit
+// giverny last put the task's START label at.
+const taskStartConfigKey = "giverny.start"
+
+// RecordTaskStartInDir records commit as where giverny has put the START
+// label of the task in the workspace at dir, so that checks before pushing
+// can tell a move giverny made, such as when syncing, from one the agent made.
+func RecordTaskStartInDir(dir, commit string) error {
+	if err := cmdutil.RunCommand("git", "-C", dir, "config", taskStartConfigKey, commit); err != nil {
+		return fmt.Errorf("failed to record the task's start: %w", err)
+	}
+	return nil
+}
+
+// TaskStartInDir returns the commit recorded by RecordTaskStartInDir for the
+// workspace in dir, or an empty string if there is none.
+func TaskStartInDir(dir string) string {
+	start, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "config", "--get", taskStartConfigKey)
+	if err != nil {
+		return ""
+	}
+	return start
+}
+
+// SetTaskBaseBranch records the host branch the task will be merged into, so
+// that later syncs (from the menu or `giverny sync`) know what to pull.
+func SetTaskBaseBranch(baseBranch string) error {
+	return SetTaskBaseBranchInDir(DefaultWorkspaceDir, baseBranch)
+}
+
+// SetTaskBaseBranchInDir is SetTaskBaseBranch for the workspace in dir.
+func SetTaskBaseBranchInDir(dir, baseBranch string) error {
+	if err := cmdutil.RunCommand("git", "-C", dir, "config", taskBaseConfigKey, baseBranch); err != nil {
+		return fmt.Errorf("failed to record base branch: %w", err)
+	}
+	return nil
+}
+
+// TaskBaseBranch returns the host branch recorded by SetTaskBaseBranch.
+func TaskBaseBranch() (string, error) {
+	return TaskBaseBranchInDir(DefaultWorkspaceDir)
+}
+
+// TaskBaseBranchInDir is TaskBaseBranch for the workspace in dir.
+func TaskBaseBranchInDir(dir string) (string, error) {
+	baseBranch, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "config", "--get", taskBaseConfigKey)
+	if err != nil || baseBranch == "" {
+		return "", fmt.Errorf("no base branch recorded for this task")
+	}
+	return baseBranch, nil
+}
+
+// FetchWorkspace updates the remote-tracking branches of the clone behind /app
+// from the git server, without touching the checked out branch.
+func FetchWorkspace(debug bool) error {
+	return FetchWorkspaceInDir(DefaultWorkspaceDir, debug)
+}
+
+// FetchWorkspaceInDir is FetchWorkspace for the workspace in dir.
+func FetchWorkspaceInDir(dir string, debug bool) error {
+	fetchArgs := []string{"-C", dir, "fetch", "origin"}
+	if !debug {
+		fetchArgs = append(fetchArgs, "--quiet")
+	}
+	if err := cmdutil.RunCommandWithDebug(debug, "git", fetchArgs...); err != nil {
+		return fmt.Errorf("failed to fetch from git server: %w", err)
+	}
+	return nil
+}
+
+// SyncWorkspace fetches new commits from the git server and brings the host's
+// copy of baseBranch into branchName, checked out in /app, by rebasing onto it
+// if rebase is true or merging it otherwise. After a rebase onto the base
+// branch the START label is moved to the new base so it still marks where the
+// task's own commits begin. Rebasing onto the task branch itself, as when its
+// push was rejected, leaves START alone: the commits already pushed are still
+// the task's own, and must stay in the range the checks before pushing see.
+//
+// If the rebase or merge stops with conflicts it is aborted, leaving /app as it
+// was, and an error is returned.
+func SyncWorkspace(branchName, baseBranch string, rebase bool, debug bool) error {
+	return SyncWorkspaceInDir(DefaultWorkspaceDir, branchName, baseBranch, rebase, debug)
+}
+
+// SyncWorkspaceInDir is SyncWorkspace for the workspace in dir.
+func SyncWorkspaceInDir(dir, branchName, baseBranch string, rebase bool, debug bool) error {
+	status, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "status", "--porcelain")
+	if err != nil {
+		return fmt.Errorf("failed to check workspace status: %w", err)
+	}
+	if status != "" {
+		return fmt.Errorf("workspace has uncommitted changes; commit them before syncing")
+	}
+
+	if err := FetchWorkspaceInDir(dir, debug); err != nil {
+		return err
+	}
+
+	upstream := "origin/" + baseBranch
+	if rebase {
+		if err := cmdutil.RunCommandWithDebug(debug, "git", "-C", dir, "rebase", upstream); err != nil {
+			_ = cmdutil.RunCommand("git", "-C", dir, "rebase", "--abort")
+			return fmt.Errorf("rebase onto %s stopped with conflicts and was aborted", upstream)
+		}
+		if baseBranch != branchName {
+			if err := cmdutil.RunCommand("git", "-C", dir, "branch", "-f", StartLabel(branchName), upstream); err != nil {
+				return fmt.Errorf("failed to move START label: %w", err)
+			}
+			start, err := GetCommitHashInDir(dir, upstream)
+			if err != nil {
+				return err
+			}
+			if err := RecordTaskStartInDir(dir, start); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := cmdutil.RunCommandWithDebug(debug, "git", "-C", dir, "merge", "--no-edit", upstream); err != nil {
+			_ = cmdutil.RunCommand("git", "-C", dir, "merge", "--abort")
+			return fmt.Errorf("merge of %s stopped with conflicts and was aborted", upstream)
+		}
+	}
+
+	return nil
+}
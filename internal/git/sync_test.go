@@ -0,0 +1,75 @@
+package git
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestSyncWorkspaceInDir(t *testing.T) {
+	t.Parallel()
+
+	// The host's repository, served as origin, with the task branch and its
+	// START label at main's first commit
+	hostDir := t.TempDir()
+	testutil.InitTestRepo(t, hostDir)
+	branchName := "giverny/test-sync"
+	setup := "git branch " + branchName + " && git branch " + StartLabel(branchName)
+	if output, err := testutil.Command(hostDir, "sh", "-c", setup).CombinedOutput(); err != nil {
+		t.Fatalf("failed to create branches: %v\n%s", err, output)
+	}
+
+	workspaceDir := filepath.Join(t.TempDir(), "app")
+	clone := "git clone -q " + hostDir + " " + workspaceDir + " && cd " + workspaceDir +
+		" && git config user.email test@example.com && git config user.name Test" +
+		" && git checkout -q " + branchName + " && git branch " + StartLabel(branchName) + " origin/" + StartLabel(branchName) +
+		" && echo task > task.txt && git add task.txt && git commit -q -m Task"
+	if output, err := testutil.Command(hostDir, "sh", "-c", clone).CombinedOutput(); err != nil {
+		t.Fatalf("failed to clone: %v\n%s", err, output)
+	}
+	revParse := func(dir, ref string) string {
+		output, err := testutil.Command(dir, "git", "rev-parse", ref).Output()
+		if err != nil {
+			t.Fatalf("failed to resolve %s: %v", ref, err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	start := revParse(workspaceDir, StartLabel(branchName))
+
+	// A commit pushed to the task branch from elsewhere: rebasing onto it
+	// keeps START, so the pushed commit is still the task's
+	pushed := "git checkout -q " + branchName + " && echo pushed > pushed.txt && git add pushed.txt && git commit -q -m Pushed && git checkout -q main"
+	if output, err := testutil.Command(hostDir, "sh", "-c", pushed).CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit to the task branch: %v\n%s", err, output)
+	}
+	if err := SyncWorkspaceInDir(workspaceDir, branchName, branchName, true, false); err != nil {
+		t.Fatalf("SyncWorkspace onto the task branch failed: %v", err)
+	}
+	if got := revParse(workspaceDir, StartLabel(branchName)); got != start {
+		t.Errorf("expected START to stay at %s after rebasing onto the task branch, got %s", start, got)
+	}
+	if got := TaskStartInDir(workspaceDir); got != "" {
+		t.Errorf("expected no start to be recorded, got %q", got)
+	}
+	if got := revParse(workspaceDir, "HEAD~1"); got != revParse(workspaceDir, "origin/"+branchName) {
+		t.Errorf("expected the task's commit on top of the pushed one")
+	}
+
+	// A commit on the base branch: rebasing onto it moves START there
+	base := "echo base > base.txt && git add base.txt && git commit -q -m Base"
+	if output, err := testutil.Command(hostDir, "sh", "-c", base).CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit to main: %v\n%s", err, output)
+	}
+	if err := SyncWorkspaceInDir(workspaceDir, branchName, "main", true, false); err != nil {
+		t.Fatalf("SyncWorkspace onto main failed: %v", err)
+	}
+	if got := revParse(workspaceDir, StartLabel(branchName)); got != revParse(workspaceDir, "origin/main") {
+		t.Errorf("expected START to move to origin/main after rebasing onto it, got %s", got)
+	}
+	// The move is recorded, so it isn't taken for the agent's
+	if got := TaskStartInDir(workspaceDir); got != revParse(workspaceDir, "origin/main") {
+		t.Errorf("expected the new start to be recorded, got %q", got)
+	}
+}
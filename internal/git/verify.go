@@ -0,0 +1,159 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"giverny/internal/cmdutil"
+)
+
+// ErrNoPreCommitHook is returned by RunPreCommitHook when the repository has
+// no pre-commit hook installed.
+var ErrNoPreCommitHook = errors.New("no pre-commit hook installed")
+
+// RunPreCommitHook runs the pre-commit hook of the current git repository on
+// the changes between fromRef and toRef, as if they were being committed in one
+// go. It returns the hook's output and whether it passed.
+func RunPreCommitHook(fromRef, toRef string) (string, bool, error) {
+	return RunPreCommitHookInDir(".", fromRef, toRef)
+}
+
+// RunPreCommitHookInDir is RunPreCommitHook for the git repository at dir.
+// The changes are staged in a temporary worktree checked out at fromRef, so
+// the repository's own worktree is left alone.
+func RunPreCommitHookInDir(dir, fromRef, toRef string) (string, bool, error) {
+	hook, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "rev-parse", "--git-path", "hooks/pre-commit")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to find hooks directory: %w", err)
+	}
+	if !filepath.IsAbs(hook) {
+		hook = filepath.Join(dir, hook)
+	}
+	if hook, err = filepath.Abs(hook); err != nil {
+		return "", false, err
+	}
+	if info, err := os.Stat(hook); err != nil || info.Mode()&0111 == 0 {
+		return "", false, ErrNoPreCommitHook
+	}
+
+	// Resolve toRef here: in the worktree, HEAD means fromRef
+	toCommit, err := GetCommitHashInDir(dir, toRef)
+	if err != nil {
+		return "", false, err
+	}
+
+	worktree, cleanup, err := tempWorktree(dir, fromRef)
+	if err != nil {
+		return "", false, err
+	}
+	defer cleanup()
+
+	// Update the index and files to toRef, leaving HEAD at fromRef, so the
+	// changes are staged
+	if output, err := exec.Command("git", "-C", worktree, "read-tree", "-m", "-u", toCommit).CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("failed to stage changes: %s", strings.TrimSpace(string(output)))
+	}
+
+	cmd := exec.Command(hook)
+	cmd.Dir = worktree
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(output), false, nil
+		}
+		return "", false, fmt.Errorf("failed to run pre-commit hook: %w", err)
+	}
+	return string(output), true, nil
+}
+
+// VerifyResult is the outcome of one verification command
+type VerifyResult struct {
+	Command string
+	Passed  bool
+}
+
+// VerifyBranch runs each command with sh in a temporary worktree of ref in
+// the current git repository, writing their output to out.
+func VerifyBranch(ref string, commands []string, out io.Writer) ([]VerifyResult, error) {
+	return VerifyBranchInDir(".", ref, commands, out)
+}
+
+// VerifyBranchInDir is VerifyBranch for the git repository at dir. The
+// commands share the worktree and run in order; all of them run even if an
+// earlier one fails.
+func VerifyBranchInDir(dir, ref string, commands []string, out io.Writer) ([]VerifyResult, error) {
+	worktree, cleanup, err := tempWorktree(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var results []VerifyResult
+	for _, command := range commands {
+		fmt.Fprintf(out, "$ %s\n", command)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = worktree
+		cmd.Stdout = out
+		cmd.Stderr = out
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to run %q: %w", command, err)
+		}
+		results = append(results, VerifyResult{Command: command, Passed: err == nil})
+	}
+	return results, nil
+}
+
+// RunAtRef runs command with sh in a temporary worktree of the current git
+// repository checked out at ref, and returns its combined output. If the
+// command fails, its output is returned with the error.
+func RunAtRef(ref, command string) (string, error) {
+	return RunAtRefInDir(".", ref, command)
+}
+
+// RunAtRefInDir is RunAtRef for the git repository at dir.
+func RunAtRefInDir(dir, ref, command string) (string, error) {
+	worktree, cleanup, err := tempWorktree(dir, ref)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = worktree
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(output), fmt.Errorf("%q failed at %s: %w", command, ref, err)
+		}
+		return "", fmt.Errorf("failed to run %q: %w", command, err)
+	}
+	return string(output), nil
+}
+
+// tempWorktree checks out ref in a new detached worktree of the repository at
+// dir. Call the returned function to remove it.
+func tempWorktree(dir, ref string) (string, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "giverny-verify-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	worktree := filepath.Join(tmpDir, "worktree")
+
+	if output, err := exec.Command("git", "-C", dir, "worktree", "add", "--quiet", "--detach", worktree, ref).CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", nil, fmt.Errorf("failed to create worktree: %s", strings.TrimSpace(string(output)))
+	}
+	return worktree, func() {
+		exec.Command("git", "-C", dir, "worktree", "remove", "--force", worktree).Run()
+		os.RemoveAll(tmpDir)
+	}, nil
+}
@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestRunPreCommitHook(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+	if err := CreateBranchInDir(tmpDir, "start", ""); err != nil {
+		t.Fatalf("failed to create start branch: %v", err)
+	}
+
+	if _, _, err := RunPreCommitHookInDir(tmpDir, "start", "HEAD"); !errors.Is(err, ErrNoPreCommitHook) {
+		t.Fatalf("expected ErrNoPreCommitHook, got %v", err)
+	}
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", "echo done > good.txt && git add good.txt && git commit -q -m 'Good' && git branch good && echo TODO > bad.txt && git add bad.txt && git commit -q -m 'Bad'")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to make commits: %v\n%s", err, output)
+	}
+
+	// The hook rejects staged files containing TODO
+	hook := "#!/bin/sh\ngit diff --cached --name-only\n! git diff --cached | grep -q TODO\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, ".git", "hooks", "pre-commit"), []byte(hook), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+
+	output, passed, err := RunPreCommitHookInDir(tmpDir, "start", "good")
+	if err != nil {
+		t.Fatalf("RunPreCommitHook failed: %v", err)
+	}
+	if !passed || strings.TrimSpace(output) != "good.txt" {
+		t.Errorf("expected the hook to pass on good.txt, got passed=%v output=%q", passed, output)
+	}
+
+	if _, passed, err = RunPreCommitHookInDir(tmpDir, "start", "HEAD"); err != nil {
+		t.Fatalf("RunPreCommitHook failed: %v", err)
+	}
+	if passed {
+		t.Error("expected the hook to fail on bad.txt")
+	}
+
+	// The temporary worktree is cleaned up
+	if output, _ := testutil.Command(tmpDir, "git", "worktree", "list").Output(); strings.Count(string(output), "\n") != 1 {
+		t.Errorf("expected only the main worktree, got:\n%s", output)
+	}
+}
+
+func TestVerifyBranch(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+	cmd := testutil.Command(tmpDir, "sh", "-c", "git checkout -q -b task && echo done > result.txt && git add result.txt && git commit -q -m 'Task' && git checkout -q main")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to make task branch: %v\n%s", err, output)
+	}
+
+	var out bytes.Buffer
+	results, err := VerifyBranchInDir(tmpDir, "task", []string{"cat result.txt", "false", "echo ok > build.log"}, &out)
+	if err != nil {
+		t.Fatalf("VerifyBranch failed: %v", err)
+	}
+	expected := []VerifyResult{{"cat result.txt", true}, {"false", false}, {"echo ok > build.log", true}}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %+v", len(expected), results)
+	}
+	for i, want := range expected {
+		if results[i] != want {
+			t.Errorf("results[%d] = %+v, want %+v", i, results[i], want)
+		}
+	}
+	if !strings.Contains(out.String(), "$ cat result.txt\ndone\n") {
+		t.Errorf("expected commands and their output, got:\n%s", out.String())
+	}
+
+	// The task branch was verified without touching the main worktree
+	if _, err := os.Stat(filepath.Join(tmpDir, "build.log")); !os.IsNotExist(err) {
+		t.Error("expected build.log to be written in the temporary worktree only")
+	}
+	if output, _ := testutil.Command(tmpDir, "git", "worktree", "list").Output(); strings.Count(string(output), "\n") != 1 {
+		t.Errorf("expected only the main worktree, got:\n%s", output)
+	}
+}
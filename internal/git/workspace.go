@@ -1,40 +1,90 @@
 package git
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"giverny/internal/cmdutil"
 )
 
-// SetupWorkspace creates /app, checks out the branch, and creates a START label
-func SetupWorkspace(branchName string, debug bool) error {
-	// Create /app directory
-	if err := os.MkdirAll("/app", 0755); err != nil {
-		return fmt.Errorf("failed to create /app directory: %w", err)
+// DefaultWorkspaceDir is where innie checks out the task branch for the agent
+// to work in.
+const DefaultWorkspaceDir = "/app"
+
+// DefaultCloneDir is where innie clones the repository from the git server.
+// The workspace is a worktree of this clone.
+const DefaultCloneDir = "/git"
+
+// SetupWorkspace creates /app, checks out the branch, and creates a START label.
+// If baseBranch is not empty, branchName does not exist on the git server yet and
+// is created from the server's copy of baseBranch instead.
+// If sparsePaths is not empty, only those directories (and files at the top
+// level) are checked out, using a cone-mode sparse checkout.
+func SetupWorkspace(branchName, baseBranch string, sparsePaths []string, debug bool) error {
+	return SetupWorkspaceInDirs(DefaultCloneDir, DefaultWorkspaceDir, branchName, baseBranch, sparsePaths, debug)
+}
+
+// SetupWorkspaceInDirs is SetupWorkspace for a clone in cloneDir and a
+// workspace in workspaceDir.
+func SetupWorkspaceInDirs(cloneDir, workspaceDir, branchName, baseBranch string, sparsePaths []string, debug bool) error {
+	// Create the workspace directory
+	if err := os.MkdirAll(workspaceDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", workspaceDir, err)
+	}
+
+	// Checkout the branch to the workspace using git worktree. For a sparse checkout,
+	// add the worktree without checking out files so that only the sparse
+	// paths are ever written (and, for partial clones, fetched).
+	worktreeArgs := []string{"-C", cloneDir, "worktree", "add"}
+	if len(sparsePaths) > 0 {
+		worktreeArgs = append(worktreeArgs, "--no-checkout")
+	}
+	if baseBranch != "" {
+		worktreeArgs = append(worktreeArgs, "-b", branchName, workspaceDir, "origin/"+baseBranch)
+		if err := cmdutil.RunCommandWithDebug(debug, "git", worktreeArgs...); err != nil {
+			return fmt.Errorf("failed to create branch %s from %s in %s: %w", branchName, baseBranch, workspaceDir, err)
+		}
+	} else {
+		worktreeArgs = append(worktreeArgs, workspaceDir, branchName)
+		if err := cmdutil.RunCommandWithDebug(debug, "git", worktreeArgs...); err != nil {
+			return fmt.Errorf("failed to checkout branch %s to %s: %w", branchName, workspaceDir, err)
+		}
 	}
 
-	// Checkout the branch to /app using git worktree
-	if err := cmdutil.RunCommandWithDebug(debug, "git", "-C", "/git", "worktree", "add", "/app", branchName); err != nil {
-		return fmt.Errorf("failed to checkout branch %s to /app: %w", branchName, err)
+	if len(sparsePaths) > 0 {
+		sparseArgs := append([]string{"-C", workspaceDir, "sparse-checkout", "set", "--cone"}, sparsePaths...)
+		if err := cmdutil.RunCommandWithDebug(debug, "git", sparseArgs...); err != nil {
+			return fmt.Errorf("failed to set sparse-checkout paths: %w", err)
+		}
+		if err := cmdutil.RunCommandWithDebug(debug, "git", "-C", workspaceDir, "checkout"); err != nil {
+			return fmt.Errorf("failed to checkout sparse paths to %s: %w", workspaceDir, err)
+		}
+		if debug {
+			fmt.Printf("Sparse checkout of: %s\n", strings.Join(sparsePaths, ", "))
+		}
 	}
 	if debug {
-		fmt.Printf("Checked out branch %s to /app\n", branchName)
+		fmt.Printf("Checked out branch %s to %s\n", branchName, workspaceDir)
 	}
 
 	// Configure git user for commits
-	if err := cmdutil.RunCommand("git", "-C", "/app", "config", "user.email", "noreply@anthropic.com"); err != nil {
+	if err := cmdutil.RunCommand("git", "-C", workspaceDir, "config", "user.email", "noreply@anthropic.com"); err != nil {
 		return fmt.Errorf("failed to set git user.email: %w", err)
 	}
 
-	if err := cmdutil.RunCommand("git", "-C", "/app", "config", "user.name", "Claude Code"); err != nil {
+	if err := cmdutil.RunCommand("git", "-C", workspaceDir, "config", "user.name", "Claude Code"); err != nil {
 		return fmt.Errorf("failed to set git user.name: %w", err)
 	}
 
 	// Create START label branch to mark where we started
-	startLabel := branchName + "-START"
-	if err := cmdutil.RunCommand("git", "-C", "/app", "branch", startLabel); err != nil {
+	startLabel := StartLabel(branchName)
+	if err := cmdutil.RunCommand("git", "-C", workspaceDir, "branch", startLabel); err != nil {
 		return fmt.Errorf("failed to create START label branch %s: %w", startLabel, err)
 	}
 	if debug {
@@ -44,9 +94,53 @@ func SetupWorkspace(branchName string, debug bool) error {
 	return nil
 }
 
+// UpdateWorkspace brings an existing /app worktree up to date after /git has
+// been fetched, for when a container is reused. Work left in /app by the earlier
+// run is kept: the branch is only fast-forwarded to the server's copy if the
+// workspace is clean and the server's copy is strictly ahead.
+func UpdateWorkspace(branchName string, debug bool) error {
+	return UpdateWorkspaceInDir(DefaultWorkspaceDir, branchName, debug)
+}
+
+// UpdateWorkspaceInDir is UpdateWorkspace for the worktree in dir.
+func UpdateWorkspaceInDir(dir, branchName string, debug bool) error {
+	remoteBranch := "origin/" + branchName
+	if err := cmdutil.RunCommand("git", "-C", dir, "rev-parse", "--verify", "--quiet", remoteBranch); err != nil {
+		// Branch has not been pushed to the server; nothing to update from
+		return nil
+	}
+
+	status, err := cmdutil.RunCommandInDirWithOutput(dir, "git", "status", "--porcelain")
+	if err != nil {
+		return fmt.Errorf("failed to check workspace status: %w", err)
+	}
+	if status != "" {
+		fmt.Printf("Keeping uncommitted changes in %s from the previous run\n", dir)
+		return nil
+	}
+
+	if err := cmdutil.RunCommand("git", "-C", dir, "merge-base", "--is-ancestor", "HEAD", remoteBranch); err != nil {
+		// Local branch has commits the server doesn't; keep them
+		return nil
+	}
+	if err := cmdutil.RunCommandWithDebug(debug, "git", "-C", dir, "merge", "--ff-only", remoteBranch); err != nil {
+		return fmt.Errorf("failed to fast-forward %s to %s: %w", branchName, remoteBranch, err)
+	}
+	if debug {
+		fmt.Printf("Updated %s in %s from the git server\n", branchName, dir)
+	}
+
+	return nil
+}
+
 // IsWorkspaceDirty checks if there are uncommitted changes in the current git repository
 func IsWorkspaceDirty() (bool, error) {
-	cmd := exec.Command("git", "status", "--porcelain")
+	return IsWorkspaceDirtyInDir(".")
+}
+
+// IsWorkspaceDirtyInDir checks if there are uncommitted changes in the git repository at dir
+func IsWorkspaceDirtyInDir(dir string) (bool, error) {
+	cmd := exec.Command("git", "-C", dir, "status", "--porcelain")
 	output, err := cmd.Output()
 	if err != nil {
 		return false, err
@@ -54,20 +148,223 @@ func IsWorkspaceDirty() (bool, error) {
 	return len(output) > 0, nil
 }
 
-// PushBranch pushes the branch to the git server
-func PushBranch(branchName string, gitServerPort int, debug bool) error {
+// HasStagedChanges checks if there are changes staged in the index of the current git repository
+func HasStagedChanges() (bool, error) {
+	return HasStagedChangesInDir(".")
+}
+
+// HasStagedChangesInDir checks if there are changes staged in the index of the git repository at dir
+func HasStagedChangesInDir(dir string) (bool, error) {
+	cmd := exec.Command("git", "-C", dir, "diff", "--cached", "--quiet")
+	err := cmd.Run()
+	if err != nil {
+		// Exit status 1 means there are staged differences
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// ChangedFiles returns the paths of the files that differ between fromRef and
+// HEAD in the current git repository, including deleted and renamed files.
+func ChangedFiles(fromRef string) ([]string, error) {
+	return ChangedFilesInDir(".", fromRef)
+}
+
+// ChangedFilesInDir is ChangedFiles for the git repository at dir.
+func ChangedFilesInDir(dir, fromRef string) ([]string, error) {
+	return ChangedFilesBetweenInDir(dir, fromRef, "HEAD")
+}
+
+// ChangedFilesBetweenInDir returns the paths of the files that differ between
+// fromRef and toRef in the git repository at dir.
+func ChangedFilesBetweenInDir(dir, fromRef, toRef string) ([]string, error) {
+	cmd := exec.Command("git", "-C", dir, "diff", "--name-only", "--no-renames", fromRef, toRef)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files between %s and %s: %w", fromRef, toRef, err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// IgnoredFilesInDir returns the paths that the .gitignore rules of the git
+// repository at dir say should not be committed, whether or not they are.
+func IgnoredFilesInDir(dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	cmd := exec.Command("git", "-C", dir, "check-ignore", "--no-index", "--stdin")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 means none of the paths are ignored
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check ignored files: %w", err)
+	}
+
+	var ignored []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			ignored = append(ignored, line)
+		}
+	}
+	return ignored, nil
+}
+
+// ResetWorkspace discards all changes in the current git repository by
+// hard-resetting to ref and removing untracked files and directories.
+func ResetWorkspace(ref string) error {
+	return ResetWorkspaceInDir(".", ref)
+}
+
+// ResetWorkspaceInDir is ResetWorkspace for the git repository at dir.
+func ResetWorkspaceInDir(dir, ref string) error {
+	if err := cmdutil.RunCommand("git", "-C", dir, "reset", "--hard", ref); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", ref, err)
+	}
+	if err := cmdutil.RunCommand("git", "-C", dir, "clean", "-fd"); err != nil {
+		return fmt.Errorf("failed to remove untracked files: %w", err)
+	}
+	return nil
+}
+
+// PushBranch pushes the branch to the git server, along with any extra
+// refspecs (e.g. "refs/tags/*" for tags the agent created).
+// Progress (including object counts) is shown on stderr. A push that fails
+// because the connection to the git daemon dropped is retried once.
+func PushBranch(branchName string, extraRefspecs []string, gitServerPort int, debug bool) error {
+	return PushBranchInDir(DefaultWorkspaceDir, branchName, extraRefspecs, gitServerPort, debug)
+}
+
+// PushBranchInDir is PushBranch for the worktree in dir.
+func PushBranchInDir(dir, branchName string, extraRefspecs []string, gitServerPort int, debug bool) error {
 	fmt.Printf("Pushing %s to git server...\n", branchName)
 
-	// Construct the git server URL
-	// When git daemon serves with --base-path pointing to a repo,
-	// we reference it with / (empty path after host:port)
-	gitServerURL := fmt.Sprintf("git://host.docker.internal:%d/", gitServerPort)
+	gitServerURL := RepoURL(gitServerPort)
 
 	// Push the branch
-	if err := cmdutil.RunCommandInDirWithDebug("/app", debug, "git", "push", gitServerURL, branchName); err != nil {
+	for attempt := 1; ; attempt++ {
+		output, err := runPush(dir, gitServerURL, append([]string{branchName}, extraRefspecs...), debug)
+		if err == nil {
+			break
+		}
+		if isNonFastForwardPushError(output) {
+			return fmt.Errorf("git push failed: %w", ErrPushRejected)
+		}
+		if attempt < maxPushAttempts && isTransientPushError(output) {
+			fmt.Fprintf(os.Stderr, "Push failed with a transient error, retrying...\n")
+			time.Sleep(pushRetryDelay)
+			continue
+		}
 		return fmt.Errorf("git push failed: %w", err)
 	}
 
 	fmt.Printf("✓ Successfully pushed %s\n", branchName)
 	return nil
 }
+
+// ForcePushBranch pushes the branch to the git server, replacing the server's
+// copy only if it is still at expectedCommit. This is used to overwrite commits
+// made on the host while the container ran, after the user has seen them.
+func ForcePushBranch(branchName, expectedCommit string, extraRefspecs []string, gitServerPort int, debug bool) error {
+	return ForcePushBranchInDir(DefaultWorkspaceDir, branchName, expectedCommit, extraRefspecs, gitServerPort, debug)
+}
+
+// ForcePushBranchInDir is ForcePushBranch for the worktree in dir.
+func ForcePushBranchInDir(dir, branchName, expectedCommit string, extraRefspecs []string, gitServerPort int, debug bool) error {
+	fmt.Printf("Force pushing %s to git server...\n", branchName)
+
+	gitServerURL := RepoURL(gitServerPort)
+	lease := fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branchName, expectedCommit)
+	output, err := runPush(dir, gitServerURL, append([]string{lease, branchName}, extraRefspecs...), debug)
+	if err != nil {
+		if isNonFastForwardPushError(output) {
+			return fmt.Errorf("git push failed: %w", ErrPushRejected)
+		}
+		return fmt.Errorf("git push failed: %w", err)
+	}
+
+	fmt.Printf("✓ Successfully pushed %s\n", branchName)
+	return nil
+}
+
+// ErrPushRejected is returned when the git server refuses a push because the
+// branch there has commits that the container does not have.
+var ErrPushRejected = errors.New("the branch has new commits on the host")
+
+const (
+	maxPushAttempts = 2
+	pushRetryDelay  = time.Second
+)
+
+// runPush runs git push with progress output, streaming stderr to the terminal
+// while also capturing it so that failures can be classified.
+func runPush(dir, url string, refspecs []string, debug bool) (string, error) {
+	args := append([]string{"push", "--progress", url}, refspecs...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if debug {
+		cmd.Stdout = os.Stdout
+	}
+	err := cmd.Run()
+	return stderr.String(), err
+}
+
+// ValidatePushRefspec checks that an extra refspec only transfers tags or
+// notes. The task branch is always pushed; other branches, forced updates and
+// deletions are not allowed, so the agent cannot rewrite refs on the host.
+func ValidatePushRefspec(refspec string) error {
+	if strings.HasPrefix(refspec, "+") {
+		return fmt.Errorf("refspec '%s' must not force updates", refspec)
+	}
+	src, dst, found := strings.Cut(refspec, ":")
+	if !found {
+		dst = src
+	}
+	if src == "" {
+		return fmt.Errorf("refspec '%s' must not delete refs", refspec)
+	}
+	if !strings.HasPrefix(dst, "refs/tags/") && !strings.HasPrefix(dst, "refs/notes/") {
+		return fmt.Errorf("refspec '%s' must push to refs/tags/ or refs/notes/", refspec)
+	}
+	return nil
+}
+
+// isNonFastForwardPushError reports whether git push output shows the branch
+// was rejected because the server's copy is not an ancestor of ours, or has
+// moved since the lease given to --force-with-lease.
+func isNonFastForwardPushError(output string) bool {
+	return strings.Contains(output, "[rejected]") &&
+		(strings.Contains(output, "non-fast-forward") || strings.Contains(output, "fetch first") || strings.Contains(output, "stale info"))
+}
+
+// isTransientPushError reports whether git push output indicates a dropped or
+// refused connection to the git daemon, as opposed to a rejected push.
+func isTransientPushError(output string) bool {
+	transient := []string{
+		"Connection refused",
+		"Connection reset",
+		"the remote end hung up unexpectedly",
+		"early EOF",
+	}
+	for _, marker := range transient {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+	return false
+}
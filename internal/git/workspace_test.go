@@ -0,0 +1,308 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giverny/internal/testutil"
+)
+
+func TestResetWorkspace(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary git repository for testing
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Initialize git repo
+	testutil.InitTestRepo(t, tmpDir)
+
+	branchName := "giverny/test-reset"
+	startLabel := StartLabel(branchName)
+	if err := CreateBranchInDir(tmpDir, startLabel, ""); err != nil {
+		t.Fatalf("failed to create START label: %v", err)
+	}
+
+	// Make a commit, a tracked change and an untracked file
+	cmd := testutil.Command(tmpDir, "sh", "-c", "echo 'committed' > committed.txt && git add committed.txt && git commit -m 'Agent commit'")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commit: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "untracked.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("failed to create untracked file: %v", err)
+	}
+
+	if err := ResetWorkspaceInDir(tmpDir, startLabel); err != nil {
+		t.Fatalf("ResetWorkspace failed: %v", err)
+	}
+
+	dirty, err := IsWorkspaceDirtyInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to check workspace status: %v", err)
+	}
+	if dirty {
+		t.Error("expected clean workspace after reset")
+	}
+
+	head, err := testutil.Command(tmpDir, "git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+	start, err := testutil.Command(tmpDir, "git", "rev-parse", startLabel).Output()
+	if err != nil {
+		t.Fatalf("failed to get START label: %v", err)
+	}
+	if strings.TrimSpace(string(head)) != strings.TrimSpace(string(start)) {
+		t.Errorf("expected HEAD to be at %s after reset", startLabel)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "committed.txt")); !os.IsNotExist(err) {
+		t.Error("expected committed file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "untracked.txt")); !os.IsNotExist(err) {
+		t.Error("expected untracked file to be removed")
+	}
+}
+
+func TestHasStagedChanges(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary git repository for testing
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Initialize git repo
+	testutil.InitTestRepo(t, tmpDir)
+
+	// An unstaged modification does not count
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	staged, err := HasStagedChangesInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("HasStagedChanges failed: %v", err)
+	}
+	if staged {
+		t.Error("expected no staged changes before git add")
+	}
+
+	if err := testutil.Command(tmpDir, "git", "add", "test.txt").Run(); err != nil {
+		t.Fatalf("failed to stage test file: %v", err)
+	}
+	staged, err = HasStagedChangesInDir(tmpDir)
+	if err != nil {
+		t.Fatalf("HasStagedChanges failed: %v", err)
+	}
+	if !staged {
+		t.Error("expected staged changes after git add")
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	if err := CreateBranchInDir(tmpDir, "start", ""); err != nil {
+		t.Fatalf("failed to create start branch: %v", err)
+	}
+
+	files, err := ChangedFilesInDir(tmpDir, "start")
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no changed files, got %v", files)
+	}
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", "mkdir -p dir && echo 'new' > dir/new.txt && git rm -q test.txt && git add dir && git commit -m 'Agent commit'")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to make commit: %v", err)
+	}
+
+	files, err = ChangedFilesInDir(tmpDir, "start")
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+	if strings.Join(files, ",") != "dir/new.txt,test.txt" {
+		t.Errorf("expected dir/new.txt and test.txt to be changed, got %v", files)
+	}
+}
+
+func TestIgnoredFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	ignored, err := IgnoredFilesInDir(tmpDir, []string{"test.txt", "debug.log"})
+	if err != nil {
+		t.Fatalf("IgnoredFiles failed: %v", err)
+	}
+	if len(ignored) != 0 {
+		t.Errorf("expected no ignored files without a .gitignore, got %v", ignored)
+	}
+
+	// Committed files are still reported if .gitignore covers them
+	cmd := testutil.Command(tmpDir, "sh", "-c", "printf '*.log\\nout/\\n' > .gitignore && mkdir out && echo x > out/app && echo x > debug.log && git add -f . && git commit -q -m 'Add artifacts'")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to make commit: %v\n%s", err, output)
+	}
+
+	ignored, err = IgnoredFilesInDir(tmpDir, []string{"test.txt", "debug.log", "out/app"})
+	if err != nil {
+		t.Fatalf("IgnoredFiles failed: %v", err)
+	}
+	if strings.Join(ignored, ",") != "debug.log,out/app" {
+		t.Errorf("expected debug.log and out/app to be ignored, got %v", ignored)
+	}
+}
+
+func TestSetupWorkspaceInDirs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-git-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	originDir := filepath.Join(tmpDir, "origin")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	workspaceDir := filepath.Join(tmpDir, "workspace")
+	if err := os.Mkdir(originDir, 0755); err != nil {
+		t.Fatalf("failed to create origin dir: %v", err)
+	}
+	testutil.InitTestRepo(t, originDir)
+
+	// Clone the way innie does, then check out a new task branch from main
+	if output, err := testutil.Command(tmpDir, "git", "clone", "--quiet", "--no-checkout", originDir, cloneDir).CombinedOutput(); err != nil {
+		t.Fatalf("failed to clone: %v\n%s", err, output)
+	}
+	branchName := "giverny/test-dirs"
+	if err := SetupWorkspaceInDirs(cloneDir, workspaceDir, branchName, "main", nil, false); err != nil {
+		t.Fatalf("SetupWorkspaceInDirs failed: %v", err)
+	}
+
+	current, err := testutil.Command(workspaceDir, "git", "branch", "--show-current").Output()
+	if err != nil {
+		t.Fatalf("failed to get current branch: %v", err)
+	}
+	if strings.TrimSpace(string(current)) != branchName {
+		t.Errorf("expected %s checked out in the workspace, got %s", branchName, current)
+	}
+	if _, err := os.Stat(filepath.Join(workspaceDir, "test.txt")); err != nil {
+		t.Errorf("expected test.txt in the workspace: %v", err)
+	}
+	if exists, err := BranchExistsInDir(workspaceDir, StartLabel(branchName)); err != nil || !exists {
+		t.Errorf("expected START label to exist, got exists=%v err=%v", exists, err)
+	}
+
+	if err := SetTaskBaseBranchInDir(workspaceDir, "main"); err != nil {
+		t.Fatalf("SetTaskBaseBranchInDir failed: %v", err)
+	}
+	if base, err := TaskBaseBranchInDir(workspaceDir); err != nil || base != "main" {
+		t.Errorf("expected base branch main, got %q (err=%v)", base, err)
+	}
+}
+
+func TestIsTransientPushError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected bool
+	}{
+		{"connection refused", "fatal: unable to connect to host.docker.internal:\nhost.docker.internal[0: 192.168.65.254]: errno=Connection refused", true},
+		{"hung up", "fatal: the remote end hung up unexpectedly", true},
+		{"rejected", " ! [rejected]        giverny/task -> giverny/task (non-fast-forward)", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientPushError(tt.output); got != tt.expected {
+				t.Errorf("isTransientPushError(%q) = %v, want %v", tt.output, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsNonFastForwardPushError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected bool
+	}{
+		{"non-fast-forward", " ! [rejected]        giverny/task -> giverny/task (non-fast-forward)", true},
+		{"fetch first", " ! [rejected]        giverny/task -> giverny/task (fetch first)", true},
+		{"stale lease", " ! [rejected]        giverny/task -> giverny/task (stale info)", true},
+		{"denied deletion", " ! [remote rejected] giverny/task (deletion prohibited)", false},
+		{"hung up", "fatal: the remote end hung up unexpectedly", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNonFastForwardPushError(tt.output); got != tt.expected {
+				t.Errorf("isNonFastForwardPushError(%q) = %v, want %v", tt.output, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidatePushRefspec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		refspec     string
+		shouldError bool
+	}{
+		{"refs/tags/*", false},
+		{"refs/notes/*", false},
+		{"refs/tags/v1.0:refs/tags/v1.0", false},
+		{"refs/notes/commits", false},
+		{"+refs/tags/*", true},
+		{":refs/tags/v1.0", true},
+		{"refs/heads/main", true},
+		{"HEAD:refs/heads/main", true},
+		{"main", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.refspec, func(t *testing.T) {
+			err := ValidatePushRefspec(tt.refspec)
+			if tt.shouldError && err == nil {
+				t.Errorf("expected error for %q", tt.refspec)
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error for %q: %v", tt.refspec, err)
+			}
+		})
+	}
+}
@@ -1,63 +1,204 @@
 package gitops
 
-import "giverny/internal/git"
+import (
+	"io"
+
+	"giverny/internal/git"
+)
 
 // GitOps defines the interface for all git operations needed by outie and innie.
 // This interface allows for mocking git operations in tests.
 type GitOps interface {
 	// Branch operations
 	IsWorkspaceDirty() (bool, error)
+	StashChanges(message string) (string, error)
+	MeasureRepo() (git.RepoSize, error)
+	RestoreStash(stash string) error
+	CurrentBranch() (string, error)
+	ProtectedBranches() ([]string, error)
+	InProgressOperation() (string, error)
 	BranchExists(branchName string) (bool, error)
-	CreateBranch(branchName string) error
-	GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, err error)
+	CreateBranch(branchName, startPoint string) error
+	GetBranchCommitRange(branchName, baseBranch string) (firstCommit, lastCommit string, err error)
 	GetShortHash(hash string) string
+	GetCommitHash(ref string) (string, error)
+	MergeBase(ref1, ref2 string) (string, error)
+	DefaultBranch() string
+	SquashBranch(branchName, firstCommit, message string) (string, error)
+	FastForwardBranch(targetBranch, branchName string) error
+	DeleteBranch(branchName string) error
+	RemoteExists(remote string) (bool, error)
+	PushBranchToRemote(remote, branchName string) error
 
 	// Server operations
-	StartServer(repoPath string) (*git.ServerCmd, int, error)
+	StartServer(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error)
+	StartServerOnPort(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error)
 	StopServer(serverCmd *git.ServerCmd) error
+	CheckServer(bindAddr string, port int, token string) error
+	ReclaimServerPort(repoPath string, port int) (bool, error)
+	SuperviseServer(serverCmd *git.ServerCmd, repoPath string, port int, logf func(format string, args ...any))
 
 	// Repository operations (for innie)
-	CloneRepo(gitPort int, debug bool) error
-	SetupWorkspace(branchName string, debug bool) error
-	PushBranch(branchName string, gitPort int, debug bool) error
+	CloneRepo(gitPort int, opts git.CloneOptions, debug bool) error
+	FetchRepo(gitPort int, debug bool) error
+	SetupWorkspace(branchName, baseBranch string, sparsePaths []string, debug bool) error
+	UpdateWorkspace(branchName string, debug bool) error
+	SetTaskBaseBranch(baseBranch string) error
+	InstallProvenanceHook(trailers []git.Trailer) error
+	ChangedFiles(fromRef string) ([]string, error)
+	ChangedFilesBetween(fromRef, toRef string) ([]string, error)
+	DiffStats(fromRef string) ([]git.FileStat, error)
+	NoiseFiles(fromRef string) ([]string, error)
+	IgnoredFiles(paths []string) ([]string, error)
+	RunPreCommitHook(fromRef, toRef string) (string, bool, error)
+	VerifyBranch(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error)
+	RunAtRef(ref, command string) (string, error)
+	AddSummaryNote(branchName, summary string) error
+	ReadSummaryNote(branchName string) string
+	PushBranch(branchName string, extraRefspecs []string, gitPort int, debug bool) error
+	ForcePushBranch(branchName, expectedCommit string, extraRefspecs []string, gitPort int, debug bool) error
+	FetchWorkspace(debug bool) error
+	SyncWorkspace(branchName, baseBranch string, rebase bool, debug bool) error
+	SnapshotWorkspace(message string) (string, error)
+	WorkspaceState() (head, tree string, err error)
+	CheckpointWorkspace(ref, message string) (string, error)
+	PushRef(commit, ref string, gitPort int, debug bool) error
+	BranchProblems(branchName, startCommit string) ([]string, error)
+	RecordTaskStart(commit string) error
+	TaskStart() string
+	ExcludePath(pattern string) error
+	MeasureCoverage(ref, command string) (float64, string, error)
 }
 
-// RealGitOps implements GitOps using the actual git package functions
-type RealGitOps struct{}
+// RealGitOps implements GitOps using the actual git package functions.
+// Branch operations run against repo; innie's clone and workspace operations
+// use cloneDir and workspaceDir.
+type RealGitOps struct {
+	repo         *git.Repository
+	workspaceDir string
+	cloneDir     string
+}
 
-// NewRealGitOps creates a new RealGitOps instance
+// NewRealGitOps creates a new RealGitOps instance for the repository in the
+// current working directory, with innie's clone and workspace in /git and /app
 func NewRealGitOps() *RealGitOps {
-	return &RealGitOps{}
+	return NewRealGitOpsWithDirs(git.DefaultWorkspaceDir, git.DefaultCloneDir)
+}
+
+// NewRealGitOpsForRepo creates a new RealGitOps instance for repo
+func NewRealGitOpsForRepo(repo *git.Repository) *RealGitOps {
+	return &RealGitOps{repo: repo, workspaceDir: git.DefaultWorkspaceDir, cloneDir: git.DefaultCloneDir}
+}
+
+// NewRealGitOpsWithDirs creates a new RealGitOps instance for innie, with the
+// clone in cloneDir and the task branch checked out in workspaceDir
+func NewRealGitOpsWithDirs(workspaceDir, cloneDir string) *RealGitOps {
+	return &RealGitOps{repo: &git.Repository{}, workspaceDir: workspaceDir, cloneDir: cloneDir}
 }
 
 // IsWorkspaceDirty checks if the workspace has uncommitted changes
 func (g *RealGitOps) IsWorkspaceDirty() (bool, error) {
-	return git.IsWorkspaceDirty()
+	return g.repo.Dirty()
+}
+
+// MeasureRepo works out how big the repository is
+func (g *RealGitOps) MeasureRepo() (git.RepoSize, error) {
+	return g.repo.Measure()
+}
+
+// StashChanges stashes the uncommitted changes and returns the stash commit
+func (g *RealGitOps) StashChanges(message string) (string, error) {
+	return g.repo.Stash(message)
+}
+
+// RestoreStash pops a stash commit made by StashChanges
+func (g *RealGitOps) RestoreStash(stash string) error {
+	return g.repo.RestoreStash(stash)
+}
+
+// CurrentBranch returns the branch checked out, or "" if HEAD is detached
+func (g *RealGitOps) CurrentBranch() (string, error) {
+	return g.repo.CurrentBranch()
+}
+
+// ProtectedBranches returns the patterns of branches tasks must not start from
+func (g *RealGitOps) ProtectedBranches() ([]string, error) {
+	return g.repo.ProtectedBranches()
+}
+
+// InProgressOperation returns the unfinished operation in the repository, if any
+func (g *RealGitOps) InProgressOperation() (string, error) {
+	return g.repo.InProgressOperation()
 }
 
 // BranchExists checks if a branch exists
 func (g *RealGitOps) BranchExists(branchName string) (bool, error) {
-	return git.BranchExists(branchName)
+	return g.repo.BranchExists(branchName)
 }
 
 // CreateBranch creates a new git branch
-func (g *RealGitOps) CreateBranch(branchName string) error {
-	return git.CreateBranch(branchName)
+func (g *RealGitOps) CreateBranch(branchName, startPoint string) error {
+	return g.repo.CreateBranch(branchName, startPoint)
 }
 
 // GetBranchCommitRange gets the first and last commit of a branch
-func (g *RealGitOps) GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, err error) {
-	return git.GetBranchCommitRange(branchName)
+func (g *RealGitOps) GetBranchCommitRange(branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
+	return g.repo.CommitRange(branchName, baseBranch)
+}
+
+// GetCommitHash resolves a ref to a full commit hash
+func (g *RealGitOps) GetCommitHash(ref string) (string, error) {
+	return g.repo.CommitHash(ref)
+}
+
+// MergeBase returns the best common ancestor of two refs
+func (g *RealGitOps) MergeBase(ref1, ref2 string) (string, error) {
+	return g.repo.MergeBase(ref1, ref2)
+}
+
+// DefaultBranch returns the repository's default branch
+func (g *RealGitOps) DefaultBranch() string {
+	return g.repo.DefaultBranch()
 }
 
 // GetShortHash converts a full hash to short form
 func (g *RealGitOps) GetShortHash(hash string) string {
-	return git.GetShortHash(hash)
+	return g.repo.ShortHash(hash)
+}
+
+// SquashBranch collapses a branch's commits into a single commit
+func (g *RealGitOps) SquashBranch(branchName, firstCommit, message string) (string, error) {
+	return g.repo.Squash(branchName, firstCommit, message)
+}
+
+// FastForwardBranch moves targetBranch forward to branchName
+func (g *RealGitOps) FastForwardBranch(targetBranch, branchName string) error {
+	return g.repo.FastForward(targetBranch, branchName)
+}
+
+// DeleteBranch deletes a branch and its START label
+func (g *RealGitOps) DeleteBranch(branchName string) error {
+	return g.repo.DeleteBranch(branchName)
+}
+
+// RemoteExists reports whether the repository has a remote named remote
+func (g *RealGitOps) RemoteExists(remote string) (bool, error) {
+	return g.repo.RemoteExists(remote)
+}
+
+// PushBranchToRemote pushes a branch to a remote and sets it as its upstream
+func (g *RealGitOps) PushBranchToRemote(remote, branchName string) error {
+	return g.repo.PushToRemote(remote, branchName)
 }
 
 // StartServer starts a git daemon server
-func (g *RealGitOps) StartServer(repoPath string) (*git.ServerCmd, int, error) {
-	return git.StartServer(repoPath)
+func (g *RealGitOps) StartServer(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+	return git.StartServer(repoPath, bindAddr, token, branchName)
+}
+
+// StartServerOnPort starts a git daemon server on a specific port
+func (g *RealGitOps) StartServerOnPort(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error) {
+	return git.StartServerOnPort(repoPath, bindAddr, port, token, branchName)
 }
 
 // StopServer stops a running git server
@@ -65,17 +206,163 @@ func (g *RealGitOps) StopServer(serverCmd *git.ServerCmd) error {
 	return git.StopServer(serverCmd)
 }
 
+// CheckServer checks that the git server on a port serves the repository
+func (g *RealGitOps) CheckServer(bindAddr string, port int, token string) error {
+	return git.CheckServer(bindAddr, port, token)
+}
+
+// ReclaimServerPort frees a port held by an earlier git server for the repository
+func (g *RealGitOps) ReclaimServerPort(repoPath string, port int) (bool, error) {
+	return git.ReclaimPort(repoPath, port)
+}
+
+// SuperviseServer restarts a git server whenever it exits, until it is stopped
+func (g *RealGitOps) SuperviseServer(serverCmd *git.ServerCmd, repoPath string, port int, logf func(format string, args ...any)) {
+	git.Supervise(serverCmd, repoPath, port, logf)
+}
+
 // CloneRepo clones the repository from the git server
-func (g *RealGitOps) CloneRepo(gitPort int, debug bool) error {
-	return git.CloneRepo(gitPort, debug)
+func (g *RealGitOps) CloneRepo(gitPort int, opts git.CloneOptions, debug bool) error {
+	return git.CloneRepoToDir(gitPort, g.cloneDir, opts, debug)
+}
+
+// FetchRepo updates an existing clone from the git server
+func (g *RealGitOps) FetchRepo(gitPort int, debug bool) error {
+	return git.FetchRepoToDir(gitPort, g.cloneDir, debug)
+}
+
+// SetupWorkspace sets up the workspace
+func (g *RealGitOps) SetupWorkspace(branchName, baseBranch string, sparsePaths []string, debug bool) error {
+	return git.SetupWorkspaceInDirs(g.cloneDir, g.workspaceDir, branchName, baseBranch, sparsePaths, debug)
+}
+
+// UpdateWorkspace brings an existing workspace up to date
+func (g *RealGitOps) UpdateWorkspace(branchName string, debug bool) error {
+	return git.UpdateWorkspaceInDir(g.workspaceDir, branchName, debug)
+}
+
+// SetTaskBaseBranch records the host branch the task will be merged into
+func (g *RealGitOps) SetTaskBaseBranch(baseBranch string) error {
+	return git.SetTaskBaseBranchInDir(g.workspaceDir, baseBranch)
+}
+
+// InstallProvenanceHook stamps every commit made in the container with trailers
+func (g *RealGitOps) InstallProvenanceHook(trailers []git.Trailer) error {
+	return git.InstallProvenanceHookInDir(g.cloneDir, trailers)
+}
+
+// ChangedFiles lists the files that differ between fromRef and HEAD
+func (g *RealGitOps) ChangedFiles(fromRef string) ([]string, error) {
+	return g.repo.ChangedFiles(fromRef)
+}
+
+// ChangedFilesBetween lists the files that differ between fromRef and toRef
+func (g *RealGitOps) ChangedFilesBetween(fromRef, toRef string) ([]string, error) {
+	return g.repo.ChangedFilesBetween(fromRef, toRef)
+}
+
+// DiffStats returns per-file change statistics between fromRef and HEAD
+func (g *RealGitOps) DiffStats(fromRef string) ([]git.FileStat, error) {
+	return g.repo.DiffStats(fromRef, "HEAD")
+}
+
+// NoiseFiles lists the files modified since fromRef whose only changes are to
+// whitespace, line endings or the file mode
+func (g *RealGitOps) NoiseFiles(fromRef string) ([]string, error) {
+	return g.repo.NoiseFiles(fromRef, "HEAD")
+}
+
+// IgnoredFiles returns the paths that .gitignore says should not be committed
+func (g *RealGitOps) IgnoredFiles(paths []string) ([]string, error) {
+	return g.repo.IgnoredFiles(paths)
+}
+
+// RunPreCommitHook runs the pre-commit hook on the changes between fromRef and toRef
+func (g *RealGitOps) RunPreCommitHook(fromRef, toRef string) (string, bool, error) {
+	return g.repo.RunPreCommitHook(fromRef, toRef)
+}
+
+// VerifyBranch runs each command in a temporary worktree of ref
+func (g *RealGitOps) VerifyBranch(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error) {
+	return g.repo.VerifyBranch(ref, commands, out)
+}
+
+// RunAtRef runs command in a temporary worktree of ref
+func (g *RealGitOps) RunAtRef(ref, command string) (string, error) {
+	return g.repo.RunAtRef(ref, command)
 }
 
-// SetupWorkspace sets up the workspace in /app
-func (g *RealGitOps) SetupWorkspace(branchName string, debug bool) error {
-	return git.SetupWorkspace(branchName, debug)
+// AddSummaryNote attaches the agent's summary to the tip of the branch
+func (g *RealGitOps) AddSummaryNote(branchName, summary string) error {
+	return g.repo.AddSummaryNote(branchName, summary)
+}
+
+// ReadSummaryNote returns the agent's summary of the branch, if any
+func (g *RealGitOps) ReadSummaryNote(branchName string) string {
+	return g.repo.SummaryNote(branchName)
 }
 
 // PushBranch pushes the branch to the git server
-func (g *RealGitOps) PushBranch(branchName string, gitPort int, debug bool) error {
-	return git.PushBranch(branchName, gitPort, debug)
+func (g *RealGitOps) PushBranch(branchName string, extraRefspecs []string, gitPort int, debug bool) error {
+	return git.PushBranchInDir(g.workspaceDir, branchName, extraRefspecs, gitPort, debug)
+}
+
+// ForcePushBranch pushes the branch to the git server, replacing it only if it is at expectedCommit
+func (g *RealGitOps) ForcePushBranch(branchName, expectedCommit string, extraRefspecs []string, gitPort int, debug bool) error {
+	return git.ForcePushBranchInDir(g.workspaceDir, branchName, expectedCommit, extraRefspecs, gitPort, debug)
+}
+
+// FetchWorkspace updates the remote-tracking branches behind the workspace
+func (g *RealGitOps) FetchWorkspace(debug bool) error {
+	return git.FetchWorkspaceInDir(g.workspaceDir, debug)
+}
+
+// SyncWorkspace rebases or merges a branch from the git server into the workspace
+func (g *RealGitOps) SyncWorkspace(branchName, baseBranch string, rebase bool, debug bool) error {
+	return git.SyncWorkspaceInDir(g.workspaceDir, branchName, baseBranch, rebase, debug)
+}
+
+// SnapshotWorkspace commits uncommitted work in the workspace without moving HEAD
+func (g *RealGitOps) SnapshotWorkspace(message string) (string, error) {
+	return git.SnapshotWorkspaceInDir(g.workspaceDir, message)
+}
+
+// WorkspaceState returns the workspace's HEAD and the tree of everything in it
+func (g *RealGitOps) WorkspaceState() (head, tree string, err error) {
+	return git.WorkspaceStateInDir(g.workspaceDir)
+}
+
+// CheckpointWorkspace records the workspace as a new commit on ref
+func (g *RealGitOps) CheckpointWorkspace(ref, message string) (string, error) {
+	return git.CheckpointWorkspaceInDir(g.workspaceDir, ref, message)
+}
+
+// PushRef force pushes commit to ref on the git server
+func (g *RealGitOps) PushRef(commit, ref string, gitPort int, debug bool) error {
+	return git.PushRefInDir(g.workspaceDir, commit, ref, gitPort, debug)
+}
+
+// BranchProblems returns what is wrong with pushing branchName from the workspace
+func (g *RealGitOps) BranchProblems(branchName, startCommit string) ([]string, error) {
+	return git.BranchProblemsInDir(g.workspaceDir, branchName, startCommit)
+}
+
+// RecordTaskStart records where giverny has put the task's START label
+func (g *RealGitOps) RecordTaskStart(commit string) error {
+	return git.RecordTaskStartInDir(g.workspaceDir, commit)
+}
+
+// TaskStart returns where giverny last put the task's START label
+func (g *RealGitOps) TaskStart() string {
+	return git.TaskStartInDir(g.workspaceDir)
+}
+
+// ExcludePath keeps git in the workspace from tracking files matching pattern
+func (g *RealGitOps) ExcludePath(pattern string) error {
+	return git.ExcludePathInDir(g.workspaceDir, pattern)
+}
+
+// MeasureCoverage runs a coverage command at ref in the workspace's repository
+func (g *RealGitOps) MeasureCoverage(ref, command string) (float64, string, error) {
+	return git.MeasureCoverageInDir(g.workspaceDir, ref, command)
 }
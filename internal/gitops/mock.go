@@ -1,20 +1,69 @@
 package gitops
 
-import "giverny/internal/git"
+import (
+	"fmt"
+	"io"
+
+	"giverny/internal/git"
+)
 
 // MockGitOps is a mock implementation of GitOps for testing
 type MockGitOps struct {
 	// Function stubs that can be set in tests
 	IsWorkspaceDirtyFunc       func() (bool, error)
+	StashChangesFunc           func(message string) (string, error)
+	MeasureRepoFunc            func() (git.RepoSize, error)
+	RestoreStashFunc           func(stash string) error
+	CurrentBranchFunc          func() (string, error)
+	ProtectedBranchesFunc      func() ([]string, error)
+	InProgressOperationFunc    func() (string, error)
 	BranchExistsFunc           func(branchName string) (bool, error)
-	CreateBranchFunc           func(branchName string) error
-	GetBranchCommitRangeFunc   func(branchName string) (firstCommit, lastCommit string, err error)
+	CreateBranchFunc           func(branchName, startPoint string) error
+	GetBranchCommitRangeFunc   func(branchName, baseBranch string) (firstCommit, lastCommit string, err error)
 	GetShortHashFunc           func(hash string) string
-	StartServerFunc            func(repoPath string) (*git.ServerCmd, int, error)
+	GetCommitHashFunc          func(ref string) (string, error)
+	MergeBaseFunc              func(ref1, ref2 string) (string, error)
+	DefaultBranchFunc          func() string
+	SquashBranchFunc           func(branchName, firstCommit, message string) (string, error)
+	FastForwardBranchFunc      func(targetBranch, branchName string) error
+	DeleteBranchFunc           func(branchName string) error
+	RemoteExistsFunc           func(remote string) (bool, error)
+	PushBranchToRemoteFunc     func(remote, branchName string) error
+	StartServerFunc            func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error)
+	StartServerOnPortFunc      func(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error)
 	StopServerFunc             func(serverCmd *git.ServerCmd) error
-	CloneRepoFunc              func(gitPort int, debug bool) error
-	SetupWorkspaceFunc         func(branchName string, debug bool) error
-	PushBranchFunc             func(branchName string, gitPort int, debug bool) error
+	CheckServerFunc            func(bindAddr string, port int, token string) error
+	ReclaimServerPortFunc      func(repoPath string, port int) (bool, error)
+	SuperviseServerFunc        func(serverCmd *git.ServerCmd, repoPath string, port int, logf func(format string, args ...any))
+	CloneRepoFunc              func(gitPort int, opts git.CloneOptions, debug bool) error
+	FetchRepoFunc              func(gitPort int, debug bool) error
+	SetupWorkspaceFunc         func(branchName, baseBranch string, sparsePaths []string, debug bool) error
+	UpdateWorkspaceFunc        func(branchName string, debug bool) error
+	SetTaskBaseBranchFunc      func(baseBranch string) error
+	InstallProvenanceHookFunc  func(trailers []git.Trailer) error
+	ChangedFilesFunc           func(fromRef string) ([]string, error)
+	ChangedFilesBetweenFunc    func(fromRef, toRef string) ([]string, error)
+	DiffStatsFunc              func(fromRef string) ([]git.FileStat, error)
+	NoiseFilesFunc             func(fromRef string) ([]string, error)
+	IgnoredFilesFunc           func(paths []string) ([]string, error)
+	RunPreCommitHookFunc       func(fromRef, toRef string) (string, bool, error)
+	VerifyBranchFunc           func(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error)
+	RunAtRefFunc               func(ref, command string) (string, error)
+	AddSummaryNoteFunc         func(branchName, summary string) error
+	ReadSummaryNoteFunc        func(branchName string) string
+	PushBranchFunc             func(branchName string, extraRefspecs []string, gitPort int, debug bool) error
+	ForcePushBranchFunc        func(branchName, expectedCommit string, extraRefspecs []string, gitPort int, debug bool) error
+	FetchWorkspaceFunc         func(debug bool) error
+	SyncWorkspaceFunc          func(branchName, baseBranch string, rebase bool, debug bool) error
+	SnapshotWorkspaceFunc      func(message string) (string, error)
+	WorkspaceStateFunc         func() (head, tree string, err error)
+	CheckpointWorkspaceFunc    func(ref, message string) (string, error)
+	PushRefFunc                func(commit, ref string, gitPort int, debug bool) error
+	BranchProblemsFunc         func(branchName, startCommit string) ([]string, error)
+	RecordTaskStartFunc        func(commit string) error
+	TaskStartFunc              func() string
+	ExcludePathFunc            func(pattern string) error
+	MeasureCoverageFunc        func(ref, command string) (float64, string, error)
 }
 
 // NewMockGitOps creates a new MockGitOps with default no-op implementations
@@ -23,33 +72,164 @@ func NewMockGitOps() *MockGitOps {
 		IsWorkspaceDirtyFunc: func() (bool, error) {
 			return false, nil
 		},
+		MeasureRepoFunc: func() (git.RepoSize, error) {
+			return git.RepoSize{}, nil
+		},
+		StashChangesFunc: func(message string) (string, error) {
+			return "", nil
+		},
+		RestoreStashFunc: func(stash string) error {
+			return nil
+		},
+		CurrentBranchFunc: func() (string, error) {
+			return "main", nil
+		},
+		ProtectedBranchesFunc: func() ([]string, error) {
+			return nil, nil
+		},
+		InProgressOperationFunc: func() (string, error) {
+			return "", nil
+		},
 		BranchExistsFunc: func(branchName string) (bool, error) {
 			return true, nil
 		},
-		CreateBranchFunc: func(branchName string) error {
+		CreateBranchFunc: func(branchName, startPoint string) error {
 			return nil
 		},
-		GetBranchCommitRangeFunc: func(branchName string) (firstCommit, lastCommit string, err error) {
+		GetBranchCommitRangeFunc: func(branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
 			return "", "", nil
 		},
 		GetShortHashFunc: func(hash string) string {
 			return hash[:7]
 		},
-		StartServerFunc: func(repoPath string) (*git.ServerCmd, int, error) {
+		GetCommitHashFunc: func(ref string) (string, error) {
+			return "", fmt.Errorf("unknown ref %s", ref)
+		},
+		MergeBaseFunc: func(ref1, ref2 string) (string, error) {
+			return "", fmt.Errorf("no merge base of %s and %s", ref1, ref2)
+		},
+		DefaultBranchFunc: func() string {
+			return "main"
+		},
+		SquashBranchFunc: func(branchName, firstCommit, message string) (string, error) {
+			return firstCommit, nil
+		},
+		FastForwardBranchFunc: func(targetBranch, branchName string) error {
+			return nil
+		},
+		DeleteBranchFunc: func(branchName string) error {
+			return nil
+		},
+		RemoteExistsFunc: func(remote string) (bool, error) {
+			return true, nil
+		},
+		PushBranchToRemoteFunc: func(remote, branchName string) error {
+			return nil
+		},
+		StartServerFunc: func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			return &git.ServerCmd{}, 9999, nil
 		},
+		StartServerOnPortFunc: func(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error) {
+			return &git.ServerCmd{}, nil
+		},
 		StopServerFunc: func(serverCmd *git.ServerCmd) error {
 			return nil
 		},
-		CloneRepoFunc: func(gitPort int, debug bool) error {
+		CheckServerFunc: func(bindAddr string, port int, token string) error {
+			return nil
+		},
+		ReclaimServerPortFunc: func(repoPath string, port int) (bool, error) {
+			return true, nil
+		},
+		SuperviseServerFunc: func(serverCmd *git.ServerCmd, repoPath string, port int, logf func(format string, args ...any)) {
+		},
+		CloneRepoFunc: func(gitPort int, opts git.CloneOptions, debug bool) error {
+			return nil
+		},
+		FetchRepoFunc: func(gitPort int, debug bool) error {
+			return nil
+		},
+		SetupWorkspaceFunc: func(branchName, baseBranch string, sparsePaths []string, debug bool) error {
+			return nil
+		},
+		UpdateWorkspaceFunc: func(branchName string, debug bool) error {
+			return nil
+		},
+		SetTaskBaseBranchFunc: func(baseBranch string) error {
+			return nil
+		},
+		InstallProvenanceHookFunc: func(trailers []git.Trailer) error {
+			return nil
+		},
+		ChangedFilesFunc: func(fromRef string) ([]string, error) {
+			return nil, nil
+		},
+		ChangedFilesBetweenFunc: func(fromRef, toRef string) ([]string, error) {
+			return nil, nil
+		},
+		DiffStatsFunc: func(fromRef string) ([]git.FileStat, error) {
+			return nil, nil
+		},
+		NoiseFilesFunc: func(fromRef string) ([]string, error) {
+			return nil, nil
+		},
+		IgnoredFilesFunc: func(paths []string) ([]string, error) {
+			return nil, nil
+		},
+		RunPreCommitHookFunc: func(fromRef, toRef string) (string, bool, error) {
+			return "", false, git.ErrNoPreCommitHook
+		},
+		VerifyBranchFunc: func(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error) {
+			return nil, nil
+		},
+		RunAtRefFunc: func(ref, command string) (string, error) {
+			return "", nil
+		},
+		AddSummaryNoteFunc: func(branchName, summary string) error {
+			return nil
+		},
+		ReadSummaryNoteFunc: func(branchName string) string {
+			return ""
+		},
+		PushBranchFunc: func(branchName string, extraRefspecs []string, gitPort int, debug bool) error {
+			return nil
+		},
+		ForcePushBranchFunc: func(branchName, expectedCommit string, extraRefspecs []string, gitPort int, debug bool) error {
+			return nil
+		},
+		FetchWorkspaceFunc: func(debug bool) error {
+			return nil
+		},
+		SyncWorkspaceFunc: func(branchName, baseBranch string, rebase bool, debug bool) error {
+			return nil
+		},
+		SnapshotWorkspaceFunc: func(message string) (string, error) {
+			return "", nil
+		},
+		WorkspaceStateFunc: func() (head, tree string, err error) {
+			return "", "", nil
+		},
+		CheckpointWorkspaceFunc: func(ref, message string) (string, error) {
+			return "", nil
+		},
+		PushRefFunc: func(commit, ref string, gitPort int, debug bool) error {
 			return nil
 		},
-		SetupWorkspaceFunc: func(branchName string, debug bool) error {
+		BranchProblemsFunc: func(branchName, startCommit string) ([]string, error) {
+			return nil, nil
+		},
+		RecordTaskStartFunc: func(commit string) error {
 			return nil
 		},
-		PushBranchFunc: func(branchName string, gitPort int, debug bool) error {
+		TaskStartFunc: func() string {
+			return ""
+		},
+		ExcludePathFunc: func(pattern string) error {
 			return nil
 		},
+		MeasureCoverageFunc: func(ref, command string) (float64, string, error) {
+			return 0, "", nil
+		},
 	}
 }
 
@@ -58,19 +238,49 @@ func (m *MockGitOps) IsWorkspaceDirty() (bool, error) {
 	return m.IsWorkspaceDirtyFunc()
 }
 
+// MeasureRepo calls the mock function
+func (m *MockGitOps) MeasureRepo() (git.RepoSize, error) {
+	return m.MeasureRepoFunc()
+}
+
+// StashChanges calls the mock function
+func (m *MockGitOps) StashChanges(message string) (string, error) {
+	return m.StashChangesFunc(message)
+}
+
+// RestoreStash calls the mock function
+func (m *MockGitOps) RestoreStash(stash string) error {
+	return m.RestoreStashFunc(stash)
+}
+
+// CurrentBranch calls the mock function
+func (m *MockGitOps) CurrentBranch() (string, error) {
+	return m.CurrentBranchFunc()
+}
+
+// ProtectedBranches calls the mock function
+func (m *MockGitOps) ProtectedBranches() ([]string, error) {
+	return m.ProtectedBranchesFunc()
+}
+
+// InProgressOperation calls the mock function
+func (m *MockGitOps) InProgressOperation() (string, error) {
+	return m.InProgressOperationFunc()
+}
+
 // BranchExists calls the mock function
 func (m *MockGitOps) BranchExists(branchName string) (bool, error) {
 	return m.BranchExistsFunc(branchName)
 }
 
 // CreateBranch calls the mock function
-func (m *MockGitOps) CreateBranch(branchName string) error {
-	return m.CreateBranchFunc(branchName)
+func (m *MockGitOps) CreateBranch(branchName, startPoint string) error {
+	return m.CreateBranchFunc(branchName, startPoint)
 }
 
 // GetBranchCommitRange calls the mock function
-func (m *MockGitOps) GetBranchCommitRange(branchName string) (firstCommit, lastCommit string, err error) {
-	return m.GetBranchCommitRangeFunc(branchName)
+func (m *MockGitOps) GetBranchCommitRange(branchName, baseBranch string) (firstCommit, lastCommit string, err error) {
+	return m.GetBranchCommitRangeFunc(branchName, baseBranch)
 }
 
 // GetShortHash calls the mock function
@@ -78,9 +288,54 @@ func (m *MockGitOps) GetShortHash(hash string) string {
 	return m.GetShortHashFunc(hash)
 }
 
+// GetCommitHash calls the mock function
+func (m *MockGitOps) GetCommitHash(ref string) (string, error) {
+	return m.GetCommitHashFunc(ref)
+}
+
+// MergeBase calls the mock function
+func (m *MockGitOps) MergeBase(ref1, ref2 string) (string, error) {
+	return m.MergeBaseFunc(ref1, ref2)
+}
+
+// DefaultBranch calls the mock function
+func (m *MockGitOps) DefaultBranch() string {
+	return m.DefaultBranchFunc()
+}
+
+// SquashBranch calls the mock function
+func (m *MockGitOps) SquashBranch(branchName, firstCommit, message string) (string, error) {
+	return m.SquashBranchFunc(branchName, firstCommit, message)
+}
+
+// FastForwardBranch calls the mock function
+func (m *MockGitOps) FastForwardBranch(targetBranch, branchName string) error {
+	return m.FastForwardBranchFunc(targetBranch, branchName)
+}
+
+// DeleteBranch calls the mock function
+func (m *MockGitOps) DeleteBranch(branchName string) error {
+	return m.DeleteBranchFunc(branchName)
+}
+
+// RemoteExists calls the mock function
+func (m *MockGitOps) RemoteExists(remote string) (bool, error) {
+	return m.RemoteExistsFunc(remote)
+}
+
+// PushBranchToRemote calls the mock function
+func (m *MockGitOps) PushBranchToRemote(remote, branchName string) error {
+	return m.PushBranchToRemoteFunc(remote, branchName)
+}
+
 // StartServer calls the mock function
-func (m *MockGitOps) StartServer(repoPath string) (*git.ServerCmd, int, error) {
-	return m.StartServerFunc(repoPath)
+func (m *MockGitOps) StartServer(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+	return m.StartServerFunc(repoPath, bindAddr, token, branchName)
+}
+
+// StartServerOnPort calls the mock function
+func (m *MockGitOps) StartServerOnPort(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error) {
+	return m.StartServerOnPortFunc(repoPath, bindAddr, port, token, branchName)
 }
 
 // StopServer calls the mock function
@@ -88,17 +343,162 @@ func (m *MockGitOps) StopServer(serverCmd *git.ServerCmd) error {
 	return m.StopServerFunc(serverCmd)
 }
 
+// CheckServer calls the mock function
+func (m *MockGitOps) CheckServer(bindAddr string, port int, token string) error {
+	return m.CheckServerFunc(bindAddr, port, token)
+}
+
+// ReclaimServerPort calls the mock function
+func (m *MockGitOps) ReclaimServerPort(repoPath string, port int) (bool, error) {
+	return m.ReclaimServerPortFunc(repoPath, port)
+}
+
+// SuperviseServer calls the mock function
+func (m *MockGitOps) SuperviseServer(serverCmd *git.ServerCmd, repoPath string, port int, logf func(format string, args ...any)) {
+	m.SuperviseServerFunc(serverCmd, repoPath, port, logf)
+}
+
 // CloneRepo calls the mock function
-func (m *MockGitOps) CloneRepo(gitPort int, debug bool) error {
-	return m.CloneRepoFunc(gitPort, debug)
+func (m *MockGitOps) CloneRepo(gitPort int, opts git.CloneOptions, debug bool) error {
+	return m.CloneRepoFunc(gitPort, opts, debug)
+}
+
+// FetchRepo calls the mock function
+func (m *MockGitOps) FetchRepo(gitPort int, debug bool) error {
+	return m.FetchRepoFunc(gitPort, debug)
 }
 
 // SetupWorkspace calls the mock function
-func (m *MockGitOps) SetupWorkspace(branchName string, debug bool) error {
-	return m.SetupWorkspaceFunc(branchName, debug)
+func (m *MockGitOps) SetupWorkspace(branchName, baseBranch string, sparsePaths []string, debug bool) error {
+	return m.SetupWorkspaceFunc(branchName, baseBranch, sparsePaths, debug)
+}
+
+// UpdateWorkspace calls the mock function
+func (m *MockGitOps) UpdateWorkspace(branchName string, debug bool) error {
+	return m.UpdateWorkspaceFunc(branchName, debug)
+}
+
+// SetTaskBaseBranch calls the mock function
+func (m *MockGitOps) SetTaskBaseBranch(baseBranch string) error {
+	return m.SetTaskBaseBranchFunc(baseBranch)
+}
+
+// InstallProvenanceHook calls the mock function
+func (m *MockGitOps) InstallProvenanceHook(trailers []git.Trailer) error {
+	return m.InstallProvenanceHookFunc(trailers)
+}
+
+// ChangedFiles calls the mock function
+func (m *MockGitOps) ChangedFiles(fromRef string) ([]string, error) {
+	return m.ChangedFilesFunc(fromRef)
+}
+
+// ChangedFilesBetween calls the mock function
+func (m *MockGitOps) ChangedFilesBetween(fromRef, toRef string) ([]string, error) {
+	return m.ChangedFilesBetweenFunc(fromRef, toRef)
+}
+
+// DiffStats calls the mock function
+func (m *MockGitOps) DiffStats(fromRef string) ([]git.FileStat, error) {
+	return m.DiffStatsFunc(fromRef)
+}
+
+// NoiseFiles calls the mock function
+func (m *MockGitOps) NoiseFiles(fromRef string) ([]string, error) {
+	return m.NoiseFilesFunc(fromRef)
+}
+
+// IgnoredFiles calls the mock function
+func (m *MockGitOps) IgnoredFiles(paths []string) ([]string, error) {
+	return m.IgnoredFilesFunc(paths)
+}
+
+// RunPreCommitHook calls the mock function
+func (m *MockGitOps) RunPreCommitHook(fromRef, toRef string) (string, bool, error) {
+	return m.RunPreCommitHookFunc(fromRef, toRef)
+}
+
+// VerifyBranch calls the mock function
+func (m *MockGitOps) VerifyBranch(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error) {
+	return m.VerifyBranchFunc(ref, commands, out)
+}
+
+// RunAtRef calls the mock function
+func (m *MockGitOps) RunAtRef(ref, command string) (string, error) {
+	return m.RunAtRefFunc(ref, command)
+}
+
+// AddSummaryNote calls the mock function
+func (m *MockGitOps) AddSummaryNote(branchName, summary string) error {
+	return m.AddSummaryNoteFunc(branchName, summary)
+}
+
+// ReadSummaryNote calls the mock function
+func (m *MockGitOps) ReadSummaryNote(branchName string) string {
+	return m.ReadSummaryNoteFunc(branchName)
 }
 
 // PushBranch calls the mock function
-func (m *MockGitOps) PushBranch(branchName string, gitPort int, debug bool) error {
-	return m.PushBranchFunc(branchName, gitPort, debug)
+func (m *MockGitOps) PushBranch(branchName string, extraRefspecs []string, gitPort int, debug bool) error {
+	return m.PushBranchFunc(branchName, extraRefspecs, gitPort, debug)
+}
+
+// ForcePushBranch calls the mock function
+func (m *MockGitOps) ForcePushBranch(branchName, expectedCommit string, extraRefspecs []string, gitPort int, debug bool) error {
+	return m.ForcePushBranchFunc(branchName, expectedCommit, extraRefspecs, gitPort, debug)
+}
+
+// FetchWorkspace calls the mock function
+func (m *MockGitOps) FetchWorkspace(debug bool) error {
+	return m.FetchWorkspaceFunc(debug)
+}
+
+// SyncWorkspace calls the mock function
+func (m *MockGitOps) SyncWorkspace(branchName, baseBranch string, rebase bool, debug bool) error {
+	return m.SyncWorkspaceFunc(branchName, baseBranch, rebase, debug)
+}
+
+// SnapshotWorkspace calls the mock function
+func (m *MockGitOps) SnapshotWorkspace(message string) (string, error) {
+	return m.SnapshotWorkspaceFunc(message)
+}
+
+// WorkspaceState calls the mock function
+func (m *MockGitOps) WorkspaceState() (head, tree string, err error) {
+	return m.WorkspaceStateFunc()
+}
+
+// CheckpointWorkspace calls the mock function
+func (m *MockGitOps) CheckpointWorkspace(ref, message string) (string, error) {
+	return m.CheckpointWorkspaceFunc(ref, message)
+}
+
+// PushRef calls the mock function
+func (m *MockGitOps) PushRef(commit, ref string, gitPort int, debug bool) error {
+	return m.PushRefFunc(commit, ref, gitPort, debug)
+}
+
+// BranchProblems calls the mock function
+func (m *MockGitOps) BranchProblems(branchName, startCommit string) ([]string, error) {
+	return m.BranchProblemsFunc(branchName, startCommit)
+}
+
+// RecordTaskStart calls the mock function
+func (m *MockGitOps) RecordTaskStart(commit string) error {
+	return m.RecordTaskStartFunc(commit)
+}
+
+// TaskStart calls the mock function
+func (m *MockGitOps) TaskStart() string {
+	return m.TaskStartFunc()
+}
+
+// ExcludePath calls the mock function
+func (m *MockGitOps) ExcludePath(pattern string) error {
+	return m.ExcludePathFunc(pattern)
+}
+
+// MeasureCoverage calls the mock function
+func (m *MockGitOps) MeasureCoverage(ref, command string) (float64, string, error) {
+	return m.MeasureCoverageFunc(ref, command)
 }
@@ -0,0 +1,91 @@
+// Package hooks deals with the client-side git hook frameworks projects use,
+// whose hooks often need tools that are on the host but not in the container.
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Modes for handling a project's hooks in the container
+const (
+	// ModeInstall installs the project's hook framework during setup, so the
+	// agent's commits run the hooks
+	ModeInstall = "install"
+	// ModeSkip keeps the hooks from running in the container; they are run
+	// on the host against the task's changes instead
+	ModeSkip = "skip"
+)
+
+// Framework is a hook framework a project can use
+type Framework struct {
+	Name string
+	// Configs are the files or directories whose presence means the project
+	// uses the framework
+	Configs []string
+	// Install is the shell command that installs its hooks in the repository
+	Install string
+}
+
+// Frameworks are the hook frameworks giverny knows about
+var Frameworks = []Framework{
+	{
+		Name:    "pre-commit",
+		Configs: []string{".pre-commit-config.yaml"},
+		Install: "command -v pre-commit >/dev/null || pip install --quiet pre-commit || pip3 install --quiet --break-system-packages pre-commit; pre-commit install --install-hooks",
+	},
+	{
+		Name:    "husky",
+		Configs: []string{".husky"},
+		Install: "npx --yes husky || npx --yes husky install",
+	},
+	{
+		Name:    "lefthook",
+		Configs: []string{"lefthook.yml", ".lefthook.yml", "lefthook.yaml", ".lefthook.yaml"},
+		Install: "npx --yes lefthook install",
+	},
+}
+
+// DisableEnv are environment variables that stop the frameworks from
+// installing or running hooks
+var DisableEnv = map[string]string{"HUSKY": "0", "LEFTHOOK": "0"}
+
+// Detect returns the hook frameworks the project in dir uses
+func Detect(dir string) []Framework {
+	var found []Framework
+	for _, framework := range Frameworks {
+		for _, config := range framework.Configs {
+			if _, err := os.Stat(filepath.Join(dir, config)); err == nil {
+				found = append(found, framework)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Names returns the names of frameworks, comma-separated
+func Names(frameworks []Framework) string {
+	var names []string
+	for _, framework := range frameworks {
+		names = append(names, framework.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Install installs the framework's hooks in the repository at dir
+func Install(dir string, framework Framework, debug bool) error {
+	cmd := exec.Command("sh", "-c", framework.Install)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if debug {
+		os.Stdout.Write(output)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to install %s hooks: %w\n%s", framework.Name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-hooks-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if found := Detect(tmpDir); len(found) != 0 {
+		t.Errorf("expected no frameworks, got %s", Names(found))
+	}
+
+	if err := os.Mkdir(filepath.Join(tmpDir, ".husky"), 0755); err != nil {
+		t.Fatalf("failed to create .husky: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, ".pre-commit-config.yaml"), []byte("repos: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write pre-commit config: %v", err)
+	}
+	if got := Names(Detect(tmpDir)); got != "pre-commit, husky" {
+		t.Errorf("Detect() = %q, want %q", got, "pre-commit, husky")
+	}
+}
@@ -0,0 +1,55 @@
+package innie
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"giverny/internal/docker"
+	gitpkg "giverny/internal/git"
+)
+
+// systemPromptFlag is Claude Code's flag for adding to its system prompt
+const systemPromptFlag = "--append-system-prompt"
+
+// agentContext describes the sandbox to the agent: where the repository is,
+// that its work must be committed to the task branch, and which of the
+// image's optional tools it can use
+func agentContext(workspaceDir string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are working in a giverny sandbox: a Docker container with a clone of the user's repository in %s.", workspaceDir)
+	if branch := os.Getenv(docker.BranchEnv); branch != "" {
+		fmt.Fprintf(&b, " The task branch %s is checked out, and `git diff %s` shows the changes made for the task so far.", branch, gitpkg.StartLabel(branch))
+	}
+	b.WriteString(" When you are done, giverny pushes the task branch to the user's machine; nothing else leaves the container.\n")
+	b.WriteString("- Commit your work to the task branch. Uncommitted changes and other branches are not brought back.\n")
+	b.WriteString("- Don't switch branches, push, or rewrite the commits the branch started from; giverny pushes for you.\n")
+	if _, err := exec.LookPath("br"); err == nil {
+		b.WriteString("- `br` (beads) is installed for tracking issues, if the repository uses it.\n")
+	}
+	if _, err := exec.LookPath("diffreviewer"); err == nil {
+		b.WriteString("- `diffreviewer` is installed for the user to review your changes in their browser; don't run it yourself.\n")
+	}
+	return b.String()
+}
+
+// withSystemPrompt returns args with text added to Claude Code's system
+// prompt, ahead of any the user added with the same flag, so that theirs has
+// the last word
+func withSystemPrompt(args []string, text string) []string {
+	for i, arg := range args {
+		if arg == systemPromptFlag && i+1 < len(args) {
+			merged := slices.Clone(args)
+			merged[i+1] = text + "\n" + args[i+1]
+			return merged
+		}
+		if value, ok := strings.CutPrefix(arg, systemPromptFlag+"="); ok {
+			merged := slices.Clone(args)
+			merged[i] = systemPromptFlag + "=" + text + "\n" + value
+			return merged
+		}
+	}
+	return append([]string{systemPromptFlag, text}, args...)
+}
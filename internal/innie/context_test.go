@@ -0,0 +1,53 @@
+package innie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "no flag",
+			args: []string{"--model", "opus"},
+			want: []string{systemPromptFlag, "sandbox", "--model", "opus"},
+		},
+		{
+			name: "no args",
+			args: nil,
+			want: []string{systemPromptFlag, "sandbox"},
+		},
+		{
+			name: "flag and value",
+			args: []string{"--model", "opus", systemPromptFlag, "Be brief."},
+			want: []string{"--model", "opus", systemPromptFlag, "sandbox\nBe brief."},
+		},
+		{
+			name: "flag=value",
+			args: []string{systemPromptFlag + "=Be brief.", "--model", "opus"},
+			want: []string{systemPromptFlag + "=sandbox\nBe brief.", "--model", "opus"},
+		},
+		{
+			name: "flag last without a value",
+			args: []string{"--model", "opus", systemPromptFlag},
+			want: []string{systemPromptFlag, "sandbox", "--model", "opus", systemPromptFlag},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string(nil), tt.args...)
+			got := withSystemPrompt(args, "sandbox")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withSystemPrompt(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("withSystemPrompt changed its args to %q", args)
+			}
+		})
+	}
+}
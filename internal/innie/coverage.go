@@ -0,0 +1,76 @@
+package innie
+
+import (
+	"fmt"
+	"os"
+
+	gitpkg "giverny/internal/git"
+	"giverny/internal/gitops"
+	"giverny/internal/interactive"
+)
+
+// checkCoverage runs the coverage command at the task's start and at HEAD and
+// reports the change in coverage with the diffstat. It returns true if the
+// push should not go ahead yet because coverage dropped by more than
+// config.MaxCoverageDrop, after letting the user have the agent add tests or
+// return to the menu. Coverage that can't be measured is only warned about.
+// The start's coverage is kept in startCoverage between rounds of checks,
+// negative until it is measured or after START moves.
+func checkCoverage(config Config, git gitops.GitOps, branchName string, startCoverage *float64, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	if config.CoverageCmd == "" {
+		return false, nil
+	}
+
+	start := gitpkg.StartLabel(branchName)
+	stats, err := git.DiffStats(start)
+	if err != nil {
+		return false, err
+	}
+	if len(stats) == 0 {
+		return false, nil
+	}
+
+	fmt.Printf("Measuring test coverage...\n")
+	if *startCoverage < 0 {
+		coverage, output, err := git.MeasureCoverage(start, config.CoverageCmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to measure coverage: %v\n%s", err, lastLines(output, 20))
+			return false, nil
+		}
+		*startCoverage = coverage
+	}
+	coverage, output, err := git.MeasureCoverage("HEAD", config.CoverageCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to measure coverage: %v\n%s", err, lastLines(output, 20))
+		return false, nil
+	}
+
+	added, deleted := 0, 0
+	for _, stat := range stats {
+		added += stat.Added
+		deleted += stat.Deleted
+	}
+	delta := coverage - *startCoverage
+	report := fmt.Sprintf("Coverage %.1f%% → %.1f%% (%+.1f), %d files changed, +%d -%d", *startCoverage, coverage, delta, len(stats), added, deleted)
+	fmt.Printf("%s\n", report)
+	if config.MaxCoverageDrop <= 0 || -delta <= config.MaxCoverageDrop {
+		return false, nil
+	}
+
+	if config.NonInteractive {
+		return false, fmt.Errorf("the task branch lowers coverage by %.1f points, more than the %.1f allowed", -delta, config.MaxCoverageDrop)
+	}
+	switch interactive.CoverageDropPrompt(report, nil) {
+	case interactive.PolicyRevert:
+		prompt := fmt.Sprintf("Your changes lower test coverage from %.1f%% to %.1f%%, as measured by `%s`. "+
+			"Add tests for the code you added or changed, and commit them.", *startCoverage, coverage, config.CoverageCmd)
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.PolicyOverride:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
@@ -0,0 +1,62 @@
+package innie
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"giverny/internal/ctrlsock"
+	gitpkg "giverny/internal/git"
+)
+
+// credentialHelper is the git credential helper command that asks outie for
+// credentials, see CredentialHelper
+const credentialHelper = "!giverny --git-credential-helper"
+
+// setupCredentials has git in the container get the HTTPS credentials of
+// hosts from outie, and has Go fetch modules on them directly, since the
+// public module proxy and checksum database can't see private repositories.
+func setupCredentials(hosts []string) error {
+	for _, host := range hosts {
+		if err := gitpkg.ConfigureCredentialHelper(host, credentialHelper); err != nil {
+			return err
+		}
+	}
+	private := hosts
+	if existing := os.Getenv("GOPRIVATE"); existing != "" {
+		private = append([]string{existing}, hosts...)
+	}
+	os.Setenv("GOPRIVATE", strings.Join(private, ","))
+	return nil
+}
+
+// CredentialHelper implements a git credential helper operation: for "get"
+// it reads git's request from in and writes the credential outie gives for
+// the host to out, asking with the task's token so outie knows the request
+// comes from its container. Credentials are only ever held in memory, so "store" and
+// "erase" do nothing.
+func CredentialHelper(operation string, in io.Reader, out io.Writer) error {
+	if operation != "get" {
+		return nil
+	}
+	fields, err := gitpkg.ParseCredentialFields(in)
+	if err != nil {
+		return err
+	}
+	if fields["protocol"] != "https" || fields["host"] == "" {
+		return nil
+	}
+	addr := ctrlsock.ContainerAddr()
+	if addr == "" {
+		return fmt.Errorf("%s environment variable is not set", ctrlsock.EnvVar)
+	}
+	// The git server's token is the task's token
+	token := gitpkg.ServerToken(os.Getenv(gitpkg.ServerURLEnv))
+	credential, err := ctrlsock.RequestCredential(addr, token, fields["host"])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, credential)
+	return err
+}
@@ -0,0 +1,67 @@
+package innie
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+)
+
+// healthCheck is the outcome of one of the task's --check commands
+type healthCheck struct {
+	Command  string
+	Passed   bool
+	Duration time.Duration
+	Output   string
+}
+
+// runHealthChecks runs each of the task's check commands through sh in the
+// workspace, uncommitted changes included, and prints whether each passed
+// as a table, followed by the end of the output of those that failed. A
+// failed check doesn't stop anything; it is there for the user to see before
+// deciding what to do at the menu.
+func runHealthChecks(config Config) {
+	if len(config.Checks) == 0 {
+		return
+	}
+
+	fmt.Printf("\nRunning checks...\n")
+	var results []healthCheck
+	for _, command := range config.Checks {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = config.WorkspaceDir
+		started := time.Now()
+		output, err := cmd.CombinedOutput()
+		result := healthCheck{Command: command, Passed: err == nil, Duration: time.Since(started), Output: string(output)}
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				result.Output = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		mark := "✓"
+		if !result.Passed {
+			mark = "✗"
+			failed++
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", mark, result.Command, result.Duration.Round(100*time.Millisecond))
+	}
+	tw.Flush()
+
+	for _, result := range results {
+		if !result.Passed {
+			fmt.Printf("\n$ %s\n%s\n", result.Command, lastLines(result.Output, 20))
+		}
+	}
+	if failed == 0 {
+		fmt.Printf("\n✓ All %d checks passed\n", len(results))
+	} else {
+		fmt.Printf("\n⚠️  %d of %d checks failed\n", failed, len(results))
+	}
+}
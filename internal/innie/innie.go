@@ -1,33 +1,267 @@
 package innie
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"giverny/internal/audit"
+	"giverny/internal/cmdutil"
+	"giverny/internal/compliance"
+	"giverny/internal/ctrlsock"
+	"giverny/internal/docker"
+	"giverny/internal/extrafiles"
+	gitpkg "giverny/internal/git"
 	"giverny/internal/gitops"
+	"giverny/internal/hooks"
 	"giverny/internal/interactive"
+	"giverny/internal/mise"
+	"giverny/internal/nix"
+	"giverny/internal/policy"
+	"giverny/internal/secrets"
+	"giverny/internal/shell"
+	"giverny/internal/status"
+	"giverny/internal/taskid"
+	"giverny/internal/taskspec"
 )
 
 // Config holds the configuration for the Innie
 type Config struct {
 	TaskID        string
 	Slug          string
+	BranchUser    string // the user the task branch is under, for --user-branch
 	Prompt        string
 	GitServerPort int
 	AgentArgs     string
+	BaseBranch    string
+	TargetBranch  string
+	CloneDepth    int
+	CloneFilter   string
+	SparsePaths   []string
+	PushRefspecs  []string
 	Debug         bool
 	UseAmp        bool
+
+	// WorkspaceDir is where the task branch is checked out for the agent
+	// (default /app), as a worktree of the clone in CloneDir (default /git)
+	WorkspaceDir string
+	CloneDir     string
+
+	// Policy lists files the agent must not modify
+	Policy policy.Policy
+	// Limits caps the size of the changes pushed back
+	Limits policy.Limits
+	// ComplianceCmd is run in the workspace with the changed files as arguments before pushing
+	ComplianceCmd string
+	// RequireSPDX checks that changed source files have an SPDX license header
+	RequireSPDX bool
+	// Summary has the agent summarize its changes in a note on the branch tip
+	Summary bool
+	// SkipSecretScan pushes without checking the commits for credentials
+	SkipSecretScan bool
+	// PlanFirst has the agent plan the task in print mode, for the user to
+	// approve, before it starts on it with the plan
+	PlanFirst bool
+	// Split has the agent split the task into sub-tasks, each then run in a
+	// print mode session of its own and committed before the next
+	Split bool
+	// TDD has the agent write failing tests for the task first, for the user
+	// to approve, and then implement it until TestCmd passes
+	TDD bool
+	// TestCmd is run in the workspace through sh before pushing, and between
+	// the phases of a TDD task
+	TestCmd string
+	// CoverageCmd is run at the task's start and at HEAD before pushing, to
+	// report the change in the coverage it prints last
+	CoverageCmd string
+	// MaxCoverageDrop blocks the push if coverage drops by more than this
+	// many percentage points; 0 only reports it
+	MaxCoverageDrop float64
+	// Checks are commands run in the workspace before each visit to the
+	// menu, whose results are shown as a table
+	Checks []string
+	// ReviewRounds is how many times a second agent, run with ReviewerArgs
+	// (AgentArgs if empty), reviews the changes after the agent's first run,
+	// with the agent addressing its notes each time
+	ReviewRounds int
+	ReviewerArgs string
+
+	// Audit logs every command the agent runs through bash to audit.LogPath
+	Audit bool
+	// ScanPaths are directories outside the workspace, such as /tmp, searched
+	// for files the agent created before pushing, to be copied into the
+	// workspace or exported to the host
+	ScanPaths []string
+
+	// NonInteractive runs the agent in print mode and pushes without the menu.
+	// Anything that would need the user, such as a failed check, fails the task.
+	NonInteractive bool
+
+	// SaveWIP pushes any uncommitted work to gitpkg.WIPRef when innie exits,
+	// however it exits
+	SaveWIP bool
+	// CheckpointInterval records the workspace on gitpkg.CheckpointsRef this
+	// often while the task runs, and pushes the checkpoints when innie exits
+	CheckpointInterval time.Duration
+	// LiveDiff pushes each checkpoint as it is made, so outie can follow progress
+	LiveDiff bool
+
+	// AutoCRLF and FileMode set core.autocrlf and core.fileMode in the clone
+	AutoCRLF string
+	FileMode string
+
+	// Hooks is hooks.ModeInstall to install the project's git hook framework,
+	// hooks.ModeSkip to keep its hooks from running, or empty to leave them be
+	Hooks string
+
+	// Nix runs the agent, and everything started from the menu, in the
+	// environment of the project's flake dev shell
+	Nix bool
+	// Mise installs the runtimes the project pins with mise and puts them
+	// first on the PATH
+	Mise bool
+	// EnableDocker is docker.DockerModeDinD to start a Docker daemon in the
+	// container before the agent runs
+	EnableDocker string
+	// GitCredentials are hosts whose HTTPS git credentials git in the
+	// container gets from outie
+	GitCredentials []string
+
+	// Provenance stamps every commit with trailers describing how it was made
+	Provenance      bool
+	Version         string
+	BaseImageDigest string
+
+	// HostUser is the "UID:GID" the files root creates in the agent's
+	// configuration mounted from the host are given back to when innie exits
+	HostUser string
+}
+
+// ConfigFromSpec returns the configuration for running the task outie
+// described in spec
+func ConfigFromSpec(spec taskspec.Spec) (Config, error) {
+	config := Config{
+		TaskID:        spec.TaskID,
+		Slug:          spec.Slug,
+		BranchUser:    spec.BranchUser,
+		Prompt:        spec.Prompt,
+		GitServerPort: spec.GitServerPort,
+		AgentArgs:     spec.AgentArgs,
+		BaseBranch:    spec.BaseBranch,
+		TargetBranch:  spec.TargetBranch,
+		CloneDepth:    spec.CloneDepth,
+		CloneFilter:   spec.CloneFilter,
+		SparsePaths:   spec.SparsePaths,
+		PushRefspecs:  spec.PushRefspecs,
+		Debug:         spec.Debug,
+		UseAmp:        spec.UseAmp,
+
+		Policy:          policy.Policy{Deny: spec.DenyPaths, Strict: spec.StrictPolicy},
+		Limits:          policy.Limits{MaxFiles: spec.MaxFiles, MaxLines: spec.MaxLines, MaxBinaryKB: spec.MaxBinaryKB},
+		ComplianceCmd:   spec.ComplianceCmd,
+		RequireSPDX:     spec.RequireSPDX,
+		Summary:         spec.Summary,
+		SkipSecretScan:  spec.NoSecretScan,
+		PlanFirst:       spec.PlanFirst,
+		Split:           spec.Split,
+		TDD:             spec.TDD,
+		TestCmd:         spec.TestCmd,
+		CoverageCmd:     spec.CoverageCmd,
+		MaxCoverageDrop: spec.CoverageDrop,
+		Checks:          spec.Checks,
+		ReviewRounds:    spec.ReviewRounds,
+		ReviewerArgs:    spec.ReviewerArgs,
+		Audit:           spec.Audit,
+		ScanPaths:       spec.ScanPaths,
+		NonInteractive:  spec.NonInteractive,
+		SaveWIP:         spec.SaveWIP,
+		LiveDiff:        spec.LiveDiff,
+		AutoCRLF:        spec.AutoCRLF,
+		FileMode:        spec.FileMode,
+		Hooks:           spec.Hooks,
+		Nix:             spec.Nix,
+		Mise:            spec.Mise,
+		EnableDocker:    spec.EnableDocker,
+		GitCredentials:  spec.GitCredentials,
+		Provenance:      spec.Provenance,
+		BaseImageDigest: spec.BaseImageDigest,
+		HostUser:        spec.HostUser,
+	}
+	if spec.GitServerPort == 0 {
+		return Config{}, fmt.Errorf("task spec has no git server port")
+	}
+	if spec.CheckpointInterval != "" {
+		interval, err := time.ParseDuration(spec.CheckpointInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid checkpoint interval in task spec: %w", err)
+		}
+		config.CheckpointInterval = interval
+	}
+	return config, nil
 }
 
 // Run executes the Innie workflow
 func Run(config Config) error {
-	return RunWithDeps(config, gitops.NewRealGitOps())
+	config = withDefaultDirs(config)
+	// Outie may have moved the git server since the container was started
+	config.GitServerPort = gitpkg.ServerPort(config.GitServerPort)
+	writePromptFile(config.Prompt)
+	return RunWithDeps(config, gitops.NewRealGitOpsWithDirs(config.WorkspaceDir, config.CloneDir))
+}
+
+// writePromptFile puts the prompt where docker.PromptFileEnv says it is, for
+// hooks, setup scripts and shells, unless outie mounted it there already
+func writePromptFile(prompt string) {
+	if data, err := os.ReadFile(taskspec.PromptFile); err == nil && string(data) == prompt {
+		return
+	}
+	if err := os.WriteFile(taskspec.PromptFile, []byte(prompt), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write the prompt to %s: %v\n", taskspec.PromptFile, err)
+	}
+}
+
+// withDefaultDirs fills in the default workspace and clone directories
+func withDefaultDirs(config Config) Config {
+	if config.WorkspaceDir == "" {
+		config.WorkspaceDir = gitpkg.DefaultWorkspaceDir
+	}
+	if config.CloneDir == "" {
+		config.CloneDir = gitpkg.DefaultCloneDir
+	}
+	return config
 }
 
 // RunWithDeps executes the Innie workflow with injected dependencies
 func RunWithDeps(config Config, git gitops.GitOps) error {
+	config = withDefaultDirs(config)
+
+	if err := taskid.Validate(config.TaskID); err != nil {
+		return fmt.Errorf("invalid TASK-ID: %w", err)
+	}
+
+	// Keep a status file up to date for `giverny watch`
+	reporter := status.Start(status.Path, status.HeartbeatInterval)
+	defer reporter.Stop()
+
+	// Clean up after the agent even if innie is stopped by a signal
+	var cleanups exitCleanups
+	defer cleanups.handleSignals()()
+
+	if config.HostUser != "" {
+		defer cleanups.add(func() { restoreOwnership(config.HostUser) })()
+	}
+
 	if config.Debug {
 		fmt.Printf("Running Innie for task: %s\n", config.TaskID)
 		fmt.Printf("Prompt: %s\n", config.Prompt)
@@ -35,77 +269,1004 @@ func RunWithDeps(config Config, git gitops.GitOps) error {
 		if config.UseAmp {
 			fmt.Printf("Agent: Amp\n")
 		}
+		if config.BaseBranch != "" {
+			fmt.Printf("Base branch: %s\n", config.BaseBranch)
+		}
 	}
 
-	// Clone the repository from Outie's git server
-	if config.Debug {
-		fmt.Printf("Cloning repository from git server...\n")
-	}
-	if err := git.CloneRepo(config.GitServerPort, config.Debug); err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
-	}
-	if config.Debug {
-		fmt.Printf("Repository cloned successfully to /git\n")
+	// Clone the repository from Outie's git server, or fetch into the existing
+	// clone if this container is being reused
+	if _, err := os.Stat(filepath.Join(config.CloneDir, ".git")); err == nil {
+		if config.Debug {
+			fmt.Printf("Fetching into existing clone in %s...\n", config.CloneDir)
+		}
+		if err := git.FetchRepo(config.GitServerPort, config.Debug); err != nil {
+			return fmt.Errorf("failed to fetch repository: %w", err)
+		}
+		if config.Debug {
+			fmt.Printf("Repository fetched successfully\n")
+		}
+	} else {
+		if config.Debug {
+			fmt.Printf("Cloning repository from git server...\n")
+		}
+		cloneOpts := gitpkg.CloneOptions{Depth: config.CloneDepth, Filter: config.CloneFilter, AutoCRLF: config.AutoCRLF, FileMode: config.FileMode}
+		if err := git.CloneRepo(config.GitServerPort, cloneOpts, config.Debug); err != nil {
+			return fmt.Errorf("failed to clone repository: %w", err)
+		}
+		if config.Debug {
+			fmt.Printf("Repository cloned successfully to %s\n", config.CloneDir)
+		}
 	}
 
-	// List /git directory contents to verify clone (debug mode only)
+	// List clone directory contents to verify clone (debug mode only)
 	if config.Debug {
-		fmt.Printf("\nContents of /git:\n")
-		cmd := exec.Command("ls", "-la", "/git")
+		fmt.Printf("\nContents of %s:\n", config.CloneDir)
+		cmd := exec.Command("ls", "-la", config.CloneDir)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to list /git directory: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Warning: failed to list %s directory: %v\n", config.CloneDir, err)
 		}
 	}
 
-	// Set up workspace in /app
-	var branchName string
-	if config.Slug != "" {
-		branchName = fmt.Sprintf("giverny/%s-%s", config.TaskID, config.Slug)
-	} else {
-		branchName = fmt.Sprintf("giverny/%s", config.TaskID)
-	}
-	if err := git.SetupWorkspace(branchName, config.Debug); err != nil {
+	// Set up the workspace
+	branchName := taskid.BranchName(config.BranchUser, config.TaskID, config.Slug)
+	if _, err := os.Stat(filepath.Join(config.WorkspaceDir, ".git")); err == nil {
+		// Reused container: keep the existing worktree and its START label
+		if err := git.UpdateWorkspace(branchName, config.Debug); err != nil {
+			return fmt.Errorf("failed to update workspace: %w", err)
+		}
+	} else if err := git.SetupWorkspace(branchName, config.BaseBranch, config.SparsePaths, config.Debug); err != nil {
 		return fmt.Errorf("failed to setup workspace: %w", err)
 	}
 
-	// Change to /app directory for all subsequent operations
-	if err := os.Chdir("/app"); err != nil {
-		return fmt.Errorf("failed to change to /app directory: %w", err)
+	// Install the project's hook framework, or keep its hooks from running
+	switch config.Hooks {
+	case hooks.ModeInstall:
+		for _, framework := range hooks.Detect(config.WorkspaceDir) {
+			if config.Debug {
+				fmt.Printf("Installing %s hooks...\n", framework.Name)
+			}
+			if err := hooks.Install(config.WorkspaceDir, framework, config.Debug); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	case hooks.ModeSkip:
+		for key, value := range hooks.DisableEnv {
+			os.Setenv(key, value)
+		}
+	}
+
+	// Take on the environment of the flake's dev shell
+	if config.Nix {
+		fmt.Printf("Entering the flake's dev shell...\n")
+		env, err := nix.DevEnv(config.WorkspaceDir, config.Debug)
+		if err != nil {
+			return err
+		}
+		for key, value := range env {
+			os.Setenv(key, value)
+		}
+	}
+
+	// Install the project's pinned runtimes
+	if config.Mise {
+		fmt.Printf("Installing the project's runtimes with mise...\n")
+		if err := mise.Install(config.WorkspaceDir, config.Debug); err != nil {
+			return err
+		}
+		env, err := mise.Env(config.WorkspaceDir)
+		if err != nil {
+			return err
+		}
+		for key, value := range env {
+			os.Setenv(key, value)
+		}
+	}
+
+	// Start the task's own Docker daemon
+	if config.EnableDocker == docker.DockerModeDinD {
+		fmt.Printf("Starting Docker...\n")
+		if err := docker.StartDaemon(config.Debug); err != nil {
+			return err
+		}
+	}
+
+	// Have git get credentials for private dependencies from outie
+	if len(config.GitCredentials) > 0 {
+		if err := setupCredentials(config.GitCredentials); err != nil {
+			return err
+		}
+	}
+
+	// Stamp commits made in the container with task provenance
+	if config.Provenance {
+		if err := git.InstallProvenanceHook(provenanceTrailers(config)); err != nil {
+			return err
+		}
+	}
+
+	// Remember which host branch to pull from when syncing mid-task
+	if config.TargetBranch != "" {
+		if err := git.SetTaskBaseBranch(config.TargetBranch); err != nil {
+			return err
+		}
+	}
+
+	// Change to the workspace for all subsequent operations
+	if err := os.Chdir(config.WorkspaceDir); err != nil {
+		return fmt.Errorf("failed to change to %s directory: %w", config.WorkspaceDir, err)
+	}
+
+	// Note where work on the branch started, to check the agent didn't
+	// rewrite the history before it. Syncing moves it, and records where.
+	startCommit, err := git.GetCommitHash(gitpkg.StartLabel(branchName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to find where the task branch starts: %v\n", err)
+	} else if err := git.RecordTaskStart(startCommit); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	// From here on the agent may leave work in the workspace
+	if config.SaveWIP {
+		defer cleanups.add(func() { saveWIP(config, git, branchName) })()
+	}
+	if config.CheckpointInterval > 0 {
+		defer cleanups.add(startCheckpointer(config, git, branchName))()
+	}
+
+	// Let menus started with `giverny menu` run the agent as this session does
+	if err := saveSession(session{WorkspaceDir: config.WorkspaceDir, Branch: branchName, Prompt: config.Prompt, AgentArgs: config.AgentArgs, UseAmp: config.UseAmp}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
-	// Execute agent with the prompt
-	if err := executeAgent(config.Prompt, config.AgentArgs, config.UseAmp, true); err != nil {
-		return fmt.Errorf("failed to execute agent: %w", err)
+	// Log the commands the agent runs, for compliance review on the host
+	if config.Audit {
+		if err := audit.Enable(); err != nil {
+			return err
+		}
+	}
+
+	// Files outside the workspace are looked for from when the agent starts,
+	// and exports go where outie expects them even if there are none
+	extraFiles := extraFilesScan{since: time.Now()}
+	if len(config.ScanPaths) > 0 {
+		if err := os.MkdirAll(extrafiles.ExportDir, 0755); err != nil {
+			return fmt.Errorf("failed to create export directory: %w", err)
+		}
+	}
+
+	// Execute agent with the prompt. If it crashes, the work it left in the
+	// workspace is still there, so go to the menu rather than exit. A
+	// non-interactive run has no menu to go to.
+	prompt := config.Prompt
+	if config.PlanFirst {
+		reporter.SetPhase(status.PhasePlan)
+		planned, err := planTask(config, git)
+		if err != nil {
+			if config.NonInteractive {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: starting the task without a plan: %v\n", err)
+		} else {
+			prompt = planned
+		}
+	}
+	var tests tddTests
+	if config.TDD {
+		reporter.SetPhase(status.PhaseAgent)
+		written, err := writeFailingTests(config, git, prompt, func(prompt string, isInteractive bool) error {
+			return executeAgent(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp, isInteractive)
+		})
+		if err != nil {
+			if config.NonInteractive {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: starting the task without tests written first: %v\n", err)
+		} else {
+			tests = written
+			prompt = implementPrompt(config, prompt, tests)
+		}
+	}
+	reporter.SetPhase(status.PhaseAgent)
+	var agentErr error
+	if config.Split {
+		agentErr = runSplit(config, git, prompt, func(prompt string, isInteractive bool) error {
+			return executeAgent(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp, isInteractive)
+		})
+	} else {
+		agentErr = executeAgent(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp, !config.NonInteractive)
+	}
+	if agentErr != nil {
+		if config.NonInteractive {
+			return agentErr
+		}
+		reportAgentFailure(config, agentErr)
 	}
 
-	// Post-agent menu loop
+	// Post-agent menu loop, then push the branch. If the push is rejected
+	// because the branch moved on the host, let the user decide what to do.
 	executeAgentWrapper := func(prompt string, isInteractive bool) error {
-		return executeAgent(prompt, config.AgentArgs, config.UseAmp, isInteractive)
+		phase := reporter.Phase()
+		reporter.SetPhase(status.PhaseAgent)
+		defer reporter.SetPhase(phase)
+		return executeAgent(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp, isInteractive)
+	}
+
+	// Have a second agent review the work, and the first address its notes,
+	// before the user sees it. What was done is kept if that goes wrong,
+	// but an unattended task doesn't push changes the reviewer made.
+	if config.ReviewRounds > 0 && agentErr == nil {
+		if err := crossReview(config, git, reporter, branchName, executeAgentWrapper); errors.Is(err, errReviewerChanged) && config.NonInteractive {
+			return fmt.Errorf("cross review failed: %w", err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cross review stopped: %v\n", err)
+		}
+	}
+
+	summaryAdded := false
+	startCoverage := -1.0
+	for {
+		runHealthChecks(config)
+		if config.NonInteractive {
+			if err := commitUnattended(git, executeAgentWrapper); err != nil {
+				return err
+			}
+		} else if err := showMenu(reporter, executeAgentWrapper, config.WorkspaceDir, branchName, config.Prompt); err != nil {
+			if errors.Is(err, errAgentFailed) {
+				reportAgentFailure(config, err)
+				continue
+			}
+			return fmt.Errorf("menu error: %w", err)
+		}
+
+		// START is where giverny last put it: a sync from the menu or with
+		// `giverny sync --rebase` moves it on, and the coverage there with it
+		if start := git.TaskStart(); start != "" && start != startCommit {
+			startCommit = start
+			startCoverage = -1
+		}
+
+		reporter.SetPhase(status.PhaseChecks)
+		blocked, err := checkExtraFiles(config, &extraFiles)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkBranch(config, git, branchName, startCommit, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkPolicy(config, git, branchName, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkLimits(config, git, branchName); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		warnNoisyDiff(git, branchName)
+		if blocked, err = checkArtifacts(config, git, branchName, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkCompliance(config, git, branchName, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkTests(config, git, tests, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if blocked, err = checkCoverage(config, git, branchName, &startCoverage, executeAgentWrapper); err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		if !config.SkipSecretScan {
+			if blocked, err = checkSecrets(config, branchName, executeAgentWrapper); err != nil {
+				return err
+			}
+			if blocked {
+				continue
+			}
+		}
+
+		if config.Summary {
+			if err := addSummary(config, git, branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write task summary: %v\n", err)
+			} else if !summaryAdded {
+				config.PushRefspecs = append(config.PushRefspecs[:len(config.PushRefspecs):len(config.PushRefspecs)], gitpkg.SummaryNotesRef(branchName))
+				summaryAdded = true
+			}
+		}
+
+		reporter.SetPhase(status.PhasePushing)
+		err = git.PushBranch(branchName, config.PushRefspecs, config.GitServerPort, config.Debug)
+		if errors.Is(err, gitpkg.ErrPushRejected) && !config.NonInteractive {
+			var pushed bool
+			pushed, err = resolvePushConflict(config, git, branchName)
+			if err == nil && !pushed {
+				continue
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to push branch: %w", err)
+		}
+		break
+	}
+
+	// Tell outie which commit was pushed so it can verify the ref arrived
+	if addr := ctrlsock.ContainerAddr(); addr != "" {
+		commit, err := git.GetCommitHash(branchName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to resolve pushed commit: %v\n", err)
+		} else if err := ctrlsock.Send(addr, fmt.Sprintf("PUSHED %s %s", branchName, commit)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to report push to outie: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// restoreOwnership gives the files the agent created as root in its
+// configuration mounted from the host back to the host user, so that they
+// can still be changed outside the container
+func restoreOwnership(hostUser string) {
+	for _, path := range docker.AgentConfigMounts {
+		if err := docker.ChownRootFiles(path, hostUser); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// exitCleanups runs cleanups when innie returns and also when it is stopped by
+// a signal (docker stop, or the terminal going away), which exits without
+// running deferred functions
+type exitCleanups struct {
+	mu       sync.Mutex
+	cleanups []func()
+}
+
+// add registers fn to run if innie is stopped by a signal and returns it,
+// run at most once, to be deferred for when innie returns
+func (c *exitCleanups) add(fn func()) func() {
+	var once sync.Once
+	run := func() { once.Do(fn) }
+	c.mu.Lock()
+	c.cleanups = append(c.cleanups, run)
+	c.mu.Unlock()
+	return run
+}
+
+// handleSignals runs the registered cleanups, the last registered first as
+// deferred functions would be, and exits if innie gets SIGTERM or SIGHUP
+// before the returned function is called
+func (c *exitCleanups) handleSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		c.mu.Lock()
+		cleanups := append([]func(){}, c.cleanups...)
+		c.mu.Unlock()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// saveWIP commits anything uncommitted in the workspace, without moving the
+// task branch, and pushes it to the WIP ref on the host.
+func saveWIP(config Config, git gitops.GitOps, branchName string) {
+	commit, err := git.SnapshotWorkspace(fmt.Sprintf("WIP on %s", branchName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save uncommitted changes: %v\n", err)
+		return
+	}
+	if commit == "" {
+		return
 	}
-	if err := interactive.PostClaudeMenu(executeAgentWrapper, nil); err != nil {
-		return fmt.Errorf("menu error: %w", err)
+	ref := gitpkg.WIPRef(branchName)
+	if err := git.PushRef(commit, ref, config.GitServerPort, config.Debug); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save uncommitted changes: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved uncommitted changes to %s\n", ref)
+}
+
+// startCheckpointer records a checkpoint of the workspace every
+// CheckpointInterval until the returned function is called, which stops it
+// and pushes the checkpoints to the host.
+func startCheckpointer(config Config, git gitops.GitOps, branchName string) func() {
+	ref := gitpkg.CheckpointsRef(branchName)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var last string
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(config.CheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				commit, err := git.CheckpointWorkspace(ref, "Checkpoint at "+now.UTC().Format(time.RFC3339))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to checkpoint workspace: %v\n", err)
+				} else if commit != "" {
+					last = commit
+					if config.LiveDiff {
+						if err := git.PushRef(commit, ref, config.GitServerPort, config.Debug); err != nil {
+							fmt.Fprintf(os.Stderr, "Warning: failed to push checkpoint: %v\n", err)
+						}
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		if last == "" {
+			return
+		}
+		if err := git.PushRef(last, ref, config.GitServerPort, config.Debug); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to push checkpoints: %v\n", err)
+			return
+		}
+		fmt.Printf("Checkpoints saved to %s\n", ref)
+	}
+}
+
+// maxExtraFiles is the most files outside the workspace offered at once
+const maxExtraFiles = 200
+
+// extraFilesScan is how far checkExtraFiles has got through the files the
+// agent created outside the workspace
+type extraFilesScan struct {
+	since   time.Time // files modified before this have been handled
+	handled []string  // files since then handled already, when there were too many to offer at once
+}
+
+// checkExtraFiles looks under ScanPaths for files the agent created outside
+// the workspace since scan.since and lets the user copy them into the
+// workspace, export them to the host or leave them. It returns true if the
+// push should not go ahead yet, so that copied files can be committed from
+// the menu. A non-interactive run exports them. Once handled, files are not
+// offered again unless they change; when there are too many to offer at
+// once, the rest are offered next.
+func checkExtraFiles(config Config, scan *extraFilesScan) (bool, error) {
+	if len(config.ScanPaths) == 0 {
+		return false, nil
+	}
+	skip := append([]string{config.WorkspaceDir, config.CloneDir, extrafiles.ExportDir, shell.CacheDir, shell.ProfileDir, filepath.Dir(status.Path), docker.SessionSocket}, docker.AgentConfigMounts...)
+	for {
+		scanned := time.Now()
+		files, truncated, err := extrafiles.Find(config.ScanPaths, scan.since, append(skip, scan.handled...), maxExtraFiles)
+		if err != nil {
+			return false, err
+		}
+		if len(files) == 0 {
+			return false, nil
+		}
+		if truncated {
+			fmt.Fprintf(os.Stderr, "Warning: the agent created more than %d files outside the workspace; they are offered %d at a time\n", maxExtraFiles, maxExtraFiles)
+		}
+		// Until a scan finds them all, leave since where it is and skip
+		// the files handled so far instead, so the rest are found next
+		handled := func() {
+			if truncated {
+				scan.handled = append(scan.handled, files...)
+			} else {
+				scan.since, scan.handled = scanned, nil
+			}
+		}
+
+		action := interactive.ExtraFilesExport
+		if !config.NonInteractive {
+			action = interactive.ExtraFilesPrompt(files, nil)
+		}
+		switch action {
+		case interactive.ExtraFilesCopy:
+			copied, skipped, err := extrafiles.CopyInto(files, config.ScanPaths, config.WorkspaceDir)
+			if err != nil {
+				return false, err
+			}
+			for _, file := range skipped {
+				fmt.Fprintf(os.Stderr, "Warning: not copying %s, which is already in the workspace\n", file)
+			}
+			fmt.Printf("Copied %d files into the workspace\n", len(copied))
+			handled()
+			return true, nil
+		case interactive.ExtraFilesExport:
+			if err := extrafiles.Export(files); err != nil {
+				return false, err
+			}
+			fmt.Printf("Exported %d files from outside the workspace; they are copied to the host when the container exits\n", len(files))
+		case interactive.ExtraFilesMenu:
+			return true, nil
+		}
+		handled()
+		if !truncated {
+			return false, nil
+		}
+	}
+}
+
+// checkBranch makes sure the agent left the task branch checked out, with no
+// operation such as a rebase unfinished, and still containing startCommit,
+// where work on it started, so that what is pushed is what the user expects.
+// If not, the user is asked how to proceed; it returns true if the push
+// should not go ahead yet.
+func checkBranch(config Config, git gitops.GitOps, branchName, startCommit string, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	problems, err := git.BranchProblems(branchName, startCommit)
+	if err != nil {
+		return false, err
+	}
+	if len(problems) == 0 {
+		return false, nil
+	}
+	if config.NonInteractive {
+		return false, fmt.Errorf("the task branch is not as giverny left it: %s", strings.Join(problems, "; "))
+	}
+
+	switch interactive.BranchProblemsPrompt(problems, nil) {
+	case interactive.PolicyRevert:
+		prompt := fmt.Sprintf("Your work must be committed on the branch %s, which must still contain commit %s, where work on it started, and must not rewrite the history before it. "+
+			"These problems were found: %s. Finish or abort any unfinished operation, check out %s, bring any commits you made elsewhere onto it, and do not push.",
+			branchName, startCommit, strings.Join(problems, "; "), branchName)
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.PolicyOverride:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// checkPolicy checks the task branch against the policy before it is pushed.
+// It returns true if the push should not go ahead yet, after letting the user
+// have the agent revert the forbidden changes or return to the menu.
+func checkPolicy(config Config, git gitops.GitOps, branchName string, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	if len(config.Policy.Deny) == 0 {
+		return false, nil
+	}
+
+	files, err := git.ChangedFiles(gitpkg.StartLabel(branchName))
+	if err != nil {
+		return false, err
+	}
+	violations := config.Policy.Violations(files)
+	if len(violations) == 0 {
+		return false, nil
+	}
+	if config.NonInteractive {
+		return false, fmt.Errorf("the task branch modifies files that are not allowed to change: %s", strings.Join(violations, ", "))
+	}
+
+	switch interactive.PolicyViolationPrompt(violations, config.Policy.Strict, nil) {
+	case interactive.PolicyRevert:
+		prompt := fmt.Sprintf("You are not allowed to modify these files: %s. Revert your changes to them and commit the result.", strings.Join(violations, ", "))
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.PolicyOverride:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// addSummary asks the agent to summarize the task branch and attaches the
+// summary as a note on its tip, for outie to print once the branch is pushed.
+func addSummary(config Config, git gitops.GitOps, branchName string) error {
+	prompt := fmt.Sprintf("Summarize the changes on this branch since %s (see git log and git diff %s..HEAD): what was changed and why. "+
+		"Reply with only the summary, as a few short Markdown bullet points. Do not modify any files.", gitpkg.StartLabel(branchName), gitpkg.StartLabel(branchName))
+	summary, err := captureAgentOutput(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp)
+	if err != nil {
+		return err
+	}
+	if summary == "" {
+		return fmt.Errorf("agent returned an empty summary")
+	}
+	return git.AddSummaryNote(branchName, summary)
+}
+
+// checkLimits checks the size of the task branch before it is pushed. It
+// returns true if the limits are exceeded and the user chose to return to the
+// menu rather than push anyway.
+func checkLimits(config Config, git gitops.GitOps, branchName string) (bool, error) {
+	if config.Limits.IsZero() {
+		return false, nil
+	}
+
+	stats, err := git.DiffStats(gitpkg.StartLabel(branchName))
+	if err != nil {
+		return false, err
+	}
+	problems := config.Limits.Check(stats)
+	if len(problems) == 0 {
+		return false, nil
+	}
+	if config.NonInteractive {
+		return false, fmt.Errorf("the task branch is larger than allowed: %s", strings.Join(problems, "; "))
+	}
+
+	return !interactive.LimitsExceededPrompt(problems, nil), nil
+}
+
+// warnNoisyDiff warns if most of the files the task branch changed only
+// change whitespace, line endings or file modes, which usually means the
+// container's git settings don't match the host's.
+func warnNoisyDiff(git gitops.GitOps, branchName string) {
+	files, err := git.ChangedFiles(gitpkg.StartLabel(branchName))
+	if err != nil || len(files) == 0 {
+		return
+	}
+	noise, err := git.NoiseFiles(gitpkg.StartLabel(branchName))
+	if err != nil || len(noise)*2 <= len(files) {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: %d of the %d files changed by this task only change whitespace, line endings or file modes:\n", len(noise), len(files))
+	for _, path := range noise {
+		fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+	fmt.Fprintf(os.Stderr, "If these changes are unintended, try running the task with --autocrlf input or --filemode false.\n")
+}
+
+// checkArtifacts looks for build artifacts committed on the task branch. It
+// returns true if the push should not go ahead yet, after letting the user
+// have the agent untrack them or return to the menu. A non-interactive run
+// only warns about them.
+func checkArtifacts(config Config, git gitops.GitOps, branchName string, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	stats, err := git.DiffStats(gitpkg.StartLabel(branchName))
+	if err != nil {
+		return false, err
+	}
+	var paths []string
+	for _, stat := range stats {
+		paths = append(paths, stat.Path)
+	}
+	ignored, err := git.IgnoredFiles(paths)
+	if err != nil {
+		return false, err
+	}
+	artifacts := policy.Artifacts(stats, ignored)
+	if len(artifacts) == 0 {
+		return false, nil
+	}
+
+	var descriptions, artifactPaths []string
+	for _, artifact := range artifacts {
+		descriptions = append(descriptions, artifact.String())
+		artifactPaths = append(artifactPaths, artifact.Path)
+	}
+	if config.NonInteractive {
+		fmt.Fprintf(os.Stderr, "Warning: the task branch commits files that look like build artifacts: %s\n", strings.Join(descriptions, ", "))
+		return false, nil
+	}
+
+	switch interactive.ArtifactsFoundPrompt(descriptions, nil) {
+	case interactive.PolicyRevert:
+		prompt := fmt.Sprintf("These files look like build artifacts and should not be committed: %s. "+
+			"Remove them from git with `git rm --cached`, keeping them on disk, add patterns for them to .gitignore if it does not already cover them, and commit the result.",
+			strings.Join(artifactPaths, ", "))
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.PolicyOverride:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// checkCompliance runs the SPDX header check and compliance command on the
+// files the task branch changed. It returns true if the push should not go
+// ahead yet, after letting the user have the agent fix the failures or return
+// to the menu.
+func checkCompliance(config Config, git gitops.GitOps, branchName string, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	if !config.RequireSPDX && config.ComplianceCmd == "" {
+		return false, nil
 	}
 
-	// Push branch and exit
-	if err := git.PushBranch(branchName, config.GitServerPort, config.Debug); err != nil {
-		return fmt.Errorf("failed to push branch: %w", err)
+	files, err := git.ChangedFiles(gitpkg.StartLabel(branchName))
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return false, nil
+	}
+
+	var failures []string
+	if config.RequireSPDX {
+		problems, err := compliance.CheckSPDX(config.WorkspaceDir, files)
+		if err != nil {
+			return false, err
+		}
+		failures = append(failures, problems...)
+	}
+	if config.ComplianceCmd != "" {
+		output, ok, err := compliance.RunCommand(config.WorkspaceDir, config.ComplianceCmd, files)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			failures = append(failures, strings.TrimRight(output, "\n"))
+		}
+	}
+	if len(failures) == 0 {
+		return false, nil
 	}
 
+	report := strings.Join(failures, "\n")
+	if config.NonInteractive {
+		return false, fmt.Errorf("the compliance check failed on the task branch:\n%s", report)
+	}
+	switch interactive.ComplianceFailedPrompt(report, nil) {
+	case interactive.PolicyRevert:
+		prompt := fmt.Sprintf("The project's compliance check failed on your changes:\n\n%s\n\nFix the problems and commit the result.", report)
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.PolicyOverride:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// checkSecrets scans the task branch's commits for credentials before they are
+// pushed. It returns true if any were found, after letting the user have the
+// agent remove them or return to the menu.
+func checkSecrets(config Config, branchName string, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	findings, err := secrets.Scan(gitpkg.StartLabel(branchName))
+	if err != nil {
+		return false, fmt.Errorf("secret scan failed: %w", err)
+	}
+	if len(findings) == 0 {
+		return false, nil
+	}
+
+	var descriptions []string
+	for _, finding := range findings {
+		descriptions = append(descriptions, finding.String())
+	}
+	if config.NonInteractive {
+		return false, fmt.Errorf("possible secrets found in the task branch: %s", strings.Join(descriptions, "; "))
+	}
+	if interactive.SecretsFoundPrompt(descriptions, nil) {
+		prompt := fmt.Sprintf("These possible secrets were committed: %s. Remove them from every commit since %s, rewriting the branch history if needed, so that they are not pushed.",
+			strings.Join(descriptions, "; "), gitpkg.StartLabel(branchName))
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	return true, nil
+}
+
+// resolvePushConflict asks the user how to push branchName after the git
+// server rejected it, and does so. It returns false without an error if the
+// user chose to go back to the menu.
+func resolvePushConflict(config Config, git gitops.GitOps, branchName string) (bool, error) {
+	for {
+		if err := git.FetchWorkspace(config.Debug); err != nil {
+			return false, err
+		}
+
+		var err error
+		switch interactive.PushConflictPrompt(config.WorkspaceDir, branchName, nil) {
+		case interactive.PushConflictRebase:
+			if err := git.SyncWorkspace(branchName, branchName, true, config.Debug); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			err = git.PushBranch(branchName, config.PushRefspecs, config.GitServerPort, config.Debug)
+		case interactive.PushConflictForce:
+			expected, hashErr := git.GetCommitHash("origin/" + branchName)
+			if hashErr != nil {
+				return false, hashErr
+			}
+			err = git.ForcePushBranch(branchName, expected, config.PushRefspecs, config.GitServerPort, config.Debug)
+		case interactive.PushConflictMenu:
+			return false, nil
+		case interactive.PushConflictAbort:
+			return false, gitpkg.ErrPushRejected
+		}
+
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, gitpkg.ErrPushRejected) {
+			return false, err
+		}
+		// The branch moved again on the host; fetch and ask again
+	}
+}
+
+// Sync brings new commits from the host's base branch into the task branch in
+// workspaceDir, for `giverny sync`. It rebases onto the base branch if rebase
+// is true and merges it otherwise.
+func Sync(workspaceDir, branchUser, taskID, slug string, rebase bool, debug bool) error {
+	baseBranch, err := gitpkg.TaskBaseBranchInDir(workspaceDir)
+	if err != nil {
+		return err
+	}
+	branchName := taskid.BranchName(branchUser, taskID, slug)
+	if err := gitpkg.SyncWorkspaceInDir(workspaceDir, branchName, baseBranch, rebase, debug); err != nil {
+		return err
+	}
+	fmt.Printf("✓ %s is up to date with %s\n", branchName, baseBranch)
 	return nil
 }
 
-// executeAgent runs the selected agent (Claude Code or Amp) with the given prompt in /app
-func executeAgent(prompt, agentArgs string, useAmp, interactive bool) error {
+// provenanceTrailers returns the commit trailers recording which giverny
+// version, task, prompt, agent and base image produced a commit.
+func provenanceTrailers(config Config) []gitpkg.Trailer {
+	promptHash := sha256.Sum256([]byte(config.Prompt))
+	agent := "claude-code"
+	if config.UseAmp {
+		agent = "amp"
+	}
+
+	trailers := []gitpkg.Trailer{
+		{Key: "Giverny-Version", Value: config.Version},
+		{Key: "Giverny-Task", Value: config.TaskID},
+		{Key: "Giverny-Prompt-SHA256", Value: hex.EncodeToString(promptHash[:])},
+		{Key: "Giverny-Agent", Value: agent},
+	}
+	if model := agentModel(config.AgentArgs); model != "" {
+		trailers = append(trailers, gitpkg.Trailer{Key: "Giverny-Model", Value: model})
+	}
+	if config.BaseImageDigest != "" {
+		trailers = append(trailers, gitpkg.Trailer{Key: "Giverny-Base-Image", Value: config.BaseImageDigest})
+	}
+	return trailers
+}
+
+// agentModel returns the model selected with --model in the agent args, or ""
+// if the agent's default model is used.
+func agentModel(agentArgs string) string {
+	fields, _ := cmdutil.SplitArgs(agentArgs)
+	for i, field := range fields {
+		if field == "--model" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+		if model, ok := strings.CutPrefix(field, "--model="); ok {
+			return model
+		}
+	}
+	return ""
+}
+
+// errAgentFailed is wrapped by the errors returned when the agent could not be
+// run or exited unsuccessfully.
+var errAgentFailed = errors.New("agent failed")
+
+// showMenu shows the post-agent menu, reporting that innie is waiting for
+// the user while it does.
+func showMenu(reporter *status.Reporter, executeAgent func(prompt string, interactive bool) error, workspaceDir, branchName, prompt string) error {
+	reporter.SetPhase(status.PhaseMenu)
+	return interactive.PostClaudeMenu(executeAgent, workspaceDir, branchName, prompt, nil)
+}
+
+// commitUnattended stands in for the menu in non-interactive runs: if the
+// agent left changes uncommitted, it asks the agent to commit them, as the
+// menu's [c] does.
+func commitUnattended(git gitops.GitOps, executeAgent func(prompt string, interactive bool) error) error {
+	dirty, err := git.IsWorkspaceDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check workspace status: %w", err)
+	}
+	if !dirty {
+		return nil
+	}
+	if err := executeAgent("Commit the changes", false); err != nil {
+		return err
+	}
+	if dirty, err = git.IsWorkspaceDirty(); err != nil {
+		return fmt.Errorf("failed to check workspace status: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("the agent left uncommitted changes in the workspace")
+	}
+	return nil
+}
+
+// reportAgentFailure tells the user the agent crashed and that they are being
+// returned to the menu with their work intact.
+func reportAgentFailure(config Config, err error) {
+	fmt.Fprintf(os.Stderr, "\n⚠️  %v\n", err)
+	fmt.Fprintf(os.Stderr, "Any changes in %s have been kept. From the menu you can start a shell, commit, or restart the agent.\n", config.WorkspaceDir)
+}
+
+// executeAgent runs the selected agent (Claude Code or Amp) with the given prompt in dir
+func executeAgent(dir, prompt, agentArgs string, useAmp, interactive bool) error {
 	if useAmp {
-		return executeAmp(prompt, agentArgs, interactive)
+		return executeAmp(dir, prompt, agentArgs, interactive, os.Stdout)
+	}
+	return executeClaude(dir, prompt, agentArgs, interactive, os.Stdout)
+}
+
+// captureAgentOutput runs the selected agent non-interactively with the given
+// prompt in dir and returns what it printed
+func captureAgentOutput(dir, prompt, agentArgs string, useAmp bool) (string, error) {
+	var output bytes.Buffer
+	var err error
+	if useAmp {
+		err = executeAmp(dir, prompt, agentArgs, false, &output)
+	} else {
+		err = executeClaude(dir, prompt, agentArgs, false, &output)
 	}
-	return executeClaude(prompt, agentArgs, interactive)
+	return strings.TrimSpace(output.String()), err
 }
 
-// executeClaude runs Claude Code with the given prompt in /app
-func executeClaude(prompt, agentArgs string, interactive bool) error {
+// maxPromptArg is the longest prompt given to the agent on its command line.
+// The kernel refuses a single argument over 128 KiB, so a longer one is
+// written to a file the agent is asked to read.
+const maxPromptArg = 64 << 10
+
+// agentPrompt returns what to give the agent as its prompt: prompt itself, or
+// if it is too long, a request to read it from a file, which the returned
+// function removes
+func agentPrompt(prompt string) (string, func(), error) {
+	if len(prompt) <= maxPromptArg {
+		return prompt, func() {}, nil
+	}
+	file, err := os.CreateTemp("", "giverny-prompt-*.md")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create prompt file: %w", err)
+	}
+	_, err = file.WriteString(prompt)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", nil, fmt.Errorf("failed to write prompt file: %w", err)
+	}
+	reference := fmt.Sprintf("Your task is too long to give here, so it is in %s. Read all of it, then carry it out.", file.Name())
+	return reference, func() { os.Remove(file.Name()) }, nil
+}
+
+// executeClaude runs Claude Code with the given prompt in dir, writing its
+// output to stdout
+func executeClaude(dir, prompt, agentArgs string, interactive bool, stdout io.Writer) error {
 	if interactive {
 		fmt.Printf("Executing Claude Code...\n")
 	} else {
@@ -117,31 +1278,38 @@ func executeClaude(prompt, agentArgs string, interactive bool) error {
 		args = append(args, "--print")
 	}
 
-	// Parse and add agent args if provided
-	if agentArgs != "" {
-		additionalArgs := strings.Fields(agentArgs)
-		args = append(args, additionalArgs...)
+	// Add any agent args, split as a shell would, with the sandbox described
+	// in the system prompt
+	additionalArgs, err := cmdutil.SplitArgs(agentArgs)
+	if err != nil {
+		return fmt.Errorf("invalid agent args: %w", err)
 	}
+	args = append(args, withSystemPrompt(additionalArgs, agentContext(dir))...)
 
+	prompt, removePrompt, err := agentPrompt(prompt)
+	if err != nil {
+		return err
+	}
+	defer removePrompt()
 	args = append(args, prompt)
 
 	cmd := exec.Command("claude", args...)
-	cmd.Dir = "/app"
-	cmd.Stdout = os.Stdout
+	cmd.Dir = dir
+	cmd.Stdout = stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 	cmd.Env = append(os.Environ(), "IS_SANDBOX=1")
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Claude exited with error: %w", err)
+		return fmt.Errorf("%w: Claude exited with error: %w", errAgentFailed, err)
 	}
 
 	fmt.Printf("Claude completed successfully\n")
 	return nil
 }
 
-// executeAmp runs Amp with the given prompt in /app
-func executeAmp(prompt, agentArgs string, interactive bool) error {
+// executeAmp runs Amp with the given prompt in dir, writing its output to stdout
+func executeAmp(dir, prompt, agentArgs string, interactive bool, stdout io.Writer) error {
 	if interactive {
 		fmt.Printf("Executing Amp...\n")
 	} else {
@@ -153,23 +1321,29 @@ func executeAmp(prompt, agentArgs string, interactive bool) error {
 		args = append(args, "-x")
 	}
 
-	// Parse and add agent args if provided
-	if agentArgs != "" {
-		additionalArgs := strings.Fields(agentArgs)
-		args = append(args, additionalArgs...)
+	// Add any agent args, split as a shell would
+	additionalArgs, err := cmdutil.SplitArgs(agentArgs)
+	if err != nil {
+		return fmt.Errorf("invalid agent args: %w", err)
 	}
+	args = append(args, additionalArgs...)
 
+	prompt, removePrompt, err := agentPrompt(prompt)
+	if err != nil {
+		return err
+	}
+	defer removePrompt()
 	args = append(args, prompt)
 
 	cmd := exec.Command("amp", args...)
-	cmd.Dir = "/app"
-	cmd.Stdout = os.Stdout
+	cmd.Dir = dir
+	cmd.Stdout = stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 	cmd.Env = append(os.Environ(), "IS_SANDBOX=1")
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Amp exited with error: %w", err)
+		return fmt.Errorf("%w: Amp exited with error: %w", errAgentFailed, err)
 	}
 
 	fmt.Printf("Amp completed successfully\n")
@@ -0,0 +1,60 @@
+package innie
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestAgentPrompt(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	line := "Refactor the parser so that every error carries its line and column.\n"
+
+	// A multi-KB prompt up to the limit is given as it is
+	short := strings.Repeat(line, maxPromptArg/len(line))
+	got, cleanup, err := agentPrompt(short)
+	if err != nil {
+		t.Fatalf("agentPrompt() error = %v", err)
+	}
+	cleanup()
+	if got != short {
+		t.Errorf("expected a prompt of %d bytes to be given as it is", len(short))
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmpDir, "giverny-prompt-*")); len(files) != 0 {
+		t.Errorf("expected no prompt file, got %v", files)
+	}
+
+	// A longer one is written to a file the agent is asked to read
+	long := strings.Repeat(line, maxPromptArg/len(line)+1)
+	got, cleanup, err = agentPrompt(long)
+	if err != nil {
+		t.Fatalf("agentPrompt() error = %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(tmpDir, "giverny-prompt-*.md"))
+	if len(files) != 1 {
+		t.Fatalf("expected one prompt file, got %v", files)
+	}
+	if !strings.Contains(got, files[0]) || len(got) > maxPromptArg {
+		t.Errorf("expected a short reference to %s, got %q", files[0], got)
+	}
+	if content, err := os.ReadFile(files[0]); err != nil || string(content) != long {
+		t.Errorf("expected the prompt file to hold the prompt (%d bytes), got %d bytes, %v", len(long), len(content), err)
+	}
+	cleanup()
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("expected the prompt file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package innie
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"giverny/internal/interactive"
+	"giverny/internal/status"
+)
+
+// sessionPath is where innie records how the task runs its agent, for menus
+// started with `giverny menu`
+const sessionPath = "/var/log/giverny/session.json"
+
+// session is what a menu needs to know about the task
+type session struct {
+	WorkspaceDir string `json:"workspace_dir"`
+	Branch       string `json:"branch"`
+	Prompt       string `json:"prompt"`
+	AgentArgs    string `json:"agent_args,omitempty"`
+	UseAmp       bool   `json:"use_amp,omitempty"`
+}
+
+func saveSession(s session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sessionPath), 0755); err != nil {
+		return fmt.Errorf("failed to record the session: %w", err)
+	}
+	if err := os.WriteFile(sessionPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to record the session: %w", err)
+	}
+	return nil
+}
+
+func loadSession() (*session, error) {
+	data, err := os.ReadFile(sessionPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no task session found; `giverny menu` runs in the container of a task that has started its agent")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sessionPath, err)
+	}
+	return &s, nil
+}
+
+// Menu shows the post-agent menu of the task running in this container, for
+// a user who entered it with docker exec rather than through the task's own
+// session. Pushing the branch to the host is left to that session, when its
+// menu is exited.
+func Menu() error {
+	s, err := loadSession()
+	if err != nil {
+		return err
+	}
+	if data, err := os.ReadFile(status.Path); err == nil {
+		if current, err := status.Parse(string(data)); err == nil && current.Phase == status.PhaseAgent {
+			fmt.Println("⚠️  The task's agent is running in its own session; changes made here will be seen by it.")
+		}
+	}
+	executeAgentWrapper := func(prompt string, isInteractive bool) error {
+		return executeAgent(s.WorkspaceDir, prompt, s.AgentArgs, s.UseAmp, isInteractive)
+	}
+	if err := interactive.PostClaudeMenu(executeAgentWrapper, s.WorkspaceDir, s.Branch, s.Prompt, nil); err != nil {
+		return err
+	}
+	fmt.Println("The task branch is pushed to the host when the task's own session exits its menu.")
+	return nil
+}
@@ -0,0 +1,125 @@
+package innie
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"giverny/internal/cmdutil"
+	"giverny/internal/gitops"
+	"giverny/internal/interactive"
+	"giverny/internal/shell"
+)
+
+// planPath is where the plan for the task is written, relative to the
+// workspace. It is excluded from git, so it is never pushed with the branch.
+const planPath = ".giverny/PLAN.md"
+
+// planTask has the agent, in print mode, write a step by step plan for the
+// task to planPath, which the user can approve, edit or have revised; a
+// non-interactive run approves it. It returns the prompt to run the task
+// with: one that points the agent at the plan, or the task's own if the user
+// chose to go without. A plan left by an earlier run in a reused container is
+// offered again rather than written anew.
+func planTask(config Config, git gitops.GitOps) (string, error) {
+	path := filepath.Join(config.WorkspaceDir, planPath)
+	if err := git.ExcludePath("/" + planPath); err != nil {
+		return "", err
+	}
+
+	plan, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(plan))) > 0 {
+		fmt.Printf("Using the plan from an earlier run in %s\n", planPath)
+	} else if plan, err = writePlan(config, path, ""); err != nil {
+		return "", err
+	}
+
+	approved := config.NonInteractive
+	for !approved {
+		action, feedback := interactive.PlanPrompt(strings.TrimSpace(string(plan)), nil)
+		switch action {
+		case interactive.PlanApprove:
+			approved = true
+		case interactive.PlanEdit:
+			if err := editFile(config.WorkspaceDir, path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			if plan, err = os.ReadFile(path); err != nil {
+				return "", fmt.Errorf("failed to read the plan: %w", err)
+			}
+		case interactive.PlanRevise:
+			if plan, err = writePlan(config, path, feedback); err != nil {
+				return "", err
+			}
+		case interactive.PlanSkip:
+			return config.Prompt, nil
+		}
+	}
+
+	return fmt.Sprintf("%s\n\nThe approved plan for this task is in %s. Follow it step by step, and update it if you have to depart from it. Don't commit it; it is excluded from git.", config.Prompt, planPath), nil
+}
+
+// writePlan has the agent write its plan for the task to path, taking
+// feedback on the plan already there into account if there is any, and
+// returns the plan
+func writePlan(config Config, path, feedback string) ([]byte, error) {
+	prompt := fmt.Sprintf("Before any work starts on the task below, plan it. Explore the repository as much as you need, "+
+		"but do not modify any files. Reply with only the plan, in Markdown: the steps to take, in order, with the files each touches and how the result will be checked.\n\nThe task:\n\n%s", config.Prompt)
+	if feedback != "" {
+		prompt += fmt.Sprintf("\n\nRevise the plan in %s with this feedback from the user: %s", planPath, feedback)
+	}
+	fmt.Printf("Planning the task...\n")
+	plan, err := captureAgentOutput(config.WorkspaceDir, prompt, config.AgentArgs, config.UseAmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan the task: %w", err)
+	}
+	if plan == "" {
+		return nil, fmt.Errorf("failed to plan the task: the agent returned an empty plan")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(plan+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write the plan: %w", err)
+	}
+	return []byte(plan), nil
+}
+
+// editFile opens path in $EDITOR, or vi or nano, whichever is found first.
+// Without any of them, a shell is started in dir to edit it with.
+func editFile(dir, path string) error {
+	var editor []string
+	if value := os.Getenv("EDITOR"); value != "" {
+		args, err := cmdutil.SplitArgs(value)
+		if err != nil {
+			return fmt.Errorf("invalid EDITOR: %w", err)
+		}
+		editor = args
+	} else {
+		for _, name := range []string{"vi", "nano"} {
+			if _, err := exec.LookPath(name); err == nil {
+				editor = []string{name}
+				break
+			}
+		}
+	}
+
+	var cmd *exec.Cmd
+	if len(editor) > 0 {
+		cmd = exec.Command(editor[0], append(editor[1:], path)...)
+	} else {
+		fmt.Printf("No editor found; edit %s in this shell, then type 'exit'\n", path)
+		cmd = exec.Command(shell.Detect())
+	}
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor exited with error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package innie
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	gitpkg "giverny/internal/git"
+	"giverny/internal/gitops"
+	"giverny/internal/status"
+)
+
+// reviewApproval is what the reviewer replies when it has nothing to add
+const reviewApproval = "LGTM"
+
+// errReviewerChanged is returned by crossReview when the reviewer changed
+// the workspace, which it is told not to, so its changes would otherwise be
+// pushed as the first agent's work
+var errReviewerChanged = errors.New("the reviewer changed the workspace")
+
+// crossReview has a second agent review the changes made for the task, and
+// the first address its notes, for up to config.ReviewRounds rounds, stopping
+// early once the reviewer approves. The reviewer runs with ReviewerArgs in
+// place of AgentArgs, so it can be a different model. It stops with
+// errReviewerChanged if the reviewer commits or modifies anything.
+func crossReview(config Config, git gitops.GitOps, reporter *status.Reporter, branchName string, executeAgent func(prompt string, interactive bool) error) error {
+	reviewerArgs := config.ReviewerArgs
+	if reviewerArgs == "" {
+		reviewerArgs = config.AgentArgs
+	}
+	start := gitpkg.StartLabel(branchName)
+
+	for round := 1; round <= config.ReviewRounds; round++ {
+		changed, err := git.ChangedFiles(start)
+		if err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			fmt.Printf("No changes to review\n")
+			return nil
+		}
+
+		fmt.Printf("\nReview round %d of %d...\n", round, config.ReviewRounds)
+		reporter.SetPhase(status.PhaseReview)
+		prompt := fmt.Sprintf("You are reviewing another agent's work on this task:\n\n%s\n\n"+
+			"Its changes are in `git diff %s` (including any not yet committed). "+
+			"Look for bugs, missed requirements, missing tests and anything that doesn't fit the surrounding code. "+
+			"Do not modify any files. Reply with only your review notes as a Markdown list, most important first, "+
+			"or with exactly %s if there is nothing that needs changing.", config.Prompt, start, reviewApproval)
+		head, tree, err := git.WorkspaceState()
+		if err != nil {
+			return err
+		}
+		notes, err := captureAgentOutput(config.WorkspaceDir, prompt, reviewerArgs, config.UseAmp)
+		if err != nil {
+			return fmt.Errorf("reviewer failed: %w", err)
+		}
+		headAfter, treeAfter, err := git.WorkspaceState()
+		if err != nil {
+			return err
+		}
+		if headAfter != head {
+			return fmt.Errorf("%w: it committed on top of %s; see git log %s..HEAD", errReviewerChanged, git.GetShortHash(head), git.GetShortHash(head))
+		}
+		if treeAfter != tree {
+			return fmt.Errorf("%w: it modified files; see git status", errReviewerChanged)
+		}
+		if notes == "" {
+			return fmt.Errorf("reviewer returned no notes")
+		}
+		if strings.TrimRight(notes, ".!") == reviewApproval {
+			fmt.Printf("✓ The reviewer has no more notes\n")
+			return nil
+		}
+		fmt.Printf("Review notes:\n%s\n\n", notes)
+
+		prompt = fmt.Sprintf("A reviewer left these notes on your changes:\n\n%s\n\n"+
+			"Address the ones you agree with and commit the result. If you disagree with a note, leave the code as it is.", notes)
+		if err := executeAgent(prompt, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
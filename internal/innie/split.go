@@ -0,0 +1,129 @@
+package innie
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"giverny/internal/gitops"
+	"giverny/internal/interactive"
+)
+
+// maxSubtasks is the most sub-tasks the agent is asked to split a task into
+const maxSubtasks = 8
+
+// subtask is one part of a task split with --split
+type subtask struct {
+	Title  string `json:"title"`
+	Prompt string `json:"prompt"`
+}
+
+// runSplit has the agent split the task into sub-tasks, then runs each in a
+// session of its own, in print mode, so that no one session has to hold the
+// whole task in its context. Each sub-task's work is committed before the
+// next starts, giving it its own range of commits. A task the agent doesn't
+// split, or that the user chooses to keep whole, is run in one session as
+// usual.
+func runSplit(config Config, git gitops.GitOps, prompt string, executeAgent func(prompt string, interactive bool) error) error {
+	subtasks, err := splitTask(config, prompt)
+	if err != nil {
+		return err
+	}
+	if len(subtasks) < 2 {
+		fmt.Printf("The agent kept the task whole; running it in one session\n")
+		return executeAgent(prompt, !config.NonInteractive)
+	}
+	var titles []string
+	for _, st := range subtasks {
+		titles = append(titles, st.Title)
+	}
+	if !config.NonInteractive && !interactive.SubtasksPrompt(titles, nil) {
+		return executeAgent(prompt, true)
+	}
+
+	var done []string
+	for i, st := range subtasks {
+		fmt.Printf("\nSub-task %d of %d: %s\n", i+1, len(subtasks), st.Title)
+		before, err := git.GetCommitHash("HEAD")
+		if err != nil {
+			return err
+		}
+		if err := executeAgent(subtaskPrompt(prompt, subtasks, done, i), false); err != nil {
+			return fmt.Errorf("sub-task %d (%s) failed: %w", i+1, st.Title, err)
+		}
+		if err := commitUnattended(git, executeAgent); err != nil {
+			return fmt.Errorf("sub-task %d (%s): %w", i+1, st.Title, err)
+		}
+		after, err := git.GetCommitHash("HEAD")
+		if err != nil {
+			return err
+		}
+
+		commits := "no commits"
+		if after != before {
+			commits = fmt.Sprintf("commits %s..%s", git.GetShortHash(before), git.GetShortHash(after))
+		}
+		done = append(done, fmt.Sprintf("%d. %s (%s)", i+1, st.Title, commits))
+		fmt.Printf("✓ Sub-task %d of %d done: %s\n", i+1, len(subtasks), commits)
+	}
+
+	fmt.Printf("\nAll %d sub-tasks are done:\n  %s\n", len(subtasks), strings.Join(done, "\n  "))
+	return nil
+}
+
+// splitTask asks the agent, in print mode, to split the task into sub-tasks
+func splitTask(config Config, prompt string) ([]subtask, error) {
+	fmt.Printf("Splitting the task into sub-tasks...\n")
+	request := fmt.Sprintf("The task below may be too large to do well in one session. Split it into at most %d sub-tasks that can be done one after the other, "+
+		"each in a fresh session that only sees the task, the list of sub-tasks and the commits of those before it. Each should leave the repository working and be committed on its own. "+
+		"Explore the repository as much as you need, but do not modify any files. "+
+		"Reply with only a JSON array of objects with a short \"title\" and a self-contained \"prompt\" for each sub-task, in the order to do them, "+
+		"or an array of one if the task should not be split.\n\nThe task:\n\n%s", maxSubtasks, prompt)
+	output, err := captureAgentOutput(config.WorkspaceDir, request, config.AgentArgs, config.UseAmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split the task: %w", err)
+	}
+	return parseSubtasks(output)
+}
+
+// parseSubtasks reads the JSON array of sub-tasks in the agent's reply,
+// which may be wrapped in a Markdown code block or surrounded by prose
+func parseSubtasks(output string) ([]subtask, error) {
+	start := strings.Index(output, "[")
+	end := strings.LastIndex(output, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("failed to split the task: the agent's reply has no list of sub-tasks")
+	}
+	var subtasks []subtask
+	if err := json.Unmarshal([]byte(output[start:end+1]), &subtasks); err != nil {
+		return nil, fmt.Errorf("failed to split the task: failed to parse the agent's list of sub-tasks: %w", err)
+	}
+	for i, st := range subtasks {
+		if strings.TrimSpace(st.Prompt) == "" {
+			return nil, fmt.Errorf("failed to split the task: sub-task %d has no prompt", i+1)
+		}
+		if st.Title == "" {
+			subtasks[i].Title = fmt.Sprintf("Sub-task %d", i+1)
+		}
+	}
+	if len(subtasks) > maxSubtasks {
+		return nil, fmt.Errorf("failed to split the task: the agent split it into %d sub-tasks, more than %d", len(subtasks), maxSubtasks)
+	}
+	return subtasks, nil
+}
+
+// subtaskPrompt returns the prompt for sub-task i: the whole task for
+// context, the list of sub-tasks with those done and their commits, and what
+// to do now
+func subtaskPrompt(prompt string, subtasks []subtask, done []string, i int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This task has been split into sub-tasks, each done in its own session. You are doing sub-task %d of %d.\n\nThe whole task, for context:\n\n%s\n\nThe sub-tasks:\n", i+1, len(subtasks), prompt)
+	for j, st := range subtasks {
+		fmt.Fprintf(&b, "%d. %s\n", j+1, st.Title)
+	}
+	if len(done) > 0 {
+		fmt.Fprintf(&b, "\nAlready done, as committed on this branch (see git log):\n%s\n", strings.Join(done, "\n"))
+	}
+	fmt.Fprintf(&b, "\nNow do only sub-task %d, leaving the later ones for their own sessions, and commit your work:\n\n%s", i+1, subtasks[i].Prompt)
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package innie
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubtasks(t *testing.T) {
+	var tooMany []string
+	for i := 0; i <= maxSubtasks; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(`{"title": "Part %d", "prompt": "Do part %d"}`, i+1, i+1))
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    []subtask
+		wantErr string
+	}{
+		{
+			name:   "fenced JSON",
+			output: "```json\n[{\"title\": \"Parser\", \"prompt\": \"Write the parser\"}, {\"title\": \"CLI\", \"prompt\": \"Add the command\"}]\n```\n",
+			want:   []subtask{{"Parser", "Write the parser"}, {"CLI", "Add the command"}},
+		},
+		{
+			name:   "prose around the array",
+			output: "Here is the split:\n[{\"title\": \"Parser\", \"prompt\": \"Write the parser\"}, {\"prompt\": \"Add the command\"}]\nEach leaves the build working.",
+			want:   []subtask{{"Parser", "Write the parser"}, {"Sub-task 2", "Add the command"}},
+		},
+		{
+			name:   "array of one",
+			output: `[{"title": "Whole task", "prompt": "Do it all"}]`,
+			want:   []subtask{{"Whole task", "Do it all"}},
+		},
+		{
+			name:    "empty prompt",
+			output:  `[{"title": "Parser", "prompt": "Write the parser"}, {"title": "CLI", "prompt": "  "}]`,
+			wantErr: "sub-task 2 has no prompt",
+		},
+		{
+			name:    "more than maxSubtasks",
+			output:  "[" + strings.Join(tooMany, ",") + "]",
+			wantErr: fmt.Sprintf("more than %d", maxSubtasks),
+		},
+		{
+			name:    "no array",
+			output:  "The task is small enough to do in one session.",
+			wantErr: "no list of sub-tasks",
+		},
+		{
+			name:    "invalid JSON",
+			output:  `[{"title": "Parser", "prompt": }]`,
+			wantErr: "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSubtasks(tt.output)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubtaskPrompt(t *testing.T) {
+	subtasks := []subtask{{"Parser", "Write the parser"}, {"CLI", "Add the command"}}
+
+	tests := []struct {
+		name     string
+		done     []string
+		i        int
+		want     []string
+		excluded []string
+	}{
+		{
+			name:     "first",
+			i:        0,
+			want:     []string{"sub-task 1 of 2", "Add a parse command", "1. Parser\n2. CLI\n", "Now do only sub-task 1", "Write the parser"},
+			excluded: []string{"Already done", "Add the command"},
+		},
+		{
+			name:     "after one done",
+			done:     []string{"1. Parser (commits abc1234..def5678)"},
+			i:        1,
+			want:     []string{"sub-task 2 of 2", "Already done, as committed on this branch (see git log):\n1. Parser (commits abc1234..def5678)", "Now do only sub-task 2", "Add the command"},
+			excluded: []string{"Write the parser"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subtaskPrompt("Add a parse command", subtasks, tt.done, tt.i)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected the prompt to contain %q, got:\n%s", want, got)
+				}
+			}
+			for _, excluded := range tt.excluded {
+				if strings.Contains(got, excluded) {
+					t.Errorf("expected the prompt not to contain %q, got:\n%s", excluded, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package innie
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"giverny/internal/gitops"
+	"giverny/internal/interactive"
+)
+
+// tddTests is what the tests phase of a --tdd task left: the commit the
+// implementation starts from and the files the tests changed
+type tddTests struct {
+	Commit string
+	Files  []string
+}
+
+// runTestCmd runs the task's test command through sh in the workspace. It
+// returns the command's combined output and whether it passed; err is only
+// set if the command could not be run.
+func runTestCmd(config Config) (string, bool, error) {
+	cmd := exec.Command("sh", "-c", config.TestCmd)
+	cmd.Dir = config.WorkspaceDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return string(output), false, nil
+		}
+		return "", false, fmt.Errorf("failed to run the test command: %w", err)
+	}
+	return string(output), true, nil
+}
+
+// writeFailingTests has the agent, in print mode, write and commit tests for
+// the task that fail until it is implemented, then checks with the test
+// command that they do. The user is shown the tests to approve, revise or
+// change themselves; a non-interactive run takes them if they fail as they
+// should. It returns the tests the implementation must pass.
+func writeFailingTests(config Config, git gitops.GitOps, prompt string, executeAgent func(prompt string, interactive bool) error) (tddTests, error) {
+	start, err := git.GetCommitHash("HEAD")
+	if err != nil {
+		return tddTests{}, err
+	}
+
+	request := fmt.Sprintf("Before the task below is implemented, write the tests for it: tests that describe the behavior the task asks for and fail until it is implemented. "+
+		"Don't implement the task itself. The tests are run with `%s`. Commit the tests when they are written.\n\nThe task:\n\n%s", config.TestCmd, prompt)
+	for {
+		// request is empty after the user changed the tests themselves,
+		// which only need committing and running again
+		if request != "" {
+			fmt.Printf("Writing the tests for the task...\n")
+			if err := executeAgent(request, false); err != nil {
+				return tddTests{}, fmt.Errorf("failed to write the tests: %w", err)
+			}
+		}
+		if err := commitUnattended(git, executeAgent); err != nil {
+			return tddTests{}, fmt.Errorf("failed to commit the tests: %w", err)
+		}
+
+		var tests tddTests
+		if tests.Commit, err = git.GetCommitHash("HEAD"); err != nil {
+			return tddTests{}, err
+		}
+		if tests.Files, err = git.ChangedFilesBetween(start, tests.Commit); err != nil {
+			return tddTests{}, err
+		}
+		output, passed, err := runTestCmd(config)
+		if err != nil {
+			return tddTests{}, err
+		}
+
+		var result string
+		switch {
+		case len(tests.Files) == 0:
+			result = "⚠️  The agent didn't commit any tests."
+		case passed:
+			result = "⚠️  The test command passes, so the tests don't check anything the task still has to do."
+		default:
+			result = "✓ The test command fails, as it should until the task is implemented:\n" + lastLines(output, 20)
+		}
+		if config.NonInteractive {
+			if len(tests.Files) == 0 || passed {
+				return tddTests{}, fmt.Errorf("%s", strings.TrimPrefix(result, "⚠️  "))
+			}
+			return tests, nil
+		}
+
+		action, feedback := interactive.TestsApprovalPrompt(config.WorkspaceDir, start, result, nil)
+		switch action {
+		case interactive.TestsApprove:
+			return tests, nil
+		case interactive.TestsRevise:
+			request = fmt.Sprintf("Revise the tests you wrote for the task (see git diff %s HEAD) with this feedback from the user: %s\n\n"+
+				"They must still fail until the task is implemented, and be run with `%s`. Don't implement the task itself. Commit the result.\n\nThe task:\n\n%s",
+				start, feedback, config.TestCmd, prompt)
+		case interactive.TestsEdited:
+			request = ""
+		}
+	}
+}
+
+// implementPrompt returns the prompt for implementing the task against the
+// tests written first
+func implementPrompt(config Config, prompt string, tests tddTests) string {
+	return fmt.Sprintf("%s\n\nTests for this task have been written and committed first, in %s. Implement the task so that `%s` passes. "+
+		"Don't change those tests to make them pass; if one is wrong, say so instead.", prompt, strings.Join(tests.Files, ", "), config.TestCmd)
+}
+
+// checkTests runs the test command before pushing and, for a --tdd task,
+// checks that the tests written first were left as they were approved. It
+// returns true if the push should not go ahead yet, after letting the user
+// have the agent fix the implementation or return to the menu.
+func checkTests(config Config, git gitops.GitOps, tests tddTests, executeAgent func(prompt string, interactive bool) error) (bool, error) {
+	if config.TestCmd == "" {
+		return false, nil
+	}
+
+	var failures []string
+	if tests.Commit != "" {
+		changed, err := git.ChangedFilesBetween(tests.Commit, "HEAD")
+		if err != nil {
+			return false, err
+		}
+		var modified []string
+		for _, file := range changed {
+			if slices.Contains(tests.Files, file) {
+				modified = append(modified, file)
+			}
+		}
+		if len(modified) > 0 {
+			failures = append(failures, fmt.Sprintf("The tests written first were changed after they were approved: %s", strings.Join(modified, ", ")))
+		}
+	}
+	output, passed, err := runTestCmd(config)
+	if err != nil {
+		return false, err
+	}
+	if !passed {
+		failures = append(failures, fmt.Sprintf("`%s` fails:\n%s", config.TestCmd, lastLines(output, 40)))
+	}
+	if len(failures) == 0 {
+		return false, nil
+	}
+
+	report := strings.Join(failures, "\n")
+	if config.NonInteractive {
+		return false, fmt.Errorf("the task branch doesn't pass its tests:\n%s", report)
+	}
+	switch interactive.TestsFailedPrompt(report, nil) {
+	case interactive.TestsFailedFix:
+		prompt := fmt.Sprintf("The task branch doesn't pass its tests:\n\n%s\n\nFix the implementation and commit the result.", report)
+		if tests.Commit != "" {
+			prompt = fmt.Sprintf("The task branch doesn't pass the tests written for it first:\n\n%s\n\n"+
+				"Fix the implementation, restoring those tests as they were in %s if you changed them, and commit the result.", report, tests.Commit)
+		}
+		if err := executeAgent(prompt, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return true, nil
+	case interactive.TestsFailedPush:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// lastLines returns the last n lines of output, which for a test run is
+// where the failures are summarized
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = append([]string{"..."}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
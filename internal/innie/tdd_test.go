@@ -0,0 +1,78 @@
+package innie
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"giverny/internal/gitops"
+)
+
+func TestCheckTests(t *testing.T) {
+	tdd := tddTests{Commit: "abc1234", Files: []string{"parse_test.go"}}
+
+	tests := []struct {
+		name    string
+		testCmd string
+		tests   tddTests
+		changed []string
+		wantErr string
+	}{
+		{name: "no test command", tests: tdd, changed: []string{"parse_test.go"}},
+		{name: "passing", testCmd: "true"},
+		{name: "failing", testCmd: "echo 1 test failed; exit 1", wantErr: "`echo 1 test failed; exit 1` fails:\n1 test failed"},
+		{name: "tdd passing", testCmd: "true", tests: tdd, changed: []string{"parse.go"}},
+		{name: "tdd tests changed", testCmd: "true", tests: tdd, changed: []string{"parse.go", "parse_test.go"}, wantErr: "changed after they were approved: parse_test.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			git := gitops.NewMockGitOps()
+			git.ChangedFilesBetweenFunc = func(fromRef, toRef string) ([]string, error) {
+				if fromRef != tt.tests.Commit || toRef != "HEAD" {
+					t.Errorf("expected the files changed since %s, got %s..%s", tt.tests.Commit, fromRef, toRef)
+				}
+				return tt.changed, nil
+			}
+			executeAgent := func(prompt string, interactive bool) error {
+				return fmt.Errorf("the agent was run")
+			}
+			config := Config{WorkspaceDir: t.TempDir(), TestCmd: tt.testCmd, NonInteractive: true}
+
+			blocked, err := checkTests(config, git, tt.tests, executeAgent)
+			if blocked {
+				t.Errorf("expected a non-interactive run never to return to the menu")
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		n      int
+		want   string
+	}{
+		{"fewer lines", "ok\nPASS\n", 3, "ok\nPASS"},
+		{"exactly n lines", "a\nb\nc\n", 3, "a\nb\nc"},
+		{"more lines", "a\nb\nc\nd\n", 2, "...\nc\nd"},
+		{"no trailing newline", "a\nb\nc", 2, "...\nb\nc"},
+		{"empty", "", 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lastLines(tt.output, tt.n); got != tt.want {
+				t.Errorf("lastLines(%q, %d) = %q, want %q", tt.output, tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -16,14 +16,21 @@ import (
 // PostClaudeMenu shows an interactive menu for committing, restarting, or exiting.
 // It returns nil when the user chooses to exit with a clean workspace.
 // The executeClaude parameter is a function that executes Claude Code with a given prompt.
-func PostClaudeMenu(executeClaude func(prompt string, interactive bool) error, reader io.Reader) error {
+// The branchName parameter is the task branch checked out in workspaceDir; its
+// START label is used when undoing changes. The prompt is the task's, which
+// restarting Claude runs again.
+func PostClaudeMenu(executeClaude func(prompt string, interactive bool) error, workspaceDir, branchName, prompt string, reader io.Reader) error {
 	if reader == nil {
 		reader = os.Stdin
 	}
 
+	// diffreviewer is optional in the image, see --with-tools
+	_, err := exec.LookPath("diffreviewer")
+	hasDiffreviewer := err == nil
+
 	for {
 		// Check if there are uncommitted changes
-		dirty, err := git.IsWorkspaceDirty()
+		dirty, err := git.IsWorkspaceDirtyInDir(workspaceDir)
 		if err != nil {
 			return fmt.Errorf("failed to check workspace status: %w", err)
 		}
@@ -31,9 +38,15 @@ func PostClaudeMenu(executeClaude func(prompt string, interactive bool) error, r
 		// Show menu
 		fmt.Println("\nWhat would you like to do?")
 		fmt.Println("  [c] Ask Claude to Commit the changes")
-		fmt.Println("  [d] Start diffreviewer")
+		if hasDiffreviewer {
+			fmt.Println("  [d] Start diffreviewer")
+		}
+		fmt.Println("  [g] Show git log")
+		fmt.Println("  [i] Interactive add")
+		fmt.Println("  [p] Pull new commits from the host")
 		fmt.Println("  [s] Start a shell")
 		fmt.Println("  [r] Restart Claude")
+		fmt.Println("  [u] Undo all changes")
 		fmt.Println("  [x] Exit")
 		if dirty {
 			fmt.Println("⚠️  You have uncommitted changes")
@@ -48,18 +61,41 @@ func PostClaudeMenu(executeClaude func(prompt string, interactive bool) error, r
 		case "c":
 			return executeClaude("Commit the changes", false)
 		case "d":
-			if err := runDiffreviewer(executeClaude); err != nil {
+			if !hasDiffreviewer {
+				fmt.Println("diffreviewer is not installed in this image (see --with-tools).")
+				continue
+			}
+			if err := runDiffreviewer(executeClaude, workspaceDir); err != nil {
 				fmt.Fprintf(os.Stderr, "Error running diffreviewer: %v\n", err)
 				continue
 			}
+		case "g":
+			if err := showGitLog(workspaceDir, branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Error showing git log: %v\n", err)
+				continue
+			}
+		case "i":
+			if err := interactiveAdd(executeClaude, workspaceDir, reader); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running interactive add: %v\n", err)
+				continue
+			}
+		case "p":
+			if err := pullHostChanges(workspaceDir, branchName, reader); err != nil {
+				fmt.Fprintf(os.Stderr, "Error pulling host changes: %v\n", err)
+				continue
+			}
 		case "s":
-			if err := startShell(); err != nil {
+			if err := startShell(workspaceDir); err != nil {
 				fmt.Fprintf(os.Stderr, "Error starting shell: %v\n", err)
 				continue
 			}
 		case "r":
-			// Restart Claude - use the last argument as the prompt
-			return executeClaude(os.Args[len(os.Args)-1], true)
+			return executeClaude(prompt, true)
+		case "u":
+			if err := undoChanges(workspaceDir, branchName, reader); err != nil {
+				fmt.Fprintf(os.Stderr, "Error undoing changes: %v\n", err)
+				continue
+			}
 		case "x":
 			// Only allow exit if workspace is clean
 			if dirty {
@@ -68,20 +104,727 @@ func PostClaudeMenu(executeClaude func(prompt string, interactive bool) error, r
 			}
 			return nil
 		default:
-			fmt.Println("Invalid choice. Please enter c, d, s, r, or x.")
+			if hasDiffreviewer {
+				fmt.Println("Invalid choice. Please enter c, d, g, i, p, s, r, u, or x.")
+			} else {
+				fmt.Println("Invalid choice. Please enter c, g, i, p, s, r, u, or x.")
+			}
+		}
+	}
+}
+
+// PushConflictAction is the user's choice after a push was rejected because
+// the task branch moved on the host.
+type PushConflictAction int
+
+const (
+	// PushConflictRebase rebases the task branch onto the host's copy and pushes again
+	PushConflictRebase PushConflictAction = iota
+	// PushConflictForce overwrites the host's copy, as long as it has not moved again
+	PushConflictForce
+	// PushConflictMenu returns to the post-agent menu
+	PushConflictMenu
+	// PushConflictAbort exits without pushing, keeping the container
+	PushConflictAbort
+)
+
+// PushConflictPrompt shows the commits on the host's copy of branchName that
+// the workspace does not have and asks the user how to proceed. The caller
+// must have fetched from the git server first.
+func PushConflictPrompt(workspaceDir, branchName string, reader io.Reader) PushConflictAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Printf("\n⚠️  Push rejected: %s has new commits on the host:\n", branchName)
+	cmd := exec.Command("git", "log", "--oneline", "HEAD..origin/"+branchName)
+	cmd.Dir = workspaceDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to list host commits: %v\n", err)
+	}
+
+	for {
+		fmt.Println("\nHow would you like to push?")
+		fmt.Println("  [r] Rebase onto the host's commits and push")
+		fmt.Println("  [f] Force push, discarding the host's commits")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [x] Exit without pushing (the container is kept)")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "r":
+			return PushConflictRebase
+		case "f":
+			fmt.Print("This will discard the commits listed above. Are you sure? [y/N]: ")
+			var answer string
+			fmt.Fscanln(reader, &answer)
+			if answer == "y" || answer == "Y" {
+				return PushConflictForce
+			}
+		case "m":
+			return PushConflictMenu
+		case "x":
+			return PushConflictAbort
+		default:
+			fmt.Println("Invalid choice. Please enter r, f, m, or x.")
+		}
+	}
+}
+
+// PolicyAction is the user's choice after the task branch was found to modify
+// files the policy forbids.
+type PolicyAction int
+
+const (
+	// PolicyRevert asks the agent to revert its changes to the forbidden files
+	PolicyRevert PolicyAction = iota
+	// PolicyMenu returns to the post-agent menu
+	PolicyMenu
+	// PolicyOverride pushes anyway; never returned in strict mode
+	PolicyOverride
+)
+
+// PolicyViolationPrompt lists the forbidden files the task branch modifies and
+// asks the user how to proceed. In strict mode the push cannot be overridden.
+func PolicyViolationPrompt(violations []string, strict bool, reader io.Reader) PolicyAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch modifies files that are not allowed to change:")
+	for _, path := range violations {
+		fmt.Printf("  %s\n", path)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [r] Ask Claude to revert these changes")
+		fmt.Println("  [m] Return to the menu")
+		if !strict {
+			fmt.Println("  [p] Push anyway")
+		}
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch {
+		case choice == "r":
+			return PolicyRevert
+		case choice == "m":
+			return PolicyMenu
+		case choice == "p" && !strict:
+			return PolicyOverride
+		case strict:
+			fmt.Println("Invalid choice. Please enter r or m.")
+		default:
+			fmt.Println("Invalid choice. Please enter r, m, or p.")
+		}
+	}
+}
+
+// BranchProblemsPrompt lists what is wrong with the task branch after the
+// agent worked on it, such as HEAD being on another branch or its history
+// having been rewritten, and asks how to proceed: PolicyRevert has the agent
+// put things right, PolicyMenu returns to the menu and PolicyOverride pushes
+// the branch as it is.
+func BranchProblemsPrompt(problems []string, reader io.Reader) PolicyAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch is not as giverny left it:")
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [f] Ask Claude to put its work back on the task branch")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push the task branch as it is")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "f":
+			return PolicyRevert
+		case "m":
+			return PolicyMenu
+		case "p":
+			return PolicyOverride
+		default:
+			fmt.Println("Invalid choice. Please enter f, m, or p.")
+		}
+	}
+}
+
+// LimitsExceededPrompt lists the ways the task branch exceeds the size limits
+// and asks whether to push anyway. It returns true to push and false to
+// return to the menu.
+func LimitsExceededPrompt(problems []string, reader io.Reader) bool {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch is larger than allowed:")
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push anyway")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "m":
+			return false
+		case "p":
+			return true
+		default:
+			fmt.Println("Invalid choice. Please enter m or p.")
+		}
+	}
+}
+
+// ComplianceFailedPrompt shows the compliance check failures for the task
+// branch and asks how to proceed: PolicyRevert has the agent fix them,
+// PolicyMenu returns to the menu and PolicyOverride pushes anyway.
+func ComplianceFailedPrompt(failures string, reader io.Reader) PolicyAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The compliance check failed on the task branch:")
+	fmt.Println(strings.TrimRight(failures, "\n"))
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [f] Ask Claude to fix the problems")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push anyway")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "f":
+			return PolicyRevert
+		case "m":
+			return PolicyMenu
+		case "p":
+			return PolicyOverride
+		default:
+			fmt.Println("Invalid choice. Please enter f, m, or p.")
+		}
+	}
+}
+
+// ArtifactsFoundPrompt lists files committed on the task branch that look
+// like build artifacts and asks how to proceed: PolicyRevert has the agent
+// untrack them, PolicyMenu returns to the menu and PolicyOverride pushes
+// anyway.
+func ArtifactsFoundPrompt(artifacts []string, reader io.Reader) PolicyAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch commits files that look like build artifacts:")
+	for _, artifact := range artifacts {
+		fmt.Printf("  %s\n", artifact)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [r] Ask Claude to remove them from git (git rm --cached)")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push anyway")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "r":
+			return PolicyRevert
+		case "m":
+			return PolicyMenu
+		case "p":
+			return PolicyOverride
+		default:
+			fmt.Println("Invalid choice. Please enter r, m, or p.")
+		}
+	}
+}
+
+// SecretsFoundPrompt lists possible secrets found in the task branch's commits
+// and asks what to do. The push is blocked either way: it returns true to ask
+// the agent to remove them from the branch history and false to return to
+// the menu.
+func SecretsFoundPrompt(findings []string, reader io.Reader) bool {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n🚨 Possible secrets found in the task branch; the push has been blocked:")
+	for _, finding := range findings {
+		fmt.Printf("  %s\n", finding)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [r] Ask Claude to remove them from the branch history")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "r":
+			return true
+		case "m":
+			return false
+		default:
+			fmt.Println("Invalid choice. Please enter r or m.")
+		}
+	}
+}
+
+// ExtraFilesAction is the user's choice for the files the agent created
+// outside the workspace.
+type ExtraFilesAction int
+
+const (
+	// ExtraFilesCopy copies them into the workspace and returns to the menu
+	ExtraFilesCopy ExtraFilesAction = iota
+	// ExtraFilesExport copies them to the host alongside the task's other files
+	ExtraFilesExport
+	// ExtraFilesIgnore leaves them in the container
+	ExtraFilesIgnore
+	// ExtraFilesMenu returns to the menu without doing anything with them
+	ExtraFilesMenu
+)
+
+// ExtraFilesPrompt lists the files the agent created outside the workspace,
+// which are lost with the container, and asks what to do with them.
+func ExtraFilesPrompt(files []string, reader io.Reader) ExtraFilesAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n📄 The agent created files outside the workspace:")
+	for _, file := range files {
+		fmt.Printf("  %s\n", file)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [w] Copy them into the workspace, then return to the menu to commit them")
+		fmt.Println("  [e] Export them to the host without committing them")
+		fmt.Println("  [i] Ignore them")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "w":
+			return ExtraFilesCopy
+		case "e":
+			return ExtraFilesExport
+		case "i":
+			return ExtraFilesIgnore
+		case "m":
+			return ExtraFilesMenu
+		default:
+			fmt.Println("Invalid choice. Please enter w, e, i, or m.")
+		}
+	}
+}
+
+// SubtasksPrompt lists the sub-tasks the agent split the task into and asks
+// whether to run them one after the other. It returns true to run them, and
+// false to run the task in one interactive session instead.
+func SubtasksPrompt(subtasks []string, reader io.Reader) bool {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n📋 The agent split the task into sub-tasks, each to be run in its own session:")
+	for i, subtask := range subtasks {
+		fmt.Printf("  %d. %s\n", i+1, subtask)
+	}
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [r] Run the sub-tasks one after the other")
+		fmt.Println("  [w] Run the whole task in one session")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "r":
+			return true
+		case "w":
+			return false
+		default:
+			fmt.Println("Invalid choice. Please enter r or w.")
 		}
 	}
 }
 
-// startShell starts an interactive shell in /app
-func startShell() error {
+// TestsAction is the user's choice for the tests the agent wrote before
+// implementing the task with --tdd.
+type TestsAction int
+
+const (
+	// TestsApprove starts the implementation
+	TestsApprove TestsAction = iota
+	// TestsRevise has the agent change the tests with the user's feedback
+	TestsRevise
+	// TestsEdited is returned after the user changed the tests in a shell,
+	// so they can be run again
+	TestsEdited
+)
+
+// TestsApprovalPrompt shows what the agent's tests changed, and the result
+// of the test command with them, and asks whether to implement the task
+// against them. With TestsRevise it also returns what the user wants changed.
+func TestsApprovalPrompt(workspaceDir, fromRef, testResult string, reader io.Reader) (TestsAction, string) {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n🧪 The agent wrote these tests for the task:")
+	cmd := exec.Command("git", "diff", "--stat", fromRef, "HEAD")
+	cmd.Dir = workspaceDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to show the tests: %v\n", err)
+	}
+	fmt.Println(testResult)
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [a] Approve the tests and implement the task")
+		fmt.Println("  [d] Show the tests' diff")
+		fmt.Println("  [r] Ask Claude to revise the tests")
+		fmt.Println("  [s] Start a shell to change them (commit your changes)")
+		fmt.Print("Choice: ")
+
+		var choice string
+		if _, err := fmt.Fscanln(reader, &choice); err == io.EOF {
+			// No more input: go with the tests as they are
+			return TestsApprove, ""
+		}
+
+		switch choice {
+		case "a":
+			return TestsApprove, ""
+		case "d":
+			cmd := exec.Command("git", "diff", fromRef, "HEAD")
+			cmd.Dir = workspaceDir
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to show the tests: %v\n", err)
+			}
+		case "r":
+			fmt.Print("What should change? ")
+			feedback := readLine(reader)
+			if feedback = strings.TrimSpace(feedback); feedback != "" {
+				return TestsRevise, feedback
+			}
+		case "s":
+			if err := startShell(workspaceDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error starting shell: %v\n", err)
+				continue
+			}
+			return TestsEdited, ""
+		default:
+			fmt.Println("Invalid choice. Please enter a, d, r, or s.")
+		}
+	}
+}
+
+// TestsFailedAction is the user's choice when the task branch doesn't pass
+// the test command before pushing.
+type TestsFailedAction int
+
+const (
+	// TestsFailedFix has the agent fix the implementation
+	TestsFailedFix TestsFailedAction = iota
+	// TestsFailedMenu returns to the menu
+	TestsFailedMenu
+	// TestsFailedPush pushes anyway
+	TestsFailedPush
+)
+
+// TestsFailedPrompt shows why the task branch doesn't pass the test command,
+// or for a --tdd task the tests written for it, and asks how to proceed.
+func TestsFailedPrompt(failures string, reader io.Reader) TestsFailedAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch doesn't pass its tests:")
+	fmt.Println(strings.TrimRight(failures, "\n"))
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [f] Ask Claude to fix the implementation")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push anyway")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "f":
+			return TestsFailedFix
+		case "m":
+			return TestsFailedMenu
+		case "p":
+			return TestsFailedPush
+		default:
+			fmt.Println("Invalid choice. Please enter f, m, or p.")
+		}
+	}
+}
+
+// CoverageDropPrompt shows how far the task branch lowers test coverage and
+// asks how to proceed: PolicyRevert has the agent add tests, PolicyMenu
+// returns to the menu and PolicyOverride pushes anyway.
+func CoverageDropPrompt(report string, reader io.Reader) PolicyAction {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Println("\n⚠️  The task branch lowers test coverage by more than allowed:")
+	fmt.Printf("  %s\n", report)
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [t] Ask Claude to add tests for its changes")
+		fmt.Println("  [m] Return to the menu")
+		fmt.Println("  [p] Push anyway")
+		fmt.Print("Choice: ")
+
+		var choice string
+		fmt.Fscanln(reader, &choice)
+
+		switch choice {
+		case "t":
+			return PolicyRevert
+		case "m":
+			return PolicyMenu
+		case "p":
+			return PolicyOverride
+		default:
+			fmt.Println("Invalid choice. Please enter t, m, or p.")
+		}
+	}
+}
+
+// PlanAction is the user's choice for the plan the agent wrote before
+// starting the task.
+type PlanAction int
+
+const (
+	// PlanApprove runs the task with the plan
+	PlanApprove PlanAction = iota
+	// PlanEdit opens the plan in an editor, then asks again
+	PlanEdit
+	// PlanRevise has the agent rewrite the plan with the user's feedback
+	PlanRevise
+	// PlanSkip runs the task without the plan
+	PlanSkip
+)
+
+// PlanPrompt shows the plan the agent wrote and asks whether to run the task
+// with it. With PlanRevise it also returns what the user wants changed.
+func PlanPrompt(plan string, reader io.Reader) (PlanAction, string) {
+	if reader == nil {
+		reader = os.Stdin
+	}
+
+	fmt.Printf("\n📋 The agent's plan for the task:\n\n%s\n", plan)
+
+	for {
+		fmt.Println("\nWhat would you like to do?")
+		fmt.Println("  [a] Approve the plan and start the task")
+		fmt.Println("  [e] Edit the plan")
+		fmt.Println("  [r] Ask Claude to revise the plan")
+		fmt.Println("  [s] Start the task without a plan")
+		fmt.Print("Choice: ")
+
+		var choice string
+		if _, err := fmt.Fscanln(reader, &choice); err == io.EOF {
+			// No more input: go with the plan as it is
+			return PlanApprove, ""
+		}
+
+		switch choice {
+		case "a":
+			return PlanApprove, ""
+		case "e":
+			return PlanEdit, ""
+		case "r":
+			fmt.Print("What should change? ")
+			feedback := readLine(reader)
+			if feedback = strings.TrimSpace(feedback); feedback != "" {
+				return PlanRevise, feedback
+			}
+		case "s":
+			return PlanSkip, ""
+		default:
+			fmt.Println("Invalid choice. Please enter a, e, r, or s.")
+		}
+	}
+}
+
+// readLine reads a line from reader, a byte at a time so that nothing after
+// it is read, as the shared stdin goes on to later prompts and the agent
+func readLine(reader io.Reader) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := reader.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			break
+		}
+	}
+	return string(line)
+}
+
+// pullHostChanges fetches new commits on the host's base branch and, after
+// asking whether to rebase or merge, brings them into the task branch.
+func pullHostChanges(workspaceDir, branchName string, reader io.Reader) error {
+	baseBranch, err := git.TaskBaseBranchInDir(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rebase onto or merge %s? [r/M]: ", baseBranch)
+	var answer string
+	fmt.Fscanln(reader, &answer)
+	rebase := answer == "r" || answer == "R"
+
+	if err := git.SyncWorkspaceInDir(workspaceDir, branchName, baseBranch, rebase, false); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Pulled new commits from %s\n", baseBranch)
+	return nil
+}
+
+// undoChanges asks for confirmation and then hard-resets the workspace to the
+// START label, discarding all commits and uncommitted changes made during the
+// task.
+func undoChanges(workspaceDir, branchName string, reader io.Reader) error {
+	startLabel := git.StartLabel(branchName)
+	fmt.Printf("This will discard all commits and changes since %s. Are you sure? [y/N]: ", startLabel)
+
+	var answer string
+	fmt.Fscanln(reader, &answer)
+	if answer != "y" && answer != "Y" {
+		fmt.Println("Undo cancelled.")
+		return nil
+	}
+
+	if err := git.ResetWorkspaceInDir(workspaceDir, startLabel); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Reset to %s\n", startLabel)
+	return nil
+}
+
+// showGitLog shows the commits made since the START label, with the files
+// each commit touched, in color and through git's pager.
+func showGitLog(workspaceDir, branchName string) error {
+	startLabel := git.StartLabel(branchName)
+	cmd := exec.Command("git", "--paginate", "log", "--color=always", "--oneline", "--stat", startLabel+"..HEAD")
+	cmd.Dir = workspaceDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git log exited with error: %w", err)
+	}
+	return nil
+}
+
+// interactiveAdd runs `git add -p` so the user can pick which hunks to stage,
+// then offers to have the agent commit only the staged changes.
+func interactiveAdd(executeClaude func(prompt string, interactive bool) error, workspaceDir string, reader io.Reader) error {
+	cmd := exec.Command("git", "add", "-p")
+	cmd.Dir = workspaceDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git add -p exited with error: %w", err)
+	}
+
+	staged, err := git.HasStagedChangesInDir(workspaceDir)
+	if err != nil {
+		return fmt.Errorf("failed to check for staged changes: %w", err)
+	}
+	if !staged {
+		fmt.Println("No changes staged.")
+		return nil
+	}
+
+	fmt.Print("Ask Claude to commit the staged changes? [y/N]: ")
+	var answer string
+	fmt.Fscanln(reader, &answer)
+	if answer != "y" && answer != "Y" {
+		fmt.Println("Staged changes left in the index.")
+		return nil
+	}
+
+	return executeClaude("Commit the staged changes. Do not stage or commit any other changes.", false)
+}
+
+// startShell starts an interactive shell in the workspace
+func startShell(workspaceDir string) error {
 	// Determine which shell to use
 	shellPath := shell.Detect()
 
-	fmt.Printf("Starting %s in /app (type 'exit' to return to menu)...\n", shellPath)
+	fmt.Printf("Starting %s in %s (type 'exit' to return to menu)...\n", shellPath, workspaceDir)
 
-	cmd := exec.Command(shellPath)
-	cmd.Dir = "/app"
+	args, env := shell.ProfileArgs(shellPath, shell.ProfileDir)
+	cmd := exec.Command(shellPath, args...)
+	cmd.Dir = workspaceDir
+	// The profile tells shells started from the menu apart from docker exec ones
+	env = append(env, "GIVERNY_MENU_SHELL=1")
+	cmd.Env = append(append(os.Environ(), env...), shell.HistoryEnv(shellPath, shell.CacheDir)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -96,13 +839,13 @@ func startShell() error {
 // runDiffreviewer starts diffreviewer as a server, notifies outie to open a
 // browser, and waits for diffreviewer to exit. If review notes are produced,
 // asks the agent to fix them.
-func runDiffreviewer(executeClaude func(prompt string, interactive bool) error) error {
+func runDiffreviewer(executeClaude func(prompt string, interactive bool) error, workspaceDir string) error {
 	fmt.Println("Starting diffreviewer...")
 
 	notesPath := "/tmp/diffreviewer-notes.md"
 
 	cmd := exec.Command("diffreviewer", "-notes", notesPath)
-	cmd.Dir = "/app"
+	cmd.Dir = workspaceDir
 	cmd.Stdin = os.Stdin
 
 	// Capture stderr to detect the startup message with the port.
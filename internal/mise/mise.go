@@ -0,0 +1,60 @@
+// Package mise installs the runtimes a project pins with asdf or mise, so the
+// agent builds and tests with the versions the project expects.
+package mise
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Configs are the files, relative to the project root, that pin runtime
+// versions. mise reads asdf's .tool-versions as well as its own files.
+var Configs = []string{".tool-versions", ".mise.toml", "mise.toml"}
+
+// Detect returns which of Configs the project at dir has
+func Detect(dir string) []string {
+	var found []string
+	for _, config := range Configs {
+		if _, err := os.Stat(filepath.Join(dir, config)); err == nil {
+			found = append(found, config)
+		}
+	}
+	return found
+}
+
+// Install trusts the project's mise configuration and installs the runtimes
+// it pins
+func Install(dir string, debug bool) error {
+	for _, args := range [][]string{{"trust", "--yes", dir}, {"install", "--yes"}} {
+		cmd := exec.Command("mise", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if debug {
+			os.Stdout.Write(output)
+		}
+		if err != nil {
+			return fmt.Errorf("mise %s failed: %w\n%s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// Env returns the environment that puts the project's runtimes first on the
+// PATH
+func Env(dir string) (map[string]string, error) {
+	cmd := exec.Command("mise", "env", "--json")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("mise env failed: %w", err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(output, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse mise env output: %w", err)
+	}
+	return env, nil
+}
@@ -0,0 +1,36 @@
+package mise
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if found := Detect(dir); len(found) != 0 {
+		t.Errorf("expected nothing in an empty directory, got %v", found)
+	}
+	for _, name := range []string{".tool-versions", "mise.toml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("go 1.25.5\n"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if found, expected := Detect(dir), []string{".tool-versions", "mise.toml"}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("Detect() = %v, want %v", found, expected)
+	}
+}
@@ -0,0 +1,60 @@
+// Package nix runs the agent in a project's Nix flake dev shell, so it has
+// the toolchain the flake pins.
+package nix
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FlakeFile is the file that makes a project a flake
+const FlakeFile = "flake.nix"
+
+// HasFlake reports whether the project at dir is a flake
+func HasFlake(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, FlakeFile))
+	return err == nil
+}
+
+// ignoredVars are variables of the dev shell that are not carried over:
+// they describe the shell itself, or point at temporary directories that
+// are removed when it exits
+var ignoredVars = map[string]bool{
+	"_": true, "PWD": true, "OLDPWD": true, "SHLVL": true, "HOME": true, "TERM": true,
+	"TMPDIR": true, "TMP": true, "TEMP": true, "TEMPDIR": true, "NIX_BUILD_TOP": true,
+}
+
+// DevEnv returns the environment of the default dev shell of the flake at
+// dir. The first time it is run, Nix builds or downloads the shell's
+// packages, which may take a while; with debug its progress is shown.
+func DevEnv(dir string, debug bool) (map[string]string, error) {
+	cmd := exec.Command("nix", "develop", "--command", "env", "-0")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if debug {
+		cmd.Stderr = os.Stderr
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enter the flake's dev shell: %w\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseEnv(output), nil
+}
+
+// parseEnv reads the output of `env -0`
+func parseEnv(output []byte) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range strings.Split(string(output), "\x00") {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" || ignoredVars[key] {
+			continue
+		}
+		env[key] = value
+	}
+	return env
+}
@@ -0,0 +1,46 @@
+package nix
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestParseEnv(t *testing.T) {
+	output := "PATH=/nix/store/abc-go/bin:/usr/bin\x00GOFLAGS=-mod=mod\x00MULTI=a\nb=c\x00TMPDIR=/tmp/nix-shell.1234\x00SHLVL=2\x00"
+	expected := map[string]string{
+		"PATH":    "/nix/store/abc-go/bin:/usr/bin",
+		"GOFLAGS": "-mod=mod",
+		"MULTI":   "a\nb=c",
+	}
+	if got := parseEnv([]byte(output)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseEnv() = %v, want %v", got, expected)
+	}
+}
+
+func TestHasFlake(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if HasFlake(dir) {
+		t.Error("expected no flake in an empty directory")
+	}
+	if err := os.WriteFile(filepath.Join(dir, FlakeFile), []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("failed to write flake.nix: %v", err)
+	}
+	if !HasFlake(dir) {
+		t.Error("expected a flake")
+	}
+}
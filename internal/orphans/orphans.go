@@ -0,0 +1,305 @@
+// Package orphans finds the containers and git servers that giverny started
+// for tasks whose giverny is no longer running, such as after a crash, so
+// they can be cleaned up instead of accumulating.
+package orphans
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"giverny/internal/docker"
+	"giverny/internal/git"
+)
+
+// DefaultMaxAge is how old what a task left behind must be before giverny
+// offers to clean it up
+const DefaultMaxAge = 24 * time.Hour
+
+// record is what is known about a task while giverny runs it
+type record struct {
+	PID     int `json:"pid"`
+	GitPort int `json:"git_port,omitempty"`
+}
+
+// activeDir returns where the records of running tasks are kept, in
+// giverny's directory under the user cache directory
+func activeDir() (string, error) {
+	return cacheDir("active")
+}
+
+// keptDir returns where the names of containers kept on purpose are
+// recorded, in giverny's directory under the user cache directory
+func keptDir() (string, error) {
+	return cacheDir("kept")
+}
+
+func cacheDir(name string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "giverny", name), nil
+}
+
+// Register records that this process is running the task in containerName,
+// with its git server on gitPort on the host (0 if it has none), so that
+// nothing of the task is taken for an orphan. The record is removed by the
+// function returned.
+func Register(containerName string, gitPort int) (func(), error) {
+	dir, err := activeDir()
+	if err != nil {
+		return nil, err
+	}
+	return register(dir, containerName, gitPort)
+}
+
+func register(dir, containerName string, gitPort int) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for running tasks: %w", err)
+	}
+	data, err := json.Marshal(record{PID: os.Getpid(), GitPort: gitPort})
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, containerName+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record running task: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// Keep records that the container containerName was kept on purpose, for
+// debugging or because the user asked to, so that it isn't taken for an
+// orphan however old it gets. The record goes when the container does.
+func Keep(containerName string) error {
+	dir, err := keptDir()
+	if err != nil {
+		return err
+	}
+	return keep(dir, containerName)
+}
+
+func keep(dir, containerName string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory for kept containers: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, containerName), nil, 0644); err != nil {
+		return fmt.Errorf("failed to record kept container: %w", err)
+	}
+	return nil
+}
+
+// Orphan is a container or git server left behind by a task
+type Orphan struct {
+	// Description says what it is, e.g. "container giverny-PROJ-1"
+	Description string
+	Age         time.Duration
+	remove      func() error
+}
+
+// String describes the orphan and its age
+func (o Orphan) String() string {
+	return fmt.Sprintf("%s, started %s ago", o.Description, formatAge(o.Age))
+}
+
+// Remove stops the orphan and removes it
+func (o Orphan) Remove() error {
+	return o.remove()
+}
+
+// Find returns the containers and git servers giverny started for tasks that
+// are older than maxAge and don't belong to a task giverny is running
+func Find(maxAge time.Duration) ([]Orphan, error) {
+	dir, err := activeDir()
+	if err != nil {
+		return nil, err
+	}
+	kept, err := keptDir()
+	if err != nil {
+		return nil, err
+	}
+	return finder{
+		now:        time.Now(),
+		activeDir:  dir,
+		keptDir:    kept,
+		containers: docker.ListTaskContainers,
+		daemons:    git.ListDaemons,
+		alive:      processAlive,
+	}.find(maxAge)
+}
+
+// finder finds orphans with its dependencies injected, for testing
+type finder struct {
+	now        time.Time
+	activeDir  string
+	keptDir    string
+	containers func() ([]docker.TaskContainer, error)
+	daemons    func() ([]git.Daemon, error)
+	alive      func(pid int) bool
+}
+
+func (f finder) find(maxAge time.Duration) ([]Orphan, error) {
+	activeContainers, activePorts, err := f.activeTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	containers, err := f.containers()
+	if err != nil {
+		return nil, err
+	}
+	kept := f.keptContainers(containers)
+	for _, c := range containers {
+		age := f.now.Sub(c.Created)
+		if activeContainers[c.Task] || kept[c.Task] || age < maxAge {
+			continue
+		}
+		description := "container " + c.Name
+		if c.Running {
+			description += " (running)"
+		}
+		name := c.Name
+		orphans = append(orphans, Orphan{
+			Description: description,
+			Age:         age,
+			remove:      func() error { return docker.RemoveTaskContainer(name) },
+		})
+	}
+
+	daemons, err := f.daemons()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range daemons {
+		if activePorts[d.Port] || d.Age < maxAge {
+			continue
+		}
+		daemon := d
+		orphans = append(orphans, Orphan{
+			Description: fmt.Sprintf("git server on port %d (PID %d)", d.Port, d.PID),
+			Age:         d.Age,
+			remove:      func() error { return git.KillDaemon(daemon) },
+		})
+	}
+	return orphans, nil
+}
+
+// activeTasks returns the containers and git server ports of the tasks
+// giverny is running. Records left by a giverny that has gone are removed.
+func (f finder) activeTasks() (map[string]bool, map[int]bool, error) {
+	containers := map[string]bool{}
+	ports := map[int]bool{}
+	entries, err := os.ReadDir(f.activeDir)
+	if os.IsNotExist(err) {
+		return containers, ports, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read running tasks: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(f.activeDir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil || !f.alive(r.PID) {
+			os.Remove(path)
+			continue
+		}
+		containers[name] = true
+		if r.GitPort != 0 {
+			ports[r.GitPort] = true
+		}
+	}
+	return containers, ports, nil
+}
+
+// keptContainers returns the task containers kept on purpose. Records of
+// kept containers that are no longer among containers are removed.
+func (f finder) keptContainers(containers []docker.TaskContainer) map[string]bool {
+	existing := map[string]bool{}
+	for _, c := range containers {
+		existing[c.Name] = true
+	}
+	kept := map[string]bool{}
+	entries, err := os.ReadDir(f.keptDir)
+	if err != nil {
+		return kept
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if !existing[entry.Name()] {
+			os.Remove(filepath.Join(f.keptDir, entry.Name()))
+			continue
+		}
+		kept[entry.Name()] = true
+	}
+	return kept
+}
+
+// processAlive reports whether the process pid is running
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// A process of another user can't be signalled, but is running
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// formatAge says roughly how long d is, in its largest whole unit
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	}
+}
+
+// Offer lists orphans on out and, if interactive, asks on in whether to
+// remove them. Without a terminal to ask on, they are only listed.
+func Offer(orphans []Orphan, interactive bool, in io.Reader, out io.Writer) {
+	if len(orphans) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Found %d leftovers of giverny tasks that are no longer running:\n", len(orphans))
+	for _, orphan := range orphans {
+		fmt.Fprintf(out, "  %s\n", orphan)
+	}
+	if !interactive {
+		fmt.Fprintf(out, "Run giverny in a terminal to remove them\n")
+		return
+	}
+	fmt.Fprint(out, "Remove them? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	if strings.TrimSpace(answer) != "y" {
+		return
+	}
+	for _, orphan := range orphans {
+		if err := orphan.Remove(); err != nil {
+			fmt.Fprintf(out, "Warning: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(out, "✓ Removed %s\n", orphan.Description)
+	}
+}
@@ -0,0 +1,217 @@
+package orphans
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"giverny/internal/docker"
+	"giverny/internal/git"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestRegister(t *testing.T) {
+	dir := t.TempDir()
+	release, err := register(dir, "giverny-task-1", 4242)
+	if err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	f := finder{activeDir: dir, alive: processAlive}
+	containers, ports, err := f.activeTasks()
+	if err != nil {
+		t.Fatalf("activeTasks() error = %v", err)
+	}
+	if !containers["giverny-task-1"] || !ports[4242] {
+		t.Errorf("expected the registered task to be active, got %v and %v", containers, ports)
+	}
+
+	release()
+	if _, err := os.Stat(filepath.Join(dir, "giverny-task-1.json")); !os.IsNotExist(err) {
+		t.Errorf("expected the record to be removed, got %v", err)
+	}
+}
+
+func TestActiveTasks_RemovesRecordsOfGoneProcesses(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := register(dir, "giverny-task-1", 4242); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	f := finder{activeDir: dir, alive: func(int) bool { return false }}
+	containers, ports, err := f.activeTasks()
+	if err != nil {
+		t.Fatalf("activeTasks() error = %v", err)
+	}
+	if len(containers) != 0 || len(ports) != 0 {
+		t.Errorf("expected no active tasks, got %v and %v", containers, ports)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "giverny-task-1.json")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale record to be removed, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := register(dir, "giverny-active", 4000); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := finder{
+		now:       now,
+		activeDir: dir,
+		containers: func() ([]docker.TaskContainer, error) {
+			return []docker.TaskContainer{
+				{Name: "giverny-active", Task: "giverny-active", Created: now.Add(-72 * time.Hour), Running: true},
+				{Name: "giverny-active-git", Task: "giverny-active", Created: now.Add(-72 * time.Hour), Running: true},
+				{Name: "giverny-crashed", Task: "giverny-crashed", Created: now.Add(-72 * time.Hour), Running: true},
+				{Name: "giverny-crashed-git", Task: "giverny-crashed", Created: now.Add(-72 * time.Hour), Running: true},
+				{Name: "giverny-failed", Task: "giverny-failed", Created: now.Add(-30 * time.Hour)},
+				{Name: "giverny-recent", Task: "giverny-recent", Created: now.Add(-time.Hour)},
+			}, nil
+		},
+		daemons: func() ([]git.Daemon, error) {
+			return []git.Daemon{
+				{PID: 10, Port: 4000, Age: 72 * time.Hour},
+				{PID: 11, Port: 5000, Age: 72 * time.Hour},
+				{PID: 12, Port: 6000, Age: time.Minute},
+			}, nil
+		},
+		alive: func(pid int) bool { return pid == os.Getpid() },
+	}
+
+	orphans, err := f.find(DefaultMaxAge)
+	if err != nil {
+		t.Fatalf("find() error = %v", err)
+	}
+	var got []string
+	for _, orphan := range orphans {
+		got = append(got, orphan.String())
+	}
+	want := []string{
+		"container giverny-crashed (running), started 3 days ago",
+		"container giverny-crashed-git (running), started 3 days ago",
+		"container giverny-failed, started 30 hours ago",
+		"git server on port 5000 (PID 11), started 3 days ago",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("find() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFind_SkipsKeptContainers(t *testing.T) {
+	keptDir := t.TempDir()
+	for _, name := range []string{"giverny-kept", "giverny-removed"} {
+		if err := keep(keptDir, name); err != nil {
+			t.Fatalf("keep() error = %v", err)
+		}
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := finder{
+		now:       now,
+		activeDir: t.TempDir(),
+		keptDir:   keptDir,
+		containers: func() ([]docker.TaskContainer, error) {
+			return []docker.TaskContainer{
+				{Name: "giverny-kept", Task: "giverny-kept", Created: now.Add(-72 * time.Hour)},
+				{Name: "giverny-failed", Task: "giverny-failed", Created: now.Add(-72 * time.Hour)},
+			}, nil
+		},
+		daemons: func() ([]git.Daemon, error) { return nil, nil },
+		alive:   processAlive,
+	}
+
+	orphans, err := f.find(DefaultMaxAge)
+	if err != nil {
+		t.Fatalf("find() error = %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Description != "container giverny-failed" {
+		t.Errorf("expected only giverny-failed to be an orphan, got %v", orphans)
+	}
+	// The record of a container that has gone is removed
+	if _, err := os.Stat(filepath.Join(keptDir, "giverny-removed")); !os.IsNotExist(err) {
+		t.Errorf("expected the record of the removed container to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(keptDir, "giverny-kept")); err != nil {
+		t.Errorf("expected the record of the kept container to stay, got %v", err)
+	}
+}
+
+func TestFind_Errors(t *testing.T) {
+	f := finder{
+		activeDir:  t.TempDir(),
+		containers: func() ([]docker.TaskContainer, error) { return nil, errors.New("cannot connect to the Docker daemon") },
+		daemons:    func() ([]git.Daemon, error) { return nil, nil },
+		alive:      processAlive,
+	}
+	if _, err := f.find(DefaultMaxAge); err == nil {
+		t.Error("expected an error when the containers can't be listed")
+	}
+}
+
+func TestOffer(t *testing.T) {
+	newOrphans := func(removed *[]string) []Orphan {
+		return []Orphan{
+			{Description: "container giverny-a", Age: 72 * time.Hour, remove: func() error {
+				*removed = append(*removed, "a")
+				return nil
+			}},
+			{Description: "container giverny-b", Age: 3 * time.Hour, remove: func() error {
+				return errors.New("failed to remove container giverny-b")
+			}},
+		}
+	}
+
+	t.Run("removes when the user agrees", func(t *testing.T) {
+		var removed []string
+		var out bytes.Buffer
+		Offer(newOrphans(&removed), true, strings.NewReader("y\n"), &out)
+		if len(removed) != 1 {
+			t.Errorf("expected giverny-a to be removed, got %v", removed)
+		}
+		for _, want := range []string{"Found 2 leftovers", "container giverny-b, started 3 hours ago", "✓ Removed container giverny-a", "Warning: failed to remove container giverny-b"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+			}
+		}
+	})
+
+	t.Run("keeps them otherwise", func(t *testing.T) {
+		var removed []string
+		var out bytes.Buffer
+		Offer(newOrphans(&removed), true, strings.NewReader("\n"), &out)
+		if len(removed) != 0 {
+			t.Errorf("expected nothing to be removed, got %v", removed)
+		}
+	})
+
+	t.Run("only lists them without a terminal", func(t *testing.T) {
+		var removed []string
+		var out bytes.Buffer
+		Offer(newOrphans(&removed), false, strings.NewReader("y\n"), &out)
+		if len(removed) != 0 || strings.Contains(out.String(), "[y/N]") {
+			t.Errorf("expected no question and nothing removed, got %v and:\n%s", removed, out.String())
+		}
+	})
+
+	t.Run("says nothing when there are none", func(t *testing.T) {
+		var out bytes.Buffer
+		Offer(nil, true, strings.NewReader("y\n"), &out)
+		if out.Len() != 0 {
+			t.Errorf("expected no output, got %q", out.String())
+		}
+	})
+}
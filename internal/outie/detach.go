@@ -0,0 +1,58 @@
+package outie
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// DetachedFlag is the hidden flag giverny is started again with to run a
+// task in the background.
+const DetachedFlag = "--detached"
+
+// DetachedLogPath returns where the output of a task run in the background is
+// written.
+func DetachedLogPath(containerName string) (string, error) {
+	return ArtifactPath(containerName, ".log")
+}
+
+// ArtifactPath returns where a file giverny produces for a task is kept: in
+// giverny's directory under the user cache directory, named after the task's
+// container with suffix appended.
+func ArtifactPath(containerName, suffix string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "giverny", containerName+suffix), nil
+}
+
+// StartDetached starts giverny again with args and DetachedFlag, in a new
+// session so that it outlives the terminal, with its output going to logPath.
+// It returns without waiting for the task.
+func StartDetached(args []string, logPath string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find giverny executable: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, append(args, DetachedFlag)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start task in the background: %w", err)
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,141 @@
+package outie
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"giverny/internal/describe"
+	"giverny/internal/gitops"
+)
+
+// hostMenuResult is what the user chose at the host menu
+type hostMenuResult struct {
+	keepContainer bool
+	// feedback is set to run the task again with it
+	feedback string
+}
+
+// retryRequest is returned by runTask when the user asks at the host menu for
+// the task to be run again on its branch, with feedback
+type retryRequest struct {
+	feedback     string
+	targetBranch string
+}
+
+func (r *retryRequest) Error() string {
+	return "the task is to be run again"
+}
+
+// retryPrompt is the prompt for running a task again with feedback on what
+// its previous run did
+func retryPrompt(prompt, feedback string) string {
+	return fmt.Sprintf("%s\n\nThe work on this branch so far is a previous attempt at this task. Feedback on it:\n%s", prompt, feedback)
+}
+
+// hostMenu offers what to do with the task branch once the container has
+// exited: merge it, verify it, open a pull request, delete it, keep the
+// container, or run the task again with feedback.
+func hostMenu(git gitops.GitOps, config Config, branchName, targetBranch string, input io.Reader) hostMenuResult {
+	reader := bufio.NewReader(input)
+	var result hostMenuResult
+	merged := false
+
+	for {
+		fmt.Printf("\nWhat would you like to do with %s?\n", branchName)
+		if !merged {
+			fmt.Printf("  [m] Merge into %s (fast-forward)\n", targetBranch)
+		}
+		fmt.Println("  [v] Run verification")
+		fmt.Println("  [o] Open a pull request")
+		fmt.Println("  [d] Delete the branch")
+		if !result.keepContainer {
+			fmt.Println("  [k] Keep the container")
+		}
+		fmt.Println("  [r] Retry with feedback")
+		fmt.Println("  [x] Exit")
+		fmt.Print("Choice: ")
+
+		choice, err := reader.ReadString('\n')
+		if err != nil && choice == "" {
+			// No more input: leave everything as it is
+			fmt.Println()
+			return result
+		}
+
+		switch strings.TrimSpace(choice) {
+		case "m":
+			if merged {
+				continue
+			}
+			if err := git.FastForwardBranch(targetBranch, branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			merged = true
+			fmt.Printf("✓ Merged %s into %s\n", branchName, targetBranch)
+		case "v":
+			commands := config.Verify
+			if len(commands) == 0 {
+				fmt.Print("Command to verify the branch with: ")
+				command, _ := reader.ReadString('\n')
+				if command = strings.TrimSpace(command); command == "" {
+					continue
+				}
+				commands = []string{command}
+			}
+			verifyBranch(git, branchName, commands)
+		case "o":
+			if err := openPullRequest(config, branchName, targetBranch); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		case "d":
+			if !merged {
+				fmt.Printf("%s has not been merged. Delete it anyway? [y/N] ", branchName)
+				answer, _ := reader.ReadString('\n')
+				if strings.TrimSpace(answer) != "y" {
+					continue
+				}
+			}
+			if err := git.DeleteBranch(branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			fmt.Printf("✓ Deleted %s\n", branchName)
+			return result
+		case "k":
+			result.keepContainer = true
+			fmt.Printf("The container will be kept\n")
+		case "r":
+			fmt.Println("What should be done differently? (one line)")
+			fmt.Print("> ")
+			feedback, _ := reader.ReadString('\n')
+			if feedback = strings.TrimSpace(feedback); feedback == "" {
+				continue
+			}
+			result.feedback = feedback
+			return result
+		case "x":
+			return result
+		default:
+			fmt.Println("Invalid choice.")
+		}
+	}
+}
+
+// openPullRequest opens a pull request for the task branch, described as
+// `giverny describe` would
+func openPullRequest(config Config, branchName, targetBranch string) error {
+	d, err := describe.Load(config.TaskID, branchName, targetBranch)
+	if err != nil {
+		return err
+	}
+	url, err := d.OpenPullRequest(d.Markdown())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Opened %s\n", url)
+	return nil
+}
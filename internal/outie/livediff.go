@@ -0,0 +1,66 @@
+package outie
+
+import (
+	"fmt"
+	"time"
+
+	gitpkg "giverny/internal/git"
+	"giverny/internal/gitops"
+	"giverny/internal/terminal"
+)
+
+// liveDiffPollInterval is how often outie looks for new checkpoints pushed by
+// innie while the container runs.
+const liveDiffPollInterval = 5 * time.Second
+
+// defaultLiveDiffInterval is the checkpoint interval used for --live-diff
+// when --checkpoint-interval is not given.
+const defaultLiveDiffInterval = time.Minute
+
+// watchCheckpoints follows the checkpoints innie pushes for branchName and
+// shows how many files the task has touched since fromRef in the terminal
+// title. The container has the terminal, so the title is the one place this
+// can be shown without getting in the agent's way. It runs until the returned
+// function is called.
+func watchCheckpoints(git gitops.GitOps, taskID, branchName, fromRef string, pollInterval time.Duration) func() {
+	ref := gitpkg.CheckpointsRef(branchName)
+	seen, _ := git.GetCommitHash(ref)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				checkpoint, err := git.GetCommitHash(ref)
+				if err != nil || checkpoint == seen {
+					continue
+				}
+				files, err := git.ChangedFilesBetween(fromRef, checkpoint)
+				if err != nil {
+					continue
+				}
+				seen = checkpoint
+				terminal.SetTitle(liveDiffTitle(taskID, len(files)))
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// liveDiffTitle returns the terminal title showing a task's progress
+func liveDiffTitle(taskID string, filesTouched int) string {
+	if filesTouched == 1 {
+		return fmt.Sprintf("Giverny: %s (1 file touched)", taskID)
+	}
+	return fmt.Sprintf("Giverny: %s (%d files touched)", taskID, filesTouched)
+}
@@ -0,0 +1,56 @@
+package outie
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"giverny/internal/gitops"
+)
+
+func TestWatchCheckpoints(t *testing.T) {
+	mockGit := gitops.NewMockGitOps()
+
+	var mu sync.Mutex
+	checkpoint := "old"
+	var diffed []string
+	mockGit.GetCommitHashFunc = func(ref string) (string, error) {
+		if ref != "refs/giverny/task-1/checkpoints" {
+			t.Errorf("unexpected ref %s", ref)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return checkpoint, nil
+	}
+	mockGit.ChangedFilesBetweenFunc = func(fromRef, toRef string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fromRef != "base" {
+			t.Errorf("expected diff from base, got %s", fromRef)
+		}
+		diffed = append(diffed, toRef)
+		return []string{"a.go", "b.go"}, nil
+	}
+
+	stop := watchCheckpoints(mockGit, "task-1", "giverny/task-1", "base", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	checkpoint = "new"
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	// The checkpoint from before the run is ignored and each new one is diffed once
+	if len(diffed) != 1 || diffed[0] != "new" {
+		t.Errorf("expected only the new checkpoint to be diffed, got %v", diffed)
+	}
+}
+
+func TestLiveDiffTitle(t *testing.T) {
+	if got := liveDiffTitle("task-1", 1); got != "Giverny: task-1 (1 file touched)" {
+		t.Errorf("unexpected title %q", got)
+	}
+	if got := liveDiffTitle("task-1", 3); got != "Giverny: task-1 (3 files touched)" {
+		t.Errorf("unexpected title %q", got)
+	}
+}
@@ -1,13 +1,36 @@
 package outie
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"giverny/internal/audit"
+	"giverny/internal/bench"
+	"giverny/internal/cmdutil"
 	"giverny/internal/ctrlsock"
+	dockerpkg "giverny/internal/docker"
 	"giverny/internal/dockerops"
+	"giverny/internal/extrafiles"
+	"giverny/internal/ghtoken"
+	gitpkg "giverny/internal/git"
 	"giverny/internal/gitops"
+	"giverny/internal/hooks"
+	"giverny/internal/mise"
+	"giverny/internal/nix"
+	"giverny/internal/orphans"
+	"giverny/internal/taskid"
+	"giverny/internal/taskspec"
+	"giverny/internal/taskstate"
 	"giverny/internal/terminal"
 )
 
@@ -15,6 +38,7 @@ import (
 type Config struct {
 	TaskID          string
 	Slug            string
+	BranchUser      string // puts the task branch under giverny/USER/, for --user-branch
 	Prompt          string
 	BaseImage       string
 	DockerArgs      string
@@ -23,20 +47,256 @@ type Config struct {
 	ShowBuildOutput bool
 	ForceRebuild    bool
 	ExistingBranch  bool
+	BaseBranch      string
+	TargetBranch    string
+	FromRef         string
+	CloneDepth      int
+	CloneFilter     string
+	OptimizeClone   bool
+	SparsePaths     []string
+	ReuseContainer  bool
+	PushRefspecs    []string
 	AllowDirty      bool
+	Autostash       bool
+	Force           bool
 	UseAmp          bool
+	Squash          bool
+	PushToRemote    string
+	Provenance      bool
+	DenyPaths       []string
+	StrictPolicy    bool
+	MaxFiles        int
+	MaxLines        int
+	MaxBinaryKB     int
+	NoSecretScan    bool
+	Summary         bool
+	ComplianceCmd   string
+	RequireSPDX     bool
+	AuditLog        string // host path to copy the container's command audit log to
+	SaveWIP         bool
+
+	// ScanPaths are directories in the container, such as /tmp, innie
+	// searches for files the agent created outside the workspace; those
+	// exported are copied alongside the task's other files (see ArtifactPath)
+	ScanPaths []string
+
+	// PlanFirst has the agent plan the task, for the user to approve, before
+	// it starts on it
+	PlanFirst bool
+	// Split has the agent split the task into sub-tasks run one after the
+	// other, each in a session of its own
+	Split bool
+	// TDD has the agent write failing tests for the task first, for the user
+	// to approve, then implement it until TestCmd passes
+	TDD bool
+	// TestCmd is run in the workspace before pushing, and between the
+	// phases of a TDD task
+	TestCmd string
+	// CoverageCmd reports the change in coverage before pushing, which
+	// blocks the push if it drops by more than MaxCoverageDrop points
+	CoverageCmd     string
+	MaxCoverageDrop float64
+	// Checks are commands innie runs in the workspace before each visit to
+	// the menu, showing whether each passed
+	Checks []string
+	// ReviewRounds is how many times a second agent, run with ReviewerArgs
+	// (AgentArgs if empty), reviews the changes after the agent's first run
+	ReviewRounds int
+	ReviewerArgs string
+
+	// CheckpointInterval is how often innie checkpoints the workspace (0 to never)
+	CheckpointInterval time.Duration
+	// LiveDiff shows the files touched so far in the terminal title as innie pushes checkpoints
+	LiveDiff bool
+
+	// Detached is set when giverny was started in the background by --detach:
+	// there is no terminal, so innie runs non-interactively
+	Detached bool
+	// HostMenu offers, once the task is done, to merge, verify or delete its
+	// branch, open a pull request, keep the container or run the task again
+	HostMenu bool
+	// Attachable keeps the agent and menu of a detached task interactive, in
+	// a container with a TTY that `giverny attach` connects to
+	Attachable bool
+	// Output says how the container's output is shown: with timestamps, and
+	// copied to a log file
+	Output dockerpkg.OutputOptions
+	// UnsafeDockerArgs runs the task even when DockerArgs would defeat the
+	// container's sandbox (see dockerpkg.DangerousDockerArgs)
+	UnsafeDockerArgs bool
+	// OrphanAge is how old the containers and git servers of tasks giverny
+	// is no longer running must be for giverny to offer to remove them when
+	// it starts (0 to never look)
+	OrphanAge time.Duration
+
+	// AutoCRLF and FileMode set core.autocrlf and core.fileMode in the
+	// container's clone
+	AutoCRLF string
+	FileMode string
+
+	// Hooks is passed on to innie; with hooks.ModeSkip the host's pre-commit
+	// hook is run on the task's changes before the merge instructions
+	Hooks string
+	// Verify lists commands run in a temporary worktree of the task branch
+	// after it is pushed back, whose results are shown with the merge
+	// instructions
+	Verify []string
+	// BenchCmd is a benchmark command run in temporary worktrees of the
+	// task's start and the task branch after it is pushed back, whose results
+	// are compared with the merge instructions, marking those that got worse
+	// by more than BenchThreshold percent
+	BenchCmd       string
+	BenchThreshold float64
+
+	// ScanImage scans the giverny-main image for vulnerabilities before the
+	// task starts; FailOnCritical (which implies it) stops the task if any
+	// are critical
+	ScanImage      bool
+	FailOnCritical bool
+	// SBOM is the format (docker.SBOMFormatSPDX or docker.SBOMFormatCycloneDX)
+	// of a software bill of materials to write for the giverny-main image
+	// alongside the task's other files (see ArtifactPath), or empty for none
+	SBOM string
+	// ImageRegistry is a registry (e.g. "ghcr.io/team") the giverny-main
+	// image is pulled from before building, and pushed to when it is built,
+	// so other machines can reuse it
+	ImageRegistry string
+	// Devcontainer bases giverny-main on the project's devcontainer instead
+	// of BaseImage
+	Devcontainer bool
+	// Nix installs Nix in the image and runs the agent in the project's
+	// flake dev shell
+	Nix bool
+	// Mise installs mise in the image and the runtimes the project pins in
+	// the container
+	Mise bool
+	// EnableDocker gives the task Docker: dockerpkg.DockerModeSocket mounts
+	// the host's socket, dockerpkg.DockerModeDinD runs a nested daemon
+	EnableDocker string
+	// GitBind is the address the git server on the host listens on (see
+	// defaultGitBind when empty)
+	GitBind string
+	// GitHost is the host the container reaches the git server on the host
+	// at, gitpkg.DefaultServerHost when empty
+	GitHost string
+	// Network is a Docker network to run the task on, with a git server
+	// sidecar on it serving the repository in place of the host's git server
+	Network string
+	// GitCredentials are hosts whose HTTPS git credentials the container's
+	// git may ask the host's credential helpers for, over the control server
+	GitCredentials []string
+	// GitHubRepos are OWNER/REPO names the container is given a short-lived
+	// read-only token for, served as the credential for github.com
+	GitHubRepos []string
+	// GitHubAPIURL is the GitHub API the token is minted with (default
+	// ghtoken.DefaultAPIURL)
+	GitHubAPIURL string
+	// Proxy is an HTTP(S) proxy URL for the image builds and the container,
+	// and CACert a PEM file of extra CA certificates to trust in them
+	Proxy  string
+	CACert string
+	// BuildNetrc and BuildNpmrc are credential files for private Go module
+	// and npm registries, used by the image builds only; GoPrivate is
+	// GOPRIVATE for the Go builds
+	BuildNetrc string
+	BuildNpmrc string
+	GoPrivate  string
+	// Diffreviewer and Beads choose the versions of the tools built into the
+	// image, and the checksums of their source
+	Diffreviewer dockerpkg.ToolVersion
+	Beads        dockerpkg.ToolVersion
+	// NoDiffreviewer and NoBeads leave those tools out of the image
+	NoDiffreviewer bool
+	NoBeads        bool
+	// Dotfiles is a directory of dotfiles to install in the image
+	Dotfiles string
+	// Packages and RunSteps are extra packages to install in the image and
+	// extra commands to run at the end of its build
+	Packages []string
+	RunSteps []string
+
+	// Version is giverny's version, recorded in the task state
+	Version string
 }
 
+// networkPattern matches a Docker network name
+var networkPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// gitHostPattern matches a host name or an IP address, IPv6 ones unbracketed
+var gitHostPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]*$`)
+
+// pushReportTimeout is how long to wait for innie's report of the pushed
+// commit after the container exits. The report is sent before innie exits, so
+// this only needs to cover delivery.
+const pushReportTimeout = 250 * time.Millisecond
+
 // Run executes the Outie workflow
 func Run(config Config) error {
+	if config.OrphanAge > 0 {
+		offerOrphanCleanup(config)
+	}
 	return RunWithDeps(config, gitops.NewRealGitOps(), dockerops.NewRealDockerOps())
 }
 
 // RunWithDeps executes the Outie workflow with injected dependencies
 func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) error {
+	for {
+		err := runTask(config, git, docker)
+		var retry *retryRequest
+		if !errors.As(err, &retry) {
+			return err
+		}
+		// Run the task again on its branch, which now exists
+		config.Prompt = retryPrompt(config.Prompt, retry.feedback)
+		config.TargetBranch = retry.targetBranch
+		config.ExistingBranch = true
+		config.BaseBranch = ""
+		config.FromRef = ""
+		config.ReuseContainer = false
+		fmt.Printf("\nRunning the task again with your feedback...\n")
+	}
+}
+
+// offerOrphanCleanup offers to remove the containers and git servers that
+// tasks whose giverny has gone, such as after a crash, left behind
+func offerOrphanCleanup(config Config) {
+	found, err := orphans.Find(config.OrphanAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to look for leftovers of earlier tasks: %v\n", err)
+		return
+	}
+	orphans.Offer(found, !config.Detached && dockerpkg.StdinIsTerminal(), os.Stdin, os.Stdout)
+}
+
+// checkDockerArgs refuses docker args that would defeat the container's
+// sandbox, unless unsafe is set, in which case it only warns about them.
+// Only the user's args are checked: those giverny adds itself, such as
+// for --enable-docker, are asked for explicitly.
+func checkDockerArgs(dockerArgs string, unsafe bool) error {
+	args, err := cmdutil.SplitArgs(dockerArgs)
+	if err != nil {
+		return fmt.Errorf("invalid --docker-args: %w", err)
+	}
+	reasons := dockerpkg.DangerousDockerArgs(args)
+	if len(reasons) == 0 {
+		return nil
+	}
+	if !unsafe {
+		return fmt.Errorf("--docker-args would defeat the container's sandbox:\n  %s\nPass --i-know-what-im-doing to run the task anyway", strings.Join(reasons, "\n  "))
+	}
+	fmt.Fprintf(os.Stderr, "Warning: --docker-args defeat the container's sandbox:\n  %s\n", strings.Join(reasons, "\n  "))
+	return nil
+}
+
+// runTask runs the task once. It returns a *retryRequest if the user asks at
+// the host menu for it to be run again.
+func runTask(config Config, git gitops.GitOps, docker dockerops.DockerOps) error {
 	// Save the current terminal title and set it to "Giverny: TASK-ID"
-	originalTitle := terminal.GetTitle()
-	terminal.SetTitle(fmt.Sprintf("Giverny: %s", config.TaskID))
+	var originalTitle string
+	if !config.Detached {
+		originalTitle = terminal.GetTitle()
+		terminal.SetTitle(fmt.Sprintf("Giverny: %s", config.TaskID))
+	}
 
 	// Restore the original title on exit
 	defer func() {
@@ -46,14 +306,28 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 	}()
 
 	// Find project root and change to it
-	projectRoot, err := findProjectRoot()
+	repo, err := gitpkg.Open(".")
 	if err != nil {
 		return fmt.Errorf("failed to find project root: %w", err)
 	}
+	projectRoot := repo.Path()
 	if err := os.Chdir(projectRoot); err != nil {
 		return fmt.Errorf("failed to change to project root: %w", err)
 	}
 
+	// The branch and container names are made from it
+	if err := taskid.Validate(config.TaskID); err != nil {
+		return fmt.Errorf("invalid TASK-ID: %w", err)
+	}
+
+	if err := taskspec.CheckPrompt(config.Prompt); err != nil {
+		return fmt.Errorf("invalid --prompt: %w", err)
+	}
+
+	if err := checkDockerArgs(config.DockerArgs, config.UnsafeDockerArgs); err != nil {
+		return err
+	}
+
 	// Validate agent token is set
 	if config.UseAmp {
 		if os.Getenv("AMP_API_KEY") == "" {
@@ -65,25 +339,137 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 		}
 	}
 
-	// Check for uncommitted changes before creating branch (unless --allow-dirty is set)
-	if !config.AllowDirty && !config.ExistingBranch {
+	if config.ExistingBranch && config.BaseBranch != "" {
+		return fmt.Errorf("--existing-branch and --branch cannot be used together")
+	}
+	if config.FromRef != "" && (config.ExistingBranch || config.BaseBranch != "") {
+		return fmt.Errorf("--from cannot be used with --existing-branch or --branch")
+	}
+	if config.Autostash && config.AllowDirty {
+		return fmt.Errorf("--autostash and --allow-dirty cannot be used together")
+	}
+	if config.PushToRemote != "" {
+		exists, err := git.RemoteExists(config.PushToRemote)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("invalid --push-to-remote: the repository has no remote named '%s'", config.PushToRemote)
+		}
+	}
+
+	if config.Nix && !nix.HasFlake(".") {
+		return fmt.Errorf("--nix needs a %s in the project root", nix.FlakeFile)
+	}
+	if config.Mise && len(mise.Detect(".")) == 0 {
+		return fmt.Errorf("--mise needs one of %s in the project root", strings.Join(mise.Configs, ", "))
+	}
+
+	switch config.EnableDocker {
+	case dockerpkg.DockerModeSocket:
+		fmt.Fprintf(os.Stderr, "WARNING: --enable-docker=%s mounts the host's Docker socket into the container.\n", config.EnableDocker)
+		fmt.Fprintf(os.Stderr, "WARNING: The agent can start privileged containers and mount any host path: this is root on the host.\n")
+	case dockerpkg.DockerModeDinD:
+		fmt.Fprintf(os.Stderr, "WARNING: --enable-docker=%s runs the container with --privileged, which weakens its isolation from the host.\n", config.EnableDocker)
+	}
+
+	if config.GitBind != "" && net.ParseIP(config.GitBind) == nil {
+		return fmt.Errorf("invalid --git-bind: '%s' is not an IP address", config.GitBind)
+	}
+	if config.GitHost != "" && !gitHostPattern.MatchString(config.GitHost) {
+		return fmt.Errorf("invalid --git-host: '%s' is not a host name or IP address", config.GitHost)
+	}
+	if config.Network != "" && !networkPattern.MatchString(config.Network) {
+		return fmt.Errorf("invalid --network: '%s' is not a Docker network name", config.Network)
+	}
+	for _, host := range config.GitCredentials {
+		if err := gitpkg.ValidateCredentialHost(host); err != nil {
+			return fmt.Errorf("invalid --git-credential: %w", err)
+		}
+	}
+	fillCredential := gitpkg.FillCredential
+	if len(config.GitHubRepos) > 0 {
+		apiURL := config.GitHubAPIURL
+		if apiURL == "" {
+			apiURL = ghtoken.DefaultAPIURL
+		}
+		source, err := ghtoken.NewSource(config.GitHubRepos, apiURL)
+		if err != nil {
+			return fmt.Errorf("invalid --github-repo: %w", err)
+		}
+		// Get the first token now, so a misconfiguration shows before the task starts
+		if _, err := source.Token(); err != nil {
+			return err
+		}
+		fillCredential = githubCredential(source, gitpkg.FillCredential)
+		if !slices.Contains(config.GitCredentials, ghtoken.Host) {
+			config.GitCredentials = append(slices.Clone(config.GitCredentials), ghtoken.Host)
+		}
+	}
+
+	// Only tags and notes may be pushed back in addition to the task branch
+	for _, refspec := range config.PushRefspecs {
+		if err := gitpkg.ValidatePushRefspec(refspec); err != nil {
+			return fmt.Errorf("invalid --push-ref: %w", err)
+		}
+	}
+
+	containerName := dockerpkg.ContainerName(config.TaskID, config.Slug)
+
+	// Look for a container kept from an earlier run of this task
+	var reused *dockerpkg.ContainerInfo
+	if config.ReuseContainer {
+		info, err := docker.InspectContainer(containerName)
+		if err != nil {
+			return fmt.Errorf("failed to check for existing container: %w", err)
+		}
+		if info != nil && info.GitServerPort == 0 {
+			return fmt.Errorf("container '%s' was not started by giverny", containerName)
+		}
+		reused = info
+	}
+
+	// Check for uncommitted changes before creating branch (unless --allow-dirty is set),
+	// and that HEAD is somewhere to start a task from (unless --force is set).
+	// With --branch the task branch is created inside the container, and with
+	// --from it does not start at HEAD, so the host workspace is not involved.
+	// A reused container set up its branch on the earlier run.
+	startsAtHead := !config.ExistingBranch && config.BaseBranch == "" && config.FromRef == "" && reused == nil
+	if startsAtHead && !config.Force {
+		if err := checkHead(git); err != nil {
+			return err
+		}
+	}
+	if !config.AllowDirty && startsAtHead {
 		isDirty, err := git.IsWorkspaceDirty()
 		if err != nil {
 			return fmt.Errorf("failed to check workspace status: %w", err)
 		}
-		if isDirty {
+		if isDirty && !config.Autostash {
 			return fmt.Errorf("working directory has uncommitted changes. Commit or stash them first, or use --allow-dirty flag")
 		}
+		if isDirty {
+			stash, err := git.StashChanges("giverny autostash for " + config.TaskID)
+			if err != nil {
+				return err
+			}
+			if stash != "" {
+				fmt.Printf("Stashed your uncommitted changes; they will be restored when the task finishes\n")
+				defer restoreStash(git, stash)
+			}
+		}
 	}
 
-	// Create or validate git branch for this task
-	var branchName string
-	if config.Slug != "" {
-		branchName = fmt.Sprintf("giverny/%s-%s", config.TaskID, config.Slug)
-	} else {
-		branchName = fmt.Sprintf("giverny/%s", config.TaskID)
+	// A reused container already has its clone
+	if reused == nil {
+		config = optimizeClone(git, config)
 	}
-	if config.ExistingBranch {
+
+	// Create or validate git branch for this task
+	branchName := taskid.BranchName(config.BranchUser, config.TaskID, config.Slug)
+	if reused != nil {
+		fmt.Printf("Reusing container %s for branch: %s\n", containerName, branchName)
+	} else if config.ExistingBranch {
 		// Validate that the branch exists
 		exists, err := git.BranchExists(branchName)
 		if err != nil {
@@ -93,42 +479,147 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 			return fmt.Errorf("branch '%s' does not exist", branchName)
 		}
 		fmt.Printf("Using existing branch: %s\n", branchName)
+	} else if config.BaseBranch != "" {
+		// Validate that the base branch exists and the task branch does not;
+		// innie creates the task branch from the base branch in the container
+		exists, err := git.BranchExists(config.BaseBranch)
+		if err != nil {
+			return fmt.Errorf("failed to check if branch exists: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("branch '%s' does not exist", config.BaseBranch)
+		}
+		exists, err = git.BranchExists(branchName)
+		if err != nil {
+			return fmt.Errorf("failed to check if branch exists: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("branch '%s' already exists", branchName)
+		}
+		fmt.Printf("Branch %s will be created from %s\n", branchName, config.BaseBranch)
 	} else {
 		// Create new branch
-		if err := git.CreateBranch(branchName); err != nil {
+		if err := git.CreateBranch(branchName, config.FromRef); err != nil {
 			return fmt.Errorf("failed to create branch: %w", err)
 		}
-		fmt.Printf("Created branch: %s\n", branchName)
+		if config.FromRef != "" {
+			fmt.Printf("Created branch: %s (from %s)\n", branchName, config.FromRef)
+		} else {
+			fmt.Printf("Created branch: %s\n", branchName)
+		}
 	}
 
-	// Start git server
-	serverCmd, gitPort, err := git.StartServer(projectRoot)
-	if err != nil {
-		return fmt.Errorf("failed to start git server: %w", err)
+	// Start git server. A reused container's clone points at the port of the
+	// earlier run's server, so use the same port if it can be had. The
+	// repository is served under a token only the container is told, so
+	// that other processes on the host can't reach it. On a network of its
+	// own, the task gets a git server sidecar instead, once the image is
+	// built, serving under the token too.
+	var serverCmd *gitpkg.ServerCmd
+	var gitPort int
+	var gitToken string
+	if reused != nil {
+		// A container started before giverny used tokens has none
+		gitToken = reused.GitToken
+	} else if gitToken, err = gitpkg.NewServerToken(); err != nil {
+		return err
 	}
-	// Ensure server is stopped on exit
-	defer func() {
-		if err := git.StopServer(serverCmd); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to stop git server: %v\n", err)
+	bindAddr := config.GitBind
+	if config.Network == "" {
+		if bindAddr == "" {
+			bindAddr = defaultGitBind(docker)
 		}
-	}()
-	if config.Debug {
-		fmt.Printf("Started git server on port: %d\n", gitPort)
+		if reused != nil {
+			serverCmd, gitPort, err = startReusedServer(git, docker, repo, containerName, bindAddr, gitToken, branchName, reused)
+		} else {
+			serverCmd, gitPort, err = git.StartServer(projectRoot, bindAddr, gitToken, branchName)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to start git server: %w", err)
+		}
+		// Ensure server is stopped on exit
+		defer func() {
+			if err := git.StopServer(serverCmd); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to stop git server: %v\n", err)
+			}
+		}()
+		if config.Debug {
+			fmt.Printf("Started git server on port: %d\n", gitPort)
+		}
+		// A daemon that can't serve would otherwise only show up as a
+		// clone error in the container, after the image build
+		if err := git.CheckServer(bindAddr, gitPort, gitToken); err != nil {
+			return fmt.Errorf("git server failed its health check: %w", err)
+		}
+		// Restart the daemon on the same port if it dies, as the container
+		// can't be told about another one
+		git.SuperviseServer(serverCmd, projectRoot, gitPort, func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+		})
 	}
 
-	// Build giverny Docker image
-	if err := docker.BuildImage(config.BaseImage, config.ShowBuildOutput, config.ForceRebuild, config.Debug); err != nil {
-		return fmt.Errorf("failed to build image: %w", err)
+	// While the task runs, its containers and git server aren't leftovers
+	hostGitPort := 0
+	if serverCmd != nil {
+		hostGitPort = gitPort
 	}
-
-	// Start control server for innie-to-outie communication
-	var containerName string
-	if config.Slug != "" {
-		containerName = fmt.Sprintf("giverny-%s-%s", config.TaskID, config.Slug)
+	if release, err := orphans.Register(containerName, hostGitPort); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	} else {
-		containerName = fmt.Sprintf("giverny-%s", config.TaskID)
+		defer release()
 	}
-	ctrlListener, err := ctrlsock.Listen(containerName, config.Debug)
+
+	// Build giverny Docker image (a reused container already has its image)
+	if reused == nil {
+		if config.Devcontainer {
+			baseImage, err := docker.DevcontainerImage(projectRoot, config.ShowBuildOutput, config.Debug)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Using devcontainer image: %s\n", baseImage)
+			config.BaseImage = baseImage
+		}
+		if err := buildImage(docker, config); err != nil {
+			return err
+		}
+		if config.ScanImage || config.FailOnCritical {
+			if err := scanImage(docker, dockerpkg.MainImageName(config.BaseImage), config.FailOnCritical); err != nil {
+				return err
+			}
+		}
+		if config.SBOM != "" {
+			writeSBOM(docker, dockerpkg.MainImageName(config.BaseImage), config.SBOM, containerName)
+		}
+	}
+
+	// Serve the repository to the task's network from a sidecar, so that no
+	// git server is exposed on the host
+	gitSidecar := dockerpkg.GitSidecarName(containerName)
+	if config.Network != "" {
+		if err := docker.EnsureNetwork(config.Network, config.Debug); err != nil {
+			return err
+		}
+		if err := docker.StartGitSidecar(gitSidecar, config.Network, dockerpkg.MainImageName(config.BaseImage), projectRoot, gitToken, branchName, config.Debug); err != nil {
+			return err
+		}
+		defer func() {
+			if err := docker.StopGitSidecar(gitSidecar); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}()
+		gitPort = dockerpkg.GitSidecarPort
+	}
+
+	// Start control server for innie-to-outie communication. A reused
+	// container's environment holds the earlier run's address, so use the
+	// same port.
+	ctrlPort := 0
+	if reused != nil {
+		if _, portStr, err := net.SplitHostPort(reused.CtrlAddr); err == nil {
+			ctrlPort, _ = strconv.Atoi(portStr)
+		}
+	}
+	ctrlListener, err := ctrlsock.ListenOnPort(containerName, ctrlPort, config.Debug)
 	if err != nil {
 		return fmt.Errorf("failed to start control server: %w", err)
 	}
@@ -136,11 +627,35 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 	if config.Debug {
 		fmt.Printf("Control server listening on port: %d\n", ctrlListener.Port())
 	}
+	if len(config.GitCredentials) > 0 && gitToken == "" {
+		fmt.Fprintf(os.Stderr, "Warning: the container was created without a git server token, so its git can't be given credentials\n")
+	} else if len(config.GitCredentials) > 0 {
+		// The git server's token is in the container's environment, so
+		// it doubles as the token credential requests must give
+		ctrlListener.ServeCredentials(gitToken, credentialServer(config.GitCredentials, fillCredential))
+	}
 
-	// Pass the control server address to the container via env var.
-	// Innie connects to host.docker.internal to reach the host.
+	// Pass the control server address, and any proxy, to the container via
+	// env vars. Innie connects to host.docker.internal to reach the host.
 	ctrlAddr := fmt.Sprintf("host.docker.internal:%d", ctrlListener.Port())
 	ctrlArgs := fmt.Sprintf("--env %s=%s", ctrlsock.EnvVar, ctrlAddr)
+	for _, env := range dockerpkg.ProxyEnv(config.Proxy) {
+		ctrlArgs += " --env " + cmdutil.QuoteArg(env)
+	}
+	if config.EnableDocker != "" {
+		ctrlArgs += " " + dockerpkg.NestedDockerArgs(config.EnableDocker)
+	}
+	if config.Network != "" {
+		ctrlArgs += " " + dockerpkg.NetworkArgs(config.Network)
+	}
+	// The URL holds the git server's token, so only its name goes on the
+	// docker command line, where any local user could read it; the docker
+	// CLI passes the value on from its environment, as it does the agent's
+	// token
+	if err := os.Setenv(gitpkg.ServerURLEnv, gitURL(config, gitSidecar, gitPort, gitToken)); err != nil {
+		return fmt.Errorf("failed to set %s: %w", gitpkg.ServerURLEnv, err)
+	}
+	ctrlArgs += " --env " + gitpkg.ServerURLEnv
 	if config.DockerArgs != "" {
 		config.DockerArgs = config.DockerArgs + " " + ctrlArgs
 	} else {
@@ -156,11 +671,173 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 		}
 	}
 
-	// Run the container with Innie
-	exitCode, err := docker.RunContainer(config.TaskID, config.Slug, config.Prompt, config.BaseImage, gitPort, config.DockerArgs, config.AgentArgs, config.Debug, config.UseAmp)
+	// Work out which branch the changes will be merged into. A task started
+	// with --branch is compared against that branch unless --base says otherwise.
+	targetBranch := config.TargetBranch
+	if targetBranch == "" {
+		targetBranch = config.BaseBranch
+	}
+	if targetBranch == "" {
+		targetBranch = git.DefaultBranch()
+	}
+
+	// Describe the task for Innie
+	spec := taskspec.Spec{
+		TaskID:        config.TaskID,
+		Slug:          config.Slug,
+		BranchUser:    config.BranchUser,
+		Prompt:        config.Prompt,
+		GitServerPort: gitPort,
+		AgentArgs:     config.AgentArgs,
+		UseAmp:        config.UseAmp,
+		Debug:         config.Debug,
+		BaseBranch:    config.BaseBranch,
+		TargetBranch:  targetBranch,
+		CloneDepth:    config.CloneDepth,
+		CloneFilter:   config.CloneFilter,
+		AutoCRLF:      config.AutoCRLF,
+		FileMode:      config.FileMode,
+		SparsePaths:   config.SparsePaths,
+		PushRefspecs:  config.PushRefspecs,
+		DenyPaths:     config.DenyPaths,
+		StrictPolicy:  config.StrictPolicy,
+		MaxFiles:      config.MaxFiles,
+		MaxLines:      config.MaxLines,
+		MaxBinaryKB:   config.MaxBinaryKB,
+		ComplianceCmd: config.ComplianceCmd,
+		RequireSPDX:   config.RequireSPDX,
+		Summary:       config.Summary,
+		NoSecretScan:  config.NoSecretScan,
+		PlanFirst:     config.PlanFirst,
+		Split:         config.Split,
+		TDD:           config.TDD,
+		TestCmd:       config.TestCmd,
+		CoverageCmd:   config.CoverageCmd,
+		CoverageDrop:  config.MaxCoverageDrop,
+		Checks:        config.Checks,
+		ReviewRounds:  config.ReviewRounds,
+		ReviewerArgs:  config.ReviewerArgs,
+		Audit:         config.AuditLog != "",
+		ScanPaths:     config.ScanPaths,
+		SaveWIP:       config.SaveWIP,
+		LiveDiff:      config.LiveDiff,
+		// Without a terminal, innie runs without the menu
+		NonInteractive: config.Detached && !config.Attachable,
+		Hooks:          config.Hooks,
+		Nix:            config.Nix,
+		Mise:           config.Mise,
+		EnableDocker:   config.EnableDocker,
+		GitCredentials: config.GitCredentials,
+		Provenance:     config.Provenance,
+		HostUser:       docker.HostUser(),
+	}
+	if !config.Mise {
+		if configs := mise.Detect("."); len(configs) > 0 {
+			fmt.Printf("Note: this repository pins runtime versions in %s, which are not installed in the container. Use --mise to install them.\n", strings.Join(configs, ", "))
+		}
+	}
+	if config.Hooks == "" {
+		if frameworks := hooks.Detect("."); len(frameworks) > 0 {
+			fmt.Printf("Note: this repository uses %s hooks, which are not installed in the container. Use --hooks install or --hooks skip to handle them.\n", hooks.Names(frameworks))
+		}
+	}
+	if config.LiveDiff && config.CheckpointInterval == 0 {
+		config.CheckpointInterval = defaultLiveDiffInterval
+	}
+	if config.CheckpointInterval > 0 {
+		spec.CheckpointInterval = config.CheckpointInterval.String()
+	}
+	if config.Provenance {
+		if digest, err := docker.ImageDigest(config.BaseImage); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to get base image digest: %v\n", err)
+		} else {
+			spec.BaseImageDigest = digest
+		}
+	}
+
+	// A prompt too long for the container's environment is mounted instead
+	if reused == nil {
+		promptArgs, err := spillPrompt(repo, containerName, &spec)
+		if err != nil {
+			return err
+		}
+		if promptArgs != "" {
+			config.DockerArgs += " " + promptArgs
+		}
+	}
+
+	// Record how the task's environment was set up, for `giverny reproduce`.
+	// A reused container keeps the state of the run that created it.
+	if reused == nil {
+		startRef := branchName
+		if config.BaseBranch != "" {
+			startRef = config.BaseBranch
+		}
+		if err := saveTaskState(repo, git, docker, config, containerName, branchName, startRef, serverCmd != nil, gitPort); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record task state: %v\n", err)
+		}
+	}
+
+	// Note where the WIP ref is, so a stale one from an earlier run isn't reported
+	wipRef := gitpkg.WIPRef(branchName)
+	var wipBefore string
+	if config.SaveWIP {
+		wipBefore, _ = git.GetCommitHash(wipRef)
+	}
+
+	// Follow the checkpoints innie pushes while the container runs. Files
+	// touched are counted from where the task branch is now, or from the
+	// branch it will be created from in the container.
+	if config.LiveDiff && !config.Detached {
+		fromRef := branchName
+		if config.BaseBranch != "" && reused == nil {
+			fromRef = config.BaseBranch
+		}
+		if fromCommit, err := git.GetCommitHash(fromRef); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: not showing live diff: %v\n", err)
+		} else {
+			defer watchCheckpoints(git, config.TaskID, branchName, fromCommit, liveDiffPollInterval)()
+		}
+	}
+
+	// The image build can take minutes, so check the git server is still
+	// serving before the container needs it
+	if serverCmd != nil {
+		if err := git.CheckServer(bindAddr, gitPort, gitToken); err != nil {
+			return fmt.Errorf("git server stopped before the container started: %w", err)
+		}
+	}
+
+	// Keep serving git to the container if the terminal goes away: innie
+	// carries on in its session and still needs to push
+	signal.Ignore(syscall.SIGHUP)
+
+	// Run the container with Innie, or restart the reused one
+	var exitCode int
+	if reused != nil {
+		exitCode, err = docker.StartContainer(containerName, config.Output)
+	} else if config.Detached && config.Attachable {
+		exitCode, err = docker.RunContainerAttachable(spec, config.BaseImage, config.DockerArgs)
+	} else {
+		exitCode, err = docker.RunContainer(spec, config.BaseImage, config.DockerArgs, config.Output)
+	}
 
 	// Post-container cleanup
 
+	// Export the audit log whether or not the task succeeded
+	if config.AuditLog != "" {
+		if err := docker.CopyFromContainer(containerName, audit.LogPath, config.AuditLog); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to export audit log: %v\n", err)
+		} else {
+			fmt.Printf("Audit log written to %s\n", config.AuditLog)
+		}
+	}
+
+	// Likewise the files exported from outside the workspace
+	if len(config.ScanPaths) > 0 {
+		exportExtraFiles(docker, containerName)
+	}
+
 	if err != nil || exitCode != 0 {
 		// On failure: keep container for debugging, print error
 		fmt.Fprintf(os.Stderr, "\n❌ Task failed\n")
@@ -169,9 +846,17 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 		} else {
 			fmt.Fprintf(os.Stderr, "Container exited with code %d\n", exitCode)
 		}
+		keepContainer(containerName)
 		fmt.Fprintf(os.Stderr, "Container '%s' has been kept for debugging\n", containerName)
 		fmt.Fprintf(os.Stderr, "To inspect: docker logs %s\n", containerName)
 		fmt.Fprintf(os.Stderr, "To remove: docker rm %s\n", containerName)
+		fmt.Fprintf(os.Stderr, "To retry in the same container: %s\n", retryCommand(config))
+		if config.SaveWIP {
+			if wip, err := git.GetCommitHash(wipRef); err == nil && wip != wipBefore {
+				fmt.Fprintf(os.Stderr, "Uncommitted changes were saved to %s\n", wipRef)
+				fmt.Fprintf(os.Stderr, "To inspect: git show %s\n", wipRef)
+			}
+		}
 
 		if err != nil {
 			return fmt.Errorf("container failed: %w", err)
@@ -179,22 +864,82 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 		return fmt.Errorf("container exited with code %d", exitCode)
 	}
 
+	// Verify that the commit innie reports pushing is what arrived on the host
+	if pushedCommit, ok := ctrlListener.WaitForPush(branchName, pushReportTimeout); ok {
+		hostCommit, err := git.GetCommitHash(branchName)
+		if err != nil {
+			return fmt.Errorf("push verification failed: %w", err)
+		}
+		if hostCommit != pushedCommit {
+			keepContainer(containerName)
+			fmt.Fprintf(os.Stderr, "Container '%s' has been kept for debugging\n", containerName)
+			return fmt.Errorf("push verification failed: %s is at %s on the host but innie pushed %s", branchName, hostCommit, pushedCommit)
+		}
+		if config.Debug {
+			fmt.Printf("Verified %s is at %s\n", branchName, hostCommit)
+		}
+	}
+
 	// On success: remove container, print success
 	fmt.Printf("\n✓ Task completed successfully\n")
-	if config.Debug {
-		fmt.Printf("Removing container...\n")
-	}
-	if err := docker.RemoveContainer(containerName); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to remove container: %v\n", err)
+	if config.Summary {
+		// Read before squashing, which moves the branch off the noted commit
+		if summary := git.ReadSummaryNote(branchName); summary != "" {
+			fmt.Printf("\nSummary:\n%s\n\n", summary)
+		}
 	}
 
 	// Get commit range for merge/cherry-pick instructions
-	firstCommit, lastCommit, err := git.GetBranchCommitRange(branchName)
+	hasCommits := false
+	firstCommit, lastCommit, err := git.GetBranchCommitRange(branchName, targetBranch)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to get commit range: %v\n", err)
 	} else if firstCommit != "" && lastCommit != "" {
+		// Collapse the task branch into a single commit if requested
+		if config.Squash && firstCommit != lastCommit {
+			message := fmt.Sprintf("%s\n\n%s", branchName, config.Prompt)
+			squashed, err := git.SquashBranch(branchName, firstCommit, message)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to squash branch: %v\n", err)
+			} else {
+				fmt.Printf("Squashed %s into a single commit\n", branchName)
+				firstCommit, lastCommit = squashed, squashed
+			}
+		}
+
+		// The task's changes are those since the branch left the target
+		// branch; firstCommit^ doesn't exist if firstCommit is a root commit
+		start := ""
+		if config.Hooks == hooks.ModeSkip || config.BenchCmd != "" {
+			if start, err = git.MergeBase(targetBranch, branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to find where %s left %s to check its changes: %v\n", branchName, targetBranch, err)
+			}
+		}
+
+		// Run the hooks the agent's commits skipped
+		if config.Hooks == hooks.ModeSkip && start != "" {
+			verifyHooks(git, start, lastCommit)
+		}
+
+		// Check the branch is safe to merge
+		if len(config.Verify) > 0 {
+			verifyBranch(git, branchName, config.Verify)
+		}
+		if config.BenchCmd != "" && start != "" {
+			compareBenchmarks(git, start, branchName, config.BenchCmd, config.BenchThreshold)
+		}
+
+		// Share the branch now that it won't change
+		if config.PushToRemote != "" {
+			if err := git.PushBranchToRemote(config.PushToRemote, branchName); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			} else {
+				fmt.Printf("\nPushed %s to %s\n", branchName, config.PushToRemote)
+			}
+		}
+
 		// Only show merge instructions if branch has commits
-		fmt.Printf("\nTo merge the changes into your main branch:\n")
+		fmt.Printf("\nTo merge the changes into %s:\n", targetBranch)
 		fmt.Printf("  %s\n", terminal.Blue(fmt.Sprintf("git merge --ff-only %s", branchName)))
 
 		// Convert to short hashes for display
@@ -212,30 +957,493 @@ func RunWithDeps(config Config, git gitops.GitOps, docker dockerops.DockerOps) e
 
 		fmt.Printf("\nTo delete the branch:\n")
 		fmt.Printf("  %s\n", terminal.Blue(fmt.Sprintf("git branch -D %s", branchName)))
+		hasCommits = true
 	}
 
+	// With a terminal, offer to do those things, or more, here
+	var choice hostMenuResult
+	if hasCommits && config.HostMenu && !config.Detached {
+		choice = hostMenu(git, config, branchName, targetBranch, os.Stdin)
+	}
+
+	if choice.keepContainer && choice.feedback == "" {
+		keepContainer(containerName)
+		fmt.Printf("Container '%s' has been kept; remove it with: docker rm %s\n", containerName, containerName)
+		return nil
+	}
+	if config.Debug {
+		fmt.Printf("Removing container...\n")
+	}
+	if err := docker.RemoveContainer(containerName); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove container: %v\n", err)
+	}
+	if choice.feedback != "" {
+		return &retryRequest{feedback: choice.feedback, targetBranch: targetBranch}
+	}
 	return nil
 }
 
-// findProjectRoot finds the project root by looking for .git directory
-func findProjectRoot() (string, error) {
-	dir, err := os.Getwd()
+// retryCommand returns the command that runs the task again in its kept
+// container
+func retryCommand(config Config) string {
+	return "giverny --reuse-container " + TaskArgs(config.TaskID, config.Slug, config.BranchUser)
+}
+
+// TaskArgs returns the arguments that name a task to giverny's commands: the
+// flags that name its container and branch, followed by its ID
+func TaskArgs(taskID, slug, branchUser string) string {
+	args := ""
+	if slug != "" {
+		args += "--slug " + slug + " "
+	}
+	if branchUser != "" {
+		args += "--user-branch "
+	}
+	return args + taskID
+}
+
+// keepContainer records that the container containerName is kept on
+// purpose, so that it isn't offered for removal with the leftovers of tasks
+func keepContainer(containerName string) {
+	if err := orphans.Keep(containerName); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// optimizeClone tells the user when the repository is large enough for the
+// clone into the container to be slow, and what would speed it up. With
+// --optimize-clone it makes the clone partial itself, unless it was already
+// limited with --clone-filter or --clone-depth.
+func optimizeClone(git gitops.GitOps, config Config) Config {
+	size, err := git.MeasureRepo()
 	if err != nil {
-		return "", err
+		if config.Debug {
+			fmt.Printf("Failed to measure the repository: %v\n", err)
+		}
+		return config
+	}
+	if !size.Large() {
+		return config
 	}
 
-	// Walk up the directory tree looking for .git
-	for {
-		gitPath := filepath.Join(dir, ".git")
-		if info, err := os.Stat(gitPath); err == nil && info.IsDir() {
-			return dir, nil
+	fmt.Printf("This repository is large: %s in %d files\n", dockerpkg.FormatSize(size.Bytes), size.Files)
+	if config.CloneFilter == "" && config.CloneDepth == 0 {
+		partial := ""
+		if size.PartialBytes > 0 {
+			partial = fmt.Sprintf(", about %s to clone instead of %s", dockerpkg.FormatSize(size.PartialBytes), dockerpkg.FormatSize(size.Bytes))
+		}
+		if config.OptimizeClone {
+			config.CloneFilter = "blob:none"
+			fmt.Printf("Cloning it with --clone-filter blob:none, which fetches file contents as they are needed%s\n", partial)
+		} else {
+			fmt.Printf("Cloning it into the container will take a while. --clone-filter blob:none fetches file contents only as they are needed%s, and --clone-depth N only the last N commits. --optimize-clone does the first for you\n", partial)
+		}
+	}
+	if size.Files > gitpkg.LargeRepoFiles && len(config.SparsePaths) == 0 {
+		fmt.Printf("Checking out %d files will take a while too; --sparse DIR checks out only the directories the task needs\n", size.Files)
+	}
+	return config
+}
+
+// restoreStash puts back the changes --autostash stashed, which stay on the
+// stash if that fails
+func restoreStash(git gitops.GitOps, stash string) {
+	if err := git.RestoreStash(stash); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Resolve any conflicts, then remove the stash with: git stash drop\n")
+		return
+	}
+	fmt.Printf("Restored your stashed changes\n")
+}
+
+// checkHead refuses to start a task from HEAD while the repository is in the
+// middle of an operation such as a rebase, or HEAD is on a branch matching a
+// gitpkg.ProtectedBranchKey pattern
+func checkHead(git gitops.GitOps) error {
+	operation, err := git.InProgressOperation()
+	if err != nil {
+		return err
+	}
+	if operation != "" {
+		return fmt.Errorf("a %s is in progress in the repository. Finish or abort it first, or use --force", operation)
+	}
+
+	branch, err := git.CurrentBranch()
+	if err != nil || branch == "" {
+		return err
+	}
+	patterns, err := git.ProtectedBranches()
+	if err != nil {
+		return err
+	}
+	if pattern := gitpkg.MatchProtectedBranch(branch, patterns); pattern != "" {
+		return fmt.Errorf("HEAD is on %s, which matches protected branch pattern '%s' (%s). Check out another branch or use --branch, or use --force", branch, pattern, gitpkg.ProtectedBranchKey)
+	}
+	return nil
+}
+
+// verifyHooks runs the host's pre-commit hook on the task's changes between
+// fromRef and toRef and reports the result. A failure does not fail the task:
+// the changes are on the branch either way.
+func verifyHooks(git gitops.GitOps, fromRef, toRef string) {
+	output, passed, err := git.RunPreCommitHook(fromRef, toRef)
+	switch {
+	case errors.Is(err, gitpkg.ErrNoPreCommitHook):
+		fmt.Printf("\nNo pre-commit hook is installed on the host, so the task's changes were not checked\n")
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed to run the pre-commit hook: %v\n", err)
+	case passed:
+		fmt.Printf("\n✓ The pre-commit hook passed on the task's changes\n")
+	default:
+		fmt.Printf("\n⚠️  The pre-commit hook failed on the task's changes:\n%s\n", strings.TrimRight(output, "\n"))
+	}
+}
+
+// verifyBranch runs the verification commands on the task branch and
+// reports which failed. A failure does not fail the task: the changes are on
+// the branch either way.
+func verifyBranch(git gitops.GitOps, branchName string, commands []string) {
+	fmt.Printf("\nVerifying %s...\n", branchName)
+	results, err := git.VerifyBranch(branchName, commands, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to verify branch: %v\n", err)
+		return
+	}
+
+	var failed []string
+	for _, result := range results {
+		if !result.Passed {
+			failed = append(failed, result.Command)
+		}
+	}
+	if len(failed) == 0 {
+		fmt.Printf("\n✓ Verification passed\n")
+		return
+	}
+	fmt.Printf("\n❌ Verification failed; review the branch before merging. Failed commands:\n")
+	for _, command := range failed {
+		fmt.Printf("  %s\n", command)
+	}
+}
+
+// compareBenchmarks runs the benchmark command at the task's start and on the
+// task branch and prints how their results compare. Like a failed
+// verification, a regression does not fail the task.
+func compareBenchmarks(git gitops.GitOps, start, branchName, command string, threshold float64) {
+	fmt.Printf("\nRunning benchmarks at the task's start and on %s...\n", branchName)
+	var runs [2][]*bench.Benchmark
+	for i, ref := range []string{start, branchName} {
+		output, err := git.RunAtRef(ref, command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to run benchmarks: %v\n%s", err, output)
+			return
+		}
+		runs[i] = bench.Parse(output)
+	}
+
+	comparisons := bench.Compare(runs[0], runs[1])
+	if len(comparisons) == 0 {
+		fmt.Printf("\nNo benchmarks ran at both the task's start and on %s\n", branchName)
+		return
+	}
+	fmt.Println()
+	if regressions := bench.Print(os.Stdout, comparisons, threshold); regressions > 0 {
+		fmt.Printf("\n⚠️  %d benchmark results got worse by more than %g%%; review the branch before merging\n", regressions, threshold)
+	} else {
+		fmt.Printf("\n✓ No benchmark got worse by more than %g%%\n", threshold)
+	}
+}
+
+// buildImage builds the image the task will run in. With an image registry,
+// the image is pulled from it first, so it is only built if the registry has
+// none or its image is too old, and it is pushed back if it was built.
+// Failing to pull or push is only a warning.
+func buildImage(docker dockerops.DockerOps, config Config) error {
+	mainImage := dockerpkg.MainImageName(config.BaseImage)
+	registryImage := ""
+	pulled := false
+	if config.ImageRegistry != "" {
+		registryImage = dockerpkg.RegistryImage(config.ImageRegistry, mainImage)
+		if !config.ForceRebuild {
+			if err := docker.PullImage(registryImage, mainImage); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			} else {
+				pulled = true
+			}
+		}
+	}
+	before, _ := docker.ImageDigest(mainImage)
+
+	if err := docker.BuildImage(config.BaseImage, buildOptions(config), config.ShowBuildOutput, config.ForceRebuild, config.Debug); err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+
+	if registryImage == "" {
+		return nil
+	}
+	if after, _ := docker.ImageDigest(mainImage); pulled && after == before {
+		if config.Debug {
+			fmt.Printf("Using %s from the registry\n", registryImage)
+		}
+		return nil
+	}
+	fmt.Printf("Pushing %s...\n", registryImage)
+	if err := docker.PushImage(mainImage, registryImage); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return nil
+}
+
+// credentialServer answers the container's requests for git credentials with
+// the host's credentials from fill, for the hosts the task was given only.
+// Credentials are looked up afresh each time and never stored.
+func credentialServer(hosts []string, fill func(host string) (*gitpkg.Credential, error)) ctrlsock.CredentialFunc {
+	return func(host string) (string, error) {
+		if !slices.Contains(hosts, host) {
+			return "", fmt.Errorf("%s was not given with --git-credential", host)
+		}
+		credential, err := fill(host)
+		if err != nil {
+			return "", err
 		}
+		return credential.Format(), nil
+	}
+}
 
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			// Reached root without finding .git
-			return "", fmt.Errorf("could not find .git directory in any parent directory")
+// githubCredential returns a credential lookup that gives source's token for
+// github.com, and the host's credentials from fill for other hosts
+func githubCredential(source *ghtoken.Source, fill func(host string) (*gitpkg.Credential, error)) func(host string) (*gitpkg.Credential, error) {
+	return func(host string) (*gitpkg.Credential, error) {
+		if host != ghtoken.Host {
+			return fill(host)
+		}
+		token, err := source.Token()
+		if err != nil {
+			return nil, err
 		}
-		dir = parent
+		return &gitpkg.Credential{Username: ghtoken.Username, Password: token}, nil
+	}
+}
+
+// buildOptions returns the extras to install in the image the task runs in
+func buildOptions(config Config) dockerpkg.BuildOptions {
+	return dockerpkg.BuildOptions{
+		Nix:       config.Nix,
+		Mise:      config.Mise,
+		CACert:    config.CACert,
+		Proxy:     config.Proxy,
+		Netrc:     config.BuildNetrc,
+		Npmrc:     config.BuildNpmrc,
+		GoPrivate: config.GoPrivate,
+
+		Diffreviewer: config.Diffreviewer,
+		Beads:        config.Beads,
+
+		NoDiffreviewer: config.NoDiffreviewer,
+		NoBeads:        config.NoBeads,
+		Docker:         config.EnableDocker,
+
+		Dotfiles: config.Dotfiles,
+		Packages: config.Packages,
+		RunSteps: config.RunSteps,
+	}
+}
+
+// scanImage scans the image the task will run in and prints a summary. With
+// failOnCritical, an image with critical vulnerabilities, or one that could
+// not be scanned, is an error.
+func scanImage(docker dockerops.DockerOps, imageName string, failOnCritical bool) error {
+	fmt.Printf("Scanning %s for vulnerabilities...\n", imageName)
+	result, err := docker.ScanImage(imageName)
+	if err != nil {
+		if failOnCritical {
+			return fmt.Errorf("failed to scan image: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: failed to scan image: %v\n", err)
+		return nil
+	}
+
+	fmt.Printf("Vulnerabilities in %s: %s\n", imageName, result.Summary())
+	if failOnCritical && result.Critical > 0 {
+		return fmt.Errorf("%s has %d critical vulnerabilities (--fail-on-critical)", imageName, result.Critical)
+	}
+	return nil
+}
+
+// writeSBOM writes a software bill of materials for the image the task will
+// run in next to the task's other files. Failing to is only a warning.
+func writeSBOM(docker dockerops.DockerOps, imageName, format, containerName string) {
+	path, err := ArtifactPath(containerName, "."+format+".sbom.json")
+	if err == nil {
+		err = docker.GenerateSBOM(imageName, format, path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write SBOM: %v\n", err)
+		return
+	}
+	fmt.Printf("SBOM for %s written to %s\n", imageName, path)
+}
+
+// gitURL returns the URL the container reaches the repository at, under its
+// token: on the git server sidecar by its name on the task's network, or on
+// the git server on the host
+func gitURL(config Config, gitSidecar string, gitPort int, gitToken string) string {
+	if config.Network != "" {
+		return gitpkg.ServerURL(gitSidecar, gitPort, gitToken)
+	}
+	host := config.GitHost
+	if host == "" {
+		host = gitpkg.DefaultServerHost
+	}
+	return gitpkg.ServerURL(host, gitPort, gitToken)
+}
+
+// defaultGitBind returns the address the git server listens on unless
+// --git-bind says otherwise, so that the repository isn't served to the
+// network: the loopback interface if the Docker runtime forwards
+// host.docker.internal to it, or else this host's address on Docker's bridge
+// network, which host.docker.internal maps to on Docker Engine for Linux.
+// Failing both, it is all interfaces, as the container couldn't reach it.
+func defaultGitBind(docker dockerops.DockerOps) string {
+	if docker.HostLoopbackReachable() {
+		return "127.0.0.1"
+	}
+	if gateway := docker.BridgeGateway(); gateway != "" {
+		return gateway
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the git server listens on all interfaces, as containers can't reach this host's loopback or Docker bridge address with this Docker runtime. Use --git-bind to choose the address it listens on.\n")
+	return ""
+}
+
+// startReusedServer starts the git server for a reused container on the port
+// its clone points at, as recorded in the task state or else on the
+// container's command line. The port is taken back from a git server left
+// running for the repository, such as after a crash; if something else holds
+// it, the server is started on a new port, which the container and the task
+// state are told about. The server keeps the container's token, and lets the
+// refs of the task on branchName be pushed.
+func startReusedServer(git gitops.GitOps, docker dockerops.DockerOps, repo *gitpkg.Repository, containerName, bindAddr, token, branchName string, reused *dockerpkg.ContainerInfo) (*gitpkg.ServerCmd, int, error) {
+	projectRoot := repo.Path()
+	port := reused.GitServerPort
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return nil, 0, err
+	}
+	state, stateErr := taskstate.Load(gitDir, containerName)
+	if stateErr == nil && state.GitPort != 0 {
+		port = state.GitPort
+	}
+
+	free, err := git.ReclaimServerPort(projectRoot, port)
+	if err != nil {
+		return nil, 0, err
+	}
+	if free {
+		serverCmd, err := git.StartServerOnPort(projectRoot, bindAddr, port, token, branchName)
+		return serverCmd, port, err
+	}
+
+	serverCmd, newPort, err := git.StartServer(projectRoot, bindAddr, token, branchName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := docker.SetGitServerPort(containerName, newPort); err != nil {
+		git.StopServer(serverCmd)
+		return nil, 0, err
+	}
+	fmt.Printf("Port %d of the container's git server is in use, serving the repository on port %d instead\n", port, newPort)
+	if stateErr == nil {
+		state.GitPort = newPort
+		if err := taskstate.Save(gitDir, containerName, *state); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record task state: %v\n", err)
+		}
+	}
+	return serverCmd, newPort, nil
+}
+
+// exportExtraFiles copies the files innie exported from outside the
+// workspace out of the container, and says where they are if there are any
+func exportExtraFiles(docker dockerops.DockerOps, containerName string) {
+	dir, err := ArtifactPath(containerName, "-files")
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err == nil {
+		err = docker.CopyFromContainer(containerName, extrafiles.ExportDir+"/.", dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to copy exported files: %v\n", err)
+		return
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+		return
+	}
+	fmt.Printf("Files exported from outside the workspace are in %s\n", dir)
+}
+
+// spillPrompt moves the prompt of a spec too large for the container's
+// environment to a file next to the task's state, so that a reused container
+// can still mount it, and returns the docker args that mount it where innie
+// reads it
+func spillPrompt(repo *gitpkg.Repository, containerName string, spec *taskspec.Spec) (string, error) {
+	encoded, err := taskspec.Encode(*spec)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded) <= taskspec.MaxEncodedSize {
+		return "", nil
+	}
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return "", err
+	}
+	path := taskstate.PromptPath(gitDir, containerName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create task state directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(spec.Prompt), 0644); err != nil {
+		return "", fmt.Errorf("failed to write the task's prompt: %w", err)
+	}
+	spec.Prompt = ""
+	spec.PromptPath = taskspec.PromptFile
+	return "-v " + cmdutil.QuoteArg(path+":"+taskspec.PromptFile+":ro"), nil
+}
+
+// saveTaskState records the images and commit the task starts from
+func saveTaskState(repo *gitpkg.Repository, git gitops.GitOps, docker dockerops.DockerOps, config Config, containerName, branchName, startRef string, hostGitServer bool, gitPort int) error {
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return err
+	}
+	mainImage := dockerpkg.MainImageName(config.BaseImage)
+	state := taskstate.State{
+		TaskID:         config.TaskID,
+		Slug:           config.Slug,
+		Branch:         branchName,
+		StartedAt:      time.Now().UTC(),
+		GivernyVersion: config.Version,
+		BaseImage:      config.BaseImage,
+		MainImage:      mainImage,
+		BuildOptions:   buildOptions(config),
+	}
+	if hostGitServer {
+		state.GitPort = gitPort
+	}
+	if state.StartCommit, err = git.GetCommitHash(startRef); err != nil {
+		return err
+	}
+	if state.BaseImageDigest, err = docker.ImageDigest(config.BaseImage); err != nil {
+		return err
+	}
+	info, err := docker.InspectImage(mainImage)
+	if err != nil {
+		return err
 	}
+	state.MainImageID = info.ID
+	state.DockerfileHash = info.DockerfileHash
+	state.ClaudeCodeVersion = info.ClaudeCodeVersion
+	state.DiffreviewerVersion = info.DiffreviewerVersion
+	state.BeadsVersion = info.BeadsVersion
+	return taskstate.Save(gitDir, containerName, state)
 }
@@ -31,20 +31,12 @@ func TestDirtyWorkspaceCheck(t *testing.T) {
 	// Initialize a git repository
 	testutil.InitTestRepo(t, tmpDir)
 
-	// Change to temp directory for tests
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("failed to get working directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("failed to change to temp dir: %v", err)
-	}
+	// Run works on the repository in the working directory, so this test
+	// changes into it and doesn't run in parallel
+	t.Chdir(tmpDir)
 
 	// Set required environment variable
-	os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
-	defer os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
 
 	t.Run("rejects dirty workspace by default", func(t *testing.T) {
 		// Make a change without committing
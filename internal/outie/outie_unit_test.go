@@ -3,13 +3,23 @@ package outie
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"giverny/internal/ctrlsock"
+	"giverny/internal/docker"
 	"giverny/internal/dockerops"
+	"giverny/internal/extrafiles"
+	"giverny/internal/ghtoken"
 	"giverny/internal/git"
 	"giverny/internal/gitops"
+	"giverny/internal/hooks"
+	"giverny/internal/taskspec"
+	"giverny/internal/taskstate"
 	"giverny/internal/testutil"
 )
 
@@ -23,20 +33,12 @@ func setupTestDir(t *testing.T) (tmpDir string, cleanup func()) {
 	// Initialize git repo
 	testutil.InitTestRepo(t, tmpDir)
 
-	// Change to temp directory
-	origDir, err := os.Getwd()
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		t.Fatalf("failed to get working directory: %v", err)
-	}
-
-	if err := os.Chdir(tmpDir); err != nil {
-		os.RemoveAll(tmpDir)
-		t.Fatalf("failed to change to temp dir: %v", err)
-	}
+	// RunWithDeps works on the repository in the working directory, as
+	// giverny does, so the tests using it change into it and don't run in
+	// parallel
+	t.Chdir(tmpDir)
 
 	cleanup = func() {
-		os.Chdir(origDir)
 		os.RemoveAll(tmpDir)
 	}
 
@@ -48,18 +50,8 @@ func TestRunWithDeps_ValidatesClaudeToken(t *testing.T) {
 	_, cleanup := setupTestDir(t)
 	defer cleanup()
 
-	// Save original token and restore after test
-	originalToken := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
-	defer func() {
-		if originalToken != "" {
-			os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", originalToken)
-		} else {
-			os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
-		}
-	}()
-
 	// Clear token for test
-	os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "")
 
 	mockGit := gitops.NewMockGitOps()
 	mockDocker := dockerops.NewMockDockerOps()
@@ -83,21 +75,44 @@ func TestRunWithDeps_ValidatesClaudeToken(t *testing.T) {
 	}
 }
 
+// TestRunWithDeps_ValidatesTaskID verifies that a TASK-ID that can't be used
+// in a branch name is rejected before anything is started
+func TestRunWithDeps_ValidatesTaskID(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	mockGit := gitops.NewMockGitOps()
+	mockDocker := dockerops.NewMockDockerOps()
+	buildCalled := false
+	mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput, forceRebuild, debug bool) error {
+		buildCalled = true
+		return nil
+	}
+
+	config := Config{
+		TaskID:     "fix login",
+		Prompt:     "test prompt",
+		BaseImage:  "alpine:latest",
+		AllowDirty: true,
+	}
+
+	err := RunWithDeps(config, mockGit, mockDocker)
+	if err == nil || !strings.Contains(err.Error(), "invalid TASK-ID: TASK-ID cannot contain spaces") {
+		t.Fatalf("Expected an invalid TASK-ID error, got: %v", err)
+	}
+	if buildCalled {
+		t.Error("Expected no image to be built")
+	}
+}
+
 // TestRunWithDeps_ChecksDirtyWorkspace verifies workspace dirty check behavior
 func TestRunWithDeps_ChecksDirtyWorkspace(t *testing.T) {
 	_, cleanup := setupTestDir(t)
 	defer cleanup()
 
 	// Set token for test
-	originalToken := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
-	os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
-	defer func() {
-		if originalToken != "" {
-			os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", originalToken)
-		} else {
-			os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
-		}
-	}()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
 
 	t.Run("rejects dirty workspace by default", func(t *testing.T) {
 		mockGit := gitops.NewMockGitOps()
@@ -136,27 +151,27 @@ func TestRunWithDeps_ChecksDirtyWorkspace(t *testing.T) {
 		mockGit.IsWorkspaceDirtyFunc = func() (bool, error) {
 			return true, nil // Workspace is dirty
 		}
-		mockGit.CreateBranchFunc = func(branchName string) error {
+		mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
 			branchCreated = true
 			return nil
 		}
-		mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			serverStarted = true
 			return &git.ServerCmd{}, 9999, nil
 		}
 		mockGit.StopServerFunc = func(serverCmd *git.ServerCmd) error {
 			return nil
 		}
-		mockGit.GetBranchCommitRangeFunc = func(branchName string) (string, string, error) {
+		mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
 			return "", "", nil
 		}
 
 		mockDocker := dockerops.NewMockDockerOps()
-		mockDocker.BuildImageFunc = func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 			imageBuilt = true
 			return nil
 		}
-		mockDocker.RunContainerFunc = func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
 			containerRan = true
 			return 0, nil // Success
 		}
@@ -202,21 +217,21 @@ func TestRunWithDeps_ChecksDirtyWorkspace(t *testing.T) {
 		mockGit.BranchExistsFunc = func(branchName string) (bool, error) {
 			return true, nil
 		}
-		mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			return &git.ServerCmd{}, 9999, nil
 		}
 		mockGit.StopServerFunc = func(serverCmd *git.ServerCmd) error {
 			return nil
 		}
-		mockGit.GetBranchCommitRangeFunc = func(branchName string) (string, string, error) {
+		mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
 			return "", "", nil
 		}
 
 		mockDocker := dockerops.NewMockDockerOps()
-		mockDocker.BuildImageFunc = func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 			return nil
 		}
-		mockDocker.RunContainerFunc = func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
 			return 0, nil
 		}
 		mockDocker.RemoveContainerFunc = func(containerName string) error {
@@ -248,19 +263,11 @@ func TestRunWithDeps_HandlesGitErrors(t *testing.T) {
 	defer cleanup()
 
 	// Set token for test
-	originalToken := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
-	os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
-	defer func() {
-		if originalToken != "" {
-			os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", originalToken)
-		} else {
-			os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
-		}
-	}()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
 
 	t.Run("handles branch creation failure", func(t *testing.T) {
 		mockGit := gitops.NewMockGitOps()
-		mockGit.CreateBranchFunc = func(branchName string) error {
+		mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
 			return errors.New("branch already exists")
 		}
 
@@ -287,10 +294,10 @@ func TestRunWithDeps_HandlesGitErrors(t *testing.T) {
 
 	t.Run("handles server start failure", func(t *testing.T) {
 		mockGit := gitops.NewMockGitOps()
-		mockGit.CreateBranchFunc = func(branchName string) error {
+		mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
 			return nil
 		}
-		mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			return nil, 0, errors.New("port already in use")
 		}
 
@@ -322,19 +329,11 @@ func TestRunWithDeps_HandlesDockerErrors(t *testing.T) {
 	defer cleanup()
 
 	// Set token for test
-	originalToken := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
-	os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
-	defer func() {
-		if originalToken != "" {
-			os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", originalToken)
-		} else {
-			os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
-		}
-	}()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
 
 	t.Run("handles build failure", func(t *testing.T) {
 		mockGit := gitops.NewMockGitOps()
-		mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			return &git.ServerCmd{}, 9999, nil
 		}
 		mockGit.StopServerFunc = func(serverCmd *git.ServerCmd) error {
@@ -342,7 +341,7 @@ func TestRunWithDeps_HandlesDockerErrors(t *testing.T) {
 		}
 
 		mockDocker := dockerops.NewMockDockerOps()
-		mockDocker.BuildImageFunc = func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 			return errors.New("docker build failed")
 		}
 
@@ -367,7 +366,7 @@ func TestRunWithDeps_HandlesDockerErrors(t *testing.T) {
 
 	t.Run("handles container run failure", func(t *testing.T) {
 		mockGit := gitops.NewMockGitOps()
-		mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 			return &git.ServerCmd{}, 9999, nil
 		}
 		mockGit.StopServerFunc = func(serverCmd *git.ServerCmd) error {
@@ -375,10 +374,10 @@ func TestRunWithDeps_HandlesDockerErrors(t *testing.T) {
 		}
 
 		mockDocker := dockerops.NewMockDockerOps()
-		mockDocker.BuildImageFunc = func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 			return nil
 		}
-		mockDocker.RunContainerFunc = func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
 			return 1, nil // Non-zero exit code
 		}
 
@@ -408,15 +407,7 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 	defer cleanup()
 
 	// Set token for test
-	originalToken := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
-	os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
-	defer func() {
-		if originalToken != "" {
-			os.Setenv("CLAUDE_CODE_OAUTH_TOKEN", originalToken)
-		} else {
-			os.Unsetenv("CLAUDE_CODE_OAUTH_TOKEN")
-		}
-	}()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
 
 	// Track call sequence
 	var callSequence []string
@@ -426,14 +417,14 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 		callSequence = append(callSequence, "IsWorkspaceDirty")
 		return false, nil
 	}
-	mockGit.CreateBranchFunc = func(branchName string) error {
+	mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
 		callSequence = append(callSequence, "CreateBranch")
 		if branchName != "giverny/test-task" {
 			return fmt.Errorf("unexpected branch name: %s", branchName)
 		}
 		return nil
 	}
-	mockGit.StartServerFunc = func(repoPath string) (*git.ServerCmd, int, error) {
+	mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
 		callSequence = append(callSequence, "StartServer")
 		return &git.ServerCmd{}, 9999, nil
 	}
@@ -441,7 +432,7 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 		callSequence = append(callSequence, "StopServer")
 		return nil
 	}
-	mockGit.GetBranchCommitRangeFunc = func(branchName string) (string, string, error) {
+	mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
 		callSequence = append(callSequence, "GetBranchCommitRange")
 		return "abc123", "def456", nil
 	}
@@ -451,23 +442,23 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 	}
 
 	mockDocker := dockerops.NewMockDockerOps()
-	mockDocker.BuildImageFunc = func(baseImage string, showOutput bool, forceRebuild bool, debug bool) error {
+	mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
 		callSequence = append(callSequence, "BuildImage")
 		if baseImage != "alpine:latest" {
 			return fmt.Errorf("unexpected base image: %s", baseImage)
 		}
 		return nil
 	}
-	mockDocker.RunContainerFunc = func(taskID, slug, prompt, baseImage string, gitPort int, dockerArgs, agentArgs string, debug, useAmp bool) (int, error) {
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
 		callSequence = append(callSequence, "RunContainer")
-		if taskID != "test-task" {
-			return 1, fmt.Errorf("unexpected task ID: %s", taskID)
+		if spec.TaskID != "test-task" {
+			return 1, fmt.Errorf("unexpected task ID: %s", spec.TaskID)
 		}
-		if prompt != "test prompt" {
-			return 1, fmt.Errorf("unexpected prompt: %s", prompt)
+		if spec.Prompt != "test prompt" {
+			return 1, fmt.Errorf("unexpected prompt: %s", spec.Prompt)
 		}
-		if gitPort != 9999 {
-			return 1, fmt.Errorf("unexpected git port: %d", gitPort)
+		if spec.GitServerPort != 9999 {
+			return 1, fmt.Errorf("unexpected git port: %d", spec.GitServerPort)
 		}
 		return 0, nil
 	}
@@ -500,10 +491,10 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 		"StartServer",
 		"BuildImage",
 		"RunContainer",
-		"RemoveContainer",
 		"GetBranchCommitRange",
 		"GetShortHash(abc123)",
 		"GetShortHash(def456)",
+		"RemoveContainer",
 		"StopServer",
 	}
 
@@ -517,3 +508,1847 @@ func TestRunWithDeps_SuccessfulFlow(t *testing.T) {
 		}
 	}
 }
+
+// TestRunWithDeps_Squash verifies that the task branch is squashed when requested
+func TestRunWithDeps_Squash(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	t.Run("squashes multiple commits", func(t *testing.T) {
+		var squashedBranch, squashedFirst, squashedMessage string
+		var shortHashes []string
+
+		mockGit := gitops.NewMockGitOps()
+		mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+			return "abc1234", "def5678", nil
+		}
+		mockGit.SquashBranchFunc = func(branchName, firstCommit, message string) (string, error) {
+			squashedBranch, squashedFirst, squashedMessage = branchName, firstCommit, message
+			return "fed9876", nil
+		}
+		mockGit.GetShortHashFunc = func(hash string) string {
+			shortHashes = append(shortHashes, hash)
+			return hash
+		}
+
+		config := Config{
+			TaskID:    "test-task",
+			Prompt:    "test prompt",
+			BaseImage: "alpine:latest",
+			Squash:    true,
+		}
+
+		if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if squashedBranch != "giverny/test-task" || squashedFirst != "abc1234" {
+			t.Errorf("Expected squash of giverny/test-task from abc1234, got %q from %q", squashedBranch, squashedFirst)
+		}
+		if !strings.Contains(squashedMessage, "test prompt") {
+			t.Errorf("Expected squash message to contain the prompt, got: %q", squashedMessage)
+		}
+		for _, hash := range shortHashes {
+			if hash != "fed9876" {
+				t.Errorf("Expected instructions to use the squashed commit, got %q", hash)
+			}
+		}
+	})
+
+	t.Run("leaves a single commit alone", func(t *testing.T) {
+		squashCalled := false
+
+		mockGit := gitops.NewMockGitOps()
+		mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+			return "abc1234", "abc1234", nil
+		}
+		mockGit.SquashBranchFunc = func(branchName, firstCommit, message string) (string, error) {
+			squashCalled = true
+			return firstCommit, nil
+		}
+
+		config := Config{
+			TaskID:    "test-task",
+			Prompt:    "test prompt",
+			BaseImage: "alpine:latest",
+			Squash:    true,
+		}
+
+		if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if squashCalled {
+			t.Error("Expected SquashBranch not to be called for a single commit")
+		}
+	})
+}
+
+// TestRunWithDeps_Verify verifies that the verification commands are run on
+// the task branch once it has been pushed back
+func TestRunWithDeps_Verify(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var verifiedRef string
+	var verifiedCommands []string
+	mockGit := gitops.NewMockGitOps()
+	mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+		return "abc1234", "def5678", nil
+	}
+	mockGit.VerifyBranchFunc = func(ref string, commands []string, out io.Writer) ([]git.VerifyResult, error) {
+		verifiedRef, verifiedCommands = ref, commands
+		return []git.VerifyResult{{Command: commands[0], Passed: true}, {Command: commands[1], Passed: false}}, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Verify:    []string{"make test", "make lint"},
+	}
+
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Fatalf("Expected a failed verification not to fail the task, got: %v", err)
+	}
+	if verifiedRef != "giverny/test-task" || strings.Join(verifiedCommands, ",") != "make test,make lint" {
+		t.Errorf("Expected both commands to be run on giverny/test-task, got %v on %q", verifiedCommands, verifiedRef)
+	}
+}
+
+// TestRunWithDeps_BenchCmd verifies that the benchmark command is run at the
+// task's start and on the task branch once it has been pushed back
+func TestRunWithDeps_BenchCmd(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var refs []string
+	mockGit := gitops.NewMockGitOps()
+	mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+		return "abc1234", "def5678", nil
+	}
+	mockGit.MergeBaseFunc = func(ref1, ref2 string) (string, error) {
+		return "0a1b2c3", nil
+	}
+	mockGit.RunAtRefFunc = func(ref, command string) (string, error) {
+		if command != "go test -bench=." {
+			t.Errorf("Expected the benchmark command, got %q", command)
+		}
+		refs = append(refs, ref)
+		if len(refs) == 1 {
+			return "BenchmarkParse-8 1000 1000 ns/op\n", nil
+		}
+		return "BenchmarkParse-8 1000 2000 ns/op\n", nil
+	}
+
+	config := Config{
+		TaskID:         "test-task",
+		Prompt:         "test prompt",
+		BaseImage:      "alpine:latest",
+		BenchCmd:       "go test -bench=.",
+		BenchThreshold: 5,
+	}
+
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Fatalf("Expected a benchmark regression not to fail the task, got: %v", err)
+	}
+	if strings.Join(refs, ",") != "0a1b2c3,giverny/test-task" {
+		t.Errorf("Expected benchmarks at the task's start and on giverny/test-task, got %v", refs)
+	}
+}
+
+// TestRunWithDeps_HooksSkip verifies that with the hooks skipped in the
+// container, the host's pre-commit hook is run on the task's changes since
+// the branch left the target branch
+func TestRunWithDeps_HooksSkip(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var fromRef, toRef string
+	mockGit := gitops.NewMockGitOps()
+	mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+		return "abc1234", "def5678", nil
+	}
+	mockGit.MergeBaseFunc = func(ref1, ref2 string) (string, error) {
+		return "0a1b2c3", nil
+	}
+	mockGit.RunPreCommitHookFunc = func(from, to string) (string, bool, error) {
+		fromRef, toRef = from, to
+		return "", true, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Hooks:     hooks.ModeSkip,
+	}
+
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Fatalf("RunWithDeps failed: %v", err)
+	}
+	if fromRef != "0a1b2c3" || toRef != "def5678" {
+		t.Errorf("Expected the hook to be run on 0a1b2c3..def5678, got %s..%s", fromRef, toRef)
+	}
+}
+
+// TestRunWithDeps_FailOnCritical verifies that the task doesn't start in an
+// image with critical vulnerabilities
+func TestRunWithDeps_FailOnCritical(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var scannedImage string
+	containerRun := false
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.ScanImageFunc = func(imageName string) (*docker.ScanResult, error) {
+		scannedImage = imageName
+		return &docker.ScanResult{Scanner: "mock", Critical: 2, High: 1}, nil
+	}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		containerRun = true
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:         "test-task",
+		Prompt:         "test prompt",
+		BaseImage:      "alpine:latest",
+		FailOnCritical: true,
+	}
+
+	err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker)
+	if err == nil || !strings.Contains(err.Error(), "2 critical vulnerabilities") {
+		t.Fatalf("Expected a critical vulnerability error, got: %v", err)
+	}
+	if scannedImage != docker.MainImageName("alpine:latest") {
+		t.Errorf("Expected the main image to be scanned, got %q", scannedImage)
+	}
+	if containerRun {
+		t.Error("Expected the container not to be run")
+	}
+
+	// Only scanning reports the vulnerabilities without stopping the task
+	config.TaskID = "test-task-2"
+	config.FailOnCritical = false
+	config.ScanImage = true
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !containerRun {
+		t.Error("Expected the container to be run")
+	}
+}
+
+// TestRunWithDeps_ImageRegistry verifies that --image-registry pulls the image
+// before building and only pushes it when it was built
+func TestRunWithDeps_ImageRegistry(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	mainImage := docker.MainImageName("alpine:latest")
+	registryImage := "registry.example.com/team/" + mainImage
+	var pulled, pushed []string
+	var pullErr error
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.PullImageFunc = func(remote, local string) error {
+		pulled = append(pulled, remote+" "+local)
+		return pullErr
+	}
+	mockDocker.PushImageFunc = func(local, remote string) error {
+		pushed = append(pushed, local+" "+remote)
+		return nil
+	}
+
+	config := Config{
+		TaskID:        "test-task",
+		Prompt:        "test prompt",
+		BaseImage:     "alpine:latest",
+		ImageRegistry: "registry.example.com/team/",
+	}
+
+	// The registry's image is fresh, so nothing is built or pushed
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pulled) != 1 || pulled[0] != registryImage+" "+mainImage {
+		t.Errorf("Expected %s to be pulled, got %v", registryImage, pulled)
+	}
+	if len(pushed) != 0 {
+		t.Errorf("Expected nothing to be pushed, got %v", pushed)
+	}
+
+	// The registry has no image, so the one built is pushed
+	pullErr = fmt.Errorf("not found")
+	config.TaskID = "test-task-2"
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pushed) != 1 || pushed[0] != mainImage+" "+registryImage {
+		t.Errorf("Expected %s to be pushed, got %v", registryImage, pushed)
+	}
+
+	// A forced rebuild skips the pull and pushes the new image
+	pulled, pushed = nil, nil
+	config.TaskID = "test-task-3"
+	config.ForceRebuild = true
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pulled) != 0 || len(pushed) != 1 {
+		t.Errorf("Expected a push without a pull, got pulled %v, pushed %v", pulled, pushed)
+	}
+}
+
+// TestRunWithDeps_Devcontainer verifies that --devcontainer builds giverny-main
+// on the project's devcontainer image
+func TestRunWithDeps_Devcontainer(t *testing.T) {
+	tmpDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var devcontainerDir, builtImage, runImage string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.DevcontainerImageFunc = func(dir string, showOutput, debug bool) (string, error) {
+		devcontainerDir = dir
+		return "giverny-devcontainer-project:latest", nil
+	}
+	mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+		builtImage = baseImage
+		return nil
+	}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		runImage = baseImage
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:       "test-task",
+		Prompt:       "test prompt",
+		BaseImage:    "giverny:latest",
+		Devcontainer: true,
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if resolved, _ := filepath.EvalSymlinks(tmpDir); devcontainerDir != resolved && devcontainerDir != tmpDir {
+		t.Errorf("Expected the devcontainer of %s, got %s", tmpDir, devcontainerDir)
+	}
+	if builtImage != "giverny-devcontainer-project:latest" || runImage != builtImage {
+		t.Errorf("Expected the devcontainer image to be built on and run, got built %q, run %q", builtImage, runImage)
+	}
+}
+
+// TestRunWithDeps_Nix verifies that --nix installs Nix in the image and has
+// innie enter the flake's dev shell, and needs a flake.nix
+func TestRunWithDeps_Nix(t *testing.T) {
+	tmpDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var buildOpts docker.BuildOptions
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+		buildOpts = opts
+		return nil
+	}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Nix:       true,
+	}
+	err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker)
+	if err == nil || !strings.Contains(err.Error(), "flake.nix") {
+		t.Fatalf("Expected an error about the missing flake.nix, got: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "flake.nix"), []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write flake.nix: %v", err)
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !buildOpts.Nix {
+		t.Error("Expected Nix to be installed in the image")
+	}
+	if !passedSpec.Nix {
+		t.Errorf("Expected Nix in the task spec, got %+v", passedSpec)
+	}
+}
+
+// TestRunWithDeps_Proxy verifies that --proxy reaches both the image build and
+// the container
+func TestRunWithDeps_Proxy(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var buildOpts docker.BuildOptions
+	var passedDockerArgs string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+		buildOpts = opts
+		return nil
+	}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedDockerArgs = dockerArgs
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:     "test-task",
+		Prompt:     "test prompt",
+		BaseImage:  "alpine:latest",
+		DockerArgs: "--cpus 2",
+		Proxy:      "http://proxy:3128",
+		CACert:     "/etc/corp-ca.pem",
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buildOpts.Proxy != "http://proxy:3128" || buildOpts.CACert != "/etc/corp-ca.pem" {
+		t.Errorf("Expected the proxy and CA certificates in the build options, got %+v", buildOpts)
+	}
+	for _, want := range []string{"--cpus 2 ", "--env HTTPS_PROXY=http://proxy:3128", "--env no_proxy="} {
+		if !strings.Contains(passedDockerArgs, want) {
+			t.Errorf("Expected %q in docker args, got %q", want, passedDockerArgs)
+		}
+	}
+}
+
+// TestRunWithDeps_EnableDocker verifies that a task given Docker gets it
+// installed in its image and the docker run flags and innie flag for it
+func TestRunWithDeps_EnableDocker(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	tests := []struct {
+		mode       string
+		dockerArgs string
+	}{
+		{docker.DockerModeSocket, "-v /var/run/docker.sock:/var/run/docker.sock"},
+		{docker.DockerModeDinD, "--privileged -v /var/lib/docker"},
+	}
+	for _, tt := range tests {
+		var buildOpts docker.BuildOptions
+		var passedDockerArgs string
+		var passedSpec taskspec.Spec
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+			buildOpts = opts
+			return nil
+		}
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			passedDockerArgs = dockerArgs
+			passedSpec = spec
+			return 0, nil
+		}
+
+		config := Config{
+			TaskID:       "test-task-" + tt.mode,
+			Prompt:       "test prompt",
+			BaseImage:    "alpine:latest",
+			EnableDocker: tt.mode,
+		}
+		if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.mode, err)
+		}
+		if buildOpts.Docker != tt.mode {
+			t.Errorf("%s: expected Docker in the build options, got %+v", tt.mode, buildOpts)
+		}
+		if !strings.Contains(passedDockerArgs, tt.dockerArgs) {
+			t.Errorf("%s: expected %q in docker args, got %q", tt.mode, tt.dockerArgs, passedDockerArgs)
+		}
+		if passedSpec.EnableDocker != tt.mode {
+			t.Errorf("%s: expected Docker enabled in the task spec, got %q", tt.mode, passedSpec.EnableDocker)
+		}
+	}
+}
+
+// TestRunWithDeps_GitCredentials verifies that innie is told which hosts it
+// may get credentials for, and that only those are served
+func TestRunWithDeps_GitCredentials(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	config := Config{
+		TaskID:         "test-task",
+		Prompt:         "test prompt",
+		BaseImage:      "alpine:latest",
+		GitCredentials: []string{"https://github.com"},
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), dockerops.NewMockDockerOps()); err == nil || !strings.Contains(err.Error(), "invalid --git-credential") {
+		t.Fatalf("expected an invalid --git-credential error, got %v", err)
+	}
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+	config.GitCredentials = []string{"github.com", "git.corp.example.com:8443"}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(passedSpec.GitCredentials, " "); got != "github.com git.corp.example.com:8443" {
+		t.Errorf("expected the git credentials in the task spec, got %v", passedSpec.GitCredentials)
+	}
+
+	serve := credentialServer(config.GitCredentials, func(host string) (*git.Credential, error) {
+		return &git.Credential{Username: "me", Password: "token-for-" + host}, nil
+	})
+	if credential, err := serve("github.com"); err != nil || credential != "username=me\npassword=token-for-github.com\n\n" {
+		t.Errorf("serve(github.com) = %q, %v", credential, err)
+	}
+	if _, err := serve("gitlab.com"); err == nil {
+		t.Error("expected a host not given with --git-credential to be refused")
+	}
+}
+
+// TestRunWithDeps_GitHubRepos verifies that a GitHub token is served as the
+// credential for github.com
+func TestRunWithDeps_GitHubRepos(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+	t.Setenv(ghtoken.AppIDEnv, "")
+	t.Setenv(ghtoken.PATEnv, "")
+
+	config := Config{
+		TaskID:      "test-task",
+		Prompt:      "test prompt",
+		BaseImage:   "alpine:latest",
+		GitHubRepos: []string{"acme/api"},
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), dockerops.NewMockDockerOps()); err == nil || !strings.Contains(err.Error(), ghtoken.PATEnv) {
+		t.Fatalf("expected an error about the missing token, got %v", err)
+	}
+
+	t.Setenv(ghtoken.PATEnv, "github_pat_abc")
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(passedSpec.GitCredentials, " "); got != "github.com" {
+		t.Errorf("expected github.com credentials in the task spec, got %v", passedSpec.GitCredentials)
+	}
+
+	source, err := ghtoken.NewSource(config.GitHubRepos, ghtoken.DefaultAPIURL)
+	if err != nil {
+		t.Fatalf("NewSource failed: %v", err)
+	}
+	fill := githubCredential(source, func(host string) (*git.Credential, error) {
+		return &git.Credential{Username: "me", Password: "host-" + host}, nil
+	})
+	if credential, err := fill("github.com"); err != nil || credential.Username != ghtoken.Username || credential.Password != "github_pat_abc" {
+		t.Errorf("fill(github.com) = %+v, %v", credential, err)
+	}
+	if credential, err := fill("gitlab.com"); err != nil || credential.Password != "host-gitlab.com" {
+		t.Errorf("fill(gitlab.com) = %+v, %v", credential, err)
+	}
+}
+
+// TestRunWithDeps_Network verifies that a task on a network of its own is
+// served by a git server sidecar instead of a git server on the host
+func TestRunWithDeps_Network(t *testing.T) {
+	tmpDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Network:   "bad name",
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), dockerops.NewMockDockerOps()); err == nil || !strings.Contains(err.Error(), "invalid --network") {
+		t.Fatalf("expected an invalid --network error, got %v", err)
+	}
+
+	mockGit := gitops.NewMockGitOps()
+	mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+		t.Error("expected no git server on the host")
+		return nil, 0, nil
+	}
+	var calls []string
+	var passedPort int
+	var passedDockerArgs string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.EnsureNetworkFunc = func(network string, debug bool) error {
+		calls = append(calls, "network "+network)
+		return nil
+	}
+	var sidecarToken string
+	mockDocker.StartGitSidecarFunc = func(name, network, image, repoPath, token, branchName string, debug bool) error {
+		calls = append(calls, fmt.Sprintf("start %s %s %s %s %t", name, network, image, branchName, repoPath == tmpDir))
+		sidecarToken = token
+		return nil
+	}
+	mockDocker.StopGitSidecarFunc = func(name string) error {
+		calls = append(calls, "stop "+name)
+		return nil
+	}
+	var passedURL string
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedPort = spec.GitServerPort
+		passedDockerArgs = dockerArgs
+		passedURL = os.Getenv(git.ServerURLEnv)
+		return 0, nil
+	}
+
+	t.Setenv(git.ServerURLEnv, "")
+	config.Network = "giverny-net"
+	if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"network giverny-net", "start giverny-test-task-git giverny-net " + docker.MainImageName("alpine:latest") + " giverny/test-task true", "stop giverny-test-task-git"}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected sidecar calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+	}
+	if passedPort != docker.GitSidecarPort {
+		t.Errorf("expected git port %d, got %d", docker.GitSidecarPort, passedPort)
+	}
+	for _, want := range []string{"--network giverny-net", "--env " + git.ServerURLEnv} {
+		if !strings.Contains(passedDockerArgs, want) {
+			t.Errorf("expected %q in docker args, got %q", want, passedDockerArgs)
+		}
+	}
+	if len(sidecarToken) != 32 || passedURL != git.ServerURL("giverny-test-task-git", docker.GitSidecarPort, sidecarToken) {
+		t.Errorf("expected the sidecar to serve under the token in the container's git URL, got %q and %q", sidecarToken, passedURL)
+	}
+	if sidecarToken != "" && strings.Contains(passedDockerArgs, sidecarToken) {
+		t.Errorf("expected the token not to be on the docker command line, got %q", passedDockerArgs)
+	}
+}
+
+// TestRunWithDeps_ChecksGitServer verifies that the task fails fast if the
+// git server stops serving, before the container starts
+func TestRunWithDeps_ChecksGitServer(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	for _, failingCheck := range []int{1, 2} {
+		checks := 0
+		mockGit := gitops.NewMockGitOps()
+		mockGit.CheckServerFunc = func(bindAddr string, port int, token string) error {
+			checks++
+			if checks == failingCheck {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		}
+		ranContainer := false
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			ranContainer = true
+			return 0, nil
+		}
+
+		config := Config{
+			TaskID:    fmt.Sprintf("test-task-%d", failingCheck),
+			Prompt:    "test prompt",
+			BaseImage: "alpine:latest",
+		}
+		err := RunWithDeps(config, mockGit, mockDocker)
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("check %d: expected the health check error, got %v", failingCheck, err)
+		}
+		if ranContainer {
+			t.Errorf("check %d: expected the container not to run", failingCheck)
+		}
+	}
+}
+
+// TestRunWithDeps_GitBind verifies the address the git server listens on
+func TestRunWithDeps_GitBind(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	tests := []struct {
+		name         string
+		gitBind      string
+		loopbackOK   bool
+		gateway      string
+		expectedBind string
+	}{
+		{"loopback where the runtime forwards it", "", true, "172.17.0.1", "127.0.0.1"},
+		{"the bridge gateway on Docker Engine for Linux", "", false, "172.17.0.1", "172.17.0.1"},
+		{"all interfaces otherwise", "", false, "", ""},
+		{"as asked", "192.168.1.5", true, "", "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var started, checked []string
+			mockGit := gitops.NewMockGitOps()
+			mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+				started = append(started, bindAddr)
+				return &git.ServerCmd{}, 9999, nil
+			}
+			mockGit.CheckServerFunc = func(bindAddr string, port int, token string) error {
+				checked = append(checked, bindAddr)
+				return nil
+			}
+			mockDocker := dockerops.NewMockDockerOps()
+			mockDocker.HostLoopbackReachableFunc = func() bool { return tt.loopbackOK }
+			mockDocker.BridgeGatewayFunc = func() string { return tt.gateway }
+
+			config := Config{
+				TaskID:    "test-task",
+				Prompt:    "test prompt",
+				BaseImage: "alpine:latest",
+				GitBind:   tt.gitBind,
+			}
+			if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(started) != 1 || started[0] != tt.expectedBind {
+				t.Errorf("expected the git server to listen on %q, got %q", tt.expectedBind, started)
+			}
+			for _, bindAddr := range checked {
+				if bindAddr != tt.expectedBind {
+					t.Errorf("expected the git server to be checked at %q, got %q", tt.expectedBind, checked)
+				}
+			}
+		})
+	}
+
+	t.Run("refuses an address that isn't an IP", func(t *testing.T) {
+		config := Config{
+			TaskID:    "test-task",
+			Prompt:    "test prompt",
+			BaseImage: "alpine:latest",
+			GitBind:   "example.com",
+		}
+		err := RunWithDeps(config, gitops.NewMockGitOps(), dockerops.NewMockDockerOps())
+		if err == nil || !strings.Contains(err.Error(), "invalid --git-bind") {
+			t.Errorf("expected an invalid --git-bind error, got %v", err)
+		}
+	})
+}
+
+// TestRunWithDeps_GitToken verifies the git server serves the repository
+// under a token that is passed to the container only, in its git URL
+func TestRunWithDeps_GitToken(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var startedToken string
+	var checkedTokens []string
+	mockGit := gitops.NewMockGitOps()
+	mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+		startedToken = token
+		return &git.ServerCmd{}, 9999, nil
+	}
+	mockGit.CheckServerFunc = func(bindAddr string, port int, token string) error {
+		checkedTokens = append(checkedTokens, token)
+		return nil
+	}
+	var dockerArgs, passedURL string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, args string, output docker.OutputOptions) (int, error) {
+		dockerArgs = args
+		passedURL = os.Getenv(git.ServerURLEnv)
+		return 0, nil
+	}
+	t.Setenv(git.ServerURLEnv, "")
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+	}
+	if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(startedToken) != 32 {
+		t.Fatalf("expected a 32 character token, got %q", startedToken)
+	}
+	for _, token := range checkedTokens {
+		if token != startedToken {
+			t.Errorf("expected the git server to be checked under %q, got %q", startedToken, checkedTokens)
+		}
+	}
+	if passedURL != "git://host.docker.internal:9999/"+startedToken {
+		t.Errorf("expected the token in the container's git URL, got %q", passedURL)
+	}
+	// Only the variable's name is on the docker command line, where any
+	// local user could read it
+	if !strings.Contains(dockerArgs, "--env "+git.ServerURLEnv) || strings.Contains(dockerArgs, startedToken) {
+		t.Errorf("expected the git URL to be passed by name only, got %q", dockerArgs)
+	}
+
+	t.Run("on the host the container is told", func(t *testing.T) {
+		config.GitHost = "tunnel.example.com"
+		if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if passedURL != "git://tunnel.example.com:9999/"+startedToken {
+			t.Errorf("expected the git URL on tunnel.example.com, got %q", passedURL)
+		}
+	})
+
+	t.Run("refuses a host that isn't one", func(t *testing.T) {
+		config.GitHost = "user@example.com/repo"
+		err := RunWithDeps(config, mockGit, mockDocker)
+		if err == nil || !strings.Contains(err.Error(), "invalid --git-host") {
+			t.Errorf("expected an invalid --git-host error, got %v", err)
+		}
+	})
+}
+
+// TestHostMenu verifies the choices offered on the host once a task is done
+func TestHostMenu(t *testing.T) {
+	mockGit := gitops.NewMockGitOps()
+	var merged, deleted []string
+	mockGit.FastForwardBranchFunc = func(targetBranch, branchName string) error {
+		merged = append(merged, branchName+" into "+targetBranch)
+		return nil
+	}
+	mockGit.DeleteBranchFunc = func(branchName string) error {
+		deleted = append(deleted, branchName)
+		return nil
+	}
+
+	// Merge, keep the container, then retry with feedback
+	result := hostMenu(mockGit, Config{TaskID: "task-1"}, "giverny/task-1", "main", strings.NewReader("m\nk\nr\nUse the existing helper\n"))
+	if len(merged) != 1 || merged[0] != "giverny/task-1 into main" {
+		t.Errorf("expected the branch to be merged into main, got %v", merged)
+	}
+	if !result.keepContainer || result.feedback != "Use the existing helper" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// Deleting an unmerged branch needs confirming
+	result = hostMenu(mockGit, Config{TaskID: "task-1"}, "giverny/task-1", "main", strings.NewReader("d\nn\nd\ny\n"))
+	if len(deleted) != 1 || deleted[0] != "giverny/task-1" {
+		t.Errorf("expected the branch to be deleted once, got %v", deleted)
+	}
+	if result.keepContainer || result.feedback != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// Running out of input leaves everything as it is
+	if result := hostMenu(mockGit, Config{}, "giverny/task-1", "main", strings.NewReader("")); result != (hostMenuResult{}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if prompt := retryPrompt("Fix the bug", "Add a test"); !strings.HasPrefix(prompt, "Fix the bug\n\n") || !strings.HasSuffix(prompt, "\nAdd a test") {
+		t.Errorf("unexpected retry prompt %q", prompt)
+	}
+}
+
+// TestRunWithDeps_SavesTaskState verifies that the images and commit a task
+// starts from are recorded
+func TestRunWithDeps_SavesTaskState(t *testing.T) {
+	tmpDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	mockGit := gitops.NewMockGitOps()
+	mockGit.GetCommitHashFunc = func(ref string) (string, error) {
+		if ref == "giverny/test-task" {
+			return "abc1234abc1234", nil
+		}
+		return "", fmt.Errorf("unknown ref %s", ref)
+	}
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.InspectImageFunc = func(imageName string) (*docker.ImageInfo, error) {
+		return &docker.ImageInfo{ID: "sha256:1111", DockerfileHash: "2222", ClaudeCodeVersion: "2.0.0 (Claude Code)"}, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Version:   "v1.2.3",
+	}
+	if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	state, err := taskstate.Load(filepath.Join(tmpDir, ".git"), "giverny-test-task")
+	if err != nil {
+		t.Fatalf("Expected task state to be saved: %v", err)
+	}
+	if state.Branch != "giverny/test-task" || state.StartCommit != "abc1234abc1234" || state.GivernyVersion != "v1.2.3" {
+		t.Errorf("Unexpected task details in state: %+v", state)
+	}
+	if state.BaseImageDigest != "alpine:latest@sha256:0000" || state.MainImage != docker.MainImageName("alpine:latest") ||
+		state.MainImageID != "sha256:1111" || state.DockerfileHash != "2222" || state.ClaudeCodeVersion != "2.0.0 (Claude Code)" {
+		t.Errorf("Unexpected image details in state: %+v", state)
+	}
+	if state.GitPort != 9999 {
+		t.Errorf("Expected the git server's port in state, got %d", state.GitPort)
+	}
+}
+
+// TestRunWithDeps_BaseBranch verifies that --branch passes the base branch to innie
+// instead of creating the task branch on the host
+func TestRunWithDeps_BaseBranch(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	t.Run("passes base branch to innie", func(t *testing.T) {
+		branchCreated := false
+		var passedSpec taskspec.Spec
+
+		mockGit := gitops.NewMockGitOps()
+		mockGit.BranchExistsFunc = func(branchName string) (bool, error) {
+			return branchName == "feature/human-work", nil
+		}
+		mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
+			branchCreated = true
+			return nil
+		}
+
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			passedSpec = spec
+			return 0, nil
+		}
+
+		config := Config{
+			TaskID:     "test-task",
+			Prompt:     "test prompt",
+			BaseImage:  "alpine:latest",
+			BaseBranch: "feature/human-work",
+		}
+
+		if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if branchCreated {
+			t.Error("Expected task branch not to be created on the host")
+		}
+		if passedSpec.BaseBranch != "feature/human-work" || passedSpec.TargetBranch != "feature/human-work" {
+			t.Errorf("Expected the task spec to contain the base branch, got: %+v", passedSpec)
+		}
+	})
+
+	t.Run("fails when base branch does not exist", func(t *testing.T) {
+		mockGit := gitops.NewMockGitOps()
+		mockGit.BranchExistsFunc = func(branchName string) (bool, error) {
+			return false, nil
+		}
+
+		config := Config{
+			TaskID:     "test-task",
+			Prompt:     "test prompt",
+			BaseImage:  "alpine:latest",
+			BaseBranch: "feature/missing",
+		}
+
+		err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps())
+		if err == nil {
+			t.Fatal("Expected error when base branch does not exist")
+		}
+		if err.Error() != "branch 'feature/missing' does not exist" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("fails when task branch already exists", func(t *testing.T) {
+		mockGit := gitops.NewMockGitOps()
+
+		config := Config{
+			TaskID:     "test-task",
+			Prompt:     "test prompt",
+			BaseImage:  "alpine:latest",
+			BaseBranch: "feature/human-work",
+		}
+
+		err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps())
+		if err == nil {
+			t.Fatal("Expected error when task branch already exists")
+		}
+		if err.Error() != "branch 'giverny/test-task' already exists" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+// TestRunWithDeps_FromRef verifies that --from creates the task branch from the given ref
+func TestRunWithDeps_FromRef(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var startPointUsed string
+	dirtyCheckCalled := false
+
+	mockGit := gitops.NewMockGitOps()
+	mockGit.IsWorkspaceDirtyFunc = func() (bool, error) {
+		dirtyCheckCalled = true
+		return true, nil
+	}
+	mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
+		startPointUsed = startPoint
+		return nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		FromRef:   "v1.2.0",
+	}
+
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if startPointUsed != "v1.2.0" {
+		t.Errorf("Expected branch to be created from v1.2.0, got %q", startPointUsed)
+	}
+	if dirtyCheckCalled {
+		t.Error("Expected dirty check to be skipped with FromRef")
+	}
+}
+
+// TestRunWithDeps_ReuseContainer verifies that an existing container is restarted
+// against a git server on its original port
+func TestRunWithDeps_Provenance(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:     "test-task",
+		Prompt:     "test prompt",
+		BaseImage:  "alpine:latest",
+		Provenance: true,
+	}
+
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !passedSpec.Provenance || passedSpec.BaseImageDigest != "alpine:latest@sha256:0000" {
+		t.Errorf("Expected the task spec to enable provenance with the base image digest, got: %+v", passedSpec)
+	}
+}
+
+// TestRunWithDeps_LongPrompt verifies that a multi-line prompt is passed to
+// innie intact, and that one too long for the container's environment is
+// mounted from a file instead
+func TestRunWithDeps_LongPrompt(t *testing.T) {
+	tmpDir, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	var passedDockerArgs string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		passedDockerArgs = dockerArgs
+		return 0, nil
+	}
+
+	line := "Rename \"Foo\" to 'Bar' in $(every) `file`; keep\ttabs & ünïcödé.\n"
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    strings.Repeat(line, 100),
+		BaseImage: "alpine:latest",
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if passedSpec.Prompt != config.Prompt || passedSpec.PromptPath != "" {
+		t.Errorf("expected a %d byte prompt to be passed in the task spec, got %d bytes from %q", len(config.Prompt), len(passedSpec.Prompt), passedSpec.PromptPath)
+	}
+
+	config.TaskID = "test-task-2"
+	config.Prompt = strings.Repeat(line, 5000)
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if passedSpec.Prompt != "" || passedSpec.PromptPath != taskspec.PromptFile {
+		t.Fatalf("expected the prompt to be read from %s, got %+v", taskspec.PromptFile, passedSpec)
+	}
+	path := taskstate.PromptPath(filepath.Join(tmpDir, ".git"), "giverny-test-task-2")
+	if want := path + ":" + taskspec.PromptFile + ":ro"; !strings.Contains(passedDockerArgs, want) {
+		t.Errorf("expected the prompt file to be mounted with %q, got %q", want, passedDockerArgs)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != config.Prompt {
+		t.Errorf("expected the prompt in %s, got %d bytes, %v", path, len(data), err)
+	}
+
+	config.Prompt = "Fix\x00it"
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err == nil || !strings.Contains(err.Error(), "NUL") {
+		t.Errorf("expected a prompt with a NUL byte to be refused, got %v", err)
+	}
+}
+
+// TestRunWithDeps_HostUser verifies that innie is told who to give root's
+// files in the mounts from the host to
+func TestRunWithDeps_HostUser(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.HostUserFunc = func() string {
+		return "1000:1000"
+	}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if passedSpec.HostUser != "1000:1000" {
+		t.Errorf("expected the host user in the task spec, got %q", passedSpec.HostUser)
+	}
+}
+
+func TestRunWithDeps_ReviewRounds(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:       "test-task",
+		Prompt:       "test prompt",
+		BaseImage:    "alpine:latest",
+		ReviewRounds: 2,
+		ReviewerArgs: "--model opus",
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if passedSpec.ReviewRounds != 2 || passedSpec.ReviewerArgs != "--model opus" {
+		t.Errorf("expected the review settings in the task spec, got %d rounds with %q", passedSpec.ReviewRounds, passedSpec.ReviewerArgs)
+	}
+}
+
+func TestRunWithDeps_Checks(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Checks:    []string{"go build ./...", "GOOS=windows go build ./..."},
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(passedSpec.Checks, config.Checks) {
+		t.Errorf("expected the checks in the task spec, got %v", passedSpec.Checks)
+	}
+}
+
+func TestRunWithDeps_ScanPaths(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+
+	var passedSpec taskspec.Spec
+	var copiedFrom string
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+	mockDocker.CopyFromContainerFunc = func(containerName, srcPath, dstPath string) error {
+		copiedFrom = srcPath
+		return os.WriteFile(filepath.Join(dstPath, "notes.md"), []byte("notes"), 0644)
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		ScanPaths: []string{"/tmp", "/root"},
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(passedSpec.ScanPaths, config.ScanPaths) {
+		t.Errorf("expected the scan paths in the task spec, got %v", passedSpec.ScanPaths)
+	}
+	if copiedFrom != extrafiles.ExportDir+"/." {
+		t.Errorf("expected the export directory to be copied out of the container, got %q", copiedFrom)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "giverny", "giverny-test-task-files", "notes.md")); err != nil {
+		t.Errorf("expected the exported files alongside the task's other files: %v", err)
+	}
+}
+
+func TestRunWithDeps_Detached(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Detached:  true,
+	}
+
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !passedSpec.NonInteractive {
+		t.Errorf("Expected innie to run non-interactively, got: %+v", passedSpec)
+	}
+
+	// An attachable task keeps the menu, in a container that can be attached to
+	passedSpec = taskspec.Spec{}
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		t.Error("Expected the attachable container to be used")
+		return 0, nil
+	}
+	mockDocker.RunContainerAttachableFunc = func(spec taskspec.Spec, baseImage, dockerArgs string) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+	config.TaskID = "test-task-2"
+	config.Attachable = true
+
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if passedSpec.TaskID == "" {
+		t.Fatal("Expected the attachable container to be run")
+	}
+	if passedSpec.NonInteractive {
+		t.Errorf("Expected an attachable task to keep the menu, got: %+v", passedSpec)
+	}
+}
+
+func TestRunWithDeps_ReuseContainer(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	t.Run("restarts existing container", func(t *testing.T) {
+		var callSequence []string
+
+		mockGit := gitops.NewMockGitOps()
+		mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
+			callSequence = append(callSequence, "CreateBranch")
+			return nil
+		}
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+			callSequence = append(callSequence, "StartServer")
+			return &git.ServerCmd{}, 9999, nil
+		}
+		mockGit.StartServerOnPortFunc = func(repoPath, bindAddr string, port int, token, branchName string) (*git.ServerCmd, error) {
+			callSequence = append(callSequence, fmt.Sprintf("StartServerOnPort(%d, %s)", port, token))
+			return &git.ServerCmd{}, nil
+		}
+
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.InspectContainerFunc = func(containerName string) (*docker.ContainerInfo, error) {
+			return &docker.ContainerInfo{GitServerPort: 4242, GitToken: "0123abcd"}, nil
+		}
+		mockDocker.BuildImageFunc = func(baseImage string, opts docker.BuildOptions, showOutput bool, forceRebuild bool, debug bool) error {
+			callSequence = append(callSequence, "BuildImage")
+			return nil
+		}
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			callSequence = append(callSequence, "RunContainer")
+			return 0, nil
+		}
+		mockDocker.StartContainerFunc = func(containerName string, output docker.OutputOptions) (int, error) {
+			callSequence = append(callSequence, "StartContainer("+containerName+")")
+			return 0, nil
+		}
+
+		config := Config{
+			TaskID:         "test-task",
+			Prompt:         "test prompt",
+			BaseImage:      "alpine:latest",
+			ReuseContainer: true,
+		}
+
+		if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []string{"StartServerOnPort(4242, 0123abcd)", "StartContainer(giverny-test-task)"}
+		if strings.Join(callSequence, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected calls %v, got %v", expected, callSequence)
+		}
+	})
+
+	t.Run("moves the git server when its port is taken", func(t *testing.T) {
+		var callSequence []string
+
+		mockGit := gitops.NewMockGitOps()
+		mockGit.ReclaimServerPortFunc = func(repoPath string, port int) (bool, error) {
+			callSequence = append(callSequence, fmt.Sprintf("ReclaimServerPort(%d)", port))
+			return false, nil
+		}
+		mockGit.StartServerFunc = func(repoPath, bindAddr, token, branchName string) (*git.ServerCmd, int, error) {
+			callSequence = append(callSequence, "StartServer")
+			return &git.ServerCmd{}, 5353, nil
+		}
+
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.InspectContainerFunc = func(containerName string) (*docker.ContainerInfo, error) {
+			return &docker.ContainerInfo{GitServerPort: 4242}, nil
+		}
+		mockDocker.SetGitServerPortFunc = func(containerName string, port int) error {
+			callSequence = append(callSequence, fmt.Sprintf("SetGitServerPort(%s, %d)", containerName, port))
+			return nil
+		}
+		mockDocker.StartContainerFunc = func(containerName string, output docker.OutputOptions) (int, error) {
+			callSequence = append(callSequence, "StartContainer("+containerName+")")
+			return 0, nil
+		}
+
+		// The port recorded in the task state wins over the container's
+		if err := taskstate.Save(".git", "giverny-test-task", taskstate.State{TaskID: "test-task", GitPort: 4343}); err != nil {
+			t.Fatalf("failed to save task state: %v", err)
+		}
+		defer os.RemoveAll(filepath.Join(".git", "giverny"))
+
+		config := Config{
+			TaskID:         "test-task",
+			Prompt:         "test prompt",
+			BaseImage:      "alpine:latest",
+			ReuseContainer: true,
+		}
+
+		if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []string{"ReclaimServerPort(4343)", "StartServer", "SetGitServerPort(giverny-test-task, 5353)", "StartContainer(giverny-test-task)"}
+		if strings.Join(callSequence, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected calls %v, got %v", expected, callSequence)
+		}
+		state, err := taskstate.Load(".git", "giverny-test-task")
+		if err != nil {
+			t.Fatalf("failed to load task state: %v", err)
+		}
+		if state.GitPort != 5353 {
+			t.Errorf("expected the new port to be recorded, got %d", state.GitPort)
+		}
+	})
+
+	t.Run("starts a new container when none exists", func(t *testing.T) {
+		containerRan := false
+
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			containerRan = true
+			return 0, nil
+		}
+
+		config := Config{
+			TaskID:         "test-task",
+			Prompt:         "test prompt",
+			BaseImage:      "alpine:latest",
+			ReuseContainer: true,
+		}
+
+		if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !containerRan {
+			t.Error("Expected a new container to be run")
+		}
+	})
+}
+
+// TestRunWithDeps_VerifiesPush verifies that the commit innie reports pushing is
+// checked against the branch on the host
+func TestRunWithDeps_VerifiesPush(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	// Set token for test
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	// reportPush sends a PUSHED message to the control server named in dockerArgs,
+	// as innie would
+	reportPush := func(dockerArgs, commit string) error {
+		_, addr, _ := strings.Cut(dockerArgs, ctrlsock.EnvVar+"=host.docker.internal")
+		return ctrlsock.Send("127.0.0.1"+strings.Fields(addr)[0], "PUSHED giverny/test-task "+commit)
+	}
+
+	tests := []struct {
+		name        string
+		hostCommit  string
+		shouldError bool
+	}{
+		{"matching commit", "abc1234", false},
+		{"mismatched commit", "0000000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockGit := gitops.NewMockGitOps()
+			mockGit.GetCommitHashFunc = func(ref string) (string, error) {
+				return tt.hostCommit, nil
+			}
+
+			mockDocker := dockerops.NewMockDockerOps()
+			mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+				return 0, reportPush(dockerArgs, "abc1234")
+			}
+
+			config := Config{
+				TaskID:    "test-task",
+				Prompt:    "test prompt",
+				BaseImage: "alpine:latest",
+			}
+
+			err := RunWithDeps(config, mockGit, mockDocker)
+			if tt.shouldError {
+				if err == nil || !strings.Contains(err.Error(), "push verification failed") {
+					t.Errorf("Expected push verification error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestRunWithDeps_BranchUser verifies that the task branch is put under the
+// user's name and innie is told to use it
+func TestRunWithDeps_BranchUser(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	mockGit := gitops.NewMockGitOps()
+	var createdBranch string
+	mockGit.CreateBranchFunc = func(branchName, startPoint string) error {
+		createdBranch = branchName
+		return nil
+	}
+	var passedSpec taskspec.Spec
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		passedSpec = spec
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:     "test-task",
+		Slug:       "fix-bug",
+		BranchUser: "hugh",
+		Prompt:     "test prompt",
+		BaseImage:  "alpine:latest",
+		AllowDirty: true,
+	}
+	if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if createdBranch != "giverny/hugh/test-task-fix-bug" {
+		t.Errorf("expected branch giverny/hugh/test-task-fix-bug, got %q", createdBranch)
+	}
+	if passedSpec.BranchUser != "hugh" {
+		t.Errorf("expected the branch user in the task spec, got %q", passedSpec.BranchUser)
+	}
+}
+
+// TestRunWithDeps_PushToRemote verifies that the finished branch is pushed to
+// the remote, and that a remote that doesn't exist is refused up front
+func TestRunWithDeps_PushToRemote(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	config := Config{
+		TaskID:       "test-task",
+		Prompt:       "test prompt",
+		BaseImage:    "alpine:latest",
+		PushToRemote: "upstream",
+	}
+
+	mockGit := gitops.NewMockGitOps()
+	mockGit.RemoteExistsFunc = func(remote string) (bool, error) {
+		return false, nil
+	}
+	runCalled := false
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		runCalled = true
+		return 0, nil
+	}
+	if err := RunWithDeps(config, mockGit, mockDocker); err == nil || !strings.Contains(err.Error(), "no remote named 'upstream'") {
+		t.Fatalf("expected a missing remote error, got %v", err)
+	}
+	if runCalled {
+		t.Error("expected the container not to be run")
+	}
+
+	var pushedRemote, pushedBranch string
+	mockGit = gitops.NewMockGitOps()
+	mockGit.GetBranchCommitRangeFunc = func(branchName, baseBranch string) (string, string, error) {
+		return "abc1234", "def5678", nil
+	}
+	mockGit.PushBranchToRemoteFunc = func(remote, branchName string) error {
+		pushedRemote, pushedBranch = remote, branchName
+		return nil
+	}
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pushedRemote != "upstream" || pushedBranch != "giverny/test-task" {
+		t.Errorf("expected giverny/test-task pushed to upstream, got %q to %q", pushedBranch, pushedRemote)
+	}
+
+	// A failed push doesn't fail the task
+	mockGit.PushBranchToRemoteFunc = func(remote, branchName string) error {
+		return fmt.Errorf("rejected")
+	}
+	if err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Errorf("expected a failed push to be a warning, got %v", err)
+	}
+}
+
+// TestRunWithDeps_ChecksHead verifies that tasks aren't started from a
+// protected branch or mid-rebase unless forced, or not from HEAD at all
+func TestRunWithDeps_ChecksHead(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+	}
+
+	mockGit := gitops.NewMockGitOps()
+	mockGit.CurrentBranchFunc = func() (string, error) {
+		return "release/1.0", nil
+	}
+	mockGit.ProtectedBranchesFunc = func() ([]string, error) {
+		return []string{"production", "release/*"}, nil
+	}
+	err := RunWithDeps(config, mockGit, dockerops.NewMockDockerOps())
+	if err == nil || !strings.Contains(err.Error(), "HEAD is on release/1.0, which matches protected branch pattern 'release/*'") {
+		t.Fatalf("expected a protected branch error, got %v", err)
+	}
+
+	mockGit.InProgressOperationFunc = func() (string, error) {
+		return "rebase", nil
+	}
+	err = RunWithDeps(config, mockGit, dockerops.NewMockDockerOps())
+	if err == nil || !strings.Contains(err.Error(), "a rebase is in progress") {
+		t.Fatalf("expected an in-progress rebase error, got %v", err)
+	}
+
+	forced := config
+	forced.Force = true
+	if err := RunWithDeps(forced, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Errorf("expected --force to start the task, got %v", err)
+	}
+
+	fromTag := config
+	fromTag.FromRef = "v1.0"
+	if err := RunWithDeps(fromTag, mockGit, dockerops.NewMockDockerOps()); err != nil {
+		t.Errorf("expected a task not started from HEAD to be allowed, got %v", err)
+	}
+}
+
+// TestRunWithDeps_Autostash verifies that uncommitted changes are stashed
+// for the task and restored afterwards, even when it fails
+func TestRunWithDeps_Autostash(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	var events []string
+	mockGit := gitops.NewMockGitOps()
+	mockGit.IsWorkspaceDirtyFunc = func() (bool, error) {
+		return true, nil
+	}
+	mockGit.StashChangesFunc = func(message string) (string, error) {
+		events = append(events, "stash")
+		return "abc1234", nil
+	}
+	mockGit.RestoreStashFunc = func(stash string) error {
+		events = append(events, "restore "+stash)
+		return nil
+	}
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		events = append(events, "run")
+		return 1, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Autostash: true,
+	}
+	if err := RunWithDeps(config, mockGit, mockDocker); err == nil {
+		t.Fatal("expected the failed container to fail the task")
+	}
+	if got := strings.Join(events, ", "); got != "stash, run, restore abc1234" {
+		t.Errorf("expected the changes stashed around the run, got %q", got)
+	}
+
+	config.AllowDirty = true
+	if err := RunWithDeps(config, mockGit, mockDocker); err == nil || !strings.Contains(err.Error(), "cannot be used together") {
+		t.Errorf("expected --autostash with --allow-dirty to be refused, got %v", err)
+	}
+}
+
+// TestRunWithDeps_OptimizeClone verifies that a large repository is cloned
+// partially with --optimize-clone, unless the clone was already limited
+func TestRunWithDeps_OptimizeClone(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	run := func(config Config, size git.RepoSize) taskspec.Spec {
+		t.Helper()
+		mockGit := gitops.NewMockGitOps()
+		mockGit.MeasureRepoFunc = func() (git.RepoSize, error) {
+			return size, nil
+		}
+		var passedSpec taskspec.Spec
+		mockDocker := dockerops.NewMockDockerOps()
+		mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+			passedSpec = spec
+			return 0, nil
+		}
+		if err := RunWithDeps(config, mockGit, mockDocker); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return passedSpec
+	}
+
+	config := Config{
+		TaskID:        "test-task",
+		Prompt:        "test prompt",
+		BaseImage:     "alpine:latest",
+		OptimizeClone: true,
+	}
+	large := git.RepoSize{Bytes: 2 << 30, Files: 1000, PartialBytes: 100 << 20}
+
+	if spec := run(config, large); spec.CloneFilter != "blob:none" {
+		t.Errorf("expected a partial clone of a large repository, got %q", spec.CloneFilter)
+	}
+	if spec := run(config, git.RepoSize{Bytes: 1 << 20, Files: 10}); spec.CloneFilter != "" {
+		t.Errorf("expected a full clone of a small repository, got %q", spec.CloneFilter)
+	}
+
+	config.CloneDepth = 1
+	if spec := run(config, large); spec.CloneFilter != "" {
+		t.Errorf("expected --clone-depth to be left alone, got %q", spec.CloneFilter)
+	}
+
+	config.CloneDepth = 0
+	config.OptimizeClone = false
+	if spec := run(config, large); spec.CloneFilter != "" {
+		t.Errorf("expected only a suggestion without --optimize-clone, got %q", spec.CloneFilter)
+	}
+}
+
+// TestRunWithDeps_Output verifies that the output options are passed on to
+// the container
+func TestRunWithDeps_Output(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	want := docker.OutputOptions{Timestamps: true, LogFile: "/tmp/task.log"}
+	var got []docker.OutputOptions
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		got = append(got, output)
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:    "test-task",
+		Prompt:    "test prompt",
+		BaseImage: "alpine:latest",
+		Output:    want,
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("RunWithDeps failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected the container to be run with %+v, got %+v", want, got)
+	}
+}
+
+// TestRunWithDeps_DangerousDockerArgs verifies that docker args that defeat
+// the sandbox are refused without --i-know-what-im-doing, while those
+// giverny adds itself for --enable-docker are not
+func TestRunWithDeps_DangerousDockerArgs(t *testing.T) {
+	_, cleanup := setupTestDir(t)
+	defer cleanup()
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", "test-token")
+
+	ran := false
+	mockDocker := dockerops.NewMockDockerOps()
+	mockDocker.RunContainerFunc = func(spec taskspec.Spec, baseImage, dockerArgs string, output docker.OutputOptions) (int, error) {
+		ran = true
+		return 0, nil
+	}
+
+	config := Config{
+		TaskID:     "test-task",
+		Prompt:     "test prompt",
+		BaseImage:  "alpine:latest",
+		DockerArgs: "--cpus 2 -v /var/run/docker.sock:/var/run/docker.sock",
+	}
+	err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker)
+	if err == nil || !strings.Contains(err.Error(), "Docker socket") || !strings.Contains(err.Error(), "--i-know-what-im-doing") {
+		t.Fatalf("expected the Docker socket mount to be refused, got %v", err)
+	}
+	if ran {
+		t.Error("expected the container not to run")
+	}
+
+	config.UnsafeDockerArgs = true
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("expected --i-know-what-im-doing to allow the docker args, got %v", err)
+	}
+	if !ran {
+		t.Error("expected the container to run")
+	}
+
+	ran = false
+	config = Config{
+		TaskID:       "test-task",
+		Prompt:       "test prompt",
+		BaseImage:    "alpine:latest",
+		EnableDocker: docker.DockerModeSocket,
+	}
+	if err := RunWithDeps(config, gitops.NewMockGitOps(), mockDocker); err != nil {
+		t.Fatalf("expected --enable-docker to be allowed, got %v", err)
+	}
+	if !ran {
+		t.Error("expected the container to run")
+	}
+}
+
+func TestRetryCommand(t *testing.T) {
+	tests := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{TaskID: "PROJ-1"}, "giverny --reuse-container PROJ-1"},
+		{Config{TaskID: "PROJ-1", Slug: "fix-login"}, "giverny --reuse-container --slug fix-login PROJ-1"},
+		{Config{TaskID: "PROJ-1", Slug: "fix-login", BranchUser: "alice"}, "giverny --reuse-container --slug fix-login --user-branch PROJ-1"},
+	}
+	for _, tt := range tests {
+		if got := retryCommand(tt.config); got != tt.expected {
+			t.Errorf("retryCommand(%+v) = %q, want %q", tt.config, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package outie
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	dockerpkg "giverny/internal/docker"
+	gitpkg "giverny/internal/git"
+	"giverny/internal/taskid"
+)
+
+// SampleFlag is the hidden flag giverny is started again with, and the
+// sample's number, to run one of the samples of a task run with --samples
+const SampleFlag = "--sample"
+
+// SampleTaskID returns the task ID of sample i of taskID
+func SampleTaskID(taskID string, i int) string {
+	return fmt.Sprintf("%s-%d", taskID, i)
+}
+
+// Sample is one of the runs of a task started by RunSamples, and what it
+// produced
+type Sample struct {
+	TaskID  string
+	Branch  string
+	LogPath string
+	// Err is why the run failed, nil if it succeeded
+	Err error
+
+	Commits int
+	Files   int
+	Added   int
+	Deleted int
+	// Passed is how many of the verification commands passed on the branch
+	Passed int
+}
+
+// SamplesConfig describes a task run with --samples
+type SamplesConfig struct {
+	TaskID     string
+	Slug       string
+	BranchUser string
+	// TargetBranch is the branch the samples are compared against (default
+	// the repository's default branch)
+	TargetBranch string
+	// Verify lists the commands run on each sample's branch to compare them
+	Verify []string
+}
+
+// RunSamples runs the task n times in parallel, each in its own container on
+// its own branch, by starting giverny again with args, SampleFlag and
+// DetachedFlag, and once all have finished prints how they compare so the
+// user can pick the best. The samples run without the menu, each writing its
+// output to a log, and stop with giverny if it is interrupted.
+func RunSamples(config SamplesConfig, args []string, n int) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find giverny executable: %w", err)
+	}
+	repo, err := gitpkg.Open(".")
+	if err != nil {
+		return fmt.Errorf("failed to find project root: %w", err)
+	}
+
+	samples := make([]Sample, n)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := range samples {
+		sample := &samples[i]
+		sample.TaskID = SampleTaskID(config.TaskID, i+1)
+		sample.Branch = taskid.BranchName(config.BranchUser, sample.TaskID, config.Slug)
+		// The samples already started carry on if one can't be
+		var cmd *exec.Cmd
+		var logFile *os.File
+		sample.LogPath, err = DetachedLogPath(dockerpkg.ContainerName(sample.TaskID, config.Slug))
+		if err == nil {
+			cmd, logFile, err = sampleCommand(executable, args, i+1, sample.LogPath)
+		}
+		if err == nil {
+			if err = cmd.Start(); err != nil {
+				logFile.Close()
+			}
+		}
+		if err != nil {
+			sample.Err = fmt.Errorf("failed to start: %w", err)
+			fmt.Fprintf(os.Stderr, "❌ Sample %s %v\n", sample.TaskID, sample.Err)
+			continue
+		}
+		fmt.Printf("Started sample %s (log: %s)\n", sample.TaskID, sample.LogPath)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer logFile.Close()
+			sample.Err = cmd.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if sample.Err != nil {
+				fmt.Printf("❌ Sample %s failed: %v\n", sample.TaskID, sample.Err)
+			} else {
+				fmt.Printf("✓ Sample %s finished\n", sample.TaskID)
+			}
+		}()
+	}
+	fmt.Printf("Follow a sample with: giverny watch %s\n", TaskArgs(SampleTaskID(config.TaskID, 1), config.Slug, config.BranchUser))
+	wg.Wait()
+
+	targetBranch := config.TargetBranch
+	if targetBranch == "" {
+		targetBranch = repo.DefaultBranch()
+	}
+	for i := range samples {
+		measureSample(repo, &samples[i], targetBranch, config.Verify)
+	}
+
+	fmt.Printf("\nSamples of %s compared with %s:\n", config.TaskID, targetBranch)
+	printSamples(os.Stdout, samples, len(config.Verify))
+	fmt.Printf("\nTo see a sample's changes:\n")
+	fmt.Printf("  git diff %s...%s\n", targetBranch, samples[0].Branch)
+	fmt.Printf("To keep one, merge its branch and delete the others with git branch -D\n")
+	return nil
+}
+
+// sampleCommand returns the command that runs sample i of the task, with its
+// output going to the log file at logPath, which the caller must close
+func sampleCommand(executable string, args []string, i int, logPath string) (*exec.Cmd, *os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+	cmd := exec.Command(executable, append(args, SampleFlag, strconv.Itoa(i), DetachedFlag)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd, logFile, nil
+}
+
+// measureSample fills in how big sample's changes to targetBranch are and,
+// with verification commands, how many pass on its branch, whose output is
+// appended to its log. A sample that failed, or whose branch has no commits,
+// is left as it is.
+func measureSample(repo *gitpkg.Repository, sample *Sample, targetBranch string, verify []string) {
+	if sample.Err != nil {
+		return
+	}
+	base, err := repo.MergeBase(targetBranch, sample.Branch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to compare %s with %s: %v\n", sample.Branch, targetBranch, err)
+		return
+	}
+	subjects, err := repo.CommitSubjects(base, sample.Branch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to list the commits of %s: %v\n", sample.Branch, err)
+		return
+	}
+	sample.Commits = len(subjects)
+	if sample.Commits == 0 {
+		return
+	}
+	stats, err := repo.DiffStats(base, sample.Branch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	sample.Files = len(stats)
+	for _, stat := range stats {
+		sample.Added += stat.Added
+		sample.Deleted += stat.Deleted
+	}
+
+	if len(verify) == 0 {
+		return
+	}
+	fmt.Printf("Verifying %s...\n", sample.Branch)
+	var out io.Writer = io.Discard
+	if logFile, err := os.OpenFile(sample.LogPath, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+		defer logFile.Close()
+		out = logFile
+	}
+	results, err := repo.VerifyBranch(sample.Branch, verify, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to verify %s: %v\n", sample.Branch, err)
+		return
+	}
+	for _, result := range results {
+		if result.Passed {
+			sample.Passed++
+		}
+	}
+}
+
+// printSamples writes a table comparing samples to w, with how many of
+// verifyCount verification commands passed if there were any
+func printSamples(w io.Writer, samples []Sample, verifyCount int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "  BRANCH\tRESULT\tCOMMITS\tFILES\tLINES"
+	if verifyCount > 0 {
+		header += "\tVERIFY"
+	}
+	fmt.Fprintln(tw, header)
+	for _, sample := range samples {
+		if sample.Err != nil {
+			fmt.Fprintf(tw, "  %s\tfailed\t-\t-\t-", sample.Branch)
+			if verifyCount > 0 {
+				fmt.Fprint(tw, "\t-")
+			}
+			fmt.Fprintln(tw)
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\tok\t%d\t%d\t+%d -%d", sample.Branch, sample.Commits, sample.Files, sample.Added, sample.Deleted)
+		if verifyCount > 0 {
+			if sample.Commits == 0 {
+				fmt.Fprint(tw, "\t-")
+			} else {
+				fmt.Fprintf(tw, "\t%d/%d passed", sample.Passed, verifyCount)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,88 @@
+package outie
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitpkg "giverny/internal/git"
+	"giverny/internal/testutil"
+)
+
+func TestMeasureSample(t *testing.T) {
+	dir := t.TempDir()
+	testutil.InitTestRepo(t, dir)
+	for _, args := range [][]string{
+		{"git", "checkout", "-q", "-b", "giverny/task-1"},
+		{"sh", "-c", "printf 'a\\nb\\n' > new.txt && echo changed > test.txt"},
+		{"git", "add", "."},
+		{"git", "commit", "-q", "-m", "Sample work"},
+		{"git", "checkout", "-q", "-b", "giverny/task-2", "main"},
+	} {
+		if output, err := testutil.Command(dir, args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%v failed: %v\n%s", args, err, output)
+		}
+	}
+	repo, err := gitpkg.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open repository: %v", err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "task-1.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sample := Sample{TaskID: "task-1", Branch: "giverny/task-1", LogPath: logPath}
+	measureSample(repo, &sample, "main", []string{"test -f new.txt", "echo verified; false"})
+	if sample.Commits != 1 || sample.Files != 2 || sample.Added != 3 || sample.Deleted != 1 {
+		t.Errorf("measureSample = %d commits, %d files, +%d -%d, want 1 commit, 2 files, +3 -1", sample.Commits, sample.Files, sample.Added, sample.Deleted)
+	}
+	if sample.Passed != 1 {
+		t.Errorf("expected 1 verification command to pass, got %d", sample.Passed)
+	}
+	if log, _ := os.ReadFile(logPath); !strings.Contains(string(log), "verified") {
+		t.Errorf("expected the verification output in the sample's log, got %q", log)
+	}
+
+	// A sample that made no commits isn't verified
+	empty := Sample{TaskID: "task-2", Branch: "giverny/task-2"}
+	measureSample(repo, &empty, "main", []string{"true"})
+	if empty.Commits != 0 || empty.Passed != 0 {
+		t.Errorf("expected an empty sample to be left alone, got %+v", empty)
+	}
+}
+
+func TestPrintSamples(t *testing.T) {
+	samples := []Sample{
+		{Branch: "giverny/task-1", Commits: 2, Files: 3, Added: 40, Deleted: 5, Passed: 2},
+		{Branch: "giverny/task-2", Err: errors.New("exit status 1")},
+		{Branch: "giverny/task-3"},
+	}
+
+	var out bytes.Buffer
+	printSamples(&out, samples, 2)
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and a line per sample, got:\n%s", out.String())
+	}
+	for i, want := range [][]string{
+		{"BRANCH", "RESULT", "COMMITS", "FILES", "LINES", "VERIFY"},
+		{"giverny/task-1", "ok", "2", "3", "+40", "-5", "2/2", "passed"},
+		{"giverny/task-2", "failed", "-", "-", "-", "-"},
+		{"giverny/task-3", "ok", "0", "0", "+0", "-0", "-"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("line %d = %q, want fields %v", i, lines[i], want)
+		}
+	}
+
+	// Without verification commands there is no VERIFY column
+	out.Reset()
+	printSamples(&out, samples[:1], 0)
+	if strings.Contains(out.String(), "VERIFY") || strings.Contains(out.String(), "passed") {
+		t.Errorf("expected no verification column, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+
+	"giverny/internal/git"
+)
+
+// ArtifactPatterns match paths that builds and tools produce and that are
+// rarely meant to be committed. They use the same syntax as Policy.Deny.
+var ArtifactPatterns = []string{
+	"node_modules",
+	"dist",
+	"__pycache__",
+	".pytest_cache",
+	".venv",
+	"*.o",
+	"*.obj",
+	"*.a",
+	"*.so",
+	"*.dylib",
+	"*.dll",
+	"*.exe",
+	"*.class",
+	"*.pyc",
+	".DS_Store",
+}
+
+// LargeBinaryKB is the size above which a committed binary file is treated as
+// a build artifact.
+const LargeBinaryKB = 1024
+
+// Artifact is a committed file that looks like a build artifact.
+type Artifact struct {
+	Path   string
+	Reason string
+}
+
+func (a Artifact) String() string {
+	return fmt.Sprintf("%s (%s)", a.Path, a.Reason)
+}
+
+// Artifacts returns the files added or modified in stats that look like build
+// artifacts: those ignored lists (paths .gitignore says not to commit), those
+// matching ArtifactPatterns, and binary files larger than LargeBinaryKB.
+// Deleted files are skipped.
+func Artifacts(stats []git.FileStat, ignored []string) []Artifact {
+	isIgnored := make(map[string]bool)
+	for _, path := range ignored {
+		isIgnored[path] = true
+	}
+	var matchers []*regexp.Regexp
+	for _, pattern := range ArtifactPatterns {
+		matchers = append(matchers, compile(pattern))
+	}
+
+	var artifacts []Artifact
+	for _, stat := range stats {
+		if stat.Size == 0 && stat.Added == 0 {
+			continue
+		}
+		switch {
+		case isIgnored[stat.Path]:
+			artifacts = append(artifacts, Artifact{stat.Path, "ignored by .gitignore"})
+		case matchesAny(matchers, stat.Path):
+			artifacts = append(artifacts, Artifact{stat.Path, "build output"})
+		case stat.Binary && stat.Size > LargeBinaryKB*1024:
+			artifacts = append(artifacts, Artifact{stat.Path, fmt.Sprintf("%d KB binary", (stat.Size+1023)/1024)})
+		}
+	}
+	return artifacts
+}
+
+func matchesAny(matchers []*regexp.Regexp, path string) bool {
+	for _, m := range matchers {
+		if m.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
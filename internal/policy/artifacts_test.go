@@ -0,0 +1,31 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"giverny/internal/git"
+)
+
+func TestArtifacts(t *testing.T) {
+	stats := []git.FileStat{
+		{Path: "main.go", Added: 30, Size: 900},
+		{Path: "web/node_modules/left-pad/index.js", Added: 10, Size: 200},
+		{Path: "src/util.o", Binary: true, Size: 8 * 1024},
+		{Path: "debug.log", Added: 5, Size: 100},
+		{Path: "assets/video.mp4", Binary: true, Size: 3 * 1024 * 1024},
+		{Path: "logo.png", Binary: true, Size: 4 * 1024},
+		{Path: "dist/old.js", Deleted: 12},
+	}
+
+	got := Artifacts(stats, []string{"debug.log"})
+	expected := []Artifact{
+		{"web/node_modules/left-pad/index.js", "build output"},
+		{"src/util.o", "build output"},
+		{"debug.log", "ignored by .gitignore"},
+		{"assets/video.mp4", "3072 KB binary"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Artifacts() = %v, want %v", got, expected)
+	}
+}
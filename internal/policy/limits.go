@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"fmt"
+
+	"giverny/internal/git"
+)
+
+// Limits caps the size of the agent's changes. Zero fields are not checked.
+type Limits struct {
+	MaxFiles    int // files added, modified or deleted
+	MaxLines    int // lines added plus lines deleted
+	MaxBinaryKB int // size of any single binary file added or modified
+}
+
+// IsZero reports whether no limits are set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Check returns a description of each limit the changes exceed.
+func (l Limits) Check(stats []git.FileStat) []string {
+	var problems []string
+
+	if l.MaxFiles > 0 && len(stats) > l.MaxFiles {
+		problems = append(problems, fmt.Sprintf("%d files changed (limit %d)", len(stats), l.MaxFiles))
+	}
+
+	if l.MaxLines > 0 {
+		lines := 0
+		for _, stat := range stats {
+			lines += stat.Added + stat.Deleted
+		}
+		if lines > l.MaxLines {
+			problems = append(problems, fmt.Sprintf("%d lines changed (limit %d)", lines, l.MaxLines))
+		}
+	}
+
+	if l.MaxBinaryKB > 0 {
+		for _, stat := range stats {
+			if stat.Binary && stat.Size > int64(l.MaxBinaryKB)*1024 {
+				problems = append(problems, fmt.Sprintf("binary file %s is %d KB (limit %d KB)", stat.Path, (stat.Size+1023)/1024, l.MaxBinaryKB))
+			}
+		}
+	}
+
+	return problems
+}
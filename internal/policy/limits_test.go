@@ -0,0 +1,37 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"giverny/internal/git"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	stats := []git.FileStat{
+		{Path: "main.go", Added: 30, Deleted: 10, Size: 900},
+		{Path: "main_test.go", Added: 20, Size: 500},
+		{Path: "build/app", Binary: true, Size: 300 * 1024},
+		{Path: "logo.png", Binary: true, Size: 4 * 1024},
+	}
+
+	tests := []struct {
+		name     string
+		limits   Limits
+		expected []string
+	}{
+		{"no limits", Limits{}, nil},
+		{"within limits", Limits{MaxFiles: 4, MaxLines: 60, MaxBinaryKB: 300}, nil},
+		{"too many files", Limits{MaxFiles: 3}, []string{"4 files changed (limit 3)"}},
+		{"too many lines", Limits{MaxLines: 59}, []string{"60 lines changed (limit 59)"}},
+		{"large binary", Limits{MaxBinaryKB: 100}, []string{"binary file build/app is 300 KB (limit 100 KB)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.Check(stats); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Check() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
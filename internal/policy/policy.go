@@ -0,0 +1,80 @@
+// Package policy checks the agent's changes against rules set on the host.
+package policy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Policy is a denylist of paths the agent must not modify.
+type Policy struct {
+	// Deny holds path patterns. "*" matches within a path segment, "**"
+	// matches across segments and a pattern without a "/" matches a file or
+	// directory name at any depth, as in .gitignore.
+	Deny []string
+	// Strict blocks the push while there are violations instead of letting
+	// the user override them.
+	Strict bool
+}
+
+// Violations returns the paths that match one of the deny patterns, in the
+// order given.
+func (p Policy) Violations(paths []string) []string {
+	var matchers []*regexp.Regexp
+	for _, pattern := range p.Deny {
+		matchers = append(matchers, compile(pattern))
+	}
+
+	var violations []string
+	for _, path := range paths {
+		for _, m := range matchers {
+			if m.MatchString(path) {
+				violations = append(violations, path)
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// Match reports whether path matches the deny pattern.
+func Match(pattern, path string) bool {
+	return compile(pattern).MatchString(path)
+}
+
+// compile turns a deny pattern into a regular expression matching the paths
+// it covers. A pattern also matches everything below a directory it names.
+func compile(pattern string) *regexp.Regexp {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				// "**/" also matches no directories at all
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(/.*)?$")
+	return regexp.MustCompile(b.String())
+}
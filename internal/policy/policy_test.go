@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{".github/workflows/**", ".github/workflows/ci.yml", true},
+		{".github/workflows/**", ".github/workflows/nested/ci.yml", true},
+		{".github/workflows/**", ".github/CODEOWNERS", false},
+		{"secrets/**", "secrets/key.pem", true},
+		{"secrets/**", "config/secrets/key.pem", false},
+		{"secrets", "config/secrets/key.pem", true},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "tools/go.mod", true},
+		{"/go.mod", "tools/go.mod", false},
+		{"/go.mod", "go.mod", true},
+		{"*.pem", "certs/server.pem", true},
+		{"*.pem", "certs/server.pem.txt", false},
+		{"docs/*.md", "docs/README.md", true},
+		{"docs/*.md", "docs/api/README.md", false},
+		{"docs/**/*.md", "docs/README.md", true},
+		{"docs/**/*.md", "docs/api/README.md", true},
+		{"v?.txt", "v1.txt", true},
+		{"v?.txt", "v10.txt", false},
+		{"go.mod", "go.modx", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.path); got != tt.expected {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestViolations(t *testing.T) {
+	p := Policy{Deny: []string{".github/workflows/**", "go.mod"}}
+	paths := []string{"main.go", ".github/workflows/ci.yml", "go.mod", "go.sum"}
+
+	got := p.Violations(paths)
+	if strings.Join(got, ",") != ".github/workflows/ci.yml,go.mod" {
+		t.Errorf("Violations() = %v, want [.github/workflows/ci.yml go.mod]", got)
+	}
+
+	if got := (Policy{}).Violations(paths); len(got) != 0 {
+		t.Errorf("empty policy should allow everything, got %v", got)
+	}
+}
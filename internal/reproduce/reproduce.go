@@ -0,0 +1,116 @@
+// Package reproduce recreates the environment a past task ran in, from the
+// state recorded when it started, so its behaviour can be investigated.
+package reproduce
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"giverny/internal/docker"
+	"giverny/internal/git"
+	"giverny/internal/taskstate"
+)
+
+// Run recreates the environment of the task run in containerName: the image
+// it ran in and its branch at the START label, checked out in a throwaway
+// clone of the repository in the current directory. It then opens a shell
+// in a new container of the image with the clone as the workspace. The clone
+// is removed when the shell exits.
+func Run(containerName string, showBuildOutput, debug bool) error {
+	repo, err := git.Open(".")
+	if err != nil {
+		return err
+	}
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return err
+	}
+	state, err := taskstate.Load(gitDir, containerName)
+	if err != nil {
+		return err
+	}
+	if state.StartCommit == "" {
+		return fmt.Errorf("no start commit was recorded for container '%s'", containerName)
+	}
+
+	image, err := findImage(state, showBuildOutput, debug)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "giverny-reproduce-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	workspace := filepath.Join(tmpDir, "workspace")
+	if err := git.CloneAtCommit(repo.Path(), workspace, state.Branch, state.StartCommit); err != nil {
+		return err
+	}
+
+	fmt.Printf("Reproducing task %s: %s at %s in %s\n", state.TaskID, state.Branch, git.GetShortHashInDir(workspace, state.StartCommit), image)
+	fmt.Printf("The workspace is a throwaway clone; changes are lost when the shell exits.\n")
+	return docker.RunShell(image, workspace, git.DefaultWorkspaceDir)
+}
+
+// findImage returns the image the task ran in if it is still present, or
+// else rebuilds it from the recorded base image, warning about anything that
+// differs from the original.
+func findImage(state *taskstate.State, showBuildOutput, debug bool) (string, error) {
+	if state.MainImageID != "" && docker.ImageExists(state.MainImageID) {
+		return state.MainImageID, nil
+	}
+
+	baseImage := rebuildBaseImage(state)
+	fmt.Printf("Image %s is no longer present; rebuilding it from %s\n", state.MainImage, baseImage)
+	if err := docker.BuildImage(baseImage, state.BuildOptions, showBuildOutput, true, debug); err != nil {
+		return "", err
+	}
+	image := docker.MainImageName(baseImage)
+	info, err := docker.InspectImage(image)
+	if err != nil {
+		return "", err
+	}
+	for _, difference := range drift(state, info) {
+		fmt.Printf("Warning: %s\n", difference)
+	}
+	return image, nil
+}
+
+// rebuildBaseImage returns the base image to rebuild the task's image from:
+// the exact digest it used if that was recorded, otherwise its name
+func rebuildBaseImage(state *taskstate.State) string {
+	// A locally built base image is recorded by its ID, which can't be pulled
+	if strings.Contains(state.BaseImageDigest, "@") {
+		return state.BaseImageDigest
+	}
+	return state.BaseImage
+}
+
+// drift describes how a rebuilt image differs from the one the task ran in.
+// Things that weren't recorded are not compared.
+func drift(state *taskstate.State, info *docker.ImageInfo) []string {
+	var differences []string
+	if state.DockerfileHash != "" && info.DockerfileHash != state.DockerfileHash {
+		differences = append(differences, "the image was built from different Dockerfiles than the task's (a different giverny version?)")
+	}
+	if state.ClaudeCodeVersion != "" && info.ClaudeCodeVersion != state.ClaudeCodeVersion {
+		differences = append(differences, fmt.Sprintf("the image has Claude Code %s, the task had %s", orUnknown(info.ClaudeCodeVersion), state.ClaudeCodeVersion))
+	}
+	if state.DiffreviewerVersion != "" && info.DiffreviewerVersion != state.DiffreviewerVersion {
+		differences = append(differences, fmt.Sprintf("the image has diffreviewer %s, the task had %s", orUnknown(info.DiffreviewerVersion), state.DiffreviewerVersion))
+	}
+	if state.BeadsVersion != "" && info.BeadsVersion != state.BeadsVersion {
+		differences = append(differences, fmt.Sprintf("the image has beads %s, the task had %s", orUnknown(info.BeadsVersion), state.BeadsVersion))
+	}
+	return differences
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+package reproduce
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"giverny/internal/docker"
+	"giverny/internal/taskstate"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestRebuildBaseImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    taskstate.State
+		expected string
+	}{
+		{"repository digest", taskstate.State{BaseImage: "ubuntu:24.04", BaseImageDigest: "ubuntu@sha256:abcd"}, "ubuntu@sha256:abcd"},
+		{"local image ID", taskstate.State{BaseImage: "giverny:latest", BaseImageDigest: "sha256:abcd"}, "giverny:latest"},
+		{"no digest", taskstate.State{BaseImage: "giverny:latest"}, "giverny:latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebuildBaseImage(&tt.state); got != tt.expected {
+				t.Errorf("rebuildBaseImage() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDrift(t *testing.T) {
+	state := &taskstate.State{DockerfileHash: "abc", ClaudeCodeVersion: "2.0.1 (Claude Code)"}
+
+	if differences := drift(state, &docker.ImageInfo{DockerfileHash: "abc", ClaudeCodeVersion: "2.0.1 (Claude Code)"}); len(differences) != 0 {
+		t.Errorf("expected no differences, got %v", differences)
+	}
+
+	differences := drift(state, &docker.ImageInfo{DockerfileHash: "def"})
+	if len(differences) != 2 {
+		t.Fatalf("expected 2 differences, got %v", differences)
+	}
+	if !strings.Contains(differences[1], "Claude Code unknown, the task had 2.0.1 (Claude Code)") {
+		t.Errorf("unexpected Claude Code difference: %q", differences[1])
+	}
+
+	state = &taskstate.State{DiffreviewerVersion: "v0.2.3", BeadsVersion: "v0.1.14"}
+	differences = drift(state, &docker.ImageInfo{DiffreviewerVersion: "v0.2.3", BeadsVersion: "v0.2.0"})
+	if len(differences) != 1 || differences[0] != "the image has beads v0.2.0, the task had v0.1.14" {
+		t.Errorf("expected a beads difference, got %v", differences)
+	}
+
+	// Nothing recorded, nothing to compare
+	if differences := drift(&taskstate.State{}, &docker.ImageInfo{DockerfileHash: "def"}); len(differences) != 0 {
+		t.Errorf("expected no differences, got %v", differences)
+	}
+}
@@ -0,0 +1,145 @@
+// Package secrets looks for credentials in the commits the agent made.
+package secrets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Finding is a possible secret added by a commit.
+type Finding struct {
+	Path string
+	Line int
+	Rule string
+}
+
+// String formats the finding for display, e.g. "config.yml:12: aws-access-key".
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s", f.Path, f.Line, f.Rule)
+}
+
+// rule is a regular expression matching one kind of credential.
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// rules are the built-in patterns used when gitleaks is not installed. They
+// favour well-known token formats over generic "password = ..." matches to
+// keep false positives down.
+var rules = []rule{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"github-pat", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`)},
+	{"anthropic-api-key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{"openai-api-key", regexp.MustCompile(`\bsk-(proj-)?[A-Za-z0-9]{32,}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"stripe-secret-key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`)},
+}
+
+// Scan checks every commit in fromRef..HEAD in the current git repository for
+// secrets. Each commit is scanned, not just the final diff, since a secret
+// that was committed and later removed is still in the pushed history.
+// gitleaks is used if it is installed; otherwise the built-in rules are.
+func Scan(fromRef string) ([]Finding, error) {
+	if _, err := exec.LookPath("gitleaks"); err == nil {
+		return scanWithGitleaks(fromRef)
+	}
+
+	cmd := exec.Command("git", "log", "-p", "--no-color", "--no-ext-diff", "-U0", "--format=", fromRef+"..HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commits since %s: %w", fromRef, err)
+	}
+	return ScanPatch(string(output)), nil
+}
+
+// ScanPatch checks the lines added in a unified diff against the built-in rules.
+func ScanPatch(patch string) []Finding {
+	var findings []Finding
+	var path string
+	line := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@ "):
+			line = hunkStartLine(text)
+		case strings.HasPrefix(text, "+"):
+			for _, r := range rules {
+				if r.pattern.MatchString(text[1:]) {
+					findings = append(findings, Finding{Path: path, Line: line, Rule: r.name})
+					break
+				}
+			}
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+// hunkStartLine returns the first line number in the new file of a hunk
+// header such as "@@ -10,2 +12,3 @@".
+func hunkStartLine(header string) int {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return 0
+	}
+	start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// scanWithGitleaks runs gitleaks over the commits in fromRef..HEAD.
+func scanWithGitleaks(fromRef string) ([]Finding, error) {
+	report, err := os.CreateTemp("", "giverny-gitleaks-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gitleaks report: %w", err)
+	}
+	report.Close()
+	defer os.Remove(report.Name())
+
+	cmd := exec.Command("gitleaks", "detect", "--source", ".", "--log-opts", fromRef+"..HEAD",
+		"--no-banner", "--redact", "--exit-code", "0", "--report-format", "json", "--report-path", report.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("gitleaks failed: %s", strings.TrimSpace(string(output)))
+	}
+
+	data, err := os.ReadFile(report.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gitleaks report: %w", err)
+	}
+	return parseGitleaksReport(data)
+}
+
+// parseGitleaksReport converts a gitleaks JSON report into findings.
+func parseGitleaksReport(data []byte) ([]Finding, error) {
+	var results []struct {
+		RuleID    string
+		File      string
+		StartLine int
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse gitleaks report: %w", err)
+	}
+
+	var findings []Finding
+	for _, r := range results {
+		findings = append(findings, Finding{Path: r.File, Line: r.StartLine, Rule: r.RuleID})
+	}
+	return findings, nil
+}
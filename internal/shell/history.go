@@ -0,0 +1,28 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// CacheDir is where the task's cache volume is mounted in its container. The
+// volume outlives the container, so what is kept there lasts across runs of
+// the task.
+const CacheDir = "/var/cache/giverny"
+
+// historySize is how many commands shells keep in their history
+const historySize = "10000"
+
+// HistoryEnv returns the environment that keeps the history of shellPath in
+// dir, so it lasts across shell sessions, or nothing if dir doesn't exist
+func HistoryEnv(shellPath, dir string) []string {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil
+	}
+	return []string{
+		"HISTFILE=" + filepath.Join(dir, filepath.Base(shellPath)+"_history"),
+		"HISTSIZE=" + historySize,
+		// zsh doesn't save any history without SAVEHIST
+		"SAVEHIST=" + historySize,
+	}
+}
@@ -0,0 +1,30 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ProfileDir is where images built without the user's dotfiles have
+// giverny's shell profile
+const ProfileDir = "/etc/giverny"
+
+// ProfileArgs returns the arguments and extra environment that start
+// shellPath with the giverny shell profile in profileDir, or nothing if
+// there is none there, leaving the shell to read the user's dotfiles.
+func ProfileArgs(shellPath, profileDir string) (args []string, env []string) {
+	rcFile := filepath.Join(profileDir, "shellrc")
+	if _, err := os.Stat(rcFile); err != nil {
+		return nil, nil
+	}
+	switch filepath.Base(shellPath) {
+	case "bash":
+		return []string{"--rcfile", rcFile}, nil
+	case "zsh":
+		// zsh reads $ZDOTDIR/.zshrc, which is linked to the profile
+		return nil, []string{"ZDOTDIR=" + profileDir}
+	default:
+		// Interactive POSIX shells read $ENV
+		return nil, []string{"ENV=" + rcFile}
+	}
+}
@@ -0,0 +1,46 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfileArgs(t *testing.T) {
+	dir := t.TempDir()
+	if args, env := ProfileArgs("/bin/bash", dir); args != nil || env != nil {
+		t.Errorf("expected nothing without a profile, got %v %v", args, env)
+	}
+
+	rcFile := filepath.Join(dir, "shellrc")
+	if err := os.WriteFile(rcFile, []byte("PS1='$ '\n"), 0644); err != nil {
+		t.Fatalf("failed to write shellrc: %v", err)
+	}
+	tests := []struct {
+		shell string
+		args  []string
+		env   []string
+	}{
+		{"/bin/bash", []string{"--rcfile", rcFile}, nil},
+		{"/bin/zsh", nil, []string{"ZDOTDIR=" + dir}},
+		{"/bin/sh", nil, []string{"ENV=" + rcFile}},
+	}
+	for _, tt := range tests {
+		args, env := ProfileArgs(tt.shell, dir)
+		if !reflect.DeepEqual(args, tt.args) || !reflect.DeepEqual(env, tt.env) {
+			t.Errorf("ProfileArgs(%q) = %v, %v, want %v, %v", tt.shell, args, env, tt.args, tt.env)
+		}
+	}
+}
+
+func TestHistoryEnv(t *testing.T) {
+	dir := t.TempDir()
+	env := HistoryEnv("/bin/zsh", dir)
+	if len(env) == 0 || env[0] != "HISTFILE="+filepath.Join(dir, "zsh_history") {
+		t.Errorf("HistoryEnv() = %v, want HISTFILE in %s", env, dir)
+	}
+	if env := HistoryEnv("/bin/bash", filepath.Join(dir, "missing")); env != nil {
+		t.Errorf("expected nothing without the cache directory, got %v", env)
+	}
+}
@@ -0,0 +1,147 @@
+// Package status records what innie is doing, so it can be checked from the
+// host while a task runs.
+package status
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is where innie writes its status inside the container
+const Path = "/var/log/giverny/status"
+
+// HeartbeatInterval is how often innie rewrites its status while it runs. A
+// status whose heartbeat is a few intervals old means innie has stopped.
+const HeartbeatInterval = 15 * time.Second
+
+// Phase is the stage of the task innie is in
+type Phase string
+
+const (
+	PhaseSetup   Phase = "setup"   // cloning and setting up the workspace
+	PhasePlan    Phase = "plan"    // the agent is planning the task, or the user reviewing its plan
+	PhaseAgent   Phase = "agent"   // the agent is running
+	PhaseReview  Phase = "review"  // a second agent is reviewing the changes
+	PhaseMenu    Phase = "menu"    // waiting for the user at the menu
+	PhaseChecks  Phase = "checks"  // running the pre-push checks
+	PhasePushing Phase = "pushing" // pushing the task branch to the host
+	PhaseDone    Phase = "done"    // innie has finished
+)
+
+// Status is innie's phase, when it entered it, and when innie last reported in
+type Status struct {
+	Phase     Phase     `json:"phase"`
+	Since     time.Time `json:"since"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// Format returns the status as written to the status file: the phase and the
+// two times in RFC 3339, tab-separated.
+func (s Status) Format() string {
+	return fmt.Sprintf("%s\t%s\t%s\n", s.Phase, s.Since.UTC().Format(time.RFC3339), s.Heartbeat.UTC().Format(time.RFC3339))
+}
+
+// Stale reports whether the heartbeat is too old for innie to still be running
+func (s Status) Stale(now time.Time) bool {
+	return s.Phase != PhaseDone && now.Sub(s.Heartbeat) > 3*HeartbeatInterval
+}
+
+// Parse reads a status in the format written by Format
+func Parse(data string) (Status, error) {
+	fields := strings.Split(strings.TrimSpace(data), "\t")
+	if len(fields) != 3 {
+		return Status{}, fmt.Errorf("invalid status %q", strings.TrimSpace(data))
+	}
+	since, err := time.Parse(time.RFC3339, fields[1])
+	if err != nil {
+		return Status{}, fmt.Errorf("invalid status time: %w", err)
+	}
+	heartbeat, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return Status{}, fmt.Errorf("invalid heartbeat time: %w", err)
+	}
+	return Status{Phase: Phase(fields[0]), Since: since, Heartbeat: heartbeat}, nil
+}
+
+// Reporter keeps the status file up to date. Failures to write it are
+// ignored: the status is only informational.
+type Reporter struct {
+	path   string
+	mu     sync.Mutex
+	status Status
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Start writes a setup status to path and rewrites it every interval until
+// Stop is called.
+func Start(path string, interval time.Duration) *Reporter {
+	now := time.Now()
+	r := &Reporter{
+		path:   path,
+		status: Status{Phase: PhaseSetup, Since: now, Heartbeat: now},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	r.write()
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				r.status.Heartbeat = time.Now()
+				r.mu.Unlock()
+				r.write()
+			}
+		}
+	}()
+	return r
+}
+
+// SetPhase records that innie has moved on to phase
+func (r *Reporter) SetPhase(phase Phase) {
+	r.mu.Lock()
+	now := time.Now()
+	r.status = Status{Phase: phase, Since: now, Heartbeat: now}
+	r.mu.Unlock()
+	r.write()
+}
+
+// Phase returns the phase innie is in
+func (r *Reporter) Phase() Phase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Phase
+}
+
+// Stop stops the heartbeat and records that innie has finished
+func (r *Reporter) Stop() {
+	close(r.stop)
+	<-r.done
+	r.SetPhase(PhaseDone)
+}
+
+// write replaces the status file, via a rename so readers never see half of
+// it. The lock is held throughout so that writes don't interleave.
+func (r *Reporter) write() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := r.status.Format()
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, r.path)
+}
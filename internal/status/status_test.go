@@ -0,0 +1,96 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestFormatAndParse(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	heartbeat := since.Add(time.Minute)
+	s := Status{Phase: PhaseAgent, Since: since, Heartbeat: heartbeat}
+
+	if got := s.Format(); got != "agent\t2026-01-02T03:04:05Z\t2026-01-02T03:05:05Z\n" {
+		t.Errorf("unexpected format %q", got)
+	}
+
+	parsed, err := Parse(s.Format())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if parsed.Phase != PhaseAgent || !parsed.Since.Equal(since) || !parsed.Heartbeat.Equal(heartbeat) {
+		t.Errorf("expected %+v, got %+v", s, parsed)
+	}
+
+	for _, bad := range []string{"", "agent", "agent\tnot-a-time\t2026-01-02T03:05:05Z"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	fresh := Status{Phase: PhaseAgent, Heartbeat: now.Add(-HeartbeatInterval)}
+	if fresh.Stale(now) {
+		t.Error("expected a recent heartbeat not to be stale")
+	}
+	old := Status{Phase: PhaseAgent, Heartbeat: now.Add(-time.Hour)}
+	if !old.Stale(now) {
+		t.Error("expected an old heartbeat to be stale")
+	}
+	done := Status{Phase: PhaseDone, Heartbeat: now.Add(-time.Hour)}
+	if done.Stale(now) {
+		t.Error("expected a finished status never to be stale")
+	}
+}
+
+func TestReporter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "giverny-status-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "giverny", "status")
+
+	read := func() Status {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read status: %v", err)
+		}
+		s, err := Parse(string(data))
+		if err != nil {
+			t.Fatalf("failed to parse status: %v", err)
+		}
+		return s
+	}
+
+	r := Start(path, time.Millisecond)
+	if got := read().Phase; got != PhaseSetup {
+		t.Errorf("expected setup phase, got %s", got)
+	}
+
+	r.SetPhase(PhaseMenu)
+	if got := read().Phase; got != PhaseMenu {
+		t.Errorf("expected menu phase, got %s", got)
+	}
+
+	r.Stop()
+	if got := read().Phase; got != PhaseDone {
+		t.Errorf("expected done phase, got %s", got)
+	}
+}
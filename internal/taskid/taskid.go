@@ -0,0 +1,138 @@
+// Package taskid validates the TASK-IDs and slugs that tasks are named by,
+// which end up in git branch names, and builds those branch names.
+package taskid
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// UserBranchEnv makes --user-branch the default when set to 1, for
+// repositories that several people run giverny against
+const UserBranchEnv = "GIVERNY_USER_BRANCH"
+
+// invalidCharsPattern matches characters git doesn't allow in a branch name:
+// forward slash (which would add a level to giverny/TASK-ID), backslash,
+// whitespace, control characters, ~, ^, :, ?, * and [
+var invalidCharsPattern = regexp.MustCompile(`[/\\\s\x00-\x1f\x7f~^:?*\[]`)
+
+// Validate checks that taskID can be used as the last part of the task's
+// branch name giverny/TASK-ID, following git's rules for branch names.
+func Validate(taskID string) error {
+	if taskID == "" {
+		return fmt.Errorf("TASK-ID cannot be empty")
+	}
+
+	if match := invalidCharsPattern.FindString(taskID); match != "" {
+		if match == "/" {
+			return fmt.Errorf("TASK-ID cannot contain forward slash (/)")
+		} else if match == "\\" {
+			return fmt.Errorf("TASK-ID cannot contain backslash (\\)")
+		} else if match == " " {
+			return fmt.Errorf("TASK-ID cannot contain spaces")
+		} else if match[0] < 32 || match[0] == 127 {
+			return fmt.Errorf("TASK-ID cannot contain control characters")
+		}
+		return fmt.Errorf("TASK-ID cannot contain '%s'", match)
+	}
+
+	// Check for special invalid patterns
+	if strings.Contains(taskID, "..") {
+		return fmt.Errorf("TASK-ID cannot contain double dots (..)")
+	}
+	if strings.Contains(taskID, "@{") {
+		return fmt.Errorf("TASK-ID cannot contain @{")
+	}
+	if strings.HasPrefix(taskID, ".") {
+		return fmt.Errorf("TASK-ID cannot start with a dot")
+	}
+	if strings.HasSuffix(taskID, ".") {
+		return fmt.Errorf("TASK-ID cannot end with a dot")
+	}
+	if strings.HasSuffix(taskID, ".lock") {
+		return fmt.Errorf("TASK-ID cannot end with .lock")
+	}
+
+	return nil
+}
+
+// Slugify turns s into a valid TASK-ID instead of rejecting it, for
+// --slugify: characters git doesn't allow become hyphens, runs of hyphens
+// and dots are collapsed, and dots, hyphens and .lock are trimmed from the
+// ends. It fails only if nothing is left.
+func Slugify(s string) (string, error) {
+	result := invalidCharsPattern.ReplaceAllString(s, "-")
+	result = strings.ReplaceAll(result, "@{", "-")
+	result = regexp.MustCompile(`\.{2,}`).ReplaceAllString(result, ".")
+	result = regexp.MustCompile(`-+`).ReplaceAllString(result, "-")
+
+	// Trimming one end can expose another .lock or dot
+	for {
+		trimmed := strings.Trim(result, "-.")
+		trimmed = strings.TrimSuffix(trimmed, ".lock")
+		if trimmed == result {
+			break
+		}
+		result = trimmed
+	}
+
+	if result == "" {
+		return "", fmt.Errorf("'%s' has nothing that can be used in a TASK-ID", s)
+	}
+	return result, nil
+}
+
+// SanitizeSlug replaces any characters that are not safe for git branch names
+// or docker container names with hyphens. Also collapses multiple consecutive
+// hyphens into a single hyphen and trims leading/trailing hyphens.
+func SanitizeSlug(slug string) string {
+	// Replace any character that's not alphanumeric, hyphen, or underscore with a hyphen
+	invalidCharsPattern := regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	result := invalidCharsPattern.ReplaceAllString(slug, "-")
+
+	// Collapse multiple consecutive hyphens into one
+	multipleHyphens := regexp.MustCompile(`-+`)
+	result = multipleHyphens.ReplaceAllString(result, "-")
+
+	// Trim leading and trailing hyphens
+	result = strings.Trim(result, "-")
+
+	return result
+}
+
+// BranchName returns the name of the branch of the task taskID with slug,
+// giverny/TASK-ID or giverny/TASK-ID-SLUG, or giverny/USER/TASK-ID... if user
+// is given so that people sharing a repository don't use the same branches
+func BranchName(user, taskID, slug string) string {
+	prefix := "giverny/"
+	if user != "" {
+		prefix += user + "/"
+	}
+	if slug != "" {
+		return fmt.Sprintf("%s%s-%s", prefix, taskID, slug)
+	}
+	return prefix + taskID
+}
+
+// User returns the name to put in branch names with --user-branch: the part
+// of git's user.email before the @, or else $USER
+func User() (string, error) {
+	email, _ := exec.Command("git", "config", "user.email").Output()
+	if user := userName(strings.TrimSpace(string(email)), os.Getenv("USER")); user != "" {
+		return user, nil
+	}
+	return "", fmt.Errorf("--user-branch needs git's user.email or $USER to be set")
+}
+
+// userName makes a name for branches from email, or login if email has none,
+// keeping only what SanitizeSlug does and in lower case
+func userName(email, login string) string {
+	name, _, _ := strings.Cut(email, "@")
+	if user := strings.ToLower(SanitizeSlug(name)); user != "" {
+		return user
+	}
+	return strings.ToLower(SanitizeSlug(login))
+}
@@ -0,0 +1,269 @@
+package taskid
+
+import (
+	"math/rand"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		taskID  string
+		wantErr bool
+		errMsg  string
+	}{
+		// Valid task IDs
+		{name: "valid simple", taskID: "abc", wantErr: false},
+		{name: "valid with numbers", taskID: "task-123", wantErr: false},
+		{name: "valid with underscores", taskID: "my_task", wantErr: false},
+		{name: "valid with dots", taskID: "task.1.2", wantErr: false},
+		{name: "valid mixed", taskID: "giv-4z1", wantErr: false},
+
+		// Invalid - empty
+		{name: "empty", taskID: "", wantErr: true, errMsg: "cannot be empty"},
+
+		// Invalid - forward slash
+		{name: "contains slash", taskID: "task/123", wantErr: true, errMsg: "forward slash"},
+
+		// Invalid - starts with dot
+		{name: "starts with dot", taskID: ".task", wantErr: true, errMsg: "start with a dot"},
+
+		// Invalid - ends with dot
+		{name: "ends with dot", taskID: "task.", wantErr: true, errMsg: "end with a dot"},
+
+		// Invalid - ends with .lock
+		{name: "ends with .lock", taskID: "task.lock", wantErr: true, errMsg: "end with .lock"},
+
+		// Invalid - double dots
+		{name: "contains double dots", taskID: "task..123", wantErr: true, errMsg: "double dots"},
+
+		// Invalid - @{
+		{name: "contains @{", taskID: "task@{123", wantErr: true, errMsg: "@{"},
+
+		// Invalid - special characters
+		{name: "contains backslash", taskID: "task\\123", wantErr: true, errMsg: "backslash"},
+		{name: "contains space", taskID: "task 123", wantErr: true, errMsg: "space"},
+		{name: "contains tilde", taskID: "task~123", wantErr: true, errMsg: "~"},
+		{name: "contains caret", taskID: "task^123", wantErr: true, errMsg: "^"},
+		{name: "contains colon", taskID: "task:123", wantErr: true, errMsg: ":"},
+		{name: "contains question mark", taskID: "task?123", wantErr: true, errMsg: "?"},
+		{name: "contains asterisk", taskID: "task*123", wantErr: true, errMsg: "*"},
+		{name: "contains square bracket", taskID: "task[123", wantErr: true, errMsg: "["},
+
+		// Invalid - control characters
+		{name: "contains newline", taskID: "task\n123", wantErr: true, errMsg: "control"},
+		{name: "contains tab", taskID: "task\t123", wantErr: true, errMsg: "control"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.taskID)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Validate(%q) expected error containing %q, got nil", tt.taskID, tt.errMsg)
+				} else if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("Validate(%q) expected error containing %q, got %q", tt.taskID, tt.errMsg, err.Error())
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Validate(%q) expected no error, got %v", tt.taskID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeSlug(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		// Simple cases
+		{name: "simple word", input: "hello", expected: "hello"},
+		{name: "with hyphen", input: "hello-world", expected: "hello-world"},
+		{name: "with underscore", input: "hello_world", expected: "hello_world"},
+		{name: "with numbers", input: "task123", expected: "task123"},
+
+		// Spaces
+		{name: "spaces to hyphens", input: "hello world", expected: "hello-world"},
+		{name: "multiple spaces", input: "hello   world", expected: "hello-world"},
+
+		// Special characters
+		{name: "special chars", input: "add@feature!", expected: "add-feature"},
+		{name: "mixed special", input: "fix: bug #123", expected: "fix-bug-123"},
+		{name: "slashes", input: "path/to/file", expected: "path-to-file"},
+
+		// Leading/trailing
+		{name: "leading space", input: " hello", expected: "hello"},
+		{name: "trailing space", input: "hello ", expected: "hello"},
+		{name: "leading special", input: "!hello", expected: "hello"},
+		{name: "trailing special", input: "hello!", expected: "hello"},
+
+		// Multiple consecutive hyphens
+		{name: "collapse hyphens", input: "hello---world", expected: "hello-world"},
+		{name: "special chars create hyphens", input: "hello!!!world", expected: "hello-world"},
+
+		// Unicode
+		{name: "unicode chars", input: "héllo wörld", expected: "h-llo-w-rld"},
+
+		// Empty result
+		{name: "all special chars", input: "!@#$%", expected: ""},
+		{name: "only spaces", input: "   ", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := SanitizeSlug(tt.input)
+			if result != tt.expected {
+				t.Errorf("SanitizeSlug(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc-123", "abc-123"},
+		{"fix login bug", "fix-login-bug"},
+		{"feature/login", "feature-login"},
+		{"  what?  ", "what"},
+		{"a..b", "a.b"},
+		{"a@{b", "a-b"},
+		{".hidden", "hidden"},
+		{"task.", "task"},
+		{"task.lock", "task"},
+		{"task.lock.", "task"},
+		{"task.lock.lock", "task"},
+		{".lock", "lock"},
+		{"héllo wörld", "héllo-wörld"},
+	}
+	for _, tt := range tests {
+		got, err := Slugify(tt.input)
+		if err != nil {
+			t.Errorf("Slugify(%q) failed: %v", tt.input, err)
+		} else if got != tt.expected {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"", "   ", "..", "~^:"} {
+		if got, err := Slugify(input); err == nil {
+			t.Errorf("Slugify(%q) = %q, want an error", input, got)
+		}
+	}
+}
+
+// refAlphabet is weighted towards the characters git's rules for ref names
+// are about
+var refAlphabet = []rune("ab1-_.@{}/\\ ~^:?*[]\t\x7f.lock.é")
+
+func randomTaskID(r *rand.Rand) string {
+	n := r.Intn(12)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteRune(refAlphabet[r.Intn(len(refAlphabet))])
+	}
+	return b.String()
+}
+
+// gitAcceptsBranch reports whether git check-ref-format accepts branch
+func gitAcceptsBranch(branch string) bool {
+	return exec.Command("git", "check-ref-format", "refs/heads/"+branch).Run() == nil
+}
+
+// TestValidate_AgreesWithGit checks Validate and Slugify against git's own
+// rules on random TASK-IDs
+func TestValidate_AgreesWithGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	config := &quick.Config{
+		MaxCount: 300,
+		Rand:     rand.New(rand.NewSource(1)),
+		Values: func(values []reflect.Value, r *rand.Rand) {
+			values[0] = reflect.ValueOf(randomTaskID(r))
+		},
+	}
+
+	// Valid TASK-IDs make valid branches, and everything else git accepts is
+	// only rejected for adding a level to the branch name
+	agrees := func(taskID string) bool {
+		valid := Validate(taskID) == nil
+		accepted := gitAcceptsBranch(BranchName("", taskID, ""))
+		if valid {
+			return accepted && gitAcceptsBranch(BranchName("hugh", taskID, "slug"))
+		}
+		return !accepted || strings.Contains(taskID, "/")
+	}
+	if err := quick.Check(agrees, config); err != nil {
+		t.Error(err)
+	}
+
+	// Whatever Slugify returns is valid to both
+	slugified := func(input string) bool {
+		taskID, err := Slugify(input)
+		if err != nil {
+			return true
+		}
+		return Validate(taskID) == nil && gitAcceptsBranch(BranchName("", taskID, ""))
+	}
+	if err := quick.Check(slugified, config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBranchName(t *testing.T) {
+	tests := []struct {
+		user, taskID, slug string
+		expected           string
+	}{
+		{"", "abc", "", "giverny/abc"},
+		{"", "abc", "fix-bug", "giverny/abc-fix-bug"},
+		{"hugh", "abc", "", "giverny/hugh/abc"},
+		{"hugh", "abc", "fix-bug", "giverny/hugh/abc-fix-bug"},
+	}
+	for _, tt := range tests {
+		if got := BranchName(tt.user, tt.taskID, tt.slug); got != tt.expected {
+			t.Errorf("BranchName(%q, %q, %q) = %q, want %q", tt.user, tt.taskID, tt.slug, got, tt.expected)
+		}
+	}
+}
+
+func TestUserName(t *testing.T) {
+	tests := []struct {
+		email, login string
+		expected     string
+	}{
+		{"hugh@example.com", "hughe", "hugh"},
+		{"Hugh.Emberson@example.com", "", "hugh-emberson"},
+		{"", "hughe", "hughe"},
+		{"@example.com", "Hugh E", "hugh-e"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := userName(tt.email, tt.login); got != tt.expected {
+			t.Errorf("userName(%q, %q) = %q, want %q", tt.email, tt.login, got, tt.expected)
+		}
+	}
+}
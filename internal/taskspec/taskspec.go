@@ -0,0 +1,161 @@
+// Package taskspec is how outie tells innie which task to run and how: one
+// JSON document in an environment variable of the container, instead of
+// command line flags, so nothing is lost to argument quoting and fields can
+// be added without breaking containers started by another giverny version.
+package taskspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// EnvVar is the environment variable of the container that holds the spec
+const EnvVar = "GIVERNY_TASK"
+
+// Version is the version of the spec this giverny writes. Fields are added
+// without changing it, as a reader ignores fields it doesn't know and leaves
+// missing ones at their zero value; it is only raised for changes older
+// readers would get wrong.
+const Version = 1
+
+// MaxEncodedSize is the most bytes of JSON outie puts in the container's
+// environment. The kernel refuses to start a process with a single argument
+// or environment variable over 128 KiB, so a spec larger than this leaves its
+// prompt out and has it read from PromptFile instead.
+const MaxEncodedSize = 64 << 10
+
+// PromptFile is where the task's prompt is in the container: mounted there
+// when it is left out of the spec, and otherwise written there by innie
+const PromptFile = "/etc/giverny-prompt"
+
+// Spec is the task innie runs in the container
+type Spec struct {
+	Version    int    `json:"version"`
+	TaskID     string `json:"task_id"`
+	Slug       string `json:"slug,omitempty"`
+	BranchUser string `json:"branch_user,omitempty"`
+	Prompt     string `json:"prompt"`
+	// PromptPath is set, and Prompt empty, when the prompt is read from the
+	// file there instead
+	PromptPath string `json:"prompt_path,omitempty"`
+
+	// GitServerPort is the port of the git server the container clones from
+	GitServerPort int `json:"git_server_port"`
+
+	AgentArgs string `json:"agent_args,omitempty"`
+	UseAmp    bool   `json:"use_amp,omitempty"`
+	Debug     bool   `json:"debug,omitempty"`
+
+	// BaseBranch is the branch the task branch is created from in the
+	// container, and TargetBranch the one its changes will be merged into
+	BaseBranch   string `json:"base_branch,omitempty"`
+	TargetBranch string `json:"target_branch,omitempty"`
+
+	CloneDepth   int      `json:"clone_depth,omitempty"`
+	CloneFilter  string   `json:"clone_filter,omitempty"`
+	AutoCRLF     string   `json:"autocrlf,omitempty"`
+	FileMode     string   `json:"filemode,omitempty"`
+	SparsePaths  []string `json:"sparse_paths,omitempty"`
+	PushRefspecs []string `json:"push_refspecs,omitempty"`
+
+	DenyPaths     []string `json:"deny_paths,omitempty"`
+	StrictPolicy  bool     `json:"strict_policy,omitempty"`
+	MaxFiles      int      `json:"max_files,omitempty"`
+	MaxLines      int      `json:"max_lines,omitempty"`
+	MaxBinaryKB   int      `json:"max_binary_kb,omitempty"`
+	ComplianceCmd string   `json:"compliance_cmd,omitempty"`
+	RequireSPDX   bool     `json:"require_spdx,omitempty"`
+	Summary       bool     `json:"summary,omitempty"`
+	NoSecretScan  bool     `json:"no_secret_scan,omitempty"`
+	PlanFirst     bool     `json:"plan_first,omitempty"`
+	Split         bool     `json:"split,omitempty"`
+	TDD           bool     `json:"tdd,omitempty"`
+	TestCmd       string   `json:"test_cmd,omitempty"`
+	CoverageCmd   string   `json:"coverage_cmd,omitempty"`
+	CoverageDrop  float64  `json:"max_coverage_drop,omitempty"`
+	Checks        []string `json:"checks,omitempty"`
+	ReviewRounds  int      `json:"review_rounds,omitempty"`
+	ReviewerArgs  string   `json:"reviewer_args,omitempty"`
+	Audit         bool     `json:"audit,omitempty"`
+	ScanPaths     []string `json:"scan_paths,omitempty"`
+
+	SaveWIP bool `json:"save_wip,omitempty"`
+	// CheckpointInterval is a duration such as "10m", empty for none
+	CheckpointInterval string `json:"checkpoint_interval,omitempty"`
+	LiveDiff           bool   `json:"live_diff,omitempty"`
+	NonInteractive     bool   `json:"non_interactive,omitempty"`
+
+	Hooks          string   `json:"hooks,omitempty"`
+	Nix            bool     `json:"nix,omitempty"`
+	Mise           bool     `json:"mise,omitempty"`
+	EnableDocker   string   `json:"enable_docker,omitempty"`
+	GitCredentials []string `json:"git_credentials,omitempty"`
+
+	Provenance      bool   `json:"provenance,omitempty"`
+	BaseImageDigest string `json:"base_image_digest,omitempty"`
+
+	// HostUser is the "UID:GID" files root creates in the mounts from the
+	// host are given to, empty if the Docker runtime maps them itself
+	HostUser string `json:"host_user,omitempty"`
+}
+
+// Encode returns spec as JSON, stamped with this giverny's Version
+func Encode(spec Spec) (string, error) {
+	spec.Version = Version
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode task spec: %w", err)
+	}
+	return string(data), nil
+}
+
+// Decode parses a spec Encode returned
+func Decode(data string) (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse task spec: %w", err)
+	}
+	if spec.Version > Version {
+		return nil, fmt.Errorf("task spec version %d is newer than this giverny understands (%d); rebuild the container's image", spec.Version, Version)
+	}
+	if spec.TaskID == "" {
+		return nil, fmt.Errorf("task spec has no task ID")
+	}
+	return &spec, nil
+}
+
+// FromEnv returns the spec outie gave the container in EnvVar, with its
+// prompt read from PromptPath if it was too long for the environment
+func FromEnv() (*Spec, error) {
+	data := os.Getenv(EnvVar)
+	if data == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", EnvVar)
+	}
+	spec, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if spec.PromptPath != "" {
+		prompt, err := os.ReadFile(spec.PromptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the task's prompt: %w", err)
+		}
+		spec.Prompt = string(prompt)
+	}
+	return spec, nil
+}
+
+// CheckPrompt returns an error if prompt can't be passed to the agent intact:
+// a NUL byte ends an argument early, and JSON replaces invalid UTF-8
+func CheckPrompt(prompt string) error {
+	if i := strings.IndexByte(prompt, 0); i >= 0 {
+		return fmt.Errorf("prompt contains a NUL byte at offset %d", i)
+	}
+	if !utf8.ValidString(prompt) {
+		return fmt.Errorf("prompt is not valid UTF-8")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package taskspec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	spec := Spec{
+		TaskID:             "task-1",
+		Prompt:             "Fix the \"quoted\" bug\nin two lines; $(not run)",
+		GitServerPort:      4242,
+		BaseBranch:         "main",
+		SparsePaths:        []string{"cmd", "internal/git"},
+		CheckpointInterval: "10m",
+	}
+	data, err := Encode(spec)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if got.Version != Version {
+		t.Errorf("expected version %d, got %d", Version, got.Version)
+	}
+	if got.TaskID != spec.TaskID || got.Prompt != spec.Prompt || got.GitServerPort != spec.GitServerPort || got.BaseBranch != spec.BaseBranch || got.CheckpointInterval != spec.CheckpointInterval {
+		t.Errorf("expected %+v back, got %+v", spec, got)
+	}
+	if strings.Join(got.SparsePaths, " ") != "cmd internal/git" {
+		t.Errorf("expected the sparse paths back, got %v", got.SparsePaths)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// Fields from a newer giverny of the same version are ignored
+	spec, err := Decode(`{"version":1,"task_id":"task-1","git_server_port":4242,"new_option":true}`)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if spec.TaskID != "task-1" || spec.GitServerPort != 4242 {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	if _, err := Decode(`{"version":2,"task_id":"task-1"}`); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected an error for a newer version, got %v", err)
+	}
+	if _, err := Decode(`{"version":1}`); err == nil || !strings.Contains(err.Error(), "no task ID") {
+		t.Errorf("expected an error for a missing task ID, got %v", err)
+	}
+	if _, err := Decode(`{`); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(EnvVar, "")
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), EnvVar) {
+		t.Errorf("expected an error naming %s, got %v", EnvVar, err)
+	}
+
+	t.Setenv(EnvVar, `{"version":1,"task_id":"task-1"}`)
+	spec, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv failed: %v", err)
+	}
+	if spec.TaskID != "task-1" {
+		t.Errorf("expected task-1, got %q", spec.TaskID)
+	}
+}
+
+func TestFromEnv_PromptPath(t *testing.T) {
+	prompt := strings.Repeat("Fix the \"parser\" so that\n\t$(this) and `that` both work.\n", 2000)
+	path := filepath.Join(t.TempDir(), "prompt")
+	if err := os.WriteFile(path, []byte(prompt), 0644); err != nil {
+		t.Fatalf("failed to write prompt: %v", err)
+	}
+	data, err := Encode(Spec{TaskID: "task-1", PromptPath: path})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	t.Setenv(EnvVar, data)
+	spec, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv failed: %v", err)
+	}
+	if spec.Prompt != prompt {
+		t.Errorf("expected the prompt read from %s, got %d bytes", path, len(spec.Prompt))
+	}
+
+	data, _ = Encode(Spec{TaskID: "task-1", PromptPath: filepath.Join(t.TempDir(), "missing")})
+	t.Setenv(EnvVar, data)
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), "prompt") {
+		t.Errorf("expected an error for a missing prompt file, got %v", err)
+	}
+}
+
+func TestCheckPrompt(t *testing.T) {
+	if err := CheckPrompt("Fix it\n\nin two paragraphs, with ünïcödé and 'quotes'"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := CheckPrompt("Fix\x00it"); err == nil || !strings.Contains(err.Error(), "NUL") {
+		t.Errorf("expected an error for a NUL byte, got %v", err)
+	}
+	if err := CheckPrompt("Fix \xff it"); err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Errorf("expected an error for invalid UTF-8, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package taskstate records how each task's environment was set up, so that
+// it can be recreated later.
+package taskstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"giverny/internal/docker"
+)
+
+// State is what was known about a task's environment when it started
+type State struct {
+	TaskID    string    `json:"task_id"`
+	Slug      string    `json:"slug,omitempty"`
+	Branch    string    `json:"branch"`
+	StartedAt time.Time `json:"started_at"`
+
+	// StartCommit is the commit the task branch started from, which innie
+	// labels START in the container
+	StartCommit string `json:"start_commit,omitempty"`
+
+	// GitPort is the port of the git server on the host the task's container
+	// reaches, kept up to date when the container is reused with another one
+	GitPort int `json:"git_port,omitempty"`
+
+	GivernyVersion    string `json:"giverny_version,omitempty"`
+	BaseImage         string `json:"base_image"`
+	BaseImageDigest   string `json:"base_image_digest,omitempty"`
+	MainImage         string `json:"main_image"`
+	MainImageID       string `json:"main_image_id,omitempty"`
+	DockerfileHash    string `json:"dockerfile_hash,omitempty"`
+	ClaudeCodeVersion string `json:"claude_code_version,omitempty"`
+	// The versions of diffreviewer and beads built into the main image
+	DiffreviewerVersion string `json:"diffreviewer_version,omitempty"`
+	BeadsVersion        string `json:"beads_version,omitempty"`
+	// BuildOptions are the extras installed in the main image
+	BuildOptions docker.BuildOptions `json:"build_options"`
+}
+
+// Path returns where the state of the task run in containerName is kept, in
+// giverny's directory inside the repository's git directory
+func Path(gitDir, containerName string) string {
+	return filepath.Join(gitDir, "giverny", "tasks", containerName+".json")
+}
+
+// PromptPath returns where the prompt of the task run in containerName is
+// kept when it is too long to pass to the container in its environment
+func PromptPath(gitDir, containerName string) string {
+	return filepath.Join(gitDir, "giverny", "tasks", containerName+".prompt")
+}
+
+// Save writes the state of the task run in containerName, replacing any
+// earlier state
+func Save(gitDir, containerName string, state State) error {
+	path := Path(gitDir, containerName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create task state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode task state: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write task state: %w", err)
+	}
+	return nil
+}
+
+// Load reads the state of the task run in containerName
+func Load(gitDir, containerName string) (*State, error) {
+	data, err := os.ReadFile(Path(gitDir, containerName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no state recorded for container '%s'", containerName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task state: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse task state: %w", err)
+	}
+	return &state, nil
+}
+
+// List returns the names of the containers of all tasks with recorded state,
+// sorted
+func List(gitDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(Path(gitDir, "")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task state directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
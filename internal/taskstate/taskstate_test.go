@@ -0,0 +1,83 @@
+package taskstate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	gitDir, err := os.MkdirTemp("", "giverny-taskstate-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(gitDir)
+
+	if _, err := Load(gitDir, "giverny-task-1"); err == nil {
+		t.Error("expected an error loading state that was never saved")
+	}
+
+	state := State{
+		TaskID:          "task-1",
+		Branch:          "giverny/task-1",
+		StartedAt:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		StartCommit:     "0123456789abcdef",
+		BaseImage:       "ubuntu:24.04",
+		BaseImageDigest: "ubuntu@sha256:1111",
+		MainImage:       "giverny-main:latest",
+		MainImageID:     "sha256:2222",
+		DockerfileHash:  "3333",
+	}
+	if err := Save(gitDir, "giverny-task-1", state); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(gitDir, "giverny-task-1")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !reflect.DeepEqual(*loaded, state) {
+		t.Errorf("Load() = %+v, want %+v", *loaded, state)
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	gitDir := t.TempDir()
+	if names, err := List(gitDir); err != nil || names != nil {
+		t.Fatalf("List() = %v, %v, want no tasks", names, err)
+	}
+
+	for _, name := range []string{"giverny-task-2", "giverny-task-1-fix"} {
+		if err := Save(gitDir, name, State{TaskID: name}); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "giverny", "tasks", "notes.txt"), nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	names, err := List(gitDir)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if expected := []string{"giverny-task-1-fix", "giverny-task-2"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("List() = %v, want %v", names, expected)
+	}
+}
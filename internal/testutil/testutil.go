@@ -2,6 +2,7 @@ package testutil
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 
@@ -45,3 +46,11 @@ func InitTestRepo(t *testing.T, dir string, content ...string) {
 		t.Fatalf("failed to create initial commit: %v", err)
 	}
 }
+
+// Command returns a command that runs in dir, so tests can work on a
+// repository without changing the process's working directory.
+func Command(dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	return cmd
+}
@@ -0,0 +1,89 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"giverny/internal/docker"
+	"giverny/internal/taskstate"
+)
+
+// States of a task's container in a Task
+const (
+	StateRunning = "running"
+	StateStopped = "stopped"
+	StateRemoved = "removed"
+)
+
+// Task is a task with recorded state, as `giverny list` shows it. Its JSON
+// field names are what `giverny list --json` prints, and must stay stable.
+type Task struct {
+	TaskID    string    `json:"task_id"`
+	Slug      string    `json:"slug"`
+	Branch    string    `json:"branch"`
+	Container string    `json:"container"`
+	StartedAt time.Time `json:"started_at"`
+	State     string    `json:"state"` // StateRunning, StateStopped or StateRemoved
+}
+
+// List returns the tasks recorded in the repository's git directory gitDir,
+// most recently started first, with the state of their containers.
+func List(gitDir string) ([]Task, error) {
+	return listTasks(gitDir, docker.InspectContainer)
+}
+
+func listTasks(gitDir string, inspect func(string) (*docker.ContainerInfo, error)) ([]Task, error) {
+	names, err := taskstate.List(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []Task{}
+	for _, name := range names {
+		state, err := taskstate.Load(gitDir, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := inspect(name)
+		if err != nil {
+			return nil, err
+		}
+		task := Task{
+			TaskID:    state.TaskID,
+			Slug:      state.Slug,
+			Branch:    state.Branch,
+			Container: name,
+			StartedAt: state.StartedAt,
+			State:     StateRemoved,
+		}
+		if info != nil {
+			task.State = StateStopped
+			if info.Running {
+				task.State = StateRunning
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	// Tasks started at the same time stay in container name order
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].StartedAt.After(tasks[j].StartedAt)
+	})
+	return tasks, nil
+}
+
+// ListText formats tasks as a table for the terminal
+func ListText(tasks []Task) string {
+	if len(tasks) == 0 {
+		return "No tasks recorded in this repository\n"
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tBRANCH\tSTATE\tSTARTED")
+	for _, task := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", task.TaskID, task.Branch, task.State, task.StartedAt.Local().Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package watch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"giverny/internal/docker"
+	"giverny/internal/taskstate"
+)
+
+func TestListTasks(t *testing.T) {
+	t.Parallel()
+
+	gitDir := t.TempDir()
+	tasks, err := listTasks(gitDir, nil)
+	if err != nil {
+		t.Fatalf("listTasks failed: %v", err)
+	}
+	if data, _ := json.Marshal(tasks); string(data) != "[]" {
+		t.Errorf("expected an empty JSON list with no tasks, got %s", data)
+	}
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, state := range []taskstate.State{
+		{TaskID: "task-1", Branch: "giverny/task-1"},
+		{TaskID: "task-2", Slug: "fix", Branch: "giverny/task-2-fix"},
+		{TaskID: "task-3", Branch: "giverny/task-3"},
+	} {
+		state.StartedAt = started.Add(time.Duration(i) * time.Hour)
+		if err := taskstate.Save(gitDir, docker.ContainerName(state.TaskID, state.Slug), state); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	containers := map[string]*docker.ContainerInfo{
+		"giverny-task-1":     {Running: true},
+		"giverny-task-2-fix": {},
+	}
+	tasks, err = listTasks(gitDir, func(name string) (*docker.ContainerInfo, error) {
+		return containers[name], nil
+	})
+	if err != nil {
+		t.Fatalf("listTasks failed: %v", err)
+	}
+
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.TaskID+" "+task.State)
+	}
+	if want := "task-3 removed,task-2 stopped,task-1 running"; strings.Join(got, ",") != want {
+		t.Errorf("listTasks() = %v, want %s", got, want)
+	}
+
+	data, err := json.Marshal(tasks[1])
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// Scripts depend on these names
+	expected := `{"task_id":"task-2","slug":"fix","branch":"giverny/task-2-fix","container":"giverny-task-2-fix",` +
+		`"started_at":"2026-01-02T04:04:05Z","state":"stopped"}`
+	if string(data) != expected {
+		t.Errorf("unexpected JSON:\n%s\nwant:\n%s", data, expected)
+	}
+
+	text := ListText(tasks)
+	if !strings.HasPrefix(text, "TASK    BRANCH              STATE    STARTED\n") || !strings.Contains(text, "task-2  giverny/task-2-fix  stopped") {
+		t.Errorf("unexpected table:\n%s", text)
+	}
+}
@@ -0,0 +1,129 @@
+// Package watch reports on tasks, including ones running in another terminal.
+package watch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"giverny/internal/docker"
+	"giverny/internal/git"
+	"giverny/internal/status"
+)
+
+// Report is what watch knows about a task. Its JSON field names are what
+// `giverny status --json` prints, and must stay stable.
+type Report struct {
+	TaskID    string         `json:"task_id"`
+	Container string         `json:"container"`
+	Running   bool           `json:"running"`
+	Status    *status.Status `json:"status"` // innie's status; nil if it could not be read
+
+	// The latest checkpoint pushed to the host, and what it changed since the
+	// task branch left the base branch. Checkpoint is empty if there are none.
+	Checkpoint string         `json:"checkpoint"`
+	Stats      []git.FileStat `json:"files"`
+}
+
+// Load inspects the task's container and reads the latest checkpoint of
+// branchName from the repository in the current directory.
+func Load(taskID, containerName, branchName, baseBranch string) (*Report, error) {
+	info, err := docker.InspectContainer(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no container found for task %s (looked for '%s')", taskID, containerName)
+	}
+
+	r := &Report{TaskID: taskID, Container: containerName, Running: info.Running}
+	if info.Running {
+		if data, err := docker.ReadContainerFile(containerName, status.Path); err == nil {
+			if s, err := status.Parse(data); err == nil {
+				r.Status = &s
+			}
+		}
+	}
+
+	if r.Checkpoint, r.Stats, err = loadCheckpoint(".", branchName, baseBranch); err != nil {
+		return nil, err
+	}
+	if r.Stats == nil {
+		// Scripts reading the JSON get an empty list rather than null
+		r.Stats = []git.FileStat{}
+	}
+	return r, nil
+}
+
+// loadCheckpoint returns the short hash of the latest checkpoint of branchName
+// in the repository at dir and its changes since it diverged from baseBranch.
+func loadCheckpoint(dir, branchName, baseBranch string) (string, []git.FileStat, error) {
+	ref := git.CheckpointsRef(branchName)
+	checkpoint, err := git.GetCommitHashInDir(dir, ref)
+	if err != nil {
+		// No checkpoints have been pushed
+		return "", nil, nil
+	}
+	mergeBase, err := git.MergeBaseInDir(dir, baseBranch, checkpoint)
+	if err != nil {
+		return "", nil, err
+	}
+	stats, err := git.DiffStatsBetweenInDir(dir, mergeBase, checkpoint)
+	if err != nil {
+		return "", nil, err
+	}
+	return git.GetShortHashInDir(dir, checkpoint), stats, nil
+}
+
+// Text formats the report for the terminal
+func (r *Report) Text(now time.Time) string {
+	var b strings.Builder
+
+	state := "stopped"
+	if r.Running {
+		state = "running"
+	}
+	fmt.Fprintf(&b, "Task %s (container %s, %s)\n", r.TaskID, r.Container, state)
+
+	if r.Status != nil {
+		fmt.Fprintf(&b, "Phase: %s for %s, last heartbeat %s ago", r.Status.Phase,
+			now.Sub(r.Status.Since).Round(time.Second), now.Sub(r.Status.Heartbeat).Round(time.Second))
+		if r.Status.Stale(now) {
+			b.WriteString(" (innie may have stopped responding)")
+		}
+		b.WriteString("\n")
+	} else if r.Running {
+		b.WriteString("Phase: unknown\n")
+	}
+
+	if r.Checkpoint == "" {
+		b.WriteString("No checkpoints on the host yet (start the task with --live-diff to see its progress here)\n")
+		return b.String()
+	}
+	added, deleted := 0, 0
+	for _, stat := range r.Stats {
+		added += stat.Added
+		deleted += stat.Deleted
+	}
+	fmt.Fprintf(&b, "Latest checkpoint %s: %d files changed, %d insertions(+), %d deletions(-)\n", r.Checkpoint, len(r.Stats), added, deleted)
+	for _, stat := range r.Stats {
+		if stat.Binary {
+			fmt.Fprintf(&b, "  %s (binary)\n", stat.Path)
+		} else {
+			fmt.Fprintf(&b, "  %s (+%d/-%d)\n", stat.Path, stat.Added, stat.Deleted)
+		}
+	}
+	return b.String()
+}
+
+// Run prints the report for a task, then its container's recent output,
+// following the output while the container runs.
+func Run(taskID, containerName, branchName, baseBranch string, tail int) error {
+	r, err := Load(taskID, containerName, branchName, baseBranch)
+	if err != nil {
+		return err
+	}
+	fmt.Print(r.Text(time.Now()))
+	fmt.Printf("\n--- Output of %s ---\n", containerName)
+	return docker.ShowLogs(containerName, tail, r.Running)
+}
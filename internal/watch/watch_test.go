@@ -0,0 +1,130 @@
+package watch
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"giverny/internal/git"
+	"giverny/internal/status"
+	"giverny/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	// Check if GIV_TEST_ENV_DIR is set and change to that directory
+	if testEnvDir := os.Getenv("GIV_TEST_ENV_DIR"); testEnvDir != "" {
+		if err := os.Chdir(testEnvDir); err != nil {
+			panic("failed to change to test environment directory: " + err.Error())
+		}
+	}
+
+	m.Run()
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "giverny-watch-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testutil.InitTestRepo(t, tmpDir)
+
+	// No checkpoints yet
+	checkpoint, stats, err := loadCheckpoint(tmpDir, "giverny/task-1", "main")
+	if err != nil {
+		t.Fatalf("loadCheckpoint failed: %v", err)
+	}
+	if checkpoint != "" || stats != nil {
+		t.Errorf("expected no checkpoint, got %q %v", checkpoint, stats)
+	}
+
+	cmd := testutil.Command(tmpDir, "sh", "-c", `git checkout -q -b giverny/task-1 &&
+		printf 'a\nb\n' > feature.txt && git add feature.txt && git commit -q -m 'Add feature' &&
+		git checkout -q main && echo 'main' > main.txt && git add main.txt && git commit -q -m 'Unrelated main commit'`)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to set up branch: %v\n%s", err, output)
+	}
+	if err := testutil.Command(tmpDir, "git", "update-ref", git.CheckpointsRef("giverny/task-1"), "giverny/task-1").Run(); err != nil {
+		t.Fatalf("failed to create checkpoint ref: %v", err)
+	}
+
+	checkpoint, stats, err = loadCheckpoint(tmpDir, "giverny/task-1", "main")
+	if err != nil {
+		t.Fatalf("loadCheckpoint failed: %v", err)
+	}
+	if checkpoint == "" {
+		t.Fatal("expected a checkpoint")
+	}
+	if len(stats) != 1 || stats[0].Path != "feature.txt" || stats[0].Added != 2 {
+		t.Errorf("expected only feature.txt (+2) in the checkpoint, got %+v", stats)
+	}
+}
+
+func TestText(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 10, 0, 0, time.UTC)
+	r := &Report{
+		TaskID:     "task-1",
+		Container:  "giverny-task-1",
+		Running:    true,
+		Status:     &status.Status{Phase: status.PhaseAgent, Since: now.Add(-5 * time.Minute), Heartbeat: now.Add(-3 * time.Second)},
+		Checkpoint: "abc1234",
+		Stats: []git.FileStat{
+			{Path: "feature.txt", Added: 2},
+			{Path: "image.png", Binary: true},
+		},
+	}
+	text := r.Text(now)
+	for _, want := range []string{
+		"Task task-1 (container giverny-task-1, running)",
+		"Phase: agent for 5m0s, last heartbeat 3s ago\n",
+		"Latest checkpoint abc1234: 2 files changed, 2 insertions(+), 0 deletions(-)",
+		"  feature.txt (+2/-0)",
+		"  image.png (binary)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in report:\n%s", want, text)
+		}
+	}
+
+	// A stale heartbeat is called out, and a missing checkpoint explained
+	r.Status.Heartbeat = now.Add(-time.Hour)
+	r.Checkpoint, r.Stats = "", nil
+	text = r.Text(now)
+	for _, want := range []string{"innie may have stopped responding", "No checkpoints on the host yet"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in report:\n%s", want, text)
+		}
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 10, 0, 0, time.UTC)
+	r := &Report{
+		TaskID:     "task-1",
+		Container:  "giverny-task-1",
+		Running:    true,
+		Status:     &status.Status{Phase: status.PhaseAgent, Since: now, Heartbeat: now},
+		Checkpoint: "abc1234",
+		Stats:      []git.FileStat{{Path: "feature.txt", Added: 2}},
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// Scripts depend on these names
+	expected := `{"task_id":"task-1","container":"giverny-task-1","running":true,` +
+		`"status":{"phase":"agent","since":"2026-01-02T03:10:00Z","heartbeat":"2026-01-02T03:10:00Z"},` +
+		`"checkpoint":"abc1234","files":[{"path":"feature.txt","added":2,"deleted":0,"binary":false,"size":0}]}`
+	if string(data) != expected {
+		t.Errorf("unexpected JSON:\n%s\nwant:\n%s", data, expected)
+	}
+}
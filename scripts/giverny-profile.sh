@@ -0,0 +1,42 @@
+# Shows where an interactive shell in a giverny container is: a banner with
+# the task, its branch and whether it has uncommitted changes, and how to get
+# back to the menu, and a prompt with the same. It is sourced by shells
+# started from the menu and, through the system rc files, by shells started
+# with `docker exec`.
+
+case $- in
+    *i*) ;;
+    *) return 0 ;;
+esac
+
+giverny_task=${GIVERNY_TASK_ID:-$(git branch --show-current 2>/dev/null | sed -n 's|^giverny/||p')}
+
+# giverny_git prints the branch, marked with * if there are uncommitted changes
+giverny_git() {
+    giverny_branch=$(git branch --show-current 2>/dev/null) || return 0
+    if [ -n "$(git status --porcelain 2>/dev/null | head -n 1)" ]; then
+        printf '%s*' "$giverny_branch"
+    else
+        printf '%s' "$giverny_branch"
+    fi
+}
+
+if [ -z "$giverny_banner_shown" ]; then
+    giverny_banner_shown=1
+    echo "giverny task ${giverny_task:-unknown}, branch $(giverny_git) (* means uncommitted changes)"
+    if [ -n "$GIVERNY_MENU_SHELL" ]; then
+        echo "Type 'exit' to return to the menu."
+    else
+        echo "Run 'giverny menu' for the task's menu here, or 'giverny attach ${giverny_task:-TASK-ID}' on the host for its session."
+    fi
+fi
+
+if [ -n "$ZSH_VERSION" ]; then
+    setopt PROMPT_SUBST
+    PROMPT='%F{cyan}[giverny ${giverny_task}]%f %F{yellow}$(giverny_git)%f %~ %# '
+elif [ -n "$BASH_VERSION" ]; then
+    PS1='\[\e[36m\][giverny ${giverny_task}]\[\e[0m\] \[\e[33m\]$(giverny_git)\[\e[0m\] \w \$ '
+else
+    # Not every sh expands a prompt, so it is fixed when it's set
+    PS1="[giverny ${giverny_task}] \$ "
+fi
@@ -0,0 +1,26 @@
+#!/bin/sh
+# Runs innie (the command given as arguments) in a dtach session, so that it
+# keeps running if the terminal attached to the container goes away, and can
+# be attached to again with `giverny attach`. Without a terminal, or without
+# dtach, the command is run directly.
+
+SOCKET=/tmp/giverny-session
+STATUS=/tmp/giverny-session.status
+
+if [ ! -t 0 ] || ! command -v dtach >/dev/null 2>&1; then
+    exec "$@"
+fi
+
+# Start the command in a new session, recording its exit status when it ends
+rm -f "$STATUS"
+dtach -n "$SOCKET" /bin/sh -c '"$@"; echo $? > '"$STATUS" giverny-session "$@"
+
+# Attach this terminal. This returns when the command exits, or when the
+# session is detached from with Ctrl-\
+dtach -a "$SOCKET" -r winch
+
+# If the session was detached from, wait for the command to finish
+while [ ! -f "$STATUS" ]; do
+    sleep 1
+done
+exit "$(cat "$STATUS")"
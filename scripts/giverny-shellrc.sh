@@ -0,0 +1,22 @@
+# giverny's shell profile for shells started from the menu: the usual rc
+# file, then the task's banner and prompt and a few aliases.
+# It is used by bash (--rcfile), zsh (ZDOTDIR) and sh (ENV).
+
+if [ -n "$ZSH_VERSION" ]; then
+    [ -f "$HOME/.zshrc" ] && . "$HOME/.zshrc"
+    setopt INC_APPEND_HISTORY HIST_IGNORE_DUPS
+elif [ -n "$BASH_VERSION" ]; then
+    [ -f "$HOME/.bashrc" ] && . "$HOME/.bashrc"
+    # Write history as commands are run, so it survives the container stopping
+    shopt -s histappend
+    HISTCONTROL=ignoredups
+    PROMPT_COMMAND="history -a${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+fi
+
+[ -f /etc/profile.d/giverny.sh ] && . /etc/profile.d/giverny.sh
+
+alias ll='ls -l'
+alias la='ls -la'
+alias gs='git status'
+alias gd='git diff'
+alias gl='git log --oneline --graph'